@@ -19,12 +19,17 @@ import (
 	"emu/plugins/cdp"
 	dhcp "emu/plugins/dhcpv4"
 	"emu/plugins/dhcpv6"
+	"emu/plugins/dns"
 	"emu/plugins/dot1x"
+	"emu/plugins/fhrp"
+	"emu/plugins/http"
 	"emu/plugins/icmp"
 	"emu/plugins/igmp"
 	"emu/plugins/ipfix"
 	"emu/plugins/ipv6"
+	"emu/plugins/lacp"
 	"emu/plugins/lldp"
+	"emu/plugins/stp"
 	"emu/plugins/tdl"
 	"emu/plugins/transport"
 	"emu/plugins/transport_example"
@@ -46,22 +51,35 @@ func RegisterPlugins(tctx *core.CThreadCtx) {
 	tdl.Register(tctx)
 	lldp.Register(tctx)
 	cdp.Register(tctx)
+	lacp.Register(tctx)
+	fhrp.Register(tctx)
+	stp.Register(tctx)
+	dns.Register(tctx)
+	http.Register(tctx)
 	transport.Register(tctx)
 	transport_example.Register(tctx)
 }
 
 type MainArgs struct {
-	port        *int    // RPC port. Port to which the client connects to
-	vethPort    *int    // Veth Port for EMU. Port to which TRex Server connects to.
-	dummyVeth   *bool   // Run Emu on dummy veth mode.
-	zmqServer   *string // IPv4 for the zmqServer. Defaults to local.
-	capture     *bool   // capture traffic, rpc, counters and dump them in a json file
-	captureJson *string // filename for the capture
-	monitor     *bool   // monitor traffic in K12 mode and dump in pcapFile
-	monitorFile *string // filename for the monitored traffic to be dumped
-	verbose     *bool   // verbose mode, will print details
-	version     *bool   // print version of EMU and exit
-	emuTCPoZMQ  *bool   // use TCP over ZMQ instead of the classic IPC to connect with TRex.
+	port        *int      // RPC port. Port to which the client connects to
+	vethPort    *int      // Veth Port for EMU. Port to which TRex Server connects to.
+	dummyVeth   *bool     // Run Emu on dummy veth mode.
+	zmqServer   *string   // IPv4 for the zmqServer. Defaults to local.
+	capture     *bool     // capture traffic, rpc, counters and dump them in a json file
+	captureJson *string   // filename for the capture
+	monitor     *bool     // monitor traffic in K12 mode and dump in pcapFile
+	monitorFile *string   // filename for the monitored traffic to be dumped
+	verbose     *bool     // verbose mode, will print details
+	version     *bool     // print version of EMU and exit
+	emuTCPoZMQ  *bool     // use TCP over ZMQ instead of the classic IPC to connect with TRex.
+	iface       *[]string // bind to Linux interfaces using AF_PACKET instead of ZMQ, vport is the index in the list
+	noRing      *bool     // don't use the TPACKET_V3 mmap ring in AF_PACKET mode
+	threads     *int      // number of worker threads, namespaces are sharded between them
+	metricsPort *int      // HTTP port of the metrics server, 0 means disabled
+	replay      *string   // replay rx packets from a pcap/pcapng file instead of TRex server
+	replaySpeed *float64  // speed factor of the replay, 0 is as fast as possible
+	replayDelay *int      // seconds to wait before the replay starts
+	record      *string   // record the tx packets to a pcapng file
 }
 
 func printVersion() {
@@ -102,6 +120,14 @@ func parseMainArgs() *MainArgs {
 	args.verbose = parser.Flag("v", "verbose", &argparse.Options{Default: false, Help: "Run server in verbose mode"})
 	args.version = parser.Flag("V", "version", &argparse.Options{Default: false, Help: "Show TRex-Emu version"})
 	args.emuTCPoZMQ = parser.Flag("", "emu-zmq-tcp", &argparse.Options{Default: false, Help: "Run TCP over ZMQ. Default is IPC"})
+	args.iface = parser.List("i", "iface", &argparse.Options{Help: "Run on Linux interface using AF_PACKET instead of TRex server. Can be repeated, vport is the order of the interface"})
+	args.noRing = parser.Flag("", "no-ring", &argparse.Options{Default: false, Help: "Don't use TPACKET_V3 ring in AF_PACKET mode, use recvfrom"})
+	args.replay = parser.String("", "replay", &argparse.Options{Default: "", Help: "Replay rx packets from a pcap/pcapng file instead of TRex server. The vport is the pcapng interface id"})
+	args.replaySpeed = parser.Float("", "replay-speed", &argparse.Options{Default: 1.0, Help: "Speed factor of the replay, 0 means as fast as possible"})
+	args.replayDelay = parser.Int("", "replay-delay", &argparse.Options{Default: 0, Help: "Seconds to wait before the replay starts, used to create the namespaces"})
+	args.record = parser.String("", "record", &argparse.Options{Default: "", Help: "Record the tx packets to a pcapng file, each packet has a comment with its vport and tunnel"})
+	args.threads = parser.Int("t", "threads", &argparse.Options{Default: 1, Help: "Number of worker threads, namespaces are distributed between the workers by their tunnel"})
+	args.metricsPort = parser.Int("", "metrics-port", &argparse.Options{Default: 0, Help: "HTTP port of the Prometheus /metrics endpoint, 0 means disabled"})
 
 	err := parser.Parse(os.Args)
 	if err != nil {
@@ -114,6 +140,8 @@ func parseMainArgs() *MainArgs {
 func RunCoreZmq(args *MainArgs) {
 
 	var zmqVeth core.VethIFZmq
+	var rawVeth core.VethIFRaw
+	var pcapVeth core.VethIFPcap
 
 	if *args.version {
 		printVersion()
@@ -121,7 +149,13 @@ func RunCoreZmq(args *MainArgs) {
 	}
 
 	port := uint16(*args.port)
-	if *args.emuTCPoZMQ {
+	rawMode := len(*args.iface) > 0
+	pcapMode := *args.replay != "" || *args.record != ""
+	if pcapMode {
+		fmt.Printf("Run PCAP server on [RPC:%d, replay: %s, record: %s]\n", port, *args.replay, *args.record)
+	} else if rawMode {
+		fmt.Printf("Run AF_PACKET server on [RPC:%d, interfaces: %v]\n", port, *args.iface)
+	} else if *args.emuTCPoZMQ {
 		fmt.Printf("Run ZMQ server on [RPC:%d, RX: TCP:%d, TX: TCP:%d]\n", port, *args.vethPort, *args.vethPort+1)
 	} else {
 		fmt.Printf("Run ZMQ server on [RPC:%d, RX: IPC, TX:IPC]\n", port)
@@ -137,12 +171,35 @@ func RunCoreZmq(args *MainArgs) {
 
 	tctx := core.NewThreadCtx(0, port, *args.dummyVeth, &simrx)
 
-	if !*args.dummyVeth {
+	if pcapMode && !*args.dummyVeth {
+		delay := time.Duration(*args.replayDelay) * time.Second
+		err := pcapVeth.Create(tctx, *args.replay, *args.replaySpeed, delay, *args.record)
+		if err != nil {
+			log.Fatal(err)
+		}
+		pcapVeth.StartRxThread()
+		tctx.SetZmqVeth(&pcapVeth)
+	} else if rawMode && !*args.dummyVeth {
+		err := rawVeth.Create(tctx, *args.iface, !*args.noRing)
+		if err != nil {
+			log.Fatal(err)
+		}
+		rawVeth.StartRxThread()
+		tctx.SetZmqVeth(&rawVeth)
+	} else if !*args.dummyVeth {
 		zmqVeth.Create(tctx, uint16(*args.vethPort), *args.zmqServer, *args.emuTCPoZMQ, false)
 		zmqVeth.StartRxThread()
 		tctx.SetZmqVeth(&zmqVeth)
 	}
 
+	var pool *core.CThreadPool
+	if *args.threads > 1 {
+		pool = core.NewThreadPool(tctx, *args.threads)
+		for _, wctx := range pool.GetWorkers() {
+			RegisterPlugins(wctx)
+		}
+		fmt.Printf("Run with %d worker threads\n", *args.threads)
+	}
 	RegisterPlugins(tctx)
 
 	tctx.SetRpcParams(*args.verbose, *args.capture)
@@ -161,7 +218,20 @@ func RunCoreZmq(args *MainArgs) {
 	tctx.StartRxThread()
 	defer tctx.Delete()
 
-	tctx.MainLoop()
+	if *args.metricsPort != 0 {
+		err = tctx.StartMetricsServer(fmt.Sprintf(":%d", *args.metricsPort))
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Run metrics server on [HTTP:%d%s]\n", *args.metricsPort, core.METRICS_PATH)
+	}
+
+	if pool != nil {
+		pool.Start()
+		pool.MainLoop()
+	} else {
+		tctx.MainLoop()
+	}
 
 	if *args.capture {
 		tctx.SimRecordExport(*args.captureJson)
@@ -6,7 +6,6 @@ package core
 
 import (
 	"bytes"
-	"encoding/binary"
 	"external/google/gopacket/layers"
 	"fmt"
 	"net"
@@ -42,6 +41,7 @@ func NewClientStatsCounterDb(o *CClientStats) *CCounterDb {
 		Help:     "active clients",
 		Unit:     "",
 		DumpZero: false,
+		Gauge:    true,
 		Info:     ScINFO})
 	return db
 }
@@ -65,6 +65,14 @@ type CClientIpv6Nd struct {
 	IPv6       Ipv6Key `json:"ipv6"`
 }
 
+// CClientPd prefix that was delegated to the client (DHCPv6 IA_PD)
+type CClientPd struct {
+	Prefix    Ipv6Key `json:"prefix"`
+	PrefixLen uint8   `json:"prefix_len"`
+	Preferred uint32  `json:"preferred"` // lifetime in sec
+	Valid     uint32  `json:"valid"`
+}
+
 // CClient represent one client
 type CClient struct {
 	dlist  DList   // for adding into list
@@ -82,6 +90,7 @@ type CClient struct {
 	Ipv6       Ipv6Key    // set the self ipv6 by user
 	DgIpv6     Ipv6Key    // default gateway if provided would be in highest priority
 	Dhcpv6     Ipv6Key    // the dhcpv6 ipv6, another ipv6 would be the one that was learned from the router
+	Ipv6Pd     *CClientPd // the prefix delegated by dhcpv6, nil in case there isn't one
 
 	Ipv6ForceDGW   bool /* true in case we want to enforce default gateway MAC */
 	Ipv6ForcedgMac MACKey
@@ -127,11 +136,12 @@ type CClientInfo struct {
 	DgIpv4 Ipv4Key `json:"ipv4_dg"`
 	MTU    uint16  `json:"ipv4_mtu"`
 
-	Ipv6Local Ipv6Key `json:"ipv6_local"`
-	Ipv6Slaac Ipv6Key `json:"ipv6_slaac"`
-	Ipv6      Ipv6Key `json:"ipv6"`
-	DgIpv6    Ipv6Key `json:"dg_ipv6"`
-	DhcpIpv6  Ipv6Key `json:"dhcp_ipv6"`
+	Ipv6Local Ipv6Key    `json:"ipv6_local"`
+	Ipv6Slaac Ipv6Key    `json:"ipv6_slaac"`
+	Ipv6      Ipv6Key    `json:"ipv6"`
+	DgIpv6    Ipv6Key    `json:"dg_ipv6"`
+	DhcpIpv6  Ipv6Key    `json:"dhcp_ipv6"`
+	Ipv6Pd    *CClientPd `json:"ipv6_pd"`
 
 	Ipv6ForceDGW   bool   `json:"ipv6_force_dg"`
 	Ipv6ForcedgMac MACKey `json:"ipv6_force_mac"`
@@ -351,8 +361,6 @@ func (o *CClient) UpdateDIPv6(NewIpv6 Ipv6Key) error {
 
 // GetL2Header get L2 header
 func (o *CClient) GetL2Header(broadcast bool, next uint16) []byte {
-	var tund CTunnelData
-	o.Ns.Key.Get(&tund)
 	b := []byte{}
 	if broadcast {
 		b = append(b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff)
@@ -360,15 +368,7 @@ func (o *CClient) GetL2Header(broadcast bool, next uint16) []byte {
 		b = append(b, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0)
 	}
 	b = append(b, o.Mac[:]...)
-	for _, val := range tund.Vlans {
-		if val != 0 {
-			b = append(b, 0, 0, 0, 0)
-			binary.BigEndian.PutUint32(b[len(b)-4:], val)
-		}
-	}
-	b = append(b, 0, 0)
-	binary.BigEndian.PutUint16(b[len(b)-2:], uint16(next))
-	return b
+	return o.Ns.appendL2(b, next)
 }
 
 func (o *CClient) GetIPv4Header(broadcast bool, next uint8) ([]byte, uint16) {
@@ -411,6 +411,7 @@ func (o *CClient) GetInfo() *CClientInfo {
 	info.Ipv6 = o.Ipv6
 	info.DgIpv6 = o.DgIpv6
 	info.DhcpIpv6 = o.Dhcpv6
+	info.Ipv6Pd = o.Ipv6Pd
 
 	info.Ipv6ForceDGW = o.Ipv6ForceDGW
 	info.Ipv6ForcedgMac = o.Ipv6ForcedgMac
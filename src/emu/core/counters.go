@@ -31,6 +31,7 @@ type CCounterRec struct {
 	Unit     string      `json:"unit"`
 	DumpZero bool        `json:"zero"`
 	Info     uint8       `json:"info"` // see scINFO,scWARNING,scERROR
+	Gauge    bool        `json:"-"`    // the value goes up and down (e.g. active sessions), exported as a gauge metric
 }
 
 func (o *CCounterRec) IsValid() bool {
@@ -284,6 +285,44 @@ func (o *CCounterDbVec) MarshalMeta() map[string]interface{} {
 	return (m)
 }
 
+// AddValuesTo accumulates the counters values into m (db name -> counter name -> value).
+// The values are copied and not referenced, so m can be used outside the thread that owns the counters.
+func (o *CCounterDbVec) AddValuesTo(m map[string]map[string]interface{}, zero bool) {
+	for _, db := range o.Vec {
+		db.Preupdate()
+		dm, ok := m[db.Name]
+		if !ok {
+			dm = make(map[string]interface{})
+		}
+		for _, rec := range db.Vec {
+			if zero || rec.IsValid() {
+				dm[rec.Name] = addCounterValue(dm[rec.Name], rec.Counter)
+			}
+		}
+		if len(dm) > 0 {
+			m[db.Name] = dm
+		}
+	}
+}
+
+func addCounterValue(sum interface{}, cnt interface{}) interface{} {
+	switch v := cnt.(type) {
+	case *uint32:
+		s, _ := sum.(uint32)
+		return s + *v
+	case *uint64:
+		s, _ := sum.(uint64)
+		return s + *v
+	case *float32:
+		s, _ := sum.(float32)
+		return s + *v
+	case *float64:
+		s, _ := sum.(float64)
+		return s + *v
+	}
+	return sum
+}
+
 //GeneralCounters function for all types of counters i.e: ctx, arp, igmp..
 func (o *CCounterDbVec) GeneralCounters(ns error,
 	tctx *CThreadCtx,
@@ -0,0 +1,298 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package core
+
+/* IPv4/IPv6 fragmentation on transmit
+
+SendFragmented sends a packet using the veth, in case the IP packet is bigger than the MTU it is
+split to fragments. IPv4 packets with DF are dropped. For IPv6 the unfragmentable part
+(IPv6 header, hop-by-hop, routing and destination options before routing) is copied to each fragment followed by a Fragment header.
+IPv4 options are copied to all the fragments and a new identification is set to the fragments of a packet,
+as the templates of the plugins usually use a constant one (except when the packet is a fragment by itself).
+
+The callers are the UDP sockets of the transport (dns server, radius, dhcp relay ..), icmp/ipv6 echo and ping,
+mdns and the dhcp server. The other plugins send their packets as they were built, bigger than the MTU too.
+
+*/
+
+import (
+	"encoding/binary"
+	"external/google/gopacket/layers"
+)
+
+type IpFragStats struct {
+	fragPkts     uint64 // packets that were fragmented
+	fragFrags    uint64 // fragments that were sent
+	errFragDF    uint64
+	errFragMtu   uint64
+	errFragNotIP uint64
+}
+
+func newIpFragStatsDb(o *IpFragStats) *CCounterDb {
+	db := NewCCounterDb("ipfrag")
+
+	db.Add(&CCounterRec{
+		Counter:  &o.fragPkts,
+		Name:     "fragPkts",
+		Help:     "packets that were fragmented",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     ScINFO})
+
+	db.Add(&CCounterRec{
+		Counter:  &o.fragFrags,
+		Name:     "fragFrags",
+		Help:     "fragments that were sent",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     ScINFO})
+
+	db.Add(&CCounterRec{
+		Counter:  &o.errFragDF,
+		Name:     "errFragDF",
+		Help:     "ipv4 packet is bigger than the mtu and has DF, dropped",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     ScERROR})
+
+	db.Add(&CCounterRec{
+		Counter:  &o.errFragMtu,
+		Name:     "errFragMtu",
+		Help:     "mtu is too small for the ip headers, dropped",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     ScERROR})
+
+	db.Add(&CCounterRec{
+		Counter:  &o.errFragNotIP,
+		Name:     "errFragNotIP",
+		Help:     "packet is not a valid ip packet, dropped",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     ScERROR})
+
+	return db
+}
+
+// IpFrag fragments IP packets on transmit, there is one per thread
+type IpFrag struct {
+	tctx  *CThreadCtx
+	id    uint32 // fragment identification
+	stats IpFragStats
+	Cdb   *CCounterDb
+}
+
+func (o *IpFrag) init(tctx *CThreadCtx) {
+	o.tctx = tctx
+	o.id = tctx.Id << 24 // different per worker thread
+	o.Cdb = newIpFragStatsDb(&o.stats)
+}
+
+/*
+SendFragmented sends the mbuf using the veth. l3 is the offset of the IPv4/IPv6 header and
+mtu is the L3 MTU. In case the IP packet is bigger than the mtu it is fragmented.
+The mbuf is owned by this function. Returns the number of packets that were sent, 0 in case of a drop.
+*/
+func (o *CThreadCtx) SendFragmented(m *Mbuf, l3 uint16, mtu uint16) int {
+	f := &o.ipFrag
+	if !m.IsContiguous() {
+		m1 := m.GetContiguous(&o.MPool)
+		m.FreeMbuf()
+		m = m1
+	}
+	p := m.GetData()
+	if len(p) < int(l3)+1 {
+		f.stats.errFragNotIP++
+		m.FreeMbuf()
+		return 0
+	}
+	n := 0
+	switch p[l3] >> 4 {
+	case 4:
+		n = f.fragIPv4(m, l3, mtu)
+	case 6:
+		n = f.fragIPv6(m, l3, mtu)
+	default:
+		f.stats.errFragNotIP++
+	}
+	if n < 0 {
+		// no need to fragment
+		o.Veth.Send(m)
+		return 1
+	}
+	m.FreeMbuf()
+	return n
+}
+
+// SendFragmented sends the mbuf using the MTU of the client, IPv4 or IPv6 by the version of the header at l3
+func (o *CClient) SendFragmented(m *Mbuf, l3 uint16) int {
+	mtu := o.MTU
+	p := m.GetData()
+	if len(p) > int(l3) && (p[l3]>>4) == 6 {
+		mtu = o.GetIPv6MTU()
+	}
+	return o.Ns.ThreadCtx.SendFragmented(m, l3, mtu)
+}
+
+// SendBufferFragmented sends the packet b of the client by SendFragmented, l3 is the offset of the IP header
+func (o *CClient) SendBufferFragmented(b []byte, l3 uint16) int {
+	m := o.Ns.ThreadCtx.MPool.Alloc(uint16(len(b)))
+	m.SetVPort(o.Ns.GetVport())
+	m.Append(b)
+	return o.SendFragmented(m, l3)
+}
+
+// fragIPv4/fragIPv6 send the fragments and return their number, 0 in case of a drop and -1 in case
+// the packet is not bigger than the mtu. the mbuf is not freed.
+func (o *IpFrag) fragIPv4(m *Mbuf, l3 uint16, mtu uint16) int {
+	p := m.GetData()
+	if len(p) < int(l3)+20 {
+		o.stats.errFragNotIP++
+		return 0
+	}
+	ipv4 := layers.IPv4Header(p[l3:])
+	hdrLen := ipv4.GetHeaderLen()
+	totalLen := ipv4.GetLength()
+	if hdrLen < 20 || totalLen < hdrLen || len(p) < int(l3)+int(totalLen) {
+		o.stats.errFragNotIP++
+		return 0
+	}
+	if totalLen <= mtu {
+		return -1
+	}
+	frag := binary.BigEndian.Uint16(p[l3+6 : l3+8])
+	if (frag & 0x4000) != 0 {
+		o.stats.errFragDF++
+		return 0
+	}
+	if mtu < hdrLen+8 {
+		o.stats.errFragMtu++
+		return 0
+	}
+	maxData := (mtu - hdrLen) &^ 7
+	hdr := p[:l3+hdrLen]
+	data := p[l3+hdrLen : l3+totalLen]
+	baseOff := (frag & 0x1fff) << 3 // the packet could be a fragment by itself
+	lastMore := frag & 0x2000
+	id := binary.BigEndian.Uint16(p[l3+4 : l3+6])
+	if (frag & 0x3fff) == 0 {
+		id = uint16(o.id)
+		o.id++
+	}
+
+	o.stats.fragPkts++
+	var n int
+	for off := 0; off < len(data); off += int(maxData) {
+		end := off + int(maxData)
+		more := uint16(0x2000)
+		if end >= len(data) {
+			end = len(data)
+			more = lastMore
+		}
+		c := o.tctx.MPool.Alloc(uint16(len(hdr) + end - off))
+		c.SetVPort(m.VPort())
+		c.Append(hdr)
+		c.Append(data[off:end])
+		cp := c.GetData()
+		cipv4 := layers.IPv4Header(cp[l3 : l3+hdrLen])
+		cipv4.SetLength(hdrLen + uint16(end-off))
+		binary.BigEndian.PutUint16(cp[l3+4:l3+6], id)
+		binary.BigEndian.PutUint16(cp[l3+6:l3+8], ((baseOff+uint16(off))>>3)|more)
+		cipv4.UpdateChecksum()
+		o.tctx.Veth.Send(c)
+		o.stats.fragFrags++
+		n++
+	}
+	return n
+}
+
+// ipv6UnfragLen returns the offset of the fragmentable part and the offset of the next header that points to it.
+// hop-by-hop, routing and destination options before a routing header are unfragmentable.
+func ipv6UnfragLen(p []byte, l3 uint16) (uint16, uint16, bool) {
+	of := l3 + IPV6_HEADER_SIZE
+	unfrag, unfragNh := of, l3+6
+	nh := p[l3+6]
+	for {
+		switch nh {
+		case IPV6_EXT_HOP_BY_HOP, IPV6_EXT_ROUTING, IPV6_EXT_DST:
+			if len(p) < int(of)+8 {
+				return 0, 0, false
+			}
+			hl := layers.IPv6ExtHeader(p[of : of+2]).HeaderLen()
+			if len(p) < int(of+hl) {
+				return 0, 0, false
+			}
+			cur, curOff := nh, of
+			nh = p[of]
+			of += hl
+			if cur != IPV6_EXT_DST {
+				unfrag, unfragNh = of, curOff
+			}
+		default:
+			return unfrag, unfragNh, true
+		}
+	}
+}
+
+func (o *IpFrag) fragIPv6(m *Mbuf, l3 uint16, mtu uint16) int {
+	p := m.GetData()
+	if len(p) < int(l3)+IPV6_HEADER_SIZE {
+		o.stats.errFragNotIP++
+		return 0
+	}
+	ipv6 := layers.IPv6Header(p[l3 : l3+IPV6_HEADER_SIZE])
+	totalLen := uint32(IPV6_HEADER_SIZE) + uint32(ipv6.PayloadLength())
+	if len(p) < int(l3)+int(totalLen) {
+		o.stats.errFragNotIP++
+		return 0
+	}
+	if totalLen <= uint32(mtu) {
+		return -1
+	}
+	unfrag, nhOff, ok := ipv6UnfragLen(p[:uint32(l3)+totalLen], l3)
+	if !ok {
+		o.stats.errFragNotIP++
+		return 0
+	}
+	hdrLen := unfrag - l3 + 8 // with fragment header
+	if mtu < hdrLen+8 {
+		o.stats.errFragMtu++
+		return 0
+	}
+	maxData := (mtu - hdrLen) &^ 7
+	hdr := p[:unfrag]
+	nh := p[nhOff]
+	data := p[unfrag : uint32(l3)+totalLen]
+	id := o.id
+	o.id++
+
+	o.stats.fragPkts++
+	var n int
+	for off := 0; off < len(data); off += int(maxData) {
+		end := off + int(maxData)
+		more := uint16(1)
+		if end >= len(data) {
+			end = len(data)
+			more = 0
+		}
+		c := o.tctx.MPool.Alloc(uint16(len(hdr) + 8 + end - off))
+		c.SetVPort(m.VPort())
+		c.Append(hdr)
+		var fh [8]byte
+		fh[0] = nh
+		binary.BigEndian.PutUint16(fh[2:4], uint16(off)|more)
+		binary.BigEndian.PutUint32(fh[4:8], id)
+		c.Append(fh[:])
+		c.Append(data[off:end])
+		cp := c.GetData()
+		cp[nhOff] = IPV6_EXT_Fragment
+		layers.IPv6Header(cp[l3 : l3+IPV6_HEADER_SIZE]).SetPyloadLength(uint16(len(cp)) - l3 - IPV6_HEADER_SIZE)
+		o.tctx.Veth.Send(c)
+		o.stats.fragFrags++
+		n++
+	}
+	return n
+}
@@ -0,0 +1,102 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package core
+
+import (
+	"bytes"
+	"testing"
+)
+
+// fragSend sends the packet with mtu and returns the transmitted packets
+func fragSend(tctx *CThreadCtx, pkt []byte, mtu uint16) [][]byte {
+	m := tctx.MPool.Alloc(uint16(len(pkt)))
+	m.SetVPort(1)
+	m.Append(pkt)
+	tctx.SendFragmented(m, 14, mtu)
+	veth := tctx.Veth.(*VethIFSimulator)
+	var res [][]byte
+	for _, m := range veth.vec {
+		res = append(res, append([]byte(nil), m.GetData()...))
+		m.FreeMbuf()
+	}
+	veth.vec = veth.vec[:0]
+	return res
+}
+
+func TestIpFrag(t *testing.T) {
+	for _, ipv6 := range []bool{false, true} {
+		var simrx VethIFSim = &VethSink{}
+		tctx := NewThreadCtx(0, 4510, true, &simrx)
+		var parser Parser
+		parser.Init(tctx)
+		parser.addProto("udp", &parserProtocol{cb: reassUdpSupported, data: ParserRegisterData{UdpDefault: true}})
+		reassL7 = nil
+
+		pkt, payload := reassBuildUdp(ipv6, 3000)
+
+		// no need to fragment
+		tx := fragSend(tctx, pkt, 9000)
+		if len(tx) != 1 || !bytes.Equal(tx[0], pkt) {
+			t.Fatalf(" packet should be sent as is ipv6:%v ", ipv6)
+		}
+
+		tx = fragSend(tctx, pkt, 1280)
+		if len(tx) != 3 {
+			t.Fatalf(" expected 3 fragments, got %d ipv6:%v ", len(tx), ipv6)
+		}
+		for _, f := range tx {
+			if len(f)-14 > 1280 {
+				t.Fatalf(" fragment is bigger than the mtu %d ", len(f)-14)
+			}
+		}
+		// the fragments are reassembled by the parser
+		for i := len(tx) - 1; i >= 0; i-- {
+			if r := reassParse(tctx, &parser, tx[i]); r != 0 {
+				t.Fatalf(" parser error %d ipv6:%v ", r, ipv6)
+			}
+		}
+		if !bytes.Equal(reassL7, payload) || parser.stats.reassPkts != 1 {
+			t.Fatalf(" reassembled payload is not as expected ipv6:%v ", ipv6)
+		}
+		if tctx.ipFrag.stats.fragPkts != 1 || tctx.ipFrag.stats.fragFrags != 3 {
+			t.Fatalf(" unexpected counters %+v ", tctx.ipFrag.stats)
+		}
+		tctx.Veth.SimulatorCleanup()
+	}
+}
+
+func TestIpFragDF(t *testing.T) {
+	var simrx VethIFSim = &VethSink{}
+	tctx := NewThreadCtx(0, 4510, true, &simrx)
+	pkt, _ := reassBuildUdp(false, 3000)
+	pkt[14+6] |= 0x40 // DF
+	if tx := fragSend(tctx, pkt, 1500); len(tx) != 0 || tctx.ipFrag.stats.errFragDF != 1 {
+		t.Fatalf(" packet with DF should be dropped ")
+	}
+	pkt, _ = reassBuildUdp(true, 3000)
+	if tx := fragSend(tctx, pkt, 40); len(tx) != 0 || tctx.ipFrag.stats.errFragMtu != 1 {
+		t.Fatalf(" mtu is too small, packet should be dropped ")
+	}
+	tctx.Veth.SimulatorCleanup()
+}
+
+func TestIpFragClientBuffer(t *testing.T) {
+	var simrx VethIFSim = &VethSink{}
+	tctx := NewThreadCtx(0, 4510, true, &simrx)
+	var key CTunnelKey
+	key.SetJson(&CTunnelDataJson{Vport: 1})
+	ns := NewNSCtx(tctx, &key)
+	tctx.AddNs(&key, ns)
+	c := NewClient(ns, MACKey{0, 0, 1, 0, 0, 1}, Ipv4Key{16, 0, 0, 1}, Ipv6Key{}, Ipv4Key{})
+	ns.AddClient(c)
+	c.MTU = 1500
+
+	pkt, _ := reassBuildUdp(false, 3000)
+	if n := c.SendBufferFragmented(pkt, 14); n != 3 || tctx.ipFrag.stats.fragFrags != 3 {
+		t.Fatalf(" expected 3 fragments, got %d ", n)
+	}
+	tctx.Veth.SimulatorCleanup()
+}
@@ -0,0 +1,312 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package core
+
+/* IPv4/IPv6 reassembly
+
+The parser keeps a reassembly table per namespace (tunnel key). Each datagram (src, dst, id, proto) has a context
+with the payload of its fragments as mbufs, sorted by offset. Once all the fragments arrived, the header of the
+first fragment and the payload mbufs are chained, converted to one contiguous mbuf and parsed again,
+so the L4 callbacks get a regular packet.
+
+Overlapping fragments (RFC 5722) or datagrams bigger than MAX_PACKET_SIZE drop the whole context.
+A context that is not completed in PARSER_REASS_TIMEOUT is removed by a timer.
+The number of contexts per namespace and the total bytes buffered are limited.
+
+*/
+
+import (
+	"encoding/binary"
+	"external/google/gopacket/layers"
+	"time"
+)
+
+const (
+	PARSER_REASS_TIMEOUT         = 30 * time.Second
+	PARSER_REASS_MAX_CTX_PER_NS  = 64
+	PARSER_REASS_MAX_FRAGS       = 64
+	PARSER_REASS_MAX_TOTAL_BYTES = 4 * 1024 * 1024
+)
+
+type ipReassKey struct {
+	src   [16]byte
+	dst   [16]byte
+	id    uint32
+	proto uint8
+	ipv6  bool
+}
+
+type ipReassFrag struct {
+	off uint16 // offset of the payload
+	m   *Mbuf  // payload only
+}
+
+type ipReassCtx struct {
+	key   ipReassKey
+	tun   CTunnelKey
+	tbl   *ipReassTable
+	frags []ipReassFrag // sorted by offset
+	hdr   []byte        // L2/L3 headers of the first fragment, without the IPv6 fragment header
+	nhOff uint16        // IPv6, offset in hdr of the next header to update
+	nh    uint8         // IPv6, next header of the fragmentable part
+	total uint16        // payload size, valid when the last fragment arrived
+	last  bool
+	bytes uint32
+	timer CHTimerObj
+}
+
+// ipReassTable reassembly table of one namespace
+type ipReassTable struct {
+	tun CTunnelKey
+	ctx map[ipReassKey]*ipReassCtx
+}
+
+// ipReass reassembly tables of all the namespaces
+type ipReass struct {
+	parser *Parser
+	tbl    map[CTunnelKey]*ipReassTable
+	bytes  uint32
+}
+
+func (o *ipReass) init(parser *Parser) {
+	o.parser = parser
+	o.tbl = make(map[CTunnelKey]*ipReassTable)
+}
+
+func (o *ipReass) getCtx(tun *CTunnelKey, key *ipReassKey) *ipReassCtx {
+	stats := &o.parser.stats
+	tbl, ok := o.tbl[*tun]
+	if !ok {
+		tbl = &ipReassTable{tun: *tun, ctx: make(map[ipReassKey]*ipReassCtx)}
+		o.tbl[*tun] = tbl
+	}
+	ctx, ok := tbl.ctx[*key]
+	if ok {
+		return ctx
+	}
+	if len(tbl.ctx) >= PARSER_REASS_MAX_CTX_PER_NS {
+		stats.errReassNoMem++
+		return nil
+	}
+	ctx = &ipReassCtx{key: *key, tun: *tun, tbl: tbl}
+	ctx.timer.SetCB(o, ctx, 0)
+	o.parser.tctx.GetTimerCtx().Start(&ctx.timer, PARSER_REASS_TIMEOUT)
+	tbl.ctx[*key] = ctx
+	stats.reassCtxAdd++
+	stats.reassCtxActive++
+	return ctx
+}
+
+func (o *ipReass) removeCtx(ctx *ipReassCtx) {
+	timerctx := o.parser.tctx.GetTimerCtx()
+	if ctx.timer.IsRunning() {
+		timerctx.Stop(&ctx.timer)
+	}
+	for _, f := range ctx.frags {
+		f.m.FreeMbuf()
+	}
+	ctx.frags = nil
+	o.bytes -= ctx.bytes
+	ctx.bytes = 0
+	delete(ctx.tbl.ctx, ctx.key)
+	if len(ctx.tbl.ctx) == 0 {
+		delete(o.tbl, ctx.tbl.tun)
+	}
+	o.parser.stats.reassCtxActive--
+}
+
+/* OnEvent reassembly timeout */
+func (o *ipReass) OnEvent(a, b interface{}) {
+	ctx := a.(*ipReassCtx)
+	o.parser.stats.reassTimeout++
+	o.removeCtx(ctx)
+}
+
+// addFrag adds the payload of a fragment to the context, returns false in case the context should be dropped
+func (o *ipReass) addFrag(ctx *ipReassCtx, hdrLen int, off uint16, more bool, payload []byte) bool {
+	stats := &o.parser.stats
+	plen := uint16(len(payload))
+	end := uint32(off) + uint32(plen)
+
+	if uint32(hdrLen)+end > uint32(MAX_PACKET_SIZE) {
+		stats.errReassTooBig++
+		return false
+	}
+	if !more {
+		if ctx.last && ctx.total != uint16(end) {
+			stats.errReassOverlap++
+			return false
+		}
+		ctx.last = true
+		ctx.total = uint16(end)
+	}
+	if ctx.last && end > uint32(ctx.total) {
+		stats.errReassOverlap++
+		return false
+	}
+
+	i := 0
+	for ; i < len(ctx.frags); i++ {
+		f := &ctx.frags[i]
+		fend := uint32(f.off) + uint32(f.m.DataLen())
+		if f.off == off && fend == end {
+			stats.reassDup++ // duplicate, ignore it
+			return true
+		}
+		if uint32(off) < fend && end > uint32(f.off) {
+			stats.errReassOverlap++
+			return false
+		}
+		if off < f.off {
+			break
+		}
+	}
+	if len(ctx.frags) >= PARSER_REASS_MAX_FRAGS {
+		stats.errReassNoMem++
+		return false
+	}
+	if o.bytes+uint32(plen) > PARSER_REASS_MAX_TOTAL_BYTES {
+		stats.errReassNoMem++
+		return false
+	}
+
+	m := o.parser.tctx.MPool.Alloc(plen)
+	m.Append(payload)
+	ctx.frags = append(ctx.frags, ipReassFrag{})
+	copy(ctx.frags[i+1:], ctx.frags[i:])
+	ctx.frags[i] = ipReassFrag{off: off, m: m}
+	ctx.bytes += uint32(plen)
+	o.bytes += uint32(plen)
+	return true
+}
+
+func (o *ipReass) isComplete(ctx *ipReassCtx) bool {
+	if !ctx.last || ctx.hdr == nil {
+		return false
+	}
+	var size uint32
+	for _, f := range ctx.frags {
+		size += uint32(f.m.DataLen())
+	}
+	return size == uint32(ctx.total)
+}
+
+// build chains the header and the payloads into a new contiguous mbuf and fixes the L3 header
+func (o *ipReass) build(ctx *ipReassCtx, ps *ParserPacketState) *Mbuf {
+	tctx := o.parser.tctx
+	h := tctx.MPool.Alloc(uint16(len(ctx.hdr)))
+	h.SetVPort(ps.M.VPort())
+	h.Append(ctx.hdr)
+	for _, f := range ctx.frags {
+		h.AppendMbuf(f.m)
+	}
+	ctx.frags = nil
+	m := h.GetContiguous(&tctx.MPool)
+	m.SetVPort(h.VPort())
+	h.FreeMbuf()
+
+	p := m.GetData()
+	l3 := p[ps.L3:]
+	if ctx.key.ipv6 {
+		ipv6 := layers.IPv6Header(l3)
+		ipv6.SetPyloadLength(uint16(len(l3) - IPV6_HEADER_SIZE))
+		p[ctx.nhOff] = ctx.nh
+	} else {
+		ipv4 := layers.IPv4Header(l3[0:ipv4HeaderLen(l3)])
+		ipv4.SetLength(uint16(len(l3)))
+		frag := binary.BigEndian.Uint16(l3[6:8])
+		binary.BigEndian.PutUint16(l3[6:8], frag&0x4000) // keep DF
+		ipv4.UpdateChecksum()
+	}
+	return m
+}
+
+func ipv4HeaderLen(l3 []byte) int {
+	return int(l3[0]&0xf) << 2
+}
+
+/*
+onFragment handles a fragment, hdr is the L2/L3 headers of the packet up to the fragmentable part.
+in case of IPv6 nhOff is the offset in hdr of the next header that points to the fragment header
+and nh is the next header of the fragment header.
+returns the parser result of the reassembled packet or PARSER_OK in case it is not completed
+*/
+func (o *ipReass) onFragment(ps *ParserPacketState, key *ipReassKey, hdr []byte,
+	nhOff uint16, nh uint8, off uint16, more bool, payload []byte) int {
+	stats := &o.parser.stats
+
+	stats.reassFrags++
+	ctx := o.getCtx(ps.Tun, key)
+	if ctx == nil {
+		return PARSER_ERR
+	}
+	if off == 0 && ctx.hdr == nil {
+		ctx.hdr = append([]byte(nil), hdr...)
+		ctx.nhOff = nhOff
+		ctx.nh = nh
+	}
+	if !o.addFrag(ctx, len(hdr), off, more, payload) {
+		o.removeCtx(ctx)
+		return PARSER_ERR
+	}
+	if !o.isComplete(ctx) {
+		return PARSER_OK
+	}
+	m := o.build(ctx, ps)
+	o.removeCtx(ctx)
+	stats.reassPkts++
+	r := o.parser.ParsePacket(m)
+	m.FreeMbuf()
+	return r
+}
+
+// onIPv4 handles an IPv4 fragment, the header was validated except for the total length
+func (o *ipReass) onIPv4(ps *ParserPacketState, ipv4 layers.IPv4Header) int {
+	var key ipReassKey
+	p := ps.M.GetData()
+	hdrLen := ipv4.GetHeaderLen()
+	frag := binary.BigEndian.Uint16(ipv4[6:8])
+	off := (frag & 0x1fff) << 3
+	more := (frag & 0x2000) != 0
+	if ipv4.GetLength() < hdrLen {
+		// total length is smaller than the header
+		o.parser.stats.errIPv4Fragment++
+		return PARSER_ERR
+	}
+	payload := p[ps.L3+hdrLen : ps.L3+ipv4.GetLength()]
+	if (more && (len(payload)&7) != 0) || uint32(off)+uint32(len(payload)) > 0xffff {
+		o.parser.stats.errIPv4Fragment++
+		return PARSER_ERR
+	}
+	copy(key.src[:], ipv4[12:16])
+	copy(key.dst[:], ipv4[16:20])
+	key.id = uint32(binary.BigEndian.Uint16(ipv4[4:6]))
+	key.proto = ipv4.GetNextProtocol()
+	return o.onFragment(ps, &key, p[:ps.L3+hdrLen], 0, 0, off, more, payload)
+}
+
+// onIPv6 handles an IPv6 fragment header at offset fh, nhOff is the offset of the next header that points to it
+func (o *ipReass) onIPv6(ps *ParserPacketState, ipv6 layers.IPv6Header, fh uint16, l4len uint16, nhOff uint16) int {
+	var key ipReassKey
+	p := ps.M.GetData()
+	if l4len < 8 {
+		o.parser.stats.errIPv6Fragment++
+		return PARSER_ERR
+	}
+	frag := binary.BigEndian.Uint16(p[fh+2 : fh+4])
+	off := frag &^ 7
+	more := (frag & 1) != 0
+	payload := p[fh+8 : fh+l4len]
+	if (more && (len(payload)&7) != 0) || uint32(off)+uint32(len(payload)) > 0xffff {
+		o.parser.stats.errIPv6Fragment++
+		return PARSER_ERR
+	}
+	copy(key.src[:], ipv6.SrcIP())
+	copy(key.dst[:], ipv6.DstIP())
+	key.id = binary.BigEndian.Uint32(p[fh+4 : fh+8])
+	key.ipv6 = true
+	return o.onFragment(ps, &key, p[:fh], nhOff, p[fh], off, more, payload)
+}
@@ -0,0 +1,194 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"external/google/gopacket"
+	"external/google/gopacket/layers"
+	"net"
+	"testing"
+)
+
+var reassL7 []byte
+
+func reassUdpSupported(ps *ParserPacketState) int {
+	p := ps.M.GetData()
+	l7 := ps.L4 + 8
+	reassL7 = append([]byte(nil), p[l7:l7+ps.L7Len]...)
+	return 0
+}
+
+func reassBuildUdp(ipv6 bool, size int) ([]byte, []byte) {
+	return reassBuildUdpPort(ipv6, size, 2000)
+}
+
+func reassBuildUdpPort(ipv6 bool, size int, dport uint16) ([]byte, []byte) {
+	payload := make([]byte, size)
+	for i := range payload {
+		payload[i] = byte(i)
+	}
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	eth := &layers.Ethernet{
+		SrcMAC:       net.HardwareAddr{0, 1, 1, 1, 1, 1},
+		DstMAC:       net.HardwareAddr{0, 2, 2, 2, 2, 2},
+		EthernetType: layers.EthernetTypeIPv4,
+	}
+	udp := &layers.UDP{SrcPort: 1000, DstPort: layers.UDPPort(dport)}
+	if ipv6 {
+		eth.EthernetType = layers.EthernetTypeIPv6
+		ip := &layers.IPv6{Version: 6, HopLimit: 64, NextHeader: layers.IPProtocolUDP,
+			SrcIP: net.ParseIP("2001::1"), DstIP: net.ParseIP("2001::2")}
+		udp.SetNetworkLayerForChecksum(ip)
+		gopacket.SerializeLayers(buf, opts, eth, ip, udp, gopacket.Payload(payload))
+	} else {
+		ip := &layers.IPv4{Version: 4, IHL: 5, TTL: 64, Id: 0x1234, Protocol: layers.IPProtocolUDP,
+			SrcIP: net.IPv4(16, 0, 0, 1), DstIP: net.IPv4(16, 0, 0, 2)}
+		udp.SetNetworkLayerForChecksum(ip)
+		gopacket.SerializeLayers(buf, opts, eth, ip, udp, gopacket.Payload(payload))
+	}
+	return buf.Bytes(), payload
+}
+
+// reassFragment splits a packet to fragments with fsize bytes of payload
+func reassFragment(pkt []byte, ipv6 bool, fsize int) [][]byte {
+	var res [][]byte
+	if ipv6 {
+		hdr := pkt[:14+40]
+		data := pkt[14+40:]
+		for off := 0; off < len(data); off += fsize {
+			end := off + fsize
+			more := uint16(1)
+			if end >= len(data) {
+				end = len(data)
+				more = 0
+			}
+			f := append([]byte(nil), hdr...)
+			layers.IPv6Header(f[14:]).SetPyloadLength(uint16(8 + end - off))
+			layers.IPv6Header(f[14:]).SetNextHeader(IPV6_EXT_Fragment)
+			var fh [8]byte
+			fh[0] = uint8(layers.IPProtocolUDP)
+			binary.BigEndian.PutUint16(fh[2:4], uint16(off)|more)
+			binary.BigEndian.PutUint32(fh[4:8], 0x5678)
+			f = append(f, fh[:]...)
+			res = append(res, append(f, data[off:end]...))
+		}
+		return res
+	}
+	hdr := pkt[:14+20]
+	data := pkt[14+20:]
+	for off := 0; off < len(data); off += fsize {
+		end := off + fsize
+		more := uint16(0x2000)
+		if end >= len(data) {
+			end = len(data)
+			more = 0
+		}
+		f := append([]byte(nil), hdr...)
+		ipv4 := layers.IPv4Header(f[14:])
+		ipv4.SetLength(uint16(20 + end - off))
+		binary.BigEndian.PutUint16(f[14+6:14+8], uint16(off>>3)|more)
+		ipv4.UpdateChecksum()
+		res = append(res, append(f, data[off:end]...))
+	}
+	return res
+}
+
+func reassParse(tctx *CThreadCtx, parser *Parser, pkt []byte) int {
+	m := tctx.MPool.Alloc(uint16(len(pkt)))
+	m.SetVPort(1)
+	m.Append(pkt)
+	r := parser.ParsePacket(m)
+	m.FreeMbuf()
+	return r
+}
+
+func TestParserReass(t *testing.T) {
+	for _, ipv6 := range []bool{false, true} {
+		tctx := NewThreadCtx(0, 4510, false, nil)
+		var parser Parser
+		parser.Init(tctx)
+		parser.addProto("udp", &parserProtocol{cb: reassUdpSupported, data: ParserRegisterData{UdpDefault: true}})
+		reassL7 = nil
+
+		pkt, payload := reassBuildUdp(ipv6, 3000)
+		frags := reassFragment(pkt, ipv6, 1200)
+		if len(frags) != 3 {
+			t.Fatalf(" expected 3 fragments ")
+		}
+		// out of order with a duplicate
+		for _, i := range []int{2, 0, 0, 1} {
+			if r := reassParse(tctx, &parser, frags[i]); r != 0 {
+				t.Fatalf(" parser error %d ipv6:%v ", r, ipv6)
+			}
+		}
+		if !bytes.Equal(reassL7, payload) {
+			t.Fatalf(" reassembled payload is not as expected ipv6:%v ", ipv6)
+		}
+		if parser.stats.reassPkts != 1 || parser.stats.reassDup != 1 || parser.stats.reassCtxActive != 0 || parser.reass.bytes != 0 {
+			t.Fatalf(" unexpected counters %+v ", parser.stats)
+		}
+	}
+}
+
+func TestParserReassDrop(t *testing.T) {
+	tctx := NewThreadCtx(0, 4510, false, nil)
+	var parser Parser
+	parser.Init(tctx)
+	parser.addProto("udp", &parserProtocol{cb: reassUdpSupported, data: ParserRegisterData{UdpDefault: true}})
+
+	pkt, _ := reassBuildUdp(false, 3000)
+	frags := reassFragment(pkt, false, 1200)
+
+	// overlap drops the context
+	reassParse(tctx, &parser, frags[0])
+	overlap := reassFragment(pkt, false, 800)
+	if r := reassParse(tctx, &parser, overlap[1]); r != PARSER_ERR {
+		t.Fatalf(" overlap should be dropped ")
+	}
+	if parser.stats.errReassOverlap != 1 || parser.stats.reassCtxActive != 0 {
+		t.Fatalf(" unexpected counters %+v ", parser.stats)
+	}
+
+	// total length is smaller than the header
+	short := append([]byte(nil), frags[0]...)
+	layers.IPv4Header(short[14:]).SetLength(8)
+	layers.IPv4Header(short[14:34]).UpdateChecksum()
+	if r := reassParse(tctx, &parser, short); r != PARSER_ERR {
+		t.Fatalf(" fragment shorter than the header should be dropped ")
+	}
+	if parser.stats.errIPv4Fragment != 1 || parser.stats.reassCtxActive != 0 {
+		t.Fatalf(" unexpected counters %+v ", parser.stats)
+	}
+
+	// bigger than max packet size
+	big, _ := reassBuildUdp(false, 12000)
+	bigFrags := reassFragment(big, false, 1200)
+	reassParse(tctx, &parser, bigFrags[len(bigFrags)-1])
+	if parser.stats.errReassTooBig != 1 || parser.stats.reassCtxActive != 0 {
+		t.Fatalf(" unexpected counters %+v ", parser.stats)
+	}
+
+	// missing fragment, removed by the timer
+	reassParse(tctx, &parser, frags[0])
+	reassParse(tctx, &parser, frags[2])
+	if parser.stats.reassCtxActive != 1 {
+		t.Fatalf(" unexpected counters %+v ", parser.stats)
+	}
+	timerctx := tctx.GetTimerCtx()
+	ticks := timerctx.DurationToTicks(PARSER_REASS_TIMEOUT) + 10
+	for i := uint32(0); i < ticks; i++ {
+		timerctx.HandleTicks()
+	}
+	if parser.stats.reassTimeout != 1 || parser.stats.reassCtxActive != 0 || parser.reass.bytes != 0 || len(parser.reass.tbl) != 0 {
+		t.Fatalf(" unexpected counters %+v ", parser.stats)
+	}
+	if parser.stats.reassPkts != 0 {
+		t.Fatalf(" packet should not be reassembled ")
+	}
+}
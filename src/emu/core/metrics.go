@@ -0,0 +1,237 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package core
+
+/* Prometheus metrics exporter
+
+The counters databases of the thread, namespaces, clients and their plugins are exported in the Prometheus
+text format by an optional HTTP server (GET /metrics).
+
+   metric name : trex_emu_<db>_<counter>, e.g. trex_emu_arp_pktRxArpQuery
+   labels      : thread, tunnel (namespace/client counters), mac (client counters), plugin (plugin counters)
+   HELP        : the Help of the counter with its Unit and Info (info/warning/error)
+   TYPE        : gauge for the records with Gauge (e.g. active sessions, leases), counter for the rest
+
+The counters are owned by the thread, so the HTTP goroutine does not read them. It sends a request to the
+MainLoop of the thread (or the thread pool) that collects the values and sends back the text.
+Plugins expose their counters by implementing IPluginCounters.
+*/
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	METRICS_PREFIX  = "trex_emu"
+	METRICS_PATH    = "/metrics"
+	METRICS_TIMEOUT = 5 * time.Second
+)
+
+// IPluginCounters is implemented by plugins that export their counters as metrics
+type IPluginCounters interface {
+	GetCounterDbVec() *CCounterDbVec
+}
+
+type metricSample struct {
+	labels string
+	val    string
+}
+
+type metricFamily struct {
+	help    string
+	typ     string
+	samples []metricSample
+}
+
+// CMetrics collects counters databases as Prometheus metrics. The values are copied, so it can be
+// formatted outside the thread that owns the counters.
+type CMetrics struct {
+	fam map[string]*metricFamily
+}
+
+func NewMetrics() *CMetrics {
+	return &CMetrics{fam: make(map[string]*metricFamily)}
+}
+
+// metricName returns a valid metric name, invalid characters are replaced with '_'
+func metricName(db, name string) string {
+	s := []byte(METRICS_PREFIX + "_" + db + "_" + name)
+	for i, c := range s {
+		if !((c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c == '_' || c == ':') {
+			s[i] = '_'
+		}
+	}
+	return string(s)
+}
+
+func metricInfo(info uint8) string {
+	switch info {
+	case ScWARNING:
+		return "warning"
+	case ScERROR:
+		return "error"
+	}
+	return "info"
+}
+
+var metricLabelReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+var metricHelpReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+// metricLabels formats the label pairs (name, value, name, value...)
+func metricLabels(kv ...string) string {
+	var b strings.Builder
+	for i := 0; i+1 < len(kv); i += 2 {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, `%s="%s"`, kv[i], metricLabelReplacer.Replace(kv[i+1]))
+	}
+	return b.String()
+}
+
+// AddDb adds the counters of the database with the labels, see metricLabels
+func (o *CMetrics) AddDb(db *CCounterDb, labels string) {
+	db.Preupdate()
+	for _, rec := range db.Vec {
+		val := rec.GetValAsString()
+		if val == "N/A" {
+			continue
+		}
+		name := metricName(db.Name, rec.Name)
+		f, ok := o.fam[name]
+		if !ok {
+			help := strings.TrimSpace(rec.Help)
+			if rec.Unit != "" {
+				help += fmt.Sprintf(" [%s]", rec.Unit)
+			}
+			help += fmt.Sprintf(" (%s)", metricInfo(rec.Info))
+			f = &metricFamily{help: help, typ: "counter"}
+			if rec.Gauge {
+				f.typ = "gauge"
+			}
+			o.fam[name] = f
+		}
+		f.samples = append(f.samples, metricSample{labels: labels, val: val})
+	}
+}
+
+func (o *CMetrics) AddDbVec(cdbv *CCounterDbVec, labels string) {
+	for _, db := range cdbv.Vec {
+		o.AddDb(db, labels)
+	}
+}
+
+// addPlugins adds the counters of the plugins, kv are the labels of the plugins owner
+func (o *CMetrics) addPlugins(ctx *PluginCtx, kv ...string) {
+	names := ctx.GetAllPlugNames()
+	sort.Strings(names)
+	for _, name := range names {
+		plug := ctx.Get(name)
+		if c, ok := plug.Ext.(IPluginCounters); ok && c.GetCounterDbVec() != nil {
+			o.AddDbVec(c.GetCounterDbVec(), metricLabels(append(kv, "plugin", name)...))
+		}
+	}
+}
+
+// AddThread adds the counters of the thread, its namespaces and clients. Should be called from the thread.
+func (o *CMetrics) AddThread(tctx *CThreadCtx) {
+	var it, cit DListIterHead
+	thread := fmt.Sprintf("%d", tctx.Id)
+	o.AddDbVec(tctx.GetCounterDbVec(), metricLabels("thread", thread))
+	o.addPlugins(tctx.PluginCtx, "thread", thread)
+	for it.Init(&tctx.nsHead); it.IsCont(); it.Next() {
+		ns := castDlistNSCtx(it.Val())
+		tun := ns.Key.StringRpc()
+		o.AddDb(ns.cdb, metricLabels("thread", thread, "tunnel", tun))
+		o.addPlugins(ns.PluginCtx, "thread", thread, "tunnel", tun)
+		for cit.Init(&ns.clientHead); cit.IsCont(); cit.Next() {
+			client := castDlistClient(cit.Val())
+			mac := net.HardwareAddr(client.Mac[:]).String()
+			o.addPlugins(client.PluginCtx, "thread", thread, "tunnel", tun, "mac", mac)
+		}
+	}
+}
+
+// Bytes returns the metrics in the Prometheus text format, sorted by name
+func (o *CMetrics) Bytes() []byte {
+	var b bytes.Buffer
+	names := make([]string, 0, len(o.fam))
+	for name := range o.fam {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		f := o.fam[name]
+		fmt.Fprintf(&b, "# HELP %s %s\n", name, metricHelpReplacer.Replace(f.help))
+		fmt.Fprintf(&b, "# TYPE %s %s\n", name, f.typ)
+		for _, s := range f.samples {
+			fmt.Fprintf(&b, "%s{%s} %s\n", name, s.labels, s.val)
+		}
+	}
+	return b.Bytes()
+}
+
+// metricsHandler serves /metrics, the collection is done by the MainLoop that reads reqC
+type metricsHandler struct {
+	reqC chan chan []byte
+}
+
+func (h *metricsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	resC := make(chan []byte, 1)
+	select {
+	case h.reqC <- resC:
+	case <-time.After(METRICS_TIMEOUT):
+		http.Error(w, "timeout", http.StatusServiceUnavailable)
+		return
+	}
+	select {
+	case res := <-resC:
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		w.Write(res)
+	case <-time.After(METRICS_TIMEOUT):
+		http.Error(w, "timeout", http.StatusServiceUnavailable)
+	}
+}
+
+// StartMetricsServer starts a HTTP server on addr (e.g. ":9100") with the /metrics endpoint.
+// Should be called before the MainLoop, the server is stopped by Delete.
+func (o *CThreadCtx) StartMetricsServer(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	o.metricsC = make(chan chan []byte)
+	o.metricsLn = ln
+	mux := http.NewServeMux()
+	mux.Handle(METRICS_PATH, &metricsHandler{reqC: o.metricsC})
+	go http.Serve(ln, mux)
+	return nil
+}
+
+// stopMetricsServer closes the listener of the metrics server, http.Serve returns
+func (o *CThreadCtx) stopMetricsServer() {
+	if o.metricsLn != nil {
+		o.metricsLn.Close()
+		o.metricsLn = nil
+	}
+}
+
+// collectMetrics returns the metrics of this thread
+func (o *CThreadCtx) collectMetrics() []byte {
+	m := NewMetrics()
+	m.AddThread(o)
+	return m.Bytes()
+}
@@ -0,0 +1,101 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package core
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type metricsTestPlugin struct {
+	PluginBase
+	cnt  uint64
+	cdbv *CCounterDbVec
+}
+
+func (o *metricsTestPlugin) OnEvent(msg string, a, b interface{}) {}
+func (o *metricsTestPlugin) OnRemove(ctx *PluginCtx)              {}
+func (o *metricsTestPlugin) GetCounterDbVec() *CCounterDbVec      { return o.cdbv }
+
+type metricsTestReg struct{}
+
+func (o metricsTestReg) NewPlugin(ctx *PluginCtx, initJson []byte) *PluginBase {
+	p := new(metricsTestPlugin)
+	p.InitPluginBase(ctx, p)
+	p.RegisterEvents(ctx, []string{}, p)
+	p.cnt = 7
+	db := NewCCounterDb("test-plug")
+	db.Add(&CCounterRec{Counter: &p.cnt, Name: "txPkts", Help: "tx \"pkts\"", Unit: "pkts", Info: ScERROR})
+	p.cdbv = NewCCounterDbVec("test-plug")
+	p.cdbv.Add(db)
+	return &p.PluginBase
+}
+
+func TestMetrics(t *testing.T) {
+	PluginRegister("metrics_test", PluginRegisterData{Client: metricsTestReg{}, Ns: metricsTestReg{}})
+	tctx := NewThreadCtx(0, 4510, false, nil)
+	var key CTunnelKey
+	key.SetJson(&CTunnelDataJson{Vport: 1, Tci: [2]uint16{100, 0}})
+	ns := NewNSCtx(tctx, &key)
+	tctx.AddNs(&key, ns)
+	ns.PluginCtx.CreatePlugins([]string{"metrics_test"}, nil)
+	client := NewClient(ns, MACKey{0, 0, 1, 0, 0, 1}, Ipv4Key{16, 0, 0, 1}, Ipv6Key{}, Ipv4Key{})
+	ns.AddClient(client)
+	client.PluginCtx.CreatePlugins([]string{"metrics_test"}, nil)
+
+	m := NewMetrics()
+	m.AddThread(tctx)
+	out := string(m.Bytes())
+
+	tun := key.StringRpc()
+	exp := []string{
+		"# HELP trex_emu_test_plug_txPkts tx \"pkts\" [pkts] (error)\n# TYPE trex_emu_test_plug_txPkts counter\n",
+		"# TYPE trex_emu_ns_activeClient gauge\n",
+		`trex_emu_test_plug_txPkts{thread="0",tunnel="` + tun + `",plugin="metrics_test"} 7`,
+		`trex_emu_test_plug_txPkts{thread="0",tunnel="` + tun + `",mac="00:00:01:00:00:01",plugin="metrics_test"} 7`,
+		`trex_emu_ns_addClient{thread="0",tunnel="` + tun + `"} 1`,
+		"# HELP trex_emu_ns_addClient add client [ops] (info)\n",
+	}
+	for _, e := range exp {
+		if !strings.Contains(out, e) {
+			t.Fatalf(" metric is missing %q in \n%s", e, out)
+		}
+	}
+	if strings.Count(out, "# HELP trex_emu_test_plug_txPkts") != 1 {
+		t.Fatalf(" metric family should be written once \n%s", out)
+	}
+
+	// the collection is done by the owner of the counters
+	h := &metricsHandler{reqC: make(chan chan []byte)}
+	go func() {
+		resC := <-h.reqC
+		resC <- tctx.collectMetrics()
+	}()
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+	res, err := http.Get(srv.URL + METRICS_PATH)
+	if err != nil {
+		t.Fatalf(" http get failed %v ", err)
+	}
+	body, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK || string(body) != out {
+		t.Fatalf(" unexpected response %d \n%s", res.StatusCode, body)
+	}
+
+	// the server is stopped with the thread
+	if err := tctx.StartMetricsServer("127.0.0.1:0"); err != nil {
+		t.Fatalf(" can't start the metrics server %v ", err)
+	}
+	addr := tctx.metricsLn.Addr().String()
+	tctx.Delete()
+	if _, err := http.Get("http://" + addr + METRICS_PATH); err == nil {
+		t.Fatalf(" metrics server is still running ")
+	}
+}
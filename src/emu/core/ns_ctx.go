@@ -92,6 +92,15 @@ type MapClientIPv6 map[Ipv6Key]*CClient
 type MapClientIPv4 map[Ipv4Key]*CClient
 type MapClientMAC map[MACKey]*CClient
 
+// CVirtualAddr an address that is owned by a client using a virtual MAC, e.g. the virtual IP of a VRRP master
+type CVirtualAddr struct {
+	Client *CClient
+	Mac    MACKey
+}
+
+type MapVirtualIPv4 map[Ipv4Key]*CVirtualAddr
+type MapVirtualIPv6 map[Ipv6Key]*CVirtualAddr
+
 type CNSCtxStats struct {
 	addClient        uint64
 	removeClient     uint64
@@ -135,6 +144,7 @@ func newNsStats(o *CNSCtxStats) *CCounterDb {
 		Help:     "active client",
 		Unit:     "ops",
 		DumpZero: false,
+		Gauge:    true,
 		Info:     ScINFO})
 
 	db.Add(&CCounterRec{
@@ -184,6 +194,8 @@ type CNSCtx struct {
 	mapIpv6        MapClientIPv6
 	mapIpv4        MapClientIPv4
 	mapMAC         MapClientMAC
+	mapVirtIpv4    MapVirtualIPv4 // virtual addresses that ARP/ND answer for
+	mapVirtIpv6    MapVirtualIPv6
 	clientHead     DList // list of ns
 	stats          CNSCtxStats
 	PluginCtx      *PluginCtx
@@ -193,14 +205,17 @@ type CNSCtx struct {
 	iter           DListIterHead
 	cdb            *CCounterDb
 	DefClientPlugs *MapJsonPlugs // Default plugins for each new client
+	encap          *tunnelEncap  // VXLAN/GRE outer encapsulation, nil if there isn't one
 }
 
 type CNsInfo struct {
-	Port          uint16    `json:"vport" validate:"required"`
-	Tci           [2]uint16 `json:"tci"`
-	Tpid          [2]uint16 `json:"tpid"`
-	ActiveClients uint64    `json:"active_clients"`
-	PlugNames     []string  `json:"plug_names"`
+	Port          uint16            `json:"vport" validate:"required"`
+	Tci           [2]uint16         `json:"tci"`
+	Tpid          [2]uint16         `json:"tpid"`
+	ActiveClients uint64            `json:"active_clients"`
+	PlugNames     []string          `json:"plug_names"`
+	Mpls          []uint32          `json:"mpls,omitempty"`
+	Encap         *CTunnelEncapJson `json:"encap,omitempty"`
 }
 
 // NewNSCtx create new one
@@ -214,6 +229,8 @@ func NewNSCtx(tctx *CThreadCtx,
 	o.mapIpv6 = make(MapClientIPv6)
 	o.mapIpv4 = make(MapClientIPv4)
 	o.mapMAC = make(MapClientMAC)
+	o.mapVirtIpv4 = make(MapVirtualIPv4)
+	o.mapVirtIpv6 = make(MapVirtualIPv6)
 	o.PluginCtx = NewPluginCtx(nil, o, tctx, PLUGIN_LEVEL_NS)
 	o.DefClientPlugs = nil
 	o.clientHead.SetSelf()
@@ -309,6 +326,58 @@ func (o *CNSCtx) CLookupByIPv6(ipv6 *Ipv6Key) *CClient {
 	}
 }
 
+// AddVirtualIPv4 the client owns ipv4 with mac, an address of a client or of another client can't be added
+func (o *CNSCtx) AddVirtualIPv4(ipv4 Ipv4Key, client *CClient, mac MACKey) error {
+	if ipv4.IsZero() {
+		return fmt.Errorf(" virtual ipv4 can't be zero")
+	}
+	if o.CLookupByIPv4(&ipv4) != nil {
+		return fmt.Errorf(" virtual ipv4 %v is an address of a client", ipv4)
+	}
+	if v, ok := o.mapVirtIpv4[ipv4]; ok && v.Client != client {
+		return fmt.Errorf(" virtual ipv4 %v is owned by another client", ipv4)
+	}
+	o.mapVirtIpv4[ipv4] = &CVirtualAddr{Client: client, Mac: mac}
+	return nil
+}
+
+// RemoveVirtualIPv4 removes ipv4 in case it is owned by the client
+func (o *CNSCtx) RemoveVirtualIPv4(ipv4 Ipv4Key, client *CClient) {
+	if v, ok := o.mapVirtIpv4[ipv4]; ok && v.Client == client {
+		delete(o.mapVirtIpv4, ipv4)
+	}
+}
+
+func (o *CNSCtx) LookupVirtualIPv4(ipv4 *Ipv4Key) *CVirtualAddr {
+	return o.mapVirtIpv4[*ipv4]
+}
+
+// AddVirtualIPv6 the client owns ipv6 with mac, an address of a client or of another client can't be added
+func (o *CNSCtx) AddVirtualIPv6(ipv6 Ipv6Key, client *CClient, mac MACKey) error {
+	if ipv6.IsZero() {
+		return fmt.Errorf(" virtual ipv6 can't be zero")
+	}
+	if o.CLookupByIPv6LocalGlobal(&ipv6) != nil {
+		return fmt.Errorf(" virtual ipv6 %v is an address of a client", ipv6.ToIP())
+	}
+	if v, ok := o.mapVirtIpv6[ipv6]; ok && v.Client != client {
+		return fmt.Errorf(" virtual ipv6 %v is owned by another client", ipv6.ToIP())
+	}
+	o.mapVirtIpv6[ipv6] = &CVirtualAddr{Client: client, Mac: mac}
+	return nil
+}
+
+// RemoveVirtualIPv6 removes ipv6 in case it is owned by the client
+func (o *CNSCtx) RemoveVirtualIPv6(ipv6 Ipv6Key, client *CClient) {
+	if v, ok := o.mapVirtIpv6[ipv6]; ok && v.Client == client {
+		delete(o.mapVirtIpv6, ipv6)
+	}
+}
+
+func (o *CNSCtx) LookupVirtualIPv6(ipv6 *Ipv6Key) *CVirtualAddr {
+	return o.mapVirtIpv6[*ipv6]
+}
+
 // AddClient add a client object to the maps and dlist
 func (o *CNSCtx) AddClient(client *CClient) error {
 
@@ -573,10 +642,12 @@ func (o *CNSCtx) GetInfo() *CNsInfo {
 	o.stats.PreUpdate()
 
 	var d CTunnelDataJson
-	o.Key.GetJson(&d)
+	o.GetTunnelJson(&d)
 	info.Port = d.Vport
 	info.Tci = d.Tci
 	info.Tpid = d.Tpid
+	info.Mpls = d.Mpls
+	info.Encap = d.Encap
 	info.ActiveClients = o.stats.activeClient
 	info.PlugNames = o.PluginCtx.GetAllPlugNames()
 	return &info
@@ -612,9 +683,22 @@ func (o *CNSCtx) Dump() {
 	}
 }
 
-func (o *CNSCtx) GetL2Header(broadcast bool, next uint16) []byte {
+// appendL2 appends the vlan tags/MPLS labels of the namespace and the ether type,
+// in case it has an encapsulation the internal encap tag is used instead
+func (o *CNSCtx) appendL2(b []byte, next uint16) []byte {
+	if o.encap != nil {
+		b = append(b, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint16(b[len(b)-6:], TUNNEL_ENCAP_TPID)
+		binary.BigEndian.PutUint16(b[len(b)-4:], o.encap.id)
+		binary.BigEndian.PutUint16(b[len(b)-2:], next)
+		return b
+	}
 	var tund CTunnelData
 	o.Key.Get(&tund)
+	return tund.appendL2(b, next)
+}
+
+func (o *CNSCtx) GetL2Header(broadcast bool, next uint16) []byte {
 	b := []byte{}
 	if broadcast {
 		b = append(b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff)
@@ -622,13 +706,5 @@ func (o *CNSCtx) GetL2Header(broadcast bool, next uint16) []byte {
 		b = append(b, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0)
 	}
 	b = append(b, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0)
-	for _, val := range tund.Vlans {
-		if val != 0 {
-			b = append(b, 0, 0, 0, 0)
-			binary.BigEndian.PutUint32(b[len(b)-4:], val)
-		}
-	}
-	b = append(b, 0, 0)
-	binary.BigEndian.PutUint16(b[len(b)-2:], uint16(next))
-	return b
+	return o.appendL2(b, next)
 }
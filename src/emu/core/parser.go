@@ -38,6 +38,12 @@ const (
 	PARSER_OK  = 0
 )
 
+const (
+	ETH_802_3_MAX_LEN    = 1500 // a smaller ether type is the length of an 802.3 frame
+	LLC_SNAP_HEADER_SIZE = 8
+	LLC_HEADER_SIZE      = 3
+)
+
 // FLAGS of IPv6
 const (
 	IPV6_M_RTALERT_ML uint32 = 0x1
@@ -70,6 +76,9 @@ type ParserStats struct {
 	errTcpTooShort        uint64
 	errDot1qTooShort      uint64
 	errToManyDot1q        uint64
+	errMplsTooShort       uint64
+	errToManyMpls         uint64
+	errMplsPayload        uint64
 	errIPv4TooShort       uint64
 	errIPv4HeaderTooShort uint64
 	errIPv4Fragment       uint64
@@ -78,6 +87,10 @@ type ParserStats struct {
 	errUDP                uint64
 	eapolPkts             uint64
 	eapolBytes            uint64
+	snapPkts              uint64
+	snapBytes             uint64
+	llcPkts               uint64
+	llcBytes              uint64
 
 	arpPkts               uint64
 	arpBytes              uint64
@@ -107,6 +120,15 @@ type ParserStats struct {
 	errL4ProtoUnsupported uint64
 	errL3ProtoUnsupported uint64
 	errPacketIsTooShort   uint64
+	reassFrags            uint64
+	reassPkts             uint64
+	reassDup              uint64
+	reassTimeout          uint64
+	reassCtxAdd           uint64
+	reassCtxActive        uint64
+	errReassOverlap       uint64
+	errReassTooBig        uint64
+	errReassNoMem         uint64
 }
 
 func newParserStatsDb(o *ParserStats) *CCounterDb {
@@ -136,6 +158,38 @@ func newParserStatsDb(o *ParserStats) *CCounterDb {
 		DumpZero: false,
 		Info:     ScINFO})
 
+	db.Add(&CCounterRec{
+		Counter:  &o.snapPkts,
+		Name:     "snapPkts",
+		Help:     "802.3 llc/snap packets",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     ScINFO})
+
+	db.Add(&CCounterRec{
+		Counter:  &o.snapBytes,
+		Name:     "snapBytes",
+		Help:     "802.3 llc/snap bytes",
+		Unit:     "bytes",
+		DumpZero: false,
+		Info:     ScINFO})
+
+	db.Add(&CCounterRec{
+		Counter:  &o.llcPkts,
+		Name:     "llcPkts",
+		Help:     "802.3 llc packets by dsap",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     ScINFO})
+
+	db.Add(&CCounterRec{
+		Counter:  &o.llcBytes,
+		Name:     "llcBytes",
+		Help:     "802.3 llc bytes by dsap",
+		Unit:     "bytes",
+		DumpZero: false,
+		Info:     ScINFO})
+
 	db.Add(&CCounterRec{
 		Counter:  &o.errInternalHandler,
 		Name:     "errInternalHandler",
@@ -208,6 +262,30 @@ func newParserStatsDb(o *ParserStats) *CCounterDb {
 		DumpZero: false,
 		Info:     ScERROR})
 
+	db.Add(&CCounterRec{
+		Counter:  &o.errMplsTooShort,
+		Name:     "errMplsTooShort",
+		Help:     "mpls label stack too short",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     ScERROR})
+
+	db.Add(&CCounterRec{
+		Counter:  &o.errToManyMpls,
+		Name:     "errToManyMpls",
+		Help:     "mpls label stack too long",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     ScERROR})
+
+	db.Add(&CCounterRec{
+		Counter:  &o.errMplsPayload,
+		Name:     "errMplsPayload",
+		Help:     "mpls payload is not ipv4/ipv6",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     ScERROR})
+
 	db.Add(&CCounterRec{
 		Counter:  &o.errIPv4TooShort,
 		Name:     "errIPv4TooShort",
@@ -235,7 +313,7 @@ func newParserStatsDb(o *ParserStats) *CCounterDb {
 	db.Add(&CCounterRec{
 		Counter:  &o.errTCP,
 		Name:     "errTCP",
-		Help:     "tcp packets",
+		Help:     "tcp packets that do not match any registered port",
 		Unit:     "pkts",
 		DumpZero: false,
 		Info:     ScERROR})
@@ -243,7 +321,7 @@ func newParserStatsDb(o *ParserStats) *CCounterDb {
 	db.Add(&CCounterRec{
 		Counter:  &o.errUDP,
 		Name:     "errUDP",
-		Help:     "udp packets",
+		Help:     "udp packets that do not match any registered port",
 		Unit:     "pkts",
 		DumpZero: false,
 		Info:     ScERROR})
@@ -456,6 +534,79 @@ func newParserStatsDb(o *ParserStats) *CCounterDb {
 		DumpZero: false,
 		Info:     ScERROR})
 
+	db.Add(&CCounterRec{
+		Counter:  &o.reassFrags,
+		Name:     "reassFrags",
+		Help:     "ip fragments to reassemble",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     ScINFO})
+
+	db.Add(&CCounterRec{
+		Counter:  &o.reassPkts,
+		Name:     "reassPkts",
+		Help:     "reassembled ip packets",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     ScINFO})
+
+	db.Add(&CCounterRec{
+		Counter:  &o.reassDup,
+		Name:     "reassDup",
+		Help:     "duplicate ip fragments",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     ScINFO})
+
+	db.Add(&CCounterRec{
+		Counter:  &o.reassTimeout,
+		Name:     "reassTimeout",
+		Help:     "reassembly contexts removed by timeout",
+		Unit:     "ctx",
+		DumpZero: false,
+		Info:     ScERROR})
+
+	db.Add(&CCounterRec{
+		Counter:  &o.reassCtxAdd,
+		Name:     "reassCtxAdd",
+		Help:     "reassembly contexts added",
+		Unit:     "ctx",
+		DumpZero: false,
+		Info:     ScINFO})
+
+	db.Add(&CCounterRec{
+		Counter:  &o.reassCtxActive,
+		Name:     "reassCtxActive",
+		Help:     "active reassembly contexts",
+		Unit:     "ctx",
+		DumpZero: false,
+		Gauge:    true,
+		Info:     ScINFO})
+
+	db.Add(&CCounterRec{
+		Counter:  &o.errReassOverlap,
+		Name:     "errReassOverlap",
+		Help:     "overlapping ip fragments, the packet is dropped",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     ScERROR})
+
+	db.Add(&CCounterRec{
+		Counter:  &o.errReassTooBig,
+		Name:     "errReassTooBig",
+		Help:     "reassembled packet is bigger than the max packet size",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     ScERROR})
+
+	db.Add(&CCounterRec{
+		Counter:  &o.errReassNoMem,
+		Name:     "errReassNoMem",
+		Help:     "reassembly contexts or memory limit",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     ScERROR})
+
 	return db
 }
 
@@ -464,63 +615,160 @@ type Parser struct {
 	tctx *CThreadCtx
 
 	stats ParserStats
-	/* call backs */
-	arp    ParserCb
-	icmp   ParserCb
-	igmp   ParserCb
-	dhcp   ParserCb
-	dhcpv6 ParserCb
-	tcp    ParserCb
-	udp    ParserCb
-	icmpv6 ParserCb
-	eapol  ParserCb
-	Cdb    *CCounterDb
+	/* call backs, filled by the registered protocols */
+	protocols map[string]bool
+	etherType map[uint16]ParserCb
+	snap      map[uint64]ParserCb // by OUI and protocol id
+	llc       [256]ParserCb       // by DSAP
+	ipProto   [256]ParserCb
+	udp       map[uint16]ParserCb // by destination port
+	udpv6     map[uint16]ParserCb
+	tcp       map[uint16]ParserCb
+	tcpv6     map[uint16]ParserCb
+	udpDef    ParserCb // unmatched packets
+	tcpDef    ParserCb
+	l3Def     ParserCb
+	l4Def     ParserCb
+	Cdb       *CCounterDb
+	reass     ipReass
 }
 
 func parserNotSupported(ps *ParserPacketState) int {
 	return -1
 }
 
-func (o *Parser) Register(protocol string) {
-	if protocol == "arp" {
-		o.arp = getProto("arp")
+func parserAddPorts(m map[uint16]ParserCb, ports []uint16, cb ParserCb, protocol string) {
+	for _, port := range ports {
+		if _, ok := m[port]; ok {
+			panic(fmt.Sprintf(" parser protocol %s, port %d is already registered ", protocol, port))
+		}
+		m[port] = cb
 	}
-	if protocol == "icmp" {
-		o.icmp = getProto("icmp")
+}
+
+func parserSetDefault(def *ParserCb, on bool, cb ParserCb, protocol string) {
+	if !on {
+		return
 	}
-	if protocol == "igmp" {
-		o.igmp = getProto("igmp")
+	if *def != nil {
+		panic(fmt.Sprintf(" parser protocol %s, default callback is already registered ", protocol))
 	}
-	if protocol == "dhcp" {
-		o.dhcp = getProto("dhcp")
+	*def = cb
+}
+
+// Register adds the keys of a protocol that was registered using ParserRegister to the parser of this thread
+func (o *Parser) Register(protocol string) {
+	o.addProto(protocol, getProto(protocol))
+}
+
+func (o *Parser) addProto(protocol string, p *parserProtocol) {
+	if o.protocols[protocol] {
+		return
 	}
-	if protocol == "icmpv6" {
-		o.icmpv6 = getProto("icmpv6")
+	o.protocols[protocol] = true
+	d := &p.data
+	for _, t := range d.EtherTypes {
+		if _, ok := o.etherType[t]; ok {
+			panic(fmt.Sprintf(" parser protocol %s, ether type 0x%04x is already registered ", protocol, t))
+		}
+		o.etherType[t] = p.cb
 	}
-	if protocol == "dhcpv6" {
-		o.dhcpv6 = getProto("dhcpv6")
+	for _, t := range d.SnapTypes {
+		if _, ok := o.snap[t]; ok {
+			panic(fmt.Sprintf(" parser protocol %s, snap type 0x%010x is already registered ", protocol, t))
+		}
+		o.snap[t] = p.cb
 	}
-	if protocol == "dot1x" {
-		o.eapol = getProto("dot1x")
+	for _, t := range d.LlcSaps {
+		if o.llc[t] != nil {
+			panic(fmt.Sprintf(" parser protocol %s, llc sap 0x%02x is already registered ", protocol, t))
+		}
+		o.llc[t] = p.cb
 	}
-
-	if protocol == "transport" {
-		o.tcp = getProto("transport")
-		o.udp = getProto("transport")
+	for _, t := range d.IpProtos {
+		if o.ipProto[t] != nil {
+			panic(fmt.Sprintf(" parser protocol %s, ip protocol %d is already registered ", protocol, t))
+		}
+		o.ipProto[t] = p.cb
 	}
+	parserAddPorts(o.udp, d.UdpPorts, p.cb, protocol)
+	parserAddPorts(o.udpv6, d.UdpV6Ports, p.cb, protocol)
+	parserAddPorts(o.tcp, d.TcpPorts, p.cb, protocol)
+	parserAddPorts(o.tcpv6, d.TcpV6Ports, p.cb, protocol)
+	parserSetDefault(&o.udpDef, d.UdpDefault, p.cb, protocol)
+	parserSetDefault(&o.tcpDef, d.TcpDefault, p.cb, protocol)
+	parserSetDefault(&o.l3Def, d.L3Default, p.cb, protocol)
+	parserSetDefault(&o.l4Def, d.L4Default, p.cb, protocol)
 }
 
 func (o *Parser) Init(tctx *CThreadCtx) {
 	o.tctx = tctx
-	o.arp = parserNotSupported
-	o.icmp = parserNotSupported
-	o.igmp = parserNotSupported
-	o.dhcp = parserNotSupported
-	o.tcp = parserNotSupported
-	o.udp = parserNotSupported
-	o.icmpv6 = parserNotSupported
-	o.dhcpv6 = parserNotSupported
+	o.protocols = make(map[string]bool)
+	o.etherType = make(map[uint16]ParserCb)
+	o.snap = make(map[uint64]ParserCb)
+	o.udp = make(map[uint16]ParserCb)
+	o.udpv6 = make(map[uint16]ParserCb)
+	o.tcp = make(map[uint16]ParserCb)
+	o.tcpv6 = make(map[uint16]ParserCb)
 	o.Cdb = newParserStatsDb(&o.stats)
+	o.reass.init(o)
+}
+
+// onEtherType calls the callback of the ether type, ps.L3 is the offset after the vlan tags
+func (o *Parser) onEtherType(ps *ParserPacketState, etherType layers.EthernetType) int {
+	if cb, ok := o.etherType[uint16(etherType)]; ok {
+		return cb(ps)
+	}
+	if o.l3Def != nil {
+		return o.l3Def(ps)
+	}
+	o.stats.errL3ProtoUnsupported++
+	return PARSER_ERR
+}
+
+// onLlc calls the callback of an 802.3 frame with a LLC/SNAP header or by the DSAP of a LLC header,
+// ps.L3 is the offset of the LLC header
+func (o *Parser) onLlc(ps *ParserPacketState, length layers.EthernetType) int {
+	p := ps.M.GetData()
+	l3 := ps.L3
+	if ps.M.PktLen() >= uint32(l3+LLC_SNAP_HEADER_SIZE) && p[l3] == 0xaa && p[l3+1] == 0xaa && p[l3+2] == 0x03 {
+		key := uint64(binary.BigEndian.Uint32(p[l3+2:l3+6])&0xffffff)<<16 | uint64(binary.BigEndian.Uint16(p[l3+6:l3+8]))
+		if cb, ok := o.snap[key]; ok {
+			o.stats.snapPkts++
+			o.stats.snapBytes += uint64(ps.M.PktLen())
+			return cb(ps)
+		}
+	} else if ps.M.PktLen() >= uint32(l3+LLC_HEADER_SIZE) {
+		if cb := o.llc[p[l3]]; cb != nil {
+			o.stats.llcPkts++
+			o.stats.llcBytes += uint64(ps.M.PktLen())
+			return cb(ps)
+		}
+	}
+	return o.onEtherType(ps, length)
+}
+
+func (o *Parser) onIpProto(ps *ParserPacketState, proto uint8) int {
+	if cb := o.ipProto[proto]; cb != nil {
+		return cb(ps)
+	}
+	if o.l4Def != nil {
+		return o.l4Def(ps)
+	}
+	o.stats.errL4ProtoUnsupported++
+	return PARSER_ERR
+}
+
+// onPort calls the callback of the destination port, in case there is no match the default one
+func (o *Parser) onPort(ps *ParserPacketState, tbl map[uint16]ParserCb, def ParserCb, port uint16, errCnt *uint64) int {
+	if cb, ok := tbl[port]; ok {
+		return cb(ps)
+	}
+	if def != nil {
+		return def(ps)
+	}
+	*errCnt++
+	return PARSER_ERR
 }
 
 func (o *Parser) parsePacketL4(ps *ParserPacketState,
@@ -545,7 +793,7 @@ func (o *Parser) parsePacketL4(ps *ParserPacketState,
 		o.stats.icmpPkts++
 		o.stats.icmpBytes += uint64(packetSize)
 		ps.L7 = ps.L4 + 8
-		return o.icmp(ps)
+		return o.onIpProto(ps, nextHdr)
 	case layers.IPProtocolIGMP:
 		if packetSize < uint32(ps.L4+8) {
 			o.stats.errIcmpv4TooShort++
@@ -553,7 +801,7 @@ func (o *Parser) parsePacketL4(ps *ParserPacketState,
 		}
 		o.stats.igmpPkts++
 		o.stats.igmpBytes += uint64(packetSize)
-		return o.igmp(ps)
+		return o.onIpProto(ps, nextHdr)
 	case layers.IPProtocolTCP:
 		if l4len < uint16(20) {
 			o.stats.errTcpTooShort++
@@ -575,7 +823,11 @@ func (o *Parser) parsePacketL4(ps *ParserPacketState,
 
 		o.stats.tcpPkts++
 		o.stats.tcpBytes += uint64(packetSize)
-		return o.tcp(ps)
+		tcp := layers.TcpHeader(p[ps.L4 : ps.L4+20])
+		if layer3 == uint16(layers.EthernetTypeIPv6) {
+			return o.onPort(ps, o.tcpv6, o.tcpDef, tcp.GetDstPort(), &o.stats.errTCP)
+		}
+		return o.onPort(ps, o.tcp, o.tcpDef, tcp.GetDstPort(), &o.stats.errTCP)
 	case layers.IPProtocolUDP:
 		if packetSize < uint32(ps.L4+8) {
 			o.stats.errUdpTooShort++
@@ -591,27 +843,25 @@ func (o *Parser) parsePacketL4(ps *ParserPacketState,
 		}
 		o.stats.udpPkts++
 		o.stats.udpBytes += uint64(packetSize)
+		if udp.DstPort() == VXLAN_UDP_PORT {
+			if r, ok := o.decap(ps, TUNNEL_ENCAP_VXLAN, ps.L4+8); ok {
+				return r
+			}
+		}
 
+		ps.L7 = ps.L4 + 8
 		if layer3 == uint16(layers.EthernetTypeIPv6) {
 			if (udp.SrcPort() == 547) && (udp.DstPort() == 546) {
 				o.stats.dhcpPkts++
 				o.stats.dhcpBytes += uint64(packetSize)
-				ps.L7 = ps.L4 + 8
-				return o.dhcpv6(ps)
-			}
-		} else {
-			if (udp.SrcPort() == 67) && (udp.DstPort() == 68) {
-				o.stats.dhcpPkts++
-				o.stats.dhcpBytes += uint64(packetSize)
-				ps.L7 = ps.L4 + 8
-				return o.dhcp(ps)
-			} else {
-				return o.udp(ps)
 			}
+			return o.onPort(ps, o.udpv6, o.udpDef, udp.DstPort(), &o.stats.errUDP)
 		}
-
-		o.stats.errUDP++
-		return PARSER_ERR
+		if (udp.SrcPort() == 67) && (udp.DstPort() == 68) {
+			o.stats.dhcpPkts++
+			o.stats.dhcpBytes += uint64(packetSize)
+		}
+		return o.onPort(ps, o.udp, o.udpDef, udp.DstPort(), &o.stats.errUDP)
 	case layers.IPProtocolICMPv6:
 		if packetSize < uint32(ps.L4+4) {
 			o.stats.errIcmpv6TooShort++
@@ -641,15 +891,19 @@ func (o *Parser) parsePacketL4(ps *ParserPacketState,
 			layers.ICMPv6TypeNeighborAdvertisement:
 			o.stats.Icmpv6Pkt++
 			o.stats.Icmpv6Bytes += uint64(packetSize)
-			return (o.icmpv6(ps))
+			return o.onIpProto(ps, nextHdr)
 		default:
 			o.stats.errIcmpv6Unsupported++
 			return PARSER_ERR
 		}
 		return -1
+	case layers.IPProtocolGRE:
+		if r, ok := o.decap(ps, TUNNEL_ENCAP_GRE, ps.L4); ok {
+			return r
+		}
+		return o.onIpProto(ps, nextHdr)
 	default:
-		o.stats.errL4ProtoUnsupported++
-		return PARSER_ERR
+		return o.onIpProto(ps, nextHdr)
 	}
 	return (0)
 }
@@ -676,6 +930,119 @@ func processIpv6Options(p []byte, flags *uint32) int {
 	return (0)
 }
 
+const (
+	MPLS_OK = iota
+	MPLS_ERR_TOO_SHORT
+	MPLS_ERR_TOO_MANY
+	MPLS_ERR_PAYLOAD
+)
+
+// getMplsLabels reads the MPLS label stack at offset into d, returns the ether type of the payload by its IP version (or ARP) and its offset
+func getMplsLabels(p []byte, offset uint16, d *CTunnelData) (layers.EthernetType, uint16, int) {
+	d.MplsCnt = 0
+	for {
+		if len(p) < int(offset)+4 {
+			return 0, 0, MPLS_ERR_TOO_SHORT
+		}
+		if d.MplsCnt == MAX_MPLS_LABELS {
+			return 0, 0, MPLS_ERR_TOO_MANY
+		}
+		val := binary.BigEndian.Uint32(p[offset : offset+4])
+		d.Mpls[d.MplsCnt] = val >> 12
+		d.MplsCnt++
+		offset += 4
+		if (val & MPLS_BOTTOM_FLAG) != 0 {
+			break
+		}
+	}
+	if len(p) <= int(offset) {
+		return 0, 0, MPLS_ERR_TOO_SHORT
+	}
+	switch p[offset] >> 4 {
+	case 4:
+		return layers.EthernetTypeIPv4, offset, MPLS_OK
+	case 6:
+		return layers.EthernetTypeIPv6, offset, MPLS_OK
+	case 0:
+		// ARP of Ethernet/IPv4
+		if len(p) >= int(offset)+4 && binary.BigEndian.Uint16(p[offset:offset+2]) == 1 &&
+			binary.BigEndian.Uint16(p[offset+2:offset+4]) == uint16(layers.EthernetTypeIPv4) {
+			return layers.EthernetTypeARP, offset, MPLS_OK
+		}
+	}
+	return 0, 0, MPLS_ERR_PAYLOAD
+}
+
+func (o *Parser) parseMpls(p []byte, offset uint16, d *CTunnelData) (layers.EthernetType, uint16, bool) {
+	nextHdr, offset, err := getMplsLabels(p, offset, d)
+	switch err {
+	case MPLS_ERR_TOO_SHORT:
+		o.stats.errMplsTooShort++
+	case MPLS_ERR_TOO_MANY:
+		o.stats.errToManyMpls++
+	case MPLS_ERR_PAYLOAD:
+		o.stats.errMplsPayload++
+	}
+	return nextHdr, offset, err == MPLS_OK
+}
+
+// GetPacketTunnelKey builds the tunnel key of a packet (vport, up to two vlan tags, MPLS labels and VXLAN/GRE/PBB encapsulation) without parsing the rest of it.
+// returns false in case the packet is too short or has too many tags
+func GetPacketTunnelKey(p []byte, vport uint16, key *CTunnelKey) bool {
+	return getPacketTunnelKey(p, vport, key, nil)
+}
+
+// getPacketTunnelKey is GetPacketTunnelKey, a VXLAN/GRE key is used only in case isLocal (when given) accepts
+// the outer IP header at l3, as decap does. Otherwise the key is the one of the outer headers.
+func getPacketTunnelKey(p []byte, vport uint16, key *CTunnelKey, isLocal func(key *CTunnelKey, p []byte, l3 uint16) bool) bool {
+	var d CTunnelData
+	d.Vport = vport
+	if len(p) < 14 {
+		return false
+	}
+	offset := 14
+	nextHdr := layers.EthernetType(binary.BigEndian.Uint16(p[12:14]))
+	for i := 0; nextHdr == layers.EthernetTypeDot1Q || nextHdr == layers.EthernetTypeQinQ; i++ {
+		if i > 1 || len(p) < offset+4 {
+			return false
+		}
+		d.Vlans[i] = binary.BigEndian.Uint32(p[offset-2:offset+2]) & 0xffff0fff
+		nextHdr = layers.EthernetType(binary.BigEndian.Uint16(p[offset+2 : offset+4]))
+		offset += 4
+	}
+	if nextHdr == PBB_ITAG_TYPE {
+		if isid, _, ok := parseEncapHeader(p, TUNNEL_ENCAP_PBB, uint16(offset)); ok {
+			d.Encap = TUNNEL_ENCAP_PBB
+			d.Vni = isid
+		}
+	}
+	if nextHdr == layers.EthernetTypeMPLSUnicast {
+		var err int
+		var off uint16
+		nextHdr, off, err = getMplsLabels(p, uint16(offset), &d)
+		if err != MPLS_OK {
+			return false
+		}
+		offset = int(off)
+	}
+	if nextHdr == layers.EthernetTypeIPv4 || nextHdr == layers.EthernetTypeIPv6 {
+		typ, vni, _, ok := getEncapKey(p, uint16(offset), nextHdr == layers.EthernetTypeIPv6)
+		if ok {
+			outer := d
+			d.Encap = typ
+			d.Vni = vni
+			if isLocal != nil {
+				key.Set(&d)
+				if !isLocal(key, p, uint16(offset)) {
+					d = outer
+				}
+			}
+		}
+	}
+	key.Set(&d)
+	return true
+}
+
 /*
 ParsePacket
 return values
@@ -717,7 +1084,7 @@ func (o *Parser) ParsePacket(m *Mbuf) int {
 			tun.Set(&d)
 			o.stats.eapolPkts++
 			o.stats.eapolBytes += uint64(packetSize)
-			return o.eapol(&ps)
+			return o.onEtherType(&ps, nextHdr)
 
 		case layers.EthernetTypeARP:
 			if packetSize < uint32(offset+layers.ARPHeaderSize) {
@@ -728,7 +1095,38 @@ func (o *Parser) ParsePacket(m *Mbuf) int {
 			tun.Set(&d)
 			o.stats.arpPkts++
 			o.stats.arpBytes += uint64(packetSize)
-			return o.arp(&ps)
+			return o.onEtherType(&ps, nextHdr)
+		case TUNNEL_ENCAP_TPID:
+			// internal tag of a decapsulated packet, see tunnel_encap.go
+			if offset != 14 || packetSize < uint32(offset+4) {
+				o.stats.errDot1qTooShort++
+				return PARSER_ERR
+			}
+			ns := o.tctx.encap.getNs(binary.BigEndian.Uint16(p[14:16]))
+			if ns == nil {
+				o.tctx.encap.stats.errDecapNoNs++
+				return PARSER_ERR
+			}
+			ns.Key.Get(&d)
+			nextHdr = layers.EthernetType(binary.BigEndian.Uint16(p[16:18]))
+			offset += 4
+			if nextHdr == layers.EthernetTypeDot1Q || nextHdr == layers.EthernetTypeQinQ {
+				o.tctx.encap.stats.errDecapInnerVlan++
+				return PARSER_ERR
+			}
+		case layers.EthernetTypeMPLSUnicast:
+			var ok bool
+			nextHdr, offset, ok = o.parseMpls(p[:packetSize], offset, &d)
+			if !ok {
+				return PARSER_ERR
+			}
+		case PBB_ITAG_TYPE:
+			ps.L3 = offset
+			tun.Set(&d)
+			if r, ok := o.decap(&ps, TUNNEL_ENCAP_PBB, offset); ok {
+				return r
+			}
+			return o.onEtherType(&ps, nextHdr)
 		case layers.EthernetTypeDot1Q, layers.EthernetTypeQinQ:
 			if packetSize < uint32(offset+4) {
 				o.stats.errDot1qTooShort++
@@ -754,10 +1152,6 @@ func (o *Parser) ParsePacket(m *Mbuf) int {
 				o.stats.errIPv4HeaderTooShort++
 				return PARSER_ERR
 			}
-			if ipv4.IsFragment() {
-				o.stats.errIPv4Fragment++
-				return PARSER_ERR
-			}
 			hdr := ipv4.GetHeaderLen()
 			if hdr < 20 {
 				o.stats.errIPv4HeaderTooShort++
@@ -779,6 +1173,10 @@ func (o *Parser) ParsePacket(m *Mbuf) int {
 				o.stats.errIPv4cs++
 				return PARSER_ERR
 			}
+			if ipv4.IsFragment() {
+				tun.Set(&d)
+				return o.reass.onIPv4(&ps, ipv4)
+			}
 			l4len := ipv4.GetLength() - ipv4.GetHeaderLen()
 			ps.L4 = offset + hdr
 			offset = ps.L4
@@ -810,6 +1208,7 @@ func (o *Parser) ParsePacket(m *Mbuf) int {
 			tun.Set(&d)
 
 			nh := ipv6.NextHeader()
+			nhOff := ps.L3 + 6 // offset of the next header that points to the current one
 			var osize uint16
 			doloop := true
 			for doloop {
@@ -828,6 +1227,7 @@ func (o *Parser) ParsePacket(m *Mbuf) int {
 					nh = ipv6ex.NextHeader()
 					processIpv6Options(p[l4+2:l4+hl], &ps.Flags)
 
+					nhOff = l4
 					l4len -= hl
 					osize += hl
 					l4 += hl
@@ -852,12 +1252,12 @@ func (o *Parser) ParsePacket(m *Mbuf) int {
 					nh = ipv6ex.NextHeader()
 					processIpv6Options(p[l4+2:l4+hl], &ps.Flags)
 
+					nhOff = l4
 					l4len -= hl
 					osize += hl
 					l4 += hl
 				case IPV6_EXT_Fragment:
-					o.stats.errIPv6Fragment++
-					return PARSER_ERR
+					return o.reass.onIPv6(&ps, ipv6, l4, l4len, nhOff)
 
 				case IPV6_EXT_JUMBO:
 					// not supported
@@ -875,20 +1275,50 @@ func (o *Parser) ParsePacket(m *Mbuf) int {
 			ps.L4 = l4
 			return o.parsePacketL4(&ps, nh, ipv6.GetPhCs(osize, nh), l4len, uint16(nextHdr))
 		default:
-			o.stats.errL3ProtoUnsupported++
-			return PARSER_ERR
+			ps.L3 = offset
+			tun.Set(&d)
+			if nextHdr <= ETH_802_3_MAX_LEN {
+				return o.onLlc(&ps, nextHdr)
+			}
+			return o.onEtherType(&ps, nextHdr)
 		}
 	}
 	return 0
 }
 
+/*
+ParserRegisterData keys of the packets that a protocol handles. The vlan tags, IPv4/IPv6 and
+the TCP/UDP headers are validated by the parser before the callback is called.
+The keys are added to the parser of a thread by CThreadCtx.RegisterParserCb, a key can't be
+registered by two protocols.
+*/
+type ParserRegisterData struct {
+	EtherTypes []uint16 // ether type after the vlan tags, e.g. ARP
+	SnapTypes  []uint64 // OUI<<16 | protocol id of 802.3 LLC/SNAP frames, e.g. CDP 0x00000c2000
+	LlcSaps    []uint8  // DSAP of 802.3 LLC frames without SNAP, e.g. STP 0x42
+	IpProtos   []uint8  // IPv4 protocol/IPv6 next header, e.g. ICMP. not TCP/UDP
+	UdpPorts   []uint16 // UDP destination port over IPv4
+	UdpV6Ports []uint16 // UDP destination port over IPv6
+	TcpPorts   []uint16 // TCP destination port over IPv4
+	TcpV6Ports []uint16 // TCP destination port over IPv6
+	UdpDefault bool     // UDP packets that do not match any port
+	TcpDefault bool     // TCP packets that do not match any port
+	L3Default  bool     // ether types that do not match
+	L4Default  bool     // IP protocols that do not match
+}
+
+type parserProtocol struct {
+	cb   ParserCb
+	data ParserRegisterData
+}
+
 type parserProtocols struct {
-	M map[string]ParserCb
+	M map[string]*parserProtocol
 }
 
 var parserDb parserProtocols
 
-func getProto(proto string) ParserCb {
+func getProto(proto string) *parserProtocol {
 	_, ok := parserDb.M[proto]
 	if !ok {
 		err := fmt.Sprintf(" parser protocol %s is no register ", proto)
@@ -897,19 +1327,31 @@ func getProto(proto string) ParserCb {
 	return parserDb.M[proto]
 }
 
-func ParserRegister(proto string, cb ParserCb) {
+// ParserRegister registers the callback of a protocol and the keys of the packets it handles, should be called on init time
+func ParserRegister(proto string, cb ParserCb, data ParserRegisterData) {
 	_, ok := parserDb.M[proto]
 	if ok {
 		s := fmt.Sprintf(" Can't register the same protocol twice %s ", proto)
 		panic(s)
 	}
-	fmt.Sprintf(" register protocol %s ", proto)
-	parserDb.M[proto] = cb
+	for _, t := range data.EtherTypes {
+		switch layers.EthernetType(t) {
+		case layers.EthernetTypeIPv4, layers.EthernetTypeIPv6, layers.EthernetTypeDot1Q, layers.EthernetTypeQinQ:
+			panic(fmt.Sprintf(" parser protocol %s, ether type 0x%04x is handled by the parser ", proto, t))
+		}
+	}
+	for _, p := range data.IpProtos {
+		switch layers.IPProtocol(p) {
+		case layers.IPProtocolTCP, layers.IPProtocolUDP:
+			panic(fmt.Sprintf(" parser protocol %s, use ports for tcp/udp ", proto))
+		}
+	}
+	parserDb.M[proto] = &parserProtocol{cb: cb, data: data}
 }
 
 func init() {
 	if runtime.NumGoroutine() != 1 {
 		panic(" NumGoroutine() should be 1 on init time, require lock  ")
 	}
-	parserDb.M = make(map[string]ParserCb)
+	parserDb.M = make(map[string]*parserProtocol)
 }
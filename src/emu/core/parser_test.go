@@ -33,8 +33,8 @@ func arpSupported(ps *ParserPacketState) int {
 func TestParserArp(t *testing.T) {
 	tctx := NewThreadCtx(0, 4510, false, nil)
 	var parser Parser
-	parser.tctx = tctx
-	parser.arp = arpSupported
+	parser.Init(tctx)
+	parser.addProto("arp", &parserProtocol{cb: arpSupported, data: ParserRegisterData{EtherTypes: []uint16{uint16(layers.EthernetTypeARP)}}})
 	m1 := tctx.MPool.Alloc(128)
 
 	buf := gopacket.NewSerializeBuffer()
@@ -92,8 +92,8 @@ func TestParserArp(t *testing.T) {
 func TestParserArp1(t *testing.T) {
 	tctx := NewThreadCtx(0, 4510, false, nil)
 	var parser Parser
-	parser.tctx = tctx
-	parser.arp = arpSupported
+	parser.Init(tctx)
+	parser.addProto("arp", &parserProtocol{cb: arpSupported, data: ParserRegisterData{EtherTypes: []uint16{uint16(layers.EthernetTypeARP)}}})
 	m1 := tctx.MPool.Alloc(128)
 
 	buf := gopacket.NewSerializeBuffer()
@@ -154,8 +154,8 @@ func TestParserArp1(t *testing.T) {
 func TestParserIcmp(t *testing.T) {
 	tctx := NewThreadCtx(0, 4510, false, nil)
 	var parser Parser
-	parser.tctx = tctx
-	parser.icmp = arpSupported
+	parser.Init(tctx)
+	parser.addProto("icmp", &parserProtocol{cb: arpSupported, data: ParserRegisterData{IpProtos: []uint8{uint8(layers.IPProtocolICMPv4)}}})
 	m1 := tctx.MPool.Alloc(128)
 
 	buf := gopacket.NewSerializeBuffer()
@@ -222,8 +222,8 @@ func TestParserIcmp(t *testing.T) {
 func TestParserDhcp1(t *testing.T) {
 	tctx := NewThreadCtx(0, 4510, false, nil)
 	var parser Parser
-	parser.tctx = tctx
-	parser.dhcp = arpSupported
+	parser.Init(tctx)
+	parser.addProto("dhcp", &parserProtocol{cb: arpSupported, data: ParserRegisterData{UdpPorts: []uint16{68}}})
 
 	buf := gopacket.NewSerializeBuffer()
 	/*opts := gopacket.SerializeOptions{FixLengths: true,
@@ -306,8 +306,8 @@ func TestParserDhcp1(t *testing.T) {
 func TestParserDhcpInvalidCs(t *testing.T) {
 	tctx := NewThreadCtx(0, 4510, false, nil)
 	var parser Parser
-	parser.tctx = tctx
-	parser.dhcp = arpSupported
+	parser.Init(tctx)
+	parser.addProto("dhcp", &parserProtocol{cb: arpSupported, data: ParserRegisterData{UdpPorts: []uint16{68}}})
 
 	buf := gopacket.NewSerializeBuffer()
 	/*opts := gopacket.SerializeOptions{FixLengths: true,
@@ -391,8 +391,8 @@ func TestParserIpv6Option(t *testing.T) {
 		0x02, 0x7d, 0x00, 0x00}
 	tctx := NewThreadCtx(0, 4510, false, nil)
 	var parser Parser
-	parser.tctx = tctx
-	parser.icmpv6 = Icmpv6Supported
+	parser.Init(tctx)
+	parser.addProto("icmpv6", &parserProtocol{cb: Icmpv6Supported, data: ParserRegisterData{IpProtos: []uint8{uint8(layers.IPProtocolICMPv6)}}})
 
 	m1 := tctx.MPool.Alloc(uint16(len(packet)))
 	m1.SetVPort(7)
@@ -401,3 +401,78 @@ func TestParserIpv6Option(t *testing.T) {
 	parser.ParsePacket(m1)
 
 }
+
+func TestParserRegistry(t *testing.T) {
+	tctx := NewThreadCtx(0, 4510, false, nil)
+	var parser Parser
+	parser.Init(tctx)
+	var last string
+	cb := func(name string) ParserCb {
+		return func(ps *ParserPacketState) int {
+			last = name
+			return 0
+		}
+	}
+	parser.addProto("dns", &parserProtocol{cb: cb("dns"), data: ParserRegisterData{UdpPorts: []uint16{53}, UdpV6Ports: []uint16{53}}})
+	parser.addProto("trans", &parserProtocol{cb: cb("trans"), data: ParserRegisterData{UdpDefault: true}})
+	parser.addProto("lldp", &parserProtocol{cb: cb("lldp"), data: ParserRegisterData{EtherTypes: []uint16{0x88cc}}})
+	parser.addProto("cdp", &parserProtocol{cb: cb("cdp"), data: ParserRegisterData{SnapTypes: []uint64{0x00000c2000}}})
+	parser.addProto("stp", &parserProtocol{cb: cb("stp"), data: ParserRegisterData{LlcSaps: []uint8{0x42}}})
+	parser.addProto("l4", &parserProtocol{cb: cb("l4"), data: ParserRegisterData{L4Default: true}})
+	parser.addProto("dns", &parserProtocol{cb: cb("dns"), data: ParserRegisterData{UdpPorts: []uint16{53}}}) // already registered
+
+	for _, ipv6 := range []bool{false, true} {
+		pkt, _ := reassBuildUdp(ipv6, 100)
+		if r := reassParse(tctx, &parser, pkt); r != 0 || last != "trans" {
+			t.Fatalf(" unmatched udp should go to the default, got %s ipv6:%v ", last, ipv6)
+		}
+		pkt, _ = reassBuildUdpPort(ipv6, 100, 53)
+		if r := reassParse(tctx, &parser, pkt); r != 0 || last != "dns" {
+			t.Fatalf(" udp port 53 should go to dns, got %s ipv6:%v ", last, ipv6)
+		}
+	}
+
+	pkt := make([]byte, 60)
+	copy(pkt[12:], []byte{0x88, 0xcc})
+	if r := reassParse(tctx, &parser, pkt); r != 0 || last != "lldp" {
+		t.Fatalf(" ether type should go to lldp, got %s ", last)
+	}
+	copy(pkt[12:], []byte{0x88, 0xcd})
+	if r := reassParse(tctx, &parser, pkt); r != PARSER_ERR || parser.stats.errL3ProtoUnsupported != 1 {
+		t.Fatalf(" unmatched ether type should be dropped ")
+	}
+	// 802.3 length and LLC/SNAP header
+	copy(pkt[12:], []byte{0x00, 0x2e, 0xaa, 0xaa, 0x03, 0x00, 0x00, 0x0c, 0x20, 0x00})
+	if r := reassParse(tctx, &parser, pkt); r != 0 || last != "cdp" || parser.stats.snapPkts != 1 {
+		t.Fatalf(" snap type should go to cdp, got %s ", last)
+	}
+	pkt[21] = 0x01
+	if r := reassParse(tctx, &parser, pkt); r != PARSER_ERR || parser.stats.errL3ProtoUnsupported != 2 {
+		t.Fatalf(" unmatched snap type should be dropped ")
+	}
+	// LLC header by DSAP
+	copy(pkt[12:], []byte{0x00, 0x26, 0x42, 0x42, 0x03, 0x00, 0x00, 0x00, 0x00})
+	if r := reassParse(tctx, &parser, pkt); r != 0 || last != "stp" || parser.stats.snapPkts != 1 || parser.stats.llcPkts != 1 {
+		t.Fatalf(" llc sap 0x42 should go to stp, got %s ", last)
+	}
+	pkt[14] = 0xfe
+	if r := reassParse(tctx, &parser, pkt); r != PARSER_ERR || parser.stats.errL3ProtoUnsupported != 3 {
+		t.Fatalf(" unmatched llc sap should be dropped ")
+	}
+
+	pkt, _ = reassBuildUdp(false, 100)
+	ipv4 := layers.IPv4Header(pkt[14:34])
+	pkt[14+9] = 47 // GRE
+	ipv4.UpdateChecksum()
+	last = ""
+	if r := reassParse(tctx, &parser, pkt); r != 0 || last != "l4" {
+		t.Fatalf(" unmatched ip protocol should go to the default, got %s ", last)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatalf(" the same port can't be registered twice ")
+		}
+	}()
+	parser.addProto("dns2", &parserProtocol{cb: cb("dns2"), data: ParserRegisterData{UdpPorts: []uint16{53}}})
+}
@@ -64,6 +64,17 @@ func (o *CZmqJsonRPC2) NewZmqRpc(serverPort uint16, simulation bool) {
 	bindStr := fmt.Sprintf("tcp://*:%d", o.serverPort)
 	socket.Bind(bindStr)
 
+	o.newMethodRepository()
+}
+
+// NewRpc create the methods repository without a zmq server, the requests are dispatched by another thread
+func (o *CZmqJsonRPC2) NewRpc() {
+	o.simulation = false
+	o.cn = make(chan []byte)
+	o.newMethodRepository()
+}
+
+func (o *CZmqJsonRPC2) newMethodRepository() {
 	mr := jsonrpc.NewMethodRepository()
 	o.mr = mr
 	o.mr.Verbose = false
@@ -109,7 +120,9 @@ func (o *CZmqJsonRPC2) StartRxThread() {
 
 // Delete  this is an help
 func (o *CZmqJsonRPC2) Delete() {
-	o.socket.Close()
+	if o.socket != nil {
+		o.socket.Close()
+	}
 }
 
 // HandleReqToChan input buffer return resonse to chan
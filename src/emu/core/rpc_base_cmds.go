@@ -244,7 +244,7 @@ func (h ApiNsIterHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMess
 	/* covert to json format */
 	for _, o := range keys {
 		k := new(CTunnelDataJson)
-		o.GetJson(k)
+		tctx.GetNs(o).GetTunnelJson(k)
 		res.Vec = append(res.Vec, k)
 	}
 	return &res, nil
@@ -3,9 +3,11 @@ package core
 import (
 	"encoding/binary"
 	"encoding/hex"
+	"external/google/gopacket/layers"
 	"fmt"
 	"io/ioutil"
 	"math/rand"
+	"net"
 	"os"
 	"testing"
 	"time"
@@ -28,20 +30,57 @@ const (
 )
 
 const (
-	DEF_TPID = 0x8100
+	DEF_TPID         = 0x8100
+	MAX_MPLS_LABELS  = 4
+	MPLS_DEF_TTL     = 64
+	MPLS_LABEL_MASK  = 0xfffff
+	MPLS_BOTTOM_FLAG = 0x100
 )
 
 type CTunnelData struct {
-	Vport uint16    // virtual port
-	Vlans [2]uint32 // vlan tags include tpid
+	Vport   uint16                  // virtual port
+	Vlans   [2]uint32               // vlan tags include tpid
+	Encap   uint8                   // TUNNEL_ENCAP_xx
+	Vni     uint32                  // VXLAN VNI, GRE key or PBB I-SID
+	MplsCnt uint8                   // number of MPLS labels
+	Mpls    [MAX_MPLS_LABELS]uint32 // MPLS label stack, outermost first, 20 bits each
+}
+
+// appendL2 appends the vlan tags and the ether type, with MPLS labels the label stack is added for every ether type,
+// the payload type is identified by its first bytes (IPv4, IPv6 or ARP)
+func (o *CTunnelData) appendL2(b []byte, next uint16) []byte {
+	for _, val := range o.Vlans {
+		if val != 0 {
+			b = append(b, 0, 0, 0, 0)
+			binary.BigEndian.PutUint32(b[len(b)-4:], val)
+		}
+	}
+	if o.MplsCnt == 0 {
+		b = append(b, 0, 0)
+		binary.BigEndian.PutUint16(b[len(b)-2:], next)
+		return b
+	}
+	b = append(b, 0, 0)
+	binary.BigEndian.PutUint16(b[len(b)-2:], uint16(layers.EthernetTypeMPLSUnicast))
+	for i := uint8(0); i < o.MplsCnt; i++ {
+		val := (o.Mpls[i] << 12) | MPLS_DEF_TTL
+		if i == o.MplsCnt-1 {
+			val |= MPLS_BOTTOM_FLAG
+		}
+		b = append(b, 0, 0, 0, 0)
+		binary.BigEndian.PutUint32(b[len(b)-4:], val)
+	}
+	return b
 }
 
 /* CTunnelDataJson json representation of tunnel data */
 type CTunnelDataJson struct {
-	Vport   uint16        `json:"vport"`
-	Tpid    [2]uint16     `json:"tpid"`
-	Tci     [2]uint16     `json:"tci"`
-	Plugins *MapJsonPlugs `json:"plugs"`
+	Vport   uint16            `json:"vport"`
+	Tpid    [2]uint16         `json:"tpid"`
+	Tci     [2]uint16         `json:"tci"`
+	Mpls    []uint32          `json:"mpls,omitempty" validate:"max=4"` // MPLS labels, outermost first, up to MAX_MPLS_LABELS
+	Encap   *CTunnelEncapJson `json:"encap,omitempty"`
+	Plugins *MapJsonPlugs     `json:"plugs"`
 }
 
 type RpcCmdTunnel struct {
@@ -49,10 +88,10 @@ type RpcCmdTunnel struct {
 }
 
 type RpcCmdTunnels struct {
-	Tunnels []CTunnelDataJson `json:"tunnels" validate:"required"`
+	Tunnels []CTunnelDataJson `json:"tunnels" validate:"required,dive"`
 }
 
-type CTunnelKey [4 + 4 + 4]byte
+type CTunnelKey [4 + 4 + 4 + 4 + 4*MAX_MPLS_LABELS]byte
 
 func (o *CTunnelKey) DumpHex() {
 	fmt.Println(hex.Dump(o[0:]))
@@ -68,6 +107,17 @@ func (o CTunnelKey) toString(newLine bool) string {
 			s += fmt.Sprintf(",")
 		}
 	}
+	if d.MplsCnt > 0 {
+		s += fmt.Sprintf(",mpls:%v", d.Mpls[:d.MplsCnt])
+	}
+	switch d.Encap {
+	case TUNNEL_ENCAP_VXLAN:
+		s += fmt.Sprintf(",vxlan:%d", d.Vni)
+	case TUNNEL_ENCAP_GRE:
+		s += fmt.Sprintf(",gre:%d", d.Vni)
+	case TUNNEL_ENCAP_PBB:
+		s += fmt.Sprintf(",pbb:%d", d.Vni)
+	}
 	if newLine {
 		s += fmt.Sprintf("\n")
 	}
@@ -83,20 +133,31 @@ func (o CTunnelKey) StringRpc() string {
 }
 
 func (o *CTunnelKey) Clear() {
-	*o = CTunnelKey([12]byte{})
+	*o = CTunnelKey{}
 }
 
 func (o *CTunnelKey) Set(d *CTunnelData) {
-	binary.LittleEndian.PutUint16(o[2:4], 0)
+	o[2] = d.Encap
+	o[3] = d.MplsCnt
 	binary.LittleEndian.PutUint16(o[0:2], d.Vport)
 	binary.LittleEndian.PutUint32(o[4:8], d.Vlans[0])
 	binary.LittleEndian.PutUint32(o[8:12], d.Vlans[1])
+	binary.LittleEndian.PutUint32(o[12:16], d.Vni)
+	for i := 0; i < MAX_MPLS_LABELS; i++ {
+		binary.LittleEndian.PutUint32(o[16+4*i:20+4*i], d.Mpls[i])
+	}
 }
 
 func (o *CTunnelKey) Get(d *CTunnelData) {
 	d.Vport = binary.LittleEndian.Uint16(o[0:2])
 	d.Vlans[0] = binary.LittleEndian.Uint32(o[4:8])
 	d.Vlans[1] = binary.LittleEndian.Uint32(o[8:12])
+	d.Encap = o[2]
+	d.MplsCnt = o[3]
+	d.Vni = binary.LittleEndian.Uint32(o[12:16])
+	for i := 0; i < MAX_MPLS_LABELS; i++ {
+		d.Mpls[i] = binary.LittleEndian.Uint32(o[16+4*i : 20+4*i])
+	}
 }
 
 func (o *CTunnelKey) GetJson(d *CTunnelDataJson) {
@@ -112,9 +173,21 @@ func (o *CTunnelKey) GetJson(d *CTunnelDataJson) {
 			d.Tci[1] = uint16((t.Vlans[1] & 0xfff))
 		}
 	}
+	if t.MplsCnt > 0 {
+		d.Mpls = append([]uint32(nil), t.Mpls[:t.MplsCnt]...)
+	}
+	switch t.Encap {
+	case TUNNEL_ENCAP_VXLAN:
+		d.Encap = &CTunnelEncapJson{Type: "vxlan", Vni: t.Vni}
+	case TUNNEL_ENCAP_GRE:
+		d.Encap = &CTunnelEncapJson{Type: "gre", Key: t.Vni}
+	case TUNNEL_ENCAP_PBB:
+		d.Encap = &CTunnelEncapJson{Type: "pbb", Isid: t.Vni}
+	}
 }
 
-func (o *CTunnelKey) SetJson(d *CTunnelDataJson) {
+// SetJson sets the key from the json, returns an error in case a MPLS label is out of range
+func (o *CTunnelKey) SetJson(d *CTunnelDataJson) error {
 	var t CTunnelData
 
 	t.Vport = d.Vport
@@ -128,8 +201,24 @@ func (o *CTunnelKey) SetJson(d *CTunnelDataJson) {
 			t.Vlans[i] = (uint32(tpid) << 16) + uint32((d.Tci[i] & 0xfff))
 		}
 	}
+	var err error
+	for i, l := range d.Mpls {
+		if i == MAX_MPLS_LABELS {
+			break // validated by the json tag
+		}
+		if l > MPLS_LABEL_MASK {
+			err = fmt.Errorf(" mpls label %d is out of range, max is %d ", l, MPLS_LABEL_MASK)
+			break
+		}
+		t.Mpls[i] = l
+		t.MplsCnt++
+	}
+	if d.Encap != nil {
+		t.Encap, t.Vni = d.Encap.getVni()
+	}
 
 	o.Set(&t)
+	return err
 }
 
 type MapPortT map[uint16]bool
@@ -175,6 +264,7 @@ func newThreadCtxStats(o *CThreadCtxStats) *CCounterDb {
 		Help:     "active ns",
 		Unit:     "ops",
 		DumpZero: false,
+		Gauge:    true,
 		Info:     ScINFO})
 
 	return db
@@ -211,13 +301,18 @@ type CThreadCtx struct {
 	Veth            VethIF
 	validate        *validator.Validate
 	parser          Parser
-	simRecorder     []interface{} // record event for simulation
+	ipFrag          IpFrag
+	encap           tunnelEncapTbl // namespaces with VXLAN/GRE encapsulation
+	simRecorder     []interface{}  // record event for simulation
 	cdbv            *CCounterDbVec
 	clientStats     CClientStats
 	DefNsPlugs      *MapJsonPlugs         // Default plugins for each new namespace
 	shutdownTimer   CHTimerObj            // Timer object for shutdown
 	shutdownTimerCb ShutdownTimerCallback // Timer callback object
 	markForShutdown bool                  // device should shutdown, timer completed
+	rxCb            VethIFCb              // in case it is set, rx packets are forwarded to it instead of the parser
+	metricsC        chan chan []byte      // requests of the metrics server, nil in case it wasn't started
+	metricsLn       net.Listener          // listener of the metrics server
 }
 
 func NewThreadCtxProxy() *CThreadCtx {
@@ -239,6 +334,7 @@ func NewThreadCtxProxy() *CThreadCtx {
 
 func NewThreadCtx(Id uint32, serverPort uint16, simulation bool, simRx *VethIFSim) *CThreadCtx {
 	o := new(CThreadCtx)
+	o.Id = Id
 	o.timerctx = NewTimerCtx(simulation)
 	o.portMap = make(MapPortT)
 	o.Simulation = simulation
@@ -251,6 +347,8 @@ func NewThreadCtx(Id uint32, serverPort uint16, simulation bool, simRx *VethIFSi
 	o.DefNsPlugs = nil
 	o.validate = validator.New()
 	o.parser.Init(o)
+	o.ipFrag.init(o)
+	o.encap.init(o)
 	if simulation {
 		var simv VethIFSimulator
 		simv.Create(o)
@@ -266,16 +364,49 @@ func NewThreadCtx(Id uint32, serverPort uint16, simulation bool, simRx *VethIFSi
 	// shutdown timer
 	o.shutdownTimer.SetCB(&o.shutdownTimerCb, o, 0) // set callback
 
-	/* counters */
+	o.newCounters()
+	return o
+}
+
+// NewThreadCtxWorker creates a worker context for CThreadPool. It does not own a RPC server or a real veth,
+// both are provided by the pool.
+func NewThreadCtxWorker(Id uint32) *CThreadCtx {
+	o := new(CThreadCtx)
+	o.Id = Id
+	o.timerctx = NewTimerCtx(false)
+	o.portMap = make(MapPortT)
+	o.mapNs = make(MapNsT)
+	o.MPool.Init(mBUFS_CACHE)
+	o.rpc.NewRpc()
+	o.rpc.SetCtx(o) /* back pointer to interface this */
+	o.nsHead.SetSelf()
+	o.PluginCtx = NewPluginCtx(nil, nil, o, PLUGIN_LEVEL_THREAD)
+	o.DefNsPlugs = nil
+	o.validate = validator.New()
+	o.parser.Init(o)
+	o.ipFrag.init(o)
+	o.encap.init(o)
+	o.simRecorder = make([]interface{}, 0)
+	o.rpc.SetRpcRecorder(&o.simRecorder)
+
+	// shutdown timer
+	o.shutdownTimer.SetCB(&o.shutdownTimerCb, o, 0) // set callback
+
+	o.newCounters()
+	return o
+}
+
+func (o *CThreadCtx) newCounters() {
 	o.cdbv = NewCCounterDbVec("ctx")
 	o.cdbv.AddVec(o.MPool.Cdbv)
 	o.cdbv.Add(o.MPool.Cdb)
 	o.cdbv.Add(o.parser.Cdb)
+	o.cdbv.Add(o.ipFrag.Cdb)
+	o.cdbv.Add(o.encap.Cdb)
 	o.cdbv.Add(o.timerctx.Cdb)
 	cdb := newThreadCtxStats(&o.stats)
 	cdb.IOpt = &o.stats
 	o.cdbv.Add(cdb)
-	return o
 }
 
 func (o *CThreadCtx) SetZmqVeth(veth VethIF) {
@@ -339,7 +470,26 @@ func (o *CThreadCtx) RegisterParserCb(protocol string) {
 	o.parser.Register(protocol)
 }
 
+// HandleUdpDefault calls the protocol of the unmatched UDP packets (e.g. transport), for protocols that
+// register a port that is shared with it and get a packet that is not for them
+func (o *CThreadCtx) HandleUdpDefault(ps *ParserPacketState) int {
+	if o.parser.udpDef == nil {
+		o.parser.stats.errUDP++
+		return PARSER_ERR
+	}
+	return o.parser.udpDef(ps)
+}
+
+// SetRxCb forwards the rx packets to cb instead of the parser, cb is responsible to free the mbuf
+func (o *CThreadCtx) SetRxCb(cb VethIFCb) {
+	o.rxCb = cb
+}
+
 func (o *CThreadCtx) HandleRxPacket(m *Mbuf) {
+	if o.rxCb != nil {
+		o.rxCb.HandleRxPacket(m)
+		return
+	}
 	r := o.parser.ParsePacket(m)
 	if r < 0 {
 		if r == -1 {
@@ -381,6 +531,8 @@ func (o *CThreadCtx) MainLoop() {
 			o.timerctx.HandleTicks()
 		case msg := <-o.Veth.GetC(): // batch of rx packets
 			o.Veth.OnRxStream(msg)
+		case resC := <-o.metricsC:
+			resC <- o.collectMetrics()
 		}
 		o.Veth.FlushTx()
 		if o.markForShutdown {
@@ -498,8 +650,7 @@ func (o *CThreadCtx) UnmarshalTunnel(data []byte, key *CTunnelKey) error {
 	if err != nil {
 		return err
 	}
-	key.SetJson(&tun.Tun)
-	return nil
+	return key.SetJson(&tun.Tun)
 }
 
 func (o *CThreadCtx) UnmarshalTunnels(data []byte) ([]CTunnelKey, error) {
@@ -510,7 +661,9 @@ func (o *CThreadCtx) UnmarshalTunnels(data []byte) ([]CTunnelKey, error) {
 	}
 	keys := make([]CTunnelKey, len(tuns.Tunnels))
 	for i, tun := range tuns.Tunnels {
-		keys[i].SetJson(&tun)
+		if err = keys[i].SetJson(&tun); err != nil {
+			return nil, err
+		}
 	}
 	return keys, nil
 }
@@ -528,6 +681,19 @@ func (o *CThreadCtx) UnmarshalTunnelsPlugins(data []byte) ([]*MapJsonPlugs, erro
 	return plugs, nil
 }
 
+func (o *CThreadCtx) UnmarshalTunnelsEncap(data []byte) ([]*CTunnelEncapJson, error) {
+	var tuns RpcCmdTunnels
+	err := o.UnmarshalValidate(data, &tuns)
+	if err != nil {
+		return nil, err
+	}
+	encaps := make([]*CTunnelEncapJson, len(tuns.Tunnels))
+	for i, tun := range tuns.Tunnels {
+		encaps[i] = tun.Encap
+	}
+	return encaps, nil
+}
+
 func (o *CThreadCtx) RemoveNsRpc(params *fastjson.RawMessage) error {
 	var key CTunnelKey
 	err := o.UnmarshalTunnel(*params, &key)
@@ -568,11 +734,15 @@ func (o *CThreadCtx) RemoveNsRpcSlice(params *fastjson.RawMessage) error {
 }
 
 func (o *CThreadCtx) AddNsRpc(params *fastjson.RawMessage) (*CNSCtx, error) {
+	var tun RpcCmdTunnel
 	var key CTunnelKey
-	err := o.UnmarshalTunnel(*params, &key)
+	err := o.UnmarshalValidate(*params, &tun)
 	if err != nil {
 		return nil, err
 	}
+	if err = key.SetJson(&tun.Tun); err != nil {
+		return nil, err
+	}
 	ns := o.GetNs(&key)
 	if ns != nil {
 		err = fmt.Errorf(" error there is valid namespace for this tunnel, can't add it ")
@@ -580,8 +750,14 @@ func (o *CThreadCtx) AddNsRpc(params *fastjson.RawMessage) (*CNSCtx, error) {
 	}
 
 	ns = NewNSCtx(o, &key)
+	if err = ns.SetEncap(tun.Tun.Encap); err != nil {
+		return nil, err
+	}
 	/* add plugin data */
-	o.AddNs(&key, ns)
+	err = o.AddNs(&key, ns)
+	if err != nil {
+		return nil, err
+	}
 	return ns, nil
 }
 
@@ -596,6 +772,11 @@ func (o *CThreadCtx) AddNsRpcSlice(params *fastjson.RawMessage) error {
 		return err
 	}
 
+	encaps, err := o.UnmarshalTunnelsEncap(*params)
+	if err != nil {
+		return err
+	}
+
 	for i, key := range keys {
 		ns := o.GetNs(&key)
 		if ns != nil {
@@ -604,6 +785,9 @@ func (o *CThreadCtx) AddNsRpcSlice(params *fastjson.RawMessage) error {
 		}
 
 		ns = NewNSCtx(o, &key)
+		if err := ns.SetEncap(encaps[i]); err != nil {
+			return err
+		}
 		err := o.AddNs(&key, ns)
 		if err != nil {
 			return err
@@ -691,6 +875,7 @@ func (o *CThreadCtx) Delete() {
 	if o.shutdownTimer.IsRunning() {
 		o.timerctx.Stop(&o.shutdownTimer)
 	}
+	o.stopMetricsServer()
 	o.rpc.Delete()
 }
 
@@ -736,6 +921,11 @@ func (o *CThreadCtx) AddNs(key *CTunnelKey, ns *CNSCtx) error {
 	if o.HasNs(key) {
 		return fmt.Errorf("ns with tunnel %v already exists", *key)
 	}
+	if ns.encap != nil {
+		if err := o.encap.add(ns); err != nil {
+			return err
+		}
+	}
 	o.stats.addNs++
 	o.mapNs[*key] = ns
 	o.nsHead.AddLast(&ns.dlist)
@@ -753,6 +943,9 @@ func (o *CThreadCtx) RemoveNs(key *CTunnelKey) error {
 		return fmt.Errorf("ns with tunnel %v still has active clients, remove them", *key)
 	}
 	ns.OnRemove()
+	if ns.encap != nil {
+		o.encap.remove(ns)
+	}
 	o.stats.removeNs++
 	o.epoc++
 	o.nsHead.RemoveNode(&ns.dlist)
@@ -0,0 +1,767 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package core
+
+import (
+	"external/osamingo/jsonrpc"
+	"fmt"
+	"hash/fnv"
+
+	"github.com/intel-go/fastjson"
+)
+
+/* CThreadPool run the emulation on N workers (CThreadCtx), each one in its own goroutine
+
+   main thread  : RPC server, real veth (rx/tx), dispatch of rx packets and RPC requests
+   worker       : shard of the namespaces, timers, parser and plugins of its namespaces
+
+   A namespace is mapped to a worker by a hash of its tunnel key, so rx packets and RPC of the same
+   tunnel always get to the same worker. The worker state is accessed only from its goroutine, the main thread
+   runs a function on the worker (runOn) and waits for it.
+
+   Methods that are not related to one tunnel are handled by the pool:
+   ctx_add/ctx_remove/ctx_get_info - split the tunnels per worker
+   ctx_iter     - iterate the workers one after the other
+   ctx_cnt      - sum of the counters of all the workers and the main thread
+   shutdown, ctx_set_def_plugins - broadcast
+*/
+
+const (
+	THREAD_POOL_TX_QUEUE = 64
+	THREAD_POOL_RX_QUEUE = 64
+)
+
+type CThreadPoolStats struct {
+	rxPkts          uint64
+	rxDropParse     uint64
+	txPkts          uint64
+	txDropParse     uint64
+	rpcRouted       uint64
+	rpcPool         uint64
+	rpcBroadcastErr uint64
+	rpcSplitErr     uint64
+}
+
+func newThreadPoolStatsDb(o *CThreadPoolStats) *CCounterDb {
+	db := NewCCounterDb("pool")
+
+	db.Add(&CCounterRec{
+		Counter:  &o.rxPkts,
+		Name:     "rxPkts",
+		Help:     "rx packets dispatched to workers",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     ScINFO})
+
+	db.Add(&CCounterRec{
+		Counter:  &o.rxDropParse,
+		Name:     "rxDropParse",
+		Help:     "rx packets without a valid tunnel key",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     ScERROR})
+
+	db.Add(&CCounterRec{
+		Counter:  &o.txPkts,
+		Name:     "txPkts",
+		Help:     "tx packets from workers",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     ScINFO})
+
+	db.Add(&CCounterRec{
+		Counter:  &o.txDropParse,
+		Name:     "txDropParse",
+		Help:     "malformed tx messages from workers",
+		Unit:     "msgs",
+		DumpZero: false,
+		Info:     ScERROR})
+
+	db.Add(&CCounterRec{
+		Counter:  &o.rpcRouted,
+		Name:     "rpcRouted",
+		Help:     "rpc requests routed to a worker",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     ScINFO})
+
+	db.Add(&CCounterRec{
+		Counter:  &o.rpcPool,
+		Name:     "rpcPool",
+		Help:     "rpc requests handled by the pool",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     ScINFO})
+
+	db.Add(&CCounterRec{
+		Counter:  &o.rpcBroadcastErr,
+		Name:     "rpcBroadcastErr",
+		Help:     "broadcast rpc that failed on a worker",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     ScERROR})
+
+	db.Add(&CCounterRec{
+		Counter:  &o.rpcSplitErr,
+		Name:     "rpcSplitErr",
+		Help:     "split rpc that failed, ctx_add is rolled back",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     ScERROR})
+
+	return db
+}
+
+// CThreadWorker one worker of the pool
+type CThreadWorker struct {
+	Tctx  *CThreadCtx
+	veth  VethIFWorker
+	jobC  chan func()
+	rxC   chan []byte
+	rxVec []byte // rx message that is built by the main thread
+	done  chan bool
+}
+
+func (o *CThreadWorker) mainLoop() {
+	tctx := o.Tctx
+	for {
+		select {
+		case job := <-o.jobC:
+			job()
+		case <-tctx.C():
+			tctx.timerctx.HandleTicks()
+		case msg := <-o.rxC:
+			o.veth.OnRxStream(msg)
+		}
+		tctx.Veth.FlushTx()
+		if tctx.markForShutdown {
+			break
+		}
+	}
+	tctx.Veth.SimulatorCleanup()
+	close(o.done)
+}
+
+type CThreadPool struct {
+	tctx       *CThreadCtx // main thread, owns the RPC server and the veth
+	workers    []*CThreadWorker
+	txC        chan []byte
+	mr         *jsonrpc.MethodRepository // methods that are handled by the pool
+	iterWorker int
+	iterReady  bool
+	encapLocal map[CTunnelKey][]byte // the local tunnel end of the VXLAN/GRE namespaces
+	stats      CThreadPoolStats
+	cdb        *CCounterDb
+}
+
+// NewThreadPool creates n workers, tctx is the main thread context with the RPC server and the veth
+func NewThreadPool(tctx *CThreadCtx, n int) *CThreadPool {
+	if n < 1 {
+		panic(" thread pool should have at least one worker ")
+	}
+	o := new(CThreadPool)
+	o.tctx = tctx
+	o.txC = make(chan []byte, THREAD_POOL_TX_QUEUE)
+	o.encapLocal = make(map[CTunnelKey][]byte)
+	o.workers = make([]*CThreadWorker, n)
+	for i := range o.workers {
+		w := new(CThreadWorker)
+		w.Tctx = NewThreadCtxWorker(uint32(i + 1))
+		w.veth.Create(w.Tctx, o.txC)
+		w.Tctx.SetZmqVeth(&w.veth)
+		w.jobC = make(chan func())
+		w.rxC = make(chan []byte, THREAD_POOL_RX_QUEUE)
+		w.done = make(chan bool)
+		o.workers[i] = w
+	}
+
+	o.mr = jsonrpc.NewMethodRepository()
+	o.mr.SetCtx(o)
+	o.mr.RegisterMethod("ctx_add", ApiPoolSplitHandler{method: "ctx_add"}, false)
+	o.mr.RegisterMethod("ctx_remove", ApiPoolSplitHandler{method: "ctx_remove"}, false)
+	o.mr.RegisterMethod("ctx_get_info", ApiPoolSplitHandler{method: "ctx_get_info", merge: true}, false)
+	o.mr.RegisterMethod("ctx_iter", ApiPoolIterHandler{}, false)
+	o.mr.RegisterMethod("ctx_cnt", ApiPoolCntHandler{}, false)
+	o.mr.RegisterMethod("ctx_set_def_plugins", ApiPoolBroadcastHandler{method: "ctx_set_def_plugins"}, false)
+	o.mr.RegisterMethod("shutdown", ApiPoolBroadcastHandler{method: "shutdown", main: true}, false)
+
+	tctx.rpc.mr.Router = o.route
+	tctx.SetRxCb(o)
+
+	o.cdb = newThreadPoolStatsDb(&o.stats)
+	tctx.GetCounterDbVec().Add(o.cdb)
+	return o
+}
+
+// GetWorkers returns the workers context, used to register the plugins
+func (o *CThreadPool) GetWorkers() []*CThreadCtx {
+	r := make([]*CThreadCtx, len(o.workers))
+	for i, w := range o.workers {
+		r[i] = w.Tctx
+	}
+	return r
+}
+
+// GetWorkerId returns the index of the worker that owns the tunnel
+func (o *CThreadPool) GetWorkerId(key *CTunnelKey) int {
+	h := fnv.New32a()
+	h.Write(key[:])
+	return int(h.Sum32() % uint32(len(o.workers)))
+}
+
+func (o *CThreadPool) Start() {
+	for _, w := range o.workers {
+		go w.mainLoop()
+	}
+}
+
+// MainLoop of the main thread, returns after all the workers were stopped
+func (o *CThreadPool) MainLoop() {
+	tctx := o.tctx
+	for {
+		select {
+		case req := <-tctx.rpc.GetC():
+			tctx.rpc.HandleReqToChan(req) // RPC commands
+		case <-tctx.C():
+			tctx.timerctx.HandleTicks()
+		case msg := <-tctx.Veth.GetC(): // batch of rx packets
+			tctx.Veth.OnRxStream(msg)
+			o.flushRx()
+		case msg := <-o.txC:
+			o.onTxStream(msg)
+		case resC := <-tctx.metricsC:
+			resC <- o.collectMetrics()
+		}
+		tctx.Veth.FlushTx()
+		if tctx.markForShutdown {
+			break
+		}
+	}
+	o.stopWorkers()
+	tctx.Veth.SimulatorCleanup()
+	tctx.MPool.ClearCache()
+}
+
+// collectMetrics returns the metrics of the main thread and the workers, each one is labeled by its thread id
+func (o *CThreadPool) collectMetrics() []byte {
+	m := NewMetrics()
+	m.AddThread(o.tctx)
+	for _, w := range o.workers {
+		tctx := w.Tctx
+		o.runOn(w, func() { m.AddThread(tctx) })
+	}
+	return m.Bytes()
+}
+
+func (o *CThreadPool) stopWorkers() {
+	for _, w := range o.workers {
+		tctx := w.Tctx
+		o.runOn(w, func() { tctx.markForShutdown = true })
+	}
+	for _, w := range o.workers {
+		for stopped := false; !stopped; {
+			select {
+			case <-w.done:
+				stopped = true
+			case msg := <-o.txC:
+				o.onTxStream(msg)
+			}
+		}
+	}
+}
+
+// runOn runs f on the worker goroutine and waits for it. The tx of the workers is handled while waiting,
+// a worker could be blocked on it.
+func (o *CThreadPool) runOn(w *CThreadWorker, f func()) {
+	done := make(chan bool, 1)
+	job := func() {
+		f()
+		done <- true
+	}
+	for sent := false; !sent; {
+		select {
+		case w.jobC <- job:
+			sent = true
+		case msg := <-o.txC:
+			o.onTxStream(msg)
+		}
+	}
+	for {
+		select {
+		case <-done:
+			o.drainTx()
+			return
+		case msg := <-o.txC:
+			o.onTxStream(msg)
+		}
+	}
+}
+
+// drainTx handles the tx messages that are already queued
+func (o *CThreadPool) drainTx() {
+	for {
+		select {
+		case msg := <-o.txC:
+			o.onTxStream(msg)
+		default:
+			return
+		}
+	}
+}
+
+// HandleRxPacket dispatch rx packet from the real veth to the worker by its tunnel key
+func (o *CThreadPool) HandleRxPacket(m *Mbuf) {
+	var key CTunnelKey
+	if !m.IsContiguous() {
+		m1 := m.GetContiguous(&o.tctx.MPool)
+		m.FreeMbuf()
+		m = m1
+	}
+	p := m.GetData()
+	if !getPacketTunnelKey(p, m.VPort(), &key, o.isEncapLocal) {
+		o.stats.rxDropParse++
+		m.FreeMbuf()
+		return
+	}
+	w := o.workers[o.GetWorkerId(&key)]
+	if len(w.rxVec)+4+len(p) > VETH_WORKER_MAX_BUFFER_SIZE {
+		o.sendRx(w)
+	}
+	w.rxVec = appendPktMsg(w.rxVec, m.VPort(), p)
+	o.stats.rxPkts++
+	m.FreeMbuf()
+}
+
+// isEncapLocal returns true in case there is a VXLAN/GRE namespace for the key and the outer destination is its
+// local tunnel end, the same check of the worker decap
+func (o *CThreadPool) isEncapLocal(key *CTunnelKey, p []byte, l3 uint16) bool {
+	addr, ok := o.encapLocal[*key]
+	return ok && isEncapDst(p, l3, addr)
+}
+
+func (o *CThreadPool) sendRx(w *CThreadWorker) {
+	if len(w.rxVec) == 0 {
+		return
+	}
+	for sent := false; !sent; {
+		select {
+		case w.rxC <- w.rxVec:
+			sent = true
+		case msg := <-o.txC:
+			o.onTxStream(msg)
+		}
+	}
+	w.rxVec = nil // owned by the worker from now on
+}
+
+func (o *CThreadPool) flushRx() {
+	for _, w := range o.workers {
+		o.sendRx(w)
+	}
+}
+
+// onTxStream sends the packets of a worker using the real veth
+func (o *CThreadPool) onTxStream(msg []byte) {
+	veth := o.tctx.Veth
+	ok := walkPktMsg(msg, func(vport uint16, pkt []byte) {
+		m := o.tctx.MPool.Alloc(uint16(len(pkt)))
+		m.SetVPort(vport)
+		m.Append(pkt)
+		o.stats.txPkts++
+		veth.Send(m)
+	})
+	if !ok {
+		o.stats.txDropParse++
+	}
+}
+
+// invokeOn invokes the request on the worker. The result is marshaled in the worker as it could reference its counters.
+func (o *CThreadPool) invokeOn(w *CThreadWorker, r *jsonrpc.Request) *jsonrpc.Response {
+	var res *jsonrpc.Response
+	o.runOn(w, func() {
+		res = w.Tctx.rpc.mr.InvokeMethod(nil, r)
+		if res.Result != nil {
+			b, err := fastjson.Marshal(res.Result)
+			if err != nil {
+				res.Result = nil
+				res.Error = jsonrpc.ErrInternal()
+				return
+			}
+			raw := fastjson.RawMessage(b)
+			res.Result = &raw
+		}
+	})
+	return res
+}
+
+func (o *CThreadPool) setApi(api string) {
+	o.mr.SetAPI(api)
+	for _, w := range o.workers {
+		mr := w.Tctx.rpc.mr
+		o.runOn(w, func() { mr.SetAPI(api) })
+	}
+}
+
+// getWorkerByParams returns the worker of the "tun" param, the first worker in case there isn't one
+func (o *CThreadPool) getWorkerByParams(params *fastjson.RawMessage) *CThreadWorker {
+	var p struct {
+		Tun *CTunnelDataJson `json:"tun"`
+	}
+	if params == nil {
+		return o.workers[0]
+	}
+	if err := fastjson.Unmarshal(*params, &p); err != nil || p.Tun == nil {
+		return o.workers[0]
+	}
+	var key CTunnelKey
+	key.SetJson(p.Tun)
+	return o.workers[o.GetWorkerId(&key)]
+}
+
+// route is called by the main RPC server for each request
+func (o *CThreadPool) route(r *jsonrpc.Request) *jsonrpc.Response {
+	mr := o.tctx.rpc.mr
+	switch r.Method {
+	case "api_sync_v2":
+		res := mr.InvokeMethod(nil, r)
+		if res.Error == nil {
+			o.setApi(mr.GetAPI())
+		}
+		return res
+	case "get_version", "ping":
+		return mr.InvokeMethod(nil, r)
+	}
+	if _, ok := o.mr.Methods()[r.Method]; ok {
+		o.stats.rpcPool++
+		return o.mr.InvokeMethod(nil, r)
+	}
+	o.stats.rpcRouted++
+	return o.invokeOn(o.getWorkerByParams(r.Params), r)
+}
+
+func newPoolRequest(method string, params *fastjson.RawMessage) *jsonrpc.Request {
+	return &jsonrpc.Request{Version: jsonrpc.Version, Method: method, Params: params}
+}
+
+type (
+	// ApiPoolSplitHandler split the tunnels of the request per worker, merge the results in case of merge.
+	// ctx_add/ctx_remove are checked on all the workers first, a ctx_add that failed on a worker is rolled back
+	ApiPoolSplitHandler struct {
+		method string
+		merge  bool
+	}
+
+	// ApiPoolBroadcastHandler invoke the request on all the workers (and the main thread in case of main)
+	ApiPoolBroadcastHandler struct {
+		method string
+		main   bool
+	}
+
+	ApiPoolIterHandler struct{}
+	ApiPoolCntHandler  struct{}
+)
+
+func (h ApiPoolSplitHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	o := ctx.(*CThreadPool)
+	var m map[string]*fastjson.RawMessage
+	var tuns []*fastjson.RawMessage
+
+	if params == nil || fastjson.Unmarshal(*params, &m) != nil || m["tunnels"] == nil ||
+		fastjson.Unmarshal(*m["tunnels"], &tuns) != nil {
+		// let the worker report the validation error
+		res := o.invokeOn(o.workers[0], newPoolRequest(h.method, params))
+		return res.Result, res.Error
+	}
+
+	groups := make([][]int, len(o.workers))
+	keys := make([]CTunnelKey, len(tuns))
+	encaps := make([]*CTunnelEncapJson, len(tuns))
+	for i, t := range tuns {
+		var tj CTunnelDataJson
+		if t != nil {
+			fastjson.Unmarshal(*t, &tj)
+		}
+		keys[i].SetJson(&tj)
+		encaps[i] = tj.Encap
+		w := o.GetWorkerId(&keys[i])
+		groups[w] = append(groups[w], i)
+	}
+
+	// check all the workers before one of them is changed
+	var failed []*fastjson.RawMessage
+	for w, indexes := range groups {
+		for _, index := range o.checkSplit(h.method, o.workers[w], keys, indexes) {
+			failed = append(failed, tuns[index])
+		}
+	}
+	if len(failed) > 0 {
+		o.stats.rpcSplitErr++
+		return nil, splitError(h.method+" is not valid", failed)
+	}
+
+	results := make([]*fastjson.RawMessage, len(tuns))
+	var done []int
+	for w, indexes := range groups {
+		if len(indexes) == 0 {
+			continue
+		}
+		sub := make([]*fastjson.RawMessage, len(indexes))
+		for i, index := range indexes {
+			sub[i] = tuns[index]
+		}
+		b, _ := fastjson.Marshal(sub)
+		subTuns := fastjson.RawMessage(b)
+		m["tunnels"] = &subTuns
+		b, _ = fastjson.Marshal(m)
+		subParams := fastjson.RawMessage(b)
+
+		res := o.invokeOn(o.workers[w], newPoolRequest(h.method, &subParams))
+		if res.Error != nil {
+			o.stats.rpcSplitErr++
+			if h.method == "ctx_add" {
+				// the namespaces did not exist, remove the ones that were added by this request
+				for _, d := range append(done, w) {
+					o.removeSplit(o.workers[d], keys, groups[d])
+				}
+			}
+			return nil, splitError(res.Error.Message, sub)
+		}
+		done = append(done, w)
+		if h.merge {
+			var vec []*fastjson.RawMessage
+			raw, _ := res.Result.(*fastjson.RawMessage)
+			if raw == nil || fastjson.Unmarshal(*raw, &vec) != nil || len(vec) != len(indexes) {
+				return nil, &jsonrpc.Error{
+					Code:    jsonrpc.ErrorCodeInternal,
+					Message: fmt.Sprintf("invalid response from worker %d", w),
+				}
+			}
+			for i, index := range indexes {
+				results[index] = vec[i]
+			}
+		}
+	}
+	for i := range keys {
+		switch h.method {
+		case "ctx_add":
+			if encaps[i] != nil {
+				o.encapLocal[keys[i]] = encaps[i].localAddr()
+			}
+		case "ctx_remove":
+			delete(o.encapLocal, keys[i])
+		}
+	}
+	if h.merge {
+		return results, nil
+	}
+	return nil, nil
+}
+
+func splitError(msg string, tuns []*fastjson.RawMessage) *jsonrpc.Error {
+	b, _ := fastjson.Marshal(tuns)
+	return &jsonrpc.Error{
+		Code:    jsonrpc.ErrorCodeInvalidRequest,
+		Message: fmt.Sprintf("%s, tunnels %s", msg, string(b)),
+	}
+}
+
+// checkSplit returns the indexes of the tunnels that ctx_add/ctx_remove would fail on the worker
+func (o *CThreadPool) checkSplit(method string, w *CThreadWorker, keys []CTunnelKey, indexes []int) []int {
+	var failed []int
+	if len(indexes) == 0 {
+		return failed
+	}
+	tctx := w.Tctx
+	o.runOn(w, func() {
+		for _, i := range indexes {
+			ns := tctx.GetNs(&keys[i])
+			switch method {
+			case "ctx_add":
+				if ns != nil {
+					failed = append(failed, i)
+				}
+			case "ctx_remove":
+				if ns == nil {
+					failed = append(failed, i)
+					break
+				}
+				ns.stats.PreUpdate()
+				if ns.stats.activeClient > 0 {
+					failed = append(failed, i)
+				}
+			}
+		}
+	})
+	return failed
+}
+
+// removeSplit removes the namespaces of the tunnels from the worker
+func (o *CThreadPool) removeSplit(w *CThreadWorker, keys []CTunnelKey, indexes []int) {
+	tctx := w.Tctx
+	o.runOn(w, func() {
+		for _, i := range indexes {
+			if tctx.GetNs(&keys[i]) != nil {
+				tctx.RemoveNs(&keys[i])
+			}
+		}
+	})
+}
+
+func (h ApiPoolBroadcastHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	o := ctx.(*CThreadPool)
+	r := newPoolRequest(h.method, params)
+	var rerr *jsonrpc.Error
+	for _, w := range o.workers {
+		res := o.invokeOn(w, r)
+		if res.Error != nil {
+			o.stats.rpcBroadcastErr++
+			if rerr == nil {
+				rerr = res.Error
+			}
+		}
+	}
+	if h.main && rerr == nil {
+		res := o.tctx.rpc.mr.InvokeMethod(nil, r)
+		rerr = res.Error
+	}
+	return nil, rerr
+}
+
+// iterReset resets the iterator of all the workers, returns false in case all of them are empty
+func (o *CThreadPool) iterReset() bool {
+	o.iterReady = false
+	o.iterWorker = 0
+	for _, w := range o.workers {
+		tctx := w.Tctx
+		var ready bool
+		o.runOn(w, func() { ready = tctx.IterReset() })
+		if ready {
+			o.iterReady = true
+		}
+	}
+	return o.iterReady
+}
+
+// iterNext returns up to n tunnels, moves to the next worker when one is done
+func (o *CThreadPool) iterNext(n uint16) ([]*CTunnelDataJson, error) {
+	r := make([]*CTunnelDataJson, 0)
+	for o.iterWorker < len(o.workers) && len(r) < int(n) {
+		tctx := o.workers[o.iterWorker].Tctx
+		var err error
+		var stopped bool
+		o.runOn(o.workers[o.iterWorker], func() {
+			if tctx.IterIsStopped() {
+				stopped = true
+				return
+			}
+			var keys []*CTunnelKey
+			keys, err = tctx.GetNext(n - uint16(len(r)))
+			for _, key := range keys {
+				k := new(CTunnelDataJson)
+				tctx.GetNs(key).GetTunnelJson(k)
+				r = append(r, k)
+			}
+			stopped = tctx.IterIsStopped()
+		})
+		if err != nil {
+			o.iterReady = false
+			return r, err
+		}
+		if stopped {
+			o.iterWorker++
+		}
+	}
+	if o.iterWorker == len(o.workers) {
+		o.iterReady = false
+	}
+	return r, nil
+}
+
+func (h ApiPoolIterHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	var p ApiNsIterParams
+	var res ApiNsIterResult
+	o := ctx.(*CThreadPool)
+	err := o.tctx.UnmarshalValidate(*params, &p)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+	if p.Reset {
+		res.Empty = !o.iterReset()
+	}
+	if res.Empty {
+		return &res, nil
+	}
+	if !o.iterReady {
+		res.Stopped = true
+		return &res, nil
+	}
+	res.Vec, err = o.iterNext(p.Count)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+	return &res, nil
+}
+
+func (h ApiPoolCntHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	var p ApiCntParams
+	o := ctx.(*CThreadPool)
+	err := o.tctx.UnmarshalValidate(*params, &p)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+
+	if p.Clear {
+		o.tctx.GetCounterDbVec().ClearValues()
+		for _, w := range o.workers {
+			cdbv := w.Tctx.GetCounterDbVec()
+			o.runOn(w, func() { cdbv.ClearValues() })
+		}
+		return nil, nil
+	}
+
+	if p.Meta {
+		// the main thread has the same counters as the workers + the real veth and the pool
+		meta := o.tctx.GetCounterDbVec().MarshalMeta()
+		for _, w := range o.workers {
+			var wmeta map[string]interface{}
+			cdbv := w.Tctx.GetCounterDbVec()
+			o.runOn(w, func() { wmeta = cdbv.MarshalMeta() })
+			for name, db := range wmeta {
+				if _, ok := meta[name]; !ok {
+					meta[name] = db
+				}
+			}
+		}
+		return meta, nil
+	}
+
+	values := make(map[string]map[string]interface{})
+	o.tctx.GetCounterDbVec().AddValuesTo(values, p.Zero)
+	for _, w := range o.workers {
+		cdbv := w.Tctx.GetCounterDbVec()
+		o.runOn(w, func() { cdbv.AddValuesTo(values, p.Zero) })
+	}
+
+	if len(p.Mask) > 0 {
+		masked := make(map[string]map[string]interface{})
+		for _, name := range p.Mask {
+			if v, ok := values[name]; ok {
+				masked[name] = v
+			}
+		}
+		return masked, nil
+	}
+	return values, nil
+}
@@ -0,0 +1,161 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package core
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func poolRpc(t *testing.T, tctx *CThreadCtx, req string) map[string]interface{} {
+	var res map[string]interface{}
+	b := tctx.rpc.HandleReq([]byte(req))
+	if err := json.Unmarshal(b, &res); err != nil {
+		t.Fatalf(" can't unmarshal response %s ", string(b))
+	}
+	if res["error"] != nil {
+		t.Fatalf(" rpc %s failed %v ", req, res["error"])
+	}
+	return res
+}
+
+func TestThreadPool1(t *testing.T) {
+	var simrx VethIFSim = &VethSink{}
+	tctx := NewThreadCtx(0, 4510, true, &simrx)
+	pool := NewThreadPool(tctx, 4)
+	pool.Start()
+
+	tuns := ""
+	for i := 1; i <= 32; i++ {
+		if i > 1 {
+			tuns += ","
+		}
+		tuns += fmt.Sprintf(`{"vport":1,"tci":[%d,0]}`, i)
+	}
+	poolRpc(t, tctx, `{"jsonrpc": "2.0", "method":"ctx_add", "params": {"tunnels": [`+tuns+`]}, "id": 1}`)
+
+	// namespaces are sharded by the tunnel key
+	total := 0
+	used := 0
+	for i, w := range pool.workers {
+		var cnt int
+		var keys []CTunnelKey
+		tctx := w.Tctx
+		pool.runOn(w, func() {
+			cnt = len(tctx.mapNs)
+			for k := range tctx.mapNs {
+				keys = append(keys, k)
+			}
+		})
+		for _, k := range keys {
+			if pool.GetWorkerId(&k) != i {
+				t.Fatalf(" namespace %v is in the wrong worker %d ", k, i)
+			}
+		}
+		total += cnt
+		if cnt > 0 {
+			used++
+		}
+	}
+	if total != 32 || used < 2 {
+		t.Fatalf(" namespaces are not sharded as expected total:%d used workers:%d ", total, used)
+	}
+
+	// iterate all the workers
+	res := poolRpc(t, tctx, `{"jsonrpc": "2.0", "method":"ctx_iter", "params": {"reset": true, "count": 10}, "id": 2}`)
+	cnt := len(res["result"].(map[string]interface{})["data"].([]interface{}))
+	for {
+		res = poolRpc(t, tctx, `{"jsonrpc": "2.0", "method":"ctx_iter", "params": {"reset": false, "count": 10}, "id": 3}`)
+		r := res["result"].(map[string]interface{})
+		if r["stopped"].(bool) {
+			break
+		}
+		cnt += len(r["data"].([]interface{}))
+	}
+	if cnt != 32 {
+		t.Fatalf(" ctx_iter returned %d namespaces instead of 32 ", cnt)
+	}
+
+	// get info keeps the order of the request
+	res = poolRpc(t, tctx, `{"jsonrpc": "2.0", "method":"ctx_get_info", "params": {"tunnels": [`+tuns+`]}, "id": 4}`)
+	if len(res["result"].([]interface{})) != 32 {
+		t.Fatalf(" ctx_get_info returned %v ", res["result"])
+	}
+
+	// a request with an existing tunnel is not applied on any worker
+	var bad map[string]interface{}
+	b := tctx.rpc.HandleReq([]byte(`{"jsonrpc": "2.0", "method":"ctx_add", "params": {"tunnels": [` +
+		`{"vport":1,"tci":[33,0]},{"vport":1,"tci":[34,0]},{"vport":1,"tci":[1,0]}]}, "id": 8}`))
+	json.Unmarshal(b, &bad)
+	if bad["error"] == nil || !strings.Contains(fmt.Sprint(bad["error"]), `"tci":[1,0]`) {
+		t.Fatalf(" ctx_add should fail on the existing tunnel %s ", string(b))
+	}
+	if pool.stats.rpcSplitErr != 1 {
+		t.Fatalf(" unexpected pool counters %+v ", pool.stats)
+	}
+	for _, w := range pool.workers {
+		var cnt int
+		tctx := w.Tctx
+		pool.runOn(w, func() { cnt = len(tctx.mapNs) })
+		total -= cnt
+	}
+	if total != 0 {
+		t.Fatalf(" the valid tunnels of a failed ctx_add were added ")
+	}
+
+	// rx packets are dispatched to the worker of the tunnel
+	for i := 1; i <= 32; i++ {
+		pkt := make([]byte, 64)
+		binary.BigEndian.PutUint16(pkt[12:14], 0x8100)
+		binary.BigEndian.PutUint16(pkt[14:16], uint16(i))
+		binary.BigEndian.PutUint16(pkt[16:18], 0x1234) // not supported
+		m := tctx.MPool.Alloc(64)
+		m.SetVPort(1)
+		m.Append(pkt)
+		tctx.HandleRxPacket(m)
+	}
+	pool.flushRx()
+
+	// tx of a worker is sent by the main veth
+	w := pool.workers[0]
+	wtctx := w.Tctx
+	pool.runOn(w, func() {
+		m := wtctx.MPool.Alloc(64)
+		m.SetVPort(1)
+		m.Append(make([]byte, 64))
+		wtctx.Veth.Send(m)
+		wtctx.Veth.FlushTx()
+	})
+	if tctx.Veth.GetStats().TxPkts != 1 {
+		t.Fatalf(" tx packet of the worker was not sent ")
+	}
+	tctx.Veth.FlushTx()
+
+	// counters are the sum of all the workers
+	res = poolRpc(t, tctx, `{"jsonrpc": "2.0", "method":"ctx_cnt", "params": {"mask": ["ctx", "parser", "veth-worker"]}, "id": 5}`)
+	r := res["result"].(map[string]interface{})
+	if r["ctx"].(map[string]interface{})["addNs"].(float64) != 32 {
+		t.Fatalf(" unexpected aggregated counters %v ", r)
+	}
+	if r["parser"].(map[string]interface{})["errL3ProtoUnsupported"].(float64) != 32 {
+		t.Fatalf(" unexpected aggregated counters %v ", r)
+	}
+	if r["veth-worker"].(map[string]interface{})["RxPkts"].(float64) != 32 {
+		t.Fatalf(" unexpected aggregated counters %v ", r)
+	}
+
+	poolRpc(t, tctx, `{"jsonrpc": "2.0", "method":"ctx_remove", "params": {"tunnels": [`+tuns+`]}, "id": 6}`)
+	res = poolRpc(t, tctx, `{"jsonrpc": "2.0", "method":"ctx_iter", "params": {"reset": true, "count": 10}, "id": 7}`)
+	if !res["result"].(map[string]interface{})["empty"].(bool) {
+		t.Fatalf(" namespaces were not removed %v ", res)
+	}
+
+	pool.stopWorkers()
+	tctx.Veth.SimulatorCleanup()
+}
@@ -0,0 +1,501 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package core
+
+/* VXLAN/GRE/PBB encapsulation of namespaces
+
+A namespace can have an outer encapsulation (VXLAN VNI or GRE key with outer MAC/IP, or 802.1ah PBB I-SID with
+backbone MACs), the VNI/key/I-SID is part of its tunnel key. Inside the emulator the packets of such a namespace carry an internal tag instead of the outer
+headers, it looks like a vlan tag with TUNNEL_ENCAP_TPID and the encap id of the namespace. This way the plugins
+see the inner Ethernet header at offset zero and handle the tag the same as a vlan tag.
+
+rx - the parser replaces the outer headers with the internal tag and parses the packet again
+tx - the veth replaces the internal tag with the outer headers, lengths and checksum are updated
+
+The vlan tags and MPLS labels of the tunnel key are the outer ones, the inner packet can't have vlan tags.
+
+*/
+
+import (
+	"bytes"
+	"encoding/binary"
+	"external/google/gopacket/layers"
+	"fmt"
+)
+
+const (
+	TUNNEL_ENCAP_NONE  = 0
+	TUNNEL_ENCAP_VXLAN = 1
+	TUNNEL_ENCAP_GRE   = 2
+	TUNNEL_ENCAP_PBB   = 3
+
+	TUNNEL_ENCAP_TPID = 0xffff // internal tag, never sent to the wire
+	VXLAN_UDP_PORT    = 4789
+	VXLAN_HEADER_SIZE = 8
+	GRE_PROTO_TEB     = 0x6558 // transparent ethernet bridging
+	GRE_FLAG_CS       = 0x8000
+	GRE_FLAG_KEY      = 0x2000
+	GRE_FLAG_SEQ      = 0x1000
+	PBB_ITAG_TYPE     = 0x88e7
+	PBB_ITAG_SIZE     = 4
+)
+
+var tunnelEncapNames = map[string]uint8{"vxlan": TUNNEL_ENCAP_VXLAN, "gre": TUNNEL_ENCAP_GRE, "pbb": TUNNEL_ENCAP_PBB}
+
+/* CTunnelEncapJson json representation of the outer encapsulation of a namespace */
+type CTunnelEncapJson struct {
+	Type    string  `json:"type" validate:"required"` // vxlan, gre or pbb
+	Vni     uint32  `json:"vni"`                      // VXLAN network identifier (24 bits)
+	Key     uint32  `json:"key"`                      // GRE key, zero means no key
+	Isid    uint32  `json:"isid"`                     // PBB service instance identifier (24 bits)
+	SrcMac  MACKey  `json:"src_mac"`                  // outer source MAC (B-SA for PBB)
+	DstMac  MACKey  `json:"dst_mac"`                  // outer destination MAC, the next hop to the remote tunnel end (B-DA for PBB)
+	SrcIpv4 Ipv4Key `json:"src_ipv4"`                 // local tunnel end
+	DstIpv4 Ipv4Key `json:"dst_ipv4"`                 // remote tunnel end
+	SrcIpv6 Ipv6Key `json:"src_ipv6"`                 // in case it is set IPv6 is used as the outer header
+	DstIpv6 Ipv6Key `json:"dst_ipv6"`
+}
+
+// getVni returns the encap type and the VNI/key of the json
+func (o *CTunnelEncapJson) getVni() (uint8, uint32) {
+	t := tunnelEncapNames[o.Type]
+	switch t {
+	case TUNNEL_ENCAP_VXLAN:
+		return t, o.Vni & 0xffffff
+	case TUNNEL_ENCAP_PBB:
+		return t, o.Isid & 0xffffff
+	}
+	return t, o.Key
+}
+
+type TunnelEncapStats struct {
+	encapPkts         uint64
+	decapPkts         uint64
+	errEncapNoNs      uint64
+	errEncapTooBig    uint64
+	errDecapNoNs      uint64
+	errDecapInnerVlan uint64
+	errDecapTooShort  uint64
+	errDecapDstIp     uint64
+}
+
+func newTunnelEncapStatsDb(o *TunnelEncapStats) *CCounterDb {
+	db := NewCCounterDb("encap")
+
+	db.Add(&CCounterRec{
+		Counter:  &o.encapPkts,
+		Name:     "encapPkts",
+		Help:     "tx packets that were encapsulated",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     ScINFO})
+
+	db.Add(&CCounterRec{
+		Counter:  &o.decapPkts,
+		Name:     "decapPkts",
+		Help:     "rx packets that were decapsulated",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     ScINFO})
+
+	db.Add(&CCounterRec{
+		Counter:  &o.errEncapNoNs,
+		Name:     "errEncapNoNs",
+		Help:     "tx packet with an encap tag of a namespace that does not exist",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     ScERROR})
+
+	db.Add(&CCounterRec{
+		Counter:  &o.errEncapTooBig,
+		Name:     "errEncapTooBig",
+		Help:     "tx packet is too big with the outer headers",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     ScERROR})
+
+	db.Add(&CCounterRec{
+		Counter:  &o.errDecapNoNs,
+		Name:     "errDecapNoNs",
+		Help:     "rx packet with an encap tag of a namespace that does not exist",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     ScERROR})
+
+	db.Add(&CCounterRec{
+		Counter:  &o.errDecapInnerVlan,
+		Name:     "errDecapInnerVlan",
+		Help:     "rx inner packet with vlan tags",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     ScERROR})
+
+	db.Add(&CCounterRec{
+		Counter:  &o.errDecapTooShort,
+		Name:     "errDecapTooShort",
+		Help:     "rx inner packet is too short",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     ScERROR})
+
+	db.Add(&CCounterRec{
+		Counter:  &o.errDecapDstIp,
+		Name:     "errDecapDstIp",
+		Help:     "rx outer destination ip is not the local tunnel end",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     ScERROR})
+
+	return db
+}
+
+// tunnelEncap outer encapsulation of one namespace
+type tunnelEncap struct {
+	id   uint16 // the id in the internal tag
+	typ  uint8
+	ipv6 bool
+	l3   uint16 // offset of the outer IP header in hdr
+	l4   uint16 // offset of the UDP/GRE header in hdr
+	hdr  []byte // outer headers template, up to the inner Ethernet header
+	json CTunnelEncapJson
+}
+
+/* SetEncap sets the outer encapsulation of the namespace, should be called before it is added to the thread */
+func (o *CNSCtx) SetEncap(e *CTunnelEncapJson) error {
+	if e == nil {
+		return nil
+	}
+	typ, vni := e.getVni()
+	if typ == TUNNEL_ENCAP_NONE {
+		return fmt.Errorf(" unsupported encap type %s, should be vxlan, gre or pbb ", e.Type)
+	}
+	var d CTunnelData
+	o.Key.Get(&d)
+
+	enc := new(tunnelEncap)
+	enc.typ = typ
+	enc.json = *e
+	enc.ipv6 = !e.SrcIpv6.IsZero()
+	b := []byte{}
+	b = append(b, e.DstMac[:]...)
+	b = append(b, e.SrcMac[:]...)
+	if typ == TUNNEL_ENCAP_PBB {
+		b = d.appendL2(b, PBB_ITAG_TYPE)
+		b = append(b, 0, 0, 0, 0)
+		binary.BigEndian.PutUint32(b[len(b)-4:], vni)
+		enc.hdr = b
+		o.encap = enc
+		return nil
+	}
+	proto := layers.IPProtocolUDP
+	if typ == TUNNEL_ENCAP_GRE {
+		proto = layers.IPProtocolGRE
+	}
+	var ip []byte
+	if enc.ipv6 {
+		b = d.appendL2(b, uint16(layers.EthernetTypeIPv6))
+		ip = PacketUtlBuild(&layers.IPv6{Version: 6, HopLimit: 64, NextHeader: proto,
+			SrcIP: e.SrcIpv6.ToIP(), DstIP: e.DstIpv6.ToIP()})
+	} else {
+		b = d.appendL2(b, uint16(layers.EthernetTypeIPv4))
+		ip = PacketUtlBuild(&layers.IPv4{Version: 4, IHL: 5, TTL: 64, Flags: layers.IPv4DontFragment,
+			Protocol: proto, SrcIP: e.SrcIpv4.ToIP(), DstIP: e.DstIpv4.ToIP()})
+	}
+	enc.l3 = uint16(len(b))
+	b = append(b, ip...)
+	enc.l4 = uint16(len(b))
+	if typ == TUNNEL_ENCAP_VXLAN {
+		var h [8 + VXLAN_HEADER_SIZE]byte
+		binary.BigEndian.PutUint16(h[0:2], 0xc000|uint16(vni&0x3fff)) // source port by the VNI
+		binary.BigEndian.PutUint16(h[2:4], VXLAN_UDP_PORT)
+		h[8] = 0x08 // valid VNI
+		binary.BigEndian.PutUint32(h[12:16], vni<<8)
+		b = append(b, h[:]...)
+	} else {
+		var h [4]byte
+		binary.BigEndian.PutUint16(h[2:4], GRE_PROTO_TEB)
+		if vni != 0 {
+			binary.BigEndian.PutUint16(h[0:2], GRE_FLAG_KEY)
+			b = append(b, h[:]...)
+			b = append(b, 0, 0, 0, 0)
+			binary.BigEndian.PutUint32(b[len(b)-4:], vni)
+		} else {
+			b = append(b, h[:]...)
+		}
+	}
+	enc.hdr = b
+	o.encap = enc
+	return nil
+}
+
+// isLocal returns true in case the destination of the outer IP header at l3 of p is the local tunnel end
+func (o *tunnelEncap) isLocal(p []byte, l3 uint16) bool {
+	src := o.hdr[o.l3:]
+	if o.ipv6 {
+		return isEncapDst(p, l3, src[8:24])
+	}
+	return isEncapDst(p, l3, src[12:16])
+}
+
+// localAddr returns the local tunnel end of the json, 4 bytes for IPv4 and 16 bytes for IPv6
+func (o *CTunnelEncapJson) localAddr() []byte {
+	if !o.SrcIpv6.IsZero() {
+		return append([]byte(nil), o.SrcIpv6[:]...)
+	}
+	return append([]byte(nil), o.SrcIpv4[:]...)
+}
+
+// isEncapDst returns true in case the destination of the outer IP header at l3 of p is addr
+func isEncapDst(p []byte, l3 uint16, addr []byte) bool {
+	if len(addr) == 16 {
+		return (p[l3]>>4) == 6 && bytes.Equal(p[l3+24:l3+40], addr)
+	}
+	return (p[l3]>>4) == 4 && bytes.Equal(p[l3+16:l3+20], addr)
+}
+
+// GetTunnelJson returns the tunnel key of the namespace with the outer headers of its encapsulation
+func (o *CNSCtx) GetTunnelJson(d *CTunnelDataJson) {
+	o.Key.GetJson(d)
+	if o.encap != nil {
+		e := o.encap.json
+		d.Encap = &e
+	}
+}
+
+// fix updates the lengths and checksums of the outer headers of packet p
+func (o *tunnelEncap) fix(p []byte) {
+	if o.typ == TUNNEL_ENCAP_PBB {
+		return
+	}
+	l3 := p[o.l3:]
+	if o.ipv6 {
+		ipv6 := layers.IPv6Header(l3[0:IPV6_HEADER_SIZE])
+		ipv6.SetPyloadLength(uint16(len(l3) - IPV6_HEADER_SIZE))
+	} else {
+		ipv4 := layers.IPv4Header(l3[0:20])
+		ipv4.SetLength(uint16(len(l3)))
+		ipv4.UpdateChecksum()
+	}
+	if o.typ != TUNNEL_ENCAP_VXLAN {
+		return
+	}
+	udp := p[o.l4 : o.l4+8]
+	binary.BigEndian.PutUint16(udp[4:6], uint16(len(p)-int(o.l4)))
+	binary.BigEndian.PutUint16(udp[6:8], 0)
+	if o.ipv6 {
+		// zero checksum is not allowed over IPv6
+		ipv6 := layers.IPv6Header(l3[0:IPV6_HEADER_SIZE])
+		cs := layers.PktChecksumTcpUdpV6(p[o.l4:], 0, ipv6, 0, uint8(layers.IPProtocolUDP))
+		binary.BigEndian.PutUint16(udp[6:8], cs)
+	}
+}
+
+// tunnelEncapTbl the namespaces with encapsulation of a thread by their encap id
+type tunnelEncapTbl struct {
+	tctx   *CThreadCtx
+	ns     map[uint16]*CNSCtx
+	nextId uint16
+	stats  TunnelEncapStats
+	Cdb    *CCounterDb
+}
+
+func (o *tunnelEncapTbl) init(tctx *CThreadCtx) {
+	o.tctx = tctx
+	o.ns = make(map[uint16]*CNSCtx)
+	o.Cdb = newTunnelEncapStatsDb(&o.stats)
+}
+
+func (o *tunnelEncapTbl) add(ns *CNSCtx) error {
+	if len(o.ns) >= 0xffff {
+		return fmt.Errorf(" too many namespaces with encapsulation ")
+	}
+	for {
+		if _, ok := o.ns[o.nextId]; !ok {
+			break
+		}
+		o.nextId++
+	}
+	ns.encap.id = o.nextId
+	o.ns[o.nextId] = ns
+	o.nextId++
+	return nil
+}
+
+func (o *tunnelEncapTbl) remove(ns *CNSCtx) {
+	delete(o.ns, ns.encap.id)
+}
+
+func (o *tunnelEncapTbl) getNs(id uint16) *CNSCtx {
+	return o.ns[id]
+}
+
+/*
+encapTx replaces the internal tag of a tx packet with the outer headers of its namespace.
+returns the mbuf to send or nil in case it was dropped and freed
+*/
+func (o *CThreadCtx) encapTx(m *Mbuf) *Mbuf {
+	tbl := &o.encap
+	if len(tbl.ns) == 0 {
+		return m
+	}
+	p := m.GetData()
+	if len(p) < 18 || binary.BigEndian.Uint16(p[12:14]) != TUNNEL_ENCAP_TPID {
+		return m
+	}
+	ns := tbl.getNs(binary.BigEndian.Uint16(p[14:16]))
+	if ns == nil {
+		tbl.stats.errEncapNoNs++
+		m.FreeMbuf()
+		return nil
+	}
+	if !m.IsContiguous() {
+		m1 := m.GetContiguous(&o.MPool)
+		m.FreeMbuf()
+		m = m1
+		p = m.GetData()
+	}
+	e := ns.encap
+	size := len(e.hdr) + len(p) - 4
+	if size > int(MAX_PACKET_SIZE) {
+		tbl.stats.errEncapTooBig++
+		m.FreeMbuf()
+		return nil
+	}
+	n := o.MPool.Alloc(uint16(size))
+	n.SetVPort(m.VPort())
+	n.Append(e.hdr)
+	n.Append(p[0:12])
+	n.Append(p[16:])
+	m.FreeMbuf()
+	e.fix(n.GetData())
+	tbl.stats.encapPkts++
+	return n
+}
+
+/*
+parseEncapHeader parses the VXLAN/GRE header at offset off of p.
+returns the VNI/key and the offset of the inner Ethernet header
+*/
+func parseEncapHeader(p []byte, typ uint8, off uint16) (uint32, uint16, bool) {
+	if typ == TUNNEL_ENCAP_PBB {
+		if len(p) < int(off)+PBB_ITAG_SIZE {
+			return 0, 0, false
+		}
+		return binary.BigEndian.Uint32(p[off:off+4]) & 0xffffff, off + PBB_ITAG_SIZE, true
+	}
+	if typ == TUNNEL_ENCAP_VXLAN {
+		if len(p) < int(off)+VXLAN_HEADER_SIZE || (p[off]&0x08) == 0 {
+			return 0, 0, false
+		}
+		return binary.BigEndian.Uint32(p[off+4:off+8]) >> 8, off + VXLAN_HEADER_SIZE, true
+	}
+	if len(p) < int(off)+4 {
+		return 0, 0, false
+	}
+	flags := binary.BigEndian.Uint16(p[off : off+2])
+	if (flags&0x7) != 0 || binary.BigEndian.Uint16(p[off+2:off+4]) != GRE_PROTO_TEB {
+		return 0, 0, false // version 0 only
+	}
+	off += 4
+	if (flags & GRE_FLAG_CS) != 0 {
+		off += 4
+	}
+	var key uint32
+	if (flags & GRE_FLAG_KEY) != 0 {
+		if len(p) < int(off)+4 {
+			return 0, 0, false
+		}
+		key = binary.BigEndian.Uint32(p[off : off+4])
+		off += 4
+	}
+	if (flags & GRE_FLAG_SEQ) != 0 {
+		off += 4
+	}
+	if len(p) < int(off) {
+		return 0, 0, false
+	}
+	return key, off, true
+}
+
+// getEncapKey returns the encap type, VNI/key and the offset of the inner Ethernet header of an IP packet at l3
+func getEncapKey(p []byte, l3 uint16, ipv6 bool) (uint8, uint32, uint16, bool) {
+	var proto uint8
+	var l4 uint16
+	if ipv6 {
+		if len(p) < int(l3)+IPV6_HEADER_SIZE {
+			return 0, 0, 0, false
+		}
+		proto = p[l3+6]
+		l4 = l3 + IPV6_HEADER_SIZE
+	} else {
+		if len(p) < int(l3)+20 {
+			return 0, 0, 0, false
+		}
+		ipv4 := layers.IPv4Header(p[l3 : l3+20])
+		if ipv4.IsFragment() {
+			return 0, 0, 0, false
+		}
+		proto = ipv4.GetNextProtocol()
+		l4 = l3 + ipv4.GetHeaderLen()
+	}
+	typ := uint8(TUNNEL_ENCAP_GRE)
+	switch layers.IPProtocol(proto) {
+	case layers.IPProtocolUDP:
+		if len(p) < int(l4)+8 || layers.UDPHeader(p[l4:l4+8]).DstPort() != VXLAN_UDP_PORT {
+			return 0, 0, 0, false
+		}
+		typ = TUNNEL_ENCAP_VXLAN
+		l4 += 8
+	case layers.IPProtocolGRE:
+	default:
+		return 0, 0, 0, false
+	}
+	vni, inner, ok := parseEncapHeader(p, typ, l4)
+	return typ, vni, inner, ok
+}
+
+/*
+decap handles a VXLAN/GRE/PBB packet, the encapsulation header is at off. In case there is a namespace for it,
+the outer headers are replaced by the internal tag and the packet is parsed again.
+returns false in case the packet should be handled as a regular packet
+*/
+func (o *Parser) decap(ps *ParserPacketState, typ uint8, off uint16) (int, bool) {
+	tbl := &o.tctx.encap
+	if len(tbl.ns) == 0 {
+		return 0, false
+	}
+	p := ps.M.GetData()
+	vni, inner, ok := parseEncapHeader(p, typ, off)
+	if !ok {
+		return 0, false
+	}
+	var d CTunnelData
+	var key CTunnelKey
+	ps.Tun.Get(&d)
+	d.Encap = typ
+	d.Vni = vni
+	key.Set(&d)
+	ns := o.tctx.GetNs(&key)
+	if ns == nil || ns.encap == nil {
+		return 0, false
+	}
+	if typ != TUNNEL_ENCAP_PBB && !ns.encap.isLocal(p, ps.L3) {
+		tbl.stats.errDecapDstIp++
+		return 0, false
+	}
+	if len(p) < int(inner)+14 {
+		tbl.stats.errDecapTooShort++
+		return PARSER_ERR, true
+	}
+	// keep the inner MACs, the tag is written instead of the last 4 bytes of the outer headers
+	ps.M.Adj(inner - 4)
+	p = ps.M.GetData()
+	copy(p[0:12], p[4:16])
+	binary.BigEndian.PutUint16(p[12:14], TUNNEL_ENCAP_TPID)
+	binary.BigEndian.PutUint16(p[14:16], ns.encap.id)
+	tbl.stats.decapPkts++
+	return o.ParsePacket(ps.M), true
+}
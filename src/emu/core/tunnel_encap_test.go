@@ -0,0 +1,249 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"external/google/gopacket"
+	"external/google/gopacket/layers"
+	"testing"
+)
+
+var encapTun *CTunnelKey
+
+func encapUdpSupported(ps *ParserPacketState) int {
+	encapTun = ps.Tun
+	return reassUdpSupported(ps)
+}
+
+func TestTunnelEncap(t *testing.T) {
+	encaps := []*CTunnelEncapJson{
+		{Type: "vxlan", Vni: 5000, SrcMac: MACKey{0, 0, 0, 1, 0, 1}, DstMac: MACKey{0, 0, 0, 1, 0, 2},
+			SrcIpv4: Ipv4Key{10, 0, 0, 1}, DstIpv4: Ipv4Key{10, 0, 0, 2}},
+		{Type: "gre", Key: 7, SrcMac: MACKey{0, 0, 0, 1, 0, 1}, DstMac: MACKey{0, 0, 0, 1, 0, 2},
+			SrcIpv6: Ipv6Key{0x20, 0x01, 15: 1}, DstIpv6: Ipv6Key{0x20, 0x01, 15: 2}},
+	}
+	for _, e := range encaps {
+		var simrx VethIFSim = &VethSink{}
+		tctx := NewThreadCtx(0, 4510, true, &simrx)
+		var parser Parser
+		parser.Init(tctx)
+		parser.addProto("udp", &parserProtocol{cb: encapUdpSupported, data: ParserRegisterData{UdpDefault: true}})
+
+		var key CTunnelKey
+		key.SetJson(&CTunnelDataJson{Vport: 1, Tci: [2]uint16{100, 0}, Encap: e})
+		ns := NewNSCtx(tctx, &key)
+		if err := ns.SetEncap(e); err != nil {
+			t.Fatalf(" SetEncap failed %v ", err)
+		}
+		if err := tctx.AddNs(&key, ns); err != nil {
+			t.Fatalf(" AddNs failed %v ", err)
+		}
+
+		// the plugins use the L2 header of the namespace, the internal tag is replaced by the veth
+		pkt, payload := reassBuildUdp(false, 100)
+		l2 := ns.GetL2Header(false, uint16(layers.EthernetTypeIPv4))
+		copy(l2[0:12], pkt[0:12])
+		inner := append(l2, pkt[14:]...)
+		m := tctx.MPool.Alloc(uint16(len(inner)))
+		m.SetVPort(1)
+		m.Append(inner)
+		tctx.Veth.Send(m)
+		veth := tctx.Veth.(*VethIFSimulator)
+		if len(veth.vec) != 1 {
+			t.Fatalf(" expected one packet %s ", e.Type)
+		}
+		out := append([]byte(nil), veth.vec[0].GetData()...)
+		gp := gopacket.NewPacket(out, layers.LayerTypeEthernet, gopacket.Default)
+		if gp.ErrorLayer() != nil {
+			t.Fatalf(" can't decode the outer packet %v ", gp.ErrorLayer().Error())
+		}
+		if d := gp.Layer(layers.LayerTypeDot1Q); d == nil || d.(*layers.Dot1Q).VLANIdentifier != 100 {
+			t.Fatalf(" outer vlan is missing %s ", e.Type)
+		}
+		if e.Type == "vxlan" {
+			v := gp.Layer(layers.LayerTypeVXLAN)
+			if v == nil || v.(*layers.VXLAN).VNI != 5000 {
+				t.Fatalf(" vxlan header is not valid ")
+			}
+			ipv4 := layers.IPv4Header(out[18:38])
+			if !ipv4.IsValidHeaderChecksum() || int(ipv4.GetLength()) != len(out)-18 {
+				t.Fatalf(" outer ipv4 header is not valid ")
+			}
+		} else {
+			g := gp.Layer(layers.LayerTypeGRE)
+			if g == nil || !g.(*layers.GRE).KeyPresent || g.(*layers.GRE).Key != 7 {
+				t.Fatalf(" gre header is not valid ")
+			}
+		}
+		if !bytes.HasSuffix(out, pkt) {
+			t.Fatalf(" inner packet is not as expected %s ", e.Type)
+		}
+
+		var rkey CTunnelKey
+		if !GetPacketTunnelKey(out, 1, &rkey) || rkey != key {
+			t.Fatalf(" tunnel key of the packet is not as expected %v %v ", rkey, key)
+		}
+
+		// the pool routes it by the outer key as the decap of the worker rejects it
+		pool := CThreadPool{encapLocal: map[CTunnelKey][]byte{key: e.localAddr()}}
+		var okey CTunnelKey
+		okey.SetJson(&CTunnelDataJson{Vport: 1, Tci: [2]uint16{100, 0}})
+		if !getPacketTunnelKey(out, 1, &rkey, pool.isEncapLocal) || rkey != okey {
+			t.Fatalf(" pool key of the packet to the remote tunnel end is not as expected %v %v ", rkey, okey)
+		}
+
+		// rx to the remote tunnel end is not decapsulated
+		reassParse(tctx, &parser, out)
+		if tctx.encap.stats.errDecapDstIp != 1 || tctx.encap.stats.decapPkts != 0 {
+			t.Fatalf(" packet to the remote tunnel end should not be decapsulated %s ", e.Type)
+		}
+		if e.SrcIpv6.IsZero() {
+			layers.IPv4Header(out[18:38]).SwapSrcDst()
+		} else {
+			layers.IPv6Header(out[18:58]).SwapSrcDst()
+		}
+
+		if !getPacketTunnelKey(out, 1, &rkey, pool.isEncapLocal) || rkey != key {
+			t.Fatalf(" pool key of the packet is not as expected %v %v ", rkey, key)
+		}
+
+		// rx, the plugin gets the inner packet with the internal tag
+		reassL7 = nil
+		if r := reassParse(tctx, &parser, out); r != 0 {
+			t.Fatalf(" parser error %d %s ", r, e.Type)
+		}
+		if !bytes.Equal(reassL7, payload) || *encapTun != key || tctx.encap.stats.decapPkts != 1 {
+			t.Fatalf(" decapsulated packet is not as expected %s ", e.Type)
+		}
+
+		// no namespace for this VNI, regular packet
+		binary.BigEndian.PutUint32(out[len(out)-len(pkt)-4:], 0)
+		if e.Type == "vxlan" {
+			reassL7 = nil
+			reassParse(tctx, &parser, out)
+			if reassL7 == nil || tctx.encap.stats.decapPkts != 1 {
+				t.Fatalf(" packet should be handled as udp ")
+			}
+		}
+
+		// the json of the namespace has the outer headers
+		var d CTunnelDataJson
+		ns.GetTunnelJson(&d)
+		if d.Encap == nil || *d.Encap != *e {
+			t.Fatalf(" tunnel json is not as expected %+v ", d.Encap)
+		}
+
+		tctx.RemoveNs(&key)
+		if len(tctx.encap.ns) != 0 {
+			t.Fatalf(" encap table should be empty ")
+		}
+		tctx.Veth.SimulatorCleanup()
+	}
+}
+
+func TestTunnelPbb(t *testing.T) {
+	var simrx VethIFSim = &VethSink{}
+	tctx := NewThreadCtx(0, 4510, true, &simrx)
+	var parser Parser
+	parser.Init(tctx)
+	parser.addProto("udp", &parserProtocol{cb: encapUdpSupported, data: ParserRegisterData{UdpDefault: true}})
+
+	e := &CTunnelEncapJson{Type: "pbb", Isid: 0x123456, SrcMac: MACKey{0, 0, 0, 1, 0, 1}, DstMac: MACKey{0, 0, 0, 1, 0, 2}}
+	var key CTunnelKey
+	key.SetJson(&CTunnelDataJson{Vport: 1, Tpid: [2]uint16{0x88a8, 0}, Tci: [2]uint16{100, 0}, Encap: e})
+	ns := NewNSCtx(tctx, &key)
+	ns.SetEncap(e)
+	tctx.AddNs(&key, ns)
+
+	pkt, payload := reassBuildUdp(false, 100)
+	l2 := ns.GetL2Header(false, uint16(layers.EthernetTypeIPv4))
+	copy(l2[0:12], pkt[0:12])
+	m := tctx.MPool.Alloc(uint16(len(l2) + len(pkt)))
+	m.SetVPort(1)
+	m.Append(l2)
+	m.Append(pkt[14:])
+	tctx.Veth.Send(m)
+	veth := tctx.Veth.(*VethIFSimulator)
+	out := append([]byte(nil), veth.vec[0].GetData()...)
+	exp := []byte{0, 0, 0, 1, 0, 2, 0, 0, 0, 1, 0, 1, 0x88, 0xa8, 0, 100, 0x88, 0xe7, 0, 0x12, 0x34, 0x56}
+	if !bytes.HasPrefix(out, exp) || !bytes.Equal(out[len(exp):], pkt) {
+		t.Fatalf(" pbb packet is not as expected ")
+	}
+
+	var rkey CTunnelKey
+	if !GetPacketTunnelKey(out, 1, &rkey) || rkey != key {
+		t.Fatalf(" tunnel key of the packet is not as expected %v %v ", rkey, key)
+	}
+	reassL7 = nil
+	if r := reassParse(tctx, &parser, out); r != 0 || !bytes.Equal(reassL7, payload) || *encapTun != key {
+		t.Fatalf(" decapsulated packet is not as expected ")
+	}
+	var d CTunnelDataJson
+	key.GetJson(&d)
+	if d.Encap == nil || d.Encap.Type != "pbb" || d.Encap.Isid != 0x123456 {
+		t.Fatalf(" json of the key is not as expected %+v ", d.Encap)
+	}
+	tctx.Veth.SimulatorCleanup()
+}
+
+func TestTunnelMpls(t *testing.T) {
+	tctx := NewThreadCtx(0, 4510, false, nil)
+	var parser Parser
+	parser.Init(tctx)
+	parser.addProto("udp", &parserProtocol{cb: encapUdpSupported, data: ParserRegisterData{UdpDefault: true}})
+
+	var key CTunnelKey
+	key.SetJson(&CTunnelDataJson{Vport: 1, Tci: [2]uint16{100, 0}, Mpls: []uint32{16, 0xfffff}})
+	ns := NewNSCtx(tctx, &key)
+	tctx.AddNs(&key, ns)
+
+	l2 := ns.GetL2Header(false, uint16(layers.EthernetTypeIPv4))
+	exp := []byte{0x81, 0, 0, 100, 0x88, 0x47, 0, 1, 0, 64, 0xff, 0xff, 0xf1, 64}
+	if !bytes.Equal(l2[12:], exp) {
+		t.Fatalf(" mpls header is not as expected % x ", l2[12:])
+	}
+	// labels are for every ether type, ARP is identified by its header
+	arp := ns.GetL2Header(true, uint16(layers.EthernetTypeARP))
+	if !bytes.Equal(arp[12:], exp) {
+		t.Fatalf(" arp header should have labels % x ", arp[12:])
+	}
+	arp = append(arp, 0, 1, 8, 0, 6, 4, 0, 1)
+	arp = append(arp, make([]byte, 20)...)
+	var akey CTunnelKey
+	if !GetPacketTunnelKey(arp, 1, &akey) || akey != key {
+		t.Fatalf(" tunnel key of the arp packet is not as expected %v %v ", akey, key)
+	}
+
+	var bad CTunnelKey
+	if err := bad.SetJson(&CTunnelDataJson{Vport: 1, Mpls: []uint32{0x100000}}); err == nil {
+		t.Fatalf(" out of range label should be rejected ")
+	}
+
+	pkt, payload := reassBuildUdp(false, 100)
+	pkt = append(append(pkt[:12:12], exp...), pkt[14:]...)
+	var rkey CTunnelKey
+	if !GetPacketTunnelKey(pkt, 1, &rkey) || rkey != key {
+		t.Fatalf(" tunnel key of the packet is not as expected %v %v ", rkey, key)
+	}
+	reassL7 = nil
+	if r := reassParse(tctx, &parser, pkt); r != 0 || !bytes.Equal(reassL7, payload) || *encapTun != key {
+		t.Fatalf(" mpls packet is not as expected ")
+	}
+
+	info := ns.GetInfo()
+	if len(info.Mpls) != 2 || info.Mpls[0] != 16 || info.Mpls[1] != 0xfffff {
+		t.Fatalf(" ns info is not as expected %+v ", info)
+	}
+
+	// bottom of stack is missing
+	labels := bytes.Repeat([]byte{0, 1, 0, 64}, MAX_MPLS_LABELS)
+	pkt = append(append(pkt[:18:18], labels...), pkt[26:]...)
+	if r := reassParse(tctx, &parser, pkt); r != PARSER_ERR || parser.stats.errToManyMpls != 1 {
+		t.Fatalf(" too many labels should be dropped ")
+	}
+}
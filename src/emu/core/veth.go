@@ -12,15 +12,20 @@ import (
 )
 
 type VethStats struct {
-	TxPkts           uint64
-	TxBytes          uint64
-	RxPkts           uint64
-	RxBytes          uint64
-	RxParseErr       uint64
-	RxZmqErr         uint64
-	RxBatch          uint64
-	TxBatch          uint64
-	TxDropNotResolve uint64 /* no resolved dg */
+	TxPkts            uint64
+	TxBytes           uint64
+	RxPkts            uint64
+	RxBytes           uint64
+	RxParseErr        uint64
+	RxZmqErr          uint64
+	RxBatch           uint64
+	TxBatch           uint64
+	TxDropNotResolve  uint64 /* no resolved dg */
+	RxSocketErr       uint64 /* AF_PACKET socket errors */
+	TxSocketErr       uint64
+	RxDropTooBig      uint64 /* packet is bigger than the max mbuf */
+	TxDropInvalidPort uint64 /* vport is not bound to an interface */
+	RxSkipLinkType    uint64 /* replay packet of a non Ethernet interface */
 
 }
 
@@ -99,6 +104,46 @@ func NewVethStatsDb(o *VethStats) *CCounterDb {
 		DumpZero: false,
 		Info:     ScERROR})
 
+	db.Add(&CCounterRec{
+		Counter:  &o.RxSocketErr,
+		Name:     "RxSocketErr",
+		Help:     "RxSocketErr",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     ScERROR})
+
+	db.Add(&CCounterRec{
+		Counter:  &o.TxSocketErr,
+		Name:     "TxSocketErr",
+		Help:     "TxSocketErr",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     ScERROR})
+
+	db.Add(&CCounterRec{
+		Counter:  &o.RxDropTooBig,
+		Name:     "RxDropTooBig",
+		Help:     "RxDropTooBig",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     ScERROR})
+
+	db.Add(&CCounterRec{
+		Counter:  &o.RxSkipLinkType,
+		Name:     "RxSkipLinkType",
+		Help:     "RxSkipLinkType",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     ScERROR})
+
+	db.Add(&CCounterRec{
+		Counter:  &o.TxDropInvalidPort,
+		Name:     "TxDropInvalidPort",
+		Help:     "TxDropInvalidPort",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     ScERROR})
+
 	return db
 }
 
@@ -191,7 +236,9 @@ func (o *VethIFSimulator) AppendSimuationRPC(request []byte) {
 }
 
 func (o *VethIFSimulator) Send(m *Mbuf) {
-
+	if m = o.tctx.encapTx(m); m == nil {
+		return
+	}
 	o.stats.TxPkts++
 	o.stats.TxBytes += uint64(m.PktLen())
 	if !m.IsContiguous() {
@@ -0,0 +1,543 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+//go:build linux
+// +build linux
+
+package core
+
+/* AF_PACKET veth
+
+Native Linux veth, each vport is bound to one interface (vport = index in the interface list).
+RX uses a TPACKET_V3 ring (mmap) when possible, a block of the ring is converted to one rx message.
+In case the ring can't be created (old kernel) it falls back to recvmsg, one packet per message.
+The kernel strips the vlan tag, it is taken from the ring header or from PACKET_AUXDATA and inserted back.
+The rx thread updates the stats with atomics as the main loop reads them.
+TX is a simple write per packet, called from FlushTx.
+
+rx message format (internal, from the rx thread to the main loop)
+
+each packet is like this
+
+uint16 vport
+uint16 pkt_size
+pkt_size bytes
+
+*/
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const (
+	AF_PACKET_TX_PKT_BUTST_SIZE = 64
+	AF_PACKET_RING_BLOCK_SIZE   = 1 << 20 // 1MB per block
+	AF_PACKET_RING_BLOCK_NR     = 8
+	AF_PACKET_RING_FRAME_SIZE   = 2048
+	AF_PACKET_RING_BLOCK_TMO    = 1 // msec, retire a partial block
+	AF_PACKET_RX_BUF_SIZE       = 16 * 1024
+)
+
+/* linux/if_packet.h, not all of them are exported by syscall */
+const (
+	lSOL_PACKET                = 263
+	lPACKET_VERSION            = 10
+	lTPACKET_V3                = 2
+	lPACKET_AUXDATA            = 8
+	lTP_STATUS_KERNEL          = 0
+	lTP_STATUS_USER            = 1 << 0
+	lTP_STATUS_VLAN_VALID      = 1 << 4
+	lTP_STATUS_VLAN_TPID_VALID = 1 << 6
+	lTPACKET3_HDRLEN           = 48 // TPACKET_ALIGN(sizeof(struct tpacket3_hdr))
+	lSLL_PKTTYPE_OFFSET        = 10 // offset of sll_pkttype in struct sockaddr_ll
+	lTPACKET_AUXDATA_LEN       = 20 // sizeof(struct tpacket_auxdata)
+)
+
+/* struct tpacket_req3 */
+type tpacketReq3 struct {
+	blockSize      uint32
+	blockNr        uint32
+	frameSize      uint32
+	frameNr        uint32
+	retireBlkTov   uint32
+	sizeofPriv     uint32
+	featureReqWord uint32
+}
+
+/* struct packet_mreq */
+type packetMreq struct {
+	ifindex int32
+	mrType  uint16
+	alen    uint16
+	address [8]byte
+}
+
+// vethRawIf one bounded interface
+type vethRawIf struct {
+	name    string
+	ifindex int
+	vport   uint16
+	fd      int
+	epfd    int
+	ring    []byte // mmap TPACKET_V3 ring, nil in case of recvfrom mode
+	req     tpacketReq3
+	block   uint32 // next block to read
+	rxbuf   []byte // recvmsg mode
+	oob     []byte // recvmsg mode, PACKET_AUXDATA
+	veth    *VethIFRaw
+}
+
+// htons convert the protocol to network order as AF_PACKET expects
+func htons(v uint16) uint16 {
+	return (v << 8) | (v >> 8)
+}
+
+func setsockopt(fd, level, name int, v unsafe.Pointer, l uintptr) error {
+	_, _, e := syscall.Syscall6(syscall.SYS_SETSOCKOPT, uintptr(fd), uintptr(level), uintptr(name), uintptr(v), l, 0)
+	if e != 0 {
+		return e
+	}
+	return nil
+}
+
+// socket opens the AF_PACKET socket in promiscuous mode
+func (o *vethRawIf) socket() error {
+	var err error
+	o.fd, err = syscall.Socket(syscall.AF_PACKET, syscall.SOCK_RAW, int(htons(syscall.ETH_P_ALL)))
+	if err != nil {
+		return fmt.Errorf("can't open AF_PACKET socket on %s, %v", o.name, err)
+	}
+
+	mreq := packetMreq{ifindex: int32(o.ifindex), mrType: syscall.PACKET_MR_PROMISC}
+	err = setsockopt(o.fd, lSOL_PACKET, syscall.PACKET_ADD_MEMBERSHIP, unsafe.Pointer(&mreq), unsafe.Sizeof(mreq))
+	if err != nil {
+		syscall.Close(o.fd)
+		return fmt.Errorf("can't set %s to promiscuous mode, %v", o.name, err)
+	}
+	return nil
+}
+
+func (o *vethRawIf) open(ring bool) error {
+	intf, err := net.InterfaceByName(o.name)
+	if err != nil {
+		return err
+	}
+	o.ifindex = intf.Index
+
+	if err = o.socket(); err != nil {
+		return err
+	}
+
+	if ring {
+		if err = o.openRing(); err != nil {
+			// the version/ring could be set already, recvmsg needs a fresh socket
+			syscall.Close(o.fd)
+			if err = o.socket(); err != nil {
+				return err
+			}
+		}
+	}
+	if o.ring == nil {
+		v := int32(1)
+		err = setsockopt(o.fd, lSOL_PACKET, lPACKET_AUXDATA, unsafe.Pointer(&v), unsafe.Sizeof(v))
+		if err != nil {
+			syscall.Close(o.fd)
+			return fmt.Errorf("can't set PACKET_AUXDATA on %s, %v", o.name, err)
+		}
+		o.rxbuf = make([]byte, AF_PACKET_RX_BUF_SIZE)
+		o.oob = make([]byte, syscall.CmsgSpace(lTPACKET_AUXDATA_LEN))
+	}
+
+	sll := syscall.SockaddrLinklayer{Protocol: htons(syscall.ETH_P_ALL), Ifindex: o.ifindex}
+	err = syscall.Bind(o.fd, &sll)
+	if err != nil {
+		o.close()
+		return fmt.Errorf("can't bind to %s, %v", o.name, err)
+	}
+	return nil
+}
+
+// openRing maps a TPACKET_V3 ring, in case of an error the ring and the epoll are released
+func (o *vethRawIf) openRing() error {
+	v := int32(lTPACKET_V3)
+	err := setsockopt(o.fd, lSOL_PACKET, lPACKET_VERSION, unsafe.Pointer(&v), unsafe.Sizeof(v))
+	if err != nil {
+		return err
+	}
+	o.req = tpacketReq3{
+		blockSize:    AF_PACKET_RING_BLOCK_SIZE,
+		blockNr:      AF_PACKET_RING_BLOCK_NR,
+		frameSize:    AF_PACKET_RING_FRAME_SIZE,
+		frameNr:      (AF_PACKET_RING_BLOCK_SIZE / AF_PACKET_RING_FRAME_SIZE) * AF_PACKET_RING_BLOCK_NR,
+		retireBlkTov: AF_PACKET_RING_BLOCK_TMO,
+	}
+	err = setsockopt(o.fd, lSOL_PACKET, syscall.PACKET_RX_RING, unsafe.Pointer(&o.req), unsafe.Sizeof(o.req))
+	if err != nil {
+		return err
+	}
+	ring, err := syscall.Mmap(o.fd, 0, int(o.req.blockSize*o.req.blockNr),
+		syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED|syscall.MAP_LOCKED)
+	if err != nil {
+		return err
+	}
+	o.epfd, err = syscall.EpollCreate1(0)
+	if err != nil {
+		syscall.Munmap(ring)
+		return err
+	}
+	ev := syscall.EpollEvent{Events: syscall.EPOLLIN | syscall.EPOLLERR, Fd: int32(o.fd)}
+	if err = syscall.EpollCtl(o.epfd, syscall.EPOLL_CTL_ADD, o.fd, &ev); err != nil {
+		syscall.Munmap(ring)
+		syscall.Close(o.epfd)
+		return err
+	}
+	o.ring = ring
+	return nil
+}
+
+func (o *vethRawIf) close() {
+	if o.ring != nil {
+		syscall.Munmap(o.ring)
+		syscall.Close(o.epfd)
+		o.ring = nil
+	}
+	syscall.Close(o.fd)
+}
+
+// appendPkt appends a packet to the rx message, a stripped vlan tag (status of the ring/auxdata) is inserted
+// back so the parser can classify the namespace. packets bigger than the max mbuf are dropped.
+func (o *vethRawIf) appendPkt(msg []byte, pkt []byte, status uint32, tci, tpid uint16) []byte {
+	var h [4]byte
+	var tag [4]byte
+	vlan := (status & lTP_STATUS_VLAN_VALID) != 0
+	size := len(pkt)
+	if vlan {
+		size += 4
+	}
+	if size > int(MAX_PACKET_SIZE) {
+		atomic.AddUint64(&o.veth.stats.RxDropTooBig, 1)
+		return msg
+	}
+	binary.BigEndian.PutUint16(h[0:2], o.vport)
+	binary.BigEndian.PutUint16(h[2:4], uint16(size))
+	msg = append(msg, h[:]...)
+	if !vlan {
+		return append(msg, pkt...)
+	}
+	if (status & lTP_STATUS_VLAN_TPID_VALID) == 0 {
+		tpid = DEF_TPID
+	}
+	binary.BigEndian.PutUint16(tag[0:2], tpid)
+	binary.BigEndian.PutUint16(tag[2:4], tci)
+	msg = append(msg, pkt[0:12]...)
+	msg = append(msg, tag[:]...)
+	return append(msg, pkt[12:]...)
+}
+
+// parseBlock converts a TPACKET_V3 block to an rx message. packets that were sent by us are skipped.
+func (o *vethRawIf) parseBlock(block []byte) []byte {
+	numPkts := binary.LittleEndian.Uint32(block[12:16])
+	of := binary.LittleEndian.Uint32(block[16:20])
+	msg := make([]byte, 0, binary.LittleEndian.Uint32(block[20:24]))
+
+	for i := uint32(0); i < numPkts; i++ {
+		hdr := block[of:]
+		next := binary.LittleEndian.Uint32(hdr[0:4])
+		snaplen := binary.LittleEndian.Uint32(hdr[12:16])
+		status := binary.LittleEndian.Uint32(hdr[20:24])
+		mac := uint32(binary.LittleEndian.Uint16(hdr[24:26]))
+		pkttype := hdr[lTPACKET3_HDRLEN+lSLL_PKTTYPE_OFFSET]
+
+		if pkttype != syscall.PACKET_OUTGOING && snaplen >= 14 {
+			tci := uint16(binary.LittleEndian.Uint32(hdr[32:36]))
+			tpid := binary.LittleEndian.Uint16(hdr[36:38])
+			msg = o.appendPkt(msg, hdr[mac:mac+snaplen], status, tci, tpid)
+		}
+		if next == 0 {
+			break
+		}
+		of += next
+	}
+	return msg
+}
+
+// recvToMsg converts a packet of recvmsg to an rx message, the vlan tag is taken from PACKET_AUXDATA
+func (o *vethRawIf) recvToMsg(pkt []byte, oob []byte) []byte {
+	var status uint32
+	var tci, tpid uint16
+	cmsgs, err := syscall.ParseSocketControlMessage(oob)
+	if err == nil {
+		for _, c := range cmsgs {
+			if c.Header.Level == lSOL_PACKET && c.Header.Type == lPACKET_AUXDATA && len(c.Data) >= lTPACKET_AUXDATA_LEN {
+				/* struct tpacket_auxdata */
+				status = binary.LittleEndian.Uint32(c.Data[0:4])
+				tci = binary.LittleEndian.Uint16(c.Data[16:18])
+				tpid = binary.LittleEndian.Uint16(c.Data[18:20])
+			}
+		}
+	}
+	return o.appendPkt(make([]byte, 0, len(pkt)+8), pkt, status, tci, tpid)
+}
+
+func (o *vethRawIf) rxThreadRing() {
+	events := make([]syscall.EpollEvent, 1)
+	for {
+		block := o.ring[o.block*o.req.blockSize : (o.block+1)*o.req.blockSize]
+		status := (*uint32)(unsafe.Pointer(&block[8]))
+		if (atomic.LoadUint32(status) & lTP_STATUS_USER) == 0 {
+			_, err := syscall.EpollWait(o.epfd, events, 100)
+			if err != nil && err != syscall.EINTR {
+				atomic.AddUint64(&o.veth.stats.RxSocketErr, 1)
+				time.Sleep(10 * time.Millisecond)
+			}
+			continue
+		}
+		msg := o.parseBlock(block)
+		atomic.StoreUint32(status, lTP_STATUS_KERNEL) // give the block back to the kernel
+		o.block = (o.block + 1) % o.req.blockNr
+		if len(msg) > 0 {
+			o.veth.cn <- msg
+		}
+	}
+}
+
+func (o *vethRawIf) rxThreadRecv() {
+	for {
+		n, oobn, flags, from, err := syscall.Recvmsg(o.fd, o.rxbuf, o.oob, 0)
+		if err != nil {
+			if err != syscall.EINTR {
+				atomic.AddUint64(&o.veth.stats.RxSocketErr, 1)
+				time.Sleep(10 * time.Millisecond)
+			}
+			continue
+		}
+		if sll, ok := from.(*syscall.SockaddrLinklayer); ok && sll.Pkttype == syscall.PACKET_OUTGOING {
+			continue
+		}
+		if (flags & syscall.MSG_TRUNC) != 0 {
+			// bigger than the rx buffer
+			atomic.AddUint64(&o.veth.stats.RxDropTooBig, 1)
+			continue
+		}
+		if n < 14 {
+			continue
+		}
+		msg := o.recvToMsg(o.rxbuf[:n], o.oob[:oobn])
+		if len(msg) > 0 {
+			o.veth.cn <- msg
+		}
+	}
+}
+
+// VethIFRaw veth on top of Linux AF_PACKET sockets, works without TRex server
+type VethIFRaw struct {
+	ifs         []*vethRawIf
+	cn          chan []byte
+	vec         []*Mbuf
+	stats       VethStats
+	tctx        *CThreadCtx
+	K12Monitor  bool     // K12 packet monitoring to monitorDest
+	monitorFile *os.File // File to print the K12 packet captured. Default is stdout.
+	cdb         *CCounterDb
+}
+
+// Create opens a socket per interface, vport is the index of the interface in the list.
+// ring enables the TPACKET_V3 rx ring.
+func (o *VethIFRaw) Create(ctx *CThreadCtx, ifaces []string, ring bool) error {
+	if len(ifaces) == 0 {
+		return fmt.Errorf("at least one interface should be provided")
+	}
+	o.tctx = ctx
+	o.cn = make(chan []byte)
+	o.vec = make([]*Mbuf, 0)
+	o.cdb = NewVethStatsDb(&o.stats)
+	o.ifs = make([]*vethRawIf, 0, len(ifaces))
+	for i, name := range ifaces {
+		intf := &vethRawIf{name: name, vport: uint16(i), veth: o}
+		if err := intf.open(ring); err != nil {
+			o.closeAll()
+			return err
+		}
+		o.ifs = append(o.ifs, intf)
+	}
+	return nil
+}
+
+func (o *VethIFRaw) closeAll() {
+	for _, intf := range o.ifs {
+		intf.close()
+	}
+	o.ifs = o.ifs[:0]
+}
+
+// IsRing returns true if the interface of the vport uses the mmap ring
+func (o *VethIFRaw) IsRing(vport uint16) bool {
+	if int(vport) >= len(o.ifs) {
+		return false
+	}
+	return o.ifs[vport].ring != nil
+}
+
+func (o *VethIFRaw) StartRxThread() {
+	for _, intf := range o.ifs {
+		if intf.ring != nil {
+			go intf.rxThreadRing()
+		} else {
+			go intf.rxThreadRecv()
+		}
+	}
+}
+
+func (o *VethIFRaw) GetC() chan []byte {
+	return o.cn
+}
+
+func (o *VethIFRaw) FlushTx() {
+	if len(o.vec) == 0 {
+		return
+	}
+	o.stats.TxBatch++
+	for _, m := range o.vec {
+		if !m.IsContiguous() {
+			panic(" mbuf should be contiguous  ")
+		}
+		if o.K12Monitor {
+			io.WriteString(o.monitorFile, "\n ->TX<- \n")
+			m.DumpK12(o.tctx.GetTickSimInSec(), o.monitorFile)
+		}
+		vport := m.VPort()
+		if int(vport) < len(o.ifs) {
+			_, err := syscall.Write(o.ifs[vport].fd, m.GetData())
+			if err != nil {
+				o.stats.TxSocketErr++
+			}
+		} else {
+			o.stats.TxDropInvalidPort++
+		}
+		m.FreeMbuf()
+	}
+	o.vec = o.vec[:0]
+}
+
+func (o *VethIFRaw) Send(m *Mbuf) {
+	if m = o.tctx.encapTx(m); m == nil {
+		return
+	}
+	o.stats.TxPkts++
+	o.stats.TxBytes += uint64(m.PktLen())
+
+	if !m.IsContiguous() {
+		m1 := m.GetContiguous(&o.tctx.MPool)
+		m.FreeMbuf()
+		o.vec = append(o.vec, m1)
+	} else {
+		o.vec = append(o.vec, m)
+	}
+	if len(o.vec) == AF_PACKET_TX_PKT_BUTST_SIZE {
+		o.FlushTx()
+	}
+}
+
+// SendBuffer get a buffer as input, should allocate mbuf and call send
+func (o *VethIFRaw) SendBuffer(unicast bool, c *CClient, b []byte) {
+	var vport uint16
+	vport = c.Ns.GetVport()
+	m := o.tctx.MPool.Alloc(uint16(len(b)))
+	m.SetVPort(vport)
+	m.Append(b)
+	if unicast {
+		if c.DGW == nil {
+			m.FreeMbuf()
+			o.stats.TxDropNotResolve++
+			return
+		}
+		if !c.DGW.IpdgResolved {
+			m.FreeMbuf()
+			o.stats.TxDropNotResolve++
+			return
+		}
+		p := m.GetData()
+		copy(p[6:12], c.Mac[:])
+		copy(p[0:6], c.DGW.IpdgMac[:])
+	}
+	o.Send(m)
+}
+
+// get the packet
+func (o *VethIFRaw) OnRx(m *Mbuf) {
+	o.stats.RxPkts++
+	o.stats.RxBytes += uint64(m.PktLen())
+	if o.K12Monitor {
+		io.WriteString(o.monitorFile, "\n ->RX<- \n")
+		m.DumpK12(o.tctx.GetTickSimInSec(), o.monitorFile)
+	}
+	o.tctx.HandleRxPacket(m)
+}
+
+func (o *VethIFRaw) OnRxStream(stream []byte) {
+	o.stats.RxBatch++
+	blen := uint32(len(stream))
+	var of uint32
+	for of < blen {
+		if blen < of+4 {
+			o.stats.RxParseErr++
+			return
+		}
+		vport := binary.BigEndian.Uint16(stream[of : of+2])
+		pktLen := uint32(binary.BigEndian.Uint16(stream[of+2 : of+4]))
+		if blen < of+4+pktLen {
+			o.stats.RxParseErr++
+			return
+		}
+		if pktLen > uint32(MAX_PACKET_SIZE) {
+			atomic.AddUint64(&o.stats.RxDropTooBig, 1)
+			of = of + 4 + pktLen
+			continue
+		}
+		m := o.tctx.MPool.Alloc(uint16(pktLen))
+		m.SetVPort(vport)
+		m.Append(stream[of+4 : of+4+pktLen])
+		o.OnRx(m)
+		of = of + 4 + pktLen
+	}
+}
+
+/* get the veth stats */
+func (o *VethIFRaw) GetStats() *VethStats {
+	return &o.stats
+}
+
+func (o *VethIFRaw) SimulatorCheckRxQueue() {
+
+}
+
+func (o *VethIFRaw) SimulatorCleanup() {
+	for _, m := range o.vec {
+		m.FreeMbuf()
+	}
+	o.vec = nil
+	o.closeAll()
+}
+
+func (o *VethIFRaw) SetDebug(monitor bool, monitorFile *os.File, capture bool) {
+	o.K12Monitor = monitor
+	o.monitorFile = monitorFile
+}
+
+func (o *VethIFRaw) GetCdb() *CCounterDb {
+	return o.cdb
+}
+
+func (o *VethIFRaw) AppendSimuationRPC(request []byte) {
+	panic("AppendSimuationRPC should not be called ")
+}
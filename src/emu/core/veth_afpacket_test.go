@@ -0,0 +1,131 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+//go:build linux
+// +build linux
+
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"syscall"
+	"testing"
+	"unsafe"
+)
+
+// addTpacket3Frame appends a tpacket3_hdr + sockaddr_ll + packet at offset of and returns the next offset
+func addTpacket3Frame(block []byte, of uint32, pkt []byte, status uint32, tci uint16, pkttype uint8, last bool) uint32 {
+	const mac = 80
+	hdr := block[of:]
+	next := uint32(0)
+	if !last {
+		next = (mac + uint32(len(pkt)) + 15) &^ 15
+	}
+	binary.LittleEndian.PutUint32(hdr[0:4], next)
+	binary.LittleEndian.PutUint32(hdr[12:16], uint32(len(pkt)))
+	binary.LittleEndian.PutUint32(hdr[16:20], uint32(len(pkt)))
+	binary.LittleEndian.PutUint32(hdr[20:24], status)
+	binary.LittleEndian.PutUint16(hdr[24:26], mac)
+	binary.LittleEndian.PutUint32(hdr[32:36], uint32(tci))
+	hdr[lTPACKET3_HDRLEN+lSLL_PKTTYPE_OFFSET] = pkttype
+	copy(hdr[mac:], pkt)
+	return of + next
+}
+
+func TestAfPacketParseBlock(t *testing.T) {
+	var veth VethIFRaw
+	intf := vethRawIf{vport: 3, veth: &veth}
+
+	pkt1 := make([]byte, 60)
+	pkt2 := make([]byte, 64)
+	for i := range pkt1 {
+		pkt1[i] = byte(i)
+	}
+	for i := range pkt2 {
+		pkt2[i] = byte(0x80 + i)
+	}
+	binary.BigEndian.PutUint16(pkt1[12:14], 0x0806)
+	binary.BigEndian.PutUint16(pkt2[12:14], 0x0800)
+
+	block := make([]byte, 4096)
+	binary.LittleEndian.PutUint32(block[12:16], 3)   // num_pkts
+	binary.LittleEndian.PutUint32(block[16:20], 48)  // offset_to_first_pkt
+	binary.LittleEndian.PutUint32(block[20:24], 512) // blk_len
+	of := addTpacket3Frame(block, 48, pkt1, lTP_STATUS_USER, 0, syscall.PACKET_HOST, false)
+	of = addTpacket3Frame(block, of, pkt2, lTP_STATUS_USER, 0, syscall.PACKET_OUTGOING, false)
+	addTpacket3Frame(block, of, pkt2, lTP_STATUS_USER|lTP_STATUS_VLAN_VALID, 7, syscall.PACKET_HOST, true)
+
+	msg := intf.parseBlock(block)
+
+	// first packet as is, second is ours and skipped, third with vlan tag inserted back
+	var exp []byte
+	exp = append(exp, 0, 3, 0, 60)
+	exp = append(exp, pkt1...)
+	exp = append(exp, 0, 3, 0, 68)
+	exp = append(exp, pkt2[0:12]...)
+	exp = append(exp, 0x81, 0x00, 0x00, 0x07)
+	exp = append(exp, pkt2[12:]...)
+
+	if !bytes.Equal(msg, exp) {
+		t.Fatalf(" parseBlock is not as expected \n%v\n%v\n", msg, exp)
+	}
+}
+
+func TestAfPacketParseBlockTooBig(t *testing.T) {
+	var veth VethIFRaw
+	intf := vethRawIf{vport: 1, veth: &veth}
+
+	pkt := make([]byte, int(MAX_PACKET_SIZE)+1)
+	binary.BigEndian.PutUint16(pkt[12:14], 0x0800)
+	block := make([]byte, len(pkt)+1024)
+	binary.LittleEndian.PutUint32(block[12:16], 1)
+	binary.LittleEndian.PutUint32(block[16:20], 48)
+	binary.LittleEndian.PutUint32(block[20:24], uint32(len(block)))
+	addTpacket3Frame(block, 48, pkt, lTP_STATUS_USER, 0, syscall.PACKET_HOST, true)
+
+	if msg := intf.parseBlock(block); len(msg) != 0 {
+		t.Fatalf(" a packet bigger than the max mbuf should be dropped")
+	}
+	if veth.stats.RxDropTooBig != 1 {
+		t.Fatalf(" RxDropTooBig should be 1 not %v", veth.stats.RxDropTooBig)
+	}
+}
+
+func TestAfPacketRecvAuxdata(t *testing.T) {
+	var veth VethIFRaw
+	intf := vethRawIf{vport: 2, veth: &veth}
+
+	pkt := make([]byte, 60)
+	for i := range pkt {
+		pkt[i] = byte(i)
+	}
+
+	// struct tpacket_auxdata with a stripped 802.1ad tag
+	oob := make([]byte, syscall.CmsgSpace(lTPACKET_AUXDATA_LEN))
+	h := (*syscall.Cmsghdr)(unsafe.Pointer(&oob[0]))
+	h.Level = lSOL_PACKET
+	h.Type = lPACKET_AUXDATA
+	h.SetLen(syscall.CmsgLen(lTPACKET_AUXDATA_LEN))
+	aux := oob[syscall.CmsgLen(0):]
+	binary.LittleEndian.PutUint32(aux[0:4], lTP_STATUS_USER|lTP_STATUS_VLAN_VALID|lTP_STATUS_VLAN_TPID_VALID)
+	binary.LittleEndian.PutUint16(aux[16:18], 0x0123)
+	binary.LittleEndian.PutUint16(aux[18:20], 0x88a8)
+
+	var exp []byte
+	exp = append(exp, 0, 2, 0, 64)
+	exp = append(exp, pkt[0:12]...)
+	exp = append(exp, 0x88, 0xa8, 0x01, 0x23)
+	exp = append(exp, pkt[12:]...)
+	if msg := intf.recvToMsg(pkt, oob); !bytes.Equal(msg, exp) {
+		t.Fatalf(" recvToMsg is not as expected \n%v\n%v\n", msg, exp)
+	}
+
+	// no auxdata, as is
+	exp = append([]byte{0, 2, 0, 60}, pkt...)
+	if msg := intf.recvToMsg(pkt, nil); !bytes.Equal(msg, exp) {
+		t.Fatalf(" recvToMsg without auxdata is not as expected \n%v\n%v\n", msg, exp)
+	}
+}
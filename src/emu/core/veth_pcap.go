@@ -0,0 +1,466 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package core
+
+/* pcap veth
+
+Offline veth, works without TRex server and without interfaces.
+RX packets are read from a pcap/pcapng file by a replay thread, keeping the original timing
+divided by a speed factor (0 - as fast as possible). For pcap files all the packets are on vport 0,
+for pcapng files the vport is the interface id of the packet.
+TX packets are written to a pcapng file, interface id is the vport and a comment with the
+vport and tunnel key is added to each packet.
+
+rx message format is the same as the AF_PACKET veth
+
+uint16 vport
+uint16 pkt_size
+pkt_size bytes
+
+*/
+
+import (
+	"bufio"
+	"encoding/binary"
+	"external/google/gopacket"
+	"external/google/gopacket/layers"
+	"external/google/gopacket/pcapgo"
+	"fmt"
+	"io"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	PCAP_REPLAY_MAX_BUFFER_SIZE = 32 * 1024
+	PCAP_TX_PKT_BUTST_SIZE      = 64
+
+	pcapngBlockSHB     = 0x0A0D0D0A
+	pcapngBlockIDB     = 0x00000001
+	pcapngBlockEPB     = 0x00000006
+	pcapngByteOrder    = 0x1A2B3C4D
+	pcapngOptEnd       = 0
+	pcapngOptComment   = 1
+	pcapngOptIfName    = 2
+	pcapngOptEpbFlags  = 2
+	pcapngOptTsResol   = 9
+	pcapngEpbOutbound  = 2
+	pcapngLinkEthernet = 1
+)
+
+type pcapPacketReader interface {
+	ReadPacketData() (data []byte, ci gopacket.CaptureInfo, err error)
+}
+
+// openPcapReader opens a pcap or pcapng file, the format is detected by the magic. only Ethernet is supported.
+func openPcapReader(fileName string) (pcapPacketReader, *os.File, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, nil, err
+	}
+	r := bufio.NewReader(f)
+	magic, err := r.Peek(4)
+	if err != nil {
+		f.Close()
+		return nil, nil, fmt.Errorf("can't read the header of %s: %v", fileName, err)
+	}
+	var pr pcapPacketReader
+	var linkType layers.LinkType
+	if binary.LittleEndian.Uint32(magic) == pcapngBlockSHB {
+		var ng *pcapgo.NgReader
+		// the packets of an interface that is not Ethernet as the first one are returned as an error, replay skips them
+		opt := pcapgo.DefaultNgReaderOptions
+		opt.ErrorOnMismatchingLinkType = true
+		if ng, err = pcapgo.NewNgReader(r, opt); err == nil {
+			pr = ng
+			linkType = ng.LinkType()
+		}
+	} else {
+		var pc *pcapgo.Reader
+		if pc, err = pcapgo.NewReader(r); err == nil {
+			pr = pc
+			linkType = pc.LinkType()
+		}
+	}
+	if err != nil {
+		f.Close()
+		return nil, nil, fmt.Errorf("%s is not a valid pcap/pcapng file: %v", fileName, err)
+	}
+	if linkType != layers.LinkTypeEthernet {
+		f.Close()
+		return nil, nil, fmt.Errorf("%s link type %v is not supported, only Ethernet", fileName, linkType)
+	}
+	return pr, f, nil
+}
+
+func pcapngPad(l int) int {
+	return (4 - l&3) & 3
+}
+
+// pcapngWriter minimal pcapng writer, supports per packet comments that are missing in pcapgo.NgWriter
+type pcapngWriter struct {
+	w   *bufio.Writer
+	ifs int // number of interfaces, interface id is the vport
+	buf []byte
+}
+
+func newPcapngWriter(w io.Writer) *pcapngWriter {
+	o := &pcapngWriter{w: bufio.NewWriter(w)}
+	o.writeSectionHeader()
+	o.w.Flush()
+	return o
+}
+
+func (o *pcapngWriter) appendOption(b []byte, code uint16, val []byte) []byte {
+	var h [4]byte
+	binary.LittleEndian.PutUint16(h[0:2], code)
+	binary.LittleEndian.PutUint16(h[2:4], uint16(len(val)))
+	b = append(b, h[:]...)
+	b = append(b, val...)
+	return append(b, make([]byte, pcapngPad(len(val)))...)
+}
+
+// writeBlock writes a block, body should be padded to 32 bit
+func (o *pcapngWriter) writeBlock(blockType uint32, body []byte) error {
+	var h [8]byte
+	blen := uint32(len(body) + 12)
+	binary.LittleEndian.PutUint32(h[0:4], blockType)
+	binary.LittleEndian.PutUint32(h[4:8], blen)
+	o.w.Write(h[:])
+	o.w.Write(body)
+	_, err := o.w.Write(h[4:8])
+	return err
+}
+
+func (o *pcapngWriter) writeSectionHeader() error {
+	b := make([]byte, 16)
+	binary.LittleEndian.PutUint32(b[0:4], pcapngByteOrder)
+	binary.LittleEndian.PutUint16(b[4:6], 1) // version 1.0
+	binary.LittleEndian.PutUint16(b[6:8], 0)
+	binary.LittleEndian.PutUint64(b[8:16], 0xFFFFFFFFFFFFFFFF) // section length is not specified
+	return o.writeBlock(pcapngBlockSHB, b)
+}
+
+func (o *pcapngWriter) addInterface(name string) error {
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint16(b[0:2], pcapngLinkEthernet)
+	binary.LittleEndian.PutUint32(b[4:8], 0) // no snap length
+	b = o.appendOption(b, pcapngOptIfName, []byte(name))
+	b = o.appendOption(b, pcapngOptTsResol, []byte{9}) // nsec
+	b = o.appendOption(b, pcapngOptEnd, nil)
+	o.ifs++
+	return o.writeBlock(pcapngBlockIDB, b)
+}
+
+// writePacket writes an outbound packet of the vport, interfaces up to the vport are added if needed
+func (o *pcapngWriter) writePacket(ts time.Time, vport uint16, data []byte, comment string) error {
+	for o.ifs <= int(vport) {
+		if err := o.addInterface(fmt.Sprintf("vport-%d", o.ifs)); err != nil {
+			return err
+		}
+	}
+	b := o.buf[:0]
+	var h [20]byte
+	nsec := uint64(ts.UnixNano())
+	binary.LittleEndian.PutUint32(h[0:4], uint32(vport))
+	binary.LittleEndian.PutUint32(h[4:8], uint32(nsec>>32))
+	binary.LittleEndian.PutUint32(h[8:12], uint32(nsec))
+	binary.LittleEndian.PutUint32(h[12:16], uint32(len(data)))
+	binary.LittleEndian.PutUint32(h[16:20], uint32(len(data)))
+	b = append(b, h[:]...)
+	b = append(b, data...)
+	b = append(b, make([]byte, pcapngPad(len(data)))...)
+	if comment != "" {
+		b = o.appendOption(b, pcapngOptComment, []byte(comment))
+	}
+	var flags [4]byte
+	binary.LittleEndian.PutUint32(flags[:], pcapngEpbOutbound)
+	b = o.appendOption(b, pcapngOptEpbFlags, flags[:])
+	b = o.appendOption(b, pcapngOptEnd, nil)
+	o.buf = b
+	return o.writeBlock(pcapngBlockEPB, b)
+}
+
+func (o *pcapngWriter) Flush() error {
+	return o.w.Flush()
+}
+
+// pcapTunnelComment returns the comment of a packet with its vport and tunnel key (vlans, MPLS labels and encap)
+func pcapTunnelComment(vport uint16, p []byte) string {
+	var key CTunnelKey
+	var d CTunnelDataJson
+	if !GetPacketTunnelKey(p, vport, &key) {
+		return fmt.Sprintf("vport:%d", vport)
+	}
+	key.GetJson(&d)
+	s := fmt.Sprintf("vport:%d tpid:[0x%04x,0x%04x] tci:[%d,%d]", d.Vport, d.Tpid[0], d.Tpid[1], d.Tci[0], d.Tci[1])
+	if len(d.Mpls) > 0 {
+		s += fmt.Sprintf(" mpls:%v", d.Mpls)
+	}
+	if e := d.Encap; e != nil {
+		switch e.Type {
+		case "vxlan":
+			s += fmt.Sprintf(" encap:vxlan vni:%d", e.Vni)
+		case "gre":
+			s += fmt.Sprintf(" encap:gre key:%d", e.Key)
+		case "pbb":
+			s += fmt.Sprintf(" encap:pbb isid:%d", e.Isid)
+		}
+	}
+	return s
+}
+
+// VethIFPcap veth that replays rx packets from a pcap/pcapng file and records the tx packets to pcapng
+type VethIFPcap struct {
+	cn          chan []byte
+	vec         []*Mbuf
+	stats       VethStats
+	tctx        *CThreadCtx
+	K12Monitor  bool     // K12 packet monitoring to monitorDest
+	monitorFile *os.File // File to print the K12 packet captured. Default is stdout.
+	cdb         *CCounterDb
+
+	replay      pcapPacketReader // nil in case there is no replay file
+	replayFile  *os.File
+	speed       float64       // speed factor of the replay, 0 means as fast as possible
+	delay       time.Duration // delay before the first packet, to let the namespaces be created
+	ReplayDoneC chan bool     // closed at the end of the replay
+
+	record     *pcapngWriter // nil in case there is no record file
+	recordFile *os.File
+}
+
+// Create opens the replay and the record files, each one could be empty.
+func (o *VethIFPcap) Create(ctx *CThreadCtx, replayFile string, speed float64, delay time.Duration, recordFile string) error {
+	if speed < 0 {
+		return fmt.Errorf("replay speed should not be negative: %v", speed)
+	}
+	o.tctx = ctx
+	o.cn = make(chan []byte)
+	o.vec = make([]*Mbuf, 0)
+	o.cdb = NewVethStatsDb(&o.stats)
+	o.speed = speed
+	o.delay = delay
+	o.ReplayDoneC = make(chan bool)
+
+	var err error
+	if replayFile != "" {
+		o.replay, o.replayFile, err = openPcapReader(replayFile)
+		if err != nil {
+			return err
+		}
+	}
+	if recordFile != "" {
+		o.recordFile, err = os.Create(recordFile)
+		if err != nil {
+			o.closeAll()
+			return err
+		}
+		o.record = newPcapngWriter(o.recordFile)
+	}
+	return nil
+}
+
+func (o *VethIFPcap) closeAll() {
+	if o.replayFile != nil {
+		o.replayFile.Close()
+		o.replayFile = nil
+	}
+	if o.recordFile != nil {
+		o.record.Flush()
+		o.recordFile.Close()
+		o.recordFile = nil
+		o.record = nil
+	}
+}
+
+func (o *VethIFPcap) StartRxThread() {
+	if o.replay == nil {
+		close(o.ReplayDoneC)
+		return
+	}
+	go o.rxThread()
+}
+
+// rxThread reads the replay file, packets that their time has arrived are sent together as one message
+func (o *VethIFPcap) rxThread() {
+	defer close(o.ReplayDoneC)
+	time.Sleep(o.delay)
+
+	var msg []byte
+	var first time.Time
+	start := time.Now()
+	for {
+		data, ci, err := o.replay.ReadPacketData()
+		if err == pcapgo.ErrNgLinkTypeMismatch {
+			atomic.AddUint64(&o.stats.RxSkipLinkType, 1)
+			continue
+		}
+		if err != nil {
+			if err != io.EOF {
+				atomic.AddUint64(&o.stats.RxParseErr, 1)
+			}
+			break
+		}
+		if len(data) > int(MAX_PACKET_SIZE) {
+			atomic.AddUint64(&o.stats.RxDropTooBig, 1)
+			continue
+		}
+		if first.IsZero() {
+			first = ci.Timestamp
+		}
+		if o.speed > 0 {
+			wait := time.Until(start.Add(time.Duration(float64(ci.Timestamp.Sub(first)) / o.speed)))
+			if wait > 0 {
+				if len(msg) > 0 {
+					o.cn <- msg
+					msg = nil
+				}
+				time.Sleep(wait)
+			}
+		}
+		if len(msg)+4+len(data) > PCAP_REPLAY_MAX_BUFFER_SIZE {
+			o.cn <- msg
+			msg = nil
+		}
+		msg = appendPktMsg(msg, uint16(ci.InterfaceIndex), data)
+	}
+	if len(msg) > 0 {
+		o.cn <- msg
+	}
+}
+
+func (o *VethIFPcap) GetC() chan []byte {
+	return o.cn
+}
+
+func (o *VethIFPcap) FlushTx() {
+	if len(o.vec) == 0 {
+		return
+	}
+	o.stats.TxBatch++
+	now := time.Now()
+	for _, m := range o.vec {
+		if !m.IsContiguous() {
+			panic(" mbuf should be contiguous  ")
+		}
+		if o.K12Monitor {
+			io.WriteString(o.monitorFile, "\n ->TX<- \n")
+			m.DumpK12(o.tctx.GetTickSimInSec(), o.monitorFile)
+		}
+		if o.record != nil {
+			p := m.GetData()
+			err := o.record.writePacket(now, m.VPort(), p, pcapTunnelComment(m.VPort(), p))
+			if err != nil {
+				o.stats.TxSocketErr++
+			}
+		}
+		m.FreeMbuf()
+	}
+	o.vec = o.vec[:0]
+	if o.record != nil {
+		o.record.Flush()
+	}
+}
+
+func (o *VethIFPcap) Send(m *Mbuf) {
+	if m = o.tctx.encapTx(m); m == nil {
+		return
+	}
+	o.stats.TxPkts++
+	o.stats.TxBytes += uint64(m.PktLen())
+
+	if !m.IsContiguous() {
+		m1 := m.GetContiguous(&o.tctx.MPool)
+		m.FreeMbuf()
+		o.vec = append(o.vec, m1)
+	} else {
+		o.vec = append(o.vec, m)
+	}
+	if len(o.vec) == PCAP_TX_PKT_BUTST_SIZE {
+		o.FlushTx()
+	}
+}
+
+// SendBuffer get a buffer as input, should allocate mbuf and call send
+func (o *VethIFPcap) SendBuffer(unicast bool, c *CClient, b []byte) {
+	var vport uint16
+	vport = c.Ns.GetVport()
+	m := o.tctx.MPool.Alloc(uint16(len(b)))
+	m.SetVPort(vport)
+	m.Append(b)
+	if unicast {
+		if c.DGW == nil {
+			m.FreeMbuf()
+			o.stats.TxDropNotResolve++
+			return
+		}
+		if !c.DGW.IpdgResolved {
+			m.FreeMbuf()
+			o.stats.TxDropNotResolve++
+			return
+		}
+		p := m.GetData()
+		copy(p[6:12], c.Mac[:])
+		copy(p[0:6], c.DGW.IpdgMac[:])
+	}
+	o.Send(m)
+}
+
+// get the packet
+func (o *VethIFPcap) OnRx(m *Mbuf) {
+	o.stats.RxPkts++
+	o.stats.RxBytes += uint64(m.PktLen())
+	if o.K12Monitor {
+		io.WriteString(o.monitorFile, "\n ->RX<- \n")
+		m.DumpK12(o.tctx.GetTickSimInSec(), o.monitorFile)
+	}
+	o.tctx.HandleRxPacket(m)
+}
+
+func (o *VethIFPcap) OnRxStream(stream []byte) {
+	o.stats.RxBatch++
+	ok := walkPktMsg(stream, func(vport uint16, pkt []byte) {
+		m := o.tctx.MPool.Alloc(uint16(len(pkt)))
+		m.SetVPort(vport)
+		m.Append(pkt)
+		o.OnRx(m)
+	})
+	if !ok {
+		o.stats.RxParseErr++
+	}
+}
+
+/* get the veth stats */
+func (o *VethIFPcap) GetStats() *VethStats {
+	return &o.stats
+}
+
+func (o *VethIFPcap) SimulatorCheckRxQueue() {
+
+}
+
+func (o *VethIFPcap) SimulatorCleanup() {
+	for _, m := range o.vec {
+		m.FreeMbuf()
+	}
+	o.vec = nil
+	o.closeAll()
+}
+
+func (o *VethIFPcap) SetDebug(monitor bool, monitorFile *os.File, capture bool) {
+	o.K12Monitor = monitor
+	o.monitorFile = monitorFile
+}
+
+func (o *VethIFPcap) GetCdb() *CCounterDb {
+	return o.cdb
+}
+
+func (o *VethIFPcap) AppendSimuationRPC(request []byte) {
+	panic("AppendSimuationRPC should not be called ")
+}
@@ -0,0 +1,195 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"external/google/gopacket"
+	"external/google/gopacket/layers"
+	"external/google/gopacket/pcapgo"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func pcapTestPkt(tci uint16, size int) []byte {
+	pkt := make([]byte, size)
+	copy(pkt[0:6], []byte{0, 0, 1, 0, 0, 1})
+	copy(pkt[6:12], []byte{0, 0, 2, 0, 0, 2})
+	binary.BigEndian.PutUint16(pkt[12:14], 0x8100)
+	binary.BigEndian.PutUint16(pkt[14:16], tci)
+	binary.BigEndian.PutUint16(pkt[16:18], 0x1234)
+	return pkt
+}
+
+func pcapReadAll(t *testing.T, veth *VethIFPcap) {
+	veth.StartRxThread()
+	for {
+		select {
+		case msg := <-veth.GetC():
+			veth.OnRxStream(msg)
+		case <-veth.ReplayDoneC:
+			return
+		case <-time.After(5 * time.Second):
+			t.Fatalf(" replay was not finished ")
+		}
+	}
+}
+
+func TestVethPcapReplayRecord(t *testing.T) {
+	dir, err := ioutil.TempDir("", "emu-pcap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	in := filepath.Join(dir, "in.pcap")
+	out := filepath.Join(dir, "out.pcapng")
+
+	f, _ := os.Create(in)
+	w := pcapgo.NewWriterNanos(f)
+	w.WriteFileHeader(65536, layers.LinkTypeEthernet)
+	ts := time.Unix(1000, 0)
+	for i := 0; i < 3; i++ {
+		pkt := pcapTestPkt(uint16(i+1), 64)
+		w.WritePacket(gopacket.CaptureInfo{Timestamp: ts.Add(time.Duration(i) * 10 * time.Millisecond),
+			Length: len(pkt), CaptureLength: len(pkt)}, pkt)
+	}
+	f.Close()
+
+	var simrx VethIFSim = &VethSink{}
+	tctx := NewThreadCtx(0, 4510, true, &simrx)
+	var veth VethIFPcap
+	if err := veth.Create(tctx, in, 1.0, 0, out); err != nil {
+		t.Fatal(err)
+	}
+	tctx.SetZmqVeth(&veth)
+
+	start := time.Now()
+	pcapReadAll(t, &veth)
+	if time.Since(start) < 20*time.Millisecond {
+		t.Fatalf(" replay timing was not kept ")
+	}
+	if veth.stats.RxPkts != 3 || tctx.parser.stats.errL3ProtoUnsupported != 3 {
+		t.Fatalf(" unexpected rx counters %+v ", veth.stats)
+	}
+
+	tx := [][]byte{pcapTestPkt(10, 60), pcapTestPkt(20, 61)}
+	for i, pkt := range tx {
+		m := tctx.MPool.Alloc(uint16(len(pkt)))
+		m.SetVPort(uint16(i * 2))
+		m.Append(pkt)
+		veth.Send(m)
+	}
+	veth.FlushTx()
+	veth.SimulatorCleanup()
+
+	b, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(b, []byte("vport:2 tpid:[0x8100,0x0000] tci:[20,0]")) {
+		t.Fatalf(" tunnel comment is missing ")
+	}
+	r, err := pcapgo.NewNgReader(bytes.NewReader(b), pcapgo.DefaultNgReaderOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, pkt := range tx {
+		data, ci, err := r.ReadPacketData()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, pkt) || ci.InterfaceIndex != i*2 {
+			t.Fatalf(" recorded packet %d is not as expected, interface %d ", i, ci.InterfaceIndex)
+		}
+	}
+
+	// replay the recording, the vport is the interface id
+	tctx = NewThreadCtx(0, 4510, true, &simrx)
+	veth = VethIFPcap{}
+	if err := veth.Create(tctx, out, 0, 0, ""); err != nil {
+		t.Fatal(err)
+	}
+	tctx.SetZmqVeth(&veth)
+	pcapReadAll(t, &veth)
+	if veth.stats.RxPkts != 2 || veth.stats.RxParseErr != 0 {
+		t.Fatalf(" unexpected rx counters %+v ", veth.stats)
+	}
+	veth.SimulatorCleanup()
+}
+
+func TestVethPcapLinkType(t *testing.T) {
+	dir, err := ioutil.TempDir("", "emu-pcap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	in := filepath.Join(dir, "sll.pcap")
+
+	f, _ := os.Create(in)
+	w := pcapgo.NewWriter(f)
+	w.WriteFileHeader(65536, layers.LinkTypeLinuxSLL)
+	f.Close()
+
+	var simrx VethIFSim = &VethSink{}
+	tctx := NewThreadCtx(0, 4510, true, &simrx)
+	var veth VethIFPcap
+	if err := veth.Create(tctx, in, 0, 0, ""); err == nil {
+		t.Fatalf(" a Linux SLL capture should be rejected ")
+	}
+}
+
+func TestVethPcapSkipLinkType(t *testing.T) {
+	dir, err := ioutil.TempDir("", "emu-pcap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	in := filepath.Join(dir, "in.pcapng")
+
+	// the second interface is Linux SLL, its packet is skipped
+	f, _ := os.Create(in)
+	w, _ := pcapgo.NewNgWriter(f, layers.LinkTypeEthernet)
+	w.AddInterface(pcapgo.NgInterface{LinkType: layers.LinkTypeLinuxSLL, SnapLength: 65536})
+	for i, intf := range []int{0, 1, 0} {
+		pkt := pcapTestPkt(uint16(i+1), 64)
+		w.WritePacket(gopacket.CaptureInfo{Timestamp: time.Unix(1000, 0), Length: len(pkt), CaptureLength: len(pkt),
+			InterfaceIndex: intf}, pkt)
+	}
+	w.Flush()
+	f.Close()
+
+	var simrx VethIFSim = &VethSink{}
+	tctx := NewThreadCtx(0, 4510, true, &simrx)
+	var veth VethIFPcap
+	if err := veth.Create(tctx, in, 0, 0, ""); err != nil {
+		t.Fatal(err)
+	}
+	tctx.SetZmqVeth(&veth)
+	pcapReadAll(t, &veth)
+	if veth.stats.RxPkts != 2 || veth.stats.RxSkipLinkType != 1 || veth.stats.RxParseErr != 0 {
+		t.Fatalf(" unexpected rx counters %+v ", veth.stats)
+	}
+	veth.SimulatorCleanup()
+}
+
+func TestVethPcapTunnelComment(t *testing.T) {
+	// vlan 20, MPLS label 100 (bottom of stack) and IPv4
+	pkt := make([]byte, 64)
+	copy(pkt[0:6], []byte{0, 0, 1, 0, 0, 1})
+	binary.BigEndian.PutUint16(pkt[12:14], 0x8100)
+	binary.BigEndian.PutUint16(pkt[14:16], 20)
+	binary.BigEndian.PutUint16(pkt[16:18], 0x8847)
+	binary.BigEndian.PutUint32(pkt[18:22], 100<<12|1<<8|64)
+	pkt[22] = 0x45
+	c := pcapTunnelComment(1, pkt)
+	if c != "vport:1 tpid:[0x8100,0x0000] tci:[20,0] mpls:[100]" {
+		t.Fatalf(" unexpected comment %s ", c)
+	}
+}
@@ -0,0 +1,178 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package core
+
+/* worker veth
+
+veth of a CThreadPool worker. It does not own a socket, the packets are moved as messages
+between the worker and the main thread (that owns the real veth).
+
+message format
+
+each packet is like this
+
+uint16 vport
+uint16 pkt_size
+pkt_size bytes
+
+*/
+
+import (
+	"encoding/binary"
+	"os"
+)
+
+const (
+	VETH_WORKER_MAX_BUFFER_SIZE = 32 * 1024
+)
+
+// appendPktMsg appends a packet to a worker message
+func appendPktMsg(msg []byte, vport uint16, pkt []byte) []byte {
+	var h [4]byte
+	binary.BigEndian.PutUint16(h[0:2], vport)
+	binary.BigEndian.PutUint16(h[2:4], uint16(len(pkt)))
+	msg = append(msg, h[:]...)
+	return append(msg, pkt...)
+}
+
+// walkPktMsg calls cb for each packet in the message, returns false in case the message is malformed
+func walkPktMsg(msg []byte, cb func(vport uint16, pkt []byte)) bool {
+	blen := uint32(len(msg))
+	var of uint32
+	for of < blen {
+		if blen < of+4 {
+			return false
+		}
+		vport := binary.BigEndian.Uint16(msg[of : of+2])
+		pktLen := uint32(binary.BigEndian.Uint16(msg[of+2 : of+4]))
+		if blen < of+4+pktLen {
+			return false
+		}
+		cb(vport, msg[of+4:of+4+pktLen])
+		of = of + 4 + pktLen
+	}
+	return true
+}
+
+type VethIFWorker struct {
+	vec   []byte        // tx message
+	txC   chan<- []byte // to the main thread
+	stats VethStats
+	tctx  *CThreadCtx
+	cdb   *CCounterDb
+}
+
+func (o *VethIFWorker) Create(ctx *CThreadCtx, txC chan<- []byte) {
+	o.tctx = ctx
+	o.txC = txC
+	o.vec = make([]byte, 0, VETH_WORKER_MAX_BUFFER_SIZE)
+	o.cdb = NewVethStatsDb(&o.stats)
+	o.cdb.Name = "veth-worker"
+}
+
+func (o *VethIFWorker) FlushTx() {
+	if len(o.vec) == 0 {
+		return
+	}
+	o.stats.TxBatch++
+	o.txC <- o.vec // the main thread owns the buffer from now on
+	o.vec = make([]byte, 0, VETH_WORKER_MAX_BUFFER_SIZE)
+}
+
+func (o *VethIFWorker) Send(m *Mbuf) {
+	if m = o.tctx.encapTx(m); m == nil {
+		return
+	}
+	pktlen := m.PktLen()
+	o.stats.TxPkts++
+	o.stats.TxBytes += uint64(pktlen)
+
+	if uint32(len(o.vec))+4+pktlen > VETH_WORKER_MAX_BUFFER_SIZE {
+		o.FlushTx()
+	}
+	if !m.IsContiguous() {
+		m1 := m.GetContiguous(&o.tctx.MPool)
+		m.FreeMbuf()
+		m = m1
+	}
+	o.vec = appendPktMsg(o.vec, m.VPort(), m.GetData())
+	m.FreeMbuf()
+}
+
+// SendBuffer get a buffer as input, should allocate mbuf and call send
+func (o *VethIFWorker) SendBuffer(unicast bool, c *CClient, b []byte) {
+	var vport uint16
+	vport = c.Ns.GetVport()
+	m := o.tctx.MPool.Alloc(uint16(len(b)))
+	m.SetVPort(vport)
+	m.Append(b)
+	if unicast {
+		if c.DGW == nil {
+			m.FreeMbuf()
+			o.stats.TxDropNotResolve++
+			return
+		}
+		if !c.DGW.IpdgResolved {
+			m.FreeMbuf()
+			o.stats.TxDropNotResolve++
+			return
+		}
+		p := m.GetData()
+		copy(p[6:12], c.Mac[:])
+		copy(p[0:6], c.DGW.IpdgMac[:])
+	}
+	o.Send(m)
+}
+
+func (o *VethIFWorker) OnRx(m *Mbuf) {
+	o.stats.RxPkts++
+	o.stats.RxBytes += uint64(m.PktLen())
+	o.tctx.HandleRxPacket(m)
+}
+
+// OnRxStream handles a message of packets from the main thread
+func (o *VethIFWorker) OnRxStream(msg []byte) {
+	o.stats.RxBatch++
+	ok := walkPktMsg(msg, func(vport uint16, pkt []byte) {
+		m := o.tctx.MPool.Alloc(uint16(len(pkt)))
+		m.SetVPort(vport)
+		m.Append(pkt)
+		o.OnRx(m)
+	})
+	if !ok {
+		o.stats.RxParseErr++
+	}
+}
+
+func (o *VethIFWorker) GetStats() *VethStats {
+	return &o.stats
+}
+
+func (o *VethIFWorker) SimulatorCheckRxQueue() {
+
+}
+
+func (o *VethIFWorker) SimulatorCleanup() {
+	o.vec = nil
+}
+
+func (o *VethIFWorker) SetDebug(monitor bool, monitorFile *os.File, capture bool) {
+}
+
+func (o *VethIFWorker) GetCdb() *CCounterDb {
+	return o.cdb
+}
+
+func (o *VethIFWorker) AppendSimuationRPC(request []byte) {
+	panic("AppendSimuationRPC should not be called ")
+}
+
+func (o *VethIFWorker) GetC() chan []byte {
+	return nil
+}
+
+func (o *VethIFWorker) StartRxThread() {
+}
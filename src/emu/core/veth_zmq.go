@@ -177,7 +177,9 @@ func (o *VethIFZmq) FlushTx() {
 }
 
 func (o *VethIFZmq) Send(m *Mbuf) {
-
+	if m = o.tctx.encapTx(m); m == nil {
+		return
+	}
 	pktlen := m.PktLen()
 	o.stats.TxPkts++
 	o.stats.TxBytes += uint64(pktlen)
@@ -255,6 +255,7 @@ func NewArpNsStatsDb(o *ArpNsStats) *core.CCounterDb {
 		Help:     "arp table active",
 		Unit:     "entries",
 		DumpZero: false,
+		Gauge:    true,
 		Info:     core.ScINFO})
 	db.Add(&core.CCounterRec{
 		Counter:  &o.tblAdd,
@@ -809,6 +810,10 @@ func NewArpNs(ctx *core.PluginCtx, initJson []byte) *core.PluginBase {
 	return &o.PluginBase
 }
 
+func (o *PluginArpNs) GetCounterDbVec() *core.CCounterDbVec {
+	return o.cdbv
+}
+
 func (o *PluginArpNs) OnRemove(ctx *core.PluginCtx) {
 	o.tbl.OnRemove()
 }
@@ -923,6 +928,8 @@ func (o *PluginArpNs) HandleRxArpPacket(m *core.Mbuf, l3 uint16) {
 				arpCPlug := cplg.Ext.(*PluginArpClient)
 				arpCPlug.Respond(&arpHeader)
 			}
+		} else if v := o.Ns.LookupVirtualIPv4(&ipv4); v != nil {
+			o.RespondVirtual(&arpHeader, ipv4, v)
 		} else {
 			o.stats.pktRxArpQueryNotForUs++
 		}
@@ -940,6 +947,27 @@ func (o *PluginArpNs) HandleRxArpPacket(m *core.Mbuf, l3 uint16) {
 	}
 }
 
+// RespondVirtual answers a query for a virtual ipv4 (e.g. VRRP/HSRP) with its virtual MAC
+func (o *PluginArpNs) RespondVirtual(arpHeader *layers.ArpHeader, ipv4 core.Ipv4Key, v *core.CVirtualAddr) {
+	var sip core.Ipv4Key
+	sip.SetUint32(arpHeader.GetSrcIpAddress())
+	pkt := v.Client.GetL2Header(false, uint16(layers.EthernetTypeARP))
+	copy(pkt[0:6], arpHeader.GetSourceAddress())
+	copy(pkt[6:12], v.Mac[:])
+	pkt = append(pkt, core.PacketUtlBuild(&layers.ARP{
+		AddrType:          0x1,
+		Protocol:          0x800,
+		HwAddressSize:     0x6,
+		ProtAddressSize:   0x4,
+		Operation:         layers.ARPReply,
+		SourceHwAddress:   v.Mac[:],
+		SourceProtAddress: ipv4[:],
+		DstHwAddress:      arpHeader.GetSourceAddress(),
+		DstProtAddress:    sip[:]})...)
+	o.stats.pktTxReply++
+	o.Tctx.Veth.SendBuffer(false, v.Client, pkt)
+}
+
 // PluginArpThread  per thread
 /*type PluginArpThread struct {
 	core.PluginBase
@@ -1190,7 +1218,8 @@ func init() {
 	core.RegisterCB("arp_ns_iter", ApiArpNsIterHandler{}, true)
 
 	/* register callback for rx side*/
-	core.ParserRegister("arp", HandleRxArpPacket)
+	core.ParserRegister("arp", HandleRxArpPacket,
+		core.ParserRegisterData{EtherTypes: []uint16{uint16(layers.EthernetTypeARP)}})
 }
 
 func Register(ctx *core.CThreadCtx) {
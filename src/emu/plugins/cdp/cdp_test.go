@@ -165,6 +165,63 @@ func TestPluginCdp2(t *testing.T) {
 	a.Run(t)
 }
 
+// VethCdpLoopSim loops the tx packets back to rx, the namespace learns its own clients as neighbors
+type VethCdpLoopSim struct{}
+
+func (o *VethCdpLoopSim) ProcessTxToRx(m *core.Mbuf) *core.Mbuf {
+	return m
+}
+
+func TestCdpNeighbors(t *testing.T) {
+	var simrx core.VethIFSim = &VethCdpLoopSim{}
+	tctx := core.NewThreadCtx(0, 4510, true, &simrx)
+	defer tctx.Delete()
+	var key core.CTunnelKey
+	key.Set(&core.CTunnelData{Vport: 1, Vlans: [2]uint32{0x81000001, 0x81000002}})
+	ns := core.NewNSCtx(tctx, &key)
+	tctx.AddNs(&key, ns)
+	ns.PluginCtx.CreatePlugins([]string{"cdp"}, [][]byte{})
+	Register(tctx)
+	cdpNs := ns.PluginCtx.Get(CDP_PLUG).Ext.(*PluginCdpNs)
+
+	cdp_options, _ := hex.DecodeString("0001000c6d7973776974636800020011000000010101cc0004c0a800fd000300134661737445746865726e6574302f310004000800000028000900" +
+		"0c4d59444f4d41494e000a00060001000b000501")
+	l := &CdpInit{Options: &CdpOptionsT{Raw: &cdp_options}}
+	jsonData, _ := json.Marshal(l)
+	jsonBadCs, _ := json.Marshal(&CdpInit{Options: &CdpOptionsT{Raw: &cdp_options}, BadCs: 0x1234})
+
+	clients := []*core.CClient{}
+	for i, j := range [][]byte{jsonData, jsonBadCs} {
+		c := core.NewClient(ns, core.MACKey{0, 0, 1, 0, 0, byte(i + 1)}, core.Ipv4Key{}, core.Ipv6Key{}, core.Ipv4Key{})
+		ns.AddClient(c)
+		c.PluginCtx.CreatePlugins([]string{"cdp"}, [][]byte{j})
+		clients = append(clients, c)
+	}
+	tctx.MainLoopSim(time.Second)
+
+	if cdpNs.IterReset() {
+		t.Fatalf(" neighbor table should not be empty %+v ", cdpNs.nstats)
+	}
+	vec, _ := cdpNs.GetNext(10)
+	if len(vec) != 1 {
+		t.Fatalf(" expected one neighbor %+v ", vec)
+	}
+	n := vec[0]
+	if n.DeviceId != "myswitch" || n.PortId != "FastEthernet0/1" || n.Ver != 2 || n.Ttl != 180 || n.Caps != 0x28 ||
+		n.VtpDomain != "MYDOMAIN" || n.NativeVlan != 1 || !n.FullDuplex || len(n.Addresses) != 1 ||
+		n.Addresses[0] != "192.168.0.253" || n.SrcMac != (core.MACKey{0, 0, 1, 0, 0, 1}) {
+		t.Fatalf(" neighbor is not as expected %+v ", n)
+	}
+
+	// the neighbor ages after the hold time
+	ns.RemoveClient(clients[0])
+	tctx.MainLoopSim(200 * time.Second)
+	st := &cdpNs.nstats
+	if len(cdpNs.neighbors) != 0 || st.neighborAged != 1 || st.neighborActive != 0 || st.errChecksum == 0 || st.errDecode != 0 {
+		t.Fatalf(" unexpected counters %+v ", *st)
+	}
+}
+
 func init() {
 	flag.IntVar(&monitor, "monitor", 0, "monitor")
 }
@@ -10,6 +10,8 @@ cdp protocol.
 
 broadcast cisco CDP packet every tick. The CDP TLV information can be tuned by the inijson
 
+the namespace plugin keeps the neighbors that were learned from the received CDP packets, see neighbor.go
+
 */
 
 import (
@@ -58,6 +60,88 @@ func NewCdpStatsDb(o *CdpStats) *core.CCounterDb {
 	return db
 }
 
+type CdpNsStats struct {
+	pktRx          uint64
+	errDecode      uint64
+	errChecksum    uint64
+	errTableFull   uint64
+	neighborAdd    uint64
+	neighborRemove uint64
+	neighborAged   uint64
+	neighborActive uint64
+}
+
+func NewCdpNsStatsDb(o *CdpNsStats) *core.CCounterDb {
+	db := core.NewCCounterDb("cdp")
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRx,
+		Name:     "pktRx",
+		Help:     "received cdp packets",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.errDecode,
+		Name:     "errDecode",
+		Help:     "malformed cdp packets",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.errChecksum,
+		Name:     "errChecksum",
+		Help:     "cdp packets with a bad checksum",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.errTableFull,
+		Name:     "errTableFull",
+		Help:     "new neighbors dropped, the table is full",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.neighborAdd,
+		Name:     "neighborAdd",
+		Help:     "neighbors added",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.neighborRemove,
+		Name:     "neighborRemove",
+		Help:     "neighbors removed",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.neighborAged,
+		Name:     "neighborAged",
+		Help:     "neighbors removed, the hold time has expired",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.neighborActive,
+		Name:     "neighborActive",
+		Help:     "active neighbors",
+		Unit:     "neighbors",
+		DumpZero: false,
+		Gauge:    true,
+		Info:     core.ScINFO})
+
+	return db
+}
+
 type PluginCdpClientTimer struct {
 }
 
@@ -177,6 +261,10 @@ func (o *PluginCdpClient) OnEvent(msg string, a, b interface{}) {
 
 }
 
+func (o *PluginCdpClient) GetCounterDbVec() *core.CCounterDbVec {
+	return o.cdbv
+}
+
 func (o *PluginCdpClient) OnRemove(ctx *core.PluginCtx) {
 	/* force removing the link to the client */
 	ctx.UnregisterEvents(&o.PluginBase, cdpEvents)
@@ -200,10 +288,18 @@ func (o *PluginCdpClient) onTimerEvent() {
 	o.SendCdp()
 }
 
-// PluginCdpNs icmp information per namespace
+// PluginCdpNs cdp information per namespace, the neighbor table
 type PluginCdpNs struct {
 	core.PluginBase
-	stats CdpStats
+	stats      CdpStats
+	nstats     CdpNsStats
+	cdb        *core.CCounterDb
+	cdbv       *core.CCounterDbVec
+	timerw     *core.TimerCtx
+	neighbors  map[cdpNeighborKey]*cdpNeighbor
+	head       core.DList
+	activeIter *core.DList
+	iterReady  bool
 }
 
 func NewCdpNs(ctx *core.PluginCtx, initJson []byte) *core.PluginBase {
@@ -211,11 +307,20 @@ func NewCdpNs(ctx *core.PluginCtx, initJson []byte) *core.PluginBase {
 	o := new(PluginCdpNs)
 	o.InitPluginBase(ctx, o)
 	o.RegisterEvents(ctx, []string{}, o)
+	o.timerw = o.Tctx.GetTimerCtx()
+	o.neighbors = make(map[cdpNeighborKey]*cdpNeighbor)
+	o.head.SetSelf()
+	o.cdb = NewCdpNsStatsDb(&o.nstats)
+	o.cdbv = core.NewCCounterDbVec("cdp")
+	o.cdbv.Add(o.cdb)
 
 	return &o.PluginBase
 }
 
 func (o *PluginCdpNs) OnRemove(ctx *core.PluginCtx) {
+	for _, n := range o.neighbors {
+		o.removeNeighbor(n)
+	}
 }
 
 func (o *PluginCdpNs) OnEvent(msg string, a, b interface{}) {
@@ -226,6 +331,20 @@ func (o *PluginCdpNs) SetTruncated() {
 
 }
 
+// HandleRxCdpPacket Parser call this function with mbuf from the pool
+func HandleRxCdpPacket(ps *core.ParserPacketState) int {
+	ns := ps.Tctx.GetNs(ps.Tun)
+	if ns == nil {
+		return core.PARSER_ERR
+	}
+	nsplg := ns.PluginCtx.Get(CDP_PLUG)
+	if nsplg == nil {
+		return core.PARSER_ERR
+	}
+	cdpPlug := nsplg.Ext.(*PluginCdpNs)
+	return cdpPlug.HandleRxCdpPacket(ps)
+}
+
 // Tx side client get an event and decide to act !
 // let's see how it works and add some tests
 
@@ -244,6 +363,17 @@ func (o PluginCdpNsReg) NewPlugin(ctx *core.PluginCtx, initJson []byte) *core.Pl
 /*  RPC commands */
 type (
 	ApiCdpClientCntHandler struct{}
+	ApiCdpNsCntHandler     struct{}
+	ApiCdpNsIterHandler    struct{}
+	ApiCdpNsIterParams     struct {
+		Reset bool   `json:"reset"`
+		Count uint16 `json:"count" validate:"required,gte=0,lte=255"`
+	}
+	ApiCdpNsIterResult struct {
+		Empty   bool             `json:"empty"`
+		Stopped bool             `json:"stopped"`
+		Vec     []CdpNeighborRec `json:"data"`
+	}
 )
 
 func getNs(ctx interface{}, params *fastjson.RawMessage) (*PluginCdpNs, *jsonrpc.Error) {
@@ -290,6 +420,55 @@ func (h ApiCdpClientCntHandler) ServeJSONRPC(ctx interface{}, params *fastjson.R
 	return c.cdbv.GeneralCounters(err, tctx, params, &p)
 }
 
+func (h ApiCdpNsCntHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	var p core.ApiCntParams
+	tctx := ctx.(*core.CThreadCtx)
+	nsPlug, err := getNs(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	return nsPlug.cdbv.GeneralCounters(err, tctx, params, &p)
+}
+
+func (h ApiCdpNsIterHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	var p ApiCdpNsIterParams
+	var res ApiCdpNsIterResult
+	tctx := ctx.(*core.CThreadCtx)
+
+	nsPlug, err := getNs(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	err1 := tctx.UnmarshalValidate(*params, &p)
+	if err1 != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err1.Error(),
+		}
+	}
+
+	if p.Reset {
+		res.Empty = nsPlug.IterReset()
+	}
+	if res.Empty {
+		return &res, nil
+	}
+	if nsPlug.IterIsStopped() {
+		res.Stopped = true
+		return &res, nil
+	}
+
+	vec, err2 := nsPlug.GetNext(p.Count)
+	if err2 != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err2.Error(),
+		}
+	}
+	res.Vec = vec
+	return &res, nil
+}
+
 func init() {
 
 	/* register of plugins callbacks for ns,c level  */
@@ -314,9 +493,14 @@ func init() {
 	*/
 
 	core.RegisterCB("cdp_client_cnt", ApiCdpClientCntHandler{}, false) // get counters/meta
+	core.RegisterCB("cdp_ns_cnt", ApiCdpNsCntHandler{}, false)         // get counters of the neighbor table
+	core.RegisterCB("cdp_ns_iter", ApiCdpNsIterHandler{}, false)       // iterate the neighbors
 
+	/* register callback for rx side*/
+	core.ParserRegister(CDP_PLUG, HandleRxCdpPacket,
+		core.ParserRegisterData{SnapTypes: []uint64{CDP_SNAP_TYPE}})
 }
 
 func Register(ctx *core.CThreadCtx) {
-	//pass
+	ctx.RegisterParserCb(CDP_PLUG)
 }
@@ -0,0 +1,252 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package cdp
+
+/*
+cdp neighbor table of a namespace.
+
+CDP frames are 802.3 frames with a LLC/SNAP header (OUI 00000c, protocol 0x2000), the parser calls the plugin
+using the SNAP type. The neighbors are kept by device ID/port ID and aged using the hold time of the last frame.
+
+*/
+
+import (
+	"emu/core"
+	"encoding/binary"
+	"errors"
+	"external/google/gopacket"
+	"external/google/gopacket/layers"
+	"net"
+	"time"
+	"unsafe"
+)
+
+const (
+	CDP_MAX_NEIGHBORS = 1024
+	CDP_SNAP_TYPE     = 0x00000c2000
+	CDP_HEADER_SIZE   = 4
+)
+
+// CdpNeighborRec neighbor information for the RPC
+type CdpNeighborRec struct {
+	SrcMac     core.MACKey `json:"src_mac"`
+	DeviceId   string      `json:"device_id"`
+	PortId     string      `json:"port_id"`
+	Ver        uint8       `json:"ver"`
+	Ttl        uint8       `json:"ttl"`
+	Remaining  uint32      `json:"remaining"` // sec
+	Addresses  []string    `json:"addresses,omitempty"`
+	Caps       uint32      `json:"caps"`
+	SwVersion  string      `json:"sw_version,omitempty"`
+	Platform   string      `json:"platform,omitempty"`
+	VtpDomain  string      `json:"vtp_domain,omitempty"`
+	NativeVlan uint16      `json:"native_vlan,omitempty"`
+	FullDuplex bool        `json:"full_duplex"`
+	VoiceVlan  uint16      `json:"voice_vlan,omitempty"` // VLAN reply
+	Mtu        uint32      `json:"mtu,omitempty"`
+	SysName    string      `json:"sys_name,omitempty"`
+	MgmtAddr   []string    `json:"mgmt_addr,omitempty"`
+	Power      uint16      `json:"power,omitempty"` // mW
+}
+
+type cdpNeighborKey struct {
+	deviceId string
+	portId   string
+}
+
+type cdpNeighbor struct {
+	dlist  core.DList // must be first
+	timer  core.CHTimerObj
+	key    cdpNeighborKey
+	expire uint64 // ticks
+	rec    CdpNeighborRec
+}
+
+func covertToCdpNeighbor(dlist *core.DList) *cdpNeighbor {
+	return (*cdpNeighbor)(unsafe.Pointer(dlist))
+}
+
+type cdpNeighborTimer struct{}
+
+func (t cdpNeighborTimer) OnEvent(a, b interface{}) {
+	a.(*PluginCdpNs).onNeighborTimer(b.(*cdpNeighbor))
+}
+
+func cdpIpStrings(ips []net.IP) []string {
+	var r []string
+	for _, ip := range ips {
+		r = append(r, ip.String())
+	}
+	return r
+}
+
+// isValidCdpChecksum checks the checksum of the CDP header and TLVs p
+func isValidCdpChecksum(p []byte) bool {
+	d := append([]byte(nil), p...)
+	cs := binary.BigEndian.Uint16(d[2:4])
+	binary.BigEndian.PutUint16(d[2:4], 0)
+	return layers.CdpChecksum(d, 0) == cs
+}
+
+// decodeCdp decodes the CDP header and TLVs p into a neighbor record
+func decodeCdp(p []byte) (*CdpNeighborRec, error) {
+	pkt := gopacket.NewPacket(p, layers.LayerTypeCiscoDiscovery, gopacket.NoCopy)
+	if e := pkt.ErrorLayer(); e != nil {
+		return nil, e.Error()
+	}
+	cdp, _ := pkt.Layer(layers.LayerTypeCiscoDiscovery).(*layers.CiscoDiscovery)
+	info, _ := pkt.Layer(layers.LayerTypeCiscoDiscoveryInfo).(*layers.CiscoDiscoveryInfo)
+	if cdp == nil || info == nil {
+		return nil, errors.New("invalid CDP packet")
+	}
+	if info.DeviceID == "" {
+		return nil, errors.New("missing CDP device ID")
+	}
+
+	r := &CdpNeighborRec{
+		DeviceId:   info.DeviceID,
+		PortId:     info.PortID,
+		Ver:        cdp.Version,
+		Ttl:        cdp.TTL,
+		Addresses:  cdpIpStrings(info.Addresses),
+		SwVersion:  info.Version,
+		Platform:   info.Platform,
+		VtpDomain:  info.VTPDomain,
+		NativeVlan: info.NativeVLAN,
+		FullDuplex: info.FullDuplex,
+		VoiceVlan:  info.VLANReply.VLAN,
+		Mtu:        info.MTU,
+		SysName:    info.SysName,
+		MgmtAddr:   cdpIpStrings(info.MgmtAddresses),
+		Power:      info.PowerConsumption,
+	}
+	for _, v := range cdp.Values {
+		if v.Type == layers.CDPTLVCapabilities && len(v.Value) >= 4 {
+			r.Caps = binary.BigEndian.Uint32(v.Value[0:4])
+		}
+	}
+	return r, nil
+}
+
+// HandleRxCdpPacket decodes the CDP frame and updates the neighbor table, ps.L3 is the offset of the LLC header
+func (o *PluginCdpNs) HandleRxCdpPacket(ps *core.ParserPacketState) int {
+	p := ps.M.GetData()
+	o.nstats.pktRx++
+	// the 802.3 length excludes the padding
+	length := uint32(binary.BigEndian.Uint16(p[ps.L3-2 : ps.L3]))
+	if length < core.LLC_SNAP_HEADER_SIZE+CDP_HEADER_SIZE || uint32(ps.L3)+length > ps.M.PktLen() {
+		o.nstats.errDecode++
+		return core.PARSER_ERR
+	}
+	cdp := p[ps.L3+core.LLC_SNAP_HEADER_SIZE : uint32(ps.L3)+length]
+	if !isValidCdpChecksum(cdp) {
+		o.nstats.errChecksum++
+		return core.PARSER_ERR
+	}
+	rec, err := decodeCdp(cdp)
+	if err != nil {
+		o.nstats.errDecode++
+		return core.PARSER_ERR
+	}
+	copy(rec.SrcMac[:], p[6:12])
+	o.updateNeighbor(rec)
+	return core.PARSER_OK
+}
+
+func (o *PluginCdpNs) updateNeighbor(rec *CdpNeighborRec) {
+	key := cdpNeighborKey{deviceId: rec.DeviceId, portId: rec.PortId}
+	n, ok := o.neighbors[key]
+	if rec.Ttl == 0 {
+		if ok {
+			o.removeNeighbor(n)
+		}
+		return
+	}
+	if !ok {
+		if len(o.neighbors) >= CDP_MAX_NEIGHBORS {
+			o.nstats.errTableFull++
+			return
+		}
+		n = new(cdpNeighbor)
+		n.key = key
+		n.timer.SetCB(cdpNeighborTimer{}, o, n)
+		o.neighbors[key] = n
+		o.head.AddLast(&n.dlist)
+		o.nstats.neighborAdd++
+		o.nstats.neighborActive++
+	} else if o.timerw.IsRunning(&n.timer) {
+		o.timerw.Stop(&n.timer)
+	}
+	n.rec = *rec
+	ticks := o.timerw.DurationToTicks(time.Duration(rec.Ttl) * time.Second)
+	n.expire = o.timerw.Ticks + uint64(ticks)
+	o.timerw.StartTicks(&n.timer, ticks)
+}
+
+func (o *PluginCdpNs) removeNeighbor(n *cdpNeighbor) {
+	if n.timer.IsRunning() {
+		o.timerw.Stop(&n.timer)
+	}
+	if o.activeIter == &n.dlist {
+		// it is going to be removed
+		o.activeIter = n.dlist.Next()
+	}
+	o.head.RemoveNode(&n.dlist)
+	delete(o.neighbors, n.key)
+	o.nstats.neighborRemove++
+	o.nstats.neighborActive--
+}
+
+func (o *PluginCdpNs) onNeighborTimer(n *cdpNeighbor) {
+	o.nstats.neighborAged++
+	o.removeNeighbor(n)
+}
+
+func (o *PluginCdpNs) getNeighborRec(n *cdpNeighbor) CdpNeighborRec {
+	r := n.rec
+	r.Remaining = 0
+	if n.expire > o.timerw.Ticks {
+		r.Remaining = uint32(time.Duration(n.expire-o.timerw.Ticks) * o.timerw.TickDuration / time.Second)
+	}
+	return r
+}
+
+func (o *PluginCdpNs) IterReset() bool {
+	o.activeIter = o.head.Next()
+	if o.head.IsEmpty() {
+		o.iterReady = false
+		return true
+	}
+	o.iterReady = true
+	return false
+}
+
+func (o *PluginCdpNs) IterIsStopped() bool {
+	return !o.iterReady
+}
+
+func (o *PluginCdpNs) GetNext(n uint16) ([]CdpNeighborRec, error) {
+	r := make([]CdpNeighborRec, 0)
+
+	if !o.iterReady {
+		return r, errors.New(" Iterator is not ready- reset the iterator")
+	}
+
+	cnt := 0
+	for {
+		if o.activeIter == &o.head {
+			o.iterReady = false
+			break
+		}
+		cnt++
+		if cnt > int(n) {
+			break
+		}
+		r = append(r, o.getNeighborRec(covertToCdpNeighbor(o.activeIter)))
+		o.activeIter = o.activeIter.Next()
+	}
+	return r, nil
+}
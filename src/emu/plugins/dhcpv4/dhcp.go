@@ -442,7 +442,7 @@ func (o *PluginDhcpClient) SendDiscover() {
 	o.cnt = 0
 	o.restartTimer(o.timerDiscoverRetransmitSec)
 	o.stats.pktTxDiscover++
-	o.Tctx.Veth.SendBuffer(false, o.Client, o.discoverPktTemplate)
+	o.sendPkt(o.discoverPktTemplate)
 }
 
 /*OnEvent support event change of IP  */
@@ -450,6 +450,10 @@ func (o *PluginDhcpClient) OnEvent(msg string, a, b interface{}) {
 
 }
 
+func (o *PluginDhcpClient) GetCounterDbVec() *core.CCounterDbVec {
+	return o.cdbv
+}
+
 func (o *PluginDhcpClient) OnRemove(ctx *core.PluginCtx) {
 	/* force removing the link to the client */
 	o.SendRenewRebind(false, true, 0)
@@ -501,7 +505,7 @@ func (o *PluginDhcpClient) SendRenewRebind(rebind bool, release bool, timerSec u
 	o.stats.pktTxRequest++
 
 	o.restartTimer(timerSec)
-	o.Tctx.Veth.SendBuffer(false, o.Client, pkt)
+	o.sendPkt(pkt)
 }
 
 func (o *PluginDhcpClient) SendReq() {
@@ -520,6 +524,15 @@ func (o *PluginDhcpClient) SendReq() {
 
 	o.stats.pktTxRequest++
 	o.restartTimer(o.timerOfferRetransmitSec)
+	o.sendPkt(pkt)
+}
+
+// sendPkt sends the packet, in case the client is relayed by the relay of the namespace the DHCP message is relayed to the server
+func (o *PluginDhcpClient) sendPkt(pkt []byte) {
+	if relay := getRelayNs(o.Ns); relay != nil && relay.isRelayed(&o.Client.Mac) {
+		relay.relayToServer(o, pkt[o.l3Offset+20+8:])
+		return
+	}
 	o.Tctx.Veth.SendBuffer(false, o.Client, pkt)
 }
 
@@ -535,7 +548,8 @@ func convert(ipv4 net.IP) core.Ipv4Key {
 	return key
 }
 
-func (o *PluginDhcpClient) verifyPkt(dhcph *layers.DHCPv4, ipv4 layers.IPv4Header, learn bool, server *core.Ipv4Key) int {
+// verifyPkt verifies the message, dst is the destination IPv4 of the packet, yiaddr or giaddr in case it was relayed
+func (o *PluginDhcpClient) verifyPkt(dhcph *layers.DHCPv4, dst uint32, learn bool, server *core.Ipv4Key, relayed bool) int {
 	if dhcph.Xid != o.xid {
 		o.stats.pktRxWrongXid++
 		return -1
@@ -558,7 +572,10 @@ func (o *PluginDhcpClient) verifyPkt(dhcph *layers.DHCPv4, ipv4 layers.IPv4Heade
 	if !learn {
 		var key core.Ipv4Key
 		key = convert(dhcph.YourClientIP)
-		if key.Uint32() != ipv4.GetIPDst() {
+		if relayed {
+			key = convert(dhcph.RelayAgentIP.To4())
+		}
+		if key.Uint32() != dst {
 			o.stats.pktRxWrongIP++
 			return -1
 		}
@@ -628,21 +645,23 @@ func (o *PluginDhcpClient) onTimerEvent() {
 
 func (o *PluginDhcpClient) HandleAckNak(dhcpmt layers.DHCPMsgType,
 	dhcph *layers.DHCPv4,
-	ipv4 layers.IPv4Header,
+	dst uint32,
 	t1 uint32,
 	t2 uint32,
 	notify bool,
-	server *core.Ipv4Key) int {
+	server *core.Ipv4Key,
+	relayed bool) int {
 	switch dhcpmt {
 	case layers.DHCPMsgTypeAck:
 		o.stats.pktRxAck++
-		if o.verifyPkt(dhcph, ipv4, false, server) != 0 {
+		if o.verifyPkt(dhcph, dst, false, server, relayed) != 0 {
 			return -1
 		}
 		o.state = DHCP_STATE_BOUND
 		if notify {
 			o.stats.pktRxNotify++
-			ipv4addr := ipv4.GetIPDst()
+			yiaddr := convert(dhcph.YourClientIP)
+			ipv4addr := yiaddr.Uint32()
 			if ipv4addr != 0 {
 				var ipv4key core.Ipv4Key
 				ipv4key.SetUint32(ipv4addr)
@@ -696,7 +715,14 @@ func (o *PluginDhcpClient) HandleRxDhcpPacket(ps *core.ParserPacketState) int {
 		o.stats.pktRxParserErr++
 		return core.PARSER_ERR
 	}
+	var srcMac core.MACKey
+	copy(srcMac[:], p[6:12])
+	return o.handleRxDhcp(&dhcph, ipv4.GetIPDst(), &srcMac, false)
+}
 
+// handleRxDhcp handles a decoded message, dst is the destination IPv4 and srcMac the source MAC of the packet,
+// relayed is true in case the message was received by the relay of the namespace
+func (o *PluginDhcpClient) handleRxDhcp(dhcph *layers.DHCPv4, dst uint32, srcMac *core.MACKey, relayed bool) int {
 	var dhcpmt layers.DHCPMsgType
 	var t1 uint32
 	var t2 uint32
@@ -740,25 +766,25 @@ func (o *PluginDhcpClient) HandleRxDhcpPacket(ps *core.ParserPacketState) int {
 
 		if dhcpmt == layers.DHCPMsgTypeOffer {
 			o.stats.pktRxOffer++
-			if o.verifyPkt(&dhcph, ipv4, true, server) != 0 {
+			if o.verifyPkt(dhcph, dst, true, server, relayed) != 0 {
 				return -1
 			}
 
-			copy(o.serverMac[:], p[6:12])
+			o.serverMac = *srcMac
 			o.state = DHCP_STATE_REQUESTING
 			o.SendReq()
 			return 0
 		}
 
 	case DHCP_STATE_REQUESTING:
-		return o.HandleAckNak(dhcpmt, &dhcph, ipv4, t1, t2, true, server)
+		return o.HandleAckNak(dhcpmt, dhcph, dst, t1, t2, true, server, relayed)
 	case DHCP_STATE_BOUND:
 		o.stats.pktRxUnhandle++
 	case DHCP_STATE_RENEWING:
-		return o.HandleAckNak(dhcpmt, &dhcph, ipv4, t1, t2, true, server)
+		return o.HandleAckNak(dhcpmt, dhcph, dst, t1, t2, true, server, relayed)
 
 	case DHCP_STATE_REBINDING:
-		return o.HandleAckNak(dhcpmt, &dhcph, ipv4, t1, t2, true, server)
+		return o.HandleAckNak(dhcpmt, dhcph, dst, t1, t2, true, server, relayed)
 
 	default:
 		o.stats.pktRxUnhandle++
@@ -959,9 +985,11 @@ func init() {
 	core.RegisterCB("dhcp_client_cnt", ApiDhcpClientCntHandler{}, false) // get counters/meta
 
 	/* register callback for rx side*/
-	core.ParserRegister("dhcp", HandleRxDhcpPacket)
+	core.ParserRegister("dhcp", HandleRxDhcpPacket,
+		core.ParserRegisterData{UdpPorts: []uint16{68}})
 }
 
 func Register(ctx *core.CThreadCtx) {
 	ctx.RegisterParserCb("dhcp")
+	ctx.RegisterParserCb(DHCPSRV_PLUG)
 }
@@ -0,0 +1,469 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package dhcp
+
+/*
+RFC 3046 DHCP relay agent, namespace plugin
+
+In case the plugin exists in the namespace, the messages of the DHCP clients in clients are not sent to the wire,
+they are relayed to the server by the relay client (relay_mac) using the UDP sockets of the transport layer.
+The other DHCP clients of the namespace (and the relay client itself) are not relayed.
+The relay sets giaddr to its IPv4 and adds option 82 with the circuit-id/remote-id of the client.
+The replies of the server (UDP port 67 of the relay) are passed to the DHCP client by chaddr.
+
+The relay client should have an IPv4 and a resolved default gateway (or ipv4_force_dg) toward the server.
+In circuit_id/remote_id {mac} is replaced by the MAC of the client, an empty string omits the sub-option.
+
+ns inijson {
+	"relay_mac": [0, 0, 1, 0, 0, 9],
+	"server": [16, 0, 0, 1],
+	"circuit_id": "{mac}",
+	"remote_id": "trex",
+	"clients": [{"mac": [0, 0, 1, 0, 0, 2], "circuit_id": "eth1/1", "remote_id": "olt-1"}]
+}
+
+*/
+
+import (
+	"bytes"
+	"emu/core"
+	"emu/plugins/transport"
+	"external/google/gopacket"
+	"external/google/gopacket/layers"
+	"external/osamingo/jsonrpc"
+	"net"
+	"strings"
+
+	"github.com/intel-go/fastjson"
+)
+
+const (
+	DHCPRELAY_PLUG            = "dhcprelay"
+	DHCP_RELAY_DEF_CIRCUIT_ID = "{mac}"
+	DHCP_RELAY_SUBOPT_CIRCUIT = 1
+	DHCP_RELAY_SUBOPT_REMOTE  = 2
+	DHCP_RELAY_MAX_HOPS       = 16
+)
+
+type DhcpRelayClientInit struct {
+	Mac       core.MACKey `json:"mac" validate:"required"`
+	CircuitId *string     `json:"circuit_id"`
+	RemoteId  *string     `json:"remote_id"`
+}
+
+type DhcpRelayInit struct {
+	RelayMac  core.MACKey           `json:"relay_mac" validate:"required"`
+	Server    core.Ipv4Key          `json:"server" validate:"required"`
+	CircuitId string                `json:"circuit_id"`
+	RemoteId  string                `json:"remote_id"`
+	Clients   []DhcpRelayClientInit `json:"clients" validate:"dive"`
+}
+
+type DhcpRelayStats struct {
+	pktTxRelayed          uint64
+	pktRxReply            uint64
+	pktRxParserErr        uint64
+	pktRxWrongGiaddr      uint64
+	pktRxNoClient         uint64
+	pktRxOption82Mismatch uint64
+	pktTxHopsExceeded     uint64
+	errNoRelay            uint64
+	errSocket             uint64
+	errUnresolved         uint64
+	errInitJson           uint64
+}
+
+func NewDhcpRelayStatsDb(o *DhcpRelayStats) *core.CCounterDb {
+	db := core.NewCCounterDb("dhcprelay")
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTxRelayed,
+		Name:     "pktTxRelayed",
+		Help:     "tx client messages to the server",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxReply,
+		Name:     "pktRxReply",
+		Help:     "rx server replies",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxParserErr,
+		Name:     "pktRxParserErr",
+		Help:     "parser error",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxWrongGiaddr,
+		Name:     "pktRxWrongGiaddr",
+		Help:     "rx reply with giaddr of another relay",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxNoClient,
+		Name:     "pktRxNoClient",
+		Help:     "rx reply without a relayed dhcp client for chaddr",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxOption82Mismatch,
+		Name:     "pktRxOption82Mismatch",
+		Help:     "rx reply with option 82 that is not of the client",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTxHopsExceeded,
+		Name:     "pktTxHopsExceeded",
+		Help:     "message with too many hops",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.errNoRelay,
+		Name:     "errNoRelay",
+		Help:     "relay client does not exist or does not have an ipv4",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.errSocket,
+		Name:     "errSocket",
+		Help:     "can't open the relay sockets",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.errUnresolved,
+		Name:     "errUnresolved",
+		Help:     "server MAC is not resolved",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.errInitJson,
+		Name:     "errInitJson",
+		Help:     "init json is not valid, relay is disabled",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	return db
+}
+
+// PluginDhcpRelayNs DHCP relay agent per namespace
+type PluginDhcpRelayNs struct {
+	core.PluginBase
+	init     DhcpRelayInit
+	enable   bool
+	clients  map[core.MACKey]*DhcpRelayClientInit
+	relay    *core.CClient // the client of the sockets
+	ipv4     core.Ipv4Key  // giaddr
+	socket   transport.SocketApi
+	accepted []transport.SocketApi
+	stats    DhcpRelayStats
+	cdb      *core.CCounterDb
+	cdbv     *core.CCounterDbVec
+}
+
+func NewDhcpRelayNs(ctx *core.PluginCtx, initJson []byte) *core.PluginBase {
+	o := new(PluginDhcpRelayNs)
+	o.InitPluginBase(ctx, o)
+	o.RegisterEvents(ctx, []string{}, o)
+	o.cdb = NewDhcpRelayStatsDb(&o.stats)
+	o.cdbv = core.NewCCounterDbVec("dhcprelay")
+	o.cdbv.Add(o.cdb)
+	o.clients = make(map[core.MACKey]*DhcpRelayClientInit)
+
+	o.init = DhcpRelayInit{CircuitId: DHCP_RELAY_DEF_CIRCUIT_ID}
+	err := o.Tctx.UnmarshalValidate(initJson, &o.init)
+	if err != nil {
+		o.stats.errInitJson++
+		return &o.PluginBase
+	}
+	for i := range o.init.Clients {
+		c := &o.init.Clients[i]
+		o.clients[c.Mac] = c
+	}
+	o.enable = true
+	return &o.PluginBase
+}
+
+func (o *PluginDhcpRelayNs) GetCounterDbVec() *core.CCounterDbVec {
+	return o.cdbv
+}
+
+func (o *PluginDhcpRelayNs) OnRemove(ctx *core.PluginCtx) {
+	o.close()
+}
+
+func (o *PluginDhcpRelayNs) OnEvent(msg string, a, b interface{}) {
+
+}
+
+// close closes the sockets in case the relay client still exists, otherwise they were removed with it
+func (o *PluginDhcpRelayNs) close() {
+	if o.relay == nil {
+		return
+	}
+	if o.Ns.CLookupByMac(&o.init.RelayMac) == o.relay {
+		o.socket.Close()
+		for _, s := range o.accepted {
+			s.Close()
+		}
+		transport.GetTransportCtx(o.relay).UnListen("udp", ":67", o)
+	}
+	o.relay = nil
+	o.socket = nil
+	o.accepted = nil
+}
+
+// open opens the socket to the server and listens to the replies on UDP port 67 of the relay client
+func (o *PluginDhcpRelayNs) open() bool {
+	relay := o.Ns.CLookupByMac(&o.init.RelayMac)
+	if relay == nil || relay.Ipv4.IsZero() {
+		o.close()
+		o.stats.errNoRelay++
+		return false
+	}
+	if relay == o.relay && relay.Ipv4 == o.ipv4 {
+		return true
+	}
+	o.close()
+
+	// the transport plugin of the client gets the rx packets
+	relay.PluginCtx.GetOrCreate(transport.TRANS_PLUG)
+	ctx := transport.GetTransportCtx(relay)
+	if err := ctx.Listen("udp", ":67", o); err != nil {
+		o.stats.errSocket++
+		return false
+	}
+	cb := &dhcpRelaySocket{relay: o}
+	s, err := ctx.Dial("udp", net.JoinHostPort(o.init.Server.ToIP().String(), "67"), cb, nil, nil)
+	if err != nil {
+		ctx.UnListen("udp", ":67", o)
+		o.stats.errSocket++
+		return false
+	}
+	cb.socket = s
+	o.relay = relay
+	o.ipv4 = relay.Ipv4
+	o.socket = s
+	return true
+}
+
+// isRelayed returns true in case the messages of the client are relayed
+func (o *PluginDhcpRelayNs) isRelayed(mac *core.MACKey) bool {
+	_, ok := o.clients[*mac]
+	return ok && *mac != o.init.RelayMac
+}
+
+// getOption82 returns the relay agent information of the client
+func (o *PluginDhcpRelayNs) getOption82(mac *core.MACKey) []byte {
+	circuit, remote := o.init.CircuitId, o.init.RemoteId
+	if c, ok := o.clients[*mac]; ok {
+		if c.CircuitId != nil {
+			circuit = *c.CircuitId
+		}
+		if c.RemoteId != nil {
+			remote = *c.RemoteId
+		}
+	}
+	var b []byte
+	smac := net.HardwareAddr(mac[:]).String()
+	for _, sub := range []struct {
+		code byte
+		val  string
+	}{{DHCP_RELAY_SUBOPT_CIRCUIT, circuit}, {DHCP_RELAY_SUBOPT_REMOTE, remote}} {
+		v := strings.Replace(sub.val, "{mac}", smac, -1)
+		if len(v) == 0 {
+			continue
+		}
+		if len(v) > 255 {
+			v = v[:255]
+		}
+		b = append(b, sub.code, byte(len(v)))
+		b = append(b, v...)
+	}
+	return b
+}
+
+// relayToServer relays the DHCP message of a client, giaddr/hops are updated and option 82 is added before the end option
+func (o *PluginDhcpRelayNs) relayToServer(c *PluginDhcpClient, msg []byte) {
+	if !o.enable || !o.open() {
+		return
+	}
+	if len(msg) < 240 {
+		return
+	}
+	if msg[3] >= DHCP_RELAY_MAX_HOPS {
+		o.stats.pktTxHopsExceeded++
+		return
+	}
+	d := append([]byte(nil), msg...)
+	d[3]++
+	copy(d[24:28], o.ipv4[:])
+
+	end := len(d)
+	for i := 240; i < len(d); {
+		code := d[i]
+		if code == byte(layers.DHCPOptEnd) {
+			end = i
+			break
+		}
+		if code == byte(layers.DHCPOptPad) {
+			i++
+			continue
+		}
+		if i+1 >= len(d) {
+			break
+		}
+		i += 2 + int(d[i+1])
+	}
+	op82 := o.getOption82(&c.Client.Mac)
+	var pkt []byte
+	pkt = append(pkt, d[:end]...)
+	if len(op82) > 0 {
+		pkt = append(pkt, DHCP_OPT_RELAY_AGENT, byte(len(op82)))
+		pkt = append(pkt, op82...)
+	}
+	pkt = append(pkt, byte(layers.DHCPOptEnd))
+
+	res, _ := o.socket.Write(pkt)
+	if res != transport.SeOK {
+		if res == transport.SeUNRESOLVED {
+			o.stats.errUnresolved++
+		} else {
+			o.stats.errSocket++
+		}
+		return
+	}
+	o.stats.pktTxRelayed++
+}
+
+// dhcpRelaySocket the callback of a socket of the relay, the replies are handled with the local address of the socket
+type dhcpRelaySocket struct {
+	relay  *PluginDhcpRelayNs
+	socket transport.SocketApi
+}
+
+func (o *dhcpRelaySocket) OnRxEvent(event transport.SocketEventType) {}
+func (o *dhcpRelaySocket) OnTxEvent(event transport.SocketEventType) {}
+
+// OnRxData the flow of the socket is matched by the destination of the packet, it is the local address of the socket
+func (o *dhcpRelaySocket) OnRxData(d []byte) {
+	var dst core.Ipv4Key
+	if host, _, err := net.SplitHostPort(o.socket.LocalAddr().String()); err == nil {
+		dst = convert(net.ParseIP(host).To4())
+	}
+	o.relay.onRxReply(d, dst)
+}
+
+// OnAccept server replies to port 67 of the relay
+func (o *PluginDhcpRelayNs) OnAccept(socket transport.SocketApi) transport.ISocketCb {
+	o.accepted = append(o.accepted, socket)
+	return &dhcpRelaySocket{relay: o, socket: socket}
+}
+
+// onRxReply passes the reply to the client of chaddr, dst is the destination IPv4 of the packet
+func (o *PluginDhcpRelayNs) onRxReply(d []byte, dst core.Ipv4Key) {
+	var dhcph layers.DHCPv4
+	if len(d) < 240 || dhcph.DecodeFromBytes(d, gopacket.NilDecodeFeedback) != nil ||
+		dhcph.Operation != layers.DHCPOpReply || len(dhcph.ClientHWAddr) != 6 {
+		o.stats.pktRxParserErr++
+		return
+	}
+	o.stats.pktRxReply++
+	if o.relay == nil || convert(dhcph.RelayAgentIP.To4()) != o.ipv4 {
+		o.stats.pktRxWrongGiaddr++
+		return
+	}
+	var mac core.MACKey
+	copy(mac[:], dhcph.ClientHWAddr)
+	client := o.Ns.CLookupByMac(&mac)
+	if client == nil || !o.isRelayed(&mac) {
+		o.stats.pktRxNoClient++
+		return
+	}
+	cplg := client.PluginCtx.Get(DHCP_PLUG)
+	if cplg == nil {
+		o.stats.pktRxNoClient++
+		return
+	}
+
+	// option 82 is not forwarded to the client
+	for i, op := range dhcph.Options {
+		if op.Type == DHCP_OPT_RELAY_AGENT {
+			if !bytes.Equal(op.Data, o.getOption82(&mac)) {
+				o.stats.pktRxOption82Mismatch++
+			}
+			dhcph.Options = append(dhcph.Options[:i], dhcph.Options[i+1:]...)
+			break
+		}
+	}
+	cplg.Ext.(*PluginDhcpClient).handleRxDhcp(&dhcph, dst.Uint32(), &o.relay.Mac, true)
+}
+
+// getRelayNs returns the relay of the namespace, nil in case there isn't one
+func getRelayNs(ns *core.CNSCtx) *PluginDhcpRelayNs {
+	nsplg := ns.PluginCtx.Get(DHCPRELAY_PLUG)
+	if nsplg == nil {
+		return nil
+	}
+	return nsplg.Ext.(*PluginDhcpRelayNs)
+}
+
+type PluginDhcpRelayNsReg struct{}
+
+func (o PluginDhcpRelayNsReg) NewPlugin(ctx *core.PluginCtx, initJson []byte) *core.PluginBase {
+	return NewDhcpRelayNs(ctx, initJson)
+}
+
+/*******************************************/
+/*  RPC commands */
+type (
+	ApiDhcpRelayNsCntHandler struct{}
+)
+
+func (h ApiDhcpRelayNsCntHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	var p core.ApiCntParams
+	tctx := ctx.(*core.CThreadCtx)
+	plug, err := tctx.GetNsPlugin(params, DHCPRELAY_PLUG)
+
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+	relay := plug.Ext.(*PluginDhcpRelayNs)
+	return relay.cdbv.GeneralCounters(err, tctx, params, &p)
+}
+
+func init() {
+	core.PluginRegister(DHCPRELAY_PLUG,
+		core.PluginRegisterData{Client: nil,
+			Ns:     PluginDhcpRelayNsReg{},
+			Thread: nil})
+
+	core.RegisterCB("dhcprelay_ns_cnt", ApiDhcpRelayNsCntHandler{}, false) // get counters/meta
+}
@@ -0,0 +1,111 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package dhcp
+
+import (
+	"emu/core"
+	"emu/plugins/transport"
+	"encoding/hex"
+	"testing"
+	"time"
+)
+
+type VethDhcpRelaySim struct {
+	tx int
+}
+
+func (o *VethDhcpRelaySim) ProcessTxToRx(m *core.Mbuf) *core.Mbuf {
+	o.tx++
+	return m
+}
+
+func createDhcpRelaySimulationEnv(simVeth *VethDhcpRelaySim) (*core.CThreadCtx, *core.CNSCtx) {
+	var simrx core.VethIFSim
+	simrx = simVeth
+	tctx := core.NewThreadCtx(0, 4510, true, &simrx)
+	var key core.CTunnelKey
+	key.Set(&core.CTunnelData{Vport: 1, Vlans: [2]uint32{0x81000001, 0x81000002}})
+	ns := core.NewNSCtx(tctx, &key)
+	tctx.AddNs(&key, ns)
+
+	server := core.NewClient(ns, core.MACKey{0, 0, 1, 0, 0, 1},
+		core.Ipv4Key{16, 0, 0, 1},
+		core.Ipv6Key{},
+		core.Ipv4Key{})
+	ns.AddClient(server)
+	ns.PluginCtx.CreatePlugins([]string{"dhcp", "dhcpsrv"}, [][]byte{nil, []byte(dhcpSrvTestJson)})
+	Register(tctx)
+	transport.Register(tctx)
+	return tctx, ns
+}
+
+func TestDhcpRelay(t *testing.T) {
+	var simVeth VethDhcpRelaySim
+	tctx, ns := createDhcpRelaySimulationEnv(&simVeth)
+	defer tctx.Delete()
+
+	relayJson := `{"relay_mac": [0, 0, 1, 0, 0, 9], "server": [16, 0, 0, 1], "remote_id": "trex",
+		"clients": [{"mac": [0, 0, 1, 0, 0, 2], "circuit_id": "eth1/1"}, {"mac": [0, 0, 1, 0, 0, 4]}]}`
+	ns.PluginCtx.CreatePlugins([]string{"dhcprelay"}, [][]byte{[]byte(relayJson)})
+	relay := core.NewClient(ns, core.MACKey{0, 0, 1, 0, 0, 9}, core.Ipv4Key{48, 0, 0, 1}, core.Ipv6Key{}, core.Ipv4Key{})
+	relay.ForceDGW = true
+	relay.Ipv4ForcedgMac = core.MACKey{0, 0, 1, 0, 0, 1}
+	ns.AddClient(relay)
+
+	// the last client is not in the clients of the relay
+	macs := []core.MACKey{{0, 0, 1, 0, 0, 2}, {0, 0, 1, 0, 0, 4}, {0, 0, 1, 0, 0, 6}}
+	for _, mac := range macs {
+		c := core.NewClient(ns, mac, core.Ipv4Key{}, core.Ipv6Key{}, core.Ipv4Key{})
+		ns.AddClient(c)
+		c.PluginCtx.CreatePlugins([]string{"dhcp"}, [][]byte{})
+	}
+	tctx.MainLoopSim(10 * time.Second)
+
+	srv := getDhcpSrv(ns)
+	relayPlug := getRelayNs(ns)
+	// pool of the relay subnet, the option 82 of each client
+	exp := []core.Ipv4Key{{48, 0, 0, 2}, {48, 0, 0, 3}}
+	op82 := []string{"\x01\x06eth1/1\x02\x04trex", "\x01\x1100:00:01:00:00:04\x02\x04trex"}
+	for i, mac := range macs[:2] {
+		c := ns.CLookupByMac(&mac)
+		if c.Ipv4 != exp[i] {
+			t.Fatalf(" client %v got %v ", mac, c.Ipv4)
+		}
+		l := srv.leases[mac]
+		if l == nil || l.state != DHCPSRV_LEASE_BOUND || l.giaddr != relay.Ipv4 || string(l.option82) != op82[i] {
+			t.Fatalf(" lease of %v is not as expected %+v ", mac, l)
+		}
+		if rec := srv.getLeaseRec(l); rec.Option82 != hex.EncodeToString([]byte(op82[i])) {
+			t.Fatalf(" lease record is not as expected %+v ", rec)
+		}
+	}
+	if l := srv.leases[macs[2]]; l == nil || !l.giaddr.IsZero() || ns.CLookupByMac(&macs[2]).Ipv4 != (core.Ipv4Key{16, 0, 0, 10}) {
+		t.Fatalf(" client that is not relayed should get a lease directly %+v ", l)
+	}
+	// discover/request of each client, all the replies are back to the clients
+	st := &relayPlug.stats
+	if st.pktTxRelayed != 4 || st.pktRxReply != 4 || st.pktRxOption82Mismatch != 0 || simVeth.tx == 0 {
+		t.Fatalf(" unexpected relay counters %+v ", *st)
+	}
+	if srv.stats.pktRxRelayed != 4 || srv.stats.pktTxAck != 3 {
+		t.Fatalf(" unexpected server counters %+v ", srv.stats)
+	}
+
+	// the release is relayed as well
+	ns.RemoveClient(ns.CLookupByMac(&macs[1]))
+	tctx.MainLoopSim(time.Second)
+	if srv.leases[macs[1]] != nil || st.pktTxRelayed != 5 {
+		t.Fatalf(" lease should be released %+v ", srv.stats)
+	}
+
+	// without the relay client the messages are dropped
+	ns.RemoveClient(relay)
+	c := ns.CLookupByMac(&macs[0])
+	c.PluginCtx.Get(DHCP_PLUG).Ext.(*PluginDhcpClient).SendDiscover()
+	if st.errNoRelay != 1 || relayPlug.relay != nil {
+		t.Fatalf(" message should be dropped %+v ", *st)
+	}
+}
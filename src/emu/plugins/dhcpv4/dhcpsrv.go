@@ -0,0 +1,1136 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package dhcp
+
+/*
+RFC 2131 DHCP server, namespace plugin
+
+The server uses the MAC/IPv4 of one of the clients of the namespace (server_mac), this client should have the
+ARP/ICMP plugins in case the server is accessed by a relay. Requests with giaddr are answered to the relay (UDP port 67)
+and the pool is selected by the subnet of giaddr, otherwise by the subnet of the server.
+Leases are kept by the client MAC with a timer, offered leases are removed after the offer timeout and bound leases after the lease time.
+A declined address (RFC 2131 4.3.3) is kept out of the pool for the decline time, the client is offered another address.
+Option 82 of the request is echoed in the reply (RFC 3046).
+
+ns inijson {
+	"server_mac": [0, 0, 1, 0, 0, 1],
+	"lease": 86400,
+	"offer": 60,
+	"decline": 600,
+	"echo_option82": true,
+	"pools": [{"min": [16, 0, 0, 10], "max": [16, 0, 0, 100], "prefix": 24,
+			   "router": [16, 0, 0, 1], "dns": [[8, 8, 8, 8]], "domain": "trex.local", "lease": 3600, "options": [[42, 16, 0, 0, 1]]}],
+	"static": [{"mac": [0, 0, 1, 0, 0, 5], "ipv4": [16, 0, 0, 5]}]
+}
+
+*/
+
+import (
+	"emu/core"
+	"encoding/binary"
+	"encoding/hex"
+	"external/google/gopacket"
+	"external/google/gopacket/layers"
+	"external/osamingo/jsonrpc"
+	"fmt"
+	"net"
+	"time"
+	"unsafe"
+
+	"github.com/intel-go/fastjson"
+)
+
+const (
+	DHCPSRV_PLUG            = "dhcpsrv"
+	DHCPSRV_DEF_LEASE_SEC   = 86400
+	DHCPSRV_DEF_OFFER_SEC   = 60
+	DHCPSRV_DEF_DECLINE_SEC = 600
+	DHCP_OPT_RELAY_AGENT    = 82
+	DHCP_FLAGS_BROADCAST    = 0x8000
+	DHCP_SERVER_PORT        = 67
+	DHCP_CLIENT_PORT        = 68
+
+	/* lease state */
+	DHCPSRV_LEASE_OFFERED  = 1
+	DHCPSRV_LEASE_BOUND    = 2
+	DHCPSRV_LEASE_DECLINED = 3 // the address is in use by another host, not the lease of the client
+)
+
+type DhcpSrvPoolInit struct {
+	Min     core.Ipv4Key   `json:"min" validate:"required"`
+	Max     core.Ipv4Key   `json:"max" validate:"required"`
+	Prefix  uint8          `json:"prefix" validate:"required,gte=1,lte=32"`
+	Router  core.Ipv4Key   `json:"router"`
+	Dns     []core.Ipv4Key `json:"dns"`
+	Domain  string         `json:"domain"`
+	Lease   uint32         `json:"lease"`   // lease time in sec, zero for the server lease time
+	Options [][]byte       `json:"options"` // raw options, the first byte is the type
+}
+
+type DhcpSrvStaticInit struct {
+	Mac  core.MACKey  `json:"mac" validate:"required"`
+	Ipv4 core.Ipv4Key `json:"ipv4" validate:"required"`
+}
+
+type DhcpSrvInit struct {
+	ServerMac    core.MACKey         `json:"server_mac" validate:"required"`
+	Lease        uint32              `json:"lease"`
+	Offer        uint32              `json:"offer"`
+	Decline      uint32              `json:"decline"` // sec a declined address is kept out of the pool
+	EchoOption82 bool                `json:"echo_option82"`
+	Pools        []DhcpSrvPoolInit   `json:"pools" validate:"required,dive"`
+	Static       []DhcpSrvStaticInit `json:"static" validate:"dive"`
+}
+
+type DhcpSrvStats struct {
+	pktRxDiscover     uint64
+	pktRxRequest      uint64
+	pktRxRelease      uint64
+	pktRxDecline      uint64
+	pktRxInform       uint64
+	pktRxRelayed      uint64
+	pktRxOption82     uint64
+	pktRxOtherServer  uint64
+	pktRxNoLease      uint64
+	pktRxLenErr       uint64
+	pktRxParserErr    uint64
+	pktRxUnhandle     uint64
+	pktTxOffer        uint64
+	pktTxAck          uint64
+	pktTxNak          uint64
+	errNoServer       uint64
+	errNoPool         uint64
+	errPoolEmpty      uint64
+	errInitJson       uint64
+	leaseActive       uint64
+	leaseAdd          uint64
+	leaseRemove       uint64
+	leaseExpired      uint64
+	leaseOfferTimeout uint64
+	leaseDeclineEnd   uint64
+}
+
+func NewDhcpSrvStatsDb(o *DhcpSrvStats) *core.CCounterDb {
+	db := core.NewCCounterDb("dhcpsrv")
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxDiscover,
+		Name:     "pktRxDiscover",
+		Help:     "rx discover",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxRequest,
+		Name:     "pktRxRequest",
+		Help:     "rx request",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxRelease,
+		Name:     "pktRxRelease",
+		Help:     "rx release",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxDecline,
+		Name:     "pktRxDecline",
+		Help:     "rx decline",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxInform,
+		Name:     "pktRxInform",
+		Help:     "rx inform",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxRelayed,
+		Name:     "pktRxRelayed",
+		Help:     "rx from a relay, giaddr is set",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxOption82,
+		Name:     "pktRxOption82",
+		Help:     "rx with relay agent information option",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxOtherServer,
+		Name:     "pktRxOtherServer",
+		Help:     "rx request for another server",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxNoLease,
+		Name:     "pktRxNoLease",
+		Help:     "rx request without a lease, ignored",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxLenErr,
+		Name:     "pktRxLenErr",
+		Help:     "len error",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxParserErr,
+		Name:     "pktRxParserErr",
+		Help:     "parser error",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxUnhandle,
+		Name:     "pktRxUnhandle",
+		Help:     "unhandle dhcp packet",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTxOffer,
+		Name:     "pktTxOffer",
+		Help:     "tx offer",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTxAck,
+		Name:     "pktTxAck",
+		Help:     "tx ack",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTxNak,
+		Name:     "pktTxNak",
+		Help:     "tx nak",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.errNoServer,
+		Name:     "errNoServer",
+		Help:     "server client does not exist or does not have an ipv4",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.errNoPool,
+		Name:     "errNoPool",
+		Help:     "no pool for the subnet of the relay",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.errPoolEmpty,
+		Name:     "errPoolEmpty",
+		Help:     "no free address in the pool",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.errInitJson,
+		Name:     "errInitJson",
+		Help:     "init json is not valid, server is disabled",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.leaseActive,
+		Name:     "leaseActive",
+		Help:     "active leases",
+		Unit:     "leases",
+		DumpZero: false,
+		Gauge:    true,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.leaseAdd,
+		Name:     "leaseAdd",
+		Help:     "lease add",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.leaseRemove,
+		Name:     "leaseRemove",
+		Help:     "lease remove",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.leaseExpired,
+		Name:     "leaseExpired",
+		Help:     "bound lease expired",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.leaseOfferTimeout,
+		Name:     "leaseOfferTimeout",
+		Help:     "offered lease without a request",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.leaseDeclineEnd,
+		Name:     "leaseDeclineEnd",
+		Help:     "declined address returned to the pool",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	return db
+}
+
+type dhcpSrvPool struct {
+	min   uint32
+	max   uint32
+	mask  uint32
+	next  uint32
+	lease uint32
+	cfg   *DhcpSrvPoolInit
+}
+
+// inSubnet returns true in case ip is in the subnet of the pool
+func (o *dhcpSrvPool) inSubnet(ip uint32) bool {
+	return (ip & o.mask) == (o.min & o.mask)
+}
+
+type dhcpSrvLease struct {
+	dlist    core.DList // must be first
+	timer    core.CHTimerObj
+	mac      core.MACKey
+	ipv4     core.Ipv4Key
+	state    uint8
+	static   bool
+	expire   uint64 // ticks
+	hostname string
+	giaddr   core.Ipv4Key
+	option82 []byte
+}
+
+func covertToDhcpSrvLease(dlist *core.DList) *dhcpSrvLease {
+	return (*dhcpSrvLease)(unsafe.Pointer(dlist))
+}
+
+// DhcpSrvLeaseRec lease information for the RPC
+type DhcpSrvLeaseRec struct {
+	Mac       core.MACKey  `json:"mac"`
+	Ipv4      core.Ipv4Key `json:"ipv4"`
+	State     string       `json:"state"`
+	Static    bool         `json:"static"`
+	Remaining uint32       `json:"remaining"` // sec
+	Hostname  string       `json:"hostname,omitempty"`
+	Giaddr    core.Ipv4Key `json:"giaddr"`
+	Option82  string       `json:"option82,omitempty"` // hex
+}
+
+// dhcpSrvReq the fields of a request that are required for the reply
+type dhcpSrvReq struct {
+	dhcph    layers.DHCPv4
+	mt       layers.DHCPMsgType
+	srcMac   core.MACKey // L2 source, the relay or the client
+	mac      core.MACKey // chaddr
+	ciaddr   core.Ipv4Key
+	giaddr   core.Ipv4Key
+	reqIp    core.Ipv4Key
+	serverId *core.Ipv4Key
+	hostname string
+	option82 []byte
+}
+
+// PluginDhcpSrvNs DHCP server per namespace
+type PluginDhcpSrvNs struct {
+	core.PluginBase
+	init       DhcpSrvInit
+	enable     bool
+	timerw     *core.TimerCtx
+	pools      []*dhcpSrvPool
+	static     map[core.MACKey]core.Ipv4Key
+	staticIps  map[core.Ipv4Key]bool
+	leases     map[core.MACKey]*dhcpSrvLease
+	ips        map[core.Ipv4Key]*dhcpSrvLease
+	head       core.DList
+	activeIter *core.DList
+	iterReady  bool
+	stats      DhcpSrvStats
+	cdb        *core.CCounterDb
+	cdbv       *core.CCounterDbVec
+}
+
+func NewDhcpSrvNs(ctx *core.PluginCtx, initJson []byte) *core.PluginBase {
+	o := new(PluginDhcpSrvNs)
+	o.InitPluginBase(ctx, o)
+	o.RegisterEvents(ctx, []string{}, o)
+	o.timerw = ctx.Tctx.GetTimerCtx()
+	o.cdb = NewDhcpSrvStatsDb(&o.stats)
+	o.cdbv = core.NewCCounterDbVec("dhcpsrv")
+	o.cdbv.Add(o.cdb)
+	o.static = make(map[core.MACKey]core.Ipv4Key)
+	o.staticIps = make(map[core.Ipv4Key]bool)
+	o.leases = make(map[core.MACKey]*dhcpSrvLease)
+	o.ips = make(map[core.Ipv4Key]*dhcpSrvLease)
+	o.head.SetSelf()
+
+	o.init = DhcpSrvInit{Lease: DHCPSRV_DEF_LEASE_SEC, Offer: DHCPSRV_DEF_OFFER_SEC, Decline: DHCPSRV_DEF_DECLINE_SEC,
+		EchoOption82: true}
+	err := o.Tctx.UnmarshalValidate(initJson, &o.init)
+	if err != nil {
+		o.stats.errInitJson++
+		return &o.PluginBase
+	}
+	for i := range o.init.Pools {
+		cfg := &o.init.Pools[i]
+		p := &dhcpSrvPool{min: cfg.Min.Uint32(), max: cfg.Max.Uint32(), cfg: cfg}
+		if p.min > p.max {
+			o.stats.errInitJson++
+			return &o.PluginBase
+		}
+		p.mask = ^uint32(0) << (32 - cfg.Prefix)
+		p.next = p.min
+		p.lease = cfg.Lease
+		if p.lease == 0 {
+			p.lease = o.init.Lease
+		}
+		o.pools = append(o.pools, p)
+	}
+	for _, s := range o.init.Static {
+		o.static[s.Mac] = s.Ipv4
+		o.staticIps[s.Ipv4] = true
+	}
+	o.enable = true
+	return &o.PluginBase
+}
+
+func (o *PluginDhcpSrvNs) GetCounterDbVec() *core.CCounterDbVec {
+	return o.cdbv
+}
+
+func (o *PluginDhcpSrvNs) OnRemove(ctx *core.PluginCtx) {
+	for _, l := range o.ips {
+		o.removeLease(l)
+	}
+}
+
+func (o *PluginDhcpSrvNs) OnEvent(msg string, a, b interface{}) {
+
+}
+
+/* OnEvent lease timer callback */
+func (o *PluginDhcpSrvNs) onLeaseTimer(l *dhcpSrvLease) {
+	switch l.state {
+	case DHCPSRV_LEASE_OFFERED:
+		o.stats.leaseOfferTimeout++
+	case DHCPSRV_LEASE_DECLINED:
+		o.stats.leaseDeclineEnd++
+	default:
+		o.stats.leaseExpired++
+	}
+	o.removeLease(l)
+}
+
+type dhcpSrvLeaseTimer struct{}
+
+func (t dhcpSrvLeaseTimer) OnEvent(a, b interface{}) {
+	a.(*PluginDhcpSrvNs).onLeaseTimer(b.(*dhcpSrvLease))
+}
+
+func (o *PluginDhcpSrvNs) addLease(mac core.MACKey, ipv4 core.Ipv4Key) *dhcpSrvLease {
+	l := new(dhcpSrvLease)
+	l.mac = mac
+	l.ipv4 = ipv4
+	l.static = o.staticIps[ipv4]
+	l.timer.SetCB(dhcpSrvLeaseTimer{}, o, l)
+	o.leases[mac] = l
+	o.ips[ipv4] = l
+	o.head.AddLast(&l.dlist)
+	o.stats.leaseAdd++
+	o.stats.leaseActive++
+	return l
+}
+
+func (o *PluginDhcpSrvNs) removeLease(l *dhcpSrvLease) {
+	if l.timer.IsRunning() {
+		o.timerw.Stop(&l.timer)
+	}
+	if o.activeIter == &l.dlist {
+		// it is going to be removed
+		o.activeIter = l.dlist.Next()
+	}
+	o.head.RemoveNode(&l.dlist)
+	if o.leases[l.mac] == l {
+		delete(o.leases, l.mac) // a declined address is not the lease of the client
+	}
+	delete(o.ips, l.ipv4)
+	o.stats.leaseRemove++
+	o.stats.leaseActive--
+}
+
+// declineLease keeps the address out of the pool for the decline time, the client gets a new lease
+func (o *PluginDhcpSrvNs) declineLease(l *dhcpSrvLease) {
+	delete(o.leases, l.mac)
+	o.startLease(l, DHCPSRV_LEASE_DECLINED, o.init.Decline)
+}
+
+// startLease moves the lease to state and restart its timer
+func (o *PluginDhcpSrvNs) startLease(l *dhcpSrvLease, state uint8, sec uint32) {
+	if l.timer.IsRunning() {
+		o.timerw.Stop(&l.timer)
+	}
+	l.state = state
+	ticks := o.timerw.DurationToTicks(time.Duration(sec) * time.Second)
+	l.expire = o.timerw.Ticks + uint64(ticks)
+	o.timerw.StartTicks(&l.timer, ticks)
+}
+
+func (o *PluginDhcpSrvNs) isFree(ipv4 core.Ipv4Key, server *core.CClient) bool {
+	return o.ips[ipv4] == nil && !o.staticIps[ipv4] && ipv4 != server.Ipv4
+}
+
+// allocIpv4 returns the static binding, the requested address in case it is free or the next free address of the pool
+func (o *PluginDhcpSrvNs) allocIpv4(req *dhcpSrvReq, pool *dhcpSrvPool, server *core.CClient) (core.Ipv4Key, bool) {
+	if ipv4, ok := o.static[req.mac]; ok {
+		if l := o.ips[ipv4]; l != nil {
+			o.removeLease(l)
+		}
+		return ipv4, true
+	}
+	r := req.reqIp.Uint32()
+	if r >= pool.min && r <= pool.max && o.isFree(req.reqIp, server) {
+		return req.reqIp, true
+	}
+	var ipv4 core.Ipv4Key
+	size := pool.max - pool.min + 1
+	for i := uint32(0); i < size || size == 0; i++ {
+		ipv4.SetUint32(pool.next)
+		if pool.next == pool.max {
+			pool.next = pool.min
+		} else {
+			pool.next++
+		}
+		if o.isFree(ipv4, server) {
+			return ipv4, true
+		}
+	}
+	return ipv4, false
+}
+
+// getPool returns the pool of the relay subnet or the server subnet
+func (o *PluginDhcpSrvNs) getPool(req *dhcpSrvReq, server *core.CClient) *dhcpSrvPool {
+	key := req.giaddr
+	if key.IsZero() {
+		key = server.Ipv4
+	}
+	for _, p := range o.pools {
+		if p.inSubnet(key.Uint32()) {
+			return p
+		}
+	}
+	if req.giaddr.IsZero() && len(o.pools) > 0 {
+		return o.pools[0]
+	}
+	return nil
+}
+
+// getPoolOf returns the pool of the subnet of the address, def in case there isn't one
+func (o *PluginDhcpSrvNs) getPoolOf(ipv4 core.Ipv4Key, def *dhcpSrvPool) *dhcpSrvPool {
+	for _, p := range o.pools {
+		if p.inSubnet(ipv4.Uint32()) {
+			return p
+		}
+	}
+	return def
+}
+
+func (o *PluginDhcpSrvNs) sendReply(req *dhcpSrvReq, server *core.CClient, mt layers.DHCPMsgType,
+	yiaddr core.Ipv4Key, pool *dhcpSrvPool, lease uint32) {
+
+	dhcph := &layers.DHCPv4{Operation: layers.DHCPOpReply,
+		HardwareType: layers.LinkTypeEthernet,
+		HardwareLen:  6,
+		Xid:          req.dhcph.Xid,
+		Flags:        req.dhcph.Flags,
+		ClientIP:     net.IP{0, 0, 0, 0},
+		YourClientIP: yiaddr.ToIP(),
+		NextServerIP: net.IP{0, 0, 0, 0},
+		RelayAgentIP: req.giaddr.ToIP(),
+		ClientHWAddr: net.HardwareAddr(req.mac[:]),
+		ServerName:   make([]byte, 64), File: make([]byte, 128)}
+	if mt != layers.DHCPMsgTypeNak {
+		dhcph.ClientIP = req.ciaddr.ToIP()
+	}
+	if mt == layers.DHCPMsgTypeNak && !req.giaddr.IsZero() {
+		dhcph.Flags |= DHCP_FLAGS_BROADCAST
+	}
+	dhcph.Options = append(dhcph.Options, layers.NewDHCPOption(layers.DHCPOptMessageType, []byte{byte(mt)}))
+	dhcph.Options = append(dhcph.Options, layers.NewDHCPOption(layers.DHCPOptServerID, server.Ipv4[:]))
+
+	if mt != layers.DHCPMsgTypeNak {
+		if lease > 0 {
+			var b [4]byte
+			binary.BigEndian.PutUint32(b[:], lease)
+			dhcph.Options = append(dhcph.Options, layers.NewDHCPOption(layers.DHCPOptLeaseTime, append([]byte{}, b[:]...)))
+			binary.BigEndian.PutUint32(b[:], lease/2)
+			dhcph.Options = append(dhcph.Options, layers.NewDHCPOption(layers.DHCPOptT1, append([]byte{}, b[:]...)))
+			binary.BigEndian.PutUint32(b[:], uint32(uint64(lease)*7/8))
+			dhcph.Options = append(dhcph.Options, layers.NewDHCPOption(layers.DHCPOptT2, append([]byte{}, b[:]...)))
+		}
+		var mask core.Ipv4Key
+		mask.SetUint32(pool.mask)
+		dhcph.Options = append(dhcph.Options, layers.NewDHCPOption(layers.DHCPOptSubnetMask, mask[:]))
+		cfg := pool.cfg
+		if !cfg.Router.IsZero() {
+			dhcph.Options = append(dhcph.Options, layers.NewDHCPOption(layers.DHCPOptRouter, cfg.Router[:]))
+		}
+		if len(cfg.Dns) > 0 {
+			var dns []byte
+			for _, d := range cfg.Dns {
+				dns = append(dns, d[:]...)
+			}
+			dhcph.Options = append(dhcph.Options, layers.NewDHCPOption(layers.DHCPOptDNS, dns))
+		}
+		if cfg.Domain != "" {
+			dhcph.Options = append(dhcph.Options, layers.NewDHCPOption(layers.DHCPOptDomainName, []byte(cfg.Domain)))
+		}
+		for _, op := range cfg.Options {
+			if len(op) > 0 {
+				dhcph.Options = append(dhcph.Options, layers.NewDHCPOption(layers.DHCPOpt(op[0]), op[1:]))
+			}
+		}
+	}
+	if req.option82 != nil && o.init.EchoOption82 {
+		// should be the last option
+		dhcph.Options = append(dhcph.Options, layers.NewDHCPOption(layers.DHCPOpt(DHCP_OPT_RELAY_AGENT), req.option82))
+	}
+
+	// destination, RFC 2131 4.1
+	broadcast := false
+	dstMac := req.mac
+	dstIp := yiaddr
+	dstPort := uint16(DHCP_CLIENT_PORT)
+	if !req.giaddr.IsZero() {
+		dstMac = req.srcMac
+		dstIp = req.giaddr
+		dstPort = DHCP_SERVER_PORT
+	} else if mt == layers.DHCPMsgTypeNak {
+		broadcast = true
+	} else if !req.ciaddr.IsZero() {
+		dstIp = req.ciaddr
+	} else if (req.dhcph.Flags & DHCP_FLAGS_BROADCAST) != 0 {
+		broadcast = true
+	}
+	if broadcast {
+		dstIp.SetUint32(0xffffffff)
+	}
+
+	d := core.PacketUtlBuild(
+		&layers.IPv4{Version: 4, IHL: 5, TTL: 64, Id: 0xcc,
+			SrcIP:    server.Ipv4.ToIP(),
+			DstIP:    dstIp.ToIP(),
+			Protocol: layers.IPProtocolUDP},
+
+		&layers.UDP{SrcPort: DHCP_SERVER_PORT, DstPort: layers.UDPPort(dstPort)},
+		dhcph,
+	)
+
+	ipv4 := layers.IPv4Header(d[0:20])
+	ipv4.SetLength(uint16(len(d)))
+	ipv4.UpdateChecksum()
+
+	binary.BigEndian.PutUint16(d[24:26], uint16(len(d)-20))
+	binary.BigEndian.PutUint16(d[26:28], 0)
+	cs := layers.PktChecksumTcpUdp(d[20:], 0, ipv4)
+	binary.BigEndian.PutUint16(d[26:28], cs)
+
+	pkt := server.GetL2Header(broadcast, uint16(layers.EthernetTypeIPv4))
+	if !broadcast {
+		copy(pkt[0:6], dstMac[:])
+	}
+	l3 := uint16(len(pkt))
+	pkt = append(pkt, d...)
+
+	switch mt {
+	case layers.DHCPMsgTypeOffer:
+		o.stats.pktTxOffer++
+	case layers.DHCPMsgTypeAck:
+		o.stats.pktTxAck++
+	case layers.DHCPMsgTypeNak:
+		o.stats.pktTxNak++
+	}
+	server.SendBufferFragmented(pkt, l3)
+}
+
+// updateLease saves the information of the last request
+func (o *PluginDhcpSrvNs) updateLease(l *dhcpSrvLease, req *dhcpSrvReq) {
+	l.giaddr = req.giaddr
+	l.option82 = req.option82
+	if req.hostname != "" {
+		l.hostname = req.hostname
+	}
+}
+
+func (o *PluginDhcpSrvNs) handleDiscover(req *dhcpSrvReq, server *core.CClient) {
+	o.stats.pktRxDiscover++
+	pool := o.getPool(req, server)
+	if pool == nil {
+		o.stats.errNoPool++
+		return
+	}
+	l := o.leases[req.mac]
+	if l == nil {
+		ipv4, ok := o.allocIpv4(req, pool, server)
+		if !ok {
+			o.stats.errPoolEmpty++
+			return
+		}
+		l = o.addLease(req.mac, ipv4)
+	}
+	o.updateLease(l, req)
+	pool = o.getPoolOf(l.ipv4, pool)
+	if l.state != DHCPSRV_LEASE_BOUND {
+		o.startLease(l, DHCPSRV_LEASE_OFFERED, o.init.Offer)
+	}
+	o.sendReply(req, server, layers.DHCPMsgTypeOffer, l.ipv4, pool, pool.lease)
+}
+
+func (o *PluginDhcpSrvNs) ack(req *dhcpSrvReq, server *core.CClient, l *dhcpSrvLease, pool *dhcpSrvPool) {
+	o.updateLease(l, req)
+	pool = o.getPoolOf(l.ipv4, pool)
+	o.startLease(l, DHCPSRV_LEASE_BOUND, pool.lease)
+	o.sendReply(req, server, layers.DHCPMsgTypeAck, l.ipv4, pool, pool.lease)
+}
+
+func (o *PluginDhcpSrvNs) nak(req *dhcpSrvReq, server *core.CClient) {
+	o.sendReply(req, server, layers.DHCPMsgTypeNak, core.Ipv4Key{}, nil, 0)
+}
+
+func (o *PluginDhcpSrvNs) handleRequest(req *dhcpSrvReq, server *core.CClient) {
+	o.stats.pktRxRequest++
+	pool := o.getPool(req, server)
+	if pool == nil {
+		o.stats.errNoPool++
+		return
+	}
+	l := o.leases[req.mac]
+
+	if req.serverId != nil {
+		// SELECTING
+		if *req.serverId != server.Ipv4 {
+			o.stats.pktRxOtherServer++
+			if l != nil && l.state == DHCPSRV_LEASE_OFFERED {
+				o.removeLease(l)
+			}
+			return
+		}
+		if l == nil || l.ipv4 != req.reqIp {
+			o.nak(req, server)
+			return
+		}
+		o.ack(req, server, l, pool)
+		return
+	}
+
+	if !req.ciaddr.IsZero() {
+		// RENEWING/REBINDING
+		if l == nil {
+			o.stats.pktRxNoLease++
+			return
+		}
+		if l.ipv4 != req.ciaddr {
+			o.nak(req, server)
+			return
+		}
+		o.ack(req, server, l, pool)
+		return
+	}
+
+	// INIT-REBOOT
+	if !pool.inSubnet(req.reqIp.Uint32()) {
+		o.nak(req, server)
+		return
+	}
+	if l == nil {
+		if ipv4, ok := o.static[req.mac]; ok && ipv4 == req.reqIp {
+			l = o.addLease(req.mac, ipv4)
+		} else {
+			o.stats.pktRxNoLease++
+			return
+		}
+	}
+	if l.ipv4 != req.reqIp {
+		o.nak(req, server)
+		return
+	}
+	o.ack(req, server, l, pool)
+}
+
+// isForServer returns true in case the destination MAC is broadcast or the MAC of the server
+func (o *PluginDhcpSrvNs) isForServer(ps *core.ParserPacketState) bool {
+	var mac core.MACKey
+	copy(mac[:], ps.M.GetData()[0:6])
+	return mac.IsBroadcast() || mac == o.init.ServerMac
+}
+
+func (o *PluginDhcpSrvNs) HandleRxDhcpPacket(ps *core.ParserPacketState) int {
+	if !o.enable {
+		return core.PARSER_ERR
+	}
+	m := ps.M
+	p := m.GetData()
+
+	dhcphlen := ps.L7Len
+	if dhcphlen < 240 {
+		o.stats.pktRxLenErr++
+		return core.PARSER_ERR
+	}
+
+	var req dhcpSrvReq
+	dhcph := &req.dhcph
+	err := dhcph.DecodeFromBytes(p[ps.L7:ps.L7+dhcphlen], gopacket.NilDecodeFeedback)
+	if err != nil {
+		o.stats.pktRxParserErr++
+		return core.PARSER_ERR
+	}
+	if dhcph.Operation != layers.DHCPOpRequest {
+		o.stats.pktRxUnhandle++
+		return core.PARSER_ERR
+	}
+	if dhcph.HardwareType != layers.LinkTypeEthernet || dhcph.HardwareLen != 6 || len(dhcph.ClientHWAddr) != 6 {
+		o.stats.pktRxParserErr++
+		return core.PARSER_ERR
+	}
+
+	server := o.Ns.CLookupByMac(&o.init.ServerMac)
+	if server == nil || server.Ipv4.IsZero() {
+		o.stats.errNoServer++
+		return core.PARSER_ERR
+	}
+
+	copy(req.srcMac[:], p[6:12])
+	copy(req.mac[:], dhcph.ClientHWAddr)
+	req.ciaddr = convert(dhcph.ClientIP.To4())
+	req.giaddr = convert(dhcph.RelayAgentIP.To4())
+	req.mt = layers.DHCPMsgTypeUnspecified
+	var serverId core.Ipv4Key
+
+	for _, op := range dhcph.Options {
+		switch op.Type {
+		case layers.DHCPOptMessageType:
+			if len(op.Data) == 1 {
+				req.mt = layers.DHCPMsgType(op.Data[0])
+			}
+		case layers.DHCPOptRequestIP:
+			if len(op.Data) == 4 {
+				copy(req.reqIp[:], op.Data)
+			}
+		case layers.DHCPOptServerID:
+			if len(op.Data) == 4 {
+				copy(serverId[:], op.Data)
+				req.serverId = &serverId
+			}
+		case layers.DHCPOptHostname:
+			req.hostname = string(op.Data)
+		case DHCP_OPT_RELAY_AGENT:
+			req.option82 = append([]byte{}, op.Data...)
+		}
+	}
+	if !req.giaddr.IsZero() {
+		o.stats.pktRxRelayed++
+	}
+	if req.option82 != nil {
+		o.stats.pktRxOption82++
+	}
+
+	switch req.mt {
+	case layers.DHCPMsgTypeDiscover:
+		o.handleDiscover(&req, server)
+	case layers.DHCPMsgTypeRequest:
+		o.handleRequest(&req, server)
+	case layers.DHCPMsgTypeDecline:
+		o.stats.pktRxDecline++
+		if l := o.leases[req.mac]; l != nil && !l.static {
+			o.declineLease(l)
+		}
+	case layers.DHCPMsgTypeRelease:
+		o.stats.pktRxRelease++
+		if l := o.leases[req.mac]; l != nil && l.ipv4 == req.ciaddr {
+			o.removeLease(l)
+		}
+	case layers.DHCPMsgTypeInform:
+		o.stats.pktRxInform++
+		if pool := o.getPool(&req, server); pool != nil {
+			o.sendReply(&req, server, layers.DHCPMsgTypeAck, core.Ipv4Key{}, o.getPoolOf(req.ciaddr, pool), 0)
+		}
+	default:
+		o.stats.pktRxUnhandle++
+	}
+	return 0
+}
+
+func (o *PluginDhcpSrvNs) getLeaseRec(l *dhcpSrvLease) DhcpSrvLeaseRec {
+	r := DhcpSrvLeaseRec{Mac: l.mac, Ipv4: l.ipv4, Static: l.static, Hostname: l.hostname, Giaddr: l.giaddr}
+	switch l.state {
+	case DHCPSRV_LEASE_BOUND:
+		r.State = "bound"
+	case DHCPSRV_LEASE_DECLINED:
+		r.State = "declined"
+	default:
+		r.State = "offered"
+	}
+	if l.expire > o.timerw.Ticks {
+		r.Remaining = uint32(time.Duration(l.expire-o.timerw.Ticks) * o.timerw.TickDuration / time.Second)
+	}
+	if l.option82 != nil {
+		r.Option82 = hex.EncodeToString(l.option82)
+	}
+	return r
+}
+
+func (o *PluginDhcpSrvNs) IterReset() bool {
+	o.activeIter = o.head.Next()
+	if o.head.IsEmpty() {
+		o.iterReady = false
+		return true
+	}
+	o.iterReady = true
+	return false
+}
+
+func (o *PluginDhcpSrvNs) IterIsStopped() bool {
+	return !o.iterReady
+}
+
+func (o *PluginDhcpSrvNs) GetNext(n uint16) ([]DhcpSrvLeaseRec, error) {
+	r := make([]DhcpSrvLeaseRec, 0)
+
+	if !o.iterReady {
+		return r, fmt.Errorf(" Iterator is not ready- reset the iterator")
+	}
+
+	cnt := 0
+	for {
+		if o.activeIter == &o.head {
+			o.iterReady = false
+			break
+		}
+		cnt++
+		if cnt > int(n) {
+			break
+		}
+		r = append(r, o.getLeaseRec(covertToDhcpSrvLease(o.activeIter)))
+		o.activeIter = o.activeIter.Next()
+	}
+	return r, nil
+}
+
+// HandleRxDhcpSrvPacket Parser call this function with mbuf from the pool
+func HandleRxDhcpSrvPacket(ps *core.ParserPacketState) int {
+	ns := ps.Tctx.GetNs(ps.Tun)
+	if ns == nil {
+		return core.PARSER_ERR
+	}
+	nsplg := ns.PluginCtx.Get(DHCPSRV_PLUG)
+	if nsplg == nil || !nsplg.Ext.(*PluginDhcpSrvNs).isForServer(ps) {
+		// port 67 of the other users of the namespace e.g. the relay of dhcprelay.go
+		return ps.Tctx.HandleUdpDefault(ps)
+	}
+	srvPlug := nsplg.Ext.(*PluginDhcpSrvNs)
+	return srvPlug.HandleRxDhcpPacket(ps)
+}
+
+type PluginDhcpSrvNsReg struct{}
+
+func (o PluginDhcpSrvNsReg) NewPlugin(ctx *core.PluginCtx, initJson []byte) *core.PluginBase {
+	return NewDhcpSrvNs(ctx, initJson)
+}
+
+/*******************************************/
+/*  RPC commands */
+type (
+	ApiDhcpSrvNsCntHandler  struct{}
+	ApiDhcpSrvNsIterHandler struct{}
+	ApiDhcpSrvNsIterParams  struct {
+		Reset bool   `json:"reset"`
+		Count uint16 `json:"count" validate:"required,gte=0,lte=255"`
+	}
+	ApiDhcpSrvNsIterResult struct {
+		Empty   bool              `json:"empty"`
+		Stopped bool              `json:"stopped"`
+		Vec     []DhcpSrvLeaseRec `json:"data"`
+	}
+
+	ApiDhcpSrvNsGetLeaseHandler struct{}
+	ApiDhcpSrvNsGetLeaseParams  struct {
+		Mac core.MACKey `json:"mac" validate:"required"`
+	}
+
+	ApiDhcpSrvNsReleaseHandler struct{}
+	ApiDhcpSrvNsReleaseParams  struct {
+		Macs []core.MACKey `json:"macs" validate:"required"`
+	}
+)
+
+func getSrvNsPlugin(ctx interface{}, params *fastjson.RawMessage) (*PluginDhcpSrvNs, *jsonrpc.Error) {
+	tctx := ctx.(*core.CThreadCtx)
+	plug, err := tctx.GetNsPlugin(params, DHCPSRV_PLUG)
+
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+	return plug.Ext.(*PluginDhcpSrvNs), nil
+}
+
+func (h ApiDhcpSrvNsCntHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	var p core.ApiCntParams
+	tctx := ctx.(*core.CThreadCtx)
+	srv, err := getSrvNsPlugin(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	return srv.cdbv.GeneralCounters(nil, tctx, params, &p)
+}
+
+func (h ApiDhcpSrvNsIterHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	var p ApiDhcpSrvNsIterParams
+	var res ApiDhcpSrvNsIterResult
+	tctx := ctx.(*core.CThreadCtx)
+
+	srv, err := getSrvNsPlugin(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	err1 := tctx.UnmarshalValidate(*params, &p)
+	if err1 != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err1.Error(),
+		}
+	}
+
+	if p.Reset {
+		res.Empty = srv.IterReset()
+	}
+	if res.Empty {
+		return &res, nil
+	}
+	if srv.IterIsStopped() {
+		res.Stopped = true
+		return &res, nil
+	}
+
+	vec, err2 := srv.GetNext(p.Count)
+	if err2 != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err2.Error(),
+		}
+	}
+	res.Vec = vec
+	return &res, nil
+}
+
+func (h ApiDhcpSrvNsGetLeaseHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	var p ApiDhcpSrvNsGetLeaseParams
+	tctx := ctx.(*core.CThreadCtx)
+
+	srv, err := getSrvNsPlugin(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	err1 := tctx.UnmarshalValidate(*params, &p)
+	if err1 != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err1.Error(),
+		}
+	}
+	l := srv.leases[p.Mac]
+	if l == nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: fmt.Sprintf("there is no lease for %v", net.HardwareAddr(p.Mac[:])),
+		}
+	}
+	rec := srv.getLeaseRec(l)
+	return &rec, nil
+}
+
+func (h ApiDhcpSrvNsReleaseHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	var p ApiDhcpSrvNsReleaseParams
+	tctx := ctx.(*core.CThreadCtx)
+
+	srv, err := getSrvNsPlugin(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	err1 := tctx.UnmarshalValidate(*params, &p)
+	if err1 != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err1.Error(),
+		}
+	}
+	for _, mac := range p.Macs {
+		if l := srv.leases[mac]; l != nil {
+			srv.removeLease(l)
+		}
+	}
+	return nil, nil
+}
+
+func init() {
+	core.PluginRegister(DHCPSRV_PLUG,
+		core.PluginRegisterData{Client: nil,
+			Ns:     PluginDhcpSrvNsReg{},
+			Thread: nil})
+
+	core.RegisterCB("dhcpsrv_ns_cnt", ApiDhcpSrvNsCntHandler{}, false)            // get counters/meta
+	core.RegisterCB("dhcpsrv_ns_iter", ApiDhcpSrvNsIterHandler{}, false)          // iterate the leases
+	core.RegisterCB("dhcpsrv_ns_get_lease", ApiDhcpSrvNsGetLeaseHandler{}, false) // lease of a client MAC
+	core.RegisterCB("dhcpsrv_ns_release", ApiDhcpSrvNsReleaseHandler{}, false)    // release leases
+
+	/* register callback for rx side*/
+	core.ParserRegister(DHCPSRV_PLUG, HandleRxDhcpSrvPacket,
+		core.ParserRegisterData{UdpPorts: []uint16{DHCP_SERVER_PORT}})
+}
@@ -0,0 +1,250 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package dhcp
+
+import (
+	"bytes"
+	"emu/core"
+	"encoding/binary"
+	"external/google/gopacket"
+	"external/google/gopacket/layers"
+	"net"
+	"testing"
+	"time"
+)
+
+type VethDhcpSrvSim struct {
+	dropAll bool
+	tx      [][]byte
+}
+
+func (o *VethDhcpSrvSim) ProcessTxToRx(m *core.Mbuf) *core.Mbuf {
+	o.tx = append(o.tx, append([]byte(nil), m.GetData()...))
+	if o.dropAll {
+		m.FreeMbuf()
+		return nil
+	}
+	return m
+}
+
+func createDhcpSrvSimulationEnv(simVeth *VethDhcpSrvSim, initJson string) (*core.CThreadCtx, *core.CNSCtx) {
+	var simrx core.VethIFSim
+	simrx = simVeth
+	tctx := core.NewThreadCtx(0, 4510, true, &simrx)
+	var key core.CTunnelKey
+	key.Set(&core.CTunnelData{Vport: 1, Vlans: [2]uint32{0x81000001, 0x81000002}})
+	ns := core.NewNSCtx(tctx, &key)
+	tctx.AddNs(&key, ns)
+
+	server := core.NewClient(ns, core.MACKey{0, 0, 1, 0, 0, 1},
+		core.Ipv4Key{16, 0, 0, 1},
+		core.Ipv6Key{},
+		core.Ipv4Key{})
+	ns.AddClient(server)
+	ns.PluginCtx.CreatePlugins([]string{"dhcp", "dhcpsrv"}, [][]byte{nil, []byte(initJson)})
+	Register(tctx)
+	return tctx, ns
+}
+
+func getDhcpSrv(ns *core.CNSCtx) *PluginDhcpSrvNs {
+	nsplg := ns.PluginCtx.Get(DHCPSRV_PLUG)
+	if nsplg == nil {
+		panic(" can't find plugin")
+	}
+	return nsplg.Ext.(*PluginDhcpSrvNs)
+}
+
+const dhcpSrvTestJson = `{"server_mac": [0, 0, 1, 0, 0, 1], "lease": 3600,
+	"pools": [{"min": [16, 0, 0, 10], "max": [16, 0, 0, 100], "prefix": 24, "router": [16, 0, 0, 1], "dns": [[8, 8, 8, 8]]},
+			  {"min": [48, 0, 0, 2], "max": [48, 0, 0, 3], "prefix": 24, "lease": 60}],
+	"static": [{"mac": [0, 0, 1, 0, 0, 3], "ipv4": [16, 0, 0, 5]}]}`
+
+func TestDhcpSrvLoopback(t *testing.T) {
+	var simVeth VethDhcpSrvSim
+	tctx, ns := createDhcpSrvSimulationEnv(&simVeth, dhcpSrvTestJson)
+	defer tctx.Delete()
+
+	macs := []core.MACKey{{0, 0, 1, 0, 0, 2}, {0, 0, 1, 0, 0, 3}}
+	for _, mac := range macs {
+		c := core.NewClient(ns, mac, core.Ipv4Key{}, core.Ipv6Key{}, core.Ipv4Key{})
+		ns.AddClient(c)
+		c.PluginCtx.CreatePlugins([]string{"dhcp"}, [][]byte{})
+	}
+	tctx.MainLoopSim(10 * time.Second)
+
+	srv := getDhcpSrv(ns)
+	exp := []core.Ipv4Key{{16, 0, 0, 10}, {16, 0, 0, 5}}
+	for i, mac := range macs {
+		c := ns.CLookupByMac(&mac)
+		if c.Ipv4 != exp[i] || c.DgIpv4 != (core.Ipv4Key{16, 0, 0, 1}) {
+			t.Fatalf(" client %v got %v dg %v ", mac, c.Ipv4, c.DgIpv4)
+		}
+		l := srv.leases[mac]
+		if l == nil || l.state != DHCPSRV_LEASE_BOUND || l.ipv4 != exp[i] {
+			t.Fatalf(" lease of %v is not bound ", mac)
+		}
+	}
+	if !srv.leases[macs[1]].static || srv.stats.leaseActive != 2 || srv.stats.pktTxAck != 2 {
+		t.Fatalf(" unexpected server state %+v ", srv.stats)
+	}
+
+	if srv.IterReset() {
+		t.Fatalf(" iterator should not be empty ")
+	}
+	recs, _ := srv.GetNext(10)
+	if len(recs) != 2 || recs[0].State != "bound" || recs[0].Remaining == 0 || recs[0].Remaining > 3600 {
+		t.Fatalf(" unexpected leases %+v ", recs)
+	}
+
+	// the release of the client removes the lease
+	ns.RemoveClient(ns.CLookupByMac(&macs[0]))
+	tctx.MainLoopSim(time.Second)
+	if srv.leases[macs[0]] != nil || srv.stats.pktRxRelease != 1 || srv.stats.leaseActive != 1 {
+		t.Fatalf(" lease should be released %+v ", srv.stats)
+	}
+	srv.removeLease(srv.leases[macs[1]])
+	if !srv.head.IsEmpty() || len(srv.ips) != 0 {
+		t.Fatalf(" lease table should be empty ")
+	}
+}
+
+func buildDhcpSrvRequest(mt layers.DHCPMsgType, giaddr net.IP, reqIp net.IP, option82 []byte) []byte {
+	dhcph := &layers.DHCPv4{Operation: layers.DHCPOpRequest,
+		HardwareType: layers.LinkTypeEthernet,
+		HardwareLen:  6,
+		Xid:          0x1234,
+		ClientIP:     net.IP{0, 0, 0, 0},
+		YourClientIP: net.IP{0, 0, 0, 0},
+		NextServerIP: net.IP{0, 0, 0, 0},
+		RelayAgentIP: giaddr,
+		ClientHWAddr: net.HardwareAddr{0, 0, 2, 0, 0, 7},
+		ServerName:   make([]byte, 64), File: make([]byte, 128)}
+	dhcph.Options = append(dhcph.Options, layers.NewDHCPOption(layers.DHCPOptMessageType, []byte{byte(mt)}))
+	if reqIp != nil {
+		dhcph.Options = append(dhcph.Options, layers.NewDHCPOption(layers.DHCPOptRequestIP, reqIp.To4()))
+	}
+	if option82 != nil {
+		dhcph.Options = append(dhcph.Options, layers.NewDHCPOption(DHCP_OPT_RELAY_AGENT, option82))
+	}
+
+	d := core.PacketUtlBuild(
+		&layers.IPv4{Version: 4, IHL: 5, TTL: 64, Id: 0xcc,
+			SrcIP:    giaddr,
+			DstIP:    net.IPv4(16, 0, 0, 1),
+			Protocol: layers.IPProtocolUDP},
+
+		&layers.UDP{SrcPort: 67, DstPort: 67},
+		dhcph,
+	)
+	ipv4 := layers.IPv4Header(d[0:20])
+	ipv4.SetLength(uint16(len(d)))
+	ipv4.UpdateChecksum()
+	binary.BigEndian.PutUint16(d[24:26], uint16(len(d)-20))
+	binary.BigEndian.PutUint16(d[26:28], 0)
+
+	l2 := []byte{0, 0, 1, 0, 0, 1, 0, 0, 9, 0, 0, 9, 0x81, 00, 0x00, 0x01, 0x81, 00, 0x00, 0x02, 0x08, 00}
+	return append(l2, d...)
+}
+
+func TestDhcpSrvRelay(t *testing.T) {
+	simVeth := VethDhcpSrvSim{dropAll: true}
+	tctx, ns := createDhcpSrvSimulationEnv(&simVeth, dhcpSrvTestJson)
+	defer tctx.Delete()
+	srv := getDhcpSrv(ns)
+
+	option82 := []byte{1, 4, 'p', 'o', 'r', 't', 2, 2, 0xa, 0xb}
+	giaddr := net.IPv4(48, 0, 0, 1).To4()
+	tctx.Veth.OnRx(genMbuf(tctx, buildDhcpSrvRequest(layers.DHCPMsgTypeDiscover, giaddr, nil, option82)))
+	tctx.Veth.SimulatorCheckRxQueue()
+
+	if len(simVeth.tx) != 1 || srv.stats.pktRxRelayed != 1 || srv.stats.pktRxOption82 != 1 {
+		t.Fatalf(" expected one offer %+v ", srv.stats)
+	}
+	pkt := gopacket.NewPacket(simVeth.tx[0], layers.LayerTypeEthernet, gopacket.Default)
+	eth := pkt.Layer(layers.LayerTypeEthernet).(*layers.Ethernet)
+	ipv4 := pkt.Layer(layers.LayerTypeIPv4).(*layers.IPv4)
+	udp := pkt.Layer(layers.LayerTypeUDP).(*layers.UDP)
+	dhcph := pkt.Layer(layers.LayerTypeDHCPv4).(*layers.DHCPv4)
+	if !bytes.Equal(eth.DstMAC, net.HardwareAddr{0, 0, 9, 0, 0, 9}) || !ipv4.DstIP.Equal(giaddr) ||
+		udp.DstPort != 67 || udp.SrcPort != 67 {
+		t.Fatalf(" offer should be sent to the relay %v %v %v ", eth.DstMAC, ipv4.DstIP, udp.DstPort)
+	}
+	// pool of the relay subnet
+	if !dhcph.YourClientIP.Equal(net.IPv4(48, 0, 0, 2)) || !dhcph.RelayAgentIP.Equal(giaddr) {
+		t.Fatalf(" unexpected yiaddr %v ", dhcph.YourClientIP)
+	}
+	last := dhcph.Options[len(dhcph.Options)-1]
+	if last.Type != DHCP_OPT_RELAY_AGENT || !bytes.Equal(last.Data, option82) {
+		t.Fatalf(" option 82 should be echoed %v ", dhcph.Options)
+	}
+	for _, op := range dhcph.Options {
+		if op.Type == layers.DHCPOptLeaseTime && binary.BigEndian.Uint32(op.Data) != 60 {
+			t.Fatalf(" lease time of the pool should be used ")
+		}
+	}
+	l := srv.leases[core.MACKey{0, 0, 2, 0, 0, 7}]
+	if l == nil || l.state != DHCPSRV_LEASE_OFFERED || !bytes.Equal(l.option82, option82) {
+		t.Fatalf(" lease should be offered ")
+	}
+
+	// init-reboot with an address of another subnet
+	tctx.Veth.OnRx(genMbuf(tctx, buildDhcpSrvRequest(layers.DHCPMsgTypeRequest, giaddr, net.IPv4(16, 0, 0, 50), nil)))
+	tctx.Veth.SimulatorCheckRxQueue()
+	if srv.stats.pktTxNak != 1 || len(simVeth.tx) != 2 {
+		t.Fatalf(" request should be nak %+v ", srv.stats)
+	}
+
+	// no relay for this subnet
+	tctx.Veth.OnRx(genMbuf(tctx, buildDhcpSrvRequest(layers.DHCPMsgTypeDiscover, net.IPv4(32, 0, 0, 1).To4(), nil, nil)))
+	tctx.Veth.SimulatorCheckRxQueue()
+	if srv.stats.errNoPool != 1 || len(simVeth.tx) != 2 {
+		t.Fatalf(" discover should be dropped %+v ", srv.stats)
+	}
+
+	// offer timeout
+	tctx.MainLoopSim(2 * DHCPSRV_DEF_OFFER_SEC * time.Second)
+	if len(srv.leases) != 0 || srv.stats.leaseOfferTimeout != 1 {
+		t.Fatalf(" offered lease should be removed %+v ", srv.stats)
+	}
+}
+
+func TestDhcpSrvDecline(t *testing.T) {
+	simVeth := VethDhcpSrvSim{dropAll: true}
+	tctx, ns := createDhcpSrvSimulationEnv(&simVeth, dhcpSrvTestJson)
+	defer tctx.Delete()
+	srv := getDhcpSrv(ns)
+
+	giaddr := net.IPv4(48, 0, 0, 1).To4()
+	mac := core.MACKey{0, 0, 2, 0, 0, 7}
+	tctx.Veth.OnRx(genMbuf(tctx, buildDhcpSrvRequest(layers.DHCPMsgTypeDiscover, giaddr, nil, nil)))
+	tctx.Veth.SimulatorCheckRxQueue()
+	l := srv.leases[mac]
+	if l == nil || l.ipv4 != (core.Ipv4Key{48, 0, 0, 2}) {
+		t.Fatalf(" lease should be offered ")
+	}
+
+	// the declined address is not offered again
+	tctx.Veth.OnRx(genMbuf(tctx, buildDhcpSrvRequest(layers.DHCPMsgTypeDecline, giaddr, net.IPv4(48, 0, 0, 2), nil)))
+	tctx.Veth.OnRx(genMbuf(tctx, buildDhcpSrvRequest(layers.DHCPMsgTypeDiscover, giaddr, nil, nil)))
+	tctx.Veth.SimulatorCheckRxQueue()
+	if l.state != DHCPSRV_LEASE_DECLINED || srv.ips[core.Ipv4Key{48, 0, 0, 2}] != l || srv.stats.pktRxDecline != 1 {
+		t.Fatalf(" address should be declined %+v ", srv.stats)
+	}
+	if srv.leases[mac] == nil || srv.leases[mac].ipv4 != (core.Ipv4Key{48, 0, 0, 3}) || srv.stats.leaseActive != 2 {
+		t.Fatalf(" client should be offered another address ")
+	}
+	srv.IterReset()
+	recs, _ := srv.GetNext(10)
+	if len(recs) != 2 || recs[0].State != "declined" {
+		t.Fatalf(" unexpected leases %+v ", recs)
+	}
+
+	// the address is back in the pool after the decline time
+	tctx.MainLoopSim(2 * DHCPSRV_DEF_DECLINE_SEC * time.Second)
+	if len(srv.ips) != 0 || srv.stats.leaseDeclineEnd != 1 || srv.stats.leaseOfferTimeout != 1 {
+		t.Fatalf(" declined address should be returned to the pool %+v ", srv.stats)
+	}
+}
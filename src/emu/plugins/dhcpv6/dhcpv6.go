@@ -11,8 +11,17 @@ RFC 8415  DHCPv6 client
 client inijson {
 	TimerDiscoverSec uint32 `json:"timerd"`
 	TimerOfferSec    uint32 `json:"timero"`
+	Pd               {"prefix_len": 56, "clients": [[0, 0, 1, 0, 0, 2]]} `json:"pd"`
+	NoNa             bool `json:"no_na"`
+	Stateless        bool `json:"stateless"`
 }:
 
+pd        - request a delegated prefix (IA_PD) with an optional prefix length hint. The downstream clients of the namespace
+            get a /64 of the prefix by their order, the address is the /64 with the EUI-64 of the client MAC.
+no_na     - do not request an address (IA_NA), e.g. a CPE that requests only a prefix
+stateless - Information-Request for the other configuration (e.g. DNS) without addresses, it is sent again after the
+            information refresh time
+
 */
 
 import (
@@ -50,6 +59,8 @@ const (
 	REQ_MAX_RC             = 10 /* Max Request retry attempts */
 	DEFAULT_TIMEOUT_T1_SEC = 1800
 	DEFAULT_TIMEOUT_T2_SEC = 3600
+	IRT_DEFAULT_SEC        = 86400 /* information refresh time */
+	IRT_MINIMUM_SEC        = 600
 )
 
 type DhcpOptionsT struct {
@@ -62,10 +73,18 @@ type DhcpOptionsT struct {
 	RemoveVC bool    `json:"rm_vc"` // remove default vendor class
 }
 
+type DhcpPdInit struct {
+	PrefixLen uint8         `json:"prefix_len"` // hint for the server, zero for any length
+	Clients   []core.MACKey `json:"clients"`    // downstream clients, each gets a /64 of the prefix
+}
+
 type DhcpInit struct {
 	TimerDiscoverSec uint32        `json:"timerd"`
 	TimerOfferSec    uint32        `json:"timero"`
 	Options          *DhcpOptionsT `json:"options"`
+	Pd               *DhcpPdInit   `json:"pd"`
+	NoNa             bool          `json:"no_na"`
+	Stateless        bool          `json:"stateless"`
 }
 
 type DhcpStats struct {
@@ -96,6 +115,12 @@ type DhcpStats struct {
 	pktRxNotify   uint64
 	pktRxRenew    uint64
 	pktRxRebind   uint64
+
+	pktTxInfoRequest uint64
+	pktRxNoIAPD      uint64
+	pktRxWrongIAPDId uint64
+	pdRxPrefix       uint64
+	pdClients        uint64
 }
 
 func NewDhcpStatsDb(o *DhcpStats) *core.CCounterDb {
@@ -177,6 +202,47 @@ func NewDhcpStatsDb(o *DhcpStats) *core.CCounterDb {
 		DumpZero: false,
 		Info:     core.ScERROR})
 
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTxInfoRequest,
+		Name:     "pktTxInfoRequest",
+		Help:     "tx information request",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxNoIAPD,
+		Name:     "pktRxNoIAPD",
+		Help:     "rx without a valid IA_PD prefix",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxWrongIAPDId,
+		Name:     "pktRxWrongIAPDId",
+		Help:     "rx IA_PD with wrong IAID",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pdRxPrefix,
+		Name:     "pdRxPrefix",
+		Help:     "new delegated prefix",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pdClients,
+		Name:     "pdClients",
+		Help:     "downstream clients with a /64 of the delegated prefix",
+		Unit:     "clients",
+		DumpZero: false,
+		Gauge:    true,
+		Info:     core.ScINFO})
+
 	db.Add(&core.CCounterRec{
 		Counter:  &o.pktRxMissingServerIdOption,
 		Name:     "pktRxMissingServerIdOption",
@@ -340,6 +406,9 @@ type PluginDhcpClient struct {
 	iaid                       uint32
 	serverOption               []byte
 	pktIana                    layers.DHCPv6OptionIANA
+	pktIapd                    layers.DHCPv6OptionIAPD
+	refreshSec                 uint32         // information refresh time
+	pdAddrs                    []core.Ipv6Key // the addresses of the downstream clients, by the order of the pd clients
 }
 
 var dhcpEvents = []string{}
@@ -458,36 +527,77 @@ func (o *PluginDhcpClient) preparePacketTemplate() {
 
 	clientid := &layers.DHCPv6DUID{Type: layers.DHCPv6DUIDTypeLL, HardwareType: []byte{0, 1}, LinkLayerAddress: o.Client.Mac[:]}
 	o.cid = append(o.cid, clientid.Encode()[:]...)
-	pad := 0
 
 	dhcp.Options = append(dhcp.Options, layers.NewDHCPv6Option(layers.DHCPv6OptClientID, clientid.Encode()))
-	if o.init.Options != nil && o.init.Options.RemoveOR == true {
-		pad += 12
-	} else {
+	if o.init.Options == nil || !o.init.Options.RemoveOR {
 		dhcp.Options = append(dhcp.Options, layers.NewDHCPv6Option(layers.DHCPv6OptOro, []byte{0, 0x11, 0, 0x17, 0, 0x18, 0x00, 0x27}))
 	}
-	if o.init.Options != nil && o.init.Options.RemoveVC == true {
-		pad += 18
-	} else {
+	if o.init.Options == nil || !o.init.Options.RemoveVC {
 		dhcp.Options = append(dhcp.Options, layers.NewDHCPv6Option(layers.DHCPv6OptVendorClass, []byte{0x00, 0x00, 0x01, 0x37, 0x00, 0x08, 0x4d, 0x53, 0x46, 0x54, 0x20, 0x35, 0x2e, 0x30}))
 	}
 
-	ianao := []byte{0x00, 0x00, 0x00, 0x00,
-		0x00, 0x00, 0x00, 0x00,
-		0x00, 0x00, 0x00, 0x00}
+	if o.requestNa() {
+		ianao := []byte{0x00, 0x00, 0x00, 0x00,
+			0x00, 0x00, 0x00, 0x00,
+			0x00, 0x00, 0x00, 0x00}
 
-	binary.BigEndian.PutUint32(ianao[0:4], iaid)
+		binary.BigEndian.PutUint32(ianao[0:4], iaid)
 
-	dhcp.Options = append(dhcp.Options, layers.NewDHCPv6Option(layers.DHCPv6OptIANA, ianao))
-	dhcp.Options = append(dhcp.Options, layers.NewDHCPv6Option(layers.DHCPv6OptElapsedTime, []byte{0x00, 0x00}))
+		dhcp.Options = append(dhcp.Options, layers.NewDHCPv6Option(layers.DHCPv6OptIANA, ianao))
+	}
+	// the IA_PD depends on the message type, it is added by SendDhcpPacket
 
-	o.l7TimeOffset = 68 - uint16(pad)
+	// offset of the elapsed time value
+	o.l7TimeOffset = 4 + 4
+	for _, op := range dhcp.Options {
+		o.l7TimeOffset += 4 + uint16(len(op.Data))
+	}
+	dhcp.Options = append(dhcp.Options, layers.NewDHCPv6Option(layers.DHCPv6OptElapsedTime, []byte{0x00, 0x00}))
 	o.l4Offset = o.l3Offset + IPV6_HEADER_SIZE
 	o.l7Offset = o.l4Offset + 8
 
 	o.discoverPktTemplate = o.buildPacket(l2, dhcp)
 }
 
+// iapdOption returns the IA_PD option of a message. Solicit has only the prefix length hint,
+// Request has the advertised prefix and Renew/Rebind/Release have the bound prefix with its lifetimes (RFC 8415 18.2)
+func (o *PluginDhcpClient) iapdOption(msgType byte) []byte {
+	iapdo := make([]byte, 12)
+	binary.BigEndian.PutUint32(iapdo[0:4], o.iaid)
+	prefixo := make([]byte, 25)
+	switch msgType {
+	case byte(layers.DHCPv6MsgTypeSolicit):
+		if o.init.Pd.PrefixLen == 0 {
+			prefixo = nil
+			break
+		}
+		prefixo[8] = o.init.Pd.PrefixLen
+	case byte(layers.DHCPv6MsgTypeRequest):
+		if !o.pktIapd.OptionValid {
+			prefixo = nil
+			break
+		}
+		binary.BigEndian.PutUint32(prefixo[0:4], o.pktIapd.PreferredLife)
+		binary.BigEndian.PutUint32(prefixo[4:8], o.pktIapd.ValidLife)
+		prefixo[8] = o.pktIapd.PrefixLen
+		copy(prefixo[9:25], o.pktIapd.Prefix)
+	default:
+		pd := o.Client.Ipv6Pd
+		if pd == nil {
+			prefixo = nil
+			break
+		}
+		binary.BigEndian.PutUint32(prefixo[0:4], pd.Preferred)
+		binary.BigEndian.PutUint32(prefixo[4:8], pd.Valid)
+		prefixo[8] = pd.PrefixLen
+		copy(prefixo[9:25], pd.Prefix[:])
+	}
+	if prefixo != nil {
+		iapdo = append(iapdo, EncodeOption(layers.NewDHCPv6Option(layers.DHCPv6OptIAPrefix, prefixo))...)
+	}
+	return EncodeOption(layers.NewDHCPv6Option(layers.DHCPv6OptIAPD, iapdo))
+}
+
 func (o *PluginDhcpClient) SendDhcpPacket(
 	msgType byte,
 	serverOption bool) {
@@ -498,6 +608,11 @@ func (o *PluginDhcpClient) SendDhcpPacket(
 	if serverOption {
 		pad = len(o.sidOption)
 	}
+	var iapdOption []byte
+	if o.requestPd() {
+		iapdOption = o.iapdOption(msgType)
+		pad += len(iapdOption)
+	}
 	if o.init.Options != nil {
 		// add option
 		switch msgType {
@@ -532,6 +647,9 @@ func (o *PluginDhcpClient) SendDhcpPacket(
 	if serverOption {
 		m.Append(o.sidOption)
 	}
+	if iapdOption != nil {
+		m.Append(iapdOption)
+	}
 
 	if o.init.Options != nil {
 		switch msgType {
@@ -579,10 +697,25 @@ func (o *PluginDhcpClient) SendDhcpPacket(
 	o.Tctx.Veth.Send(m)
 }
 
+// requestNa returns true in case an address is requested (IA_NA)
+func (o *PluginDhcpClient) requestNa() bool {
+	return !o.init.Stateless && !o.init.NoNa
+}
+
+// requestPd returns true in case a prefix is requested (IA_PD)
+func (o *PluginDhcpClient) requestPd() bool {
+	return !o.init.Stateless && o.init.Pd != nil
+}
+
 func (o *PluginDhcpClient) SendDiscover() {
 	o.state = DHCP_STATE_INIT
 	o.cnt = 0
 	o.restartTimer(o.timerDiscoverRetransmitSec)
+	if o.init.Stateless {
+		o.stats.pktTxInfoRequest++
+		o.SendDhcpPacket(byte(layers.DHCPv6MsgTypeInformationRequest), false)
+		return
+	}
 	o.stats.pktTxDiscover++
 	o.SendDhcpPacket(byte(layers.DHCPv6MsgTypeSolicit), false)
 }
@@ -592,9 +725,16 @@ func (o *PluginDhcpClient) OnEvent(msg string, a, b interface{}) {
 
 }
 
+func (o *PluginDhcpClient) GetCounterDbVec() *core.CCounterDbVec {
+	return o.cdbv
+}
+
 func (o *PluginDhcpClient) OnRemove(ctx *core.PluginCtx) {
 	/* force removing the link to the client */
-	o.SendRenewRebind(false, true, 0)
+	if !o.init.Stateless {
+		o.SendRenewRebind(false, true, 0)
+	}
+	o.removePd()
 	ctx.UnregisterEvents(&o.PluginBase, dhcpEvents)
 	if o.timer.IsRunning() {
 		o.timerw.Stop(&o.timer)
@@ -639,6 +779,7 @@ func (o *PluginDhcpClient) verifyPkt(dhcph *layers.DHCPv6,
 	cid []byte,
 	sid []byte,
 	validiana bool,
+	validiapd bool,
 ) int {
 
 	var verifysid bool
@@ -658,7 +799,18 @@ func (o *PluginDhcpClient) verifyPkt(dhcph *layers.DHCPv6,
 		return -1
 	}
 
-	if !validiana {
+	if o.requestPd() {
+		if !validiapd {
+			o.stats.pktRxNoIAPD++
+			return -1
+		}
+		if o.pktIapd.IAID != o.iaid {
+			o.stats.pktRxWrongIAPDId++
+			return -1
+		}
+	}
+
+	if o.requestNa() && !validiana {
 		o.stats.pktRxNoIANA++
 		return -1
 		if o.pktIana.IAID != o.iaid {
@@ -721,6 +873,12 @@ func (o *PluginDhcpClient) onTimerEvent() {
 	case DHCP_STATE_REQUESTING:
 		o.SendReq()
 	case DHCP_STATE_BOUND:
+		if o.init.Stateless {
+			// information refresh
+			o.resetTransactionTimer()
+			o.SendDiscover()
+			return
+		}
 		if o.cnt == 1 {
 			o.resetTransactionTimer()
 		}
@@ -762,24 +920,113 @@ func (o *PluginDhcpClient) HandleAckNak(dhcpmt layers.DHCPv6MsgType,
 	case layers.DHCPv6MsgTypeReply:
 		o.stats.pktRxAck++
 		o.state = DHCP_STATE_BOUND
+		o.cnt = 0
+		if o.init.Stateless {
+			o.restartTimer(o.refreshSec)
+			return 0
+		}
 		if notify {
 			o.stats.pktRxNotify++
-			var NewIpv6 core.Ipv6Key
-			copy(NewIpv6[:], o.pktIana.IPv6)
-			o.Client.UpdateDIPv6(NewIpv6)
+			if o.requestNa() {
+				var NewIpv6 core.Ipv6Key
+				copy(NewIpv6[:], o.pktIana.IPv6)
+				o.Client.UpdateDIPv6(NewIpv6)
+			}
+			if o.requestPd() {
+				o.updatePd()
+			}
 		}
 
-		o.t1 = normTime(o.pktIana.T1, false)
-		o.t2 = normTime(o.pktIana.T2, true)
+		// the renew is for all the IAs, the first one
+		var t1, t2 uint32
+		if o.requestNa() {
+			t1, t2 = o.pktIana.T1, o.pktIana.T2
+		}
+		if o.requestPd() && (t1 == 0 || (o.pktIapd.T1 != 0 && o.pktIapd.T1 < t1)) {
+			t1, t2 = o.pktIapd.T1, o.pktIapd.T2
+		}
+		o.t1 = normTime(t1, false)
+		o.t2 = normTime(t2, true)
 		if o.t2 < o.t1 {
 			o.t2 = o.t1 + 60
 		}
-		o.cnt = 0
 		o.restartTimer(o.t1)
 	}
 	return 0
 }
 
+// updatePd updates the delegated prefix of the client, in case it was changed the downstream clients get a /64 of the new prefix
+func (o *PluginDhcpClient) updatePd() {
+	var pd core.CClientPd
+	copy(pd.Prefix[:], o.pktIapd.Prefix)
+	pd.PrefixLen = o.pktIapd.PrefixLen
+	pd.Preferred = o.pktIapd.PreferredLife
+	pd.Valid = o.pktIapd.ValidLife
+	old := o.Client.Ipv6Pd
+	o.Client.Ipv6Pd = &pd
+	if old != nil && old.Prefix == pd.Prefix && old.PrefixLen == pd.PrefixLen {
+		return
+	}
+	o.stats.pdRxPrefix++
+	o.releasePdClients()
+
+	if pd.PrefixLen > 64 {
+		return
+	}
+	// number of /64 in the prefix
+	var subnets uint64 = 1 << 63
+	if pd.PrefixLen > 0 {
+		subnets = 1 << (64 - pd.PrefixLen)
+	}
+	prefix := binary.BigEndian.Uint64(pd.Prefix[0:8])
+	for i, mac := range o.init.Pd.Clients {
+		var addr core.Ipv6Key
+		if uint64(i) >= subnets {
+			break
+		}
+		client := o.Ns.CLookupByMac(&mac)
+		if client != nil {
+			binary.BigEndian.PutUint64(addr[0:8], prefix+uint64(i))
+			addr[8] = mac[0] ^ 0x2
+			addr[9] = mac[1]
+			addr[10] = mac[2]
+			addr[11] = 0xFF
+			addr[12] = 0xFE
+			addr[13] = mac[3]
+			addr[14] = mac[4]
+			addr[15] = mac[5]
+			if client.UpdateIPv6(addr) == nil {
+				o.stats.pdClients++
+			} else {
+				addr = core.Ipv6Key{}
+			}
+		}
+		o.pdAddrs = append(o.pdAddrs, addr)
+	}
+}
+
+// releasePdClients removes the addresses of the downstream clients, in case they were not changed
+func (o *PluginDhcpClient) releasePdClients() {
+	for i, addr := range o.pdAddrs {
+		mac := o.init.Pd.Clients[i]
+		client := o.Ns.CLookupByMac(&mac)
+		if client != nil && !addr.IsZero() && client.Ipv6 == addr {
+			client.UpdateIPv6(core.Ipv6Key{})
+		}
+	}
+	o.pdAddrs = o.pdAddrs[:0]
+	o.stats.pdClients = 0
+}
+
+// removePd removes the delegated prefix
+func (o *PluginDhcpClient) removePd() {
+	if o.Client.Ipv6Pd == nil {
+		return
+	}
+	o.releasePdClients()
+	o.Client.Ipv6Pd = nil
+}
+
 func EncodeOption(o layers.DHCPv6Option) []byte {
 	b := make([]byte, 4)
 	binary.BigEndian.PutUint16(b[0:2], uint16(o.Code))
@@ -815,7 +1062,9 @@ func (o *PluginDhcpClient) HandleRxDhcpPacket(ps *core.ParserPacketState) int {
 	var cid []byte
 	var sid []byte
 	var validiana bool
+	var validiapd bool
 	var status uint16
+	o.refreshSec = IRT_DEFAULT_SEC
 
 	for _, op := range dhcph.Options {
 		switch op.Code {
@@ -828,6 +1077,20 @@ func (o *PluginDhcpClient) HandleRxDhcpPacket(ps *core.ParserPacketState) int {
 			if o.pktIana.Decode(op.Data) == nil {
 				validiana = true
 			}
+		case layers.DHCPv6OptIAPD:
+			if o.pktIapd.Decode(op.Data) == nil && o.pktIapd.OptionValid {
+				validiapd = o.pktIapd.Status == STATUS_Success
+			}
+			if o.pktIapd.Status == STATUS_NoPrefixAvail {
+				o.stats.pktRxSTATUS_NoPrefixAvail++
+			}
+		case layers.DHCPv6OptInformationRefreshTime:
+			if len(op.Data) == 4 {
+				o.refreshSec = binary.BigEndian.Uint32(op.Data[0:4])
+				if o.refreshSec < IRT_MINIMUM_SEC {
+					o.refreshSec = IRT_MINIMUM_SEC
+				}
+			}
 		case layers.DHCPv6OptStatusCode:
 			if len(op.Data) == 2 {
 				status = binary.BigEndian.Uint16(op.Data[0:2])
@@ -859,13 +1122,17 @@ func (o *PluginDhcpClient) HandleRxDhcpPacket(ps *core.ParserPacketState) int {
 		}
 	}
 
-	if o.verifyPkt(&dhcph, ipv6, cid, sid, validiana) != 0 {
+	if o.verifyPkt(&dhcph, ipv6, cid, sid, validiana, validiapd) != 0 {
 		return -1
 	}
 
 	switch o.state {
 	case DHCP_STATE_INIT:
 
+		if o.init.Stateless && dhcpmt == layers.DHCPv6MsgTypeReply {
+			return o.HandleAckNak(dhcpmt, &dhcph, ipv6, true, status)
+		}
+
 		if dhcpmt == layers.DHCPv6MsgTypeAdverstise {
 			o.stats.pktRxOffer++
 			// save server ip and server-id option
@@ -1058,9 +1325,11 @@ func init() {
 	core.RegisterCB("dhcpv6_client_cnt", ApiDhcpClientCntHandler{}, false) // get counters/meta
 
 	/* register callback for rx side*/
-	core.ParserRegister("dhcpv6", HandleRxDhcpv6Packet)
+	core.ParserRegister("dhcpv6", HandleRxDhcpv6Packet,
+		core.ParserRegisterData{UdpV6Ports: []uint16{546}})
 }
 
 func Register(ctx *core.CThreadCtx) {
 	ctx.RegisterParserCb("dhcpv6")
+	ctx.RegisterParserCb(DHCPV6SRV_PLUG)
 }
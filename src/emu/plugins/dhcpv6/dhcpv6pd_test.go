@@ -0,0 +1,210 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package dhcpv6
+
+import (
+	"emu/core"
+	"encoding/binary"
+	"external/google/gopacket"
+	"external/google/gopacket/layers"
+	"net"
+	"testing"
+	"time"
+)
+
+// VethDhcpv6PdSim answers as a delegating router, with a prefix or with the other configuration
+type VethDhcpv6PdSim struct {
+	tctx   *core.CThreadCtx
+	prefix net.IP
+	rx     []layers.DHCPv6MsgType
+	iapds  map[layers.DHCPv6MsgType]layers.DHCPv6OptionIAPD // the last IA_PD of each message type
+}
+
+func (o *VethDhcpv6PdSim) ProcessTxToRx(m *core.Mbuf) *core.Mbuf {
+	off := 14 + 8 + 40 + 8
+	if m.PktLen() <= uint32(off) {
+		m.FreeMbuf()
+		return nil
+	}
+	var dhcph layers.DHCPv6
+	err := dhcph.DecodeFromBytes(m.GetData()[off:], gopacket.NilDecodeFeedback)
+	m.FreeMbuf()
+	if err != nil {
+		return nil
+	}
+	o.rx = append(o.rx, dhcph.MsgType)
+	for _, op := range dhcph.Options {
+		if op.Code == layers.DHCPv6OptIAPD {
+			var iapd layers.DHCPv6OptionIAPD
+			if iapd.Decode(op.Data) == nil {
+				if o.iapds == nil {
+					o.iapds = make(map[layers.DHCPv6MsgType]layers.DHCPv6OptionIAPD)
+				}
+				o.iapds[dhcph.MsgType] = iapd
+			}
+		}
+	}
+
+	var mt layers.DHCPv6MsgType
+	var ops []layers.DHCPv6Option
+	switch dhcph.MsgType {
+	case layers.DHCPv6MsgTypeSolicit:
+		mt = layers.DHCPv6MsgTypeAdverstise
+		ops = append(ops, o.iapd())
+	case layers.DHCPv6MsgTypeRequest, layers.DHCPv6MsgTypeRenew:
+		mt = layers.DHCPv6MsgTypeReply
+		ops = append(ops, o.iapd())
+	case layers.DHCPv6MsgTypeInformationRequest:
+		mt = layers.DHCPv6MsgTypeReply
+		ops = append(ops, layers.NewDHCPv6Option(layers.DHCPv6OptDNSServers, Ipv6SA("2001:db8::53")))
+		ops = append(ops, layers.NewDHCPv6Option(layers.DHCPv6OptInformationRefreshTime, []byte{0, 0, 0x0e, 0x10}))
+	default:
+		return nil
+	}
+	return genMbuf(o.tctx, o.generate(XidToUint32(dhcph.TransactionID), mt, ops))
+}
+
+func (o *VethDhcpv6PdSim) iapd() layers.DHCPv6Option {
+	prefix := make([]byte, 25)
+	binary.BigEndian.PutUint32(prefix[0:4], 1000)
+	binary.BigEndian.PutUint32(prefix[4:8], 2000)
+	prefix[8] = 56
+	copy(prefix[9:25], o.prefix)
+	iapd := []byte{0x12, 0x34, 0x56, 0x78, 0, 0, 0x02, 0x58, 0, 0, 0x03, 0x84}
+	iapd = append(iapd, EncodeOption(layers.NewDHCPv6Option(layers.DHCPv6OptIAPrefix, prefix))...)
+	return layers.NewDHCPv6Option(layers.DHCPv6OptIAPD, iapd)
+}
+
+func (o *VethDhcpv6PdSim) generate(xid uint32, mt layers.DHCPv6MsgType, ops []layers.DHCPv6Option) []byte {
+	dhcp := &layers.DHCPv6{MsgType: mt,
+		TransactionID: []byte{byte(xid >> 16), byte(xid >> 8), byte(xid)}}
+	clientid := &layers.DHCPv6DUID{Type: layers.DHCPv6DUIDTypeLL, HardwareType: []byte{0, 1}, LinkLayerAddress: []byte{0, 0, 1, 0, 0, 1}}
+	dhcp.Options = append(dhcp.Options, layers.NewDHCPv6Option(layers.DHCPv6OptClientID, clientid.Encode()))
+	dhcp.Options = append(dhcp.Options, layers.NewDHCPv6Option(layers.DHCPv6OptServerID, []byte{0x00, 0x03, 0x00, 0x01, 0x00, 0x00, 0x01, 0x00, 0x00, 0x09}))
+	dhcp.Options = append(dhcp.Options, ops...)
+
+	ipv6pkt := core.PacketUtlBuild(
+		&layers.IPv6{Version: 6, NextHeader: layers.IPProtocolUDP, HopLimit: 1,
+			SrcIP: Ipv6SA("FE80::01"), DstIP: Ipv6SA("FE80::200:1ff:fe00:1")},
+		&layers.UDP{SrcPort: 547, DstPort: 546},
+		dhcp,
+	)
+	p := append(getL2(), ipv6pkt...)
+	rcof := 22 + IPV6_HEADER_SIZE
+	ipv6 := layers.IPv6Header(p[22:rcof])
+	binary.BigEndian.PutUint16(p[rcof+4:rcof+6], uint16(len(p)-rcof))
+	ipv6.SetPyloadLength(uint16(len(p) - rcof))
+	ipv6.FixUdpL4Checksum(p[rcof:], 0)
+	return p
+}
+
+func createPdEnv(sim *VethDhcpv6PdSim, inijson string, downstream []core.MACKey) (*core.CThreadCtx, *core.CNSCtx, *PluginDhcpClient) {
+	var simrx core.VethIFSim = sim
+	tctx := core.NewThreadCtx(0, 4510, true, &simrx)
+	sim.tctx = tctx
+	var key core.CTunnelKey
+	key.Set(&core.CTunnelData{Vport: 1, Vlans: [2]uint32{0x81000001, 0x81000002}})
+	ns := core.NewNSCtx(tctx, &key)
+	tctx.AddNs(&key, ns)
+	ns.PluginCtx.CreatePlugins([]string{"dhcpv6"}, [][]byte{})
+	for _, mac := range downstream {
+		ns.AddClient(core.NewClient(ns, mac, core.Ipv4Key{}, core.Ipv6Key{}, core.Ipv4Key{}))
+	}
+	client := core.NewClient(ns, core.MACKey{0, 0, 1, 0, 0, 1}, core.Ipv4Key{}, core.Ipv6Key{}, core.Ipv4Key{})
+	ns.AddClient(client)
+	client.PluginCtx.CreatePlugins([]string{"dhcpv6"}, [][]byte{[]byte(inijson)})
+	tctx.RegisterParserCb("dhcpv6")
+	return tctx, ns, client.PluginCtx.Get(DHCPV6_PLUG).Ext.(*PluginDhcpClient)
+}
+
+func TestPluginDhcpv6Pd(t *testing.T) {
+	sim := &VethDhcpv6PdSim{prefix: Ipv6SA("2001:db8:0:ab00::")}
+	downstream := []core.MACKey{{0, 0, 1, 0, 0, 2}, {0, 0, 1, 0, 0, 3}}
+	tctx, ns, plug := createPdEnv(sim, `{"no_na": true, "pd": {"prefix_len": 56, "clients": [[0, 0, 1, 0, 0, 2], [0, 0, 1, 0, 0, 3]]}}`, downstream)
+	defer tctx.Delete()
+
+	tctx.MainLoopSim(10 * time.Second)
+
+	pd := plug.Client.Ipv6Pd
+	if plug.state != DHCP_STATE_BOUND || pd == nil || pd.PrefixLen != 56 || pd.Valid != 2000 ||
+		!net.IP(pd.Prefix[:]).Equal(sim.prefix) {
+		t.Fatalf(" prefix should be delegated %+v ", pd)
+	}
+	if !plug.Client.Dhcpv6.IsZero() {
+		t.Fatalf(" address should not be requested ")
+	}
+	exp := []string{"2001:db8:0:ab00:200:1ff:fe00:2", "2001:db8:0:ab01:200:1ff:fe00:3"}
+	for i, mac := range downstream {
+		c := ns.CLookupByMac(&mac)
+		if !net.IP(c.Ipv6[:]).Equal(Ipv6SA(exp[i])) {
+			t.Fatalf(" client %v got %v ", mac, net.IP(c.Ipv6[:]))
+		}
+	}
+	if plug.stats.pdRxPrefix != 1 || plug.stats.pdClients != 2 {
+		t.Fatalf(" unexpected counters %+v ", plug.stats)
+	}
+	if plug.Client.GetInfo().Ipv6Pd != pd {
+		t.Fatalf(" prefix should be in the client info ")
+	}
+
+	// the solicit has the length hint, the request has the advertised prefix
+	if iapd := sim.iapds[layers.DHCPv6MsgTypeSolicit]; !iapd.OptionValid || iapd.PrefixLen != 56 || !iapd.Prefix.IsUnspecified() {
+		t.Fatalf(" unexpected solicit IA_PD %+v ", iapd)
+	}
+	if iapd := sim.iapds[layers.DHCPv6MsgTypeRequest]; !iapd.OptionValid || iapd.PrefixLen != 56 || !iapd.Prefix.Equal(sim.prefix) {
+		t.Fatalf(" unexpected request IA_PD %+v ", iapd)
+	}
+
+	// renew at T1 of the IA_PD, with the bound prefix and its lifetimes
+	tctx.MainLoopSim(700 * time.Second)
+	if plug.stats.pktRxRenew == 0 || plug.stats.pdRxPrefix != 1 || plug.state != DHCP_STATE_BOUND {
+		t.Fatalf(" prefix should be renewed %+v ", plug.stats)
+	}
+	if iapd := sim.iapds[layers.DHCPv6MsgTypeRenew]; !iapd.OptionValid || iapd.IAID != 0x12345678 || !iapd.Prefix.Equal(sim.prefix) ||
+		iapd.PreferredLife != 1000 || iapd.ValidLife != 2000 {
+		t.Fatalf(" unexpected renew IA_PD %+v ", iapd)
+	}
+
+	// new prefix in the renew
+	sim.prefix = Ipv6SA("2001:db8:0:cd00::")
+	tctx.MainLoopSim(700 * time.Second)
+	c := ns.CLookupByMac(&downstream[0])
+	if plug.stats.pdRxPrefix != 2 || !net.IP(c.Ipv6[:]).Equal(Ipv6SA("2001:db8:0:cd00:200:1ff:fe00:2")) {
+		t.Fatalf(" downstream client should get the new prefix %v ", net.IP(c.Ipv6[:]))
+	}
+
+	ns.RemoveClient(plug.Client)
+	if !c.Ipv6.IsZero() || plug.stats.pdClients != 0 {
+		t.Fatalf(" downstream address should be removed ")
+	}
+	tctx.Veth.SimulatorCheckRxQueue()
+	if iapd := sim.iapds[layers.DHCPv6MsgTypeRelease]; !iapd.OptionValid || !iapd.Prefix.Equal(sim.prefix) {
+		t.Fatalf(" release should have the bound prefix %+v ", iapd)
+	}
+}
+
+func TestPluginDhcpv6Stateless(t *testing.T) {
+	sim := &VethDhcpv6PdSim{}
+	tctx, _, plug := createPdEnv(sim, `{"stateless": true}`, nil)
+	defer tctx.Delete()
+
+	tctx.MainLoopSim(10 * time.Second)
+	if plug.state != DHCP_STATE_BOUND || plug.refreshSec != 3600 || plug.stats.pktTxInfoRequest != 1 ||
+		!plug.Client.Dhcpv6.IsZero() {
+		t.Fatalf(" information should be received %+v ", plug.stats)
+	}
+
+	// information refresh
+	tctx.MainLoopSim(3700 * time.Second)
+	if plug.stats.pktTxInfoRequest != 2 {
+		t.Fatalf(" information should be refreshed %+v ", plug.stats)
+	}
+	for _, mt := range sim.rx {
+		if mt != layers.DHCPv6MsgTypeInformationRequest {
+			t.Fatalf(" unexpected message %v ", mt)
+		}
+	}
+}
@@ -0,0 +1,1284 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package dhcpv6
+
+/*
+RFC 8415 DHCPv6 server, namespace plugin
+
+The server uses the MAC and the link-local address of one of the clients of the namespace (server_mac). Addresses (IA_NA) are allocated
+from na_pools and prefixes (IA_PD) from pd_pools, each pd pool is split into prefixes of delegated_len. Leases are kept by DUID, IA type and IAID
+with a timer, advertised leases are removed after the offer timeout and bound leases after the valid lifetime.
+Relay-Forward messages are answered with a Relay-Reply to the relay (UDP port 547), the na pool is selected by the link-address of the
+relay closest to the client, otherwise by the address of the server. A Solicit with Rapid Commit is answered with a Reply in case rapid_commit is set.
+static binds an address and/or a prefix to a DUID (hex).
+
+ns inijson {
+	"server_mac": [0, 0, 1, 0, 0, 1],
+	"preferred": 3600,
+	"valid": 7200,
+	"offer": 60,
+	"preference": 255,
+	"rapid_commit": true,
+	"dns": [[32, 1, 13, 184, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 83]],
+	"domain": "trex.local",
+	"na_pools": [{"min": [32, 1, 13, 184, 0, 1, 0, 0, 0, 0, 0, 0, 0, 0, 1, 0], "max": [32, 1, 13, 184, 0, 1, 0, 0, 0, 0, 0, 0, 0, 0, 1, 255], "prefix": 64}],
+	"pd_pools": [{"prefix": [32, 1, 13, 184, 16, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0], "prefix_len": 40, "delegated_len": 56}],
+	"static": [{"duid": "00030001000001000005", "ipv6": [32, 1, 13, 184, 0, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 5]}]
+}
+
+*/
+
+import (
+	"bytes"
+	"emu/core"
+	"encoding/binary"
+	"encoding/hex"
+	"external/google/gopacket"
+	"external/google/gopacket/layers"
+	"external/osamingo/jsonrpc"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+	"unsafe"
+
+	"github.com/intel-go/fastjson"
+)
+
+const (
+	DHCPV6SRV_PLUG              = "dhcpv6srv"
+	DHCPV6SRV_DEF_PREFERRED_SEC = 3600
+	DHCPV6SRV_DEF_VALID_SEC     = 7200
+	DHCPV6SRV_DEF_OFFER_SEC     = 60
+	DHCPV6_SERVER_PORT          = 547
+	DHCPV6_CLIENT_PORT          = 546
+	DHCPV6_HOP_COUNT_LIMIT      = 8
+
+	/* lease state */
+	DHCPV6SRV_LEASE_OFFERED = 1
+	DHCPV6SRV_LEASE_BOUND   = 2
+)
+
+type Dhcpv6SrvNaPoolInit struct {
+	Min       core.Ipv6Key `json:"min" validate:"required"`
+	Max       core.Ipv6Key `json:"max" validate:"required"`
+	Prefix    uint8        `json:"prefix" validate:"lte=128"` // prefix of the link, selects the pool by the relay link-address, default 64
+	Preferred uint32       `json:"preferred"`                 // zero for the server lifetime
+	Valid     uint32       `json:"valid"`
+}
+
+type Dhcpv6SrvPdPoolInit struct {
+	Prefix       core.Ipv6Key `json:"prefix" validate:"required"`
+	PrefixLen    uint8        `json:"prefix_len" validate:"required,gte=1,lte=64"`
+	DelegatedLen uint8        `json:"delegated_len" validate:"required,gte=1,lte=64"`
+	Preferred    uint32       `json:"preferred"`
+	Valid        uint32       `json:"valid"`
+}
+
+type Dhcpv6SrvStaticInit struct {
+	Duid      string       `json:"duid" validate:"required"` // hex, ':' is allowed
+	Ipv6      core.Ipv6Key `json:"ipv6"`
+	Prefix    core.Ipv6Key `json:"prefix"`
+	PrefixLen uint8        `json:"prefix_len" validate:"lte=128"`
+}
+
+type Dhcpv6SrvInit struct {
+	ServerMac   core.MACKey           `json:"server_mac" validate:"required"`
+	Preferred   uint32                `json:"preferred"`
+	Valid       uint32                `json:"valid"`
+	Offer       uint32                `json:"offer"`
+	Preference  uint8                 `json:"preference"`
+	RapidCommit bool                  `json:"rapid_commit"`
+	Dns         []core.Ipv6Key        `json:"dns"`
+	Domain      string                `json:"domain"`
+	NaPools     []Dhcpv6SrvNaPoolInit `json:"na_pools" validate:"dive"`
+	PdPools     []Dhcpv6SrvPdPoolInit `json:"pd_pools" validate:"dive"`
+	Static      []Dhcpv6SrvStaticInit `json:"static" validate:"dive"`
+}
+
+type Dhcpv6SrvStats struct {
+	pktRxSolicit      uint64
+	pktRxRequest      uint64
+	pktRxRenew        uint64
+	pktRxRebind       uint64
+	pktRxRelease      uint64
+	pktRxDecline      uint64
+	pktRxInfoRequest  uint64
+	pktRxRelayed      uint64
+	pktRxRapidCommit  uint64
+	pktRxOtherServer  uint64
+	pktRxNoLease      uint64
+	pktRxNoClientId   uint64
+	pktRxLenErr       uint64
+	pktRxParserErr    uint64
+	pktRxUnhandle     uint64
+	pktTxAdvertise    uint64
+	pktTxReply        uint64
+	errNoServer       uint64
+	errNoPool         uint64
+	errPoolEmpty      uint64
+	errInitJson       uint64
+	leaseActive       uint64
+	leaseAdd          uint64
+	leaseRemove       uint64
+	leaseExpired      uint64
+	leaseOfferTimeout uint64
+}
+
+func NewDhcpv6SrvStatsDb(o *Dhcpv6SrvStats) *core.CCounterDb {
+	db := core.NewCCounterDb("dhcpv6srv")
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxSolicit,
+		Name:     "pktRxSolicit",
+		Help:     "rx solicit",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxRequest,
+		Name:     "pktRxRequest",
+		Help:     "rx request",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxRenew,
+		Name:     "pktRxRenew",
+		Help:     "rx renew",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxRebind,
+		Name:     "pktRxRebind",
+		Help:     "rx rebind",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxRelease,
+		Name:     "pktRxRelease",
+		Help:     "rx release",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxDecline,
+		Name:     "pktRxDecline",
+		Help:     "rx decline",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxInfoRequest,
+		Name:     "pktRxInfoRequest",
+		Help:     "rx information request",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxRelayed,
+		Name:     "pktRxRelayed",
+		Help:     "rx relay forward",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxRapidCommit,
+		Name:     "pktRxRapidCommit",
+		Help:     "rx solicit with rapid commit",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxOtherServer,
+		Name:     "pktRxOtherServer",
+		Help:     "rx message for another server",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxNoLease,
+		Name:     "pktRxNoLease",
+		Help:     "rx renew/rebind of an IA without a lease",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxNoClientId,
+		Name:     "pktRxNoClientId",
+		Help:     "rx without client id",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxLenErr,
+		Name:     "pktRxLenErr",
+		Help:     "len error",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxParserErr,
+		Name:     "pktRxParserErr",
+		Help:     "parser error",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxUnhandle,
+		Name:     "pktRxUnhandle",
+		Help:     "unhandle dhcpv6 packet",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTxAdvertise,
+		Name:     "pktTxAdvertise",
+		Help:     "tx advertise",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTxReply,
+		Name:     "pktTxReply",
+		Help:     "tx reply",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.errNoServer,
+		Name:     "errNoServer",
+		Help:     "server client does not exist or does not have a source ipv6",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.errNoPool,
+		Name:     "errNoPool",
+		Help:     "no pool for the link of the relay",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.errPoolEmpty,
+		Name:     "errPoolEmpty",
+		Help:     "no free address/prefix in the pools",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.errInitJson,
+		Name:     "errInitJson",
+		Help:     "init json is not valid, server is disabled",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.leaseActive,
+		Name:     "leaseActive",
+		Help:     "active leases",
+		Unit:     "leases",
+		DumpZero: false,
+		Gauge:    true,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.leaseAdd,
+		Name:     "leaseAdd",
+		Help:     "lease add",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.leaseRemove,
+		Name:     "leaseRemove",
+		Help:     "lease remove",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.leaseExpired,
+		Name:     "leaseExpired",
+		Help:     "bound lease expired",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.leaseOfferTimeout,
+		Name:     "leaseOfferTimeout",
+		Help:     "advertised lease without a request",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	return db
+}
+
+// dhcpv6SrvPool a pool of addresses or prefixes, the upper 64 bits are fixed
+type dhcpv6SrvPool struct {
+	pd        bool
+	base      core.Ipv6Key
+	linkLen   uint8  // na: the prefix of the link, pd: the delegated prefix length
+	min       uint64 // na: lower 64 bits of the address, pd: index of the prefix
+	max       uint64
+	next      uint64
+	preferred uint32
+	valid     uint32
+}
+
+// get returns the address/prefix of index i
+func (o *dhcpv6SrvPool) get(i uint64) core.Ipv6Key {
+	var ipv6 core.Ipv6Key
+	copy(ipv6[:], o.base[:])
+	if o.pd {
+		high := binary.BigEndian.Uint64(o.base[0:8]) + (i << (64 - o.linkLen))
+		binary.BigEndian.PutUint64(ipv6[0:8], high)
+		binary.BigEndian.PutUint64(ipv6[8:16], 0)
+	} else {
+		binary.BigEndian.PutUint64(ipv6[8:16], i)
+	}
+	return ipv6
+}
+
+// inRange returns true in case the address/prefix is one of the pool
+func (o *dhcpv6SrvPool) inRange(ipv6 core.Ipv6Key, plen uint8) bool {
+	if o.pd {
+		if plen != o.linkLen || binary.BigEndian.Uint64(ipv6[8:16]) != 0 {
+			return false
+		}
+		i := (binary.BigEndian.Uint64(ipv6[0:8]) - binary.BigEndian.Uint64(o.base[0:8])) >> (64 - o.linkLen)
+		return i >= o.min && i <= o.max && o.get(i) == ipv6
+	}
+	l := binary.BigEndian.Uint64(ipv6[8:16])
+	return bytes.Equal(ipv6[0:8], o.base[0:8]) && l >= o.min && l <= o.max
+}
+
+// inSubnet returns true in case ipv6 is in the link of the na pool
+func (o *dhcpv6SrvPool) inSubnet(ipv6 core.Ipv6Key) bool {
+	return prefixEqual(ipv6, o.base, o.linkLen)
+}
+
+func prefixEqual(a, b core.Ipv6Key, plen uint8) bool {
+	n := plen / 8
+	if !bytes.Equal(a[:n], b[:n]) {
+		return false
+	}
+	if plen%8 == 0 {
+		return true
+	}
+	mask := byte(0xff) << (8 - plen%8)
+	return (a[n] & mask) == (b[n] & mask)
+}
+
+type dhcpv6SrvLeaseKey struct {
+	duid string
+	pd   bool
+	iaid uint32
+}
+
+type dhcpv6SrvLease struct {
+	dlist     core.DList // must be first
+	timer     core.CHTimerObj
+	key       dhcpv6SrvLeaseKey
+	ipv6      core.Ipv6Key
+	prefixLen uint8 // 128 for an address
+	preferred uint32
+	valid     uint32
+	state     uint8
+	static    bool
+	expire    uint64 // ticks
+	linkAddr  core.Ipv6Key
+}
+
+func covertToDhcpv6SrvLease(dlist *core.DList) *dhcpv6SrvLease {
+	return (*dhcpv6SrvLease)(unsafe.Pointer(dlist))
+}
+
+// Dhcpv6SrvLeaseRec lease information for the RPC
+type Dhcpv6SrvLeaseRec struct {
+	Duid      string       `json:"duid"` // hex
+	Type      string       `json:"type"` // na/pd
+	Iaid      uint32       `json:"iaid"`
+	Ipv6      core.Ipv6Key `json:"ipv6"`
+	PrefixLen uint8        `json:"prefix_len"`
+	State     string       `json:"state"`
+	Static    bool         `json:"static"`
+	Remaining uint32       `json:"remaining"` // sec
+	LinkAddr  core.Ipv6Key `json:"link_addr"`
+}
+
+// dhcpv6SrvIa an IA_NA/IA_PD of the request
+type dhcpv6SrvIa struct {
+	pd      bool
+	iaid    uint32
+	hint    core.Ipv6Key // the requested address/prefix
+	hintLen uint8
+}
+
+// dhcpv6SrvReq the fields of a request that are required for the reply
+type dhcpv6SrvReq struct {
+	mt          layers.DHCPv6MsgType
+	xid         []byte
+	cid         []byte
+	duid        string
+	sid         []byte
+	ias         []dhcpv6SrvIa
+	rapidCommit bool
+	relays      []layers.DHCPv6 // relay forward messages, the first is the outer one
+	linkAddr    core.Ipv6Key    // link-address of the relay closest to the client
+	srcMac      core.MACKey
+	srcIpv6     core.Ipv6Key
+	srcPort     uint16
+}
+
+// PluginDhcpv6SrvNs DHCPv6 server per namespace
+type PluginDhcpv6SrvNs struct {
+	core.PluginBase
+	init       Dhcpv6SrvInit
+	enable     bool
+	timerw     *core.TimerCtx
+	naPools    []*dhcpv6SrvPool
+	pdPools    []*dhcpv6SrvPool
+	static     map[string]*Dhcpv6SrvStaticInit
+	staticIps  map[core.Ipv6Key]bool
+	leases     map[dhcpv6SrvLeaseKey]*dhcpv6SrvLease
+	ips        map[core.Ipv6Key]*dhcpv6SrvLease
+	head       core.DList
+	activeIter *core.DList
+	iterReady  bool
+	stats      Dhcpv6SrvStats
+	cdb        *core.CCounterDb
+	cdbv       *core.CCounterDbVec
+}
+
+func normDuid(duid string) string {
+	return strings.ToLower(strings.Replace(duid, ":", "", -1))
+}
+
+func NewDhcpv6SrvNs(ctx *core.PluginCtx, initJson []byte) *core.PluginBase {
+	o := new(PluginDhcpv6SrvNs)
+	o.InitPluginBase(ctx, o)
+	o.RegisterEvents(ctx, []string{}, o)
+	o.timerw = ctx.Tctx.GetTimerCtx()
+	o.cdb = NewDhcpv6SrvStatsDb(&o.stats)
+	o.cdbv = core.NewCCounterDbVec("dhcpv6srv")
+	o.cdbv.Add(o.cdb)
+	o.static = make(map[string]*Dhcpv6SrvStaticInit)
+	o.staticIps = make(map[core.Ipv6Key]bool)
+	o.leases = make(map[dhcpv6SrvLeaseKey]*dhcpv6SrvLease)
+	o.ips = make(map[core.Ipv6Key]*dhcpv6SrvLease)
+	o.head.SetSelf()
+
+	o.init = Dhcpv6SrvInit{Preferred: DHCPV6SRV_DEF_PREFERRED_SEC, Valid: DHCPV6SRV_DEF_VALID_SEC, Offer: DHCPV6SRV_DEF_OFFER_SEC}
+	err := o.Tctx.UnmarshalValidate(initJson, &o.init)
+	if err != nil {
+		o.stats.errInitJson++
+		return &o.PluginBase
+	}
+	for _, cfg := range o.init.NaPools {
+		p := &dhcpv6SrvPool{base: cfg.Min, linkLen: cfg.Prefix, preferred: cfg.Preferred, valid: cfg.Valid}
+		p.min = binary.BigEndian.Uint64(cfg.Min[8:16])
+		p.max = binary.BigEndian.Uint64(cfg.Max[8:16])
+		if !bytes.Equal(cfg.Min[0:8], cfg.Max[0:8]) || p.min > p.max {
+			o.stats.errInitJson++
+			return &o.PluginBase
+		}
+		if p.linkLen == 0 {
+			p.linkLen = 64
+		}
+		o.naPools = append(o.naPools, p)
+	}
+	for _, cfg := range o.init.PdPools {
+		if cfg.PrefixLen > cfg.DelegatedLen {
+			o.stats.errInitJson++
+			return &o.PluginBase
+		}
+		p := &dhcpv6SrvPool{pd: true, base: cfg.Prefix, linkLen: cfg.DelegatedLen, preferred: cfg.Preferred, valid: cfg.Valid}
+		binary.BigEndian.PutUint64(p.base[8:16], 0)
+		p.max = (uint64(1) << (cfg.DelegatedLen - cfg.PrefixLen)) - 1
+		o.pdPools = append(o.pdPools, p)
+	}
+	for _, pools := range [][]*dhcpv6SrvPool{o.naPools, o.pdPools} {
+		for _, p := range pools {
+			p.next = p.min
+			if p.preferred == 0 {
+				p.preferred = o.init.Preferred
+			}
+			if p.valid == 0 {
+				p.valid = o.init.Valid
+			}
+			if p.valid < p.preferred {
+				p.valid = p.preferred
+			}
+		}
+	}
+	for i := range o.init.Static {
+		s := &o.init.Static[i]
+		o.static[normDuid(s.Duid)] = s
+		if !s.Ipv6.IsZero() {
+			o.staticIps[s.Ipv6] = true
+		}
+		if !s.Prefix.IsZero() {
+			o.staticIps[s.Prefix] = true
+		}
+	}
+	o.enable = true
+	return &o.PluginBase
+}
+
+func (o *PluginDhcpv6SrvNs) GetCounterDbVec() *core.CCounterDbVec {
+	return o.cdbv
+}
+
+func (o *PluginDhcpv6SrvNs) OnRemove(ctx *core.PluginCtx) {
+	for _, l := range o.leases {
+		o.removeLease(l)
+	}
+}
+
+func (o *PluginDhcpv6SrvNs) OnEvent(msg string, a, b interface{}) {
+
+}
+
+/* OnEvent lease timer callback */
+func (o *PluginDhcpv6SrvNs) onLeaseTimer(l *dhcpv6SrvLease) {
+	if l.state == DHCPV6SRV_LEASE_OFFERED {
+		o.stats.leaseOfferTimeout++
+	} else {
+		o.stats.leaseExpired++
+	}
+	o.removeLease(l)
+}
+
+type dhcpv6SrvLeaseTimer struct{}
+
+func (t dhcpv6SrvLeaseTimer) OnEvent(a, b interface{}) {
+	a.(*PluginDhcpv6SrvNs).onLeaseTimer(b.(*dhcpv6SrvLease))
+}
+
+func (o *PluginDhcpv6SrvNs) addLease(key dhcpv6SrvLeaseKey, ipv6 core.Ipv6Key, plen uint8) *dhcpv6SrvLease {
+	l := new(dhcpv6SrvLease)
+	l.key = key
+	l.ipv6 = ipv6
+	l.prefixLen = plen
+	l.static = o.staticIps[ipv6]
+	l.timer.SetCB(dhcpv6SrvLeaseTimer{}, o, l)
+	o.leases[key] = l
+	o.ips[ipv6] = l
+	o.head.AddLast(&l.dlist)
+	o.stats.leaseAdd++
+	o.stats.leaseActive++
+	return l
+}
+
+func (o *PluginDhcpv6SrvNs) removeLease(l *dhcpv6SrvLease) {
+	if l.timer.IsRunning() {
+		o.timerw.Stop(&l.timer)
+	}
+	if o.activeIter == &l.dlist {
+		// it is going to be removed
+		o.activeIter = l.dlist.Next()
+	}
+	o.head.RemoveNode(&l.dlist)
+	delete(o.leases, l.key)
+	delete(o.ips, l.ipv6)
+	o.stats.leaseRemove++
+	o.stats.leaseActive--
+}
+
+// startLease moves the lease to state and restart its timer
+func (o *PluginDhcpv6SrvNs) startLease(l *dhcpv6SrvLease, state uint8, sec uint32) {
+	if l.timer.IsRunning() {
+		o.timerw.Stop(&l.timer)
+	}
+	l.state = state
+	ticks := o.timerw.DurationToTicks(time.Duration(sec) * time.Second)
+	l.expire = o.timerw.Ticks + uint64(ticks)
+	o.timerw.StartTicks(&l.timer, ticks)
+}
+
+func (o *PluginDhcpv6SrvNs) isFree(ipv6 core.Ipv6Key, server *core.CClient) bool {
+	return o.ips[ipv6] == nil && !o.staticIps[ipv6] && !server.OwnsIPv6(ipv6)
+}
+
+// allocFromPool returns the hint in case it is free or the next free address/prefix of the pool
+func (o *PluginDhcpv6SrvNs) allocFromPool(ia *dhcpv6SrvIa, pool *dhcpv6SrvPool, server *core.CClient) (core.Ipv6Key, bool) {
+	if !ia.hint.IsZero() && pool.inRange(ia.hint, ia.hintLen) && o.isFree(ia.hint, server) {
+		return ia.hint, true
+	}
+	var ipv6 core.Ipv6Key
+	size := pool.max - pool.min + 1
+	for i := uint64(0); i < size || size == 0; i++ {
+		ipv6 = pool.get(pool.next)
+		if pool.next == pool.max {
+			pool.next = pool.min
+		} else {
+			pool.next++
+		}
+		if o.isFree(ipv6, server) {
+			return ipv6, true
+		}
+	}
+	return ipv6, false
+}
+
+// getNaPool returns the na pool of the relay link or the server link
+func (o *PluginDhcpv6SrvNs) getNaPool(req *dhcpv6SrvReq, server *core.CClient) *dhcpv6SrvPool {
+	key := req.linkAddr
+	if key.IsZero() {
+		key, _ = server.GetSourceIPv6()
+	}
+	for _, p := range o.naPools {
+		if p.inSubnet(key) {
+			return p
+		}
+	}
+	if req.linkAddr.IsZero() && len(o.naPools) > 0 {
+		return o.naPools[0]
+	}
+	return nil
+}
+
+// allocLease returns the lease of the IA, a new one is allocated from the static binding or the pools
+func (o *PluginDhcpv6SrvNs) allocLease(req *dhcpv6SrvReq, ia *dhcpv6SrvIa, server *core.CClient) *dhcpv6SrvLease {
+	key := dhcpv6SrvLeaseKey{duid: req.duid, pd: ia.pd, iaid: ia.iaid}
+	if l := o.leases[key]; l != nil {
+		return l
+	}
+	var pool *dhcpv6SrvPool
+	var ipv6 core.Ipv6Key
+	var plen uint8 = 128
+	ok := false
+	if s := o.static[req.duid]; s != nil {
+		if !ia.pd && !s.Ipv6.IsZero() {
+			ipv6, ok = s.Ipv6, true
+		}
+		if ia.pd && !s.Prefix.IsZero() {
+			ipv6, plen, ok = s.Prefix, s.PrefixLen, true
+		}
+		if ok {
+			if l := o.ips[ipv6]; l != nil {
+				o.removeLease(l)
+			}
+			pool = &dhcpv6SrvPool{preferred: o.init.Preferred, valid: o.init.Valid}
+		}
+	}
+	if !ok && !ia.pd {
+		pool = o.getNaPool(req, server)
+		if pool == nil {
+			o.stats.errNoPool++
+			return nil
+		}
+		ipv6, ok = o.allocFromPool(ia, pool, server)
+	}
+	if !ok && ia.pd {
+		// the pools of the requested length first
+		for _, match := range []bool{true, false} {
+			for _, p := range o.pdPools {
+				if ok || (match != (ia.hintLen == p.linkLen)) {
+					continue
+				}
+				if ipv6, ok = o.allocFromPool(ia, p, server); ok {
+					pool, plen = p, p.linkLen
+				}
+			}
+		}
+	}
+	if !ok {
+		o.stats.errPoolEmpty++
+		return nil
+	}
+	l := o.addLease(key, ipv6, plen)
+	l.preferred = pool.preferred
+	l.valid = pool.valid
+	return l
+}
+
+// getIaOption returns the IA_NA/IA_PD option of the lease or with a status code in case there isn't one
+func (o *PluginDhcpv6SrvNs) getIaOption(ia *dhcpv6SrvIa, l *dhcpv6SrvLease, status uint16) layers.DHCPv6Option {
+	b := make([]byte, 12)
+	binary.BigEndian.PutUint32(b[0:4], ia.iaid)
+	code := layers.DHCPv6OptIANA
+	if ia.pd {
+		code = layers.DHCPv6OptIAPD
+	}
+	if l == nil {
+		b = append(b, EncodeOption(layers.NewDHCPv6Option(layers.DHCPv6OptStatusCode, []byte{0, byte(status)}))...)
+		return layers.NewDHCPv6Option(code, b)
+	}
+	// RFC 8415 21.4 recommended values
+	binary.BigEndian.PutUint32(b[4:8], l.preferred/2)
+	binary.BigEndian.PutUint32(b[8:12], uint32(uint64(l.preferred)*4/5))
+	if ia.pd {
+		p := make([]byte, 25)
+		binary.BigEndian.PutUint32(p[0:4], l.preferred)
+		binary.BigEndian.PutUint32(p[4:8], l.valid)
+		p[8] = l.prefixLen
+		copy(p[9:25], l.ipv6[:])
+		b = append(b, EncodeOption(layers.NewDHCPv6Option(layers.DHCPv6OptIAPrefix, p))...)
+	} else {
+		p := make([]byte, 24)
+		copy(p[0:16], l.ipv6[:])
+		binary.BigEndian.PutUint32(p[16:20], l.preferred)
+		binary.BigEndian.PutUint32(p[20:24], l.valid)
+		b = append(b, EncodeOption(layers.NewDHCPv6Option(layers.DHCPv6OptIAAddr, p))...)
+	}
+	return layers.NewDHCPv6Option(code, b)
+}
+
+// encodeDomain encodes the domain name in DNS format, RFC 1035 3.1
+func encodeDomain(domain string) []byte {
+	var b []byte
+	for _, label := range strings.Split(strings.Trim(domain, "."), ".") {
+		if len(label) == 0 || len(label) > 63 {
+			continue
+		}
+		b = append(b, byte(len(label)))
+		b = append(b, label...)
+	}
+	return append(b, 0)
+}
+
+func (o *PluginDhcpv6SrvNs) getServerId(server *core.CClient) []byte {
+	duid := &layers.DHCPv6DUID{Type: layers.DHCPv6DUIDTypeLL, HardwareType: []byte{0, 1}, LinkLayerAddress: server.Mac[:]}
+	return duid.Encode()
+}
+
+func (o *PluginDhcpv6SrvNs) sendReply(req *dhcpv6SrvReq, server *core.CClient, mt layers.DHCPv6MsgType, ops []layers.DHCPv6Option) {
+	dhcp := &layers.DHCPv6{MsgType: mt, TransactionID: req.xid}
+	if req.cid != nil {
+		dhcp.Options = append(dhcp.Options, layers.NewDHCPv6Option(layers.DHCPv6OptClientID, req.cid))
+	}
+	dhcp.Options = append(dhcp.Options, layers.NewDHCPv6Option(layers.DHCPv6OptServerID, o.getServerId(server)))
+	dhcp.Options = append(dhcp.Options, ops...)
+	if mt == layers.DHCPv6MsgTypeAdverstise && o.init.Preference > 0 {
+		dhcp.Options = append(dhcp.Options, layers.NewDHCPv6Option(layers.DHCPv6OptPreference, []byte{o.init.Preference}))
+	}
+	if len(o.init.Dns) > 0 {
+		var dns []byte
+		for _, d := range o.init.Dns {
+			dns = append(dns, d[:]...)
+		}
+		dhcp.Options = append(dhcp.Options, layers.NewDHCPv6Option(layers.DHCPv6OptDNSServers, dns))
+	}
+	if o.init.Domain != "" {
+		dhcp.Options = append(dhcp.Options, layers.NewDHCPv6Option(layers.DHCPv6OptDomainList, encodeDomain(o.init.Domain)))
+	}
+	msg := core.PacketUtlBuild(dhcp)
+
+	// Relay-Reply for each relay, from the inner one, RFC 8415 19.3
+	for i := len(req.relays) - 1; i >= 0; i-- {
+		r := &req.relays[i]
+		relay := &layers.DHCPv6{MsgType: layers.DHCPv6MsgTypeRelayReply, HopCount: r.HopCount, LinkAddr: r.LinkAddr, PeerAddr: r.PeerAddr}
+		for _, op := range r.Options {
+			if op.Code == layers.DHCPv6OptInterfaceID {
+				relay.Options = append(relay.Options, layers.NewDHCPv6Option(layers.DHCPv6OptInterfaceID, op.Data))
+			}
+		}
+		relay.Options = append(relay.Options, layers.NewDHCPv6Option(layers.DHCPv6OptRelayMessage, msg))
+		msg = core.PacketUtlBuild(relay)
+	}
+
+	var src core.Ipv6Key
+	if req.srcIpv6[0] == 0xfe && (req.srcIpv6[1]&0xc0) == 0x80 {
+		server.GetIpv6LocalLink(&src)
+	} else {
+		var err error
+		src, err = server.GetSourceIPv6()
+		if err != nil {
+			o.stats.errNoServer++
+			return
+		}
+	}
+	dstPort := uint16(DHCPV6_CLIENT_PORT)
+	if len(req.relays) > 0 {
+		dstPort = DHCPV6_SERVER_PORT
+	}
+
+	d := core.PacketUtlBuild(
+		&layers.IPv6{Version: 6, NextHeader: layers.IPProtocolUDP, HopLimit: 64,
+			SrcIP: src.ToIP(), DstIP: req.srcIpv6.ToIP()},
+		&layers.UDP{SrcPort: DHCPV6_SERVER_PORT, DstPort: layers.UDPPort(dstPort)},
+		gopacket.Payload(msg),
+	)
+	ipv6 := layers.IPv6Header(d[0:IPV6_HEADER_SIZE])
+	binary.BigEndian.PutUint16(d[IPV6_HEADER_SIZE+4:IPV6_HEADER_SIZE+6], uint16(len(d)-IPV6_HEADER_SIZE))
+	ipv6.SetPyloadLength(uint16(len(d) - IPV6_HEADER_SIZE))
+	ipv6.FixUdpL4Checksum(d[IPV6_HEADER_SIZE:], 0)
+
+	pkt := server.GetL2Header(false, uint16(layers.EthernetTypeIPv6))
+	copy(pkt[0:6], req.srcMac[:])
+	pkt = append(pkt, d...)
+
+	if mt == layers.DHCPv6MsgTypeAdverstise {
+		o.stats.pktTxAdvertise++
+	} else {
+		o.stats.pktTxReply++
+	}
+	o.Tctx.Veth.SendBuffer(false, server, pkt)
+}
+
+func (o *PluginDhcpv6SrvNs) handleSolicit(req *dhcpv6SrvReq, server *core.CClient) {
+	o.stats.pktRxSolicit++
+	commit := req.rapidCommit && o.init.RapidCommit
+	if commit {
+		o.stats.pktRxRapidCommit++
+	}
+	var ops []layers.DHCPv6Option
+	for i := range req.ias {
+		ia := &req.ias[i]
+		l := o.allocLease(req, ia, server)
+		if l != nil {
+			l.linkAddr = req.linkAddr
+			if commit {
+				o.startLease(l, DHCPV6SRV_LEASE_BOUND, l.valid)
+			} else if l.state != DHCPV6SRV_LEASE_BOUND {
+				o.startLease(l, DHCPV6SRV_LEASE_OFFERED, o.init.Offer)
+			}
+		}
+		status := uint16(STATUS_NoAddrsAvail)
+		if ia.pd {
+			status = STATUS_NoPrefixAvail
+		}
+		ops = append(ops, o.getIaOption(ia, l, status))
+	}
+	if commit {
+		ops = append(ops, layers.NewDHCPv6Option(layers.DHCPv6OptRapidCommit, []byte{}))
+		o.sendReply(req, server, layers.DHCPv6MsgTypeReply, ops)
+		return
+	}
+	o.sendReply(req, server, layers.DHCPv6MsgTypeAdverstise, ops)
+}
+
+// handleRequest handles request/renew/rebind, a new lease is allocated only for a request
+func (o *PluginDhcpv6SrvNs) handleRequest(req *dhcpv6SrvReq, server *core.CClient) {
+	var ops []layers.DHCPv6Option
+	for i := range req.ias {
+		ia := &req.ias[i]
+		var l *dhcpv6SrvLease
+		status := uint16(STATUS_NoBinding)
+		if req.mt == layers.DHCPv6MsgTypeRequest {
+			l = o.allocLease(req, ia, server)
+			status = STATUS_NoAddrsAvail
+			if ia.pd {
+				status = STATUS_NoPrefixAvail
+			}
+		} else {
+			l = o.leases[dhcpv6SrvLeaseKey{duid: req.duid, pd: ia.pd, iaid: ia.iaid}]
+			if l == nil {
+				o.stats.pktRxNoLease++
+			}
+		}
+		if l != nil {
+			l.linkAddr = req.linkAddr
+			o.startLease(l, DHCPV6SRV_LEASE_BOUND, l.valid)
+		}
+		ops = append(ops, o.getIaOption(ia, l, status))
+	}
+	o.sendReply(req, server, layers.DHCPv6MsgTypeReply, ops)
+}
+
+// handleRelease handles release/decline, the leases of the IAs are removed
+func (o *PluginDhcpv6SrvNs) handleRelease(req *dhcpv6SrvReq, server *core.CClient) {
+	for _, ia := range req.ias {
+		if l := o.leases[dhcpv6SrvLeaseKey{duid: req.duid, pd: ia.pd, iaid: ia.iaid}]; l != nil {
+			o.removeLease(l)
+		}
+	}
+	ops := []layers.DHCPv6Option{layers.NewDHCPv6Option(layers.DHCPv6OptStatusCode, []byte{0, STATUS_Success})}
+	o.sendReply(req, server, layers.DHCPv6MsgTypeReply, ops)
+}
+
+// isForServer returns true in case the destination MAC is multicast or the MAC of the server
+func (o *PluginDhcpv6SrvNs) isForServer(ps *core.ParserPacketState) bool {
+	var mac core.MACKey
+	copy(mac[:], ps.M.GetData()[0:6])
+	return mac[0] == 0x33 && mac[1] == 0x33 || mac == o.init.ServerMac
+}
+
+// decodeIa decodes IA_NA/IA_PD, the first IA Address/IA Prefix is the hint
+func decodeIa(data []byte, pd bool) (ia dhcpv6SrvIa, ok bool) {
+	if len(data) < 12 {
+		return ia, false
+	}
+	ia.pd = pd
+	ia.iaid = binary.BigEndian.Uint32(data[0:4])
+	p := data[12:]
+	for len(p) >= 4 {
+		code := layers.DHCPv6Opt(binary.BigEndian.Uint16(p[0:2]))
+		length := int(binary.BigEndian.Uint16(p[2:4]))
+		if len(p) < 4+length {
+			return ia, false
+		}
+		d := p[4 : 4+length]
+		if !pd && code == layers.DHCPv6OptIAAddr && length >= 24 && ia.hintLen == 0 {
+			copy(ia.hint[:], d[0:16])
+			ia.hintLen = 128
+		}
+		if pd && code == layers.DHCPv6OptIAPrefix && length >= 25 && ia.hintLen == 0 {
+			ia.hintLen = d[8]
+			copy(ia.hint[:], d[9:25])
+		}
+		p = p[4+length:]
+	}
+	return ia, true
+}
+
+func (o *PluginDhcpv6SrvNs) HandleRxDhcpPacket(ps *core.ParserPacketState) int {
+	if !o.enable {
+		return core.PARSER_ERR
+	}
+	m := ps.M
+	p := m.GetData()
+
+	dhcphlen := ps.L7Len
+	if dhcphlen < 4 {
+		o.stats.pktRxLenErr++
+		return core.PARSER_ERR
+	}
+
+	server := o.Ns.CLookupByMac(&o.init.ServerMac)
+	if server == nil {
+		o.stats.errNoServer++
+		return core.PARSER_ERR
+	}
+
+	var req dhcpv6SrvReq
+	var dhcph layers.DHCPv6
+	copy(req.srcMac[:], p[6:12])
+	ipv6 := layers.IPv6Header(p[ps.L3 : ps.L3+IPV6_HEADER_SIZE])
+	copy(req.srcIpv6[:], ipv6.SrcIP())
+
+	msg := p[ps.L7 : ps.L7+dhcphlen]
+	for {
+		if len(msg) < 4 {
+			o.stats.pktRxLenErr++
+			return core.PARSER_ERR
+		}
+		err := dhcph.DecodeFromBytes(msg, gopacket.NilDecodeFeedback)
+		if err != nil {
+			o.stats.pktRxParserErr++
+			return core.PARSER_ERR
+		}
+		if dhcph.MsgType != layers.DHCPv6MsgTypeRelayForward {
+			break
+		}
+		if len(req.relays) == DHCPV6_HOP_COUNT_LIMIT {
+			o.stats.pktRxParserErr++
+			return core.PARSER_ERR
+		}
+		msg = nil
+		relay := layers.DHCPv6{MsgType: dhcph.MsgType, HopCount: dhcph.HopCount,
+			LinkAddr: append(net.IP{}, dhcph.LinkAddr...), PeerAddr: append(net.IP{}, dhcph.PeerAddr...)}
+		for _, op := range dhcph.Options {
+			switch op.Code {
+			case layers.DHCPv6OptRelayMessage:
+				msg = op.Data
+			case layers.DHCPv6OptInterfaceID:
+				relay.Options = append(relay.Options, layers.NewDHCPv6Option(op.Code, append([]byte{}, op.Data...)))
+			}
+		}
+		var link core.Ipv6Key
+		copy(link[:], relay.LinkAddr)
+		if !link.IsZero() {
+			req.linkAddr = link
+		}
+		req.relays = append(req.relays, relay)
+	}
+	if len(req.relays) > 0 {
+		o.stats.pktRxRelayed++
+	}
+
+	req.mt = dhcph.MsgType
+	req.xid = append([]byte{}, dhcph.TransactionID...)
+	for _, op := range dhcph.Options {
+		switch op.Code {
+		case layers.DHCPv6OptClientID:
+			req.cid = append([]byte{}, op.Data...)
+			req.duid = hex.EncodeToString(op.Data)
+		case layers.DHCPv6OptServerID:
+			req.sid = op.Data
+		case layers.DHCPv6OptIANA, layers.DHCPv6OptIAPD:
+			if ia, ok := decodeIa(op.Data, op.Code == layers.DHCPv6OptIAPD); ok {
+				req.ias = append(req.ias, ia)
+			}
+		case layers.DHCPv6OptRapidCommit:
+			req.rapidCommit = true
+		}
+	}
+
+	if req.mt == layers.DHCPv6MsgTypeInformationRequest {
+		o.stats.pktRxInfoRequest++
+		o.sendReply(&req, server, layers.DHCPv6MsgTypeReply, nil)
+		return 0
+	}
+
+	if req.cid == nil {
+		o.stats.pktRxNoClientId++
+		return core.PARSER_ERR
+	}
+
+	switch req.mt {
+	case layers.DHCPv6MsgTypeSolicit, layers.DHCPv6MsgTypeRebind:
+		// without server id
+		if req.sid != nil {
+			o.stats.pktRxParserErr++
+			return core.PARSER_ERR
+		}
+	case layers.DHCPv6MsgTypeRequest, layers.DHCPv6MsgTypeRenew, layers.DHCPv6MsgTypeRelease, layers.DHCPv6MsgTypeDecline:
+		if !bytes.Equal(req.sid, o.getServerId(server)) {
+			o.stats.pktRxOtherServer++
+			return 0
+		}
+	}
+
+	switch req.mt {
+	case layers.DHCPv6MsgTypeSolicit:
+		o.handleSolicit(&req, server)
+	case layers.DHCPv6MsgTypeRequest:
+		o.stats.pktRxRequest++
+		o.handleRequest(&req, server)
+	case layers.DHCPv6MsgTypeRenew:
+		o.stats.pktRxRenew++
+		o.handleRequest(&req, server)
+	case layers.DHCPv6MsgTypeRebind:
+		o.stats.pktRxRebind++
+		o.handleRequest(&req, server)
+	case layers.DHCPv6MsgTypeRelease:
+		o.stats.pktRxRelease++
+		o.handleRelease(&req, server)
+	case layers.DHCPv6MsgTypeDecline:
+		o.stats.pktRxDecline++
+		o.handleRelease(&req, server)
+	default:
+		o.stats.pktRxUnhandle++
+	}
+	return 0
+}
+
+func (o *PluginDhcpv6SrvNs) getLeaseRec(l *dhcpv6SrvLease) Dhcpv6SrvLeaseRec {
+	r := Dhcpv6SrvLeaseRec{Duid: l.key.duid, Iaid: l.key.iaid, Ipv6: l.ipv6, PrefixLen: l.prefixLen, Static: l.static, LinkAddr: l.linkAddr}
+	r.Type = "na"
+	if l.key.pd {
+		r.Type = "pd"
+	}
+	r.State = "offered"
+	if l.state == DHCPV6SRV_LEASE_BOUND {
+		r.State = "bound"
+	}
+	if l.expire > o.timerw.Ticks {
+		r.Remaining = uint32(time.Duration(l.expire-o.timerw.Ticks) * o.timerw.TickDuration / time.Second)
+	}
+	return r
+}
+
+func (o *PluginDhcpv6SrvNs) IterReset() bool {
+	o.activeIter = o.head.Next()
+	if o.head.IsEmpty() {
+		o.iterReady = false
+		return true
+	}
+	o.iterReady = true
+	return false
+}
+
+func (o *PluginDhcpv6SrvNs) IterIsStopped() bool {
+	return !o.iterReady
+}
+
+func (o *PluginDhcpv6SrvNs) GetNext(n uint16) ([]Dhcpv6SrvLeaseRec, error) {
+	r := make([]Dhcpv6SrvLeaseRec, 0)
+
+	if !o.iterReady {
+		return r, fmt.Errorf(" Iterator is not ready- reset the iterator")
+	}
+
+	cnt := 0
+	for {
+		if o.activeIter == &o.head {
+			o.iterReady = false
+			break
+		}
+		cnt++
+		if cnt > int(n) {
+			break
+		}
+		r = append(r, o.getLeaseRec(covertToDhcpv6SrvLease(o.activeIter)))
+		o.activeIter = o.activeIter.Next()
+	}
+	return r, nil
+}
+
+// HandleRxDhcpv6SrvPacket Parser call this function with mbuf from the pool
+func HandleRxDhcpv6SrvPacket(ps *core.ParserPacketState) int {
+	ns := ps.Tctx.GetNs(ps.Tun)
+	if ns == nil {
+		return core.PARSER_ERR
+	}
+	nsplg := ns.PluginCtx.Get(DHCPV6SRV_PLUG)
+	if nsplg == nil || !nsplg.Ext.(*PluginDhcpv6SrvNs).isForServer(ps) {
+		return ps.Tctx.HandleUdpDefault(ps)
+	}
+	srvPlug := nsplg.Ext.(*PluginDhcpv6SrvNs)
+	return srvPlug.HandleRxDhcpPacket(ps)
+}
+
+type PluginDhcpv6SrvNsReg struct{}
+
+func (o PluginDhcpv6SrvNsReg) NewPlugin(ctx *core.PluginCtx, initJson []byte) *core.PluginBase {
+	return NewDhcpv6SrvNs(ctx, initJson)
+}
+
+/*******************************************/
+/*  RPC commands */
+type (
+	ApiDhcpv6SrvNsCntHandler  struct{}
+	ApiDhcpv6SrvNsIterHandler struct{}
+	ApiDhcpv6SrvNsIterParams  struct {
+		Reset bool   `json:"reset"`
+		Count uint16 `json:"count" validate:"required,gte=0,lte=255"`
+	}
+	ApiDhcpv6SrvNsIterResult struct {
+		Empty   bool                `json:"empty"`
+		Stopped bool                `json:"stopped"`
+		Vec     []Dhcpv6SrvLeaseRec `json:"data"`
+	}
+
+	ApiDhcpv6SrvNsReleaseHandler struct{}
+	ApiDhcpv6SrvNsReleaseParams  struct {
+		Duids []string `json:"duids" validate:"required"`
+	}
+)
+
+func getSrvNsPlugin(ctx interface{}, params *fastjson.RawMessage) (*PluginDhcpv6SrvNs, *jsonrpc.Error) {
+	tctx := ctx.(*core.CThreadCtx)
+	plug, err := tctx.GetNsPlugin(params, DHCPV6SRV_PLUG)
+
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+	return plug.Ext.(*PluginDhcpv6SrvNs), nil
+}
+
+func (h ApiDhcpv6SrvNsCntHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	var p core.ApiCntParams
+	tctx := ctx.(*core.CThreadCtx)
+	srv, err := getSrvNsPlugin(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	return srv.cdbv.GeneralCounters(nil, tctx, params, &p)
+}
+
+func (h ApiDhcpv6SrvNsIterHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	var p ApiDhcpv6SrvNsIterParams
+	var res ApiDhcpv6SrvNsIterResult
+	tctx := ctx.(*core.CThreadCtx)
+
+	srv, err := getSrvNsPlugin(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	err1 := tctx.UnmarshalValidate(*params, &p)
+	if err1 != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err1.Error(),
+		}
+	}
+
+	if p.Reset {
+		res.Empty = srv.IterReset()
+	}
+	if res.Empty {
+		return &res, nil
+	}
+	if srv.IterIsStopped() {
+		res.Stopped = true
+		return &res, nil
+	}
+
+	vec, err2 := srv.GetNext(p.Count)
+	if err2 != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err2.Error(),
+		}
+	}
+	res.Vec = vec
+	return &res, nil
+}
+
+func (h ApiDhcpv6SrvNsReleaseHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	var p ApiDhcpv6SrvNsReleaseParams
+	tctx := ctx.(*core.CThreadCtx)
+
+	srv, err := getSrvNsPlugin(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	err1 := tctx.UnmarshalValidate(*params, &p)
+	if err1 != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err1.Error(),
+		}
+	}
+	duids := make(map[string]bool)
+	for _, duid := range p.Duids {
+		duids[normDuid(duid)] = true
+	}
+	for key, l := range srv.leases {
+		if duids[key.duid] {
+			srv.removeLease(l)
+		}
+	}
+	return nil, nil
+}
+
+func init() {
+	core.PluginRegister(DHCPV6SRV_PLUG,
+		core.PluginRegisterData{Client: nil,
+			Ns:     PluginDhcpv6SrvNsReg{},
+			Thread: nil})
+
+	core.RegisterCB("dhcpv6srv_ns_cnt", ApiDhcpv6SrvNsCntHandler{}, false)         // get counters/meta
+	core.RegisterCB("dhcpv6srv_ns_iter", ApiDhcpv6SrvNsIterHandler{}, false)       // iterate the leases
+	core.RegisterCB("dhcpv6srv_ns_release", ApiDhcpv6SrvNsReleaseHandler{}, false) // release the leases of duids
+
+	/* register callback for rx side*/
+	core.ParserRegister(DHCPV6SRV_PLUG, HandleRxDhcpv6SrvPacket,
+		core.ParserRegisterData{UdpV6Ports: []uint16{DHCPV6_SERVER_PORT}})
+}
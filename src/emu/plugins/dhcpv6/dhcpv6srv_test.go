@@ -0,0 +1,225 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package dhcpv6
+
+import (
+	"bytes"
+	"emu/core"
+	"encoding/binary"
+	"external/google/gopacket"
+	"external/google/gopacket/layers"
+	"net"
+	"testing"
+	"time"
+)
+
+type VethDhcpv6SrvSim struct {
+	loop bool
+	tx   [][]byte
+}
+
+func (o *VethDhcpv6SrvSim) ProcessTxToRx(m *core.Mbuf) *core.Mbuf {
+	o.tx = append(o.tx, append([]byte(nil), m.GetData()...))
+	if o.loop {
+		return m
+	}
+	m.FreeMbuf()
+	return nil
+}
+
+func createDhcpv6SrvSimulationEnv(sim *VethDhcpv6SrvSim, srvJson string) (*core.CThreadCtx, *core.CNSCtx) {
+	var simrx core.VethIFSim = sim
+	tctx := core.NewThreadCtx(0, 4510, true, &simrx)
+	var key core.CTunnelKey
+	key.Set(&core.CTunnelData{Vport: 1, Vlans: [2]uint32{0x81000001, 0x81000002}})
+	ns := core.NewNSCtx(tctx, &key)
+	tctx.AddNs(&key, ns)
+	ns.PluginCtx.CreatePlugins([]string{"dhcpv6", "dhcpv6srv"}, [][]byte{nil, []byte(srvJson)})
+
+	server := core.NewClient(ns, core.MACKey{0, 0, 1, 0, 0, 1},
+		core.Ipv4Key{0, 0, 0, 0},
+		core.Ipv6Key{},
+		core.Ipv4Key{0, 0, 0, 0})
+	ns.AddClient(server)
+	Register(tctx)
+	return tctx, ns
+}
+
+func getDhcpv6Srv(ns *core.CNSCtx) *PluginDhcpv6SrvNs {
+	return ns.PluginCtx.Get(DHCPV6SRV_PLUG).Ext.(*PluginDhcpv6SrvNs)
+}
+
+func ipv6Key(s string) core.Ipv6Key {
+	var key core.Ipv6Key
+	copy(key[:], Ipv6SA(s))
+	return key
+}
+
+const dhcpv6SrvTestJson = `{"server_mac": [0, 0, 1, 0, 0, 1], "rapid_commit": true, "dns": [[32, 1, 13, 184, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 83]],
+	"na_pools": [{"min": [32, 1, 13, 184, 0, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 16], "max": [32, 1, 13, 184, 0, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 32]},
+				 {"min": [32, 1, 13, 184, 0, 2, 0, 0, 0, 0, 0, 0, 0, 0, 0, 16], "max": [32, 1, 13, 184, 0, 2, 0, 0, 0, 0, 0, 0, 0, 0, 0, 16], "valid": 300, "preferred": 200}],
+	"pd_pools": [{"prefix": [32, 1, 13, 184, 16, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0], "prefix_len": 40, "delegated_len": 56}],
+	"static": [{"duid": "00:03:00:01:00:00:01:00:00:05", "ipv6": [32, 1, 13, 184, 0, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 5]}]}`
+
+func TestDhcpv6SrvLoopback(t *testing.T) {
+	sim := &VethDhcpv6SrvSim{loop: true}
+	tctx, ns := createDhcpv6SrvSimulationEnv(sim, dhcpv6SrvTestJson)
+	defer tctx.Delete()
+
+	macs := []core.MACKey{{0, 0, 1, 0, 0, 2}, {0, 0, 1, 0, 0, 3}, {0, 0, 1, 0, 0, 5}}
+	inits := []string{`{}`, `{"pd": {"prefix_len": 56}}`, `{}`}
+	for i, mac := range macs {
+		c := core.NewClient(ns, mac, core.Ipv4Key{}, core.Ipv6Key{}, core.Ipv4Key{})
+		ns.AddClient(c)
+		c.PluginCtx.CreatePlugins([]string{"dhcpv6"}, [][]byte{[]byte(inits[i])})
+	}
+	tctx.MainLoopSim(10 * time.Second)
+
+	srv := getDhcpv6Srv(ns)
+	exp := []string{"2001:db8:1::10", "2001:db8:1::11", "2001:db8:1::5"}
+	for i, mac := range macs {
+		c := ns.CLookupByMac(&mac)
+		if c.Dhcpv6 != ipv6Key(exp[i]) {
+			t.Fatalf(" client %v got %v ", mac, c.Dhcpv6.ToIP())
+		}
+	}
+	pd := ns.CLookupByMac(&macs[1]).Ipv6Pd
+	if pd == nil || pd.Prefix != ipv6Key("2001:db8:1000::") || pd.PrefixLen != 56 || pd.Valid != DHCPV6SRV_DEF_VALID_SEC {
+		t.Fatalf(" unexpected delegated prefix %+v ", pd)
+	}
+	l := srv.leases[dhcpv6SrvLeaseKey{duid: "00030001000001000003", pd: true, iaid: 0x12345678}]
+	if l == nil || l.state != DHCPV6SRV_LEASE_BOUND || l.ipv6 != pd.Prefix {
+		t.Fatalf(" prefix lease is not bound %+v ", l)
+	}
+	if !srv.ips[ipv6Key(exp[2])].static || srv.stats.leaseActive != 4 || srv.stats.pktTxReply != 3 || srv.stats.pktTxAdvertise != 3 {
+		t.Fatalf(" unexpected server state %+v ", srv.stats)
+	}
+
+	if srv.IterReset() {
+		t.Fatalf(" iterator should not be empty ")
+	}
+	recs, _ := srv.GetNext(10)
+	if len(recs) != 4 || recs[0].State != "bound" || recs[0].Remaining == 0 || recs[0].Remaining > DHCPV6SRV_DEF_VALID_SEC {
+		t.Fatalf(" unexpected leases %+v ", recs)
+	}
+	if recs[2].Type != "pd" || recs[2].PrefixLen != 56 {
+		t.Fatalf(" unexpected prefix lease %+v ", recs[2])
+	}
+
+	// renew of the clients at T1, half of the preferred lifetime
+	tctx.MainLoopSim(DHCPV6SRV_DEF_PREFERRED_SEC * time.Second)
+	if srv.stats.pktRxRenew != 6 || srv.stats.pktRxNoLease != 0 || srv.stats.leaseExpired != 0 {
+		t.Fatalf(" leases should be renewed %+v ", srv.stats)
+	}
+
+	// the release of the client removes the leases
+	ns.RemoveClient(ns.CLookupByMac(&macs[1]))
+	tctx.MainLoopSim(time.Second)
+	if srv.stats.pktRxRelease != 1 || srv.stats.leaseActive != 2 {
+		t.Fatalf(" leases should be released %+v ", srv.stats)
+	}
+}
+
+func buildDhcpv6SrvRelayForw(mac byte, link net.IP, rapidCommit bool) []byte {
+	dhcp := &layers.DHCPv6{MsgType: layers.DHCPv6MsgTypeSolicit, TransactionID: []byte{1, 2, 3}}
+	clientid := &layers.DHCPv6DUID{Type: layers.DHCPv6DUIDTypeLL, HardwareType: []byte{0, 1}, LinkLayerAddress: []byte{0, 0, 2, 0, 0, mac}}
+	dhcp.Options = append(dhcp.Options, layers.NewDHCPv6Option(layers.DHCPv6OptClientID, clientid.Encode()))
+	dhcp.Options = append(dhcp.Options, layers.NewDHCPv6Option(layers.DHCPv6OptIANA, []byte{0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 0}))
+	if rapidCommit {
+		dhcp.Options = append(dhcp.Options, layers.NewDHCPv6Option(layers.DHCPv6OptRapidCommit, []byte{}))
+	}
+	relay := &layers.DHCPv6{MsgType: layers.DHCPv6MsgTypeRelayForward, LinkAddr: link, PeerAddr: Ipv6SA("fe80::200:2ff:fe00:7")}
+	relay.Options = append(relay.Options, layers.NewDHCPv6Option(layers.DHCPv6OptInterfaceID, []byte("eth1/1")))
+	relay.Options = append(relay.Options, layers.NewDHCPv6Option(layers.DHCPv6OptRelayMessage, core.PacketUtlBuild(dhcp)))
+
+	d := core.PacketUtlBuild(
+		&layers.IPv6{Version: 6, NextHeader: layers.IPProtocolUDP, HopLimit: 64,
+			SrcIP: Ipv6SA("2001:db8:2::1"), DstIP: Ipv6SA("ff02::1:2")},
+		&layers.UDP{SrcPort: 547, DstPort: 547},
+		relay,
+	)
+	ipv6 := layers.IPv6Header(d[0:IPV6_HEADER_SIZE])
+	binary.BigEndian.PutUint16(d[IPV6_HEADER_SIZE+4:IPV6_HEADER_SIZE+6], uint16(len(d)-IPV6_HEADER_SIZE))
+	ipv6.SetPyloadLength(uint16(len(d) - IPV6_HEADER_SIZE))
+	ipv6.FixUdpL4Checksum(d[IPV6_HEADER_SIZE:], 0)
+
+	l2 := []byte{0x33, 0x33, 0, 1, 0, 2, 0, 0, 9, 0, 0, 9, 0x81, 00, 0x00, 0x01, 0x81, 00, 0x00, 0x02, 0x86, 0xdd}
+	return append(l2, d...)
+}
+
+func TestDhcpv6SrvRelay(t *testing.T) {
+	sim := &VethDhcpv6SrvSim{}
+	tctx, ns := createDhcpv6SrvSimulationEnv(sim, dhcpv6SrvTestJson)
+	defer tctx.Delete()
+	srv := getDhcpv6Srv(ns)
+	srv.Ns.CLookupByMac(&core.MACKey{0, 0, 1, 0, 0, 1}).UpdateIPv6(ipv6Key("2001:db8:1::1"))
+
+	tctx.Veth.OnRx(genMbuf(tctx, buildDhcpv6SrvRelayForw(7, Ipv6SA("2001:db8:2::1"), true)))
+	tctx.Veth.SimulatorCheckRxQueue()
+
+	if len(sim.tx) != 1 || srv.stats.pktRxRelayed != 1 || srv.stats.pktRxRapidCommit != 1 {
+		t.Fatalf(" expected one reply %+v ", srv.stats)
+	}
+	pkt := gopacket.NewPacket(sim.tx[0], layers.LayerTypeEthernet, gopacket.Default)
+	eth := pkt.Layer(layers.LayerTypeEthernet).(*layers.Ethernet)
+	ipv6 := pkt.Layer(layers.LayerTypeIPv6).(*layers.IPv6)
+	udp := pkt.Layer(layers.LayerTypeUDP).(*layers.UDP)
+	if !bytes.Equal(eth.DstMAC, net.HardwareAddr{0, 0, 9, 0, 0, 9}) || !ipv6.DstIP.Equal(Ipv6SA("2001:db8:2::1")) ||
+		!ipv6.SrcIP.Equal(Ipv6SA("2001:db8:1::1")) || udp.DstPort != 547 || udp.SrcPort != 547 {
+		t.Fatalf(" reply should be sent to the relay %v %v %v ", eth.DstMAC, ipv6.DstIP, udp.DstPort)
+	}
+	var relay, reply layers.DHCPv6
+	if relay.DecodeFromBytes(udp.Payload, gopacket.NilDecodeFeedback) != nil || relay.MsgType != layers.DHCPv6MsgTypeRelayReply ||
+		!relay.PeerAddr.Equal(Ipv6SA("fe80::200:2ff:fe00:7")) {
+		t.Fatalf(" relay reply is not valid %v ", relay)
+	}
+	if relay.Options[0].Code != layers.DHCPv6OptInterfaceID || string(relay.Options[0].Data) != "eth1/1" ||
+		relay.Options[1].Code != layers.DHCPv6OptRelayMessage {
+		t.Fatalf(" interface id should be echoed %v ", relay.Options)
+	}
+	if reply.DecodeFromBytes(relay.Options[1].Data, gopacket.NilDecodeFeedback) != nil || reply.MsgType != layers.DHCPv6MsgTypeReply {
+		t.Fatalf(" reply is not valid %v ", reply)
+	}
+	// pool of the relay link, rapid commit
+	var iana layers.DHCPv6OptionIANA
+	iana.IPv6 = make(net.IP, 16)
+	rapid := false
+	for _, op := range reply.Options {
+		switch op.Code {
+		case layers.DHCPv6OptIANA:
+			iana.Decode(op.Data)
+		case layers.DHCPv6OptRapidCommit:
+			rapid = true
+		}
+	}
+	if !rapid || !iana.OptionValid || !iana.IPv6.Equal(Ipv6SA("2001:db8:2::10")) || iana.ValidLife != 300 || iana.T1 != 100 {
+		t.Fatalf(" unexpected IA_NA %+v ", iana)
+	}
+	l := srv.ips[ipv6Key("2001:db8:2::10")]
+	if l == nil || l.state != DHCPV6SRV_LEASE_BOUND || l.linkAddr != ipv6Key("2001:db8:2::1") {
+		t.Fatalf(" lease should be bound %+v ", l)
+	}
+
+	// the pool is empty, advertise with NoAddrsAvail
+	tctx.Veth.OnRx(genMbuf(tctx, buildDhcpv6SrvRelayForw(8, Ipv6SA("2001:db8:2::2"), false)))
+	tctx.Veth.SimulatorCheckRxQueue()
+	if srv.stats.errPoolEmpty != 1 || srv.stats.pktTxAdvertise != 1 || len(srv.leases) != 1 {
+		t.Fatalf(" the pool should be empty %+v ", srv.stats)
+	}
+
+	// no pool for this link
+	tctx.Veth.OnRx(genMbuf(tctx, buildDhcpv6SrvRelayForw(9, Ipv6SA("2001:db8:3::1"), false)))
+	tctx.Veth.SimulatorCheckRxQueue()
+	if srv.stats.errNoPool != 1 {
+		t.Fatalf(" no pool is expected %+v ", srv.stats)
+	}
+
+	// lease expired
+	tctx.MainLoopSim(400 * time.Second)
+	if len(srv.leases) != 0 || srv.stats.leaseExpired != 1 {
+		t.Fatalf(" lease should be removed %+v ", srv.stats)
+	}
+}
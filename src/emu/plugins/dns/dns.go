@@ -0,0 +1,223 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package dns
+
+/*
+DNS (RFC 1035) helpers shared by the plugins of the package, the messages are encoded/decoded by the gopacket DNS layer.
+*/
+
+import (
+	"encoding/hex"
+	"external/google/gopacket"
+	"external/google/gopacket/layers"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+const (
+	DNS_PORT          = 53
+	DNS_HEADER_SIZE   = 12
+	DNS_MAX_UDP_SIZE  = 512
+	DNS_MAX_NAME_LEN  = 253
+	DNS_MAX_LABEL_LEN = 63
+)
+
+var dnsTypes = map[string]layers.DNSType{
+	"A":     layers.DNSTypeA,
+	"NS":    layers.DNSTypeNS,
+	"CNAME": layers.DNSTypeCNAME,
+	"SOA":   layers.DNSTypeSOA,
+	"PTR":   layers.DNSTypePTR,
+	"MX":    layers.DNSTypeMX,
+	"TXT":   layers.DNSTypeTXT,
+	"AAAA":  layers.DNSTypeAAAA,
+	"SRV":   layers.DNSTypeSRV,
+}
+
+var dnsRcodes = map[layers.DNSResponseCode]string{
+	layers.DNSResponseCodeNoErr:    "NOERROR",
+	layers.DNSResponseCodeFormErr:  "FORMERR",
+	layers.DNSResponseCodeServFail: "SERVFAIL",
+	layers.DNSResponseCodeNXDomain: "NXDOMAIN",
+	layers.DNSResponseCodeNotImp:   "NOTIMP",
+	layers.DNSResponseCodeRefused:  "REFUSED",
+}
+
+// DnsRecord is a resource record of an answer, data is formatted by the type, e.g. "10 5 5060 sip.example.com" for SRV
+type DnsRecord struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	Ttl  uint32 `json:"ttl"`
+	Data string `json:"data"`
+}
+
+func parseDnsType(s string) (layers.DNSType, error) {
+	t, ok := dnsTypes[strings.ToUpper(s)]
+	if !ok {
+		return 0, fmt.Errorf("unsupported dns type %q", s)
+	}
+	return t, nil
+}
+
+func rcodeString(rcode layers.DNSResponseCode) string {
+	if s, ok := dnsRcodes[rcode]; ok {
+		return s
+	}
+	return fmt.Sprintf("RCODE%d", rcode)
+}
+
+// canonicalName returns the name in lower case without the trailing dot
+func canonicalName(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
+
+// checkName verifies the labels of a canonical name, the encoder of gopacket does not
+func checkName(name string) error {
+	if len(name) == 0 || len(name) > DNS_MAX_NAME_LEN {
+		return fmt.Errorf("invalid dns name %q", name)
+	}
+	for _, l := range strings.Split(name, ".") {
+		if len(l) == 0 || len(l) > DNS_MAX_LABEL_LEN {
+			return fmt.Errorf("invalid label in dns name %q", name)
+		}
+	}
+	return nil
+}
+
+// ReverseName returns the name of the PTR query of an IP, 1.0.0.16.in-addr.arpa for 16.0.0.1
+func ReverseName(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return fmt.Sprintf("%d.%d.%d.%d.in-addr.arpa", ip4[3], ip4[2], ip4[1], ip4[0])
+	}
+	h := hex.EncodeToString(ip.To16())
+	var b strings.Builder
+	for i := len(h) - 1; i >= 0; i-- {
+		b.WriteByte(h[i])
+		b.WriteByte('.')
+	}
+	b.WriteString("ip6.arpa")
+	return b.String()
+}
+
+func newDnsRecord(rr *layers.DNSResourceRecord) DnsRecord {
+	r := DnsRecord{Name: string(rr.Name), Type: rr.Type.String(), Ttl: rr.TTL}
+	switch rr.Type {
+	case layers.DNSTypeA, layers.DNSTypeAAAA:
+		r.Data = net.IP(rr.IP).String()
+	case layers.DNSTypeNS:
+		r.Data = string(rr.NS)
+	case layers.DNSTypeCNAME:
+		r.Data = string(rr.CNAME)
+	case layers.DNSTypePTR:
+		r.Data = string(rr.PTR)
+	case layers.DNSTypeMX:
+		r.Data = fmt.Sprintf("%d %s", rr.MX.Preference, rr.MX.Name)
+	case layers.DNSTypeSRV:
+		r.Data = fmt.Sprintf("%d %d %d %s", rr.SRV.Priority, rr.SRV.Weight, rr.SRV.Port, rr.SRV.Name)
+	case layers.DNSTypeTXT:
+		txts := make([]string, len(rr.TXTs))
+		for i, t := range rr.TXTs {
+			txts[i] = string(t)
+		}
+		r.Data = strings.Join(txts, " ")
+	case layers.DNSTypeSOA:
+		s := &rr.SOA
+		r.Data = fmt.Sprintf("%s %s %d %d %d %d %d", s.MName, s.RName, s.Serial, s.Refresh, s.Retry, s.Expire, s.Minimum)
+	default:
+		r.Type = fmt.Sprintf("TYPE%d", rr.Type)
+		r.Data = hex.EncodeToString(rr.Data)
+	}
+	return r
+}
+
+// newResourceRecord parses a record of a zone, the data is formatted as by newDnsRecord
+func newResourceRecord(r *DnsRecord) (layers.DNSResourceRecord, error) {
+	var rr layers.DNSResourceRecord
+	name := canonicalName(r.Name)
+	if err := checkName(name); err != nil {
+		return rr, err
+	}
+	t, err := parseDnsType(r.Type)
+	if err != nil {
+		return rr, err
+	}
+	rr.Name, rr.Type, rr.Class, rr.TTL = []byte(name), t, layers.DNSClassIN, r.Ttl
+	invalid := fmt.Errorf("invalid data %q of %s record %q", r.Data, r.Type, r.Name)
+	f := strings.Fields(r.Data)
+	// the target of the name types
+	target := func(s string) ([]byte, error) {
+		n := canonicalName(s)
+		if checkName(n) != nil {
+			return nil, invalid
+		}
+		return []byte(n), nil
+	}
+	switch t {
+	case layers.DNSTypeA, layers.DNSTypeAAAA:
+		ip := net.ParseIP(r.Data)
+		if ip == nil || (ip.To4() != nil) != (t == layers.DNSTypeA) {
+			return rr, invalid
+		}
+		if t == layers.DNSTypeA {
+			ip = ip.To4()
+		}
+		rr.IP = ip
+	case layers.DNSTypeNS:
+		rr.NS, err = target(r.Data)
+	case layers.DNSTypeCNAME:
+		rr.CNAME, err = target(r.Data)
+	case layers.DNSTypePTR:
+		rr.PTR, err = target(r.Data)
+	case layers.DNSTypeMX:
+		if len(f) != 2 {
+			return rr, invalid
+		}
+		var pref uint64
+		if pref, err = strconv.ParseUint(f[0], 10, 16); err != nil {
+			return rr, invalid
+		}
+		rr.MX.Preference = uint16(pref)
+		rr.MX.Name, err = target(f[1])
+	case layers.DNSTypeSRV:
+		if len(f) != 4 {
+			return rr, invalid
+		}
+		var v [3]uint64
+		for i := range v {
+			if v[i], err = strconv.ParseUint(f[i], 10, 16); err != nil {
+				return rr, invalid
+			}
+		}
+		rr.SRV.Priority, rr.SRV.Weight, rr.SRV.Port = uint16(v[0]), uint16(v[1]), uint16(v[2])
+		rr.SRV.Name, err = target(f[3])
+	case layers.DNSTypeTXT:
+		// character strings are up to 255 bytes
+		d := []byte(r.Data)
+		for len(d) > 255 {
+			rr.TXTs = append(rr.TXTs, d[:255])
+			d = d[255:]
+		}
+		rr.TXTs = append(rr.TXTs, d)
+	default:
+		return rr, fmt.Errorf("unsupported %s record %q", r.Type, r.Name)
+	}
+	return rr, err
+}
+
+func encodeDns(d *layers.DNS) ([]byte, error) {
+	buf := gopacket.NewSerializeBuffer()
+	err := d.SerializeTo(buf, gopacket.SerializeOptions{FixLengths: true})
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decodeDns(b []byte, d *layers.DNS) error {
+	return d.DecodeFromBytes(b, gopacket.NilDecodeFeedback)
+}
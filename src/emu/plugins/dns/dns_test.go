@@ -0,0 +1,696 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package dns
+
+import (
+	"emu/core"
+	"emu/plugins/igmp"
+	"emu/plugins/ipv6"
+	"emu/plugins/transport"
+	"encoding/binary"
+	"external/google/gopacket/layers"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// VethDnsSim returns the tx packets as rx, the servers and the resolvers share the namespace
+type VethDnsSim struct {
+}
+
+func (o *VethDnsSim) ProcessTxToRx(m *core.Mbuf) *core.Mbuf {
+	return m
+}
+
+// dnsTestServer answers from records, it can drop or truncate the UDP answers
+type dnsTestServer struct {
+	records  map[dnsCacheKey][]layers.DNSResourceRecord
+	drop     int
+	truncate bool
+	rxUdp    int
+	rxTcp    int
+}
+
+type dnsTestConn struct {
+	srv    *dnsTestServer
+	socket transport.SocketApi
+}
+
+func (o *dnsTestServer) OnAccept(socket transport.SocketApi) transport.ISocketCb {
+	return &dnsTestConn{srv: o, socket: socket}
+}
+
+func (o *dnsTestConn) OnRxEvent(event transport.SocketEventType) {}
+func (o *dnsTestConn) OnTxEvent(event transport.SocketEventType) {}
+
+func (o *dnsTestConn) OnRxData(d []byte) {
+	tcp := o.socket.GetCap()&transport.SocketCapStream != 0
+	if tcp {
+		o.srv.rxTcp++
+		d = d[2:]
+	} else {
+		o.srv.rxUdp++
+		if o.srv.drop > 0 {
+			o.srv.drop--
+			return
+		}
+	}
+	var q layers.DNS
+	if decodeDns(d, &q) != nil {
+		return
+	}
+	qu := q.Questions[0]
+	a := layers.DNS{ID: q.ID, QR: true, AA: true, RD: q.RD, Questions: q.Questions}
+	rr, ok := o.srv.records[dnsCacheKey{name: string(qu.Name), qtype: qu.Type}]
+	switch {
+	case !tcp && o.srv.truncate:
+		a.TC = true
+	case ok:
+		a.Answers = rr
+	default:
+		a.ResponseCode = layers.DNSResponseCodeNXDomain
+		a.Authorities = []layers.DNSResourceRecord{{Name: []byte("example.com"), Type: layers.DNSTypeSOA,
+			Class: layers.DNSClassIN, TTL: 300, SOA: layers.DNSSOA{MName: []byte("ns.example.com"),
+				RName: []byte("admin.example.com"), Serial: 1, Refresh: 60, Retry: 60, Expire: 60, Minimum: 30}}}
+	}
+	b, _ := encodeDns(&a)
+	if tcp {
+		l := make([]byte, 2)
+		binary.BigEndian.PutUint16(l, uint16(len(b)))
+		b = append(l, b...)
+	}
+	o.socket.Write(b)
+}
+
+func newDnsTestServer() *dnsTestServer {
+	in := layers.DNSClassIN
+	return &dnsTestServer{records: map[dnsCacheKey][]layers.DNSResourceRecord{
+		{"www.example.com", layers.DNSTypeA}: {
+			{Name: []byte("www.example.com"), Type: layers.DNSTypeCNAME, Class: in, TTL: 100, CNAME: []byte("web.example.com")},
+			{Name: []byte("web.example.com"), Type: layers.DNSTypeA, Class: in, TTL: 10, IP: net.IP{48, 0, 0, 1}}},
+		{"www.example.com", layers.DNSTypeAAAA}: {
+			{Name: []byte("www.example.com"), Type: layers.DNSTypeAAAA, Class: in, TTL: 10, IP: net.ParseIP("2001:db8::1")}},
+		{"_sip._udp.example.com", layers.DNSTypeSRV}: {
+			{Name: []byte("_sip._udp.example.com"), Type: layers.DNSTypeSRV, Class: in, TTL: 10,
+				SRV: layers.DNSSRV{Priority: 10, Weight: 5, Port: 5060, Name: []byte("sip.example.com")}}},
+		{"1.0.0.48.in-addr.arpa", layers.DNSTypePTR}: {
+			{Name: []byte("1.0.0.48.in-addr.arpa"), Type: layers.DNSTypePTR, Class: in, TTL: 10, PTR: []byte("web.example.com")}},
+	}}
+}
+
+// newDnsNs creates the namespace of the servers and the resolvers
+func newDnsNs() (*core.CThreadCtx, *core.CNSCtx) {
+	var simrx core.VethIFSim = &VethDnsSim{}
+	tctx := core.NewThreadCtx(0, 4510, true, &simrx)
+	transport.Register(tctx)
+	Register(tctx)
+	var key core.CTunnelKey
+	key.Set(&core.CTunnelData{Vport: 1})
+	ns := core.NewNSCtx(tctx, &key)
+	tctx.AddNs(&key, ns)
+	return tctx, ns
+}
+
+// addDnsClient adds the client 16.0.0.id, the client 16.0.0.dg is its default gateway
+func addDnsClient(ns *core.CNSCtx, id, dg uint8) *core.CClient {
+	c := core.NewClient(ns, core.MACKey{0, 0, 1, 0, 0, id}, core.Ipv4Key{16, 0, 0, id}, core.Ipv6Key{}, core.Ipv4Key{})
+	c.ForceDGW = true
+	c.Ipv4ForcedgMac = core.MACKey{0, 0, 1, 0, 0, dg}
+	ns.AddClient(c)
+	return c
+}
+
+// createDnsEnv creates the server 16.0.0.2 and the resolver 16.0.0.1, each is the default gateway of the other
+func createDnsEnv(srv *dnsTestServer, initJson string) (*core.CThreadCtx, *PluginDnsClient) {
+	tctx, ns := newDnsNs()
+	c := addDnsClient(ns, 2, 1)
+	c.PluginCtx.CreatePlugins([]string{transport.TRANS_PLUG}, [][]byte{nil})
+	ctx := transport.GetTransportCtx(c)
+	ctx.Listen("udp", ":53", srv)
+	ctx.Listen("tcp", ":53", srv)
+
+	c = addDnsClient(ns, 1, 2)
+	c.PluginCtx.CreatePlugins([]string{DNS_PLUG}, [][]byte{[]byte(initJson)})
+	return tctx, c.PluginCtx.Get(DNS_PLUG).Ext.(*PluginDnsClient)
+}
+
+// createDnsSrvEnv creates the dns_srv server 16.0.0.2 and the resolver 16.0.0.1 of it
+func createDnsSrvEnv(srvJson string, timeout int) (*core.CThreadCtx, *PluginDnsSrvClient, *PluginDnsClient) {
+	tctx, ns := newDnsNs()
+	c := addDnsClient(ns, 2, 1)
+	c.PluginCtx.CreatePlugins([]string{DNS_SRV_PLUG}, [][]byte{[]byte(srvJson)})
+	srv := c.PluginCtx.Get(DNS_SRV_PLUG).Ext.(*PluginDnsSrvClient)
+
+	c = addDnsClient(ns, 1, 2)
+	j := fmt.Sprintf(`{"servers": ["16.0.0.2"], "timeout": %d, "tries": 1}`, timeout)
+	c.PluginCtx.CreatePlugins([]string{DNS_PLUG}, [][]byte{[]byte(j)})
+	return tctx, srv, c.PluginCtx.Get(DNS_PLUG).Ext.(*PluginDnsClient)
+}
+
+func TestDnsResolver(t *testing.T) {
+	srv := newDnsTestServer()
+	tctx, o := createDnsEnv(srv, `{"servers": ["16.0.0.2"], "neg_ttl": 100}`)
+	defer tctx.Delete()
+
+	var res []*DnsResult
+	cb := func(r *DnsResult) { res = append(res, r) }
+	for _, q := range []struct {
+		name  string
+		qtype layers.DNSType
+	}{{"WWW.example.com.", layers.DNSTypeA}, {"www.example.com", layers.DNSTypeAAAA},
+		{"_sip._udp.example.com", layers.DNSTypeSRV}, {"48.0.0.1", layers.DNSTypePTR}, {"none.example.com", layers.DNSTypeA}} {
+		if err := o.Query(q.name, q.qtype, cb); err != nil {
+			t.Fatalf(" query %s failed %v", q.name, err)
+		}
+	}
+	tctx.MainLoopSim(time.Second)
+	if len(res) != 5 || o.stats.pktTx != 5 || o.stats.pktRx != 5 || o.stats.rcodeNoError != 4 || o.stats.rcodeNxDomain != 1 {
+		t.Fatalf(" unexpected answers %+v %+v", res, o.stats)
+	}
+	a := res[0]
+	if a.Name != "www.example.com" || a.Rcode != "NOERROR" || a.Cached || len(a.Records) != 2 ||
+		a.Records[0] != (DnsRecord{Name: "www.example.com", Type: "CNAME", Ttl: 100, Data: "web.example.com"}) ||
+		a.Records[1] != (DnsRecord{Name: "web.example.com", Type: "A", Ttl: 10, Data: "48.0.0.1"}) {
+		t.Fatalf(" unexpected A answer %+v", *a)
+	}
+	if res[1].Records[0].Data != "2001:db8::1" || res[2].Records[0].Data != "10 5 5060 sip.example.com" ||
+		res[3].Name != "1.0.0.48.in-addr.arpa" || res[3].Records[0].Data != "web.example.com" {
+		t.Fatalf(" unexpected answers %+v %+v %+v", res[1], res[2], res[3])
+	}
+	// the negative TTL is the SOA minimum
+	if res[4].Rcode != "NXDOMAIN" || len(res[4].Records) != 0 || o.stats.cacheAdd != 5 {
+		t.Fatalf(" unexpected NXDOMAIN %+v", res[4])
+	}
+	info := o.getCacheInfo()
+	if len(info) != 5 || info[2].Name != "none.example.com" || info[2].Remaining != 29 {
+		t.Fatalf(" unexpected cache %+v", info)
+	}
+
+	// the cached answer with the remaining TTL
+	tctx.MainLoopSim(5 * time.Second)
+	res = nil
+	o.Query("www.example.com", layers.DNSTypeA, cb)
+	if len(res) != 1 || !res[0].Cached || res[0].Records[1].Ttl != 4 || res[0].Records[0].Ttl != 94 || o.stats.pktTx != 5 ||
+		o.stats.cacheHit != 1 {
+		t.Fatalf(" expected a cached answer %+v %+v", res, o.stats)
+	}
+
+	// the minimal TTL expires the entry
+	tctx.MainLoopSim(5 * time.Second)
+	if o.stats.cacheExpired != 4 || len(o.cache) != 1 {
+		t.Fatalf(" expected expired entries %+v", o.stats)
+	}
+	res = nil
+	o.Query("www.example.com", layers.DNSTypeA, cb)
+	tctx.MainLoopSim(time.Second)
+	if len(res) != 1 || res[0].Cached || o.stats.pktTx != 6 {
+		t.Fatalf(" expected a new query %+v %+v", res, o.stats)
+	}
+
+	// literal and resolved addresses
+	var addrs []string
+	for _, a := range []string{"16.0.0.9:80", "www.example.com:80", "[2001:db8::9]:443", "www.example.com:443", "none.example.com:80"} {
+		ResolveAddr(o.Client, a, a == "www.example.com:443", func(addr string, err error) {
+			if err != nil {
+				addr = err.Error()
+			}
+			addrs = append(addrs, addr)
+		})
+	}
+	tctx.MainLoopSim(time.Second)
+	// the cached names are resolved at once
+	exp := []string{"16.0.0.9:80", "48.0.0.1:80", "[2001:db8::9]:443", "can't resolve none.example.com A: NXDOMAIN", "[2001:db8::1]:443"}
+	if len(addrs) != len(exp) {
+		t.Fatalf(" unexpected addresses %v", addrs)
+	}
+	for i := range exp {
+		if addrs[i] != exp[i] {
+			t.Fatalf(" unexpected addresses %v", addrs)
+		}
+	}
+	if err := o.Query("a..b", layers.DNSTypeA, cb); err == nil || o.stats.errName != 1 {
+		t.Fatalf(" invalid name should fail")
+	}
+	if o.stats.errRxMalformed != 0 || o.stats.errRxUnexpected != 0 || o.stats.latencyUnder1s == 0 {
+		t.Fatalf(" unexpected counters %+v", o.stats)
+	}
+}
+
+func TestDnsTcpFallback(t *testing.T) {
+	srv := newDnsTestServer()
+	srv.truncate = true
+	tctx, o := createDnsEnv(srv, `{"servers": ["16.0.0.2"]}`)
+	defer tctx.Delete()
+
+	var res *DnsResult
+	o.Query("www.example.com", layers.DNSTypeAAAA, func(r *DnsResult) { res = r })
+	tctx.MainLoopSim(time.Second)
+	if res == nil || len(res.Records) != 1 || res.Records[0].Data != "2001:db8::1" {
+		t.Fatalf(" unexpected answer %+v %+v", res, o.stats)
+	}
+	if o.stats.pktRxTruncated != 1 || o.stats.pktTxTcp != 1 || o.stats.pktRxTcp != 1 || srv.rxUdp != 1 || srv.rxTcp != 1 ||
+		len(o.queries) != 0 || o.stats.errTcp != 0 {
+		t.Fatalf(" unexpected counters %+v", o.stats)
+	}
+}
+
+func TestDnsRetransmit(t *testing.T) {
+	srv := newDnsTestServer()
+	// both servers are the same one, the second gets the query after 2 tries of the first
+	tctx, o := createDnsEnv(srv, `{"servers": ["16.0.0.2", "16.0.0.2:53"], "timeout": 1000, "tries": 2}`)
+	defer tctx.Delete()
+
+	srv.drop = 2
+	var res []*DnsResult
+	cb := func(r *DnsResult) { res = append(res, r) }
+	o.Query("www.example.com", layers.DNSTypeA, cb)
+	tctx.MainLoopSim(5 * time.Second)
+	if len(res) != 1 || len(res[0].Records) != 2 || o.stats.pktTxRetransmit != 2 || o.stats.pktTx != 3 ||
+		o.stats.latencyUnder1s != 0 || o.stats.latencyOver1s != 1 || o.stats.latencyMin < 2000000 {
+		t.Fatalf(" unexpected answer %+v %+v", res, o.stats)
+	}
+
+	// without answers the query fails after all the tries
+	srv.drop = 100
+	o.Query("www.example.com", layers.DNSTypeAAAA, cb)
+	tctx.MainLoopSim(5 * time.Second)
+	if len(res) != 2 || res[1].Error != DNS_ERR_TIMEOUT || o.stats.queryTimeout != 1 || o.stats.pktTx != 7 || len(o.queries) != 0 {
+		t.Fatalf(" expected a timeout %+v %+v", res, o.stats)
+	}
+}
+
+func TestDnsStorm(t *testing.T) {
+	srv := newDnsTestServer()
+	tctx, o := createDnsEnv(srv, `{"servers": ["16.0.0.2"], "storm": {"rate": 20, "count": 30, "types": ["A", "aaaa"],
+		"engines": [{"engine_name": "name", "engine_type": "string_list",
+			"params": {"size": 32, "offset": 0, "op": "inc", "list": ["www.example.com", "none.example.com"]}}]}}`)
+	defer tctx.Delete()
+
+	tctx.MainLoopSim(3 * time.Second)
+	st := &o.stats
+	if !o.enable || st.stormQueries != 30 || st.pktTx != 30 || st.pktRx != 30 || st.cacheHit != 0 || st.errStormName != 0 {
+		t.Fatalf(" unexpected storm counters %+v", *st)
+	}
+	// www A, none AAAA, ...
+	if st.rcodeNoError != 15 || st.rcodeNxDomain != 15 || st.latencyUnder1s != 30 || len(o.cache) != 2 {
+		t.Fatalf(" unexpected answers %+v", *st)
+	}
+
+	for _, j := range []string{
+		`{"servers": ["www.example.com"]}`,
+		`{"servers": ["16.0.0.2:dns"]}`,
+		`{"storm": {"rate": 10, "names": ["www.example.com"]}}`,
+		`{"servers": ["16.0.0.2"], "storm": {"rate": 10}}`,
+		`{"servers": ["16.0.0.2"], "storm": {"rate": 10, "names": ["www.example.com"], "types": ["ANY"]}}`,
+		`{"servers": ["16.0.0.2"], "storm": {"rate": 10, "engines": [{"engine_name": "qname", "engine_type": "string_list",
+			"params": {"size": 32, "offset": 0, "op": "inc", "list": ["www.example.com"]}}]}}`,
+	} {
+		c := core.NewClient(o.Ns, core.MACKey{0, 0, 1, 0, 0, 3}, core.Ipv4Key{16, 0, 0, 3}, core.Ipv6Key{}, core.Ipv4Key{})
+		o.Ns.AddClient(c)
+		c.PluginCtx.CreatePlugins([]string{DNS_PLUG}, [][]byte{[]byte(j)})
+		bad := c.PluginCtx.Get(DNS_PLUG).Ext.(*PluginDnsClient)
+		if bad.enable || bad.stats.errInitJson != 1 || bad.Query("www.example.com", layers.DNSTypeA, nil) == nil {
+			t.Fatalf(" %s should be invalid", j)
+		}
+		o.Ns.RemoveClient(c)
+	}
+}
+
+// the client json replaces the servers of the namespace and is merged into its storm, the namespace is not changed
+func TestDnsNsInit(t *testing.T) {
+	tctx, ns := newDnsNs()
+	defer tctx.Delete()
+	ns.PluginCtx.CreatePlugins([]string{DNS_PLUG}, [][]byte{[]byte(`{"servers": ["16.0.0.2", "16.0.0.3"],
+		"storm": {"rate": 10, "count": 5, "names": ["a.example.com", "b.example.com"]}}`)})
+	var plugs []*PluginDnsClient
+	for i, j := range []string{`{"servers": ["16.0.0.4"], "storm": {"rate": 5, "names": ["c.example.com"]}}`, `{}`} {
+		c := core.NewClient(ns, core.MACKey{0, 0, 1, 0, 0, uint8(i + 1)}, core.Ipv4Key{16, 0, 0, uint8(i + 1)}, core.Ipv6Key{}, core.Ipv4Key{})
+		ns.AddClient(c)
+		c.PluginCtx.CreatePlugins([]string{DNS_PLUG}, [][]byte{[]byte(j)})
+		plugs = append(plugs, c.PluginCtx.Get(DNS_PLUG).Ext.(*PluginDnsClient))
+	}
+	c1, c2 := plugs[0].init, plugs[1].init
+	if len(c1.Servers) != 1 || c1.Storm.Rate != 5 || c1.Storm.Count != 5 || len(c1.Storm.Names) != 1 || c1.Storm.Names[0] != "c.example.com" {
+		t.Fatalf(" unexpected client init %+v %+v", c1, *c1.Storm)
+	}
+	if len(c2.Servers) != 2 || c2.Servers[0] != "16.0.0.2" || c2.Storm.Rate != 10 || c2.Storm.Names[0] != "a.example.com" {
+		t.Fatalf(" the namespace init was changed %+v %+v", c2, *c2.Storm)
+	}
+}
+
+func TestDnsServer(t *testing.T) {
+	txt := strings.Repeat("x", 600)
+	tctx, srv, o := createDnsSrvEnv(`{"origin": "Example.com.", "neg_ttl": 30, "zone": [
+		{"name": "www.example.com", "type": "CNAME", "data": "web.example.com"},
+		{"name": "web.example.com", "type": "A", "ttl": 10, "data": "48.0.0.1"},
+		{"name": "web.example.com", "type": "aaaa", "data": "2001:db8::1"},
+		{"name": "example.com", "type": "MX", "data": "10 mail.example.com"},
+		{"name": "_sip._udp.example.com", "type": "SRV", "data": "10 5 5060 sip.example.com"},
+		{"name": "big.example.com", "type": "TXT", "data": "`+txt+`"}]}`, 1000)
+	defer tctx.Delete()
+
+	res := make(map[string]*DnsResult)
+	for _, q := range []struct {
+		name  string
+		qtype layers.DNSType
+	}{{"www.example.com", layers.DNSTypeA}, {"web.example.com", layers.DNSTypeAAAA}, {"example.com", layers.DNSTypeMX},
+		{"_sip._udp.example.com", layers.DNSTypeSRV}, {"none.example.com", layers.DNSTypeA}, {"web.example.com", layers.DNSTypeMX},
+		{"www.example.org", layers.DNSTypeA}, {"big.example.com", layers.DNSTypeTXT}} {
+		o.Query(q.name, q.qtype, func(r *DnsResult) { res[r.Name+" "+r.Type] = r })
+	}
+	tctx.MainLoopSim(time.Second)
+	if !srv.enable || len(res) != 8 {
+		t.Fatalf(" unexpected answers %+v %+v", res, srv.stats)
+	}
+	a := res["www.example.com A"]
+	if a.Rcode != "NOERROR" || len(a.Records) != 2 ||
+		a.Records[0] != (DnsRecord{Name: "www.example.com", Type: "CNAME", Ttl: 300, Data: "web.example.com"}) ||
+		a.Records[1] != (DnsRecord{Name: "web.example.com", Type: "A", Ttl: 10, Data: "48.0.0.1"}) {
+		t.Fatalf(" unexpected A answer %+v", *a)
+	}
+	if res["web.example.com AAAA"].Records[0].Data != "2001:db8::1" || res["example.com MX"].Records[0].Data != "10 mail.example.com" ||
+		res["_sip._udp.example.com SRV"].Records[0].Data != "10 5 5060 sip.example.com" {
+		t.Fatalf(" unexpected answers %+v", res)
+	}
+	// the TXT record does not fit UDP, it is split to character strings of 255 bytes
+	if a = res["big.example.com TXT"]; len(a.Records) != 1 || strings.Replace(a.Records[0].Data, " ", "", -1) != txt {
+		t.Fatalf(" unexpected TXT answer %+v", *a)
+	}
+	if res["none.example.com A"].Rcode != "NXDOMAIN" || res["web.example.com MX"].Rcode != "NOERROR" ||
+		len(res["web.example.com MX"].Records) != 0 || res["www.example.org A"].Rcode != "REFUSED" {
+		t.Fatalf(" unexpected negative answers %+v", res)
+	}
+	// the negative TTL is the SOA minimum
+	for _, e := range o.getCacheInfo() {
+		if e.Name == "none.example.com" && e.Remaining != 29 {
+			t.Fatalf(" unexpected negative ttl %+v", e)
+		}
+	}
+
+	st := &srv.stats
+	if st.pktRx != 8 || st.pktRxTcp != 1 || st.pktTx != 8 || st.pktTxTcp != 1 || st.pktTxTruncated != 1 || st.rcodeNoError != 7 ||
+		st.noData != 1 || st.rcodeNxDomain != 1 || st.rcodeRefused != 1 || st.errRxMalformed != 0 || st.errTx != 0 {
+		t.Fatalf(" unexpected server counters %+v", *st)
+	}
+	info := srv.getQueryInfo()
+	if len(info) != 8 || info[0] != (DnsSrvQueryInfo{Name: "_sip._udp.example.com", Type: "SRV", Queries: 1}) ||
+		info[1] != (DnsSrvQueryInfo{Name: "big.example.com", Type: "TXT", Queries: 2}) {
+		t.Fatalf(" unexpected query table %+v", info)
+	}
+	// the UDP flows are closed once answered, the TCP connection by the resolver
+	tctx.MainLoopSim(5 * time.Second)
+	if len(srv.conns) != 0 {
+		t.Fatalf(" unexpected open flows %d", len(srv.conns))
+	}
+}
+
+// the zone of the client replaces the one of the namespace, the namespace is not changed
+func TestDnsServerNsInit(t *testing.T) {
+	tctx, ns := newDnsNs()
+	defer tctx.Delete()
+	ns.PluginCtx.CreatePlugins([]string{DNS_SRV_PLUG}, [][]byte{[]byte(`{"zone": [{"name": "a.example.com", "type": "A", "data": "16.0.0.9"}]}`)})
+	var plugs []*PluginDnsSrvClient
+	for i, j := range []string{`{"ttl": 30}`, `{"zone": [{"name": "b.example.com", "type": "AAAA", "data": "2001:db8::1"}]}`} {
+		c := core.NewClient(ns, core.MACKey{0, 0, 1, 0, 0, uint8(i + 1)}, core.Ipv4Key{16, 0, 0, uint8(i + 1)}, core.Ipv6Key{}, core.Ipv4Key{})
+		ns.AddClient(c)
+		c.PluginCtx.CreatePlugins([]string{DNS_SRV_PLUG}, [][]byte{[]byte(j)})
+		plugs = append(plugs, c.PluginCtx.Get(DNS_SRV_PLUG).Ext.(*PluginDnsSrvClient))
+	}
+	z1, z2 := plugs[0].init.Zone, plugs[1].init.Zone
+	if len(z1) != 1 || z1[0].Name != "a.example.com" || z1[0].Ttl != 30 || len(plugs[0].zone["a.example.com"]) != 1 {
+		t.Fatalf(" unexpected zone %+v", z1)
+	}
+	if len(z2) != 1 || z2[0].Name != "b.example.com" || z2[0].Ttl != DNS_SRV_DEF_TTL || len(plugs[1].zone["a.example.com"]) != 0 {
+		t.Fatalf(" the zone of the client should replace the namespace zone %+v", z2)
+	}
+	if z := plugs[0].dnsSrvNsPlug.init.Zone; z[0].Name != "a.example.com" || z[0].Type != "A" || z[0].Ttl != 0 {
+		t.Fatalf(" the namespace zone was changed %+v", z)
+	}
+}
+
+func TestDnsServerFaults(t *testing.T) {
+	zone := `"zone": [{"name": "www.example.com", "type": "A", "data": "48.0.0.1"}]`
+	for _, c := range []struct {
+		json  string
+		rcode string
+		err   string
+	}{
+		{`{"delay": 500, ` + zone + `}`, "NOERROR", ""},
+		{`{"drop_rate": 1, ` + zone + `}`, "", DNS_ERR_TIMEOUT},
+		{`{"servfail_rate": 1, ` + zone + `}`, "SERVFAIL", ""},
+		{`{"nxdomain_rate": 1, ` + zone + `}`, "NXDOMAIN", ""},
+	} {
+		tctx, srv, o := createDnsSrvEnv(c.json, 1000)
+		var res *DnsResult
+		o.Query("www.example.com", layers.DNSTypeA, func(r *DnsResult) { res = r })
+		tctx.MainLoopSim(300 * time.Millisecond)
+		if res != nil && c.rcode == "NOERROR" {
+			t.Fatalf(" the answer should be delayed %+v", *res)
+		}
+		tctx.MainLoopSim(2 * time.Second)
+		if res == nil || res.Rcode != c.rcode || res.Error != c.err {
+			t.Fatalf(" %s unexpected answer %+v %+v", c.json, res, srv.stats)
+		}
+		st := &srv.stats
+		if st.delayed+st.dropInjected+st.servFailInjected+st.nxDomainInjected != 1 || srv.queries[dnsCacheKey{"www.example.com", layers.DNSTypeA}] != 1 {
+			t.Fatalf(" %s unexpected counters %+v", c.json, *st)
+		}
+		tctx.Delete()
+	}
+
+	tctx, ns := newDnsNs()
+	defer tctx.Delete()
+	for k, j := range []string{
+		`{"zone": [{"name": "www.example.com", "type": "A", "data": "2001:db8::1"}]}`,
+		`{"zone": [{"name": "www.example.com", "type": "MX", "data": "mail.example.com"}]}`,
+		`{"zone": [{"name": "www.example.com", "type": "SOA", "data": "ns.example.com"}]}`,
+		`{"zone": [{"name": "www.example.com", "type": "CNAME", "data": "a"}, {"name": "www.example.com", "type": "A", "data": "48.0.0.1"}]}`,
+		`{"origin": "example.com", "zone": [{"name": "www.example.org", "type": "A", "data": "48.0.0.1"}]}`,
+		`{"drop_rate": 2}`,
+	} {
+		c := addDnsClient(ns, uint8(10+k), 1)
+		c.PluginCtx.CreatePlugins([]string{DNS_SRV_PLUG}, [][]byte{[]byte(j)})
+		bad := c.PluginCtx.Get(DNS_SRV_PLUG).Ext.(*PluginDnsSrvClient)
+		if bad.enable || bad.stats.errInitJson != 1 {
+			t.Fatalf(" %s should be invalid", j)
+		}
+	}
+}
+
+// addMdnsClient adds the client 16.0.0.id with the mdns plugin
+func addMdnsClient(ns *core.CNSCtx, id uint8, initJson string) *PluginMdnsClient {
+	c := addDnsClient(ns, id, 1)
+	c.PluginCtx.CreatePlugins([]string{MDNS_PLUG}, [][]byte{[]byte(initJson)})
+	return c.PluginCtx.Get(MDNS_PLUG).Ext.(*PluginMdnsClient)
+}
+
+func igmpGroups(ns *core.CNSCtx) []core.Ipv4Key {
+	var v []core.Ipv4Key
+	p := ns.PluginCtx.Get(igmp.IGMP_PLUG).Ext.(*igmp.PluginIgmpNs)
+	if p.IterReset() {
+		return v
+	}
+	l, _ := p.GetNext(100)
+	for _, e := range l {
+		v = append(v, e.G)
+	}
+	return v
+}
+
+func TestMdnsResponder(t *testing.T) {
+	tctx, ns := newDnsNs()
+	defer tctx.Delete()
+	ns.PluginCtx.CreatePlugins([]string{igmp.IGMP_PLUG}, [][]byte{[]byte(`{"dmac": [0, 0, 1, 0, 0, 1]}`)})
+
+	srv := addMdnsClient(ns, 1, `{"hostname": "Printer", "services": [
+		{"instance": "Printer 1", "type": "_ipp._tcp", "port": 631, "txt": ["txtvers=1", "rp=printers/1"]},
+		{"instance": "Share", "type": "_smb._tcp.local.", "port": 445}]}`)
+	br := addMdnsClient(ns, 2, `{"browse": ["_ipp._tcp"]}`)
+	if !srv.enable || !br.enable {
+		t.Fatalf(" unexpected init error %s %s", srv.initErr, br.initErr)
+	}
+	if g := igmpGroups(ns); len(g) != 1 || g[0] != mdnsGroupIpv4 || srv.mdnsNsPlug.stats.joinIpv4 != 1 {
+		t.Fatalf(" unexpected igmp groups %v", g)
+	}
+
+	tctx.MainLoopSim(5 * time.Second)
+	st := &srv.stats
+	if srv.state != mdnsStateEstablished || st.pktTxProbe != MDNS_PROBES || st.pktTxAnnounce != MDNS_ANNOUNCES ||
+		st.conflicts != 0 || st.errTx != 0 {
+		t.Fatalf(" unexpected responder state %d %+v", srv.state, *st)
+	}
+	info := srv.getInfo()
+	if info.State != "established" || info.Hostname != "printer.local" || len(info.Instances) != 2 ||
+		info.Instances[0] != "Printer 1._ipp._tcp.local" || info.Instances[1] != "Share._smb._tcp.local" {
+		t.Fatalf(" unexpected info %+v", info)
+	}
+	// the instance of the browsed type only, from the announcements or the answers of the browser queries
+	b := br.getBrowseInfo()
+	if len(b) != 1 || b[0].Name != "Printer 1._ipp._tcp.local" || b[0].Instance != "Printer 1" || b[0].Type != "_ipp._tcp" ||
+		b[0].Host != "printer.local" || b[0].Port != 631 || len(b[0].Txt) != 2 || b[0].Txt[1] != "rp=printers/1" ||
+		len(b[0].Ipv4) != 1 || b[0].Ipv4[0] != "16.0.0.1" || b[0].Remaining == 0 {
+		t.Fatalf(" unexpected browse table %+v", b)
+	}
+	if br.stats.pktTxQuery == 0 || br.stats.browseAdd != 1 {
+		t.Fatalf(" unexpected browser counters %+v", br.stats)
+	}
+	// the browser queries carry the known answer
+	if st.knownAnswer == 0 {
+		t.Fatalf(" expected suppressed known answers %+v", *st)
+	}
+
+	// a legacy unicast query, the answer has the id and the question of the query
+	c := addDnsClient(ns, 3, 1)
+	c.PluginCtx.CreatePlugins([]string{DNS_PLUG}, [][]byte{[]byte(`{"servers": ["16.0.0.1:5353"], "tries": 1}`)})
+	o := c.PluginCtx.Get(DNS_PLUG).Ext.(*PluginDnsClient)
+	var res *DnsResult
+	o.Query("PRINTER.local", layers.DNSTypeA, func(r *DnsResult) { res = r })
+	tctx.MainLoopSim(time.Second)
+	if res == nil || res.Rcode != "NOERROR" || len(res.Records) != 1 ||
+		res.Records[0] != (DnsRecord{Name: "printer.local", Type: "A", Ttl: MDNS_LEGACY_TTL, Data: "16.0.0.1"}) ||
+		st.pktTxUnicast != 1 {
+		t.Fatalf(" unexpected legacy answer %+v %+v", res, *st)
+	}
+
+	// the goodbye removes the instance
+	ns.RemoveClient(srv.Client)
+	tctx.MainLoopSim(time.Second)
+	if st.pktTxGoodbye != 1 || len(br.getBrowseInfo()) != 0 || br.stats.browseRemove != 1 {
+		t.Fatalf(" unexpected goodbye %+v %+v", *st, br.stats)
+	}
+	ns.RemoveClient(br.Client)
+	if g := igmpGroups(ns); len(g) != 0 || br.mdnsNsPlug.stats.leaveIpv4 != 1 {
+		t.Fatalf(" the group should be removed %v", g)
+	}
+	if br.mdnsNsPlug.stats.errRxMalformed != 0 {
+		t.Fatalf(" unexpected ns counters %+v", br.mdnsNsPlug.stats)
+	}
+}
+
+// the group added by rpc is kept when the mdns clients leave
+func TestMdnsIgmpRpcGroup(t *testing.T) {
+	tctx, ns := newDnsNs()
+	defer tctx.Delete()
+	ns.PluginCtx.CreatePlugins([]string{igmp.IGMP_PLUG}, [][]byte{[]byte(`{"dmac": [0, 0, 1, 0, 0, 1]}`)})
+	p := ns.PluginCtx.Get(igmp.IGMP_PLUG).Ext.(*igmp.PluginIgmpNs)
+	if err := p.AddMc([]core.Ipv4Key{mdnsGroupIpv4}); err != nil {
+		t.Fatal(err)
+	}
+	o := addMdnsClient(ns, 1, `{"hostname": "printer"}`)
+	if o.mdnsNsPlug.stats.joinIpv4 != 1 || o.mdnsNsPlug.stats.errJoin != 0 {
+		t.Fatalf(" unexpected join %+v", o.mdnsNsPlug.stats)
+	}
+	ns.RemoveClient(o.Client)
+	if g := igmpGroups(ns); len(g) != 1 || g[0] != mdnsGroupIpv4 || o.mdnsNsPlug.stats.leaveIpv4 != 1 {
+		t.Fatalf(" the group of the rpc should be kept %v", g)
+	}
+	if err := p.RemoveMc([]core.Ipv4Key{mdnsGroupIpv4}); err != nil || len(igmpGroups(ns)) != 0 {
+		t.Fatalf(" the group should be removed %v", err)
+	}
+}
+
+// port 5353 is served by the transport when the namespace has no mdns
+func TestMdnsUdpDefault(t *testing.T) {
+	tctx, ns := newDnsNs()
+	defer tctx.Delete()
+	srv := newDnsTestServer()
+	c := addDnsClient(ns, 2, 1)
+	c.PluginCtx.CreatePlugins([]string{transport.TRANS_PLUG}, [][]byte{nil})
+	transport.GetTransportCtx(c).Listen("udp", ":5353", srv)
+
+	c = addDnsClient(ns, 1, 2)
+	c.PluginCtx.CreatePlugins([]string{DNS_PLUG}, [][]byte{[]byte(`{"servers": ["16.0.0.2:5353"], "tries": 1}`)})
+	o := c.PluginCtx.Get(DNS_PLUG).Ext.(*PluginDnsClient)
+	var res *DnsResult
+	o.Query("www.example.com", layers.DNSTypeA, func(r *DnsResult) { res = r })
+	tctx.MainLoopSim(time.Second)
+	if srv.rxUdp != 1 || res == nil || res.Rcode != "NOERROR" {
+		t.Fatalf(" unexpected result %+v rx %d", res, srv.rxUdp)
+	}
+}
+
+func TestMdnsIpv6(t *testing.T) {
+	tctx, ns := newDnsNs()
+	defer tctx.Delete()
+	ns.PluginCtx.CreatePlugins([]string{ipv6.IPV6_PLUG}, [][]byte{[]byte(`{"dmac": [0, 0, 1, 0, 0, 1]}`)})
+
+	srv := addMdnsClient(ns, 1, `{"hostname": "tv", "ipv6": true, "services": [{"instance": "TV", "type": "_airplay._tcp", "port": 7000}]}`)
+	br := addMdnsClient(ns, 2, `{"ipv6": true, "browse": ["_airplay._tcp"]}`)
+	tctx.MainLoopSim(5 * time.Second)
+	nsSt := &srv.mdnsNsPlug.stats
+	if srv.state != mdnsStateEstablished || nsSt.joinIpv6 != 1 || nsSt.joinIpv4 != 0 || nsSt.errJoin != 0 {
+		t.Fatalf(" unexpected state %d %+v %+v", srv.state, srv.stats, *nsSt)
+	}
+	// the probes and the announcements are sent over both families
+	if srv.stats.pktTxProbe != 2*MDNS_PROBES || srv.stats.pktTxAnnounce != 2*MDNS_ANNOUNCES {
+		t.Fatalf(" unexpected counters %+v", srv.stats)
+	}
+	var ll core.Ipv6Key
+	srv.Client.GetIpv6LocalLink(&ll)
+	b := br.getBrowseInfo()
+	if len(b) != 1 || b[0].Host != "tv.local" || len(b[0].Ipv4) != 1 || len(b[0].Ipv6) != 1 || b[0].Ipv6[0] != ll.ToIP().String() {
+		t.Fatalf(" unexpected browse table %+v", b)
+	}
+	ns.RemoveClient(srv.Client)
+	ns.RemoveClient(br.Client)
+	if nsSt.leaveIpv6 != 1 {
+		t.Fatalf(" the group should be removed %+v", *nsSt)
+	}
+}
+
+func TestMdnsConflict(t *testing.T) {
+	tctx, ns := newDnsNs()
+	defer tctx.Delete()
+
+	j := `{"hostname": "printer", "services": [{"instance": "Printer", "type": "_ipp._tcp", "port": 631}]}`
+	a := addMdnsClient(ns, 1, j)
+	b := addMdnsClient(ns, 2, j)
+	tctx.MainLoopSim(10 * time.Second)
+	if a.state != mdnsStateEstablished || b.state != mdnsStateEstablished {
+		t.Fatalf(" unexpected states %d %d %+v %+v", a.state, b.state, a.stats, b.stats)
+	}
+	// the tie break of the simultaneous probes renames one of them
+	ia, ib := a.getInfo(), b.getInfo()
+	if ia.Hostname == ib.Hostname || ia.Instances[0] == ib.Instances[0] || a.conflicts+b.conflicts != 2 {
+		t.Fatalf(" unexpected names %+v %+v", ia, ib)
+	}
+	renamed := ia
+	if a.conflicts == 0 {
+		renamed = ib
+	}
+	if renamed.Hostname != "printer-2.local" || renamed.Instances[0] != "Printer (2)._ipp._tcp.local" {
+		t.Fatalf(" unexpected rename %+v", renamed)
+	}
+
+	// the announcement without probing takes the name, the owner probes again and is renamed
+	c := addMdnsClient(ns, 3, `{"hostname": "printer", "no_probe": true}`)
+	tctx.MainLoopSim(10 * time.Second)
+	names := make(map[string]bool)
+	for _, o := range []*PluginMdnsClient{a, b, c} {
+		if o.state != mdnsStateEstablished {
+			t.Fatalf(" unexpected state %+v %+v", o.getInfo(), o.stats)
+		}
+		names[o.getInfo().Hostname] = true
+	}
+	if c.getInfo().Hostname != "printer.local" || len(names) != 3 || !names["printer-3.local"] {
+		t.Fatalf(" unexpected names %v", names)
+	}
+
+	for k, j := range []string{
+		`{"hostname": "a.b"}`,
+		`{"services": [{"instance": "x", "type": "ipp"}]}`,
+		`{"services": [{"instance": "x.y", "type": "_ipp._tcp"}]}`,
+		`{"services": [{"type": "_ipp._tcp"}]}`,
+		`{"browse": ["_ipp._sctp"]}`,
+	} {
+		bad := addMdnsClient(ns, uint8(10+k), j)
+		if bad.enable || bad.stats.errInitJson != 1 {
+			t.Fatalf(" %s should be invalid", j)
+		}
+	}
+}
@@ -0,0 +1,1563 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package dns
+
+/*
+mDNS/DNS-SD responder and browser (RFC 6762, RFC 6763), client plugin
+
+Each client is a device that publishes its host name (A/AAAA of <hostname>.local) and services to 224.0.0.251/ff02::fb
+UDP port 5353. A service is the PTR <type>.local -> <instance>.<type>.local, the SRV (port and host) and TXT of the
+instance and the PTR of the service type enumeration _services._dns-sd._udp.local.
+
+The unique names (the host and the instances) are probed, 3 queries 250 msec apart. A conflict renames the host to
+<hostname>-2 or the instance to "<instance> (2)" and probes again, a simultaneous probe is resolved by the tie break of
+the proposed records, the loser probes again after 1 sec. Then the records are announced twice, 1 sec apart.
+
+The queries are answered by multicast, or unicast to the querier in case of the QU bit or a legacy query (the source
+port is not 5353). The known answers of the query are suppressed, answers of shared records (PTR) are delayed 20-120 msec.
+A goodbye (TTL 0) is sent when the plugin is removed.
+
+The browser queries the PTR of the service types, 1 sec after the start and then the interval is doubled up to
+query_interval sec. It collects the instances with their SRV, TXT and addresses.
+
+The namespace plugin joins the groups by the igmp and ipv6 (MLD) namespace plugins, in case they exist.
+
+client init json {
+	"hostname": "printer-1",
+	"services": [{"instance": "Printer 1", "type": "_ipp._tcp", "port": 631, "txt": ["txtvers=1", "rp=printers/1"]}],
+	"ipv6": false,
+	"host_ttl": 120,
+	"ttl": 4500,
+	"no_probe": false,
+	"browse": ["_ipp._tcp", "_airplay._tcp"],
+	"query_interval": 3600
+}
+
+hostname is emu-<mac> by default. ipv6 publishes the AAAA of the link local address (and of the client IPv6) and works
+over ff02::fb as well. host_ttl is the TTL of the A/AAAA/SRV records, ttl of the PTR/TXT records.
+
+*/
+
+import (
+	"emu/core"
+	"emu/plugins/igmp"
+	"emu/plugins/ipv6"
+	"encoding/binary"
+	"external/google/gopacket"
+	"external/google/gopacket/layers"
+	"external/osamingo/jsonrpc"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/intel-go/fastjson"
+)
+
+const (
+	MDNS_PLUG               = "mdns"
+	MDNS_PORT               = 5353
+	MDNS_DOMAIN             = "local"
+	MDNS_SERVICES           = "_services._dns-sd._udp.local"
+	MDNS_DEF_HOST_TTL       = 120
+	MDNS_DEF_TTL            = 4500
+	MDNS_DEF_QUERY_INTERVAL = 3600
+	MDNS_LEGACY_TTL         = 10
+	MDNS_PROBES             = 3
+	MDNS_ANNOUNCES          = 2
+	MDNS_MAX_CONFLICTS      = 10
+	MDNS_MAX_BROWSE         = 1024
+	MDNS_MAX_ADDRS          = 8
+	MDNS_CLASS_FLUSH        = 0x8000 // cache flush of a record, unicast response of a question
+	MDNS_TYPE_ANY           = 255
+	IPV4_HEADER_SIZE        = 20
+	IPV6_HEADER_SIZE        = 40
+	UDP_HEADER_SIZE         = 8
+)
+
+const (
+	mdnsStateProbing = iota
+	mdnsStateAnnouncing
+	mdnsStateEstablished
+	mdnsStateConflict
+)
+
+var mdnsStates = []string{"probing", "announcing", "established", "conflict"}
+
+// timers of a client
+const (
+	mdnsTimerState = iota
+	mdnsTimerBrowse
+	mdnsTimerResponse
+)
+
+var mdnsGroupIpv4 = core.Ipv4Key{224, 0, 0, 251}
+var mdnsGroupIpv6 = core.Ipv6Key{0xff, 0x02, 14: 0, 15: 0xfb}
+var mdnsMacIpv4 = core.MACKey{0x01, 0x00, 0x5e, 0x00, 0x00, 0xfb}
+var mdnsMacIpv6 = core.MACKey{0x33, 0x33, 0x00, 0x00, 0x00, 0xfb}
+
+type MdnsServiceInit struct {
+	Instance string   `json:"instance" validate:"required"`
+	Type     string   `json:"type" validate:"required"`
+	Port     uint16   `json:"port"`
+	Txt      []string `json:"txt"`
+}
+
+type MdnsInit struct {
+	Hostname      string            `json:"hostname"`
+	Services      []MdnsServiceInit `json:"services" validate:"dive"`
+	Ipv6          bool              `json:"ipv6"`
+	HostTtl       uint32            `json:"host_ttl"`
+	Ttl           uint32            `json:"ttl"`
+	NoProbe       bool              `json:"no_probe"`
+	Browse        []string          `json:"browse"`
+	QueryInterval uint32            `json:"query_interval"` // sec
+}
+
+// MdnsInfo the state of the responder, the names after the conflicts
+type MdnsInfo struct {
+	State     string   `json:"state"`
+	Hostname  string   `json:"hostname"`
+	Instances []string `json:"instances"`
+	Conflicts uint32   `json:"conflicts"`
+}
+
+// MdnsServiceInfo a service instance that was found by the browser
+type MdnsServiceInfo struct {
+	Name      string   `json:"name"`
+	Instance  string   `json:"instance"`
+	Type      string   `json:"type"`
+	Host      string   `json:"host"`
+	Port      uint16   `json:"port"`
+	Txt       []string `json:"txt"`
+	Ipv4      []string `json:"ipv4"`
+	Ipv6      []string `json:"ipv6"`
+	Remaining uint32   `json:"remaining"` // sec
+}
+
+type MdnsStats struct {
+	pktTx              uint64
+	pktTxProbe         uint64
+	pktTxAnnounce      uint64
+	pktTxResponse      uint64
+	pktTxUnicast       uint64
+	pktTxQuery         uint64
+	pktTxGoodbye       uint64
+	pktRxQuery         uint64
+	pktRxResponse      uint64
+	knownAnswer        uint64
+	conflicts          uint64
+	probeDefer         uint64
+	browseAdd          uint64
+	browseRemove       uint64
+	browseExpired      uint64
+	errInitJson        uint64
+	errTx              uint64
+	errTooManyConflict uint64
+	errBrowseFull      uint64
+}
+
+func NewMdnsStatsDb(o *MdnsStats) *core.CCounterDb {
+	db := core.NewCCounterDb(MDNS_PLUG)
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTx,
+		Name:     "pktTx",
+		Help:     "tx packets",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTxProbe,
+		Name:     "pktTxProbe",
+		Help:     "tx probe queries",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTxAnnounce,
+		Name:     "pktTxAnnounce",
+		Help:     "tx unsolicited announcements",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTxResponse,
+		Name:     "pktTxResponse",
+		Help:     "tx responses to queries",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTxUnicast,
+		Name:     "pktTxUnicast",
+		Help:     "tx unicast responses, QU bit or legacy queries",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTxQuery,
+		Name:     "pktTxQuery",
+		Help:     "tx browse queries",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTxGoodbye,
+		Name:     "pktTxGoodbye",
+		Help:     "tx goodbye responses",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxQuery,
+		Name:     "pktRxQuery",
+		Help:     "rx queries of the names of the client",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxResponse,
+		Name:     "pktRxResponse",
+		Help:     "rx responses with the names of the client or of a browsing client",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.knownAnswer,
+		Name:     "knownAnswer",
+		Help:     "records not sent, known answers of the query",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.conflicts,
+		Name:     "conflicts",
+		Help:     "name conflicts",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.probeDefer,
+		Name:     "probeDefer",
+		Help:     "probing deferred by a lost tie break",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.browseAdd,
+		Name:     "browseAdd",
+		Help:     "instances found by the browser",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.browseRemove,
+		Name:     "browseRemove",
+		Help:     "instances removed by a goodbye",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.browseExpired,
+		Name:     "browseExpired",
+		Help:     "instances removed by the TTL",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.errInitJson,
+		Name:     "errInitJson",
+		Help:     "invalid init json",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.errTx,
+		Name:     "errTx",
+		Help:     "can't encode the message",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.errTooManyConflict,
+		Name:     "errTooManyConflict",
+		Help:     "the names are not published, too many conflicts",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.errBrowseFull,
+		Name:     "errBrowseFull",
+		Help:     "instance not added, the browse table is full",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	return db
+}
+
+// mdnsRecord a record of the client, unique records are probed and sent with the cache flush bit
+type mdnsRecord struct {
+	rr     layers.DNSResourceRecord
+	unique bool
+	key    string
+}
+
+// mdnsKey identifies a record by the name, type and data
+func mdnsKey(rr *layers.DNSResourceRecord) string {
+	r := newDnsRecord(rr)
+	return strings.ToLower(r.Name + " " + r.Type + " " + r.Data)
+}
+
+type mdnsService struct {
+	init     *MdnsServiceInit
+	instance string // renamed by conflicts
+	renames  uint32
+}
+
+// mdnsAddr the address of a querier for unicast responses
+type mdnsAddr struct {
+	mac  core.MACKey
+	ipv6 bool
+	ip4  core.Ipv4Key
+	ip6  core.Ipv6Key
+	port uint16
+}
+
+type mdnsBrowseEntry struct {
+	name     string
+	instance string
+	stype    string
+	host     string
+	port     uint16
+	txt      []string
+	ipv4     []string
+	ipv6     []string
+	ttl      uint32
+	expire   uint64 // ticks
+}
+
+type PluginMdnsClientTimer struct {
+}
+
+func (o *PluginMdnsClientTimer) OnEvent(a, b interface{}) {
+	pi := a.(*PluginMdnsClient)
+	switch b.(int) {
+	case mdnsTimerState:
+		pi.onStateTimer()
+	case mdnsTimerBrowse:
+		pi.onBrowseTimer()
+	case mdnsTimerResponse:
+		pi.onResponseTimer()
+	}
+}
+
+// PluginMdnsClient the mDNS responder/browser of a client
+type PluginMdnsClient struct {
+	core.PluginBase
+	mdnsNsPlug    *PluginMdnsNs
+	init          MdnsInit
+	enable        bool
+	state         int
+	hostname      string
+	hostRenames   uint32
+	services      []mdnsService
+	records       []mdnsRecord
+	names         map[string][]*mdnsRecord // by canonical name
+	unique        map[string]bool          // the probed names
+	conflicts     uint32
+	probes        int
+	announces     int
+	browse        map[string]bool // canonical <type>.local
+	table         map[string]*mdnsBrowseEntry
+	queryInterval time.Duration
+	pending       [2]map[*mdnsRecord]bool // delayed multicast answers over IPv4/IPv6
+	timer         core.CHTimerObj
+	browseTimer   core.CHTimerObj
+	respTimer     core.CHTimerObj
+	timerw        *core.TimerCtx
+	timerCb       PluginMdnsClientTimer
+	stats         MdnsStats
+	cdb           *core.CCounterDb
+	cdbv          *core.CCounterDbVec
+	initErr       string
+}
+
+/*NewMdnsClient create plugin */
+func NewMdnsClient(ctx *core.PluginCtx, initJson []byte) *core.PluginBase {
+
+	o := new(PluginMdnsClient)
+	o.InitPluginBase(ctx, o)             /* init base object*/
+	o.RegisterEvents(ctx, []string{}, o) /* register events, only if exits*/
+	nsplg := o.Ns.PluginCtx.GetOrCreate(MDNS_PLUG)
+	o.mdnsNsPlug = nsplg.Ext.(*PluginMdnsNs)
+	o.OnCreate(initJson)
+
+	return &o.PluginBase
+}
+
+func (o *PluginMdnsClient) OnCreate(initJson []byte) {
+	o.timerw = o.Tctx.GetTimerCtx()
+	o.cdb = NewMdnsStatsDb(&o.stats)
+	o.cdbv = core.NewCCounterDbVec(MDNS_PLUG)
+	o.cdbv.Add(o.cdb)
+	o.browse = make(map[string]bool)
+	o.table = make(map[string]*mdnsBrowseEntry)
+	for i := range o.pending {
+		o.pending[i] = make(map[*mdnsRecord]bool)
+	}
+	o.timer.SetCB(&o.timerCb, o, mdnsTimerState)
+	o.browseTimer.SetCB(&o.timerCb, o, mdnsTimerBrowse)
+	o.respTimer.SetCB(&o.timerCb, o, mdnsTimerResponse)
+
+	o.init = MdnsInit{HostTtl: MDNS_DEF_HOST_TTL, Ttl: MDNS_DEF_TTL, QueryInterval: MDNS_DEF_QUERY_INTERVAL}
+	var err error
+	if len(initJson) > 0 {
+		err = o.Tctx.UnmarshalValidate(initJson, &o.init)
+	}
+	if err == nil {
+		err = o.validate()
+	}
+	if err != nil {
+		o.stats.errInitJson++
+		o.initErr = err.Error()
+		return
+	}
+	o.enable = true
+	o.buildRecords()
+	o.mdnsNsPlug.addClient(o)
+	if len(o.unique) > 0 {
+		if o.init.NoProbe {
+			o.state = mdnsStateAnnouncing
+			o.timerw.StartTicks(&o.timer, 1)
+		} else {
+			// a random delay of 0-250 msec before the first probe
+			o.startProbing(time.Duration(o.Tctx.GetRandNumber(0, 250)) * time.Millisecond)
+		}
+	}
+	if len(o.browse) > 0 {
+		o.queryInterval = time.Second
+		o.timerw.Start(&o.browseTimer, time.Duration(o.Tctx.GetRandNumber(20, 120))*time.Millisecond)
+	}
+}
+
+// mdnsServiceType returns the canonical <type>.local of a service type, e.g. _ipp._tcp
+func mdnsServiceType(t string) (string, error) {
+	t = canonicalName(t)
+	if !strings.HasSuffix(t, "."+MDNS_DOMAIN) {
+		t += "." + MDNS_DOMAIN
+	}
+	l := strings.Split(t, ".")
+	if len(l) < 3 || !strings.HasPrefix(l[len(l)-3], "_") || (l[len(l)-2] != "_tcp" && l[len(l)-2] != "_udp") {
+		return "", fmt.Errorf("invalid service type %q, should be _service._tcp or _service._udp", t)
+	}
+	return t, checkName(t)
+}
+
+func (o *PluginMdnsClient) validate() error {
+	i := &o.init
+	if i.Hostname == "" {
+		m := o.Client.Mac
+		i.Hostname = fmt.Sprintf("emu-%02x%02x%02x%02x%02x%02x", m[0], m[1], m[2], m[3], m[4], m[5])
+	}
+	i.Hostname = strings.TrimSuffix(canonicalName(i.Hostname), "."+MDNS_DOMAIN)
+	if strings.Contains(i.Hostname, ".") || checkName(i.Hostname) != nil {
+		return fmt.Errorf("invalid hostname %q", i.Hostname)
+	}
+	o.hostname = i.Hostname
+	if o.Client.Ipv4.IsZero() && !i.Ipv6 {
+		return fmt.Errorf("the client should have an ipv4 or the ipv6 option")
+	}
+	for k := range i.Services {
+		s := &i.Services[k]
+		t, err := mdnsServiceType(s.Type)
+		if err != nil {
+			return err
+		}
+		s.Type = t
+		// the instance is a single label, it can have spaces and UTF-8
+		if strings.Contains(s.Instance, ".") || len(s.Instance) > DNS_MAX_LABEL_LEN-5 {
+			return fmt.Errorf("invalid service instance %q", s.Instance)
+		}
+		for _, txt := range s.Txt {
+			if len(txt) > 255 {
+				return fmt.Errorf("txt %q of %q is too long", txt, s.Instance)
+			}
+		}
+		o.services = append(o.services, mdnsService{init: s, instance: s.Instance})
+	}
+	for _, b := range i.Browse {
+		t := canonicalName(b)
+		if t != MDNS_SERVICES {
+			var err error
+			if t, err = mdnsServiceType(b); err != nil {
+				return err
+			}
+		}
+		o.browse[t] = true
+	}
+	if i.QueryInterval == 0 {
+		i.QueryInterval = MDNS_DEF_QUERY_INTERVAL
+	}
+	return nil
+}
+
+func (o *PluginMdnsClient) hostName() string {
+	return o.hostname + "." + MDNS_DOMAIN
+}
+
+func (o *PluginMdnsClient) addRecord(rr layers.DNSResourceRecord, unique bool) {
+	rr.Class = layers.DNSClassIN
+	key := mdnsKey(&rr)
+	for i := range o.records {
+		if o.records[i].key == key {
+			return
+		}
+	}
+	o.records = append(o.records, mdnsRecord{rr: rr, unique: unique, key: key})
+}
+
+// buildRecords builds the records of the current names
+func (o *PluginMdnsClient) buildRecords() {
+	i := &o.init
+	o.records = o.records[:0]
+	host := []byte(o.hostName())
+	if !o.Client.Ipv4.IsZero() {
+		o.addRecord(layers.DNSResourceRecord{Name: host, Type: layers.DNSTypeA, TTL: i.HostTtl, IP: o.Client.Ipv4.ToIP().To4()}, true)
+	}
+	if i.Ipv6 {
+		var l6 core.Ipv6Key
+		o.Client.GetIpv6LocalLink(&l6)
+		o.addRecord(layers.DNSResourceRecord{Name: host, Type: layers.DNSTypeAAAA, TTL: i.HostTtl, IP: l6.ToIP()}, true)
+		if !o.Client.Ipv6.IsZero() {
+			o.addRecord(layers.DNSResourceRecord{Name: host, Type: layers.DNSTypeAAAA, TTL: i.HostTtl, IP: o.Client.Ipv6.ToIP()}, true)
+		}
+	}
+	for k := range o.services {
+		s := &o.services[k]
+		name := []byte(s.instance + "." + s.init.Type)
+		o.addRecord(layers.DNSResourceRecord{Name: []byte(s.init.Type), Type: layers.DNSTypePTR, TTL: i.Ttl, PTR: name}, false)
+		o.addRecord(layers.DNSResourceRecord{Name: []byte(MDNS_SERVICES), Type: layers.DNSTypePTR, TTL: i.Ttl, PTR: []byte(s.init.Type)}, false)
+		o.addRecord(layers.DNSResourceRecord{Name: name, Type: layers.DNSTypeSRV, TTL: i.HostTtl,
+			SRV: layers.DNSSRV{Port: s.init.Port, Name: host}}, true)
+		txt := layers.DNSResourceRecord{Name: name, Type: layers.DNSTypeTXT, TTL: i.Ttl}
+		for _, t := range s.init.Txt {
+			txt.TXTs = append(txt.TXTs, []byte(t))
+		}
+		if len(txt.TXTs) == 0 {
+			txt.TXTs = [][]byte{{}}
+		}
+		o.addRecord(txt, true)
+	}
+	o.names = make(map[string][]*mdnsRecord)
+	o.unique = make(map[string]bool)
+	for k := range o.records {
+		r := &o.records[k]
+		n := canonicalName(string(r.rr.Name))
+		o.names[n] = append(o.names[n], r)
+		if r.unique {
+			o.unique[n] = true
+		}
+	}
+}
+
+func (o *PluginMdnsClient) OnEvent(msg string, a, b interface{}) {
+}
+
+func (o *PluginMdnsClient) GetCounterDbVec() *core.CCounterDbVec {
+	return o.cdbv
+}
+
+func (o *PluginMdnsClient) OnRemove(ctx *core.PluginCtx) {
+	if !o.enable {
+		return
+	}
+	if o.state == mdnsStateAnnouncing || o.state == mdnsStateEstablished {
+		o.sendGoodbye()
+	}
+	for _, t := range []*core.CHTimerObj{&o.timer, &o.browseTimer, &o.respTimer} {
+		if t.IsRunning() {
+			o.timerw.Stop(t)
+		}
+	}
+	o.mdnsNsPlug.removeClient(o)
+	o.enable = false
+}
+
+func (o *PluginMdnsClient) startProbing(delay time.Duration) {
+	if o.timer.IsRunning() {
+		o.timerw.Stop(&o.timer)
+	}
+	o.state = mdnsStateProbing
+	o.probes = 0
+	o.timerw.Start(&o.timer, delay)
+}
+
+func (o *PluginMdnsClient) onStateTimer() {
+	switch o.state {
+	case mdnsStateProbing:
+		if o.probes < MDNS_PROBES {
+			o.sendProbe()
+			o.probes++
+			o.timerw.Start(&o.timer, 250*time.Millisecond)
+			return
+		}
+		o.state = mdnsStateAnnouncing
+		o.announces = 0
+		fallthrough
+	case mdnsStateAnnouncing:
+		o.sendAnnounce()
+		o.announces++
+		if o.announces < MDNS_ANNOUNCES {
+			o.timerw.Start(&o.timer, time.Second)
+		} else {
+			o.state = mdnsStateEstablished
+		}
+	}
+}
+
+// families returns the IP versions of the multicast messages
+func (o *PluginMdnsClient) families() []bool {
+	if o.init.Ipv6 {
+		return []bool{false, true}
+	}
+	return []bool{false}
+}
+
+func (o *PluginMdnsClient) sendProbe() {
+	var d layers.DNS
+	for n := range o.unique {
+		recs := o.names[n]
+		d.Questions = append(d.Questions, layers.DNSQuestion{Name: recs[0].rr.Name, Type: MDNS_TYPE_ANY,
+			Class: layers.DNSClassIN | MDNS_CLASS_FLUSH})
+		for _, r := range recs {
+			if r.unique {
+				d.Authorities = append(d.Authorities, r.rr)
+			}
+		}
+	}
+	// the order of the map
+	sort.Slice(d.Questions, func(i, j int) bool { return string(d.Questions[i].Name) < string(d.Questions[j].Name) })
+	for _, ipv6 := range o.families() {
+		if o.send(&d, ipv6, nil) {
+			o.stats.pktTxProbe++
+		}
+	}
+}
+
+// response returns the response of the records with the additional records of RFC 6763 12
+func (o *PluginMdnsClient) response(answers []*mdnsRecord, ttl func(r *mdnsRecord) (uint32, layers.DNSClass)) *layers.DNS {
+	d := &layers.DNS{QR: true, AA: true}
+	sent := make(map[*mdnsRecord]bool)
+	add := func(v *[]layers.DNSResourceRecord, r *mdnsRecord) {
+		if sent[r] {
+			return
+		}
+		sent[r] = true
+		rr := r.rr
+		rr.TTL, rr.Class = ttl(r)
+		*v = append(*v, rr)
+	}
+	for _, r := range answers {
+		add(&d.Answers, r)
+	}
+	for _, r := range answers {
+		var target string
+		switch r.rr.Type {
+		case layers.DNSTypePTR:
+			target = string(r.rr.PTR)
+		case layers.DNSTypeSRV:
+			target = string(r.rr.SRV.Name)
+		default:
+			continue
+		}
+		for _, a := range o.names[canonicalName(target)] {
+			if a.rr.Type == layers.DNSTypePTR {
+				continue
+			}
+			add(&d.Additionals, a)
+			if a.rr.Type == layers.DNSTypeSRV {
+				for _, h := range o.names[canonicalName(string(a.rr.SRV.Name))] {
+					add(&d.Additionals, h)
+				}
+			}
+		}
+	}
+	return d
+}
+
+// multicastTtl the TTL and class of multicast responses, the unique records flush the caches
+func multicastTtl(r *mdnsRecord) (uint32, layers.DNSClass) {
+	if r.unique {
+		return r.rr.TTL, layers.DNSClassIN | MDNS_CLASS_FLUSH
+	}
+	return r.rr.TTL, layers.DNSClassIN
+}
+
+func (o *PluginMdnsClient) allRecords() []*mdnsRecord {
+	v := make([]*mdnsRecord, len(o.records))
+	for i := range o.records {
+		v[i] = &o.records[i]
+	}
+	return v
+}
+
+func (o *PluginMdnsClient) sendAnnounce() {
+	d := o.response(o.allRecords(), multicastTtl)
+	for _, ipv6 := range o.families() {
+		if o.send(d, ipv6, nil) {
+			o.stats.pktTxAnnounce++
+		}
+	}
+}
+
+func (o *PluginMdnsClient) sendGoodbye() {
+	d := &layers.DNS{QR: true, AA: true}
+	for _, r := range o.allRecords() {
+		rr := r.rr
+		rr.TTL = 0
+		d.Answers = append(d.Answers, rr)
+	}
+	for _, ipv6 := range o.families() {
+		if o.send(d, ipv6, nil) {
+			o.stats.pktTxGoodbye++
+		}
+	}
+}
+
+// send the message from port 5353 to the group or to a querier
+func (o *PluginMdnsClient) send(d *layers.DNS, ipv6 bool, dst *mdnsAddr) bool {
+	b, err := encodeDns(d)
+	if err != nil {
+		o.stats.errTx++
+		return false
+	}
+	var pkt []byte
+	var l3off uint16
+	if ipv6 {
+		var src core.Ipv6Key
+		o.Client.GetIpv6LocalLink(&src)
+		dstIp, dstMac, port := mdnsGroupIpv6, mdnsMacIpv6, uint16(MDNS_PORT)
+		if dst != nil {
+			dstIp, dstMac, port = dst.ip6, dst.mac, dst.port
+		}
+		l3 := core.PacketUtlBuild(
+			&layers.IPv6{Version: 6, NextHeader: layers.IPProtocolUDP, HopLimit: 255, SrcIP: src.ToIP(), DstIP: dstIp.ToIP()},
+			&layers.UDP{SrcPort: MDNS_PORT, DstPort: layers.UDPPort(port)},
+			gopacket.Payload(b),
+		)
+		ipv6h := layers.IPv6Header(l3[0:IPV6_HEADER_SIZE])
+		binary.BigEndian.PutUint16(l3[IPV6_HEADER_SIZE+4:IPV6_HEADER_SIZE+6], uint16(len(l3)-IPV6_HEADER_SIZE))
+		ipv6h.SetPyloadLength(uint16(len(l3) - IPV6_HEADER_SIZE))
+		ipv6h.FixUdpL4Checksum(l3[IPV6_HEADER_SIZE:], 0)
+		pkt = o.Client.GetL2Header(false, uint16(layers.EthernetTypeIPv6))
+		copy(pkt[0:6], dstMac[:])
+		l3off = uint16(len(pkt))
+		pkt = append(pkt, l3...)
+	} else {
+		dstIp, dstMac, port := mdnsGroupIpv4, mdnsMacIpv4, uint16(MDNS_PORT)
+		if dst != nil {
+			dstIp, dstMac, port = dst.ip4, dst.mac, dst.port
+		}
+		l3 := core.PacketUtlBuild(
+			&layers.IPv4{Version: 4, IHL: 5, TTL: 255, Id: 0xcc, SrcIP: o.Client.Ipv4.ToIP(), DstIP: dstIp.ToIP(),
+				Protocol: layers.IPProtocolUDP},
+			&layers.UDP{SrcPort: MDNS_PORT, DstPort: layers.UDPPort(port)},
+			gopacket.Payload(b),
+		)
+		ipv4h := layers.IPv4Header(l3[0:IPV4_HEADER_SIZE])
+		ipv4h.SetLength(uint16(len(l3)))
+		ipv4h.UpdateChecksum()
+		binary.BigEndian.PutUint16(l3[IPV4_HEADER_SIZE+4:IPV4_HEADER_SIZE+6], uint16(len(l3)-IPV4_HEADER_SIZE))
+		binary.BigEndian.PutUint16(l3[IPV4_HEADER_SIZE+6:IPV4_HEADER_SIZE+8], 0)
+		cs := layers.PktChecksumTcpUdp(l3[IPV4_HEADER_SIZE:], 0, ipv4h)
+		binary.BigEndian.PutUint16(l3[IPV4_HEADER_SIZE+6:IPV4_HEADER_SIZE+8], cs)
+		pkt = o.Client.GetL2Header(false, uint16(layers.EthernetTypeIPv4))
+		copy(pkt[0:6], dstMac[:])
+		l3off = uint16(len(pkt))
+		pkt = append(pkt, l3...)
+	}
+	o.Client.SendBufferFragmented(pkt, l3off)
+	o.stats.pktTx++
+	return true
+}
+
+func (o *PluginMdnsClient) onRx(d *layers.DNS, src *mdnsAddr) {
+	if src.mac == o.Client.Mac {
+		// our own message
+		return
+	}
+	if d.QR {
+		o.onResponse(d)
+	} else {
+		o.onQuery(d, src)
+	}
+}
+
+// lostTieBreak compares the proposed records of a simultaneous probe of the name, RFC 6762 8.2
+func (o *PluginMdnsClient) lostTieBreak(name string, d *layers.DNS) bool {
+	var theirs, ours []string
+	for i := range d.Authorities {
+		if rr := &d.Authorities[i]; canonicalName(string(rr.Name)) == name {
+			theirs = append(theirs, mdnsKey(rr))
+		}
+	}
+	if len(theirs) == 0 {
+		return false
+	}
+	for _, r := range o.names[name] {
+		if r.unique {
+			ours = append(ours, r.key)
+		}
+	}
+	sort.Strings(theirs)
+	sort.Strings(ours)
+	for i := 0; i < len(ours) && i < len(theirs); i++ {
+		if ours[i] != theirs[i] {
+			return ours[i] < theirs[i]
+		}
+	}
+	return len(ours) < len(theirs)
+}
+
+func (o *PluginMdnsClient) onQuery(d *layers.DNS, src *mdnsAddr) {
+	o.stats.pktRxQuery++
+	switch o.state {
+	case mdnsStateProbing:
+		for _, q := range d.Questions {
+			n := canonicalName(string(q.Name))
+			if o.unique[n] && o.lostTieBreak(n, d) {
+				o.stats.probeDefer++
+				o.startProbing(time.Second)
+				return
+			}
+		}
+		return
+	case mdnsStateConflict:
+		return
+	}
+
+	legacy := src.port != MDNS_PORT
+	unicast := legacy
+	shared := false
+	var answers []*mdnsRecord
+	added := make(map[*mdnsRecord]bool)
+	for _, q := range d.Questions {
+		if q.Class&MDNS_CLASS_FLUSH != 0 {
+			unicast = true
+		}
+		if c := q.Class &^ MDNS_CLASS_FLUSH; c != layers.DNSClassIN && c != layers.DNSClassAny {
+			continue
+		}
+		for _, r := range o.names[canonicalName(string(q.Name))] {
+			if (q.Type != r.rr.Type && q.Type != MDNS_TYPE_ANY) || added[r] {
+				continue
+			}
+			if o.isKnownAnswer(r, d) {
+				o.stats.knownAnswer++
+				continue
+			}
+			added[r] = true
+			answers = append(answers, r)
+			shared = shared || !r.unique
+		}
+	}
+	if len(answers) == 0 {
+		return
+	}
+	if unicast {
+		o.sendUnicast(answers, d, src, legacy)
+		return
+	}
+	if !shared {
+		o.sendResponse(answers, src.ipv6)
+		return
+	}
+	// the responders of a shared record answer at once, spread them
+	fam := 0
+	if src.ipv6 {
+		fam = 1
+	}
+	for _, r := range answers {
+		o.pending[fam][r] = true
+	}
+	if !o.respTimer.IsRunning() {
+		o.timerw.Start(&o.respTimer, time.Duration(o.Tctx.GetRandNumber(20, 120))*time.Millisecond)
+	}
+}
+
+// isKnownAnswer returns true in case the query has the record with at least half of the TTL, RFC 6762 7.1
+func (o *PluginMdnsClient) isKnownAnswer(r *mdnsRecord, d *layers.DNS) bool {
+	for i := range d.Answers {
+		if a := &d.Answers[i]; a.Type == r.rr.Type && a.TTL >= r.rr.TTL/2 && mdnsKey(a) == r.key {
+			return true
+		}
+	}
+	return false
+}
+
+func (o *PluginMdnsClient) sendResponse(answers []*mdnsRecord, ipv6 bool) {
+	if o.send(o.response(answers, multicastTtl), ipv6, nil) {
+		o.stats.pktTxResponse++
+	}
+}
+
+func (o *PluginMdnsClient) sendUnicast(answers []*mdnsRecord, q *layers.DNS, src *mdnsAddr, legacy bool) {
+	ttl := multicastTtl
+	if legacy {
+		// RFC 6762 6.7, without cache flush
+		ttl = func(r *mdnsRecord) (uint32, layers.DNSClass) {
+			if r.rr.TTL > MDNS_LEGACY_TTL {
+				return MDNS_LEGACY_TTL, layers.DNSClassIN
+			}
+			return r.rr.TTL, layers.DNSClassIN
+		}
+	}
+	d := o.response(answers, ttl)
+	if legacy {
+		d.ID = q.ID
+		d.Questions = q.Questions
+	}
+	if o.send(d, src.ipv6, src) {
+		o.stats.pktTxResponse++
+		o.stats.pktTxUnicast++
+	}
+}
+
+func (o *PluginMdnsClient) onResponseTimer() {
+	for fam := range o.pending {
+		if len(o.pending[fam]) == 0 {
+			continue
+		}
+		var answers []*mdnsRecord
+		for i := range o.records {
+			if r := &o.records[i]; o.pending[fam][r] {
+				answers = append(answers, r)
+			}
+		}
+		o.pending[fam] = make(map[*mdnsRecord]bool)
+		if o.state == mdnsStateAnnouncing || o.state == mdnsStateEstablished {
+			o.sendResponse(answers, fam == 1)
+		}
+	}
+}
+
+func (o *PluginMdnsClient) onResponse(d *layers.DNS) {
+	o.stats.pktRxResponse++
+	if len(o.unique) > 0 && o.state != mdnsStateConflict {
+		o.checkConflict(d)
+	}
+	if len(o.browse) > 0 {
+		o.onBrowseResponse(d)
+	}
+}
+
+// checkConflict looks for records of other hosts with our unique names, RFC 6762 9
+func (o *PluginMdnsClient) checkConflict(d *layers.DNS) {
+	for _, v := range [][]layers.DNSResourceRecord{d.Answers, d.Additionals} {
+		for i := range v {
+			rr := &v[i]
+			n := canonicalName(string(rr.Name))
+			if !o.unique[n] || rr.TTL == 0 {
+				continue
+			}
+			key := mdnsKey(rr)
+			conflict := true
+			sameType := false
+			for _, r := range o.names[n] {
+				if r.key == key {
+					conflict = false
+				}
+				sameType = sameType || (r.unique && r.rr.Type == rr.Type)
+			}
+			// any record of the name conflicts with a probe, a record of the same type with a published name
+			if !conflict || (o.state != mdnsStateProbing && !sameType) {
+				continue
+			}
+			o.onConflict(n)
+			return
+		}
+	}
+}
+
+func (o *PluginMdnsClient) onConflict(name string) {
+	o.stats.conflicts++
+	o.conflicts++
+	if o.state != mdnsStateProbing {
+		// probe the names again, the other host defends them
+		o.startProbing(0)
+		return
+	}
+	if o.conflicts > MDNS_MAX_CONFLICTS {
+		o.stats.errTooManyConflict++
+		o.state = mdnsStateConflict
+		if o.timer.IsRunning() {
+			o.timerw.Stop(&o.timer)
+		}
+		return
+	}
+	o.mdnsNsPlug.unindex(o)
+	if name == canonicalName(o.hostName()) {
+		o.hostRenames++
+		o.hostname = fmt.Sprintf("%s-%d", o.init.Hostname, o.hostRenames+1)
+	} else {
+		for k := range o.services {
+			s := &o.services[k]
+			if canonicalName(s.instance+"."+s.init.Type) == name {
+				s.renames++
+				s.instance = fmt.Sprintf("%s (%d)", s.init.Instance, s.renames+1)
+			}
+		}
+	}
+	o.buildRecords()
+	o.mdnsNsPlug.index(o)
+	o.startProbing(0)
+}
+
+// remaining returns the remaining TTL of the entry in sec
+func (o *PluginMdnsClient) remaining(e *mdnsBrowseEntry) uint32 {
+	if e.expire <= o.timerw.Ticks {
+		return 0
+	}
+	return uint32(time.Duration(e.expire-o.timerw.Ticks) * o.timerw.TickDuration / time.Second)
+}
+
+func (o *PluginMdnsClient) expireBrowse() {
+	for k, e := range o.table {
+		if e.expire <= o.timerw.Ticks {
+			delete(o.table, k)
+			o.stats.browseExpired++
+		}
+	}
+}
+
+func (o *PluginMdnsClient) onBrowseTimer() {
+	o.expireBrowse()
+	var d layers.DNS
+	for t := range o.browse {
+		d.Questions = append(d.Questions, layers.DNSQuestion{Name: []byte(t), Type: layers.DNSTypePTR, Class: layers.DNSClassIN})
+	}
+	sort.Slice(d.Questions, func(i, j int) bool { return string(d.Questions[i].Name) < string(d.Questions[j].Name) })
+	// the known answers with more than half of the TTL
+	for _, e := range o.table {
+		if rem := o.remaining(e); rem > e.ttl/2 {
+			d.Answers = append(d.Answers, layers.DNSResourceRecord{Name: []byte(e.stype), Type: layers.DNSTypePTR,
+				Class: layers.DNSClassIN, TTL: rem, PTR: []byte(e.name)})
+		}
+	}
+	for _, ipv6 := range o.families() {
+		if o.send(&d, ipv6, nil) {
+			o.stats.pktTxQuery++
+		}
+	}
+	o.timerw.Start(&o.browseTimer, o.queryInterval)
+	o.queryInterval *= 2
+	if max := time.Duration(o.init.QueryInterval) * time.Second; o.queryInterval > max {
+		o.queryInterval = max
+	}
+}
+
+func appendAddr(v []string, addr string) []string {
+	for _, a := range v {
+		if a == addr {
+			return v
+		}
+	}
+	if len(v) >= MDNS_MAX_ADDRS {
+		return v
+	}
+	return append(v, addr)
+}
+
+// onBrowseResponse updates the instances of the browsed types, the SRV/TXT/A/AAAA are usually additional records
+func (o *PluginMdnsClient) onBrowseResponse(d *layers.DNS) {
+	rrs := make([]*layers.DNSResourceRecord, 0, len(d.Answers)+len(d.Additionals))
+	for _, v := range [][]layers.DNSResourceRecord{d.Answers, d.Additionals} {
+		for i := range v {
+			rrs = append(rrs, &v[i])
+		}
+	}
+	for _, rr := range rrs {
+		t := canonicalName(string(rr.Name))
+		if rr.Type != layers.DNSTypePTR || !o.browse[t] {
+			continue
+		}
+		name := string(rr.PTR)
+		k := canonicalName(name)
+		e, ok := o.table[k]
+		if rr.TTL == 0 {
+			if ok {
+				delete(o.table, k)
+				o.stats.browseRemove++
+			}
+			continue
+		}
+		if !ok {
+			if len(o.table) >= MDNS_MAX_BROWSE {
+				o.stats.errBrowseFull++
+				continue
+			}
+			e = &mdnsBrowseEntry{name: name, stype: string(rr.Name), instance: name}
+			if strings.HasSuffix(k, "."+t) {
+				e.instance = name[:len(k)-len(t)-1]
+			}
+			o.table[k] = e
+			o.stats.browseAdd++
+		}
+		e.ttl = rr.TTL
+		e.expire = o.timerw.Ticks + uint64(o.timerw.DurationToTicks(time.Duration(rr.TTL)*time.Second))
+	}
+	for _, rr := range rrs {
+		e, ok := o.table[canonicalName(string(rr.Name))]
+		if !ok {
+			continue
+		}
+		switch rr.Type {
+		case layers.DNSTypeSRV:
+			e.host, e.port = string(rr.SRV.Name), rr.SRV.Port
+		case layers.DNSTypeTXT:
+			e.txt = e.txt[:0]
+			for _, t := range rr.TXTs {
+				if len(t) > 0 {
+					e.txt = append(e.txt, string(t))
+				}
+			}
+		}
+	}
+	for _, rr := range rrs {
+		if rr.Type != layers.DNSTypeA && rr.Type != layers.DNSTypeAAAA {
+			continue
+		}
+		host := canonicalName(string(rr.Name))
+		for _, e := range o.table {
+			if canonicalName(e.host) != host {
+				continue
+			}
+			if rr.Type == layers.DNSTypeA {
+				e.ipv4 = appendAddr(e.ipv4, net.IP(rr.IP).String())
+			} else {
+				e.ipv6 = appendAddr(e.ipv6, net.IP(rr.IP).String())
+			}
+		}
+	}
+}
+
+func (o *PluginMdnsClient) getInfo() *MdnsInfo {
+	info := &MdnsInfo{State: mdnsStates[o.state], Hostname: o.hostName(), Instances: []string{}, Conflicts: o.conflicts}
+	for _, s := range o.services {
+		info.Instances = append(info.Instances, s.instance+"."+s.init.Type)
+	}
+	return info
+}
+
+func (o *PluginMdnsClient) getBrowseInfo() []MdnsServiceInfo {
+	o.expireBrowse()
+	info := make([]MdnsServiceInfo, 0, len(o.table))
+	for _, e := range o.table {
+		info = append(info, MdnsServiceInfo{Name: e.name, Instance: e.instance, Type: strings.TrimSuffix(e.stype, "."+MDNS_DOMAIN),
+			Host: e.host, Port: e.port, Txt: append([]string{}, e.txt...), Ipv4: append([]string{}, e.ipv4...),
+			Ipv6: append([]string{}, e.ipv6...), Remaining: o.remaining(e)})
+	}
+	sort.Slice(info, func(i, j int) bool { return info[i].Name < info[j].Name })
+	return info
+}
+
+type MdnsNsStats struct {
+	pktRx          uint64
+	pktRxNoClient  uint64
+	errRxMalformed uint64
+	joinIpv4       uint64
+	joinIpv6       uint64
+	leaveIpv4      uint64
+	leaveIpv6      uint64
+	errJoin        uint64
+}
+
+func NewMdnsNsStatsDb(o *MdnsNsStats) *core.CCounterDb {
+	db := core.NewCCounterDb(MDNS_PLUG)
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRx,
+		Name:     "pktRx",
+		Help:     "rx packets",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxNoClient,
+		Name:     "pktRxNoClient",
+		Help:     "rx packets without names of the clients",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.errRxMalformed,
+		Name:     "errRxMalformed",
+		Help:     "rx malformed packets",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.joinIpv4,
+		Name:     "joinIpv4",
+		Help:     "224.0.0.251 added to the igmp plugin",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.joinIpv6,
+		Name:     "joinIpv6",
+		Help:     "ff02::fb added to the ipv6 plugin (MLD)",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.leaveIpv4,
+		Name:     "leaveIpv4",
+		Help:     "224.0.0.251 removed from the igmp plugin",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.leaveIpv6,
+		Name:     "leaveIpv6",
+		Help:     "ff02::fb removed from the ipv6 plugin (MLD)",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.errJoin,
+		Name:     "errJoin",
+		Help:     "can't add the group, e.g. it was added by the user",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	return db
+}
+
+// PluginMdnsNs dispatches the messages to the clients by the names and joins the groups for them
+type PluginMdnsNs struct {
+	core.PluginBase
+	names    map[string][]*PluginMdnsClient
+	browsers map[*PluginMdnsClient]bool
+	clients  int
+	joined4  bool
+	joined6  bool
+	stats    MdnsNsStats
+	cdb      *core.CCounterDb
+	cdbv     *core.CCounterDbVec
+}
+
+func NewMdnsNs(ctx *core.PluginCtx, initJson []byte) *core.PluginBase {
+	o := new(PluginMdnsNs)
+	o.InitPluginBase(ctx, o)
+	o.RegisterEvents(ctx, []string{}, o)
+	o.cdb = NewMdnsNsStatsDb(&o.stats)
+	o.cdbv = core.NewCCounterDbVec(MDNS_PLUG)
+	o.cdbv.Add(o.cdb)
+	o.names = make(map[string][]*PluginMdnsClient)
+	o.browsers = make(map[*PluginMdnsClient]bool)
+	return &o.PluginBase
+}
+
+func (o *PluginMdnsNs) OnRemove(ctx *core.PluginCtx) {
+}
+
+func (o *PluginMdnsNs) OnEvent(msg string, a, b interface{}) {
+}
+
+func (o *PluginMdnsNs) GetCounterDbVec() *core.CCounterDbVec {
+	return o.cdbv
+}
+
+func (o *PluginMdnsNs) igmpNs() *igmp.PluginIgmpNs {
+	plug := o.Ns.PluginCtx.Get(igmp.IGMP_PLUG)
+	if plug == nil || plug.Ext == nil {
+		return nil
+	}
+	return plug.Ext.(*igmp.PluginIgmpNs)
+}
+
+func (o *PluginMdnsNs) ipv6Ns() *ipv6.PluginIpv6Ns {
+	plug := o.Ns.PluginCtx.Get(ipv6.IPV6_PLUG)
+	if plug == nil || plug.Ext == nil {
+		return nil
+	}
+	return plug.Ext.(*ipv6.PluginIpv6Ns)
+}
+
+// join adds the groups to the namespace plugins that exist, the first client of the family joins
+func (o *PluginMdnsNs) join(c *PluginMdnsClient) {
+	if !o.joined4 {
+		if p := o.igmpNs(); p != nil {
+			if p.JoinMc([]core.Ipv4Key{mdnsGroupIpv4}) == nil {
+				o.joined4 = true
+				o.stats.joinIpv4++
+			} else {
+				o.stats.errJoin++
+			}
+		}
+	}
+	if !o.joined6 && c.init.Ipv6 {
+		if p := o.ipv6Ns(); p != nil {
+			if p.JoinMc([]core.Ipv6Key{mdnsGroupIpv6}) == nil {
+				o.joined6 = true
+				o.stats.joinIpv6++
+			} else {
+				o.stats.errJoin++
+			}
+		}
+	}
+}
+
+// leave removes the groups when there are no clients
+func (o *PluginMdnsNs) leave() {
+	if o.joined4 {
+		if p := o.igmpNs(); p != nil {
+			p.LeaveMc([]core.Ipv4Key{mdnsGroupIpv4})
+			o.stats.leaveIpv4++
+		}
+		o.joined4 = false
+	}
+	if o.joined6 {
+		if p := o.ipv6Ns(); p != nil {
+			p.LeaveMc([]core.Ipv6Key{mdnsGroupIpv6})
+			o.stats.leaveIpv6++
+		}
+		o.joined6 = false
+	}
+}
+
+func (o *PluginMdnsNs) index(c *PluginMdnsClient) {
+	for n := range c.names {
+		o.names[n] = append(o.names[n], c)
+	}
+}
+
+func (o *PluginMdnsNs) unindex(c *PluginMdnsClient) {
+	for n := range c.names {
+		v := o.names[n]
+		for i := range v {
+			if v[i] == c {
+				v = append(v[:i], v[i+1:]...)
+				break
+			}
+		}
+		if len(v) == 0 {
+			delete(o.names, n)
+		} else {
+			o.names[n] = v
+		}
+	}
+}
+
+func (o *PluginMdnsNs) addClient(c *PluginMdnsClient) {
+	o.clients++
+	o.join(c)
+	o.index(c)
+	if len(c.browse) > 0 {
+		o.browsers[c] = true
+	}
+}
+
+func (o *PluginMdnsNs) removeClient(c *PluginMdnsClient) {
+	o.unindex(c)
+	delete(o.browsers, c)
+	o.clients--
+	if o.clients == 0 {
+		o.leave()
+	}
+}
+
+// HandleRxMdnsPacket dispatches queries to the owners of the names, responses to the owners and the browsers
+func (o *PluginMdnsNs) HandleRxMdnsPacket(ps *core.ParserPacketState) int {
+	o.stats.pktRx++
+	p := ps.M.GetData()
+	var d layers.DNS
+	if ps.L7Len == 0 || decodeDns(p[ps.L7:ps.L7+ps.L7Len], &d) != nil {
+		o.stats.errRxMalformed++
+		return core.PARSER_ERR
+	}
+	var src mdnsAddr
+	copy(src.mac[:], p[6:12])
+	src.ipv6 = p[ps.L3]>>4 == 6
+	if src.ipv6 {
+		copy(src.ip6[:], p[ps.L3+8:ps.L3+24])
+	} else {
+		copy(src.ip4[:], p[ps.L3+12:ps.L3+16])
+	}
+	src.port = binary.BigEndian.Uint16(p[ps.L4 : ps.L4+2])
+
+	var clients []*PluginMdnsClient
+	found := make(map[*PluginMdnsClient]bool)
+	add := func(name []byte) {
+		for _, c := range o.names[canonicalName(string(name))] {
+			if !found[c] {
+				found[c] = true
+				clients = append(clients, c)
+			}
+		}
+	}
+	if d.QR {
+		for _, v := range [][]layers.DNSResourceRecord{d.Answers, d.Additionals} {
+			for i := range v {
+				add(v[i].Name)
+			}
+		}
+		for c := range o.browsers {
+			if !found[c] {
+				found[c] = true
+				clients = append(clients, c)
+			}
+		}
+	} else {
+		for i := range d.Questions {
+			add(d.Questions[i].Name)
+		}
+	}
+	if len(clients) == 0 {
+		o.stats.pktRxNoClient++
+		return core.PARSER_OK
+	}
+	for _, c := range clients {
+		if c.enable {
+			c.onRx(&d, &src)
+		}
+	}
+	return core.PARSER_OK
+}
+
+// HandleRxMdnsPacket Parser call this function with mbuf from the pool
+func HandleRxMdnsPacket(ps *core.ParserPacketState) int {
+	ns := ps.Tctx.GetNs(ps.Tun)
+	if ns == nil {
+		return core.PARSER_ERR
+	}
+	nsplg := ns.PluginCtx.Get(MDNS_PLUG)
+	if nsplg == nil {
+		// port 5353 of the other users of the namespace e.g. transport
+		return ps.Tctx.HandleUdpDefault(ps)
+	}
+	mdnsPlug := nsplg.Ext.(*PluginMdnsNs)
+	return mdnsPlug.HandleRxMdnsPacket(ps)
+}
+
+type PluginMdnsCReg struct{}
+type PluginMdnsNsReg struct{}
+
+func (o PluginMdnsCReg) NewPlugin(ctx *core.PluginCtx, initJson []byte) *core.PluginBase {
+	return NewMdnsClient(ctx, initJson)
+}
+
+func (o PluginMdnsNsReg) NewPlugin(ctx *core.PluginCtx, initJson []byte) *core.PluginBase {
+	return NewMdnsNs(ctx, initJson)
+}
+
+/*******************************************/
+/*  RPC commands */
+type (
+	ApiMdnsClientCntHandler    struct{}
+	ApiMdnsClientInfoHandler   struct{}
+	ApiMdnsClientBrowseHandler struct{}
+	ApiMdnsNsCntHandler        struct{}
+)
+
+func getMdnsClient(ctx interface{}, params *fastjson.RawMessage) (*PluginMdnsClient, *jsonrpc.Error) {
+	tctx := ctx.(*core.CThreadCtx)
+	plug, err := tctx.GetClientPlugin(params, MDNS_PLUG)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+	return plug.Ext.(*PluginMdnsClient), nil
+}
+
+func (h ApiMdnsClientCntHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	var p core.ApiCntParams
+	tctx := ctx.(*core.CThreadCtx)
+	c, err := getMdnsClient(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	return c.cdbv.GeneralCounters(err, tctx, params, &p)
+}
+
+func (h ApiMdnsClientInfoHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	c, err := getMdnsClient(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	return c.getInfo(), nil
+}
+
+func (h ApiMdnsClientBrowseHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	c, err := getMdnsClient(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	return c.getBrowseInfo(), nil
+}
+
+func (h ApiMdnsNsCntHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	var p core.ApiCntParams
+	tctx := ctx.(*core.CThreadCtx)
+	plug, err := tctx.GetNsPlugin(params, MDNS_PLUG)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+	return plug.Ext.(*PluginMdnsNs).cdbv.GeneralCounters(nil, tctx, params, &p)
+}
+
+func init() {
+
+	/* register of plugins callbacks for ns,c level  */
+	core.PluginRegister(MDNS_PLUG,
+		core.PluginRegisterData{Client: PluginMdnsCReg{},
+			Ns:     PluginMdnsNsReg{},
+			Thread: nil}) /* no need for thread context for now */
+
+	core.RegisterCB("mdns_client_cnt", ApiMdnsClientCntHandler{}, false)       // get counters/meta
+	core.RegisterCB("mdns_client_info", ApiMdnsClientInfoHandler{}, false)     // names and state
+	core.RegisterCB("mdns_client_browse", ApiMdnsClientBrowseHandler{}, false) // the instances found by the browser
+	core.RegisterCB("mdns_ns_cnt", ApiMdnsNsCntHandler{}, false)               // get counters/meta
+
+	/* register callback for rx side*/
+	core.ParserRegister(MDNS_PLUG, HandleRxMdnsPacket,
+		core.ParserRegisterData{UdpPorts: []uint16{MDNS_PORT}, UdpV6Ports: []uint16{MDNS_PORT}})
+}
@@ -0,0 +1,1399 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package dns
+
+/*
+DNS stub resolver, client plugin
+
+The queries are sent over a UDP socket of the transport layer to the first server and retransmitted after timeout msec,
+after tries attempts the next server is queried. A truncated (TC) answer is queried again over TCP, "tcp": true
+always uses TCP. SERVFAIL/REFUSED moves to the next server as well.
+
+The answers are cached for the minimal TTL of the records (up to max_ttl sec), NXDOMAIN and empty answers for the SOA
+minimum or neg_ttl. The entries are removed by the timer wheel, a cached answer is returned with the remaining TTL.
+
+The namespace init json is the default of the clients, the client init json overrides it (a storm of the client is merged
+into the storm of the namespace).
+
+client init json {
+	"servers": ["16.0.0.254", "[2001:db8::53]:5353"],
+	"timeout": 2000,
+	"tries": 2,
+	"tcp": false,
+	"no_cache": false,
+	"cache_size": 1024,
+	"max_ttl": 86400,
+	"neg_ttl": 60,
+	"storm": {"rate": 100, "names": ["www.example.com"], "types": ["A", "AAAA"], "count": 1000, "cache": false}
+}
+
+storm sends rate queries/sec of the names (round robin) and types (round robin, A by default), count 0 is forever.
+Instead of names the field engine "name" can generate them, e.g.
+
+	"engines": [{"engine_name": "name", "engine_type": "string_list",
+				 "params": {"size": 32, "offset": 0, "op": "rand", "list": ["a.example.com", "b.example.com"]}}]
+
+The storm queries bypass the cache unless "cache": true. The latency is measured from the first transmission.
+
+Other plugins (http, tdl, ipfix, transe) resolve a host:port with ResolveAddr(client, "www.example.com:80", false, cb).
+
+*/
+
+import (
+	"bytes"
+	"emu/core"
+	engines "emu/plugins/field_engine"
+	"emu/plugins/transport"
+	"encoding/binary"
+	"external/google/gopacket/layers"
+	"external/osamingo/jsonrpc"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/intel-go/fastjson"
+)
+
+const (
+	DNS_PLUG            = "dns"
+	DNS_DEF_TIMEOUT_MS  = 2000
+	DNS_DEF_TRIES       = 2
+	DNS_DEF_CACHE_SIZE  = 1024
+	DNS_DEF_MAX_TTL     = 86400
+	DNS_DEF_NEG_TTL     = 60
+	DNS_MAX_PENDING     = 4096
+	DNS_STORM_NAME_ENG  = "name"
+	DNS_ERR_TIMEOUT     = "timeout"
+	DNS_ERR_NO_SERVERS  = "no dns servers"
+	DNS_ERR_NOT_ENABLED = "dns client is not enabled"
+)
+
+type DnsStormInit struct {
+	Rate    float32              `json:"rate" validate:"required,gt=0"`
+	Names   []string             `json:"names"`
+	Engines *fastjson.RawMessage `json:"engines"`
+	Types   []string             `json:"types"`
+	Count   uint64               `json:"count"`
+	Cache   bool                 `json:"cache"`
+}
+
+type DnsInit struct {
+	Servers   []string      `json:"servers" validate:"max=8"`
+	Timeout   uint32        `json:"timeout"`
+	Tries     uint8         `json:"tries" validate:"max=10"`
+	Tcp       bool          `json:"tcp"`
+	NoCache   bool          `json:"no_cache"`
+	CacheSize uint32        `json:"cache_size"`
+	MaxTtl    uint32        `json:"max_ttl"`
+	NegTtl    uint32        `json:"neg_ttl"`
+	Storm     *DnsStormInit `json:"storm"`
+}
+
+// DnsResult is the answer of a query, error is set in case there is no answer
+type DnsResult struct {
+	Name    string      `json:"name"`
+	Type    string      `json:"type"`
+	Rcode   string      `json:"rcode"`
+	Records []DnsRecord `json:"records"`
+	Cached  bool        `json:"cached"`
+	Error   string      `json:"error,omitempty"`
+}
+
+// DnsResultCb is called with the result of a query
+type DnsResultCb func(res *DnsResult)
+
+type DnsCacheInfo struct {
+	DnsResult
+	Remaining uint32 `json:"remaining"` // sec
+}
+
+type DnsStats struct {
+	queries           uint64
+	stormQueries      uint64
+	cacheHit          uint64
+	cacheMiss         uint64
+	cacheAdd          uint64
+	cacheExpired      uint64
+	cacheFull         uint64
+	pktTx             uint64
+	pktTxTcp          uint64
+	pktTxRetransmit   uint64
+	pktRx             uint64
+	pktRxTcp          uint64
+	pktRxTruncated    uint64
+	rcodeNoError      uint64
+	rcodeNxDomain     uint64
+	rcodeServFail     uint64
+	rcodeRefused      uint64
+	rcodeOther        uint64
+	queryTimeout      uint64
+	latencyMin        uint64
+	latencyAvg        uint64
+	latencyMax        uint64
+	latencyUnder1ms   uint64
+	latencyUnder10ms  uint64
+	latencyUnder100ms uint64
+	latencyUnder1s    uint64
+	latencyOver1s     uint64
+	errInitJson       uint64
+	errName           uint64
+	errSocket         uint64
+	errUnresolved     uint64
+	errTx             uint64
+	errTcp            uint64
+	errRxMalformed    uint64
+	errRxUnexpected   uint64
+	errPendingFull    uint64
+	errStormName      uint64
+}
+
+func NewDnsStatsDb(o *DnsStats) *core.CCounterDb {
+	db := core.NewCCounterDb(DNS_PLUG)
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.queries,
+		Name:     "queries",
+		Help:     "queries sent to the servers",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.stormQueries,
+		Name:     "stormQueries",
+		Help:     "queries of the storm",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.cacheHit,
+		Name:     "cacheHit",
+		Help:     "queries answered from the cache",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.cacheMiss,
+		Name:     "cacheMiss",
+		Help:     "queries not in the cache",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.cacheAdd,
+		Name:     "cacheAdd",
+		Help:     "answers added to the cache",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.cacheExpired,
+		Name:     "cacheExpired",
+		Help:     "cache entries removed by the TTL",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.cacheFull,
+		Name:     "cacheFull",
+		Help:     "answers not cached, the cache is full",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTx,
+		Name:     "pktTx",
+		Help:     "tx queries over UDP",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTxTcp,
+		Name:     "pktTxTcp",
+		Help:     "tx queries over TCP",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTxRetransmit,
+		Name:     "pktTxRetransmit",
+		Help:     "queries retransmitted after timeout",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRx,
+		Name:     "pktRx",
+		Help:     "rx answers over UDP",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxTcp,
+		Name:     "pktRxTcp",
+		Help:     "rx answers over TCP",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxTruncated,
+		Name:     "pktRxTruncated",
+		Help:     "rx truncated answers, queried again over TCP",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.rcodeNoError,
+		Name:     "rcodeNoError",
+		Help:     "answers with NOERROR",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.rcodeNxDomain,
+		Name:     "rcodeNxDomain",
+		Help:     "answers with NXDOMAIN",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.rcodeServFail,
+		Name:     "rcodeServFail",
+		Help:     "answers with SERVFAIL",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.rcodeRefused,
+		Name:     "rcodeRefused",
+		Help:     "answers with REFUSED",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.rcodeOther,
+		Name:     "rcodeOther",
+		Help:     "answers with other error codes",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.queryTimeout,
+		Name:     "queryTimeout",
+		Help:     "queries without an answer of any server",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.latencyMin,
+		Name:     "latencyMin",
+		Help:     "minimal latency of the answers",
+		Unit:     "usec",
+		DumpZero: false,
+		Gauge:    true,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.latencyAvg,
+		Name:     "latencyAvg",
+		Help:     "average latency of the answers",
+		Unit:     "usec",
+		DumpZero: false,
+		Gauge:    true,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.latencyMax,
+		Name:     "latencyMax",
+		Help:     "maximal latency of the answers",
+		Unit:     "usec",
+		DumpZero: false,
+		Gauge:    true,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.latencyUnder1ms,
+		Name:     "latencyUnder1ms",
+		Help:     "answers with latency under 1 msec",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.latencyUnder10ms,
+		Name:     "latencyUnder10ms",
+		Help:     "answers with latency of 1-10 msec",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.latencyUnder100ms,
+		Name:     "latencyUnder100ms",
+		Help:     "answers with latency of 10-100 msec",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.latencyUnder1s,
+		Name:     "latencyUnder1s",
+		Help:     "answers with latency of 100-1000 msec",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.latencyOver1s,
+		Name:     "latencyOver1s",
+		Help:     "answers with latency over 1 sec",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.errInitJson,
+		Name:     "errInitJson",
+		Help:     "invalid init json",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.errName,
+		Name:     "errName",
+		Help:     "invalid name or type of a query",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.errSocket,
+		Name:     "errSocket",
+		Help:     "can't open a socket to the server",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.errUnresolved,
+		Name:     "errUnresolved",
+		Help:     "query not sent, the default gateway is not resolved",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.errTx,
+		Name:     "errTx",
+		Help:     "query not sent by the socket",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.errTcp,
+		Name:     "errTcp",
+		Help:     "TCP connection closed without an answer",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.errRxMalformed,
+		Name:     "errRxMalformed",
+		Help:     "rx malformed answers",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.errRxUnexpected,
+		Name:     "errRxUnexpected",
+		Help:     "rx answers without a matching query",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.errPendingFull,
+		Name:     "errPendingFull",
+		Help:     "query dropped, too many pending queries",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.errStormName,
+		Name:     "errStormName",
+		Help:     "the field engine failed to generate a name",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	return db
+}
+
+// dnsServer is a server of the client, the UDP socket is opened by the first query
+type dnsServer struct {
+	plug   *PluginDnsClient
+	addr   string
+	socket transport.SocketApi
+}
+
+func (o *dnsServer) OnRxEvent(event transport.SocketEventType) {}
+func (o *dnsServer) OnTxEvent(event transport.SocketEventType) {}
+
+func (o *dnsServer) OnRxData(d []byte) {
+	o.plug.onUdpAnswer(o, d)
+}
+
+// dnsTcpConn is a TCP connection of a query, the message is prefixed by a 2 bytes length
+type dnsTcpConn struct {
+	query  *dnsQuery
+	socket transport.SocketApi
+	rx     []byte
+}
+
+func (o *dnsTcpConn) OnRxEvent(event transport.SocketEventType) {
+	q := o.query
+	if event&transport.SocketEventConnected > 0 {
+		q.plug.sendTcp(o)
+	}
+	if event&transport.SocketRemoteDisconnect > 0 {
+		o.socket.Close()
+	}
+	if event&transport.SocketClosed > 0 && q.conn == o {
+		// closed before the answer, the timer retransmits the query
+		q.plug.stats.errTcp++
+		q.conn = nil
+	}
+}
+
+func (o *dnsTcpConn) OnTxEvent(event transport.SocketEventType) {}
+
+func (o *dnsTcpConn) OnRxData(d []byte) {
+	o.rx = append(o.rx, d...)
+	if len(o.rx) < 2 {
+		return
+	}
+	l := int(binary.BigEndian.Uint16(o.rx[0:2]))
+	if len(o.rx) < 2+l {
+		return
+	}
+	o.query.plug.onTcpAnswer(o, o.rx[2:2+l])
+}
+
+type dnsQuery struct {
+	plug   *PluginDnsClient
+	id     uint16
+	name   string
+	qtype  layers.DNSType
+	msg    []byte
+	server int
+	tries  uint8
+	useTcp bool
+	conn   *dnsTcpConn
+	start  time.Time
+	timer  core.CHTimerObj
+	cb     DnsResultCb
+}
+
+func (o *dnsQuery) match(d *layers.DNS) bool {
+	if !d.QR || len(d.Questions) != 1 {
+		return false
+	}
+	q := &d.Questions[0]
+	return q.Type == o.qtype && canonicalName(string(q.Name)) == o.name
+}
+
+type dnsCacheKey struct {
+	name  string
+	qtype layers.DNSType
+}
+
+type dnsCacheEntry struct {
+	key    dnsCacheKey
+	res    DnsResult
+	ttl    uint32
+	expire uint64 // ticks
+	timer  core.CHTimerObj
+}
+
+type dnsStorm struct {
+	init      *DnsStormInit
+	types     []layers.DNSType
+	engineMgr *engines.FieldEngineManager
+	engine    engines.FieldEngineIF
+	buf       []byte
+	nameIdx   int
+	typeIdx   int
+	ticks     uint32
+	burst     uint32
+	timer     core.CHTimerObj
+	started   bool
+}
+
+type PluginDnsClientTimer struct {
+}
+
+func (o *PluginDnsClientTimer) OnEvent(a, b interface{}) {
+	pi := a.(*PluginDnsClient)
+	switch v := b.(type) {
+	case *dnsQuery:
+		pi.onQueryTimeout(v)
+	case *dnsCacheEntry:
+		pi.onCacheExpire(v)
+	default:
+		pi.onStormTimer()
+	}
+}
+
+// PluginDnsClient the stub resolver of a client
+type PluginDnsClient struct {
+	core.PluginBase
+	dnsNsPlug  *PluginDnsNs
+	init       DnsInit
+	enable     bool
+	servers    []*dnsServer
+	queries    map[uint16]*dnsQuery
+	cache      map[dnsCacheKey]*dnsCacheEntry
+	nextId     uint16
+	storm      dnsStorm
+	latencySum uint64
+	latencyCnt uint64
+	timerw     *core.TimerCtx
+	timerCb    PluginDnsClientTimer
+	stats      DnsStats
+	cdb        *core.CCounterDb
+	cdbv       *core.CCounterDbVec
+	initErr    string
+}
+
+var dnsEvents = []string{core.MSG_DG_MAC_RESOLVED}
+
+/*NewDnsClient create plugin */
+func NewDnsClient(ctx *core.PluginCtx, initJson []byte) *core.PluginBase {
+
+	o := new(PluginDnsClient)
+	o.InitPluginBase(ctx, o)            /* init base object*/
+	o.RegisterEvents(ctx, dnsEvents, o) /* register events, only if exits*/
+	nsplg := o.Ns.PluginCtx.GetOrCreate(DNS_PLUG)
+	o.dnsNsPlug = nsplg.Ext.(*PluginDnsNs)
+	o.OnCreate(initJson)
+
+	return &o.PluginBase
+}
+
+func (o *PluginDnsClient) OnCreate(initJson []byte) {
+	o.timerw = o.Tctx.GetTimerCtx()
+	o.cdb = NewDnsStatsDb(&o.stats)
+	o.cdbv = core.NewCCounterDbVec(DNS_PLUG)
+	o.cdbv.Add(o.cdb)
+	o.queries = make(map[uint16]*dnsQuery)
+	o.cache = make(map[dnsCacheKey]*dnsCacheEntry)
+	o.nextId = uint16(o.Tctx.GetRandNumber(0, 0xffff))
+	o.storm.timer.SetCB(&o.timerCb, o, nil)
+
+	// a field of the client json replaces the one of the namespace, servers as a whole list, while a client storm
+	// is merged field by field into the storm of the namespace. The decoder reuses slices, so they are copied first.
+	o.init = o.dnsNsPlug.init
+	o.init.Servers = append([]string(nil), o.init.Servers...)
+	if ns := o.init.Storm; ns != nil {
+		storm := *ns
+		storm.Names = append([]string(nil), ns.Names...)
+		storm.Types = append([]string(nil), ns.Types...)
+		if ns.Engines != nil {
+			e := append(fastjson.RawMessage(nil), *ns.Engines...)
+			storm.Engines = &e
+		}
+		o.init.Storm = &storm
+	}
+	var err error
+	if len(initJson) > 0 {
+		err = o.Tctx.UnmarshalValidate(initJson, &o.init)
+	}
+	if err == nil {
+		err = o.validate()
+	}
+	if err != nil {
+		o.stats.errInitJson++
+		o.initErr = err.Error()
+		return
+	}
+	// the rx side of the sockets
+	o.Client.PluginCtx.GetOrCreate(transport.TRANS_PLUG)
+	o.enable = true
+	if _, ok := o.Client.ResolveIPv4DGMac(); ok {
+		o.startStorm()
+	} else if _, ok := o.Client.ResolveIPv6DGMac(); ok {
+		o.startStorm()
+	}
+}
+
+func setDnsDefaults(i *DnsInit) {
+	if i.Timeout == 0 {
+		i.Timeout = DNS_DEF_TIMEOUT_MS
+	}
+	if i.Tries == 0 {
+		i.Tries = DNS_DEF_TRIES
+	}
+	if i.CacheSize == 0 {
+		i.CacheSize = DNS_DEF_CACHE_SIZE
+	}
+	if i.MaxTtl == 0 {
+		i.MaxTtl = DNS_DEF_MAX_TTL
+	}
+	if i.NegTtl == 0 {
+		i.NegTtl = DNS_DEF_NEG_TTL
+	}
+}
+
+// parseServer returns the host:port of a server, the port is 53 by default
+func parseServer(s string) (string, error) {
+	if ip := net.ParseIP(s); ip != nil {
+		return net.JoinHostPort(ip.String(), strconv.Itoa(DNS_PORT)), nil
+	}
+	host, port, err := net.SplitHostPort(s)
+	if err != nil {
+		return "", err
+	}
+	if net.ParseIP(host) == nil {
+		return "", fmt.Errorf("invalid dns server %q, should be an ip", s)
+	}
+	if _, err = strconv.ParseUint(port, 10, 16); err != nil {
+		return "", fmt.Errorf("invalid port of dns server %q", s)
+	}
+	return s, nil
+}
+
+func (o *PluginDnsClient) validate() error {
+	i := &o.init
+	setDnsDefaults(i)
+	for _, s := range i.Servers {
+		addr, err := parseServer(s)
+		if err != nil {
+			return err
+		}
+		o.servers = append(o.servers, &dnsServer{plug: o, addr: addr})
+	}
+	if i.Storm == nil {
+		return nil
+	}
+	st := &o.storm
+	st.init = i.Storm
+	if len(o.servers) == 0 {
+		return fmt.Errorf("the storm needs dns servers")
+	}
+	for _, s := range st.init.Types {
+		t, err := parseDnsType(s)
+		if err != nil {
+			return err
+		}
+		st.types = append(st.types, t)
+	}
+	if len(st.types) == 0 {
+		st.types = []layers.DNSType{layers.DNSTypeA}
+	}
+	for _, n := range st.init.Names {
+		if err := checkName(canonicalName(n)); err != nil {
+			return err
+		}
+	}
+	if st.init.Engines != nil {
+		st.engineMgr = engines.NewEngineManager(o.Tctx, st.init.Engines)
+		if !st.engineMgr.WasCreatedSuccessfully() {
+			return fmt.Errorf("failed building the field engines of the storm")
+		}
+		st.engine = st.engineMgr.GetEngineMap()[DNS_STORM_NAME_ENG]
+		if st.engine == nil {
+			return fmt.Errorf("the storm needs the field engine %q", DNS_STORM_NAME_ENG)
+		}
+		st.buf = make([]byte, st.engine.GetSize())
+	} else if len(st.init.Names) == 0 {
+		return fmt.Errorf("the storm needs names or a name engine")
+	}
+	st.ticks, st.burst = o.timerw.DurationToTicksBurst(time.Duration(float32(time.Second) / st.init.Rate))
+	return nil
+}
+
+/*OnEvent the storm starts when the default gateway is resolved */
+func (o *PluginDnsClient) OnEvent(msg string, a, b interface{}) {
+	switch msg {
+	case core.MSG_DG_MAC_RESOLVED:
+		if o.enable {
+			o.startStorm()
+		}
+	}
+}
+
+func (o *PluginDnsClient) GetCounterDbVec() *core.CCounterDbVec {
+	return o.cdbv
+}
+
+func (o *PluginDnsClient) OnRemove(ctx *core.PluginCtx) {
+	ctx.UnregisterEvents(&o.PluginBase, dnsEvents)
+	o.stopTimer(&o.storm.timer)
+	for _, q := range o.queries {
+		o.stopTimer(&q.timer)
+		if q.conn != nil {
+			q.conn.socket.Close()
+		}
+	}
+	o.flushCache()
+	for _, s := range o.servers {
+		if s.socket != nil {
+			s.socket.Close()
+		}
+	}
+}
+
+func (o *PluginDnsClient) stopTimer(t *core.CHTimerObj) {
+	if t.IsRunning() {
+		o.timerw.Stop(t)
+	}
+}
+
+// now is the time of the latency, the simulation advances by the ticks of the timer wheel
+func (o *PluginDnsClient) now() time.Time {
+	if o.Tctx.Simulation {
+		return time.Unix(0, int64(time.Duration(o.timerw.Ticks)*o.timerw.TickDuration))
+	}
+	return time.Now()
+}
+
+func (o *PluginDnsClient) allocId() uint16 {
+	for {
+		o.nextId++
+		if _, ok := o.queries[o.nextId]; !ok {
+			return o.nextId
+		}
+	}
+}
+
+// Query resolves the name, the callback (could be nil) gets the answer at once in case it is cached, otherwise
+// when the query is done. PTR of an IP queries its reverse name.
+func (o *PluginDnsClient) Query(name string, qtype layers.DNSType, cb DnsResultCb) error {
+	return o.query(name, qtype, cb, true)
+}
+
+func (o *PluginDnsClient) query(name string, qtype layers.DNSType, cb DnsResultCb, useCache bool) error {
+	if !o.enable {
+		return fmt.Errorf("%s %s", DNS_ERR_NOT_ENABLED, o.initErr)
+	}
+	if len(o.servers) == 0 {
+		return fmt.Errorf(DNS_ERR_NO_SERVERS)
+	}
+	if ip := net.ParseIP(name); ip != nil && qtype == layers.DNSTypePTR {
+		name = ReverseName(ip)
+	}
+	name = canonicalName(name)
+	if err := checkName(name); err != nil {
+		o.stats.errName++
+		return err
+	}
+	key := dnsCacheKey{name: name, qtype: qtype}
+	if useCache && !o.init.NoCache {
+		if e, ok := o.cache[key]; ok {
+			o.stats.cacheHit++
+			if cb != nil {
+				cb(o.getCachedResult(e))
+			}
+			return nil
+		}
+		o.stats.cacheMiss++
+	}
+	if len(o.queries) >= DNS_MAX_PENDING {
+		o.stats.errPendingFull++
+		return fmt.Errorf("too many pending dns queries")
+	}
+
+	q := &dnsQuery{plug: o, id: o.allocId(), name: name, qtype: qtype, useTcp: o.init.Tcp, cb: cb}
+	var err error
+	q.msg, err = encodeDns(&layers.DNS{ID: q.id, RD: true,
+		Questions: []layers.DNSQuestion{{Name: []byte(name), Type: qtype, Class: layers.DNSClassIN}}})
+	if err != nil {
+		o.stats.errName++
+		return err
+	}
+	q.timer.SetCB(&o.timerCb, o, q)
+	q.start = o.now()
+	o.queries[q.id] = q
+	o.stats.queries++
+	o.send(q)
+	return nil
+}
+
+// send sends the query to the current server and starts the timeout
+func (o *PluginDnsClient) send(q *dnsQuery) {
+	o.stopTimer(&q.timer)
+	o.timerw.Start(&q.timer, time.Duration(o.init.Timeout)*time.Millisecond)
+	s := o.servers[q.server]
+	if q.useTcp {
+		o.dialTcp(q, s)
+		return
+	}
+	if s.socket == nil {
+		socket, err := transport.GetTransportCtx(o.Client).Dial("udp", s.addr, s, nil, nil)
+		if err != nil {
+			o.stats.errSocket++
+			return
+		}
+		s.socket = socket
+	}
+	res, _ := s.socket.Write(q.msg)
+	if res != transport.SeOK {
+		if res == transport.SeUNRESOLVED {
+			o.stats.errUnresolved++
+		} else {
+			o.stats.errTx++
+		}
+		return
+	}
+	o.stats.pktTx++
+}
+
+func (o *PluginDnsClient) closeTcp(q *dnsQuery) {
+	if q.conn != nil {
+		c := q.conn
+		q.conn = nil
+		c.socket.Close()
+	}
+}
+
+func (o *PluginDnsClient) dialTcp(q *dnsQuery, s *dnsServer) {
+	o.closeTcp(q)
+	c := &dnsTcpConn{query: q}
+	socket, err := transport.GetTransportCtx(o.Client).Dial("tcp", s.addr, c, nil, nil)
+	if err != nil {
+		o.stats.errSocket++
+		return
+	}
+	c.socket = socket
+	q.conn = c
+}
+
+func (o *PluginDnsClient) sendTcp(c *dnsTcpConn) {
+	q := c.query
+	if q.conn != c {
+		return
+	}
+	b := make([]byte, 2+len(q.msg))
+	binary.BigEndian.PutUint16(b[0:2], uint16(len(q.msg)))
+	copy(b[2:], q.msg)
+	if res, _ := c.socket.Write(b); res != transport.SeOK {
+		o.stats.errTx++
+		return
+	}
+	o.stats.pktTxTcp++
+}
+
+func (o *PluginDnsClient) onQueryTimeout(q *dnsQuery) {
+	o.closeTcp(q)
+	q.tries++
+	if q.tries >= o.init.Tries {
+		// the next server, from UDP
+		q.server++
+		q.tries = 0
+		q.useTcp = o.init.Tcp
+		if q.server == len(o.servers) {
+			o.stats.queryTimeout++
+			o.done(q, &DnsResult{Name: q.name, Type: q.qtype.String(), Error: DNS_ERR_TIMEOUT})
+			return
+		}
+	}
+	o.stats.pktTxRetransmit++
+	o.send(q)
+}
+
+func (o *PluginDnsClient) onUdpAnswer(s *dnsServer, d []byte) {
+	var dns layers.DNS
+	o.stats.pktRx++
+	if decodeDns(d, &dns) != nil {
+		o.stats.errRxMalformed++
+		return
+	}
+	q, ok := o.queries[dns.ID]
+	if !ok || q.useTcp || o.servers[q.server] != s || !q.match(&dns) {
+		o.stats.errRxUnexpected++
+		return
+	}
+	if dns.TC {
+		o.stats.pktRxTruncated++
+		q.useTcp = true
+		o.send(q)
+		return
+	}
+	o.onAnswer(q, &dns)
+}
+
+func (o *PluginDnsClient) onTcpAnswer(c *dnsTcpConn, d []byte) {
+	q := c.query
+	if q.conn != c {
+		return
+	}
+	o.closeTcp(q)
+	var dns layers.DNS
+	o.stats.pktRxTcp++
+	if decodeDns(d, &dns) != nil {
+		o.stats.errRxMalformed++
+		return
+	}
+	if dns.ID != q.id || !q.match(&dns) {
+		o.stats.errRxUnexpected++
+		return
+	}
+	o.onAnswer(q, &dns)
+}
+
+func (o *PluginDnsClient) onAnswer(q *dnsQuery, dns *layers.DNS) {
+	switch dns.ResponseCode {
+	case layers.DNSResponseCodeNoErr:
+		o.stats.rcodeNoError++
+	case layers.DNSResponseCodeNXDomain:
+		o.stats.rcodeNxDomain++
+	default:
+		switch dns.ResponseCode {
+		case layers.DNSResponseCodeServFail:
+			o.stats.rcodeServFail++
+		case layers.DNSResponseCodeRefused:
+			o.stats.rcodeRefused++
+		default:
+			o.stats.rcodeOther++
+		}
+		if q.server+1 < len(o.servers) {
+			q.server++
+			q.tries = 0
+			q.useTcp = o.init.Tcp
+			o.send(q)
+			return
+		}
+	}
+	o.updateLatency(o.now().Sub(q.start))
+
+	res := &DnsResult{Name: q.name, Type: q.qtype.String(), Rcode: rcodeString(dns.ResponseCode), Records: []DnsRecord{}}
+	ttl := o.init.MaxTtl
+	for i := range dns.Answers {
+		rr := &dns.Answers[i]
+		res.Records = append(res.Records, newDnsRecord(rr))
+		if rr.TTL < ttl {
+			ttl = rr.TTL
+		}
+	}
+	switch {
+	case dns.ResponseCode != layers.DNSResponseCodeNoErr && dns.ResponseCode != layers.DNSResponseCodeNXDomain:
+		ttl = 0
+	case len(dns.Answers) == 0:
+		// negative answer, RFC 2308
+		ttl = o.init.NegTtl
+		for i := range dns.Authorities {
+			if rr := &dns.Authorities[i]; rr.Type == layers.DNSTypeSOA {
+				ttl = rr.TTL
+				if rr.SOA.Minimum < ttl {
+					ttl = rr.SOA.Minimum
+				}
+			}
+		}
+	}
+	o.addCache(dnsCacheKey{name: q.name, qtype: q.qtype}, res, ttl)
+	o.done(q, res)
+}
+
+func (o *PluginDnsClient) updateLatency(d time.Duration) {
+	usec := uint64(d / time.Microsecond)
+	st := &o.stats
+	if o.latencyCnt == 0 || usec < st.latencyMin {
+		st.latencyMin = usec
+	}
+	if usec > st.latencyMax {
+		st.latencyMax = usec
+	}
+	o.latencySum += usec
+	o.latencyCnt++
+	st.latencyAvg = o.latencySum / o.latencyCnt
+	switch {
+	case d < time.Millisecond:
+		st.latencyUnder1ms++
+	case d < 10*time.Millisecond:
+		st.latencyUnder10ms++
+	case d < 100*time.Millisecond:
+		st.latencyUnder100ms++
+	case d < time.Second:
+		st.latencyUnder1s++
+	default:
+		st.latencyOver1s++
+	}
+}
+
+func (o *PluginDnsClient) done(q *dnsQuery, res *DnsResult) {
+	o.stopTimer(&q.timer)
+	o.closeTcp(q)
+	delete(o.queries, q.id)
+	if q.cb != nil {
+		q.cb(res)
+	}
+}
+
+func (o *PluginDnsClient) addCache(key dnsCacheKey, res *DnsResult, ttl uint32) {
+	if o.init.NoCache || ttl == 0 {
+		return
+	}
+	if ttl > o.init.MaxTtl {
+		ttl = o.init.MaxTtl
+	}
+	if e, ok := o.cache[key]; ok {
+		o.stopTimer(&e.timer)
+		delete(o.cache, key)
+	}
+	if uint32(len(o.cache)) >= o.init.CacheSize {
+		o.stats.cacheFull++
+		return
+	}
+	e := &dnsCacheEntry{key: key, res: *res, ttl: ttl}
+	ticks := o.timerw.DurationToTicks(time.Duration(ttl) * time.Second)
+	e.expire = o.timerw.Ticks + uint64(ticks)
+	e.timer.SetCB(&o.timerCb, o, e)
+	o.timerw.StartTicks(&e.timer, ticks)
+	o.cache[key] = e
+	o.stats.cacheAdd++
+}
+
+func (o *PluginDnsClient) onCacheExpire(e *dnsCacheEntry) {
+	delete(o.cache, e.key)
+	o.stats.cacheExpired++
+}
+
+func (o *PluginDnsClient) flushCache() {
+	for k, e := range o.cache {
+		o.stopTimer(&e.timer)
+		delete(o.cache, k)
+	}
+}
+
+// remaining returns the remaining TTL of the entry in sec
+func (o *PluginDnsClient) remaining(e *dnsCacheEntry) uint32 {
+	if e.expire <= o.timerw.Ticks {
+		return 0
+	}
+	return uint32(time.Duration(e.expire-o.timerw.Ticks) * o.timerw.TickDuration / time.Second)
+}
+
+// getCachedResult returns the answer of the entry with the TTL of the records aged
+func (o *PluginDnsClient) getCachedResult(e *dnsCacheEntry) *DnsResult {
+	res := e.res
+	res.Cached = true
+	res.Records = make([]DnsRecord, len(e.res.Records))
+	aged := e.ttl - o.remaining(e)
+	for i, r := range e.res.Records {
+		if r.Ttl > aged {
+			r.Ttl -= aged
+		} else {
+			r.Ttl = 0
+		}
+		res.Records[i] = r
+	}
+	return &res
+}
+
+func (o *PluginDnsClient) getCacheInfo() []DnsCacheInfo {
+	info := make([]DnsCacheInfo, 0, len(o.cache))
+	for _, e := range o.cache {
+		info = append(info, DnsCacheInfo{DnsResult: *o.getCachedResult(e), Remaining: o.remaining(e)})
+	}
+	sort.Slice(info, func(i, j int) bool {
+		if info[i].Name != info[j].Name {
+			return info[i].Name < info[j].Name
+		}
+		return info[i].Type < info[j].Type
+	})
+	return info
+}
+
+func (o *PluginDnsClient) startStorm() {
+	st := &o.storm
+	if st.init == nil || st.started {
+		return
+	}
+	st.started = true
+	o.timerw.StartTicks(&st.timer, st.ticks)
+}
+
+func (o *PluginDnsClient) stormName() (string, bool) {
+	st := &o.storm
+	if st.engine == nil {
+		n := st.init.Names[st.nameIdx]
+		st.nameIdx = (st.nameIdx + 1) % len(st.init.Names)
+		return n, true
+	}
+	l, err := st.engine.Update(st.buf)
+	if err != nil {
+		return "", false
+	}
+	// the strings of the engine are padded by zeros
+	return string(bytes.TrimRight(st.buf[:l], "\x00")), true
+}
+
+func (o *PluginDnsClient) onStormTimer() {
+	st := &o.storm
+	for i := uint32(0); i < st.burst; i++ {
+		if st.init.Count > 0 && o.stats.stormQueries >= st.init.Count {
+			return
+		}
+		name, ok := o.stormName()
+		if !ok {
+			o.stats.errStormName++
+			continue
+		}
+		qtype := st.types[st.typeIdx]
+		st.typeIdx = (st.typeIdx + 1) % len(st.types)
+		o.stats.stormQueries++
+		o.query(name, qtype, nil, st.init.Cache)
+	}
+	o.timerw.StartTicks(&st.timer, st.ticks)
+}
+
+// ResolveAddr resolves the host of a host:port address by an A (or AAAA for ipv6) query of the dns plugin of
+// the client. The callback gets a literal address at once.
+func ResolveAddr(c *core.CClient, address string, ipv6 bool, cb func(addr string, err error)) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		cb("", err)
+		return
+	}
+	if net.ParseIP(host) != nil {
+		cb(address, nil)
+		return
+	}
+	plug := c.PluginCtx.Get(DNS_PLUG)
+	if plug == nil {
+		cb("", fmt.Errorf("client %v has no dns plugin", c.Mac))
+		return
+	}
+	qtype := layers.DNSTypeA
+	if ipv6 {
+		qtype = layers.DNSTypeAAAA
+	}
+	err = plug.Ext.(*PluginDnsClient).Query(host, qtype, func(res *DnsResult) {
+		for _, r := range res.Records {
+			if r.Type == qtype.String() {
+				cb(net.JoinHostPort(r.Data, port), nil)
+				return
+			}
+		}
+		reason := res.Error
+		if reason == "" {
+			reason = res.Rcode
+		}
+		cb("", fmt.Errorf("can't resolve %s %s: %s", host, qtype, reason))
+	})
+	if err != nil {
+		cb("", err)
+	}
+}
+
+type DnsNsStats struct {
+	errInitJson uint64
+}
+
+func NewDnsNsStatsDb(o *DnsNsStats) *core.CCounterDb {
+	db := core.NewCCounterDb(DNS_PLUG)
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.errInitJson,
+		Name:     "errInitJson",
+		Help:     "invalid init json of the namespace defaults",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	return db
+}
+
+// PluginDnsNs the defaults of the resolvers of the namespace
+type PluginDnsNs struct {
+	core.PluginBase
+	init  DnsInit
+	stats DnsNsStats
+	cdb   *core.CCounterDb
+	cdbv  *core.CCounterDbVec
+}
+
+func NewDnsNs(ctx *core.PluginCtx, initJson []byte) *core.PluginBase {
+	o := new(PluginDnsNs)
+	o.InitPluginBase(ctx, o)
+	o.RegisterEvents(ctx, []string{}, o)
+	o.cdb = NewDnsNsStatsDb(&o.stats)
+	o.cdbv = core.NewCCounterDbVec(DNS_PLUG)
+	o.cdbv.Add(o.cdb)
+	if len(initJson) > 0 {
+		if err := o.Tctx.UnmarshalValidate(initJson, &o.init); err != nil {
+			o.stats.errInitJson++
+			o.init = DnsInit{}
+		}
+	}
+	return &o.PluginBase
+}
+
+func (o *PluginDnsNs) OnRemove(ctx *core.PluginCtx) {
+}
+
+func (o *PluginDnsNs) OnEvent(msg string, a, b interface{}) {
+}
+
+func (o *PluginDnsNs) GetCounterDbVec() *core.CCounterDbVec {
+	return o.cdbv
+}
+
+type PluginDnsCReg struct{}
+type PluginDnsNsReg struct{}
+
+func (o PluginDnsCReg) NewPlugin(ctx *core.PluginCtx, initJson []byte) *core.PluginBase {
+	return NewDnsClient(ctx, initJson)
+}
+
+func (o PluginDnsNsReg) NewPlugin(ctx *core.PluginCtx, initJson []byte) *core.PluginBase {
+	return NewDnsNs(ctx, initJson)
+}
+
+/*******************************************/
+/*  RPC commands */
+type (
+	ApiDnsClientCntHandler   struct{}
+	ApiDnsClientCacheHandler struct{}
+	ApiDnsClientFlushHandler struct{}
+	ApiDnsClientQueryHandler struct{}
+	ApiDnsNsCntHandler       struct{}
+
+	ApiDnsClientQueryParams struct {
+		Name  string `json:"name" validate:"required"`
+		Type  string `json:"type"`
+		Cache *bool  `json:"cache"`
+	}
+)
+
+func getDnsClient(ctx interface{}, params *fastjson.RawMessage) (*PluginDnsClient, *jsonrpc.Error) {
+	tctx := ctx.(*core.CThreadCtx)
+	plug, err := tctx.GetClientPlugin(params, DNS_PLUG)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+	return plug.Ext.(*PluginDnsClient), nil
+}
+
+func (h ApiDnsClientCntHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	var p core.ApiCntParams
+	tctx := ctx.(*core.CThreadCtx)
+	c, err := getDnsClient(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	return c.cdbv.GeneralCounters(err, tctx, params, &p)
+}
+
+func (h ApiDnsClientCacheHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	c, err := getDnsClient(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	return c.getCacheInfo(), nil
+}
+
+func (h ApiDnsClientFlushHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	c, err := getDnsClient(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	c.flushCache()
+	return nil, nil
+}
+
+// ServeJSONRPC for ApiDnsClientQueryHandler sends a query, returns the answer in case it is cached, otherwise
+// the answer is in the cache (dns_client_cache) once it arrives
+func (h ApiDnsClientQueryHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	tctx := ctx.(*core.CThreadCtx)
+	c, err := getDnsClient(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	p := ApiDnsClientQueryParams{Type: "A"}
+	err1 := tctx.UnmarshalValidate(*params, &p)
+	var qtype layers.DNSType
+	if err1 == nil {
+		qtype, err1 = parseDnsType(p.Type)
+	}
+	if err1 != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err1.Error(),
+		}
+	}
+	var res *DnsResult
+	err1 = c.query(p.Name, qtype, func(r *DnsResult) { res = r }, p.Cache == nil || *p.Cache)
+	if err1 != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err1.Error(),
+		}
+	}
+	return res, nil
+}
+
+func (h ApiDnsNsCntHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	var p core.ApiCntParams
+	tctx := ctx.(*core.CThreadCtx)
+	plug, err := tctx.GetNsPlugin(params, DNS_PLUG)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+	return plug.Ext.(*PluginDnsNs).cdbv.GeneralCounters(nil, tctx, params, &p)
+}
+
+func init() {
+
+	/* register of plugins callbacks for ns,c level  */
+	core.PluginRegister(DNS_PLUG,
+		core.PluginRegisterData{Client: PluginDnsCReg{},
+			Ns:     PluginDnsNsReg{},
+			Thread: nil}) /* no need for thread context for now */
+
+	core.RegisterCB("dns_client_cnt", ApiDnsClientCntHandler{}, false)     // get counters/meta
+	core.RegisterCB("dns_client_cache", ApiDnsClientCacheHandler{}, false) // cached answers
+	core.RegisterCB("dns_client_flush", ApiDnsClientFlushHandler{}, false) // flush the cache
+	core.RegisterCB("dns_client_query", ApiDnsClientQueryHandler{}, false) // send a query
+	core.RegisterCB("dns_ns_cnt", ApiDnsNsCntHandler{}, false)             // get counters/meta
+
+	/* the rx side is the transport plugin*/
+}
+
+func Register(ctx *core.CThreadCtx) {
+	ctx.RegisterParserCb(MDNS_PLUG)
+}
@@ -0,0 +1,873 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package dns
+
+/*
+Authoritative DNS server, client plugin
+
+The client listens on UDP and TCP port 53 of the transport layer and answers from the records of the zone. A CNAME is
+followed inside the zone, a name out of the origin is REFUSED. In case origin is set, the negative answers (NXDOMAIN,
+NODATA) carry its SOA with the minimum of neg_ttl. An UDP answer bigger than 512 bytes is truncated (TC).
+
+drop_rate, nxdomain_rate and servfail_rate inject failures, delay holds the answers for msec.
+
+The namespace init json is the default of the clients, the client init json overrides it.
+
+client init json {
+	"origin": "example.com",
+	"ttl": 300,
+	"neg_ttl": 60,
+	"zone": [
+		{"name": "www.example.com", "type": "CNAME", "data": "web.example.com"},
+		{"name": "web.example.com", "type": "A", "ttl": 10, "data": "48.0.0.1"},
+		{"name": "web.example.com", "type": "AAAA", "data": "2001:db8::1"},
+		{"name": "example.com", "type": "MX", "data": "10 mail.example.com"},
+		{"name": "example.com", "type": "TXT", "data": "v=spf1 -all"},
+		{"name": "_sip._udp.example.com", "type": "SRV", "data": "10 5 5060 sip.example.com"}
+	],
+	"delay": 0,
+	"drop_rate": 0.0,
+	"nxdomain_rate": 0.0,
+	"servfail_rate": 0.0,
+	"no_tcp": false
+}
+
+ttl is the TTL of the records without one. The queries are counted per name and type, see dns_srv_client_queries.
+
+*/
+
+import (
+	"emu/core"
+	"emu/plugins/transport"
+	"encoding/binary"
+	"external/google/gopacket/layers"
+	"external/osamingo/jsonrpc"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/intel-go/fastjson"
+)
+
+const (
+	DNS_SRV_PLUG            = "dns_srv"
+	DNS_SRV_DEF_TTL         = 300
+	DNS_SRV_DEF_NEG_TTL     = 60
+	DNS_SRV_MAX_PENDING     = 4096
+	DNS_SRV_MAX_QUERY_TABLE = 4096
+	DNS_SRV_MAX_CNAME       = 8
+)
+
+type DnsSrvInit struct {
+	Origin       string      `json:"origin"`
+	Ttl          uint32      `json:"ttl"`
+	NegTtl       uint32      `json:"neg_ttl"`
+	Zone         []DnsRecord `json:"zone"`
+	Delay        uint32      `json:"delay"` // msec
+	DropRate     float32     `json:"drop_rate" validate:"gte=0,lte=1"`
+	NxDomainRate float32     `json:"nxdomain_rate" validate:"gte=0,lte=1"`
+	ServFailRate float32     `json:"servfail_rate" validate:"gte=0,lte=1"`
+	NoTcp        bool        `json:"no_tcp"`
+}
+
+// DnsSrvQueryInfo is the number of queries of a name and type
+type DnsSrvQueryInfo struct {
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Queries uint64 `json:"queries"`
+}
+
+type DnsSrvStats struct {
+	pktRx            uint64
+	pktRxTcp         uint64
+	pktTx            uint64
+	pktTxTcp         uint64
+	pktTxTruncated   uint64
+	rcodeNoError     uint64
+	rcodeNxDomain    uint64
+	rcodeServFail    uint64
+	rcodeRefused     uint64
+	rcodeOther       uint64
+	noData           uint64
+	delayed          uint64
+	dropInjected     uint64
+	nxDomainInjected uint64
+	servFailInjected uint64
+	errInitJson      uint64
+	errListen        uint64
+	errRxMalformed   uint64
+	errRxUnexpected  uint64
+	errTx            uint64
+	errConnClosed    uint64
+	errPendingFull   uint64
+	errQueryTable    uint64
+}
+
+func NewDnsSrvStatsDb(o *DnsSrvStats) *core.CCounterDb {
+	db := core.NewCCounterDb(DNS_SRV_PLUG)
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRx,
+		Name:     "pktRx",
+		Help:     "rx queries over UDP",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxTcp,
+		Name:     "pktRxTcp",
+		Help:     "rx queries over TCP",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTx,
+		Name:     "pktTx",
+		Help:     "tx answers over UDP",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTxTcp,
+		Name:     "pktTxTcp",
+		Help:     "tx answers over TCP",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTxTruncated,
+		Name:     "pktTxTruncated",
+		Help:     "tx truncated UDP answers",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.rcodeNoError,
+		Name:     "rcodeNoError",
+		Help:     "answers with NOERROR",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.rcodeNxDomain,
+		Name:     "rcodeNxDomain",
+		Help:     "answers with NXDOMAIN",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.rcodeServFail,
+		Name:     "rcodeServFail",
+		Help:     "answers with SERVFAIL",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.rcodeRefused,
+		Name:     "rcodeRefused",
+		Help:     "answers with REFUSED, the name is out of the origin",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.rcodeOther,
+		Name:     "rcodeOther",
+		Help:     "answers with FORMERR or NOTIMP",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.noData,
+		Name:     "noData",
+		Help:     "NOERROR answers without records of the type",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.delayed,
+		Name:     "delayed",
+		Help:     "answers held for the delay",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.dropInjected,
+		Name:     "dropInjected",
+		Help:     "queries dropped by the drop rate",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.nxDomainInjected,
+		Name:     "nxDomainInjected",
+		Help:     "NXDOMAIN answers of the nxdomain rate",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.servFailInjected,
+		Name:     "servFailInjected",
+		Help:     "SERVFAIL answers of the servfail rate",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.errInitJson,
+		Name:     "errInitJson",
+		Help:     "invalid init json",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.errListen,
+		Name:     "errListen",
+		Help:     "can't listen on the dns port",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.errRxMalformed,
+		Name:     "errRxMalformed",
+		Help:     "rx malformed queries",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.errRxUnexpected,
+		Name:     "errRxUnexpected",
+		Help:     "rx answers instead of queries",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.errTx,
+		Name:     "errTx",
+		Help:     "answer not sent by the socket",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.errConnClosed,
+		Name:     "errConnClosed",
+		Help:     "delayed answer not sent, the connection is closed",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.errPendingFull,
+		Name:     "errPendingFull",
+		Help:     "answer dropped, too many delayed answers",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.errQueryTable,
+		Name:     "errQueryTable",
+		Help:     "query not counted per name, the table is full",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	return db
+}
+
+// dnsSrvConn is a flow of a querier, UDP flows are closed once answered
+type dnsSrvConn struct {
+	plug         *PluginDnsSrvClient
+	socket       transport.SocketApi
+	tcp          bool
+	rx           []byte
+	pending      int
+	remoteClosed bool
+	closed       bool
+}
+
+func (o *dnsSrvConn) OnRxEvent(event transport.SocketEventType) {
+	if event&transport.SocketRemoteDisconnect > 0 {
+		o.remoteClosed = true
+		o.plug.release(o)
+	}
+	if event&transport.SocketClosed > 0 {
+		o.closed = true
+		delete(o.plug.conns, o)
+	}
+}
+
+func (o *dnsSrvConn) OnTxEvent(event transport.SocketEventType) {}
+
+func (o *dnsSrvConn) OnRxData(d []byte) {
+	if !o.tcp {
+		o.plug.onQuery(o, d)
+		return
+	}
+	// the messages are prefixed by a 2 bytes length
+	o.rx = append(o.rx, d...)
+	for len(o.rx) >= 2 {
+		l := int(binary.BigEndian.Uint16(o.rx[0:2]))
+		if len(o.rx) < 2+l {
+			return
+		}
+		msg := o.rx[2 : 2+l]
+		o.rx = o.rx[2+l:]
+		o.plug.onQuery(o, msg)
+	}
+}
+
+// dnsSrvAnswer is an answer held for the delay
+type dnsSrvAnswer struct {
+	conn  *dnsSrvConn
+	msg   []byte
+	timer core.CHTimerObj
+}
+
+type PluginDnsSrvClientTimer struct {
+}
+
+func (o *PluginDnsSrvClientTimer) OnEvent(a, b interface{}) {
+	pi := a.(*PluginDnsSrvClient)
+	pi.onDelayed(b.(*dnsSrvAnswer))
+}
+
+// PluginDnsSrvClient the authoritative server of a client
+type PluginDnsSrvClient struct {
+	core.PluginBase
+	dnsSrvNsPlug *PluginDnsSrvNs
+	init         DnsSrvInit
+	enable       bool
+	zone         map[string]map[layers.DNSType][]layers.DNSResourceRecord
+	soa          *layers.DNSResourceRecord
+	conns        map[*dnsSrvConn]bool
+	pending      map[*dnsSrvAnswer]bool
+	queries      map[dnsCacheKey]uint64
+	listen       []string
+	timerw       *core.TimerCtx
+	timerCb      PluginDnsSrvClientTimer
+	stats        DnsSrvStats
+	cdb          *core.CCounterDb
+	cdbv         *core.CCounterDbVec
+	initErr      string
+}
+
+/*NewDnsSrvClient create plugin */
+func NewDnsSrvClient(ctx *core.PluginCtx, initJson []byte) *core.PluginBase {
+
+	o := new(PluginDnsSrvClient)
+	o.InitPluginBase(ctx, o)             /* init base object*/
+	o.RegisterEvents(ctx, []string{}, o) /* register events, only if exits*/
+	nsplg := o.Ns.PluginCtx.GetOrCreate(DNS_SRV_PLUG)
+	o.dnsSrvNsPlug = nsplg.Ext.(*PluginDnsSrvNs)
+	o.OnCreate(initJson)
+
+	return &o.PluginBase
+}
+
+func (o *PluginDnsSrvClient) OnCreate(initJson []byte) {
+	o.timerw = o.Tctx.GetTimerCtx()
+	o.cdb = NewDnsSrvStatsDb(&o.stats)
+	o.cdbv = core.NewCCounterDbVec(DNS_SRV_PLUG)
+	o.cdbv.Add(o.cdb)
+	o.zone = make(map[string]map[layers.DNSType][]layers.DNSResourceRecord)
+	o.conns = make(map[*dnsSrvConn]bool)
+	o.pending = make(map[*dnsSrvAnswer]bool)
+	o.queries = make(map[dnsCacheKey]uint64)
+
+	// a field of the client json replaces the one of the namespace, e.g. a zone of the client is not merged.
+	// The zone is decoded into a new slice, otherwise the records of the namespace are reused.
+	o.init = o.dnsSrvNsPlug.init
+	o.init.Zone = nil
+	var err error
+	if len(initJson) > 0 {
+		err = o.Tctx.UnmarshalValidate(initJson, &o.init)
+	}
+	if o.init.Zone == nil {
+		// validate sets the ttl of the records
+		o.init.Zone = append([]DnsRecord(nil), o.dnsSrvNsPlug.init.Zone...)
+	}
+	if err == nil {
+		err = o.validate()
+	}
+	if err != nil {
+		o.stats.errInitJson++
+		o.initErr = err.Error()
+		return
+	}
+
+	o.Client.PluginCtx.GetOrCreate(transport.TRANS_PLUG)
+	ctx := transport.GetTransportCtx(o.Client)
+	networks := []string{"udp", "tcp"}
+	if o.init.NoTcp {
+		networks = networks[:1]
+	}
+	for _, n := range networks {
+		if err = ctx.Listen(n, ":"+strconv.Itoa(DNS_PORT), o); err != nil {
+			o.stats.errListen++
+			o.initErr = err.Error()
+			o.unlisten()
+			return
+		}
+		o.listen = append(o.listen, n)
+	}
+	o.enable = true
+}
+
+// inZone returns true in case the server is authoritative for the name
+func (o *PluginDnsSrvClient) inZone(name string) bool {
+	origin := o.init.Origin
+	return origin == "" || name == origin || strings.HasSuffix(name, "."+origin)
+}
+
+func (o *PluginDnsSrvClient) addRecord(rr *layers.DNSResourceRecord) {
+	name := string(rr.Name)
+	rrs, ok := o.zone[name]
+	if !ok {
+		rrs = make(map[layers.DNSType][]layers.DNSResourceRecord)
+		o.zone[name] = rrs
+	}
+	rrs[rr.Type] = append(rrs[rr.Type], *rr)
+}
+
+func (o *PluginDnsSrvClient) validate() error {
+	i := &o.init
+	if i.Ttl == 0 {
+		i.Ttl = DNS_SRV_DEF_TTL
+	}
+	if i.NegTtl == 0 {
+		i.NegTtl = DNS_SRV_DEF_NEG_TTL
+	}
+	if i.Origin != "" {
+		i.Origin = canonicalName(i.Origin)
+		if err := checkName(i.Origin); err != nil {
+			return err
+		}
+	}
+	for k := range i.Zone {
+		r := &i.Zone[k]
+		if r.Ttl == 0 {
+			r.Ttl = i.Ttl
+		}
+		rr, err := newResourceRecord(r)
+		if err != nil {
+			return err
+		}
+		if !o.inZone(string(rr.Name)) {
+			return fmt.Errorf("record %q is out of the origin %q", r.Name, i.Origin)
+		}
+		o.addRecord(&rr)
+	}
+	for name, rrs := range o.zone {
+		if _, ok := rrs[layers.DNSTypeCNAME]; ok && (len(rrs) > 1 || len(rrs[layers.DNSTypeCNAME]) > 1) {
+			return fmt.Errorf("CNAME %q and other records", name)
+		}
+	}
+	if i.Origin != "" {
+		o.soa = &layers.DNSResourceRecord{Name: []byte(i.Origin), Type: layers.DNSTypeSOA, Class: layers.DNSClassIN,
+			TTL: i.Ttl, SOA: layers.DNSSOA{MName: []byte("ns." + i.Origin), RName: []byte("hostmaster." + i.Origin),
+				Serial: 1, Refresh: 3600, Retry: 600, Expire: 86400, Minimum: i.NegTtl}}
+		o.addRecord(o.soa)
+	}
+	return nil
+}
+
+func (o *PluginDnsSrvClient) OnEvent(msg string, a, b interface{}) {
+}
+
+func (o *PluginDnsSrvClient) GetCounterDbVec() *core.CCounterDbVec {
+	return o.cdbv
+}
+
+func (o *PluginDnsSrvClient) unlisten() {
+	if len(o.listen) == 0 {
+		return
+	}
+	ctx := transport.GetTransportCtx(o.Client)
+	for _, n := range o.listen {
+		ctx.UnListen(n, ":"+strconv.Itoa(DNS_PORT), o)
+	}
+	o.listen = nil
+}
+
+func (o *PluginDnsSrvClient) OnRemove(ctx *core.PluginCtx) {
+	for p := range o.pending {
+		if p.timer.IsRunning() {
+			o.timerw.Stop(&p.timer)
+		}
+	}
+	o.pending = nil
+	for c := range o.conns {
+		c.socket.Close()
+	}
+	o.unlisten()
+}
+
+// OnAccept is called by the transport layer for a new flow of a querier
+func (o *PluginDnsSrvClient) OnAccept(socket transport.SocketApi) transport.ISocketCb {
+	if !o.enable {
+		return nil
+	}
+	c := &dnsSrvConn{plug: o, socket: socket, tcp: socket.GetCap()&transport.SocketCapStream != 0}
+	o.conns[c] = true
+	return c
+}
+
+// release closes a UDP flow, or a TCP connection closed by the querier, once the answers are sent
+func (o *PluginDnsSrvClient) release(c *dnsSrvConn) {
+	if c.pending > 0 || c.closed || (c.tcp && !c.remoteClosed) {
+		return
+	}
+	c.closed = true
+	c.socket.Close()
+	delete(o.conns, c)
+}
+
+func (o *PluginDnsSrvClient) countQuery(name string, qtype layers.DNSType) {
+	k := dnsCacheKey{name: name, qtype: qtype}
+	if _, ok := o.queries[k]; !ok && len(o.queries) >= DNS_SRV_MAX_QUERY_TABLE {
+		o.stats.errQueryTable++
+		return
+	}
+	o.queries[k]++
+}
+
+func (o *PluginDnsSrvClient) countRcode(a *layers.DNS) {
+	switch a.ResponseCode {
+	case layers.DNSResponseCodeNoErr:
+		o.stats.rcodeNoError++
+		if len(a.Answers) == 0 {
+			o.stats.noData++
+		}
+	case layers.DNSResponseCodeNXDomain:
+		o.stats.rcodeNxDomain++
+	case layers.DNSResponseCodeServFail:
+		o.stats.rcodeServFail++
+	case layers.DNSResponseCodeRefused:
+		o.stats.rcodeRefused++
+	default:
+		o.stats.rcodeOther++
+	}
+}
+
+func (o *PluginDnsSrvClient) negative(a *layers.DNS, rcode layers.DNSResponseCode) {
+	a.ResponseCode = rcode
+	if o.soa != nil {
+		a.Authorities = append(a.Authorities, *o.soa)
+	}
+}
+
+// lookup fills the answer of the question, the CNAMEs are followed inside the zone
+func (o *PluginDnsSrvClient) lookup(a *layers.DNS, name string, q *layers.DNSQuestion) {
+	if (q.Class != layers.DNSClassIN && q.Class != layers.DNSClassAny) || !o.inZone(name) {
+		a.ResponseCode = layers.DNSResponseCodeRefused
+		return
+	}
+	a.AA = true
+	for i := 0; i < DNS_SRV_MAX_CNAME; i++ {
+		rrs, ok := o.zone[name]
+		if !ok {
+			o.negative(a, layers.DNSResponseCodeNXDomain)
+			return
+		}
+		if rr := rrs[q.Type]; len(rr) > 0 {
+			a.Answers = append(a.Answers, rr...)
+			return
+		}
+		cname := rrs[layers.DNSTypeCNAME]
+		if len(cname) == 0 {
+			break
+		}
+		a.Answers = append(a.Answers, cname...)
+		name = string(cname[0].CNAME)
+		if !o.inZone(name) {
+			// the resolver follows the target
+			return
+		}
+	}
+	o.negative(a, layers.DNSResponseCodeNoErr)
+}
+
+// answer returns the answer of the query, nil in case it is dropped
+func (o *PluginDnsSrvClient) answer(q *layers.DNS) *layers.DNS {
+	a := &layers.DNS{ID: q.ID, QR: true, OpCode: q.OpCode, RD: q.RD, Questions: q.Questions}
+	switch {
+	case q.OpCode != layers.DNSOpCodeQuery:
+		a.ResponseCode = layers.DNSResponseCodeNotImp
+	case len(q.Questions) != 1:
+		a.ResponseCode = layers.DNSResponseCodeFormErr
+	default:
+		qu := &q.Questions[0]
+		name := canonicalName(string(qu.Name))
+		o.countQuery(name, qu.Type)
+		i := &o.init
+		switch {
+		case i.DropRate > 0 && rand.Float32() < i.DropRate:
+			o.stats.dropInjected++
+			return nil
+		case i.ServFailRate > 0 && rand.Float32() < i.ServFailRate:
+			o.stats.servFailInjected++
+			a.ResponseCode = layers.DNSResponseCodeServFail
+		case i.NxDomainRate > 0 && rand.Float32() < i.NxDomainRate:
+			o.stats.nxDomainInjected++
+			a.AA = true
+			o.negative(a, layers.DNSResponseCodeNXDomain)
+		default:
+			o.lookup(a, name, qu)
+		}
+	}
+	o.countRcode(a)
+	return a
+}
+
+func (o *PluginDnsSrvClient) onQuery(c *dnsSrvConn, d []byte) {
+	if c.tcp {
+		o.stats.pktRxTcp++
+	} else {
+		o.stats.pktRx++
+	}
+	var q layers.DNS
+	if decodeDns(d, &q) != nil {
+		o.stats.errRxMalformed++
+		o.release(c)
+		return
+	}
+	if q.QR {
+		o.stats.errRxUnexpected++
+		o.release(c)
+		return
+	}
+	a := o.answer(&q)
+	if a == nil {
+		o.release(c)
+		return
+	}
+	b, err := encodeDns(a)
+	if err == nil && !c.tcp && len(b) > DNS_MAX_UDP_SIZE {
+		// the querier should retry over TCP
+		a.TC = true
+		a.Answers, a.Authorities, a.Additionals = nil, nil, nil
+		o.stats.pktTxTruncated++
+		b, err = encodeDns(a)
+	}
+	if err != nil {
+		o.stats.errTx++
+		o.release(c)
+		return
+	}
+	if o.init.Delay == 0 {
+		o.send(c, b)
+		o.release(c)
+		return
+	}
+	if len(o.pending) >= DNS_SRV_MAX_PENDING {
+		o.stats.errPendingFull++
+		o.release(c)
+		return
+	}
+	p := &dnsSrvAnswer{conn: c, msg: b}
+	p.timer.SetCB(&o.timerCb, o, p)
+	o.pending[p] = true
+	c.pending++
+	o.stats.delayed++
+	o.timerw.Start(&p.timer, time.Duration(o.init.Delay)*time.Millisecond)
+}
+
+func (o *PluginDnsSrvClient) onDelayed(p *dnsSrvAnswer) {
+	delete(o.pending, p)
+	c := p.conn
+	c.pending--
+	if c.closed {
+		o.stats.errConnClosed++
+		return
+	}
+	o.send(c, p.msg)
+	o.release(c)
+}
+
+func (o *PluginDnsSrvClient) send(c *dnsSrvConn, b []byte) {
+	if c.tcp {
+		l := make([]byte, 2, 2+len(b))
+		binary.BigEndian.PutUint16(l, uint16(len(b)))
+		b = append(l, b...)
+	}
+	if res, _ := c.socket.Write(b); res != transport.SeOK {
+		o.stats.errTx++
+		return
+	}
+	if c.tcp {
+		o.stats.pktTxTcp++
+	} else {
+		o.stats.pktTx++
+	}
+}
+
+func (o *PluginDnsSrvClient) getQueryInfo() []DnsSrvQueryInfo {
+	info := make([]DnsSrvQueryInfo, 0, len(o.queries))
+	for k, v := range o.queries {
+		t := k.qtype.String()
+		if _, ok := dnsTypes[t]; !ok {
+			t = fmt.Sprintf("TYPE%d", k.qtype)
+		}
+		info = append(info, DnsSrvQueryInfo{Name: k.name, Type: t, Queries: v})
+	}
+	sort.Slice(info, func(i, j int) bool {
+		if info[i].Name != info[j].Name {
+			return info[i].Name < info[j].Name
+		}
+		return info[i].Type < info[j].Type
+	})
+	return info
+}
+
+type DnsSrvNsStats struct {
+	errInitJson uint64
+}
+
+func NewDnsSrvNsStatsDb(o *DnsSrvNsStats) *core.CCounterDb {
+	db := core.NewCCounterDb(DNS_SRV_PLUG)
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.errInitJson,
+		Name:     "errInitJson",
+		Help:     "invalid init json of the namespace defaults",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	return db
+}
+
+// PluginDnsSrvNs the defaults of the servers of the namespace
+type PluginDnsSrvNs struct {
+	core.PluginBase
+	init  DnsSrvInit
+	stats DnsSrvNsStats
+	cdb   *core.CCounterDb
+	cdbv  *core.CCounterDbVec
+}
+
+func NewDnsSrvNs(ctx *core.PluginCtx, initJson []byte) *core.PluginBase {
+	o := new(PluginDnsSrvNs)
+	o.InitPluginBase(ctx, o)
+	o.RegisterEvents(ctx, []string{}, o)
+	o.cdb = NewDnsSrvNsStatsDb(&o.stats)
+	o.cdbv = core.NewCCounterDbVec(DNS_SRV_PLUG)
+	o.cdbv.Add(o.cdb)
+	if len(initJson) > 0 {
+		if err := o.Tctx.UnmarshalValidate(initJson, &o.init); err != nil {
+			o.stats.errInitJson++
+			o.init = DnsSrvInit{}
+		}
+	}
+	return &o.PluginBase
+}
+
+func (o *PluginDnsSrvNs) OnRemove(ctx *core.PluginCtx) {
+}
+
+func (o *PluginDnsSrvNs) OnEvent(msg string, a, b interface{}) {
+}
+
+func (o *PluginDnsSrvNs) GetCounterDbVec() *core.CCounterDbVec {
+	return o.cdbv
+}
+
+type PluginDnsSrvCReg struct{}
+type PluginDnsSrvNsReg struct{}
+
+func (o PluginDnsSrvCReg) NewPlugin(ctx *core.PluginCtx, initJson []byte) *core.PluginBase {
+	return NewDnsSrvClient(ctx, initJson)
+}
+
+func (o PluginDnsSrvNsReg) NewPlugin(ctx *core.PluginCtx, initJson []byte) *core.PluginBase {
+	return NewDnsSrvNs(ctx, initJson)
+}
+
+/*******************************************/
+/*  RPC commands */
+type (
+	ApiDnsSrvClientCntHandler     struct{}
+	ApiDnsSrvClientQueriesHandler struct{}
+	ApiDnsSrvNsCntHandler         struct{}
+)
+
+func getDnsSrvClient(ctx interface{}, params *fastjson.RawMessage) (*PluginDnsSrvClient, *jsonrpc.Error) {
+	tctx := ctx.(*core.CThreadCtx)
+	plug, err := tctx.GetClientPlugin(params, DNS_SRV_PLUG)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+	return plug.Ext.(*PluginDnsSrvClient), nil
+}
+
+func (h ApiDnsSrvClientCntHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	var p core.ApiCntParams
+	tctx := ctx.(*core.CThreadCtx)
+	c, err := getDnsSrvClient(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	return c.cdbv.GeneralCounters(err, tctx, params, &p)
+}
+
+func (h ApiDnsSrvClientQueriesHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	c, err := getDnsSrvClient(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	return c.getQueryInfo(), nil
+}
+
+func (h ApiDnsSrvNsCntHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	var p core.ApiCntParams
+	tctx := ctx.(*core.CThreadCtx)
+	plug, err := tctx.GetNsPlugin(params, DNS_SRV_PLUG)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+	return plug.Ext.(*PluginDnsSrvNs).cdbv.GeneralCounters(nil, tctx, params, &p)
+}
+
+func init() {
+
+	/* register of plugins callbacks for ns,c level  */
+	core.PluginRegister(DNS_SRV_PLUG,
+		core.PluginRegisterData{Client: PluginDnsSrvCReg{},
+			Ns:     PluginDnsSrvNsReg{},
+			Thread: nil}) /* no need for thread context for now */
+
+	core.RegisterCB("dns_srv_client_cnt", ApiDnsSrvClientCntHandler{}, false)         // get counters/meta
+	core.RegisterCB("dns_srv_client_queries", ApiDnsSrvClientQueriesHandler{}, false) // queries per name and type
+	core.RegisterCB("dns_srv_ns_cnt", ApiDnsSrvNsCntHandler{}, false)                 // get counters/meta
+}
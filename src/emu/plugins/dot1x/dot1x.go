@@ -10,6 +10,7 @@ RFC 8415  dot1x client with
 
 EAP-MD5
 EAP-MSCHAPv2
+EAP-TLS, PEAP and EAP-TTLS in case tls is given (see eaptls.go)
 
 
 */
@@ -54,17 +55,22 @@ const (
 
 	EAP_MD5_MASK      = 1
 	EAP_MSCHAPv2_MASK = 2
+	EAP_TLS_MASK      = 4
+	EAP_PEAP_MASK     = 8
+	EAP_TTLS_MASK     = 16
 )
 
 var dot1xDefaultDestMAC = []byte{0x01, 0x80, 0xc2, 0x00, 0x00, 0x03}
 
 type Dot1xCfg struct {
-	User       *string `json:"user"`       // user name
-	Password   *string `json:"password"`   // password
-	Nthash     *string `json:"nthash"`     // hash string for MSCHAPv2
-	Flags      uint32  `json:"flags"`      // not used
-	TimeoutSec uint32  `json:"timeo_idle"` // timeout for success in sec
-	MaxStart   uint32  `json:"max_start"`  // max number of retries
+	User            *string      `json:"user"`             // user name
+	Password        *string      `json:"password"`         // password
+	Nthash          *string      `json:"nthash"`           // hash string for MSCHAPv2
+	Flags           uint32       `json:"flags"`            // 1 - disable EAP-MD5, 2 - disable EAP-MSCHAPv2
+	TimeoutSec      uint32       `json:"timeo_idle"`       // timeout for success in sec
+	MaxStart        uint32       `json:"max_start"`        // max number of retries
+	Tls             *Dot1xTlsCfg `json:"tls"`              // certificates of the TLS methods
+	DisabledMethods uint32       `json:"disabled_methods"` // mask of the disabled methods
 }
 
 type Dot1xStats struct {
@@ -82,6 +88,10 @@ type Dot1xStats struct {
 	pktMethodNoPassword    uint64
 	pktMethodWrongLen      uint64
 	pktMethodFailErr       uint64
+	pktTlsErr              uint64
+	pktTlsRxFrag           uint64
+	pktTlsTxFrag           uint64
+	tlsCfgErr              uint64
 }
 
 func NewDot1xStatsDb(o *Dot1xStats) *core.CCounterDb {
@@ -199,6 +209,38 @@ func NewDot1xStatsDb(o *Dot1xStats) *core.CCounterDb {
 		DumpZero: false,
 		Info:     core.ScERROR})
 
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTlsErr,
+		Name:     "pktTlsErr",
+		Help:     "tls handshake or framing error",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTlsRxFrag,
+		Name:     "pktTlsRxFrag",
+		Help:     "rx tls fragment, acked",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTlsTxFrag,
+		Name:     "pktTlsTxFrag",
+		Help:     "tx tls fragment",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.tlsCfgErr,
+		Name:     "tlsCfgErr",
+		Help:     "tls certificates are not valid, the tls methods are disabled",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
 	return db
 }
 
@@ -229,12 +271,16 @@ type Dot1xMethodIF interface {
 type MethodToHandler map[uint8]Dot1xMethodIF
 
 type Dot1xClientInfo struct {
-	State          uint8 `json:"state"`
-	SelectedMethod uint8 `json:"method"`
-	EapVer         uint8 `json:"eap_version"`
+	State          uint8  `json:"state"`
+	SelectedMethod uint8  `json:"method"`
+	EapVer         uint8  `json:"eap_version"`
+	TlsStage       string `json:"tls_stage,omitempty"`   // handshake stage of EAP-TLS/PEAP/TTLS
+	TlsVersion     uint16 `json:"tls_version,omitempty"` // once established
+	TlsCipher      string `json:"tls_cipher,omitempty"`
+	TlsErr         string `json:"tls_error,omitempty"`
 }
 
-//PluginDot1xClient information per client
+// PluginDot1xClient information per client
 type PluginDot1xClient struct {
 	core.PluginBase
 	nsPlug  *PluginDot1xNs
@@ -295,24 +341,57 @@ func (o *PluginDot1xClient) OnCreate() {
 	o.mapHandler = make(MethodToHandler)
 	// add the handlers
 
-	if o.cfg.Flags&EAP_MD5_MASK == 0 {
+	if !o.isDisabled(EAP_MD5_MASK) {
 		o.mapHandler[EAP_TYPE_MD5] = NewEapMd5()
 	}
-	if o.cfg.Flags&EAP_MSCHAPv2_MASK == 0 {
+	if !o.isDisabled(EAP_MSCHAPv2_MASK) {
 		o.mapHandler[EAP_TYPE_MSCHAPV2] = NewEapMschapv2()
 	}
 
-	if o.cfg.Flags&EAP_MD5_MASK == 0 {
+	if !o.isDisabled(EAP_MD5_MASK) {
 		o.nack = []byte{EAP_TYPE_MD5}
 	} else {
 		o.nack = []byte{EAP_TYPE_MSCHAPV2}
 	}
+	o.addTlsHandlers()
 
 	o.eapVer = MAX_EAPOL_VER
 	o.smCnt = 0
 	o.StartSm()
 }
 
+// isDisabled returns true in case the method is disabled by disabled_methods, flags can disable EAP-MD5 and EAP-MSCHAPv2 too
+func (o *PluginDot1xClient) isDisabled(mask uint32) bool {
+	disabled := o.cfg.DisabledMethods | o.cfg.Flags&(EAP_MD5_MASK|EAP_MSCHAPv2_MASK)
+	return disabled&mask != 0
+}
+
+// addTlsHandlers adds the tls methods in case tls is given, EAP-TLS requires a client certificate
+func (o *PluginDot1xClient) addTlsHandlers() {
+	if o.cfg.Tls == nil {
+		return
+	}
+	cfg, hasCert, err := newTlsConfig(o.cfg.Tls)
+	if err != nil {
+		o.stats.tlsCfgErr++
+		return
+	}
+	var nack []byte
+	if hasCert && !o.isDisabled(EAP_TLS_MASK) {
+		o.mapHandler[EAP_TYPE_TLS] = NewEapTls(EAP_TYPE_TLS, cfg, o.cfg.Tls.FragSize)
+		nack = append(nack, EAP_TYPE_TLS)
+	}
+	if !o.isDisabled(EAP_PEAP_MASK) {
+		o.mapHandler[EAP_TYPE_PEAP] = NewEapTls(EAP_TYPE_PEAP, cfg, o.cfg.Tls.FragSize)
+		nack = append(nack, EAP_TYPE_PEAP)
+	}
+	if !o.isDisabled(EAP_TTLS_MASK) {
+		o.mapHandler[EAP_TYPE_TTLS] = NewEapTls(EAP_TYPE_TTLS, cfg, o.cfg.Tls.FragSize)
+		nack = append(nack, EAP_TYPE_TTLS)
+	}
+	o.nack = append(nack, o.nack...)
+}
+
 func (o *PluginDot1xClient) changeToInit() {
 	o.selectedMethod = 0
 	o.methodState = 0
@@ -398,12 +477,19 @@ func (o *PluginDot1xClient) OnEvent(msg string, a, b interface{}) {
 
 }
 
+func (o *PluginDot1xClient) GetCounterDbVec() *core.CCounterDbVec {
+	return o.cdbv
+}
+
 func (o *PluginDot1xClient) OnRemove(ctx *core.PluginCtx) {
 	/* force removing the link to the client */
 	ctx.UnregisterEvents(&o.PluginBase, dot1xEvents)
 	if o.timer.IsRunning() {
 		o.timerw.Stop(&o.timer)
 	}
+	for _, h := range o.mapHandler {
+		h.OnRemove()
+	}
 }
 
 func (o *PluginDot1xClient) makeSurereTimerIsRunning() {
@@ -420,7 +506,7 @@ func (o *PluginDot1xClient) restartTimer() {
 	o.timerw.Start(&o.timer, time.Duration(o.cfg.TimeoutSec)*time.Second)
 }
 
-//onTimerEvent on timer event callback
+// onTimerEvent on timer event callback
 func (o *PluginDot1xClient) onTimerEvent() {
 
 	if (o.smState == EAP_DONE_OK) ||
@@ -656,6 +742,10 @@ func HandleRxDot1xPacket(ps *core.ParserPacketState) int {
 	if ns == nil {
 		return core.PARSER_ERR
 	}
+	// frames of the supplicants are for the authenticator of the namespace
+	if auth := getAuthNs(ns); auth != nil && isForAuth(ps.M.GetData(), ps.L3) {
+		return auth.HandleRxDot1xPacket(ps)
+	}
 	nsplg := ns.PluginCtx.Get(DOT1X_PLUG)
 	if nsplg == nil {
 		return core.PARSER_ERR
@@ -737,6 +827,9 @@ func (h ApiDot1xClientInfoHandler) ServeJSONRPC(ctx interface{}, params *fastjso
 		rp.State = pc.smState
 		rp.SelectedMethod = pc.selectedMethod
 		rp.EapVer = pc.eapVer
+		if h, ok := pc.mapHandler[pc.selectedMethod].(*EapTlsHandler); ok {
+			h.getInfo(rp)
+		}
 	}
 
 	return res, nil
@@ -785,7 +878,8 @@ func init() {
 	// TBD getter for the client info
 
 	/* register callback for rx side*/
-	core.ParserRegister("dot1x", HandleRxDot1xPacket)
+	core.ParserRegister("dot1x", HandleRxDot1xPacket,
+		core.ParserRegisterData{EtherTypes: []uint16{uint16(layers.EthernetTypeEAPOL)}})
 }
 
 func Register(ctx *core.CThreadCtx) {
@@ -0,0 +1,1033 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package dot1x
+
+/*
+IEEE 802.1X authenticator, namespace plugin
+
+The authenticator answers EAPOL-Start of the supplicants of the port, sends EAP-Request/Identity and runs the server side
+of EAP-MD5/EAP-MSCHAPv2 against a local table of users. The frames are sent from auth_mac (a client of the namespace)
+to the MAC of the supplicant.
+
+In case radius is given the authenticator is a pass-through (RFC 3579), the EAP messages are carried to the RADIUS server
+by Access-Request from the UDP socket of auth_mac (transport layer) and the EAP messages of Access-Challenge are passed to the
+supplicant. Access-Accept/Access-Reject end the session with EAP-Success/EAP-Failure.
+Up to 256 Access-Requests are pending (one per RADIUS id), a response of the supplicant is dropped while all the ids are in use.
+auth_mac should have an IPv4 and a resolved default gateway (or ipv4_force_dg) toward the server in this case.
+
+A request is retransmitted every timeo seconds up to max_req times, after a failure new EAPOL-Start are ignored for quiet seconds.
+reauth restarts the authentication of an authorized supplicant, zero disables it.
+
+ns inijson {
+	"auth_mac": [0, 0, 1, 0, 0, 9],
+	"methods": ["md5", "mschapv2"],
+	"users": [{"user": "hhaim", "password": "cisco"}],
+	"timeo": 30,
+	"max_req": 2,
+	"quiet": 60,
+	"reauth": 0,
+	"radius": {"server": [16, 0, 0, 1], "port": 1812, "secret": "switch1"}
+}
+
+*/
+
+import (
+	"emu/core"
+	"emu/plugins/transport"
+	"encoding/binary"
+	"external/google/gopacket"
+	"external/google/gopacket/layers"
+	"external/osamingo/jsonrpc"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/intel-go/fastjson"
+)
+
+const (
+	DOT1XAUTH_PLUG = "dot1xauth"
+
+	// default timers
+	DOT1X_AUTH_TIMEOUT_SEC = 30
+	DOT1X_AUTH_MAX_REQ     = 2
+	DOT1X_AUTH_QUIET_SEC   = 60
+
+	/* state of each session */
+	DOT1X_AUTH_IDENTITY      = 1 // wait for the identity
+	DOT1X_AUTH_METHOD        = 2 // wait for the response of the method
+	DOT1X_AUTH_RADIUS        = 3 // wait for the RADIUS server
+	DOT1X_AUTH_AUTHENTICATED = 4
+	DOT1X_AUTH_HELD          = 5 // failed, quiet period
+)
+
+var dot1xAuthStateName = map[uint8]string{
+	DOT1X_AUTH_IDENTITY:      "identity",
+	DOT1X_AUTH_METHOD:        "method",
+	DOT1X_AUTH_RADIUS:        "radius",
+	DOT1X_AUTH_AUTHENTICATED: "authenticated",
+	DOT1X_AUTH_HELD:          "held",
+}
+
+type Dot1xAuthUser struct {
+	User     string `json:"user" validate:"required"`
+	Password string `json:"password"`
+}
+
+type Dot1xAuthRadius struct {
+	Server core.Ipv4Key `json:"server" validate:"required"`
+	Port   uint16       `json:"port"`
+	Secret string       `json:"secret" validate:"required"`
+}
+
+type Dot1xAuthInit struct {
+	AuthMac    core.MACKey      `json:"auth_mac" validate:"required"`
+	Methods    []string         `json:"methods"`
+	Users      []Dot1xAuthUser  `json:"users"`
+	TimeoutSec uint32           `json:"timeo"`
+	MaxReq     uint32           `json:"max_req"`
+	QuietSec   uint32           `json:"quiet"`
+	ReauthSec  uint32           `json:"reauth"`
+	Radius     *Dot1xAuthRadius `json:"radius"`
+}
+
+type Dot1xAuthStats struct {
+	pktRxStart         uint64
+	pktRxStartHeld     uint64
+	pktRxLogoff        uint64
+	pktRxResponse      uint64
+	pktRxNak           uint64
+	pktRxWrongId       uint64
+	pktRxWrongType     uint64
+	pktRxNoSession     uint64
+	pktRxParserErr     uint64
+	pktTxRequest       uint64
+	pktTxRetransmit    uint64
+	pktTxSuccess       uint64
+	pktTxFailure       uint64
+	authSuccess        uint64
+	authFailure        uint64
+	authTimeout        uint64
+	errUnknownUser     uint64
+	radiusTxRequest    uint64
+	radiusTxRetransmit uint64
+	radiusRxChallenge  uint64
+	radiusRxAccept     uint64
+	radiusRxReject     uint64
+	radiusRxInvalid    uint64
+	errRadiusTimeout   uint64
+	errRadiusIdBusy    uint64
+	errSocket          uint64
+	errUnresolved      uint64
+	errNoAuthClient    uint64
+	errInitJson        uint64
+	sessionActive      uint64
+}
+
+func NewDot1xAuthStatsDb(o *Dot1xAuthStats) *core.CCounterDb {
+	db := core.NewCCounterDb("dot1xauth")
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxStart,
+		Name:     "pktRxStart",
+		Help:     "rx EAPOL-Start",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxStartHeld,
+		Name:     "pktRxStartHeld",
+		Help:     "rx EAPOL-Start in the quiet period, ignored",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxLogoff,
+		Name:     "pktRxLogoff",
+		Help:     "rx EAPOL-Logoff",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxResponse,
+		Name:     "pktRxResponse",
+		Help:     "rx EAP-Response",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxNak,
+		Name:     "pktRxNak",
+		Help:     "rx EAP-Response/Nak",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxWrongId,
+		Name:     "pktRxWrongId",
+		Help:     "rx response with an id of another request",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxWrongType,
+		Name:     "pktRxWrongType",
+		Help:     "rx response of another method or in the wrong state",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxNoSession,
+		Name:     "pktRxNoSession",
+		Help:     "rx response without a session",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxParserErr,
+		Name:     "pktRxParserErr",
+		Help:     "rx parser error",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTxRequest,
+		Name:     "pktTxRequest",
+		Help:     "tx EAP-Request",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTxRetransmit,
+		Name:     "pktTxRetransmit",
+		Help:     "tx EAP-Request retransmit",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTxSuccess,
+		Name:     "pktTxSuccess",
+		Help:     "tx EAP-Success",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTxFailure,
+		Name:     "pktTxFailure",
+		Help:     "tx EAP-Failure",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.authSuccess,
+		Name:     "authSuccess",
+		Help:     "supplicants that were authenticated",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.authFailure,
+		Name:     "authFailure",
+		Help:     "supplicants that failed the authentication",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.authTimeout,
+		Name:     "authTimeout",
+		Help:     "supplicants that did not answer max_req requests",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.errUnknownUser,
+		Name:     "errUnknownUser",
+		Help:     "identity is not in the users table",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.radiusTxRequest,
+		Name:     "radiusTxRequest",
+		Help:     "tx RADIUS Access-Request",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.radiusTxRetransmit,
+		Name:     "radiusTxRetransmit",
+		Help:     "tx RADIUS Access-Request retransmit",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.radiusRxChallenge,
+		Name:     "radiusRxChallenge",
+		Help:     "rx RADIUS Access-Challenge",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.radiusRxAccept,
+		Name:     "radiusRxAccept",
+		Help:     "rx RADIUS Access-Accept",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.radiusRxReject,
+		Name:     "radiusRxReject",
+		Help:     "rx RADIUS Access-Reject",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.radiusRxInvalid,
+		Name:     "radiusRxInvalid",
+		Help:     "rx RADIUS packet that is not valid, unknown id or wrong authenticator",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.errRadiusTimeout,
+		Name:     "errRadiusTimeout",
+		Help:     "RADIUS server did not answer",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.errRadiusIdBusy,
+		Name:     "errRadiusIdBusy",
+		Help:     "all the 256 RADIUS ids are pending, the response is dropped",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.errSocket,
+		Name:     "errSocket",
+		Help:     "can't open or write the RADIUS socket",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.errUnresolved,
+		Name:     "errUnresolved",
+		Help:     "default gateway of auth_mac is not resolved",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.errNoAuthClient,
+		Name:     "errNoAuthClient",
+		Help:     "auth_mac client does not exist or has no IPv4",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.errInitJson,
+		Name:     "errInitJson",
+		Help:     "init json is not valid, the authenticator is disabled",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.sessionActive,
+		Name:     "sessionActive",
+		Help:     "active sessions",
+		Unit:     "sessions",
+		DumpZero: false,
+		Gauge:    true,
+		Info:     core.ScINFO})
+
+	return db
+}
+
+// Dot1xAuthMethodData the context of the method call
+type Dot1xAuthMethodData struct {
+	plug *PluginDot1xAuthNs
+	sess *dot1xAuthSession
+	eap  *layers.EAP // the response, nil in BuildReq
+	id   uint8       // the id of the request
+}
+
+// Dot1xAuthMethodIF the authenticator side of an EAP method, an object per session
+type Dot1xAuthMethodIF interface {
+
+	// the name of the method, e.g. "eap-md5"
+	GetName() string
+
+	// BuildReq returns the type data of the first request
+	BuildReq(d *Dot1xAuthMethodData) []byte
+
+	// HandleResp returns (finish, ok, type data of the next request)
+	HandleResp(d *Dot1xAuthMethodData) (bool, bool, []byte)
+}
+
+type dot1xAuthMethodReg struct {
+	name   string
+	t      uint8
+	create func() Dot1xAuthMethodIF
+}
+
+var dot1xAuthMethods = []dot1xAuthMethodReg{
+	{name: "md5", t: EAP_TYPE_MD5, create: NewEapMd5Auth},
+	{name: "mschapv2", t: EAP_TYPE_MSCHAPV2, create: NewEapMschapv2Auth},
+}
+
+type PluginDot1xAuthTimer struct {
+}
+
+func (o *PluginDot1xAuthTimer) OnEvent(a, b interface{}) {
+	sess := a.(*dot1xAuthSession)
+	sess.plug.onTimerEvent(sess)
+}
+
+// dot1xAuthSession the authentication of a supplicant
+type dot1xAuthSession struct {
+	mac         core.MACKey
+	state       uint8
+	eapVer      uint8
+	id          uint8  // id of the last request
+	cnt         uint32 // retransmits of the last request
+	lastReq     []byte // the last EAP request
+	user        string
+	password    string
+	method      Dot1xAuthMethodIF
+	methodType  uint8
+	radiusId    uint8
+	radiusAuth  []byte // Request Authenticator of the last Access-Request
+	radiusPkt   []byte // the last Access-Request
+	radiusState []byte
+	timer       core.CHTimerObj
+	plug        *PluginDot1xAuthNs
+}
+
+type Dot1xAuthSessionInfo struct {
+	Mac    core.MACKey `json:"mac"`
+	State  string      `json:"state"`
+	User   string      `json:"user"`
+	Method uint8       `json:"method"`
+	EapVer uint8       `json:"eap_version"`
+}
+
+// PluginDot1xAuthNs 802.1X authenticator per namespace
+type PluginDot1xAuthNs struct {
+	core.PluginBase
+	init     Dot1xAuthInit
+	enable   bool
+	methods  []*dot1xAuthMethodReg
+	users    map[string]string
+	sessions map[core.MACKey]*dot1xAuthSession
+	pending  map[uint8]*dot1xAuthSession // RADIUS id to session, one server per namespace
+	radiusId uint8                       // the last allocated id
+	nas      *core.CClient               // the client of the RADIUS socket
+	ipv4     core.Ipv4Key
+	socket   transport.SocketApi
+	timerw   *core.TimerCtx
+	timerCb  PluginDot1xAuthTimer
+	stats    Dot1xAuthStats
+	cdb      *core.CCounterDb
+	cdbv     *core.CCounterDbVec
+}
+
+func NewDot1xAuthNs(ctx *core.PluginCtx, initJson []byte) *core.PluginBase {
+	o := new(PluginDot1xAuthNs)
+	o.InitPluginBase(ctx, o)
+	o.RegisterEvents(ctx, []string{}, o)
+	o.cdb = NewDot1xAuthStatsDb(&o.stats)
+	o.cdbv = core.NewCCounterDbVec("dot1xauth")
+	o.cdbv.Add(o.cdb)
+	o.timerw = o.Tctx.GetTimerCtx()
+	o.users = make(map[string]string)
+	o.sessions = make(map[core.MACKey]*dot1xAuthSession)
+	o.pending = make(map[uint8]*dot1xAuthSession)
+
+	o.init = Dot1xAuthInit{Methods: []string{"md5", "mschapv2"},
+		TimeoutSec: DOT1X_AUTH_TIMEOUT_SEC,
+		MaxReq:     DOT1X_AUTH_MAX_REQ,
+		QuietSec:   DOT1X_AUTH_QUIET_SEC}
+	err := o.Tctx.UnmarshalValidate(initJson, &o.init)
+	if err != nil || !o.loadMethods() {
+		o.stats.errInitJson++
+		return &o.PluginBase
+	}
+	if o.init.Radius != nil && o.init.Radius.Port == 0 {
+		o.init.Radius.Port = RADIUS_PORT
+	}
+	for _, u := range o.init.Users {
+		o.users[u.User] = u.Password
+	}
+	o.enable = true
+	return &o.PluginBase
+}
+
+func (o *PluginDot1xAuthNs) loadMethods() bool {
+	for _, name := range o.init.Methods {
+		var m *dot1xAuthMethodReg
+		for i := range dot1xAuthMethods {
+			if dot1xAuthMethods[i].name == name {
+				m = &dot1xAuthMethods[i]
+			}
+		}
+		if m == nil {
+			return false
+		}
+		o.methods = append(o.methods, m)
+	}
+	return len(o.methods) > 0
+}
+
+func (o *PluginDot1xAuthNs) getMethod(t uint8) *dot1xAuthMethodReg {
+	for _, m := range o.methods {
+		if m.t == t {
+			return m
+		}
+	}
+	return nil
+}
+
+func (o *PluginDot1xAuthNs) GetCounterDbVec() *core.CCounterDbVec {
+	return o.cdbv
+}
+
+func (o *PluginDot1xAuthNs) OnRemove(ctx *core.PluginCtx) {
+	for _, sess := range o.sessions {
+		o.removeSession(sess)
+	}
+	o.close()
+}
+
+func (o *PluginDot1xAuthNs) OnEvent(msg string, a, b interface{}) {
+
+}
+
+func (o *PluginDot1xAuthNs) getAuthClient() *core.CClient {
+	c := o.Ns.CLookupByMac(&o.init.AuthMac)
+	if c == nil {
+		o.stats.errNoAuthClient++
+	}
+	return c
+}
+
+// close closes the socket in case the client still exists, otherwise it was removed with it
+func (o *PluginDot1xAuthNs) close() {
+	if o.nas == nil {
+		return
+	}
+	if o.Ns.CLookupByMac(&o.init.AuthMac) == o.nas {
+		o.socket.Close()
+	}
+	o.nas = nil
+	o.socket = nil
+}
+
+// open opens the socket of auth_mac to the RADIUS server
+func (o *PluginDot1xAuthNs) open() bool {
+	nas := o.Ns.CLookupByMac(&o.init.AuthMac)
+	if nas == nil || nas.Ipv4.IsZero() {
+		o.close()
+		o.stats.errNoAuthClient++
+		return false
+	}
+	if nas == o.nas && nas.Ipv4 == o.ipv4 {
+		return true
+	}
+	o.close()
+
+	nas.PluginCtx.GetOrCreate(transport.TRANS_PLUG)
+	ctx := transport.GetTransportCtx(nas)
+	server := net.JoinHostPort(o.init.Radius.Server.ToIP().String(), strconv.Itoa(int(o.init.Radius.Port)))
+	s, err := ctx.Dial("udp", server, o, nil, nil)
+	if err != nil {
+		o.stats.errSocket++
+		return false
+	}
+	o.nas = nas
+	o.ipv4 = nas.Ipv4
+	o.socket = s
+	return true
+}
+
+func (o *PluginDot1xAuthNs) newSession(mac core.MACKey) *dot1xAuthSession {
+	sess := &dot1xAuthSession{mac: mac, plug: o, eapVer: MAX_EAPOL_VER}
+	sess.timer.SetCB(&o.timerCb, sess, 0)
+	o.sessions[mac] = sess
+	o.stats.sessionActive++
+	return sess
+}
+
+func (o *PluginDot1xAuthNs) removeSession(sess *dot1xAuthSession) {
+	if sess.timer.IsRunning() {
+		o.timerw.Stop(&sess.timer)
+	}
+	o.clearPending(sess)
+	delete(o.sessions, sess.mac)
+	o.stats.sessionActive--
+}
+
+// clearPending forgets the Access-Request of the session, a late reply is dropped
+func (o *PluginDot1xAuthNs) clearPending(sess *dot1xAuthSession) {
+	if o.pending[sess.radiusId] == sess {
+		delete(o.pending, sess.radiusId)
+	}
+}
+
+func (o *PluginDot1xAuthNs) restartTimer(sess *dot1xAuthSession, sec uint32) {
+	if sess.timer.IsRunning() {
+		o.timerw.Stop(&sess.timer)
+	}
+	if sec > 0 {
+		o.timerw.Start(&sess.timer, time.Duration(sec)*time.Second)
+	}
+}
+
+func (o *PluginDot1xAuthNs) onTimerEvent(sess *dot1xAuthSession) {
+	switch sess.state {
+	case DOT1X_AUTH_IDENTITY, DOT1X_AUTH_METHOD:
+		if sess.cnt < o.init.MaxReq {
+			sess.cnt++
+			o.stats.pktTxRetransmit++
+			o.sendEap(sess, sess.lastReq)
+			o.restartTimer(sess, o.init.TimeoutSec)
+			return
+		}
+		o.stats.authTimeout++
+		o.fail(sess)
+	case DOT1X_AUTH_RADIUS:
+		if sess.cnt < o.init.MaxReq {
+			sess.cnt++
+			o.stats.radiusTxRetransmit++
+			o.writeRadius(sess.radiusPkt)
+			o.restartTimer(sess, o.init.TimeoutSec)
+			return
+		}
+		o.stats.errRadiusTimeout++
+		o.clearPending(sess)
+		o.fail(sess)
+	case DOT1X_AUTH_AUTHENTICATED:
+		o.startIdentity(sess)
+	case DOT1X_AUTH_HELD:
+		o.removeSession(sess)
+	}
+}
+
+// sendEap sends the EAP packet to the supplicant
+func (o *PluginDot1xAuthNs) sendEap(sess *dot1xAuthSession, eap []byte) {
+	client := o.getAuthClient()
+	if client == nil {
+		return
+	}
+	pkt := client.GetL2Header(false, uint16(layers.EthernetTypeEAPOL))
+	copy(pkt[0:6], sess.mac[:])
+	pkt = append(pkt, sess.eapVer, byte(layers.EAPOLTypeEAP), 0, 0)
+	binary.BigEndian.PutUint16(pkt[len(pkt)-2:], uint16(len(eap)))
+	pkt = append(pkt, eap...)
+	o.Tctx.Veth.SendBuffer(false, client, pkt)
+}
+
+// sendRequest sends a new EAP-Request, it is retransmitted until the response
+func (o *PluginDot1xAuthNs) sendRequest(sess *dot1xAuthSession, t uint8, d []byte) {
+	sess.id++
+	eap := []byte{byte(layers.EAPCodeRequest), sess.id, 0, 0, t}
+	eap = append(eap, d...)
+	binary.BigEndian.PutUint16(eap[2:4], uint16(len(eap)))
+	o.forwardRequest(sess, eap)
+}
+
+func (o *PluginDot1xAuthNs) forwardRequest(sess *dot1xAuthSession, eap []byte) {
+	sess.lastReq = eap
+	sess.cnt = 0
+	o.stats.pktTxRequest++
+	o.sendEap(sess, eap)
+	o.restartTimer(sess, o.init.TimeoutSec)
+}
+
+func (o *PluginDot1xAuthNs) startIdentity(sess *dot1xAuthSession) {
+	sess.state = DOT1X_AUTH_IDENTITY
+	sess.method = nil
+	sess.methodType = 0
+	sess.radiusState = nil
+	o.sendRequest(sess, uint8(layers.EAPTypeIdentity), []byte{})
+}
+
+func (o *PluginDot1xAuthNs) startMethod(sess *dot1xAuthSession, m *dot1xAuthMethodReg) {
+	sess.state = DOT1X_AUTH_METHOD
+	sess.method = m.create()
+	sess.methodType = m.t
+	d := Dot1xAuthMethodData{plug: o, sess: sess, id: sess.id + 1}
+	o.sendRequest(sess, m.t, sess.method.BuildReq(&d))
+}
+
+// success ends the session with EAP-Success, the id is the one of the last response
+func (o *PluginDot1xAuthNs) success(sess *dot1xAuthSession) {
+	o.stats.pktTxSuccess++
+	o.stats.authSuccess++
+	sess.state = DOT1X_AUTH_AUTHENTICATED
+	o.sendEap(sess, []byte{byte(layers.EAPCodeSuccess), sess.id, 0, 4})
+	o.restartTimer(sess, o.init.ReauthSec)
+}
+
+func (o *PluginDot1xAuthNs) fail(sess *dot1xAuthSession) {
+	o.stats.pktTxFailure++
+	o.stats.authFailure++
+	sess.state = DOT1X_AUTH_HELD
+	o.sendEap(sess, []byte{byte(layers.EAPCodeFailure), sess.id, 0, 4})
+	if o.init.QuietSec == 0 {
+		o.removeSession(sess)
+		return
+	}
+	o.restartTimer(sess, o.init.QuietSec)
+}
+
+// isForAuth returns true in case the frame is sent by a supplicant (EAPOL-Start/Logoff, EAP-Response)
+func isForAuth(p []byte, l3 uint16) bool {
+	if len(p) < int(l3)+4 {
+		return false
+	}
+	switch layers.EAPOLType(p[l3+1]) {
+	case layers.EAPOLTypeStart, layers.EAPOLTypeLogOff:
+		return true
+	case layers.EAPOLTypeEAP:
+		return len(p) > int(l3)+4 && layers.EAPCode(p[l3+4]) == layers.EAPCodeResponse
+	}
+	return false
+}
+
+func (o *PluginDot1xAuthNs) HandleRxDot1xPacket(ps *core.ParserPacketState) int {
+	if !o.enable {
+		return core.PARSER_ERR
+	}
+	p := ps.M.GetData()
+	var eapol layers.EAPOL
+	err := eapol.DecodeFromBytes(p[ps.L3:ps.L3+4], gopacket.NilDecodeFeedback)
+	if err != nil || eapol.Version < 1 || eapol.Version > MAX_EAPOL_VER {
+		o.stats.pktRxParserErr++
+		return core.PARSER_ERR
+	}
+	var mac core.MACKey
+	copy(mac[:], p[6:12])
+	sess := o.sessions[mac]
+
+	switch eapol.Type {
+	case layers.EAPOLTypeStart:
+		o.stats.pktRxStart++
+		if sess != nil && sess.state == DOT1X_AUTH_HELD {
+			o.stats.pktRxStartHeld++
+			return 0
+		}
+		if sess == nil {
+			sess = o.newSession(mac)
+		}
+		sess.eapVer = eapol.Version
+		o.startIdentity(sess)
+
+	case layers.EAPOLTypeLogOff:
+		o.stats.pktRxLogoff++
+		if sess != nil {
+			o.removeSession(sess)
+		}
+
+	case layers.EAPOLTypeEAP:
+		l := uint32(ps.L3) + 4 + uint32(eapol.Length)
+		if eapol.Length < 5 || l > ps.M.PktLen() {
+			o.stats.pktRxParserErr++
+			return core.PARSER_ERR
+		}
+		var eap layers.EAP
+		if eap.DecodeFromBytes(p[ps.L3+4:l], gopacket.NilDecodeFeedback) != nil || eap.Length < 5 ||
+			uint32(eap.Length) > uint32(eapol.Length) {
+			o.stats.pktRxParserErr++
+			return core.PARSER_ERR
+		}
+		eap.TypeData = eap.TypeData[:eap.Length-EAPSIZE_PKT_HEADER]
+		o.stats.pktRxResponse++
+		if sess == nil {
+			o.stats.pktRxNoSession++
+			return 0
+		}
+		if sess.state != DOT1X_AUTH_IDENTITY && sess.state != DOT1X_AUTH_METHOD {
+			o.stats.pktRxWrongType++
+			return 0
+		}
+		if eap.Id != sess.id {
+			o.stats.pktRxWrongId++
+			return 0
+		}
+		sess.eapVer = eapol.Version
+		o.handleResponse(sess, &eap, p[ps.L3+4:ps.L3+4+eap.Length])
+	}
+	return 0
+}
+
+func (o *PluginDot1xAuthNs) handleResponse(sess *dot1xAuthSession, eap *layers.EAP, raw []byte) {
+	if eap.Type == layers.EAPTypeIdentity {
+		sess.user = string(eap.TypeData)
+	}
+	if o.init.Radius != nil {
+		o.sendAccessRequest(sess, raw)
+		return
+	}
+
+	switch {
+	case eap.Type == layers.EAPTypeIdentity:
+		pwd, ok := o.users[sess.user]
+		if !ok {
+			o.stats.errUnknownUser++
+			o.fail(sess)
+			return
+		}
+		sess.password = pwd
+		o.startMethod(sess, o.methods[0])
+
+	case eap.Type == layers.EAPTypeNACK && sess.state == DOT1X_AUTH_METHOD:
+		o.stats.pktRxNak++
+		for _, t := range eap.TypeData {
+			if m := o.getMethod(t); m != nil && t != sess.methodType {
+				o.startMethod(sess, m)
+				return
+			}
+		}
+		o.fail(sess)
+
+	case uint8(eap.Type) == sess.methodType && sess.state == DOT1X_AUTH_METHOD:
+		d := Dot1xAuthMethodData{plug: o, sess: sess, eap: eap, id: sess.id + 1}
+		finish, ok, next := sess.method.HandleResp(&d)
+		if !finish {
+			o.sendRequest(sess, sess.methodType, next)
+		} else if ok {
+			o.success(sess)
+		} else {
+			o.fail(sess)
+		}
+
+	default:
+		o.stats.pktRxWrongType++
+	}
+}
+
+func (o *PluginDot1xAuthNs) writeRadius(pkt []byte) {
+	if !o.open() {
+		return
+	}
+	res, _ := o.socket.Write(pkt)
+	if res != transport.SeOK {
+		if res == transport.SeUNRESOLVED {
+			o.stats.errUnresolved++
+		} else {
+			o.stats.errSocket++
+		}
+	}
+}
+
+// sendAccessRequest passes the EAP response of the supplicant to the RADIUS server
+func (o *PluginDot1xAuthNs) sendAccessRequest(sess *dot1xAuthSession, eap []byte) {
+	o.clearPending(sess)
+	if !o.allocRadiusId(sess) {
+		// the supplicant answers the retransmitted request, the id is allocated again then
+		o.stats.errRadiusIdBusy++
+		return
+	}
+	if o.Tctx.Simulation {
+		sess.radiusAuth = []byte{1, 2, 3, 4, 5, 6, 7, 8, 1, 2, 3, 4, 5, 6, 7, sess.radiusId}
+	} else {
+		genChalange16B(&sess.radiusAuth)
+	}
+
+	pkt := radiusPacket{code: RADIUS_ACCESS_REQUEST, id: sess.radiusId, auth: sess.radiusAuth}
+	pkt.add(RADIUS_ATTR_USER_NAME, []byte(sess.user))
+	pkt.add(RADIUS_ATTR_NAS_IP_ADDRESS, o.ipv4ToRadius())
+	m := sess.mac
+	pkt.add(RADIUS_ATTR_CALLING_STATION_ID,
+		[]byte(fmt.Sprintf("%02X-%02X-%02X-%02X-%02X-%02X", m[0], m[1], m[2], m[3], m[4], m[5])))
+	pkt.add(RADIUS_ATTR_NAS_PORT_TYPE, []byte{0, 0, 0, RADIUS_NAS_PORT_TYPE_ETHERNET})
+	if len(sess.radiusState) > 0 {
+		pkt.add(RADIUS_ATTR_STATE, sess.radiusState)
+	}
+	pkt.add(RADIUS_ATTR_EAP_MESSAGE, eap)
+	pkt.add(RADIUS_ATTR_MESSAGE_AUTHENTICATOR, make([]byte, 16))
+	sess.radiusPkt = pkt.encode(o.init.Radius.Secret)
+
+	sess.state = DOT1X_AUTH_RADIUS
+	sess.cnt = 0
+	o.stats.radiusTxRequest++
+	o.writeRadius(sess.radiusPkt)
+	o.restartTimer(sess, o.init.TimeoutSec)
+}
+
+// allocRadiusId allocates an id that is not pending, there are only 256 ids for the server
+func (o *PluginDot1xAuthNs) allocRadiusId(sess *dot1xAuthSession) bool {
+	if len(o.pending) >= 256 {
+		return false
+	}
+	for {
+		o.radiusId++
+		if _, ok := o.pending[o.radiusId]; !ok {
+			break
+		}
+	}
+	sess.radiusId = o.radiusId
+	o.pending[sess.radiusId] = sess
+	return true
+}
+
+func (o *PluginDot1xAuthNs) ipv4ToRadius() []byte {
+	var ip core.Ipv4Key
+	if c := o.Ns.CLookupByMac(&o.init.AuthMac); c != nil {
+		ip = c.Ipv4
+	}
+	return []byte(ip.ToIP().To4())
+}
+
+// OnRxData handles the replies of the RADIUS server
+func (o *PluginDot1xAuthNs) OnRxData(d []byte) {
+	pkt, err := decodeRadius(d)
+	if err != nil {
+		o.stats.radiusRxInvalid++
+		return
+	}
+	sess := o.pending[pkt.id]
+	if sess == nil || sess.state != DOT1X_AUTH_RADIUS || !verifyRadiusResponse(d, sess.radiusAuth, o.init.Radius.Secret) {
+		o.stats.radiusRxInvalid++
+		return
+	}
+	eap := pkt.get(RADIUS_ATTR_EAP_MESSAGE)
+
+	switch pkt.code {
+	case RADIUS_ACCESS_CHALLENGE:
+		if len(eap) < 5 || eap[0] != byte(layers.EAPCodeRequest) {
+			o.stats.radiusRxInvalid++
+			return
+		}
+		o.stats.radiusRxChallenge++
+		delete(o.pending, pkt.id)
+		sess.radiusState = pkt.get(RADIUS_ATTR_STATE)
+		sess.state = DOT1X_AUTH_METHOD
+		sess.id = eap[1]
+		sess.methodType = eap[4]
+		o.forwardRequest(sess, eap)
+	case RADIUS_ACCESS_ACCEPT:
+		o.stats.radiusRxAccept++
+		delete(o.pending, pkt.id)
+		o.success(sess)
+	case RADIUS_ACCESS_REJECT:
+		o.stats.radiusRxReject++
+		delete(o.pending, pkt.id)
+		o.fail(sess)
+	default:
+		o.stats.radiusRxInvalid++
+	}
+}
+
+func (o *PluginDot1xAuthNs) OnRxEvent(event transport.SocketEventType) {}
+func (o *PluginDot1xAuthNs) OnTxEvent(event transport.SocketEventType) {}
+func (o *PluginDot1xAuthNs) OnAccept(socket transport.SocketApi) transport.ISocketCb {
+	return nil
+}
+
+func (o *PluginDot1xAuthNs) getSessionsInfo() []Dot1xAuthSessionInfo {
+	res := make([]Dot1xAuthSessionInfo, 0, len(o.sessions))
+	for _, sess := range o.sessions {
+		res = append(res, Dot1xAuthSessionInfo{Mac: sess.mac,
+			State:  dot1xAuthStateName[sess.state],
+			User:   sess.user,
+			Method: sess.methodType,
+			EapVer: sess.eapVer})
+	}
+	return res
+}
+
+// getAuthNs returns the authenticator of the namespace, nil in case there isn't one
+func getAuthNs(ns *core.CNSCtx) *PluginDot1xAuthNs {
+	nsplg := ns.PluginCtx.Get(DOT1XAUTH_PLUG)
+	if nsplg == nil {
+		return nil
+	}
+	return nsplg.Ext.(*PluginDot1xAuthNs)
+}
+
+type PluginDot1xAuthNsReg struct{}
+
+func (o PluginDot1xAuthNsReg) NewPlugin(ctx *core.PluginCtx, initJson []byte) *core.PluginBase {
+	return NewDot1xAuthNs(ctx, initJson)
+}
+
+/*******************************************/
+/*  RPC commands */
+type (
+	ApiDot1xAuthNsCntHandler      struct{}
+	ApiDot1xAuthNsSessionsHandler struct{}
+)
+
+func getAuthNsPlugin(ctx interface{}, params *fastjson.RawMessage) (*PluginDot1xAuthNs, *jsonrpc.Error) {
+	tctx := ctx.(*core.CThreadCtx)
+	plug, err := tctx.GetNsPlugin(params, DOT1XAUTH_PLUG)
+
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+	return plug.Ext.(*PluginDot1xAuthNs), nil
+}
+
+func (h ApiDot1xAuthNsCntHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	var p core.ApiCntParams
+	tctx := ctx.(*core.CThreadCtx)
+	auth, err := getAuthNsPlugin(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	return auth.cdbv.GeneralCounters(nil, tctx, params, &p)
+}
+
+func (h ApiDot1xAuthNsSessionsHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	auth, err := getAuthNsPlugin(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	return auth.getSessionsInfo(), nil
+}
+
+func init() {
+	core.PluginRegister(DOT1XAUTH_PLUG,
+		core.PluginRegisterData{Client: nil,
+			Ns:     PluginDot1xAuthNsReg{},
+			Thread: nil})
+
+	core.RegisterCB("dot1xauth_ns_cnt", ApiDot1xAuthNsCntHandler{}, false)           // get counters/meta
+	core.RegisterCB("dot1xauth_ns_sessions", ApiDot1xAuthNsSessionsHandler{}, false) // get the sessions
+}
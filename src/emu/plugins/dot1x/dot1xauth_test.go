@@ -0,0 +1,245 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package dot1x
+
+import (
+	"bytes"
+	"crypto/md5"
+	"emu/core"
+	"emu/plugins/transport"
+	"external/google/gopacket"
+	"external/google/gopacket/layers"
+	"testing"
+	"time"
+)
+
+type VethDot1xAuthSim struct {
+	dropAll bool
+	tx      [][]byte
+}
+
+func (o *VethDot1xAuthSim) ProcessTxToRx(m *core.Mbuf) *core.Mbuf {
+	o.tx = append(o.tx, append([]byte(nil), m.GetData()...))
+	if o.dropAll {
+		m.FreeMbuf()
+		return nil
+	}
+	return m
+}
+
+func createDot1xAuthSimulationEnv(simVeth *VethDot1xAuthSim, initJson string) (*core.CThreadCtx, *core.CNSCtx, *core.CClient) {
+	var simrx core.VethIFSim
+	simrx = simVeth
+	tctx := core.NewThreadCtx(0, 4510, true, &simrx)
+	var key core.CTunnelKey
+	key.Set(&core.CTunnelData{Vport: 1, Vlans: [2]uint32{0x81000001, 0x81000002}})
+	ns := core.NewNSCtx(tctx, &key)
+	tctx.AddNs(&key, ns)
+
+	client := core.NewClient(ns, core.MACKey{0, 0, 1, 0, 0, 9},
+		core.Ipv4Key{16, 0, 0, 9},
+		core.Ipv6Key{},
+		core.Ipv4Key{0, 0, 0, 0})
+	ns.AddClient(client)
+	ns.PluginCtx.CreatePlugins([]string{"dot1xauth"}, [][]byte{[]byte(initJson)})
+	Register(tctx)
+	return tctx, ns, client
+}
+
+func addSupplicant(ns *core.CNSCtx, mac core.MACKey, cfg string) *PluginDot1xClient {
+	c := core.NewClient(ns, mac, core.Ipv4Key{}, core.Ipv6Key{}, core.Ipv4Key{})
+	ns.AddClient(c)
+	c.PluginCtx.CreatePlugins([]string{"dot1x"}, [][]byte{[]byte(cfg)})
+	return c.PluginCtx.Get(DOT1X_PLUG).Ext.(*PluginDot1xClient)
+}
+
+const dot1xAuthTestJson = `{"auth_mac": [0, 0, 1, 0, 0, 9],
+	"users": [{"user": "md5user", "password": "pwd1"}, {"user": "msuser", "password": "pwd2"}]}`
+
+func TestDot1xAuthLocal(t *testing.T) {
+	var simVeth VethDot1xAuthSim
+	tctx, ns, _ := createDot1xAuthSimulationEnv(&simVeth, dot1xAuthTestJson)
+	defer tctx.Delete()
+
+	macs := []core.MACKey{{0, 0, 1, 0, 0, 2}, {0, 0, 1, 0, 0, 3}, {0, 0, 1, 0, 0, 4}, {0, 0, 1, 0, 0, 5}}
+	cfgs := []string{`{"user": "md5user", "password": "pwd1"}`,
+		`{"user": "msuser", "password": "pwd2", "flags": 1}`, // no md5, Nak to mschapv2
+		`{"user": "md5user", "password": "bad"}`,
+		`{"user": "nobody", "password": "pwd1"}`}
+	var supp []*PluginDot1xClient
+	for i, mac := range macs {
+		supp = append(supp, addSupplicant(ns, mac, cfgs[i]))
+	}
+	tctx.MainLoopSim(5 * time.Second)
+
+	auth := getAuthNs(ns)
+	expState := []uint8{EAP_DONE_OK, EAP_DONE_OK, EAP_DONE_FAIL, EAP_WAIT_FOR_METHOD}
+	expMethod := []uint8{EAP_TYPE_MD5, EAP_TYPE_MSCHAPV2, EAP_TYPE_MD5, 0}
+	expSess := []uint8{DOT1X_AUTH_AUTHENTICATED, DOT1X_AUTH_AUTHENTICATED, DOT1X_AUTH_HELD, DOT1X_AUTH_HELD}
+	for i, mac := range macs {
+		if supp[i].smState != expState[i] {
+			t.Fatalf(" supplicant %v state %v ", mac, supp[i].smState)
+		}
+		sess := auth.sessions[mac]
+		if sess == nil || sess.state != expSess[i] || sess.methodType != expMethod[i] {
+			t.Fatalf(" session of %v is not as expected %+v ", mac, sess)
+		}
+	}
+	st := &auth.stats
+	if st.authSuccess != 2 || st.authFailure != 2 || st.pktRxNak != 1 || st.errUnknownUser != 1 || st.sessionActive != 4 {
+		t.Fatalf(" unexpected counters %+v ", *st)
+	}
+	if len(auth.getSessionsInfo()) != 4 {
+		t.Fatalf(" unexpected sessions %+v ", auth.getSessionsInfo())
+	}
+
+	// the logoff removes the session, the held sessions are removed after the quiet period
+	supp[0].SendLogoffPacket()
+	tctx.MainLoopSim(time.Second)
+	if auth.sessions[macs[0]] != nil || st.pktRxLogoff != 1 {
+		t.Fatalf(" session should be removed %+v ", *st)
+	}
+	tctx.MainLoopSim(DOT1X_AUTH_QUIET_SEC * time.Second)
+	if len(auth.sessions) != 1 || st.pktRxStartHeld == 0 {
+		t.Fatalf(" held sessions should be removed %+v ", *st)
+	}
+}
+
+// radiusRequests returns the Access-Requests that were sent to the server
+func radiusRequests(simVeth *VethDot1xAuthSim) [][]byte {
+	var res [][]byte
+	for _, b := range simVeth.tx {
+		pkt := gopacket.NewPacket(b, layers.LayerTypeEthernet, gopacket.Default)
+		if udp, ok := pkt.Layer(layers.LayerTypeUDP).(*layers.UDP); ok && udp.DstPort == RADIUS_PORT {
+			res = append(res, udp.Payload)
+		}
+	}
+	return res
+}
+
+// radiusReply returns the reply of the server to req
+func radiusReply(req *radiusPacket, code uint8, secret string, eap []byte, state []byte) []byte {
+	r := radiusPacket{code: code, id: req.id, auth: req.auth}
+	if len(state) > 0 {
+		r.add(RADIUS_ATTR_STATE, state)
+	}
+	if len(eap) > 0 {
+		r.add(RADIUS_ATTR_EAP_MESSAGE, eap)
+	}
+	r.add(RADIUS_ATTR_MESSAGE_AUTHENTICATOR, make([]byte, 16))
+	b := r.encode(secret)
+	h := md5.Sum(append(append([]byte(nil), b...), []byte(secret)...))
+	copy(b[4:20], h[:])
+	return b
+}
+
+func TestDot1xAuthRadius(t *testing.T) {
+	var simVeth VethDot1xAuthSim
+	tctx, ns, client := createDot1xAuthSimulationEnv(&simVeth, `{"auth_mac": [0, 0, 1, 0, 0, 9], "timeo": 1,
+		"radius": {"server": [48, 0, 0, 1], "secret": "switch1"}}`)
+	defer tctx.Delete()
+	transport.Register(tctx)
+	client.ForceDGW = true
+	client.Ipv4ForcedgMac = core.MACKey{0, 0, 1, 0, 0, 1}
+	auth := getAuthNs(ns)
+	st := &auth.stats
+
+	supp := addSupplicant(ns, core.MACKey{0, 0, 1, 0, 0, 2}, `{"user": "md5user", "password": "pwd1"}`)
+	tctx.MainLoopSim(time.Second)
+
+	// the identity of the supplicant
+	reqs := radiusRequests(&simVeth)
+	if len(reqs) != 1 {
+		t.Fatalf(" expected an Access-Request %+v ", *st)
+	}
+	req, err := decodeRadius(reqs[0])
+	if err != nil || req.code != RADIUS_ACCESS_REQUEST || string(req.get(RADIUS_ATTR_USER_NAME)) != "md5user" ||
+		string(req.get(RADIUS_ATTR_CALLING_STATION_ID)) != "00-00-01-00-00-02" ||
+		!bytes.Equal(req.get(RADIUS_ATTR_NAS_IP_ADDRESS), []byte{16, 0, 0, 9}) || req.has(RADIUS_ATTR_STATE) {
+		t.Fatalf(" Access-Request is not valid %+v ", req)
+	}
+	eap := req.get(RADIUS_ATTR_EAP_MESSAGE)
+	if len(eap) < 5 || eap[0] != byte(layers.EAPCodeResponse) || eap[4] != byte(layers.EAPTypeIdentity) {
+		t.Fatalf(" EAP message is not valid %v ", eap)
+	}
+	// the Message-Authenticator is over the packet with a zero value
+	d := append([]byte(nil), reqs[0]...)
+	ma := d[len(d)-16:]
+	exp := append([]byte(nil), ma...)
+	copy(ma, make([]byte, 16))
+	if !bytes.Equal(radiusMessageAuth(d, "switch1"), exp) {
+		t.Fatalf(" Message-Authenticator is not valid ")
+	}
+
+	// the challenge of the server is passed to the supplicant
+	chal := []byte{1, 1, 2, 2, 3, 3, 4, 4, 5, 5, 6, 6, 7, 7, 8, 8}
+	md5req := append([]byte{byte(layers.EAPCodeRequest), 7, 0, 22, EAP_TYPE_MD5, 16}, chal...)
+	auth.OnRxData(radiusReply(req, RADIUS_ACCESS_CHALLENGE, "bad", md5req, nil))
+	if st.radiusRxInvalid != 1 {
+		t.Fatalf(" reply with the wrong secret should be dropped %+v ", *st)
+	}
+	auth.OnRxData(radiusReply(req, RADIUS_ACCESS_CHALLENGE, "switch1", md5req, []byte("state1")))
+	tctx.MainLoopSim(time.Second)
+
+	reqs = radiusRequests(&simVeth)
+	if len(reqs) != 2 {
+		t.Fatalf(" expected the response of the method %+v ", *st)
+	}
+	req, _ = decodeRadius(reqs[1])
+	eap = req.get(RADIUS_ATTR_EAP_MESSAGE)
+	h := md5.Sum(append(append([]byte{7}, []byte("pwd1")...), chal...))
+	if string(req.get(RADIUS_ATTR_STATE)) != "state1" || len(eap) != 22 || eap[1] != 7 || !bytes.Equal(eap[6:22], h[:]) {
+		t.Fatalf(" Access-Request is not valid %v ", eap)
+	}
+	auth.OnRxData(radiusReply(req, RADIUS_ACCESS_ACCEPT, "switch1", []byte{byte(layers.EAPCodeSuccess), 7, 0, 4}, nil))
+	tctx.MainLoopSim(time.Second)
+	if supp.smState != EAP_DONE_OK || auth.sessions[supp.Client.Mac].state != DOT1X_AUTH_AUTHENTICATED {
+		t.Fatalf(" supplicant should be authenticated %+v ", *st)
+	}
+
+	// the server does not answer, retransmit and fail
+	supp = addSupplicant(ns, core.MACKey{0, 0, 1, 0, 0, 3}, `{"user": "md5user", "password": "pwd1"}`)
+	tctx.MainLoopSim(5 * time.Second)
+	if st.radiusTxRetransmit != DOT1X_AUTH_MAX_REQ || st.errRadiusTimeout != 1 ||
+		auth.sessions[supp.Client.Mac].state != DOT1X_AUTH_HELD || len(auth.pending) != 0 {
+		t.Fatalf(" session should fail %+v ", *st)
+	}
+	if st.radiusRxChallenge != 1 || st.radiusRxAccept != 1 || st.errUnresolved != 0 || st.errSocket != 0 {
+		t.Fatalf(" unexpected counters %+v ", *st)
+	}
+}
+
+func TestDot1xAuthRadiusIds(t *testing.T) {
+	simVeth := VethDot1xAuthSim{dropAll: true}
+	tctx, ns, _ := createDot1xAuthSimulationEnv(&simVeth, `{"auth_mac": [0, 0, 1, 0, 0, 9],
+		"radius": {"server": [48, 0, 0, 1], "secret": "switch1"}}`)
+	defer tctx.Delete()
+	transport.Register(tctx)
+	auth := getAuthNs(ns)
+	st := &auth.stats
+
+	// the pending ids are skipped
+	other := &dot1xAuthSession{plug: auth}
+	auth.pending[1] = other
+	auth.pending[2] = other
+	sess := &dot1xAuthSession{mac: core.MACKey{0, 0, 1, 0, 0, 2}, state: DOT1X_AUTH_IDENTITY, plug: auth}
+	auth.sendAccessRequest(sess, []byte{byte(layers.EAPCodeResponse), 1, 0, 5, byte(layers.EAPTypeIdentity)})
+	if sess.radiusId != 3 || auth.pending[3] != sess || sess.state != DOT1X_AUTH_RADIUS {
+		t.Fatalf(" unexpected RADIUS id %v ", sess.radiusId)
+	}
+	auth.clearPending(sess)
+	auth.timerw.Stop(&sess.timer)
+
+	// all the ids are pending, the response is dropped
+	for i := 0; i < 256; i++ {
+		auth.pending[uint8(i)] = other
+	}
+	sess = &dot1xAuthSession{mac: core.MACKey{0, 0, 1, 0, 0, 3}, state: DOT1X_AUTH_METHOD, plug: auth}
+	auth.sendAccessRequest(sess, []byte{byte(layers.EAPCodeResponse), 1, 0, 5, byte(layers.EAPTypeIdentity)})
+	if st.errRadiusIdBusy != 1 || st.radiusTxRequest != 1 || sess.state != DOT1X_AUTH_METHOD {
+		t.Fatalf(" Access-Request should be refused %+v ", *st)
+	}
+}
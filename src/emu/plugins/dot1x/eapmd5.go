@@ -6,6 +6,7 @@
 package dot1x
 
 import (
+	"bytes"
 	"crypto/md5"
 )
 
@@ -58,3 +59,38 @@ func NewEapMd5() Dot1xMethodIF {
 	p.r = make([]byte, 0)
 	return p
 }
+
+// EapMd5AuthHandler the authenticator side, a challenge per session
+type EapMd5AuthHandler struct {
+	challenge []byte
+}
+
+func (o *EapMd5AuthHandler) GetName() string {
+	return ("eap-md5")
+}
+
+func (o *EapMd5AuthHandler) BuildReq(d *Dot1xAuthMethodData) []byte {
+	if d.plug.Tctx.Simulation {
+		o.challenge = []byte{8, 7, 6, 5, 4, 3, 2, 1, 8, 7, 6, 5, 4, 3, 2, 1}
+	} else {
+		genChalange16B(&o.challenge)
+	}
+	r := []byte{uint8(len(o.challenge))}
+	return append(r, o.challenge...)
+}
+
+func (o *EapMd5AuthHandler) HandleResp(d *Dot1xAuthMethodData) (bool, bool, []byte) {
+	td := d.eap.TypeData
+	if len(td) < 17 || td[0] != 16 {
+		return true, false, nil
+	}
+	b := []byte{d.eap.Id} //[id,password,challeng]
+	b = append(b, []byte(d.sess.password)...)
+	b = append(b, o.challenge...)
+	r := md5.Sum(b)
+	return true, bytes.Equal(r[:], td[1:17]), nil
+}
+
+func NewEapMd5Auth() Dot1xAuthMethodIF {
+	return new(EapMd5AuthHandler)
+}
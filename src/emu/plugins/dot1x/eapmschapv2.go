@@ -6,6 +6,7 @@
 package dot1x
 
 import (
+	"bytes"
 	"encoding/binary"
 	"strings"
 )
@@ -16,6 +17,8 @@ const (
 	MS_CHAPV2_SUCCESS    = 3
 	MS_CHAPV2_FAILURE    = 4
 	MS_CHAPV2_CHANGE_PWD = 7
+
+	MS_CHAPV2_AUTH_NAME = "trex"
 )
 
 type EapMschapv2Handler struct {
@@ -125,3 +128,55 @@ func NewEapMschapv2() Dot1xMethodIF {
 
 	return p
 }
+
+// EapMschapv2AuthHandler the authenticator side, challenge -> response -> success -> success
+type EapMschapv2AuthHandler struct {
+	challenge      []byte
+	waitForSuccess bool
+}
+
+func (o *EapMschapv2AuthHandler) GetName() string {
+	return ("eap-mschapv2")
+}
+
+// build returns the type data of a request, MS-Length counts from the OpCode
+func (o *EapMschapv2AuthHandler) build(opcode uint8, id uint8, d []byte) []byte {
+	r := []byte{opcode, id, 0, 0}
+	r = append(r, d...)
+	binary.BigEndian.PutUint16(r[2:4], uint16(len(r)))
+	return r
+}
+
+func (o *EapMschapv2AuthHandler) BuildReq(d *Dot1xAuthMethodData) []byte {
+	if d.plug.Tctx.Simulation {
+		o.challenge = []byte{8, 7, 6, 5, 4, 3, 2, 1, 8, 7, 6, 5, 4, 3, 2, 1}
+	} else {
+		genChalange16B(&o.challenge)
+	}
+	v := []byte{uint8(len(o.challenge))}
+	v = append(v, o.challenge...)
+	v = append(v, []byte(MS_CHAPV2_AUTH_NAME)...)
+	return o.build(MS_CHAPV2_CHALLENGE, d.id, v)
+}
+
+func (o *EapMschapv2AuthHandler) HandleResp(d *Dot1xAuthMethodData) (bool, bool, []byte) {
+	b := d.eap.TypeData
+	if o.waitForSuccess {
+		return true, len(b) > 0 && b[0] == MS_CHAPV2_SUCCESS, nil
+	}
+
+	/* [OpCode, MS-CHAPv2-ID, MS-Length(2), Value-Size=49, Peer-Challenge(16), Reserved(8), NT-Response(24), Flags, Name] */
+	if len(b) < 54 || b[0] != MS_CHAPV2_RESPONSE || b[4] != 49 {
+		return true, false, nil
+	}
+	res, e := Encryptv2(o.challenge, b[5:21], string(b[54:]), d.sess.password)
+	if e != nil || !bytes.Equal(res.ChallengeResponse, b[29:53]) {
+		return true, false, nil
+	}
+	o.waitForSuccess = true
+	return false, false, o.build(MS_CHAPV2_SUCCESS, d.id, []byte(res.AuthenticatorResponse+" M=OK"))
+}
+
+func NewEapMschapv2Auth() Dot1xAuthMethodIF {
+	return new(EapMschapv2AuthHandler)
+}
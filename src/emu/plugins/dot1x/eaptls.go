@@ -0,0 +1,661 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package dot1x
+
+/*
+RFC 5216 EAP-TLS, PEAPv0 (draft-kamath-pppext-peapv0) and RFC 5281 EAP-TTLSv0 supplicant methods
+
+The TLS records are carried by the EAP messages, each message has flags (L,M,S and the version) and the fragments are
+acknowledged by an empty message. crypto/tls runs in a goroutine of the session over eapTlsConn, the goroutine is blocked
+on Read while the thread handles the EAP messages so the records are passed in both directions in the order of the EAP exchange.
+
+PEAP and TTLS run the inner EAP-MD5/EAP-MSCHAPv2 of the client (user/password) inside the tunnel, PEAP ends with the
+Result TLV and TTLS carries the inner EAP messages in EAP-Message AVPs.
+TLS 1.2 is used, the version of PEAP/TTLS is 0.
+*/
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"external/google/gopacket/layers"
+)
+
+const (
+	EAP_TYPE_TLS  = 13
+	EAP_TYPE_TTLS = 21
+	EAP_TYPE_PEAP = 25
+	EAP_TYPE_TLV  = 33 // PEAP extensions
+
+	EAP_TLS_FLAG_LEN   = 0x80
+	EAP_TLS_FLAG_MORE  = 0x40
+	EAP_TLS_FLAG_START = 0x20
+	EAP_TLS_VER_MASK   = 0x07
+
+	EAP_TLS_DEF_FRAG_SIZE = 1024
+	EAP_TLS_MAX_MSG_SIZE  = 65536
+
+	PEAP_TLV_RESULT         = 3
+	PEAP_TLV_MANDATORY      = 0x8000
+	PEAP_TLV_RESULT_SUCCESS = 1
+	PEAP_TLV_RESULT_FAILURE = 2
+
+	TTLS_AVP_EAP_MESSAGE = 79
+	TTLS_AVP_FLAG_M      = 0x40
+
+	/* handshake stages */
+	EAP_TLS_STAGE_IDLE        = 0
+	EAP_TLS_STAGE_HANDSHAKE   = 1
+	EAP_TLS_STAGE_ESTABLISHED = 2
+	EAP_TLS_STAGE_INNER       = 3
+	EAP_TLS_STAGE_DONE        = 4
+	EAP_TLS_STAGE_FAILED      = 5
+)
+
+var eapTlsStageName = map[uint8]string{
+	EAP_TLS_STAGE_IDLE:        "idle",
+	EAP_TLS_STAGE_HANDSHAKE:   "handshake",
+	EAP_TLS_STAGE_ESTABLISHED: "established",
+	EAP_TLS_STAGE_INNER:       "inner",
+	EAP_TLS_STAGE_DONE:        "done",
+	EAP_TLS_STAGE_FAILED:      "failed",
+}
+
+var errEapTlsFrag = errors.New("eap-tls fragment is not valid")
+
+type Dot1xTlsCfg struct {
+	Ca         *string `json:"ca"`          // PEM of the CA of the server, the server is not verified without it
+	Cert       *string `json:"cert"`        // PEM of the certificate of the client, required by EAP-TLS
+	Key        *string `json:"key"`         // PEM of the private key of the client
+	ServerName string  `json:"server_name"` // name of the server certificate, only the chain is verified without it
+	FragSize   uint32  `json:"frag_size"`   // max TLS data in an EAP message
+}
+
+// newTlsConfig returns the client configuration, hasCert is true in case there is a client certificate
+func newTlsConfig(c *Dot1xTlsCfg) (cfg *tls.Config, hasCert bool, err error) {
+	cfg = &tls.Config{MinVersion: tls.VersionTLS12, MaxVersion: tls.VersionTLS12}
+	if c.Cert != nil || c.Key != nil {
+		if c.Cert == nil || c.Key == nil {
+			return nil, false, errors.New("cert and key should be given together")
+		}
+		cert, err := tls.X509KeyPair([]byte(*c.Cert), []byte(*c.Key))
+		if err != nil {
+			return nil, false, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+		hasCert = true
+	}
+	if c.Ca == nil {
+		cfg.InsecureSkipVerify = true
+		return cfg, hasCert, nil
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM([]byte(*c.Ca)) {
+		return nil, false, errors.New("ca is not valid")
+	}
+	cfg.RootCAs = roots
+	if c.ServerName != "" {
+		cfg.ServerName = c.ServerName
+		return cfg, hasCert, nil
+	}
+	// verify the chain without the name
+	cfg.InsecureSkipVerify = true
+	cfg.VerifyPeerCertificate = func(raw [][]byte, _ [][]*x509.Certificate) error {
+		opts := x509.VerifyOptions{Roots: roots, Intermediates: x509.NewCertPool()}
+		var leaf *x509.Certificate
+		for i, b := range raw {
+			cert, err := x509.ParseCertificate(b)
+			if err != nil {
+				return err
+			}
+			if i == 0 {
+				leaf = cert
+			} else {
+				opts.Intermediates.AddCert(cert)
+			}
+		}
+		if leaf == nil {
+			return errors.New("no server certificate")
+		}
+		_, err := leaf.Verify(opts)
+		return err
+	}
+	return cfg, hasCert, nil
+}
+
+type eapTlsAddr struct{}
+
+func (a eapTlsAddr) Network() string { return "eap" }
+func (a eapTlsAddr) String() string  { return "eap" }
+
+// eapTlsConn the connection of crypto/tls, Read blocks the goroutine until the thread passes the next records
+type eapTlsConn struct {
+	in   chan []byte
+	wait chan bool // true the goroutine waits for records, false it exited
+	mu   sync.Mutex
+	out  []byte
+	rbuf []byte
+}
+
+func (o *eapTlsConn) Read(b []byte) (int, error) {
+	if len(o.rbuf) == 0 {
+		o.wait <- true
+		d, ok := <-o.in
+		if !ok {
+			return 0, io.EOF
+		}
+		o.rbuf = d
+	}
+	n := copy(b, o.rbuf)
+	o.rbuf = o.rbuf[n:]
+	return n, nil
+}
+
+func (o *eapTlsConn) Write(b []byte) (int, error) {
+	o.mu.Lock()
+	o.out = append(o.out, b...)
+	o.mu.Unlock()
+	return len(b), nil
+}
+
+func (o *eapTlsConn) Close() error                       { return nil }
+func (o *eapTlsConn) LocalAddr() net.Addr                { return eapTlsAddr{} }
+func (o *eapTlsConn) RemoteAddr() net.Addr               { return eapTlsAddr{} }
+func (o *eapTlsConn) SetDeadline(t time.Time) error      { return nil }
+func (o *eapTlsConn) SetReadDeadline(t time.Time) error  { return nil }
+func (o *eapTlsConn) SetWriteDeadline(t time.Time) error { return nil }
+
+func (o *eapTlsConn) takeOut() []byte {
+	o.mu.Lock()
+	out := o.out
+	o.out = nil
+	o.mu.Unlock()
+	return out
+}
+
+// eapTlsEngine runs the TLS session, each call returns the records to send to the peer
+type eapTlsEngine struct {
+	conn        eapTlsConn
+	tls         *tls.Conn
+	tunnel      bool // read the application data after the handshake
+	running     bool
+	established bool
+	state       tls.ConnectionState
+	err         error
+	plain       [][]byte // application data of the peer
+}
+
+func newEapTlsEngine(cfg *tls.Config, server bool, tunnel bool) *eapTlsEngine {
+	o := &eapTlsEngine{tunnel: tunnel}
+	o.conn.in = make(chan []byte)
+	o.conn.wait = make(chan bool)
+	if server {
+		o.tls = tls.Server(&o.conn, cfg)
+	} else {
+		o.tls = tls.Client(&o.conn, cfg)
+	}
+	return o
+}
+
+func (o *eapTlsEngine) run() {
+	err := o.tls.Handshake()
+	if err == nil {
+		o.established = true
+		o.state = o.tls.ConnectionState()
+		buf := make([]byte, 16384)
+		for o.tunnel && err == nil {
+			var n int
+			n, err = o.tls.Read(buf)
+			if n > 0 {
+				o.plain = append(o.plain, append([]byte(nil), buf[:n]...))
+			}
+		}
+	}
+	o.err = err
+	o.conn.wait <- false
+}
+
+func (o *eapTlsEngine) waitForRecords() []byte {
+	if !<-o.conn.wait {
+		o.running = false
+	}
+	return o.conn.takeOut()
+}
+
+// start starts the handshake, the client hello in case of a client
+func (o *eapTlsEngine) start() []byte {
+	o.running = true
+	go o.run()
+	return o.waitForRecords()
+}
+
+// input passes the records of the peer
+func (o *eapTlsEngine) input(d []byte) []byte {
+	if !o.running {
+		return nil
+	}
+	o.conn.in <- d
+	return o.waitForRecords()
+}
+
+// write sends application data in the tunnel
+func (o *eapTlsEngine) write(d []byte) []byte {
+	if !o.established || !o.running {
+		return nil
+	}
+	o.tls.Write(d)
+	return o.conn.takeOut()
+}
+
+// takePlain returns the application data that was read
+func (o *eapTlsEngine) takePlain() [][]byte {
+	p := o.plain
+	o.plain = nil
+	return p
+}
+
+func (o *eapTlsEngine) close() {
+	if o.running {
+		close(o.conn.in)
+		for <-o.conn.wait {
+		}
+		o.running = false
+	}
+}
+
+// eapTlsFramer fragments and reassembles the TLS data of the EAP messages
+type eapTlsFramer struct {
+	ver      uint8
+	fragSize int
+	tx       []byte
+	txFirst  bool
+	rx       []byte
+}
+
+// setTx sets a new message to send
+func (o *eapTlsFramer) setTx(d []byte) {
+	o.tx = d
+	o.txFirst = true
+}
+
+func (o *eapTlsFramer) hasTx() bool {
+	return len(o.tx) > 0
+}
+
+// nextFrag returns the type data of the next fragment, an ack in case there is nothing to send
+func (o *eapTlsFramer) nextFrag() []byte {
+	flags := o.ver
+	n := len(o.tx)
+	if n > o.fragSize {
+		n = o.fragSize
+		flags |= EAP_TLS_FLAG_MORE
+	}
+	r := []byte{flags}
+	if o.txFirst && flags&EAP_TLS_FLAG_MORE != 0 {
+		r[0] |= EAP_TLS_FLAG_LEN
+		r = append(r, 0, 0, 0, 0)
+		binary.BigEndian.PutUint32(r[1:5], uint32(len(o.tx)))
+	}
+	o.txFirst = false
+	r = append(r, o.tx[:n]...)
+	o.tx = o.tx[n:]
+	return r
+}
+
+// rxFrag adds the fragment of the type data, returns the message in case it is the last one, ack is true for an empty message
+func (o *eapTlsFramer) rxFrag(td []byte) (msg []byte, ack bool, err error) {
+	if len(td) < 1 {
+		return nil, false, errEapTlsFrag
+	}
+	flags := td[0]
+	d := td[1:]
+	if flags&EAP_TLS_FLAG_LEN != 0 {
+		if len(d) < 4 || binary.BigEndian.Uint32(d[0:4]) > EAP_TLS_MAX_MSG_SIZE {
+			return nil, false, errEapTlsFrag
+		}
+		d = d[4:]
+	}
+	if len(d) == 0 && flags&EAP_TLS_FLAG_MORE == 0 && len(o.rx) == 0 {
+		return nil, true, nil
+	}
+	if len(o.rx)+len(d) > EAP_TLS_MAX_MSG_SIZE {
+		o.rx = nil
+		return nil, false, errEapTlsFrag
+	}
+	o.rx = append(o.rx, d...)
+	if flags&EAP_TLS_FLAG_MORE != 0 {
+		return nil, false, nil
+	}
+	msg = o.rx
+	o.rx = nil
+	return msg, false, nil
+}
+
+// EapTlsHandler EAP-TLS, PEAP and TTLS supplicant
+type EapTlsHandler struct {
+	t      uint8
+	cfg    *tls.Config
+	engine *eapTlsEngine
+	framer eapTlsFramer
+	stage  uint8
+	finish bool
+	errMsg string
+	inner  MethodToHandler
+}
+
+// eapTlsInnerNak the inner methods of PEAP/TTLS
+var eapTlsInnerNak = []byte{EAP_TYPE_MSCHAPV2, EAP_TYPE_MD5}
+
+func (o *EapTlsHandler) GetName() string {
+	switch o.t {
+	case EAP_TYPE_PEAP:
+		return "eap-peap"
+	case EAP_TYPE_TTLS:
+		return "eap-ttls"
+	}
+	return "eap-tls"
+}
+
+func (o *EapTlsHandler) reset() {
+	if o.engine != nil {
+		o.engine.close()
+	}
+	o.engine = newEapTlsEngine(o.cfg, false, o.t != EAP_TYPE_TLS)
+	o.framer.ver = 0
+	o.framer.rx = nil
+	o.framer.tx = nil
+	o.stage = EAP_TLS_STAGE_HANDSHAKE
+	o.finish = false
+	o.errMsg = ""
+	o.inner = MethodToHandler{EAP_TYPE_MD5: NewEapMd5(), EAP_TYPE_MSCHAPV2: NewEapMschapv2()}
+}
+
+// failed answers with an empty EAP-TLS response, the authenticator fails the method (a Nak is valid only for the first request)
+func (o *EapTlsHandler) failed(d *Dot1xMethodData, err error) (bool, bool, []byte) {
+	d.plug.stats.pktTlsErr++
+	o.stage = EAP_TLS_STAGE_FAILED
+	o.errMsg = err.Error()
+	o.finish = true
+	o.framer.tx = nil
+	return true, true, []byte{o.framer.ver}
+}
+
+// send returns the first fragment of the records
+func (o *EapTlsHandler) send(d *Dot1xMethodData, out []byte) (bool, bool, []byte) {
+	o.framer.setTx(out)
+	return o.sendFrag(d)
+}
+
+func (o *EapTlsHandler) sendFrag(d *Dot1xMethodData) (bool, bool, []byte) {
+	if o.framer.hasTx() {
+		d.plug.stats.pktTlsTxFrag++
+	}
+	r := o.framer.nextFrag()
+	return true, o.finish && !o.framer.hasTx(), r
+}
+
+func (o *EapTlsHandler) BuildResp(d *Dot1xMethodData) (bool, bool, []byte) {
+	td := d.eap.TypeData
+	if n := int(d.eap.Length) - EAPSIZE_PKT_HEADER; n >= 0 && n < len(td) {
+		td = td[:n]
+	}
+	if len(td) < 1 {
+		d.plug.stats.pktMethodWrongLen++
+		return false, false, []byte{}
+	}
+
+	if td[0]&EAP_TLS_FLAG_START != 0 {
+		o.reset()
+		return o.send(d, o.engine.start())
+	}
+	if o.engine == nil || o.stage == EAP_TLS_STAGE_FAILED {
+		d.plug.stats.pktMethodWrongstate++
+		return false, false, []byte{}
+	}
+
+	msg, ack, err := o.framer.rxFrag(td)
+	if err != nil {
+		return o.failed(d, err)
+	}
+	if ack {
+		return o.sendFrag(d)
+	}
+	if msg == nil {
+		// more fragments
+		d.plug.stats.pktTlsRxFrag++
+		return true, false, []byte{o.framer.ver}
+	}
+
+	wasEstablished := o.engine.established
+	out := o.engine.input(msg)
+	if !o.engine.established {
+		if o.engine.err != nil {
+			if len(out) == 0 {
+				return o.failed(d, o.engine.err)
+			}
+			// the alert is sent to the server
+			o.stage = EAP_TLS_STAGE_FAILED
+			o.errMsg = o.engine.err.Error()
+			o.finish = true
+			d.plug.stats.pktTlsErr++
+		}
+		return o.send(d, out)
+	}
+
+	if !wasEstablished {
+		o.stage = EAP_TLS_STAGE_ESTABLISHED
+		switch o.t {
+		case EAP_TYPE_TLS:
+			o.stage = EAP_TLS_STAGE_DONE
+			o.finish = true
+		case EAP_TYPE_TTLS:
+			// the client starts the inner authentication
+			o.stage = EAP_TLS_STAGE_INNER
+			id := o.identity(d, 0)
+			out = append(out, o.engine.write(ttlsAvp(TTLS_AVP_EAP_MESSAGE, id))...)
+		}
+	}
+	for _, p := range o.engine.takePlain() {
+		if o.t == EAP_TYPE_PEAP {
+			out = append(out, o.handlePeap(d, p)...)
+		} else {
+			out = append(out, o.handleTtls(d, p)...)
+		}
+	}
+	return o.send(d, out)
+}
+
+// identity returns the inner EAP-Response/Identity
+func (o *EapTlsHandler) identity(d *Dot1xMethodData, id uint8) []byte {
+	return eapResponse(id, uint8(layers.EAPTypeIdentity), []byte(*d.plug.cfg.User))
+}
+
+func eapResponse(id uint8, t uint8, data []byte) []byte {
+	r := []byte{byte(layers.EAPCodeResponse), id, 0, 0, t}
+	r = append(r, data...)
+	binary.BigEndian.PutUint16(r[2:4], uint16(len(r)))
+	return r
+}
+
+// handleInner returns the response to the inner EAP packet, nil in case there is no response
+func (o *EapTlsHandler) handleInner(d *Dot1xMethodData, p []byte) []byte {
+	if len(p) < 4 || int(binary.BigEndian.Uint16(p[2:4])) > len(p) {
+		d.plug.stats.pktMethodWrongLen++
+		return nil
+	}
+	p = p[:binary.BigEndian.Uint16(p[2:4])]
+	switch layers.EAPCode(p[0]) {
+	case layers.EAPCodeSuccess, layers.EAPCodeFailure:
+		o.finish = true
+		o.stage = EAP_TLS_STAGE_DONE
+		return nil
+	case layers.EAPCodeRequest:
+	default:
+		return nil
+	}
+	if len(p) < 5 {
+		d.plug.stats.pktMethodWrongLen++
+		return nil
+	}
+	o.stage = EAP_TLS_STAGE_INNER
+	id := p[1]
+	t := p[4]
+	switch t {
+	case uint8(layers.EAPTypeIdentity):
+		return o.identity(d, id)
+	case EAP_TYPE_TLV:
+		return o.handleTlv(d, id, p[5:])
+	}
+	h, ok := o.inner[t]
+	if !ok {
+		return eapResponse(id, uint8(layers.EAPTypeNACK), eapTlsInnerNak)
+	}
+	eap := layers.EAP{Code: layers.EAPCodeRequest, Id: id, Length: uint16(len(p)), Type: layers.EAPType(t), TypeData: p[5:]}
+	inner := Dot1xMethodData{plug: d.plug, eap: &eap}
+	ok, finish, res := h.BuildResp(&inner)
+	if !ok {
+		return eapResponse(id, uint8(layers.EAPTypeNACK), eapTlsInnerNak)
+	}
+	if finish && o.t == EAP_TYPE_TTLS {
+		// TTLS has no result inside the tunnel
+		o.finish = true
+		o.stage = EAP_TLS_STAGE_DONE
+	}
+	return eapResponse(id, t, res)
+}
+
+// handleTlv answers the Result TLV of PEAP with the same result
+func (o *EapTlsHandler) handleTlv(d *Dot1xMethodData, id uint8, b []byte) []byte {
+	for len(b) >= 4 {
+		t := binary.BigEndian.Uint16(b[0:2]) &^ PEAP_TLV_MANDATORY
+		l := int(binary.BigEndian.Uint16(b[2:4]))
+		if 4+l > len(b) {
+			break
+		}
+		if t == PEAP_TLV_RESULT && l == 2 {
+			res := b[4:6]
+			o.finish = true
+			o.stage = EAP_TLS_STAGE_DONE
+			if binary.BigEndian.Uint16(res) != PEAP_TLV_RESULT_SUCCESS {
+				o.stage = EAP_TLS_STAGE_FAILED
+				o.errMsg = "peap result failure"
+			}
+			tlv := []byte{PEAP_TLV_MANDATORY >> 8, PEAP_TLV_RESULT, 0, 2}
+			return eapResponse(id, EAP_TYPE_TLV, append(tlv, res...))
+		}
+		b = b[4+l:]
+	}
+	d.plug.stats.pktMethodWrongLen++
+	return nil
+}
+
+// handlePeap PEAPv0 omits the EAP header inside the tunnel except for the TLV packets
+func (o *EapTlsHandler) handlePeap(d *Dot1xMethodData, p []byte) []byte {
+	full := len(p) >= 5 && p[0] == byte(layers.EAPCodeRequest) &&
+		int(binary.BigEndian.Uint16(p[2:4])) == len(p) && p[4] == EAP_TYPE_TLV
+	if !full {
+		h := []byte{byte(layers.EAPCodeRequest), d.eap.Id, 0, 0}
+		binary.BigEndian.PutUint16(h[2:4], uint16(len(p)+4))
+		p = append(h, p...)
+	}
+	r := o.handleInner(d, p)
+	if r == nil {
+		return nil
+	}
+	if !full {
+		r = r[4:]
+	}
+	return o.engine.write(r)
+}
+
+// handleTtls the inner EAP packets are in EAP-Message AVPs
+func (o *EapTlsHandler) handleTtls(d *Dot1xMethodData, p []byte) []byte {
+	eap := ttlsGetAvp(p, TTLS_AVP_EAP_MESSAGE)
+	if eap == nil {
+		d.plug.stats.pktMethodWrongLen++
+		return nil
+	}
+	r := o.handleInner(d, eap)
+	if r == nil {
+		return nil
+	}
+	return o.engine.write(ttlsAvp(TTLS_AVP_EAP_MESSAGE, r))
+}
+
+// ttlsAvp returns a Diameter AVP, padded to 4 bytes
+func ttlsAvp(code uint32, data []byte) []byte {
+	b := make([]byte, 8, 8+len(data)+3)
+	binary.BigEndian.PutUint32(b[0:4], code)
+	binary.BigEndian.PutUint32(b[4:8], uint32(8+len(data)))
+	b[4] = TTLS_AVP_FLAG_M
+	b = append(b, data...)
+	for len(b)%4 != 0 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+// ttlsGetAvp returns the concatenated data of the AVPs of code, nil in case there isn't one
+func ttlsGetAvp(b []byte, code uint32) []byte {
+	var r []byte
+	for len(b) >= 8 {
+		l := int(binary.BigEndian.Uint32(b[4:8]) & 0xffffff)
+		hdr := 8
+		if b[4]&0x80 != 0 {
+			hdr = 12 // vendor id
+		}
+		if l < hdr || l > len(b) {
+			return nil
+		}
+		if binary.BigEndian.Uint32(b[0:4]) == code {
+			r = append(r, b[hdr:l]...)
+		}
+		l = (l + 3) &^ 3
+		if l > len(b) {
+			break
+		}
+		b = b[l:]
+	}
+	return r
+}
+
+func (o *EapTlsHandler) Success(d *Dot1xMethodData) bool {
+	return o.stage != EAP_TLS_STAGE_FAILED
+}
+
+func (o *EapTlsHandler) OnRemove() {
+	if o.engine != nil {
+		o.engine.close()
+		o.engine = nil
+	}
+}
+
+// getInfo returns the stage, version, cipher and the last error of the session
+func (o *EapTlsHandler) getInfo(info *Dot1xClientInfo) {
+	info.TlsStage = eapTlsStageName[o.stage]
+	info.TlsErr = o.errMsg
+	if o.engine != nil && o.engine.established {
+		info.TlsVersion = o.engine.state.Version
+		info.TlsCipher = tls.CipherSuiteName(o.engine.state.CipherSuite)
+	}
+}
+
+func NewEapTls(t uint8, cfg *tls.Config, fragSize uint32) Dot1xMethodIF {
+	p := new(EapTlsHandler)
+	p.t = t
+	p.cfg = cfg
+	p.framer.fragSize = int(fragSize)
+	if p.framer.fragSize == 0 {
+		p.framer.fragSize = EAP_TLS_DEF_FRAG_SIZE
+	}
+	return p
+}
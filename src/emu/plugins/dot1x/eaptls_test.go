@@ -0,0 +1,393 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package dot1x
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"emu/core"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/pem"
+	"external/google/gopacket/layers"
+	"math/big"
+	"testing"
+	"time"
+)
+
+type eapTlsTestPki struct {
+	caPem   string
+	roots   *x509.CertPool
+	server  tls.Certificate
+	certPem string
+	keyPem  string
+}
+
+func genTestCert(t *testing.T, tmpl *x509.Certificate, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey, string, string) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if parent == nil {
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatalf(" can't create certificate %v ", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	kder, _ := x509.MarshalECPrivateKey(key)
+	return cert, key,
+		string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: kder}))
+}
+
+func genTestPki(t *testing.T) *eapTlsTestPki {
+	o := new(eapTlsTestPki)
+	now := time.Now()
+	ca, caKey, caPem, _ := genTestCert(t, &x509.Certificate{SerialNumber: big.NewInt(1),
+		Subject: pkix.Name{CommonName: "trex-ca"}, NotBefore: now.Add(-time.Hour), NotAfter: now.Add(time.Hour),
+		IsCA: true, BasicConstraintsValid: true, KeyUsage: x509.KeyUsageCertSign}, nil, nil)
+	o.caPem = caPem
+	o.roots = x509.NewCertPool()
+	o.roots.AddCert(ca)
+
+	_, _, srvPem, srvKeyPem := genTestCert(t, &x509.Certificate{SerialNumber: big.NewInt(2),
+		Subject: pkix.Name{CommonName: "radius.trex"}, DNSNames: []string{"radius.trex"},
+		NotBefore: now.Add(-time.Hour), NotAfter: now.Add(time.Hour),
+		KeyUsage: x509.KeyUsageDigitalSignature, ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}}, ca, caKey)
+	o.server, _ = tls.X509KeyPair([]byte(srvPem), []byte(srvKeyPem))
+
+	_, _, o.certPem, o.keyPem = genTestCert(t, &x509.Certificate{SerialNumber: big.NewInt(3),
+		Subject: pkix.Name{CommonName: "hhaim"}, NotBefore: now.Add(-time.Hour), NotAfter: now.Add(time.Hour),
+		KeyUsage: x509.KeyUsageDigitalSignature, ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}, ca, caKey)
+	return o
+}
+
+// VethEapTlsSim the EAP server of one supplicant, answers each frame of the supplicant
+type VethEapTlsSim struct {
+	t         uint8
+	tctx      *core.CThreadCtx
+	cfg       *tls.Config
+	id        uint8
+	engine    *eapTlsEngine
+	framer    eapTlsFramer
+	auth      PluginDot1xAuthNs // context of the inner methods
+	sess      dot1xAuthSession
+	inner     Dot1xAuthMethodIF
+	innerId   uint8
+	peapStage int
+	done      bool
+	badFrag   bool  // answer the client hello with a fragment that is not valid
+	failType  uint8 // type of the response to the bad fragment
+}
+
+func (o *VethEapTlsSim) reply(p []byte, code layers.EAPCode, t uint8, d []byte) *core.Mbuf {
+	pkt := append([]byte(nil), p[6:12]...)
+	pkt = append(pkt, 0, 0, 1, 0, 0, 0x10)
+	pkt = append(pkt, p[12:22]...)
+	eap := []byte{byte(code), o.id, 0, 0}
+	if code == layers.EAPCodeRequest {
+		eap = append(eap, t)
+		eap = append(eap, d...)
+	}
+	binary.BigEndian.PutUint16(eap[2:4], uint16(len(eap)))
+	pkt = append(pkt, 2, 0, 0, 0)
+	binary.BigEndian.PutUint16(pkt[len(pkt)-2:], uint16(len(eap)))
+	pkt = append(pkt, eap...)
+	return genMbuf(o.tctx, pkt)
+}
+
+func (o *VethEapTlsSim) tlsRequest(p []byte, out []byte) *core.Mbuf {
+	o.id++
+	if out != nil {
+		o.framer.setTx(out)
+	}
+	return o.reply(p, layers.EAPCodeRequest, o.t, o.framer.nextFrag())
+}
+
+func (o *VethEapTlsSim) result(p []byte) *core.Mbuf {
+	if o.done {
+		return o.reply(p, layers.EAPCodeSuccess, 0, nil)
+	}
+	return o.reply(p, layers.EAPCodeFailure, 0, nil)
+}
+
+// innerResp returns the next inner request, sets done at the end
+func (o *VethEapTlsSim) innerResp(eap []byte) []byte {
+	if len(eap) < 5 {
+		return nil
+	}
+	e := layers.EAP{Code: layers.EAPCode(eap[0]), Id: eap[1], Length: uint16(len(eap)), Type: layers.EAPType(eap[4]), TypeData: eap[5:]}
+	d := Dot1xAuthMethodData{plug: &o.auth, sess: &o.sess, eap: &e, id: o.innerId + 1}
+	switch {
+	case e.Type == layers.EAPTypeIdentity:
+		if o.t == EAP_TYPE_PEAP {
+			o.inner = NewEapMschapv2Auth()
+		} else {
+			o.inner = NewEapMd5Auth()
+		}
+		o.innerId++
+		return append([]byte{uint8(o.innerType())}, o.inner.BuildReq(&d)...)
+	case e.Type == layers.EAPType(EAP_TYPE_TLV):
+		o.done = len(eap) == 11 && eap[10] == PEAP_TLV_RESULT_SUCCESS
+		return nil
+	}
+	finish, ok, next := o.inner.HandleResp(&d)
+	o.innerId++
+	if !finish {
+		return append([]byte{uint8(o.innerType())}, next...)
+	}
+	if o.t == EAP_TYPE_PEAP {
+		// the result TLV with the full header
+		return []byte{byte(layers.EAPCodeRequest), o.innerId, 0, 11, EAP_TYPE_TLV, 0x80, PEAP_TLV_RESULT, 0, 2, 0, PEAP_TLV_RESULT_SUCCESS}
+	}
+	o.done = ok
+	return nil
+}
+
+func (o *VethEapTlsSim) innerType() uint8 {
+	if o.t == EAP_TYPE_PEAP {
+		return EAP_TYPE_MSCHAPV2
+	}
+	return EAP_TYPE_MD5
+}
+
+// tunnel handles the application data of the client, returns the records of the reply
+func (o *VethEapTlsSim) tunnel(plain []byte) []byte {
+	if o.t == EAP_TYPE_PEAP {
+		full := len(plain) >= 5 && plain[4] == EAP_TYPE_TLV
+		if !full {
+			plain = append([]byte{byte(layers.EAPCodeResponse), o.id, 0, 0}, plain...)
+			binary.BigEndian.PutUint16(plain[2:4], uint16(len(plain)))
+		}
+		r := o.innerResp(plain)
+		if r == nil {
+			return nil
+		}
+		return o.engine.write(r)
+	}
+	r := o.innerResp(ttlsGetAvp(plain, TTLS_AVP_EAP_MESSAGE))
+	if r == nil {
+		return nil
+	}
+	req := []byte{byte(layers.EAPCodeRequest), o.innerId, 0, 0}
+	req = append(req, r...)
+	binary.BigEndian.PutUint16(req[2:4], uint16(len(req)))
+	return o.engine.write(ttlsAvp(TTLS_AVP_EAP_MESSAGE, req))
+}
+
+func (o *VethEapTlsSim) handle(p []byte) *core.Mbuf {
+	if p[23] == byte(layers.EAPOLTypeStart) {
+		o.id++
+		return o.reply(p, layers.EAPCodeRequest, uint8(layers.EAPTypeIdentity), nil)
+	}
+	if len(p) < 31 || p[26] != byte(layers.EAPCodeResponse) {
+		return nil
+	}
+	l := binary.BigEndian.Uint16(p[28:30])
+	t := p[30]
+	td := p[31 : 26+l]
+	if t == uint8(layers.EAPTypeIdentity) {
+		o.engine = newEapTlsEngine(o.cfg, true, o.t != EAP_TYPE_TLS)
+		o.engine.start()
+		o.id++
+		return o.reply(p, layers.EAPCodeRequest, o.t, []byte{EAP_TLS_FLAG_START})
+	}
+	if o.failType != 0 {
+		return o.result(p)
+	}
+	if o.badFrag && o.framer.rx == nil && !o.framer.hasTx() && len(td) > 1 {
+		o.failType = t
+		o.id++
+		return o.reply(p, layers.EAPCodeRequest, o.t, []byte{EAP_TLS_FLAG_LEN})
+	}
+	msg, ack, err := o.framer.rxFrag(td)
+	if err != nil || t != o.t {
+		return o.result(p)
+	}
+	if msg == nil && !ack {
+		return o.tlsRequest(p, nil)
+	}
+	if ack && o.framer.hasTx() {
+		return o.tlsRequest(p, nil)
+	}
+	var out []byte
+	if !ack {
+		out = o.engine.input(msg)
+		if !o.engine.established {
+			if o.engine.err != nil {
+				return o.result(p)
+			}
+			return o.tlsRequest(p, out)
+		}
+		for _, plain := range o.engine.takePlain() {
+			out = append(out, o.tunnel(plain)...)
+		}
+	}
+	if len(out) > 0 {
+		return o.tlsRequest(p, out)
+	}
+	switch {
+	case o.t == EAP_TYPE_TLS:
+		o.done = true
+	case o.t == EAP_TYPE_PEAP && o.peapStage == 0:
+		// the identity request without the header
+		o.peapStage = 1
+		return o.tlsRequest(p, o.engine.write([]byte{uint8(layers.EAPTypeIdentity)}))
+	}
+	return o.result(p)
+}
+
+func (o *VethEapTlsSim) ProcessTxToRx(m *core.Mbuf) *core.Mbuf {
+	p := m.GetData()
+	var r *core.Mbuf
+	if len(p) >= 26 {
+		r = o.handle(p)
+	}
+	m.FreeMbuf()
+	return r
+}
+
+func createEapTlsEnv(t *testing.T, sim *VethEapTlsSim, cfg map[string]interface{}) (*core.CThreadCtx, *PluginDot1xClient) {
+	var simrx core.VethIFSim = sim
+	tctx := core.NewThreadCtx(0, 4510, true, &simrx)
+	sim.tctx = tctx
+	sim.auth.Tctx = tctx
+	sim.sess.password = "pwd1"
+	sim.framer.fragSize = 300
+	var key core.CTunnelKey
+	key.Set(&core.CTunnelData{Vport: 1, Vlans: [2]uint32{0x81000001, 0x81000002}})
+	ns := core.NewNSCtx(tctx, &key)
+	tctx.AddNs(&key, ns)
+	Register(tctx)
+
+	cfg["user"] = "hhaim"
+	cfg["password"] = "pwd1"
+	initJson, _ := json.Marshal(cfg)
+	c := core.NewClient(ns, core.MACKey{0, 0, 1, 0, 0, 2}, core.Ipv4Key{}, core.Ipv6Key{}, core.Ipv4Key{})
+	ns.AddClient(c)
+	c.PluginCtx.CreatePlugins([]string{"dot1x"}, [][]byte{initJson})
+	return tctx, c.PluginCtx.Get(DOT1X_PLUG).Ext.(*PluginDot1xClient)
+}
+
+func getDot1xInfo(plug *PluginDot1xClient) Dot1xClientInfo {
+	var info Dot1xClientInfo
+	if h, ok := plug.mapHandler[plug.selectedMethod].(*EapTlsHandler); ok {
+		h.getInfo(&info)
+	}
+	return info
+}
+
+func TestEapTlsMethods(t *testing.T) {
+	pki := genTestPki(t)
+	srvCfg := &tls.Config{Certificates: []tls.Certificate{pki.server}, MaxVersion: tls.VersionTLS12}
+	tlsCfg := map[string]interface{}{"ca": pki.caPem, "server_name": "radius.trex", "frag_size": 200}
+
+	tests := []struct {
+		t     uint8
+		tls   map[string]interface{}
+		state uint8
+		stage string
+	}{
+		{EAP_TYPE_TLS, map[string]interface{}{"ca": pki.caPem, "cert": pki.certPem, "key": pki.keyPem, "frag_size": 200}, EAP_DONE_OK, "done"},
+		{EAP_TYPE_PEAP, tlsCfg, EAP_DONE_OK, "done"},
+		{EAP_TYPE_TTLS, tlsCfg, EAP_DONE_OK, "done"},
+		{EAP_TYPE_PEAP, map[string]interface{}{"ca": pki.caPem, "server_name": "other.trex"}, EAP_DONE_FAIL, "failed"},
+	}
+	for _, test := range tests {
+		cfg := srvCfg.Clone()
+		if test.t == EAP_TYPE_TLS {
+			cfg.ClientAuth = tls.RequireAndVerifyClientCert
+			cfg.ClientCAs = pki.roots
+		}
+		sim := &VethEapTlsSim{t: test.t, cfg: cfg}
+		tctx, plug := createEapTlsEnv(t, sim, map[string]interface{}{"tls": test.tls})
+		tctx.MainLoopSim(2 * time.Second)
+
+		info := getDot1xInfo(plug)
+		if plug.smState != test.state || plug.selectedMethod != test.t || info.TlsStage != test.stage {
+			t.Fatalf(" method %v state %v info %+v ", test.t, plug.smState, info)
+		}
+		if test.state == EAP_DONE_OK {
+			if info.TlsVersion != tls.VersionTLS12 || info.TlsCipher == "" ||
+				plug.stats.pktTlsTxFrag == 0 || plug.stats.pktTlsRxFrag == 0 || plug.stats.pktTlsErr != 0 {
+				t.Fatalf(" method %v info %+v stats %+v ", test.t, info, plug.stats)
+			}
+		} else if info.TlsErr == "" || plug.stats.pktTlsErr != 1 {
+			t.Fatalf(" method %v should fail %+v ", test.t, info)
+		}
+		tctx.Delete()
+		sim.engine.close()
+	}
+}
+
+func TestEapTlsBadFrag(t *testing.T) {
+	pki := genTestPki(t)
+	sim := &VethEapTlsSim{t: EAP_TYPE_PEAP, cfg: &tls.Config{Certificates: []tls.Certificate{pki.server}}, badFrag: true}
+	tctx, plug := createEapTlsEnv(t, sim, map[string]interface{}{"tls": map[string]interface{}{"ca": pki.caPem}})
+	defer tctx.Delete()
+	tctx.MainLoopSim(2 * time.Second)
+	sim.engine.close()
+
+	// the method is failed by the server, not a Nak in the middle of the method
+	info := getDot1xInfo(plug)
+	if sim.failType != EAP_TYPE_PEAP || plug.stats.pktTxNack != 0 || plug.stats.pktTlsErr != 1 ||
+		plug.smState != EAP_DONE_FAIL || info.TlsStage != "failed" {
+		t.Fatalf(" response %v state %v info %+v stats %+v ", sim.failType, plug.smState, info, plug.stats)
+	}
+}
+
+func TestEapTlsDisabledMethods(t *testing.T) {
+	pki := genTestPki(t)
+	tlsCfg := map[string]interface{}{"ca": pki.caPem, "cert": pki.certPem, "key": pki.keyPem}
+	// flags disables only EAP-MD5 and EAP-MSCHAPv2
+	sim := &VethEapTlsSim{t: EAP_TYPE_TLS}
+	tctx, plug := createEapTlsEnv(t, sim, map[string]interface{}{"tls": tlsCfg, "flags": EAP_MD5_MASK | EAP_TLS_MASK,
+		"disabled_methods": EAP_PEAP_MASK})
+	defer tctx.Delete()
+	for _, m := range []uint8{EAP_TYPE_MD5, EAP_TYPE_PEAP} {
+		if _, ok := plug.mapHandler[m]; ok {
+			t.Fatalf(" method %v should be disabled ", m)
+		}
+	}
+	for _, m := range []uint8{EAP_TYPE_MSCHAPV2, EAP_TYPE_TLS, EAP_TYPE_TTLS} {
+		if _, ok := plug.mapHandler[m]; !ok {
+			t.Fatalf(" method %v should be enabled ", m)
+		}
+	}
+}
+
+func TestEapTlsFramer(t *testing.T) {
+	tx := eapTlsFramer{fragSize: 4}
+	var rx eapTlsFramer
+	tx.setTx([]byte{1, 2, 3, 4, 5, 6, 7, 8, 9})
+	var msg []byte
+	for i := 0; tx.hasTx(); i++ {
+		f := tx.nextFrag()
+		if i == 0 && (f[0] != EAP_TLS_FLAG_LEN|EAP_TLS_FLAG_MORE || binary.BigEndian.Uint32(f[1:5]) != 9) {
+			t.Fatalf(" first fragment is not valid %v ", f)
+		}
+		var ack bool
+		var err error
+		msg, ack, err = rx.rxFrag(f)
+		if err != nil || ack || (msg != nil) != !tx.hasTx() {
+			t.Fatalf(" fragment %d is not valid %v ", i, f)
+		}
+	}
+	if len(msg) != 9 || msg[8] != 9 {
+		t.Fatalf(" message is not valid %v ", msg)
+	}
+	if _, ack, _ := rx.rxFrag(tx.nextFrag()); !ack {
+		t.Fatalf(" expected an ack ")
+	}
+
+	avp := ttlsAvp(TTLS_AVP_EAP_MESSAGE, []byte{2, 1, 0, 5, 1})
+	if len(avp) != 16 || string(ttlsGetAvp(append(avp, avp...), TTLS_AVP_EAP_MESSAGE)) != string([]byte{2, 1, 0, 5, 1, 2, 1, 0, 5, 1}) {
+		t.Fatalf(" avp is not valid %v ", avp)
+	}
+}
@@ -0,0 +1,161 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package dot1x
+
+/*
+RFC 2865/3579 RADIUS client, the minimum that is required to pass EAP to a RADIUS server
+*/
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+)
+
+const (
+	RADIUS_PORT         = 1812
+	RADIUS_HEADER_SIZE  = 20
+	RADIUS_MAX_PKT_SIZE = 4096
+	RADIUS_MAX_ATTR_LEN = 253
+
+	/* codes */
+	RADIUS_ACCESS_REQUEST   = 1
+	RADIUS_ACCESS_ACCEPT    = 2
+	RADIUS_ACCESS_REJECT    = 3
+	RADIUS_ACCESS_CHALLENGE = 11
+
+	/* attributes */
+	RADIUS_ATTR_USER_NAME             = 1
+	RADIUS_ATTR_NAS_IP_ADDRESS        = 4
+	RADIUS_ATTR_FRAMED_MTU            = 12
+	RADIUS_ATTR_STATE                 = 24
+	RADIUS_ATTR_CALLING_STATION_ID    = 31
+	RADIUS_ATTR_NAS_IDENTIFIER        = 32
+	RADIUS_ATTR_NAS_PORT_TYPE         = 61
+	RADIUS_ATTR_EAP_MESSAGE           = 79
+	RADIUS_ATTR_MESSAGE_AUTHENTICATOR = 80
+
+	RADIUS_NAS_PORT_TYPE_ETHERNET = 15
+)
+
+type radiusAttr struct {
+	t uint8
+	v []byte
+}
+
+type radiusPacket struct {
+	code  uint8
+	id    uint8
+	auth  []byte // 16 bytes
+	attrs []radiusAttr
+}
+
+// add adds an attribute, a long value is split into several attributes (e.g. EAP-Message)
+func (o *radiusPacket) add(t uint8, v []byte) {
+	for {
+		n := len(v)
+		if n > RADIUS_MAX_ATTR_LEN {
+			n = RADIUS_MAX_ATTR_LEN
+		}
+		o.attrs = append(o.attrs, radiusAttr{t: t, v: v[:n]})
+		v = v[n:]
+		if len(v) == 0 {
+			return
+		}
+	}
+}
+
+// get returns the concatenated value of the attributes of type t, nil in case there isn't one
+func (o *radiusPacket) get(t uint8) []byte {
+	var v []byte
+	for _, a := range o.attrs {
+		if a.t == t {
+			v = append(v, a.v...)
+		}
+	}
+	return v
+}
+
+func (o *radiusPacket) has(t uint8) bool {
+	for _, a := range o.attrs {
+		if a.t == t {
+			return true
+		}
+	}
+	return false
+}
+
+// encode returns the packet, the Message-Authenticator is calculated in case it exists (zero value)
+func (o *radiusPacket) encode(secret string) []byte {
+	b := make([]byte, RADIUS_HEADER_SIZE)
+	b[0] = o.code
+	b[1] = o.id
+	copy(b[4:20], o.auth)
+	ma := -1
+	for _, a := range o.attrs {
+		if a.t == RADIUS_ATTR_MESSAGE_AUTHENTICATOR {
+			ma = len(b) + 2
+		}
+		b = append(b, a.t, uint8(len(a.v)+2))
+		b = append(b, a.v...)
+	}
+	binary.BigEndian.PutUint16(b[2:4], uint16(len(b)))
+	if ma >= 0 {
+		copy(b[ma:ma+16], radiusMessageAuth(b, secret))
+	}
+	return b
+}
+
+func radiusMessageAuth(b []byte, secret string) []byte {
+	mac := hmac.New(md5.New, []byte(secret))
+	mac.Write(b)
+	return mac.Sum(nil)
+}
+
+func decodeRadius(b []byte) (*radiusPacket, error) {
+	if len(b) < RADIUS_HEADER_SIZE {
+		return nil, errors.New("radius packet is too short")
+	}
+	l := int(binary.BigEndian.Uint16(b[2:4]))
+	if l < RADIUS_HEADER_SIZE || l > len(b) || l > RADIUS_MAX_PKT_SIZE {
+		return nil, errors.New("radius length is not valid")
+	}
+	o := &radiusPacket{code: b[0], id: b[1], auth: b[4:20]}
+	p := b[RADIUS_HEADER_SIZE:l]
+	for len(p) > 0 {
+		if len(p) < 2 || p[1] < 2 || int(p[1]) > len(p) {
+			return nil, errors.New("radius attribute is not valid")
+		}
+		o.attrs = append(o.attrs, radiusAttr{t: p[0], v: p[2:p[1]]})
+		p = p[p[1]:]
+	}
+	return o, nil
+}
+
+// verifyRadiusResponse verifies the Response Authenticator and the Message-Authenticator of the response, reqAuth is the authenticator of the request
+func verifyRadiusResponse(b []byte, reqAuth []byte, secret string) bool {
+	l := int(binary.BigEndian.Uint16(b[2:4]))
+	d := append([]byte(nil), b[:l]...)
+	copy(d[4:20], reqAuth)
+	h := md5.New()
+	h.Write(d)
+	h.Write([]byte(secret))
+	if !bytes.Equal(h.Sum(nil), b[4:20]) {
+		return false
+	}
+	for p := d[RADIUS_HEADER_SIZE:]; len(p) >= 2 && p[1] >= 2 && int(p[1]) <= len(p); p = p[p[1]:] {
+		if p[0] == RADIUS_ATTR_MESSAGE_AUTHENTICATOR && p[1] == 18 {
+			ma := append([]byte(nil), p[2:18]...)
+			for i := 2; i < 18; i++ {
+				p[i] = 0
+			}
+			return hmac.Equal(ma, radiusMessageAuth(d, secret))
+		}
+	}
+	return true
+}
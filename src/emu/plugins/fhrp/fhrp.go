@@ -0,0 +1,210 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package fhrp
+
+/*
+first hop redundancy protocols: VRRP v2/v3 (RFC 3768, RFC 5798) and HSRP v1/v2 (RFC 2281).
+
+Each emulated router is a client plugin of one group, the namespace plugin dispatches the received adverts/hellos
+by group. The master/active router owns the virtual IPs in the namespace with the virtual MAC (core virtual
+addresses), so the arp/ipv6 plugins answer ARP/ND for them, and announces them with gratuitous ARP/unsolicited NA.
+
+*/
+
+import (
+	"bytes"
+	"emu/core"
+	"encoding/binary"
+	"external/google/gopacket/layers"
+	"net"
+)
+
+const (
+	IPV4_HEADER_SIZE = 20
+	IPV6_HEADER_SIZE = 40
+	UDP_HEADER_SIZE  = 8
+	ND_NA_SIZE       = 32 // with target link-layer address option
+)
+
+func ipv4McastMac(ip core.Ipv4Key) core.MACKey {
+	return core.MACKey{0x01, 0x00, 0x5e, ip[1] & 0x7f, ip[2], ip[3]}
+}
+
+func ipv6McastMac(ip core.Ipv6Key) core.MACKey {
+	return core.MACKey{0x33, 0x33, ip[12], ip[13], ip[14], ip[15]}
+}
+
+func ipv6Key(ip net.IP) (k core.Ipv6Key) {
+	copy(k[:], ip.To16())
+	return k
+}
+
+// fhrpIpv4Packet returns an IPv4 packet from srcMac/the ipv4 of the client with the payload l4
+func fhrpIpv4Packet(c *core.CClient, srcMac core.MACKey, dst core.Ipv4Key, ttl uint8, proto layers.IPProtocol, l4 []byte) ([]byte, int) {
+	pkt := c.GetL2Header(false, uint16(layers.EthernetTypeIPv4))
+	dmac := ipv4McastMac(dst)
+	copy(pkt[0:6], dmac[:])
+	copy(pkt[6:12], srcMac[:])
+	l3 := len(pkt)
+	pkt = append(pkt, make([]byte, IPV4_HEADER_SIZE)...)
+	ipv4 := layers.IPv4Header(pkt[l3:])
+	ipv4[0] = 0x45
+	ipv4.SetTOS(0xc0)
+	ipv4.SetLength(uint16(IPV4_HEADER_SIZE + len(l4)))
+	ipv4.SetTTL(ttl)
+	ipv4[9] = uint8(proto)
+	ipv4.SetIPSrc(c.Ipv4.Uint32())
+	ipv4.SetIPDst(dst.Uint32())
+	ipv4.UpdateChecksum()
+	return append(pkt, l4...), l3
+}
+
+// fhrpIpv6Packet returns an IPv6 packet from srcMac/src with the payload l4
+func fhrpIpv6Packet(c *core.CClient, srcMac core.MACKey, src, dst core.Ipv6Key, hop uint8, next layers.IPProtocol, l4 []byte) ([]byte, int) {
+	pkt := c.GetL2Header(false, uint16(layers.EthernetTypeIPv6))
+	dmac := ipv6McastMac(dst)
+	copy(pkt[0:6], dmac[:])
+	copy(pkt[6:12], srcMac[:])
+	l3 := len(pkt)
+	pkt = append(pkt, make([]byte, IPV6_HEADER_SIZE)...)
+	ipv6 := layers.IPv6Header(pkt[l3:])
+	ipv6[0] = 0x60
+	ipv6.SetPyloadLength(uint16(len(l4)))
+	ipv6.SetNextHeader(uint8(next))
+	ipv6.SetHopLimit(hop)
+	copy(ipv6.SrcIP(), src[:])
+	copy(ipv6.DstIP(), dst[:])
+	return append(pkt, l4...), l3
+}
+
+// fhrpUdpPacket returns an UDP packet with a valid checksum, over IPv6 in case src is not nil
+func fhrpUdpPacket(c *core.CClient, srcMac core.MACKey, src *core.Ipv6Key, dst4 core.Ipv4Key, dst6 core.Ipv6Key,
+	ttl uint8, port uint16, payload []byte) []byte {
+	udp := make([]byte, UDP_HEADER_SIZE, UDP_HEADER_SIZE+len(payload))
+	binary.BigEndian.PutUint16(udp[0:2], port)
+	binary.BigEndian.PutUint16(udp[2:4], port)
+	binary.BigEndian.PutUint16(udp[4:6], uint16(UDP_HEADER_SIZE+len(payload)))
+	udp = append(udp, payload...)
+	if src != nil {
+		pkt, l3 := fhrpIpv6Packet(c, srcMac, *src, dst6, ttl, layers.IPProtocolUDP, udp)
+		layers.IPv6Header(pkt[l3:]).FixUdpL4Checksum(pkt[l3+IPV6_HEADER_SIZE:], 0)
+		return pkt
+	}
+	pkt, l3 := fhrpIpv4Packet(c, srcMac, dst4, ttl, layers.IPProtocolUDP, udp)
+	l4 := pkt[l3+IPV4_HEADER_SIZE:]
+	cs := layers.PktChecksumTcpUdp(l4, 0, layers.IPv4Header(pkt[l3:]))
+	if cs == 0 {
+		cs = 0xffff
+	}
+	binary.BigEndian.PutUint16(l4[6:8], cs)
+	return pkt
+}
+
+// fhrpVips the virtual IPs of a group, owned by the master/active router
+type fhrpVips struct {
+	client *core.CClient
+	mac    core.MACKey
+	ipv4   []core.Ipv4Key
+	ipv6   []core.Ipv6Key
+	owned  bool
+}
+
+// own adds the virtual IPs to the namespace, all or nothing
+func (o *fhrpVips) own() error {
+	if o.owned {
+		return nil
+	}
+	ns := o.client.Ns
+	for i, ip := range o.ipv4 {
+		if err := ns.AddVirtualIPv4(ip, o.client, o.mac); err != nil {
+			for _, r := range o.ipv4[:i] {
+				ns.RemoveVirtualIPv4(r, o.client)
+			}
+			return err
+		}
+	}
+	for i, ip := range o.ipv6 {
+		if err := ns.AddVirtualIPv6(ip, o.client, o.mac); err != nil {
+			for _, r := range o.ipv6[:i] {
+				ns.RemoveVirtualIPv6(r, o.client)
+			}
+			return err
+		}
+	}
+	o.owned = true
+	return nil
+}
+
+func (o *fhrpVips) release() {
+	if !o.owned {
+		return
+	}
+	for _, ip := range o.ipv4 {
+		o.client.Ns.RemoveVirtualIPv4(ip, o.client)
+	}
+	for _, ip := range o.ipv6 {
+		o.client.Ns.RemoveVirtualIPv6(ip, o.client)
+	}
+	o.owned = false
+}
+
+// announce sends a gratuitous ARP/unsolicited NA from the virtual MAC for each of the virtual IPs, returns the number of packets
+func (o *fhrpVips) announce(tctx *core.CThreadCtx) int {
+	c := o.client
+	for _, ip := range o.ipv4 {
+		pkt := c.GetL2Header(true, uint16(layers.EthernetTypeARP))
+		copy(pkt[6:12], o.mac[:])
+		pkt = append(pkt, core.PacketUtlBuild(&layers.ARP{
+			AddrType:          0x1,
+			Protocol:          0x800,
+			HwAddressSize:     0x6,
+			ProtAddressSize:   0x4,
+			Operation:         layers.ARPRequest,
+			SourceHwAddress:   o.mac[:],
+			SourceProtAddress: ip[:],
+			DstHwAddress:      []byte{0, 0, 0, 0, 0, 0},
+			DstProtAddress:    ip[:]})...)
+		tctx.Veth.SendBuffer(false, c, pkt)
+	}
+	allNodes := ipv6Key(net.IPv6linklocalallnodes)
+	for _, ip := range o.ipv6 {
+		na := make([]byte, ND_NA_SIZE)
+		na[0] = layers.ICMPv6TypeNeighborAdvertisement
+		na[4] = 0xa0 // router, override
+		copy(na[8:24], ip[:])
+		na[24] = uint8(layers.ICMPv6OptTargetAddress)
+		na[25] = 1
+		copy(na[26:32], o.mac[:])
+		pkt, l3 := fhrpIpv6Packet(c, o.mac, ip, allNodes, 255, layers.IPProtocolICMPv6, na)
+		layers.IPv6Header(pkt[l3:]).FixIcmpL4Checksum(pkt[l3+IPV6_HEADER_SIZE:], 0)
+		tctx.Veth.SendBuffer(false, c, pkt)
+	}
+	return len(o.ipv4) + len(o.ipv6)
+}
+
+func (o *fhrpVips) strings() []string {
+	var r []string
+	for _, ip := range o.ipv4 {
+		r = append(r, ip.ToIP().String())
+	}
+	for _, ip := range o.ipv6 {
+		r = append(r, ip.ToIP().String())
+	}
+	return r
+}
+
+// fhrpIsHigher the election tie break, a higher priority and then a higher primary IP
+func fhrpIsHigher(prio uint32, ip []byte, ourPrio uint32, ourIp []byte) bool {
+	if prio != ourPrio {
+		return prio > ourPrio
+	}
+	return bytes.Compare(ip, ourIp) > 0
+}
+
+func Register(ctx *core.CThreadCtx) {
+	ctx.RegisterParserCb(VRRP_PLUG)
+	ctx.RegisterParserCb(HSRP_PLUG)
+}
@@ -0,0 +1,344 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package fhrp
+
+import (
+	"emu/core"
+	"emu/plugins/arp"
+	"emu/plugins/ipv6"
+	"emu/plugins/transport"
+	"encoding/binary"
+	"external/google/gopacket/layers"
+	"net"
+	"testing"
+	"time"
+)
+
+// VethFhrpSim a L2 segment between vport 1 and 2, records the ARP replies and the neighbor advertisements of
+// the vrrp virtual mac
+type VethFhrpSim struct {
+	arpReply []layers.ARP
+	na       [][]byte
+}
+
+func (o *VethFhrpSim) ProcessTxToRx(m *core.Mbuf) *core.Mbuf {
+	p := m.GetData()
+	switch layers.EthernetType(binary.BigEndian.Uint16(p[12:14])) {
+	case layers.EthernetTypeARP:
+		if binary.BigEndian.Uint16(p[20:22]) == uint16(layers.ARPReply) {
+			o.arpReply = append(o.arpReply, layers.ARP{SourceHwAddress: append([]byte(nil), p[22:28]...),
+				SourceProtAddress: append([]byte(nil), p[28:32]...)})
+		}
+	case layers.EthernetTypeIPv6:
+		if p[14+6] == uint8(layers.IPProtocolICMPv6) && p[14+IPV6_HEADER_SIZE] == layers.ICMPv6TypeNeighborAdvertisement &&
+			string(p[6:11]) == "\x00\x00\x5e\x00\x02" {
+			o.na = append(o.na, append([]byte(nil), p...))
+		}
+	}
+	m.SetVPort(3 - m.VPort())
+	return m
+}
+
+func genMbuf(tctx *core.CThreadCtx, vport uint16, pkt []byte) *core.Mbuf {
+	m := tctx.MPool.Alloc(uint16(len(pkt)))
+	m.SetVPort(vport)
+	m.Append(pkt)
+	return m
+}
+
+func createFhrpClient(tctx *core.CThreadCtx, vport uint16, id byte, plug string, initJson string) *core.CClient {
+	var key core.CTunnelKey
+	key.Set(&core.CTunnelData{Vport: vport})
+	ns := tctx.GetNs(&key)
+	if ns == nil {
+		ns = core.NewNSCtx(tctx, &key)
+		tctx.AddNs(&key, ns)
+	}
+	c := core.NewClient(ns, core.MACKey{0, 0, 1, 0, 0, id}, core.Ipv4Key{16, 0, 0, id},
+		core.Ipv6Key{}, core.Ipv4Key{})
+	ns.AddClient(c)
+	c.PluginCtx.CreatePlugins([]string{"arp", "ipv6", plug}, [][]byte{[]byte("{}"), []byte("{}"), []byte(initJson)})
+	return c
+}
+
+func newFhrpCtx(t *testing.T) (*core.CThreadCtx, *VethFhrpSim) {
+	sim := &VethFhrpSim{}
+	var simrx core.VethIFSim = sim
+	tctx := core.NewThreadCtx(0, 4510, true, &simrx)
+	Register(tctx)
+	arp.Register(tctx)
+	ipv6.Register(tctx)
+	return tctx, sim
+}
+
+func vrrpPlug(c *core.CClient) *PluginVrrpClient {
+	return c.PluginCtx.Get(VRRP_PLUG).Ext.(*PluginVrrpClient)
+}
+
+func hsrpPlug(c *core.CClient) *PluginHsrpClient {
+	return c.PluginCtx.Get(HSRP_PLUG).Ext.(*PluginHsrpClient)
+}
+
+func checkVrrp(t *testing.T, c *core.CClient, state, masterIp string) *VrrpInfo {
+	o := vrrpPlug(c)
+	info := o.getInfo()
+	if info.State != state || info.MasterIp != masterIp {
+		t.Fatalf(" expected %s master %s, got %+v %+v", state, masterIp, *info, o.stats)
+	}
+	return info
+}
+
+func TestVrrpElection(t *testing.T) {
+	tctx, sim := newFhrpCtx(t)
+	defer tctx.Delete()
+
+	vip := core.Ipv4Key{16, 0, 0, 254}
+	r1 := createFhrpClient(tctx, 1, 1, "vrrp", `{"vrid": 7, "ipv4": [[16, 0, 0, 254]]}`)
+	r2 := createFhrpClient(tctx, 2, 2, "vrrp", `{"vrid": 7, "prio": 200, "ipv4": [[16, 0, 0, 254]]}`)
+
+	// the backup with the higher priority has the shorter skew time and takes over first
+	tctx.MainLoopSim(5 * time.Second)
+	checkVrrp(t, r1, "backup", "16.0.0.2")
+	info := checkVrrp(t, r2, "master", "16.0.0.2")
+	if info.Vmac != "00:00:5e:00:01:07" || info.MasterPrio != 200 || r2.Ns.LookupVirtualIPv4(&vip) == nil ||
+		r1.Ns.LookupVirtualIPv4(&vip) != nil || vrrpPlug(r2).stats.pktTxAnnounce != 1 {
+		t.Fatalf(" unexpected master %+v %+v", *info, vrrpPlug(r2).stats)
+	}
+
+	// the master answers ARP for the virtual ip with the virtual mac
+	req := r1.GetL2Header(true, uint16(layers.EthernetTypeARP))
+	req = append(req, core.PacketUtlBuild(&layers.ARP{
+		AddrType:          0x1,
+		Protocol:          0x800,
+		HwAddressSize:     0x6,
+		ProtAddressSize:   0x4,
+		Operation:         layers.ARPRequest,
+		SourceHwAddress:   []byte{0, 0, 2, 0, 0, 1},
+		SourceProtAddress: []byte{16, 0, 0, 100},
+		DstHwAddress:      []byte{0, 0, 0, 0, 0, 0},
+		DstProtAddress:    vip[:]})...)
+	tctx.HandleRxPacket(genMbuf(tctx, 2, req))
+	tctx.MainLoopSim(100 * time.Millisecond)
+	if len(sim.arpReply) != 1 || net.HardwareAddr(sim.arpReply[0].SourceHwAddress).String() != info.Vmac ||
+		net.IP(sim.arpReply[0].SourceProtAddress).String() != "16.0.0.254" {
+		t.Fatalf(" expected an ARP reply from the virtual mac %+v", sim.arpReply)
+	}
+
+	// a master that leaves sends priority zero, the backup takes over after the skew time
+	r2.PluginCtx.RemovePlugins(VRRP_PLUG)
+	if r2.Ns.LookupVirtualIPv4(&vip) != nil {
+		t.Fatalf(" the virtual ip should be released")
+	}
+	tctx.MainLoopSim(time.Second)
+	checkVrrp(t, r1, "master", "16.0.0.1")
+	if o := vrrpPlug(r1); o.stats.pktRxPrio0 != 1 || o.stats.masterDownTimer != 1 || r1.Ns.LookupVirtualIPv4(&vip) == nil {
+		t.Fatalf(" unexpected counters %+v", o.stats)
+	}
+
+	// a higher priority router preempts the master
+	r3 := createFhrpClient(tctx, 2, 3, "vrrp", `{"vrid": 7, "ver": 2, "prio": 150, "ipv4": [[16, 0, 0, 254]]}`)
+	tctx.MainLoopSim(5 * time.Second)
+	checkVrrp(t, r1, "master", "16.0.0.1")
+	if vrrpPlug(r3).stats.errVersion == 0 {
+		t.Fatalf(" v3 adverts of a v2 router should be dropped %+v", vrrpPlug(r3).stats)
+	}
+	r3.Ns.RemoveClient(r3)
+	r4 := createFhrpClient(tctx, 2, 4, "vrrp", `{"vrid": 7, "prio": 150, "ipv4": [[16, 0, 0, 254]]}`)
+	tctx.MainLoopSim(5 * time.Second)
+	checkVrrp(t, r1, "backup", "16.0.0.4")
+	checkVrrp(t, r4, "master", "16.0.0.4")
+	if o := vrrpPlug(r1); o.stats.toBackup != 2 || o.stats.pktRxAddrDiff != 0 {
+		t.Fatalf(" unexpected counters %+v", o.stats)
+	}
+	ns := r1.Ns.PluginCtx.Get(VRRP_PLUG).Ext.(*PluginVrrpNs)
+	if ns.stats.errChecksum != 0 || ns.stats.errTtl != 0 || ns.stats.errTooShort != 0 {
+		t.Fatalf(" unexpected ns counters %+v", ns.stats)
+	}
+}
+
+func TestVrrpIpv6(t *testing.T) {
+	tctx, sim := newFhrpCtx(t)
+	defer tctx.Delete()
+
+	vips := `"ipv6": [[254, 128, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1], [32, 1, 13, 184, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1]]`
+	r1 := createFhrpClient(tctx, 1, 1, "vrrp", `{"vrid": 3, "prio": 255, `+vips+`}`)
+	r2 := createFhrpClient(tctx, 2, 2, "vrrp", `{"vrid": 3, "interval": 500, `+vips+`}`)
+
+	// the owner is the master at once, the backup learns its interval
+	tctx.MainLoopSim(3 * time.Second)
+	info := checkVrrp(t, r1, "master", "fe80::200:1ff:fe00:1")
+	checkVrrp(t, r2, "backup", "fe80::200:1ff:fe00:1")
+	if info.Vmac != "00:00:5e:00:02:03" || vrrpPlug(r2).getInfo().MasterAdv != VRRP_DEF_INTERVAL_MS {
+		t.Fatalf(" unexpected info %+v %+v", *info, *vrrpPlug(r2).getInfo())
+	}
+	// unsolicited NA for each of the virtual ips
+	if len(sim.na) != 2 || vrrpPlug(r1).stats.pktTxAnnounce != 2 {
+		t.Fatalf(" expected unsolicited NA %d %+v", len(sim.na), vrrpPlug(r1).stats)
+	}
+
+	// neighbor solicitation for the global virtual ip
+	target := ipv6Key(net.ParseIP("2001:db8::1"))
+	src := ipv6Key(net.ParseIP("2001:db8::100"))
+	ns := make([]byte, 32)
+	ns[0] = layers.ICMPv6TypeNeighborSolicitation
+	copy(ns[8:24], target[:])
+	ns[24] = uint8(layers.ICMPv6OptSourceAddress)
+	ns[25] = 1
+	copy(ns[26:32], []byte{0, 0, 2, 0, 0, 1})
+	dst := ipv6Key(net.ParseIP("ff02::1:ff00:1"))
+	pkt, l3 := fhrpIpv6Packet(r2, core.MACKey{0, 0, 2, 0, 0, 1}, src, dst, 255, layers.IPProtocolICMPv6, ns)
+	layers.IPv6Header(pkt[l3:]).FixIcmpL4Checksum(pkt[l3+IPV6_HEADER_SIZE:], 0)
+	sim.na = nil
+	tctx.HandleRxPacket(genMbuf(tctx, 1, pkt))
+	tctx.MainLoopSim(100 * time.Millisecond)
+	if len(sim.na) != 1 {
+		t.Fatalf(" expected NA for the virtual ip %d", len(sim.na))
+	}
+	na := sim.na[0]
+	if net.HardwareAddr(na[6:12]).String() != info.Vmac || string(na[14+IPV6_HEADER_SIZE+8:14+IPV6_HEADER_SIZE+24]) != string(target[:]) {
+		t.Fatalf(" unexpected NA %v", na)
+	}
+}
+
+func TestFhrpInvalidInit(t *testing.T) {
+	tctx, _ := newFhrpCtx(t)
+	defer tctx.Delete()
+
+	for _, j := range []string{
+		`{"vrid": 1, "ver": 2, "ipv6": [[254, 128, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1]]}`,
+		`{"vrid": 1, "ipv6": [[32, 1, 13, 184, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1]]}`,
+		`{"vrid": 1, "interval": 1005, "ipv4": [[16, 0, 0, 254]]}`,
+		`{"vrid": 1}`,
+	} {
+		c := createFhrpClient(tctx, 1, 1, "vrrp", j)
+		if o := vrrpPlug(c); o.enable || o.stats.errInitJson != 1 || o.initErr == "" {
+			t.Fatalf(" %s should be invalid %+v", j, o.stats)
+		}
+		c.Ns.RemoveClient(c)
+	}
+	for _, j := range []string{
+		`{"group": 300, "ipv4": [16, 0, 0, 254]}`,
+		`{"ver": 1, "ipv6": [254, 128, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1]}`,
+		`{"ver": 2, "hello": 5000, "hold": 3000, "ipv4": [16, 0, 0, 254]}`,
+		`{"auth": "long authentication", "ipv4": [16, 0, 0, 254]}`,
+	} {
+		c := createFhrpClient(tctx, 1, 1, "hsrp", j)
+		if o := hsrpPlug(c); o.enable || o.stats.errInitJson != 1 || o.initErr == "" {
+			t.Fatalf(" %s should be invalid %+v", j, o.stats)
+		}
+		c.Ns.RemoveClient(c)
+	}
+}
+
+func checkHsrp(t *testing.T, c *core.CClient, state, activeIp, standbyIp string) *HsrpInfo {
+	o := hsrpPlug(c)
+	info := o.getInfo()
+	if info.State != state || info.ActiveIp != activeIp || info.StandbyIp != standbyIp {
+		t.Fatalf(" expected %s active %s standby %s, got %+v %+v", state, activeIp, standbyIp, *info, o.stats)
+	}
+	return info
+}
+
+func TestHsrpV1(t *testing.T) {
+	tctx, _ := newFhrpCtx(t)
+	defer tctx.Delete()
+
+	vip := core.Ipv4Key{16, 0, 0, 254}
+	r1 := createFhrpClient(tctx, 1, 1, "hsrp", `{"group": 5, "ipv4": [16, 0, 0, 254]}`)
+	r2 := createFhrpClient(tctx, 2, 2, "hsrp", `{"group": 5, "prio": 110, "ipv4": [16, 0, 0, 254]}`)
+
+	tctx.MainLoopSim(40 * time.Second)
+	checkHsrp(t, r1, "standby", "16.0.0.2", "16.0.0.1")
+	info := checkHsrp(t, r2, "active", "16.0.0.2", "16.0.0.1")
+	if info.Vmac != "00:00:0c:07:ac:05" || r2.Ns.LookupVirtualIPv4(&vip) == nil || r1.Ns.LookupVirtualIPv4(&vip) != nil {
+		t.Fatalf(" unexpected active %+v", *info)
+	}
+
+	// the active router resigns, the standby takes over at once
+	r2.PluginCtx.RemovePlugins(HSRP_PLUG)
+	tctx.MainLoopSim(100 * time.Millisecond)
+	checkHsrp(t, r1, "active", "16.0.0.1", "")
+	if o := hsrpPlug(r1); o.stats.pktRxResign != 1 || r1.Ns.LookupVirtualIPv4(&vip) == nil {
+		t.Fatalf(" unexpected counters %+v", o.stats)
+	}
+
+	// hellos with another authentication are ignored, the active router is not learned
+	r3 := createFhrpClient(tctx, 2, 3, "hsrp", `{"group": 5, "prio": 110, "auth": "other", "ipv4": [16, 0, 0, 254]}`)
+	tctx.MainLoopSim(5 * time.Second)
+	checkHsrp(t, r3, "listen", "", "")
+	if o := hsrpPlug(r3); o.stats.errAuth == 0 || o.stats.pktRxHello != 0 {
+		t.Fatalf(" expected authentication errors %+v", o.stats)
+	}
+}
+
+func TestHsrpV2Preempt(t *testing.T) {
+	tctx, _ := newFhrpCtx(t)
+	defer tctx.Delete()
+
+	r1 := createFhrpClient(tctx, 1, 1, "hsrp", `{"group": 300, "ver": 2, "hello": 1000, "hold": 3000, "ipv6": [254, 128, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1]}`)
+	tctx.MainLoopSim(10 * time.Second)
+	info := checkHsrp(t, r1, "active", "fe80::200:1ff:fe00:1", "")
+	if info.Vmac != "00:05:73:a0:01:2c" {
+		t.Fatalf(" unexpected vmac %s", info.Vmac)
+	}
+
+	// a higher priority router with preempt takes over by a coup
+	r2 := createFhrpClient(tctx, 2, 2, "hsrp", `{"group": 300, "ver": 2, "prio": 200, "preempt": true, "hello": 1000, "hold": 3000, "ipv6": [254, 128, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1]}`)
+	tctx.MainLoopSim(10 * time.Second)
+	checkHsrp(t, r2, "active", "fe80::200:1ff:fe00:2", "fe80::200:1ff:fe00:1")
+	checkHsrp(t, r1, "standby", "fe80::200:1ff:fe00:2", "fe80::200:1ff:fe00:1")
+	if hsrpPlug(r2).stats.pktTxCoup != 1 || hsrpPlug(r1).stats.pktRxCoup != 1 {
+		t.Fatalf(" expected a coup %+v %+v", hsrpPlug(r2).stats, hsrpPlug(r1).stats)
+	}
+	ns := r1.Ns.PluginCtx.Get(HSRP_PLUG).Ext.(*PluginHsrpNs)
+	if ns.stats.errVersion != 0 || ns.stats.errTooShort != 0 || ns.stats.pktRxNoGroup != 0 {
+		t.Fatalf(" unexpected ns counters %+v", ns.stats)
+	}
+}
+
+// hsrpUdpSrv counts the data of the transport sockets
+type hsrpUdpSrv struct {
+	rx int
+}
+
+func (o *hsrpUdpSrv) OnAccept(socket transport.SocketApi) transport.ISocketCb { return o }
+func (o *hsrpUdpSrv) OnRxEvent(event transport.SocketEventType)               {}
+func (o *hsrpUdpSrv) OnTxEvent(event transport.SocketEventType)               {}
+func (o *hsrpUdpSrv) OnRxData(d []byte)                                       { o.rx += len(d) }
+
+// the HSRP port of a namespace without hsrp is passed to the transport layer
+func TestHsrpUdpDefault(t *testing.T) {
+	tctx, _ := newFhrpCtx(t)
+	defer tctx.Delete()
+	transport.Register(tctx)
+
+	var clients []*core.CClient
+	for id := byte(1); id <= 2; id++ {
+		var key core.CTunnelKey
+		key.Set(&core.CTunnelData{Vport: uint16(id)})
+		ns := core.NewNSCtx(tctx, &key)
+		tctx.AddNs(&key, ns)
+		c := core.NewClient(ns, core.MACKey{0, 0, 1, 0, 0, id}, core.Ipv4Key{16, 0, 0, id}, core.Ipv6Key{}, core.Ipv4Key{})
+		c.ForceDGW = true
+		c.Ipv4ForcedgMac = core.MACKey{0, 0, 1, 0, 0, 3 - id}
+		ns.AddClient(c)
+		c.PluginCtx.CreatePlugins([]string{transport.TRANS_PLUG}, [][]byte{nil})
+		clients = append(clients, c)
+	}
+	srv := &hsrpUdpSrv{}
+	if err := transport.GetTransportCtx(clients[0]).Listen("udp", ":1985", srv); err != nil {
+		t.Fatal(err)
+	}
+	s, err := transport.GetTransportCtx(clients[1]).Dial("udp", "16.0.0.1:1985", &hsrpUdpSrv{}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Write(make([]byte, 100))
+	tctx.MainLoopSim(time.Second)
+	if srv.rx != 100 {
+		t.Fatalf(" the server got %d bytes", srv.rx)
+	}
+}
@@ -0,0 +1,897 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package fhrp
+
+/*
+hsrp speaker of one group of the client, v1 (IPv4) or v2 (IPv4 or IPv6).
+
+init json:
+
+	{"group": 1, "ver": 1, "prio": 100, "ipv4": [16, 0, 0, 254], "hello": 3000, "hold": 10000, "preempt": false, "auth": "cisco"}
+
+ipv4/ipv6 is the virtual IP, hello/hold are in msec (seconds for v1). The states are listen, speak, standby and
+active, the active router owns the virtual IP. A router with a higher priority (and then a higher IP) takes over
+the active router by a coup only with preempt.
+
+*/
+
+import (
+	"emu/core"
+	"encoding/binary"
+	"external/osamingo/jsonrpc"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/intel-go/fastjson"
+)
+
+const (
+	HSRP_PLUG           = "hsrp"
+	HSRP_PORT           = 1985
+	HSRP_V6_PORT        = 2029
+	HSRP_V1_SIZE        = 20
+	HSRP_V2_TLV_GROUP   = 1
+	HSRP_V2_TLV_AUTH    = 3
+	HSRP_V2_GROUP_LEN   = 40
+	HSRP_AUTH_LEN       = 8
+	HSRP_DEF_PRIO       = 100
+	HSRP_DEF_HELLO_MS   = 3000
+	HSRP_DEF_HOLD_MS    = 10000
+	HSRP_V1_MAX_GROUP   = 255
+	HSRP_V2_MAX_GROUP   = 4095
+	HSRP_V1_MAX_TIME_MS = 255000
+
+	HSRP_OP_HELLO  = 0
+	HSRP_OP_COUP   = 1
+	HSRP_OP_RESIGN = 2
+
+	HSRP_STATE_INITIAL = 0
+	HSRP_STATE_LISTEN  = 2
+	HSRP_STATE_SPEAK   = 4
+	HSRP_STATE_STANDBY = 8
+	HSRP_STATE_ACTIVE  = 16
+
+	HSRP_TIMER_HELLO   = 1
+	HSRP_TIMER_ACTIVE  = 2
+	HSRP_TIMER_STANDBY = 3
+)
+
+var hsrpV1Group = core.Ipv4Key{224, 0, 0, 2}
+var hsrpV2Group = core.Ipv4Key{224, 0, 0, 102}
+var hsrpIpv6Group = ipv6Key(net.ParseIP("ff02::66"))
+
+var hsrpStateNames = map[uint8]string{
+	HSRP_STATE_INITIAL: "initial",
+	HSRP_STATE_LISTEN:  "listen",
+	HSRP_STATE_SPEAK:   "speak",
+	HSRP_STATE_STANDBY: "standby",
+	HSRP_STATE_ACTIVE:  "active",
+}
+
+type HsrpInit struct {
+	Group   uint16        `json:"group"`
+	Ver     uint8         `json:"ver" validate:"oneof=1 2"`
+	Prio    uint8         `json:"prio"`
+	Ipv4    *core.Ipv4Key `json:"ipv4"`
+	Ipv6    *core.Ipv6Key `json:"ipv6"`
+	Hello   uint32        `json:"hello" validate:"required"` // msec
+	Hold    uint32        `json:"hold" validate:"required"`  // msec
+	Preempt bool          `json:"preempt"`
+	Auth    string        `json:"auth" validate:"max=8"`
+}
+
+// HsrpInfo election state for the RPC
+type HsrpInfo struct {
+	Group       uint16 `json:"group"`
+	Ver         uint8  `json:"ver"`
+	State       string `json:"state"`
+	Prio        uint8  `json:"prio"`
+	Vip         string `json:"vip"`
+	Vmac        string `json:"vmac"`
+	ActiveIp    string `json:"active_ip,omitempty"`
+	ActivePrio  uint8  `json:"active_prio"`
+	StandbyIp   string `json:"standby_ip,omitempty"`
+	StandbyPrio uint8  `json:"standby_prio"`
+	InitErr     string `json:"init_error,omitempty"`
+}
+
+type hsrpKey struct {
+	ver   uint8
+	ipv6  bool
+	group uint16
+}
+
+// hsrpHello the fields of a received hello/coup/resign
+type hsrpHello struct {
+	op    uint8
+	state uint8
+	prio  uint8
+	group uint16
+	hold  uint32 // msec
+	vip   []byte
+	auth  []byte
+	src   []byte
+}
+
+type hsrpRouter struct {
+	ip   []byte
+	prio uint8
+}
+
+type HsrpStats struct {
+	pktTxHello     uint64
+	pktTxCoup      uint64
+	pktTxResign    uint64
+	pktTxAnnounce  uint64
+	pktRxHello     uint64
+	pktRxCoup      uint64
+	pktRxResign    uint64
+	pktRxVipDiff   uint64
+	errAuth        uint64
+	errInitJson    uint64
+	errVip         uint64
+	toActive       uint64
+	toStandby      uint64
+	activeExpired  uint64
+	standbyExpired uint64
+}
+
+func NewHsrpStatsDb(o *HsrpStats) *core.CCounterDb {
+	db := core.NewCCounterDb("hsrp")
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTxHello,
+		Name:     "pktTxHello",
+		Help:     "hellos sent",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTxCoup,
+		Name:     "pktTxCoup",
+		Help:     "coups sent",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTxResign,
+		Name:     "pktTxResign",
+		Help:     "resigns sent",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTxAnnounce,
+		Name:     "pktTxAnnounce",
+		Help:     "gratuitous arp/unsolicited na sent for the virtual ip",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxHello,
+		Name:     "pktRxHello",
+		Help:     "hellos received",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxCoup,
+		Name:     "pktRxCoup",
+		Help:     "coups received",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxResign,
+		Name:     "pktRxResign",
+		Help:     "resigns received",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxVipDiff,
+		Name:     "pktRxVipDiff",
+		Help:     "hellos with another virtual ip",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.errAuth,
+		Name:     "errAuth",
+		Help:     "wrong authentication data",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.errInitJson,
+		Name:     "errInitJson",
+		Help:     "invalid init json",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.errVip,
+		Name:     "errVip",
+		Help:     "active router can't own the virtual ip, exists in the namespace",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.toActive,
+		Name:     "toActive",
+		Help:     "transitions to active",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.toStandby,
+		Name:     "toStandby",
+		Help:     "transitions to standby",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.activeExpired,
+		Name:     "activeExpired",
+		Help:     "active timer expired",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.standbyExpired,
+		Name:     "standbyExpired",
+		Help:     "standby timer expired",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	return db
+}
+
+type PluginHsrpClientTimer struct {
+}
+
+func (o *PluginHsrpClientTimer) OnEvent(a, b interface{}) {
+	pi := a.(*PluginHsrpClient)
+	pi.onTimerEvent(b.(int))
+}
+
+// PluginHsrpClient a hsrp speaker of one group
+type PluginHsrpClient struct {
+	core.PluginBase
+	hsrpNsPlug   *PluginHsrpNs
+	init         HsrpInit
+	key          hsrpKey
+	enable       bool
+	srcIp        []byte // for the tie break
+	src6         core.Ipv6Key
+	vips         fhrpVips
+	auth         [HSRP_AUTH_LEN]byte
+	state        uint8
+	active       hsrpRouter
+	standby      hsrpRouter
+	timerw       *core.TimerCtx
+	helloTimer   core.CHTimerObj
+	activeTimer  core.CHTimerObj
+	standbyTimer core.CHTimerObj
+	timerCb      PluginHsrpClientTimer
+	stats        HsrpStats
+	cdb          *core.CCounterDb
+	cdbv         *core.CCounterDbVec
+	initErr      string
+}
+
+var hsrpEvents = []string{}
+
+/*NewHsrpClient create plugin */
+func NewHsrpClient(ctx *core.PluginCtx, initJson []byte) *core.PluginBase {
+
+	o := new(PluginHsrpClient)
+	o.InitPluginBase(ctx, o)             /* init base object*/
+	o.RegisterEvents(ctx, hsrpEvents, o) /* register events, only if exits*/
+	nsplg := o.Ns.PluginCtx.GetOrCreate(HSRP_PLUG)
+	o.hsrpNsPlug = nsplg.Ext.(*PluginHsrpNs)
+	o.OnCreate(initJson)
+
+	return &o.PluginBase
+}
+
+func (o *PluginHsrpClient) OnCreate(initJson []byte) {
+	o.timerw = o.Tctx.GetTimerCtx()
+	o.cdb = NewHsrpStatsDb(&o.stats)
+	o.cdbv = core.NewCCounterDbVec("hsrp")
+	o.cdbv.Add(o.cdb)
+	o.helloTimer.SetCB(&o.timerCb, o, HSRP_TIMER_HELLO)
+	o.activeTimer.SetCB(&o.timerCb, o, HSRP_TIMER_ACTIVE)
+	o.standbyTimer.SetCB(&o.timerCb, o, HSRP_TIMER_STANDBY)
+
+	o.init = HsrpInit{Ver: 1, Prio: HSRP_DEF_PRIO, Hello: HSRP_DEF_HELLO_MS, Hold: HSRP_DEF_HOLD_MS, Auth: "cisco"}
+	err := o.Tctx.UnmarshalValidate(initJson, &o.init)
+	if err == nil {
+		err = o.validate()
+	}
+	if err != nil {
+		o.stats.errInitJson++
+		o.initErr = err.Error()
+		return
+	}
+	copy(o.auth[:], o.init.Auth)
+	o.hsrpNsPlug.groups[o.key] = o
+	o.enable = true
+	// nothing is known, listen for the hold time
+	o.state = HSRP_STATE_LISTEN
+	o.startTimer(&o.activeTimer, o.init.Hold)
+	o.startTimer(&o.standbyTimer, o.init.Hold)
+}
+
+func (o *PluginHsrpClient) validate() error {
+	i := &o.init
+	switch {
+	case i.Ipv4 != nil && i.Ipv6 != nil:
+		return fmt.Errorf("hsrp should have ipv4 or ipv6 virtual ip, not both")
+	case i.Ipv4 == nil && i.Ipv6 == nil:
+		return fmt.Errorf("hsrp should have ipv4 or ipv6 virtual ip")
+	case i.Hold <= i.Hello:
+		return fmt.Errorf("hold %d should be bigger than hello %d", i.Hold, i.Hello)
+	}
+	if i.Ver == 1 {
+		if i.Ipv6 != nil {
+			return fmt.Errorf("hsrp v1 supports only ipv4")
+		}
+		if i.Group > HSRP_V1_MAX_GROUP {
+			return fmt.Errorf("hsrp v1 group %d is bigger than %d", i.Group, HSRP_V1_MAX_GROUP)
+		}
+		if i.Hello%1000 != 0 || i.Hold%1000 != 0 || i.Hold > HSRP_V1_MAX_TIME_MS {
+			return fmt.Errorf("hsrp v1 hello/hold should be in seconds, up to %d msec", HSRP_V1_MAX_TIME_MS)
+		}
+	} else if i.Group > HSRP_V2_MAX_GROUP {
+		return fmt.Errorf("hsrp v2 group %d is bigger than %d", i.Group, HSRP_V2_MAX_GROUP)
+	}
+	o.key = hsrpKey{ver: i.Ver, ipv6: i.Ipv6 != nil, group: i.Group}
+	if _, ok := o.hsrpNsPlug.groups[o.key]; ok {
+		return fmt.Errorf("hsrp v%d group %d already exists in the namespace", i.Ver, i.Group)
+	}
+	o.vips = fhrpVips{client: o.Client}
+	g := uint8(i.Group)
+	switch {
+	case i.Ver == 1:
+		o.vips.mac = core.MACKey{0x00, 0x00, 0x0c, 0x07, 0xac, g}
+	case i.Ipv6 != nil:
+		o.vips.mac = core.MACKey{0x00, 0x05, 0x73, 0xa0, uint8(i.Group >> 8), g}
+	default:
+		o.vips.mac = core.MACKey{0x00, 0x00, 0x0c, 0x9f, 0xf0 | uint8(i.Group>>8), g}
+	}
+	if i.Ipv6 != nil {
+		o.vips.ipv6 = []core.Ipv6Key{*i.Ipv6}
+		o.Client.GetIpv6LocalLink(&o.src6)
+		o.srcIp = o.src6[:]
+	} else {
+		if o.Client.Ipv4.IsZero() {
+			return fmt.Errorf("the client should have an ipv4")
+		}
+		o.vips.ipv4 = []core.Ipv4Key{*i.Ipv4}
+		o.srcIp = o.Client.Ipv4[:]
+	}
+	return nil
+}
+
+/*OnEvent support event change of IP  */
+func (o *PluginHsrpClient) OnEvent(msg string, a, b interface{}) {
+
+}
+
+func (o *PluginHsrpClient) GetCounterDbVec() *core.CCounterDbVec {
+	return o.cdbv
+}
+
+func (o *PluginHsrpClient) OnRemove(ctx *core.PluginCtx) {
+	ctx.UnregisterEvents(&o.PluginBase, hsrpEvents)
+	for _, t := range []*core.CHTimerObj{&o.helloTimer, &o.activeTimer, &o.standbyTimer} {
+		if t.IsRunning() {
+			o.timerw.Stop(t)
+		}
+	}
+	if !o.enable {
+		return
+	}
+	if o.state == HSRP_STATE_ACTIVE {
+		// the standby router takes over at once
+		o.sendPkt(HSRP_OP_RESIGN)
+		o.vips.release()
+	}
+	delete(o.hsrpNsPlug.groups, o.key)
+}
+
+func (o *PluginHsrpClient) startTimer(t *core.CHTimerObj, msec uint32) {
+	if t.IsRunning() {
+		o.timerw.Stop(t)
+	}
+	o.timerw.Start(t, time.Duration(msec)*time.Millisecond)
+}
+
+func (o *PluginHsrpClient) stopTimer(t *core.CHTimerObj) {
+	if t.IsRunning() {
+		o.timerw.Stop(t)
+	}
+}
+
+func (o *PluginHsrpClient) onTimerEvent(t int) {
+	switch t {
+	case HSRP_TIMER_HELLO:
+		o.sendPkt(HSRP_OP_HELLO)
+		o.startTimer(&o.helloTimer, o.init.Hello)
+	case HSRP_TIMER_ACTIVE:
+		o.stats.activeExpired++
+		o.onActiveDown()
+	case HSRP_TIMER_STANDBY:
+		o.stats.standbyExpired++
+		o.standby = hsrpRouter{}
+		switch o.state {
+		case HSRP_STATE_LISTEN:
+			o.setState(HSRP_STATE_SPEAK)
+		case HSRP_STATE_SPEAK:
+			o.setState(HSRP_STATE_STANDBY)
+		}
+	}
+}
+
+// onActiveDown the active router is gone, by the active timer or a resign
+func (o *PluginHsrpClient) onActiveDown() {
+	o.stopTimer(&o.activeTimer)
+	o.active = hsrpRouter{}
+	switch o.state {
+	case HSRP_STATE_LISTEN:
+		o.setState(HSRP_STATE_SPEAK)
+	case HSRP_STATE_STANDBY:
+		o.setState(HSRP_STATE_ACTIVE)
+	}
+}
+
+func (o *PluginHsrpClient) setState(state uint8) {
+	if o.state == state {
+		return
+	}
+	prev := o.state
+	o.state = state
+	if prev == HSRP_STATE_ACTIVE {
+		o.vips.release()
+	}
+	switch state {
+	case HSRP_STATE_LISTEN:
+		o.stopTimer(&o.helloTimer)
+	case HSRP_STATE_SPEAK:
+		// speak for the hold time to be the standby router
+		o.startTimer(&o.standbyTimer, o.init.Hold)
+	case HSRP_STATE_STANDBY:
+		o.stats.toStandby++
+		o.stopTimer(&o.standbyTimer)
+		o.standby = hsrpRouter{ip: o.srcIp, prio: o.init.Prio}
+		if !o.activeTimer.IsRunning() {
+			// there is no active router
+			o.setState(HSRP_STATE_ACTIVE)
+			return
+		}
+	case HSRP_STATE_ACTIVE:
+		o.stats.toActive++
+		o.stopTimer(&o.activeTimer)
+		o.active = hsrpRouter{ip: o.srcIp, prio: o.init.Prio}
+		if o.standby.ip != nil && string(o.standby.ip) == string(o.srcIp) {
+			o.standby = hsrpRouter{}
+		}
+		if err := o.vips.own(); err != nil {
+			o.stats.errVip++
+		}
+		o.stats.pktTxAnnounce += uint64(o.vips.announce(o.Tctx))
+	}
+	if state != HSRP_STATE_LISTEN {
+		o.sendPkt(HSRP_OP_HELLO)
+		o.startTimer(&o.helloTimer, o.init.Hello)
+	}
+}
+
+func (o *PluginHsrpClient) isHigher(h *hsrpHello) bool {
+	return fhrpIsHigher(uint32(h.prio), h.src, uint32(o.init.Prio), o.srcIp)
+}
+
+// onHello the election by a received hello/coup/resign
+func (o *PluginHsrpClient) onHello(h *hsrpHello) {
+	if string(h.auth) != string(o.auth[:]) {
+		o.stats.errAuth++
+		return
+	}
+	vip := o.vips.strings()[0]
+	if h.vip != nil && net.IP(h.vip).String() != vip {
+		o.stats.pktRxVipDiff++
+	}
+	higher := o.isHigher(h)
+	peer := hsrpRouter{ip: append([]byte(nil), h.src...), prio: h.prio}
+
+	switch h.op {
+	case HSRP_OP_RESIGN:
+		o.stats.pktRxResign++
+		if o.state != HSRP_STATE_ACTIVE {
+			o.onActiveDown()
+		}
+		return
+	case HSRP_OP_COUP:
+		o.stats.pktRxCoup++
+		if o.state == HSRP_STATE_ACTIVE && higher {
+			o.active = peer
+			o.startTimer(&o.activeTimer, h.hold)
+			o.setState(HSRP_STATE_SPEAK)
+		}
+		return
+	}
+
+	o.stats.pktRxHello++
+	switch h.state {
+	case HSRP_STATE_ACTIVE:
+		if o.state == HSRP_STATE_ACTIVE {
+			if !higher {
+				// our hellos make it leave
+				return
+			}
+			o.active = peer
+			o.startTimer(&o.activeTimer, h.hold)
+			o.setState(HSRP_STATE_SPEAK)
+			return
+		}
+		if !higher && o.init.Preempt {
+			o.stats.pktTxCoup++
+			o.sendPkt(HSRP_OP_COUP)
+			o.setState(HSRP_STATE_ACTIVE)
+			return
+		}
+		o.active = peer
+		o.startTimer(&o.activeTimer, h.hold)
+	case HSRP_STATE_STANDBY:
+		if o.state == HSRP_STATE_STANDBY && !higher {
+			return
+		}
+		o.standby = peer
+		o.startTimer(&o.standbyTimer, h.hold)
+		if higher && (o.state == HSRP_STATE_STANDBY || o.state == HSRP_STATE_SPEAK) {
+			o.setState(HSRP_STATE_LISTEN)
+		} else if !higher && o.state == HSRP_STATE_LISTEN {
+			o.setState(HSRP_STATE_SPEAK)
+		}
+	case HSRP_STATE_SPEAK:
+		if higher && (o.state == HSRP_STATE_STANDBY || o.state == HSRP_STATE_SPEAK) {
+			o.setState(HSRP_STATE_LISTEN)
+		}
+	}
+}
+
+func (o *PluginHsrpClient) sendPkt(op uint8) {
+	state := o.state
+	if op == HSRP_OP_COUP {
+		state = HSRP_STATE_SPEAK
+	}
+	if op == HSRP_OP_RESIGN {
+		o.stats.pktTxResign++
+	} else if op == HSRP_OP_HELLO {
+		o.stats.pktTxHello++
+	}
+	srcMac := o.Client.Mac
+	if o.state == HSRP_STATE_ACTIVE {
+		srcMac = o.vips.mac
+	}
+
+	var d []byte
+	if o.init.Ver == 1 {
+		d = make([]byte, HSRP_V1_SIZE)
+		d[1] = op
+		d[2] = state
+		d[3] = uint8(o.init.Hello / 1000)
+		d[4] = uint8(o.init.Hold / 1000)
+		d[5] = o.init.Prio
+		d[6] = uint8(o.init.Group)
+		copy(d[8:16], o.auth[:])
+		copy(d[16:20], o.vips.ipv4[0][:])
+		o.Tctx.Veth.SendBuffer(false, o.Client, fhrpUdpPacket(o.Client, srcMac, nil, hsrpV1Group, core.Ipv6Key{}, 1, HSRP_PORT, d))
+		return
+	}
+
+	d = make([]byte, 2+HSRP_V2_GROUP_LEN, 2+HSRP_V2_GROUP_LEN+2+HSRP_AUTH_LEN)
+	d[0] = HSRP_V2_TLV_GROUP
+	d[1] = HSRP_V2_GROUP_LEN
+	g := d[2:]
+	g[0] = 2
+	g[1] = op
+	g[2] = state
+	g[3] = 4
+	binary.BigEndian.PutUint16(g[4:6], o.init.Group)
+	copy(g[6:12], o.Client.Mac[:])
+	binary.BigEndian.PutUint32(g[12:16], uint32(o.init.Prio))
+	binary.BigEndian.PutUint32(g[16:20], o.init.Hello)
+	binary.BigEndian.PutUint32(g[20:24], o.init.Hold)
+	d = append(d, HSRP_V2_TLV_AUTH, HSRP_AUTH_LEN)
+	d = append(d, o.auth[:]...)
+	if o.key.ipv6 {
+		g[3] = 6
+		copy(g[24:40], o.vips.ipv6[0][:])
+		o.Tctx.Veth.SendBuffer(false, o.Client, fhrpUdpPacket(o.Client, srcMac, &o.src6, core.Ipv4Key{}, hsrpIpv6Group, 255, HSRP_V6_PORT, d))
+		return
+	}
+	copy(g[24:28], o.vips.ipv4[0][:])
+	o.Tctx.Veth.SendBuffer(false, o.Client, fhrpUdpPacket(o.Client, srcMac, nil, hsrpV2Group, core.Ipv6Key{}, 1, HSRP_PORT, d))
+}
+
+func (o *PluginHsrpClient) getInfo() *HsrpInfo {
+	i := &HsrpInfo{Group: o.init.Group, Ver: o.init.Ver, State: hsrpStateNames[o.state], Prio: o.init.Prio,
+		Vmac: net.HardwareAddr(o.vips.mac[:]).String(), ActivePrio: o.active.prio, StandbyPrio: o.standby.prio,
+		InitErr: o.initErr}
+	if vips := o.vips.strings(); len(vips) > 0 {
+		i.Vip = vips[0]
+	}
+	if o.active.ip != nil {
+		i.ActiveIp = net.IP(o.active.ip).String()
+	}
+	if o.standby.ip != nil {
+		i.StandbyIp = net.IP(o.standby.ip).String()
+	}
+	return i
+}
+
+type HsrpNsStats struct {
+	pktRx        uint64
+	pktRxNoGroup uint64
+	errTooShort  uint64
+	errVersion   uint64
+}
+
+func NewHsrpNsStatsDb(o *HsrpNsStats) *core.CCounterDb {
+	db := core.NewCCounterDb("hsrpns")
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRx,
+		Name:     "pktRx",
+		Help:     "hsrp packets received",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxNoGroup,
+		Name:     "pktRxNoGroup",
+		Help:     "packets of a group that is not emulated",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.errTooShort,
+		Name:     "errTooShort",
+		Help:     "packet is too short",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.errVersion,
+		Name:     "errVersion",
+		Help:     "version is not supported",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	return db
+}
+
+// PluginHsrpNs the hsrp groups of the namespace
+type PluginHsrpNs struct {
+	core.PluginBase
+	groups map[hsrpKey]*PluginHsrpClient
+	stats  HsrpNsStats
+	cdb    *core.CCounterDb
+	cdbv   *core.CCounterDbVec
+}
+
+func NewHsrpNs(ctx *core.PluginCtx, initJson []byte) *core.PluginBase {
+	o := new(PluginHsrpNs)
+	o.InitPluginBase(ctx, o)
+	o.RegisterEvents(ctx, []string{}, o)
+	o.groups = make(map[hsrpKey]*PluginHsrpClient)
+	o.cdb = NewHsrpNsStatsDb(&o.stats)
+	o.cdbv = core.NewCCounterDbVec("hsrpns")
+	o.cdbv.Add(o.cdb)
+	return &o.PluginBase
+}
+
+func (o *PluginHsrpNs) OnRemove(ctx *core.PluginCtx) {
+}
+
+func (o *PluginHsrpNs) OnEvent(msg string, a, b interface{}) {
+
+}
+
+func (o *PluginHsrpNs) GetCounterDbVec() *core.CCounterDbVec {
+	return o.cdbv
+}
+
+// decodeHsrp decodes the v1 packet or the v2 TLVs d, returns the version or zero
+func decodeHsrp(d []byte, h *hsrpHello) uint8 {
+	if len(d) >= HSRP_V1_SIZE && d[0] == 0 {
+		h.op = d[1]
+		h.state = d[2]
+		h.hold = uint32(d[4]) * 1000
+		h.prio = d[5]
+		h.group = uint16(d[6])
+		h.auth = d[8:16]
+		h.vip = d[16:20]
+		return 1
+	}
+	var ver uint8
+	h.auth = make([]byte, HSRP_AUTH_LEN) // no authentication TLV
+	for len(d) >= 2 {
+		t, l := d[0], int(d[1])
+		if len(d) < 2+l {
+			return 0
+		}
+		v := d[2 : 2+l]
+		switch {
+		case t == HSRP_V2_TLV_GROUP && l == HSRP_V2_GROUP_LEN && v[0] == 2:
+			ver = 2
+			h.op = v[1]
+			h.state = v[2]
+			h.group = binary.BigEndian.Uint16(v[4:6])
+			prio := binary.BigEndian.Uint32(v[12:16])
+			if prio > 255 {
+				prio = 255
+			}
+			h.prio = uint8(prio)
+			h.hold = binary.BigEndian.Uint32(v[20:24])
+			if v[3] == 6 {
+				h.vip = v[24:40]
+			} else {
+				h.vip = v[24:28]
+			}
+		case t == HSRP_V2_TLV_AUTH && l == HSRP_AUTH_LEN:
+			h.auth = v
+		}
+		d = d[2+l:]
+	}
+	return ver
+}
+
+// HandleRxHsrpPacket decodes the hsrp packet and passes it to the speaker of the group
+func (o *PluginHsrpNs) HandleRxHsrpPacket(ps *core.ParserPacketState) int {
+	o.stats.pktRx++
+	p := ps.M.GetData()
+	if ps.L7Len == 0 {
+		o.stats.errTooShort++
+		return core.PARSER_ERR
+	}
+	d := p[ps.L7 : ps.L7+ps.L7Len]
+	ipv6 := p[ps.L3]>>4 == 6
+	var h hsrpHello
+	if ipv6 {
+		h.src = p[ps.L3+8 : ps.L3+24]
+	} else {
+		h.src = p[ps.L3+12 : ps.L3+16]
+	}
+	ver := decodeHsrp(d, &h)
+	if ver == 0 {
+		o.stats.errVersion++
+		return core.PARSER_ERR
+	}
+	c, ok := o.groups[hsrpKey{ver: ver, ipv6: ipv6, group: h.group}]
+	if !ok {
+		o.stats.pktRxNoGroup++
+		return core.PARSER_OK
+	}
+	c.onHello(&h)
+	return core.PARSER_OK
+}
+
+// HandleRxHsrpPacket Parser call this function with mbuf from the pool
+func HandleRxHsrpPacket(ps *core.ParserPacketState) int {
+	ns := ps.Tctx.GetNs(ps.Tun)
+	if ns == nil {
+		return core.PARSER_ERR
+	}
+	nsplg := ns.PluginCtx.Get(HSRP_PLUG)
+	if nsplg == nil {
+		// the ports of HSRP could be used by the other users of the namespace e.g. transport
+		return ps.Tctx.HandleUdpDefault(ps)
+	}
+	hsrpPlug := nsplg.Ext.(*PluginHsrpNs)
+	return hsrpPlug.HandleRxHsrpPacket(ps)
+}
+
+type PluginHsrpCReg struct{}
+type PluginHsrpNsReg struct{}
+
+func (o PluginHsrpCReg) NewPlugin(ctx *core.PluginCtx, initJson []byte) *core.PluginBase {
+	return NewHsrpClient(ctx, initJson)
+}
+
+func (o PluginHsrpNsReg) NewPlugin(ctx *core.PluginCtx, initJson []byte) *core.PluginBase {
+	return NewHsrpNs(ctx, initJson)
+}
+
+/*******************************************/
+/*  RPC commands */
+type (
+	ApiHsrpClientCntHandler  struct{}
+	ApiHsrpClientInfoHandler struct{}
+	ApiHsrpNsCntHandler      struct{}
+)
+
+func getHsrpClient(ctx interface{}, params *fastjson.RawMessage) (*PluginHsrpClient, *jsonrpc.Error) {
+	tctx := ctx.(*core.CThreadCtx)
+	plug, err := tctx.GetClientPlugin(params, HSRP_PLUG)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+	return plug.Ext.(*PluginHsrpClient), nil
+}
+
+func (h ApiHsrpClientCntHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	var p core.ApiCntParams
+	tctx := ctx.(*core.CThreadCtx)
+	c, err := getHsrpClient(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	return c.cdbv.GeneralCounters(err, tctx, params, &p)
+}
+
+func (h ApiHsrpClientInfoHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	c, err := getHsrpClient(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	return c.getInfo(), nil
+}
+
+func (h ApiHsrpNsCntHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	var p core.ApiCntParams
+	tctx := ctx.(*core.CThreadCtx)
+	plug, err := tctx.GetNsPlugin(params, HSRP_PLUG)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+	return plug.Ext.(*PluginHsrpNs).cdbv.GeneralCounters(nil, tctx, params, &p)
+}
+
+func init() {
+
+	/* register of plugins callbacks for ns,c level  */
+	core.PluginRegister(HSRP_PLUG,
+		core.PluginRegisterData{Client: PluginHsrpCReg{},
+			Ns:     PluginHsrpNsReg{},
+			Thread: nil}) /* no need for thread context for now */
+
+	core.RegisterCB("hsrp_client_cnt", ApiHsrpClientCntHandler{}, false)   // get counters/meta
+	core.RegisterCB("hsrp_client_info", ApiHsrpClientInfoHandler{}, false) // election state
+	core.RegisterCB("hsrp_ns_cnt", ApiHsrpNsCntHandler{}, false)           // get counters/meta of the rx side
+
+	/* register callback for rx side*/
+	core.ParserRegister(HSRP_PLUG, HandleRxHsrpPacket,
+		core.ParserRegisterData{UdpPorts: []uint16{HSRP_PORT}, UdpV6Ports: []uint16{HSRP_V6_PORT}})
+}
@@ -0,0 +1,798 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package fhrp
+
+/*
+vrrp router of one virtual router (VRID) of the client, v2 (IPv4) or v3 (IPv4 or IPv6).
+
+init json:
+
+	{"vrid": 1, "ver": 3, "prio": 100, "ipv4": [[16, 0, 0, 254]], "interval": 1000, "preempt": true}
+
+ipv4/ipv6 are the virtual IPs, the first IPv6 should be link-local. interval is the advertisement interval in msec,
+seconds for v2 and centiseconds for v3. A priority of 255 is the owner of the addresses, it is master at once.
+
+*/
+
+import (
+	"emu/core"
+	"encoding/binary"
+	"external/google/gopacket/layers"
+	"external/osamingo/jsonrpc"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/intel-go/fastjson"
+)
+
+const (
+	VRRP_PLUG               = "vrrp"
+	VRRP_PROTO              = 112
+	VRRP_TTL                = 255
+	VRRP_HEADER_SIZE        = 8
+	VRRP_V2_AUTH_SIZE       = 8
+	VRRP_TYPE_ADVERT        = 1
+	VRRP_PRIO_OWNER         = 255
+	VRRP_DEF_PRIO           = 100
+	VRRP_DEF_INTERVAL_MS    = 1000
+	VRRP_V2_MAX_INTERVAL_MS = 255000
+	VRRP_V3_MAX_INTERVAL_MS = 40950
+	VRRP_MAX_ADDRS          = 255
+
+	VRRP_STATE_INIT   = 0
+	VRRP_STATE_BACKUP = 1
+	VRRP_STATE_MASTER = 2
+)
+
+var vrrpIpv4Group = core.Ipv4Key{224, 0, 0, 18}
+var vrrpIpv6Group = ipv6Key(net.ParseIP("ff02::12"))
+var vrrpStateNames = []string{"init", "backup", "master"}
+
+type VrrpInit struct {
+	Vrid     uint8          `json:"vrid" validate:"required"`
+	Ver      uint8          `json:"ver" validate:"oneof=2 3"`
+	Prio     uint8          `json:"prio" validate:"required"`
+	Ipv4     []core.Ipv4Key `json:"ipv4"`
+	Ipv6     []core.Ipv6Key `json:"ipv6"`
+	Interval uint32         `json:"interval" validate:"required"` // msec
+	Preempt  bool           `json:"preempt"`
+}
+
+// VrrpInfo election state for the RPC
+type VrrpInfo struct {
+	Vrid       uint8    `json:"vrid"`
+	Ver        uint8    `json:"ver"`
+	State      string   `json:"state"`
+	Prio       uint8    `json:"prio"`
+	Vips       []string `json:"vips"`
+	Vmac       string   `json:"vmac"`
+	MasterIp   string   `json:"master_ip,omitempty"`
+	MasterPrio uint8    `json:"master_prio"`
+	MasterAdv  uint32   `json:"master_adv"` // msec
+	Remaining  uint32   `json:"remaining"`  // msec, to the next advert (master) or to master down (backup)
+	InitErr    string   `json:"init_error,omitempty"`
+}
+
+type vrrpKey struct {
+	ipv6 bool
+	vrid uint8
+}
+
+type VrrpStats struct {
+	pktTx           uint64
+	pktTxPrio0      uint64
+	pktTxAnnounce   uint64
+	pktRx           uint64
+	pktRxPrio0      uint64
+	pktRxAddrDiff   uint64
+	errVersion      uint64
+	errInterval     uint64
+	errInitJson     uint64
+	errVip          uint64
+	toMaster        uint64
+	toBackup        uint64
+	masterDownTimer uint64
+}
+
+func NewVrrpStatsDb(o *VrrpStats) *core.CCounterDb {
+	db := core.NewCCounterDb("vrrp")
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTx,
+		Name:     "pktTx",
+		Help:     "advertisements sent",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTxPrio0,
+		Name:     "pktTxPrio0",
+		Help:     "advertisements sent with priority 0, master stops",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTxAnnounce,
+		Name:     "pktTxAnnounce",
+		Help:     "gratuitous arp/unsolicited na sent for the virtual ips",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRx,
+		Name:     "pktRx",
+		Help:     "advertisements received",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxPrio0,
+		Name:     "pktRxPrio0",
+		Help:     "advertisements received with priority 0",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxAddrDiff,
+		Name:     "pktRxAddrDiff",
+		Help:     "advertisements with other virtual ips",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.errVersion,
+		Name:     "errVersion",
+		Help:     "advertisements of another version",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.errInterval,
+		Name:     "errInterval",
+		Help:     "v2 advertisements with another interval",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.errInitJson,
+		Name:     "errInitJson",
+		Help:     "invalid init json",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.errVip,
+		Name:     "errVip",
+		Help:     "master can't own the virtual ips, exist in the namespace",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.toMaster,
+		Name:     "toMaster",
+		Help:     "transitions to master",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.toBackup,
+		Name:     "toBackup",
+		Help:     "transitions to backup",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.masterDownTimer,
+		Name:     "masterDownTimer",
+		Help:     "master down timer expired",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	return db
+}
+
+type PluginVrrpClientTimer struct {
+}
+
+func (o *PluginVrrpClientTimer) OnEvent(a, b interface{}) {
+	pi := a.(*PluginVrrpClient)
+	pi.onTimerEvent()
+}
+
+// PluginVrrpClient a vrrp router of one virtual router
+type PluginVrrpClient struct {
+	core.PluginBase
+	vrrpNsPlug  *PluginVrrpNs
+	init        VrrpInit
+	key         vrrpKey
+	enable      bool
+	srcIp       []byte // the primary address, for the tie break
+	src6        core.Ipv6Key
+	vips        fhrpVips
+	state       uint8
+	masterIp    []byte
+	masterPrio  uint8
+	masterAdvMs uint32
+	timerw      *core.TimerCtx
+	timer       core.CHTimerObj
+	timerCb     PluginVrrpClientTimer
+	expire      uint64 // ticks
+	stats       VrrpStats
+	cdb         *core.CCounterDb
+	cdbv        *core.CCounterDbVec
+	initErr     string
+}
+
+var vrrpEvents = []string{}
+
+/*NewVrrpClient create plugin */
+func NewVrrpClient(ctx *core.PluginCtx, initJson []byte) *core.PluginBase {
+
+	o := new(PluginVrrpClient)
+	o.InitPluginBase(ctx, o)             /* init base object*/
+	o.RegisterEvents(ctx, vrrpEvents, o) /* register events, only if exits*/
+	nsplg := o.Ns.PluginCtx.GetOrCreate(VRRP_PLUG)
+	o.vrrpNsPlug = nsplg.Ext.(*PluginVrrpNs)
+	o.OnCreate(initJson)
+
+	return &o.PluginBase
+}
+
+func (o *PluginVrrpClient) OnCreate(initJson []byte) {
+	o.timerw = o.Tctx.GetTimerCtx()
+	o.cdb = NewVrrpStatsDb(&o.stats)
+	o.cdbv = core.NewCCounterDbVec("vrrp")
+	o.cdbv.Add(o.cdb)
+	o.timer.SetCB(&o.timerCb, o, 0)
+
+	o.init = VrrpInit{Ver: 3, Prio: VRRP_DEF_PRIO, Interval: VRRP_DEF_INTERVAL_MS, Preempt: true}
+	err := o.Tctx.UnmarshalValidate(initJson, &o.init)
+	if err == nil {
+		err = o.validate()
+	}
+	if err != nil {
+		o.stats.errInitJson++
+		o.initErr = err.Error()
+		return
+	}
+	o.vrrpNsPlug.groups[o.key] = o
+	o.enable = true
+	if o.init.Prio == VRRP_PRIO_OWNER {
+		o.becomeMaster()
+	} else {
+		o.masterAdvMs = o.init.Interval
+		o.becomeBackup()
+	}
+}
+
+func (o *PluginVrrpClient) validate() error {
+	i := &o.init
+	ipv6 := len(i.Ipv6) > 0
+	switch {
+	case len(i.Ipv4) > 0 && ipv6:
+		return fmt.Errorf("vrrp should have ipv4 or ipv6 virtual ips, not both")
+	case len(i.Ipv4) == 0 && !ipv6:
+		return fmt.Errorf("vrrp should have ipv4 or ipv6 virtual ips")
+	case len(i.Ipv4)+len(i.Ipv6) > VRRP_MAX_ADDRS:
+		return fmt.Errorf("vrrp supports up to %d virtual ips", VRRP_MAX_ADDRS)
+	}
+	if i.Ver == 2 {
+		if ipv6 {
+			return fmt.Errorf("vrrp v2 supports only ipv4")
+		}
+		if i.Interval%1000 != 0 || i.Interval > VRRP_V2_MAX_INTERVAL_MS {
+			return fmt.Errorf("vrrp v2 interval %d should be in seconds, up to %d msec", i.Interval, VRRP_V2_MAX_INTERVAL_MS)
+		}
+	} else if i.Interval%10 != 0 || i.Interval > VRRP_V3_MAX_INTERVAL_MS {
+		return fmt.Errorf("vrrp v3 interval %d should be in centiseconds, up to %d msec", i.Interval, VRRP_V3_MAX_INTERVAL_MS)
+	}
+	o.key = vrrpKey{ipv6: ipv6, vrid: i.Vrid}
+	if _, ok := o.vrrpNsPlug.groups[o.key]; ok {
+		return fmt.Errorf("vrid %d already exists in the namespace", i.Vrid)
+	}
+	o.vips = fhrpVips{client: o.Client, mac: core.MACKey{0x00, 0x00, 0x5e, 0x00, 0x01, i.Vrid},
+		ipv4: i.Ipv4, ipv6: i.Ipv6}
+	if ipv6 {
+		if !i.Ipv6[0].ToIP().IsLinkLocalUnicast() {
+			return fmt.Errorf("the first virtual ipv6 should be link-local")
+		}
+		o.vips.mac[4] = 0x02
+		o.Client.GetIpv6LocalLink(&o.src6)
+		o.srcIp = o.src6[:]
+	} else {
+		if o.Client.Ipv4.IsZero() {
+			return fmt.Errorf("the client should have an ipv4")
+		}
+		o.srcIp = o.Client.Ipv4[:]
+	}
+	return nil
+}
+
+/*OnEvent support event change of IP  */
+func (o *PluginVrrpClient) OnEvent(msg string, a, b interface{}) {
+
+}
+
+func (o *PluginVrrpClient) GetCounterDbVec() *core.CCounterDbVec {
+	return o.cdbv
+}
+
+func (o *PluginVrrpClient) OnRemove(ctx *core.PluginCtx) {
+	ctx.UnregisterEvents(&o.PluginBase, vrrpEvents)
+	if o.timer.IsRunning() {
+		o.timerw.Stop(&o.timer)
+	}
+	if !o.enable {
+		return
+	}
+	if o.state == VRRP_STATE_MASTER {
+		// the backup takes over after the skew time
+		o.stats.pktTxPrio0++
+		o.sendAdvert(0)
+		o.vips.release()
+	}
+	delete(o.vrrpNsPlug.groups, o.key)
+}
+
+func (o *PluginVrrpClient) startTimer(msec uint32) {
+	if o.timer.IsRunning() {
+		o.timerw.Stop(&o.timer)
+	}
+	ticks := o.timerw.DurationToTicks(time.Duration(msec) * time.Millisecond)
+	o.expire = o.timerw.Ticks + uint64(ticks)
+	o.timerw.StartTicks(&o.timer, ticks)
+}
+
+// skewMs the skew time of the backup, a backup with a higher priority takes over first
+func (o *PluginVrrpClient) skewMs() uint32 {
+	if o.init.Ver == 2 {
+		return (256 - uint32(o.init.Prio)) * 1000 / 256
+	}
+	return (256 - uint32(o.init.Prio)) * o.masterAdvMs / 256
+}
+
+func (o *PluginVrrpClient) masterDownMs() uint32 {
+	return 3*o.masterAdvMs + o.skewMs()
+}
+
+func (o *PluginVrrpClient) becomeMaster() {
+	o.state = VRRP_STATE_MASTER
+	o.stats.toMaster++
+	o.masterIp = o.srcIp
+	o.masterPrio = o.init.Prio
+	o.masterAdvMs = o.init.Interval
+	if err := o.vips.own(); err != nil {
+		o.stats.errVip++
+	}
+	o.sendAdvert(o.init.Prio)
+	o.stats.pktTxAnnounce += uint64(o.vips.announce(o.Tctx))
+	o.startTimer(o.init.Interval)
+}
+
+func (o *PluginVrrpClient) becomeBackup() {
+	if o.state == VRRP_STATE_MASTER {
+		o.vips.release()
+	}
+	o.state = VRRP_STATE_BACKUP
+	o.stats.toBackup++
+	o.startTimer(o.masterDownMs())
+}
+
+func (o *PluginVrrpClient) onTimerEvent() {
+	switch o.state {
+	case VRRP_STATE_MASTER:
+		o.sendAdvert(o.init.Prio)
+		o.startTimer(o.init.Interval)
+	case VRRP_STATE_BACKUP:
+		o.stats.masterDownTimer++
+		o.becomeMaster()
+	}
+}
+
+func (o *PluginVrrpClient) addrLen() int {
+	if o.key.ipv6 {
+		return net.IPv6len
+	}
+	return net.IPv4len
+}
+
+func (o *PluginVrrpClient) sendAdvert(prio uint8) {
+	n := len(o.init.Ipv4) + len(o.init.Ipv6)
+	size := VRRP_HEADER_SIZE + n*o.addrLen()
+	if o.init.Ver == 2 {
+		size += VRRP_V2_AUTH_SIZE
+	}
+	d := make([]byte, size)
+	d[0] = o.init.Ver<<4 | VRRP_TYPE_ADVERT
+	d[1] = o.init.Vrid
+	d[2] = prio
+	d[3] = uint8(n)
+	if o.init.Ver == 2 {
+		d[5] = uint8(o.init.Interval / 1000) // no authentication
+	} else {
+		binary.BigEndian.PutUint16(d[4:6], uint16(o.init.Interval/10))
+	}
+	a := d[VRRP_HEADER_SIZE:]
+	for _, ip := range o.init.Ipv4 {
+		a = a[copy(a, ip[:]):]
+	}
+	for _, ip := range o.init.Ipv6 {
+		a = a[copy(a, ip[:]):]
+	}
+
+	var pkt []byte
+	if o.key.ipv6 {
+		var l3 int
+		pkt, l3 = fhrpIpv6Packet(o.Client, o.vips.mac, o.src6, vrrpIpv6Group, VRRP_TTL, VRRP_PROTO, d)
+		layers.IPv6Header(pkt[l3:]).FixL4ChecksumOffset(pkt[l3+IPV6_HEADER_SIZE:], 0, 6)
+	} else {
+		if o.init.Ver == 2 {
+			binary.BigEndian.PutUint16(d[6:8], layers.PktChecksum(d, 0))
+		}
+		var l3 int
+		pkt, l3 = fhrpIpv4Packet(o.Client, o.vips.mac, vrrpIpv4Group, VRRP_TTL, VRRP_PROTO, d)
+		if o.init.Ver == 3 {
+			l4 := pkt[l3+IPV4_HEADER_SIZE:]
+			binary.BigEndian.PutUint16(l4[6:8], layers.PktChecksumTcpUdp(l4, 0, layers.IPv4Header(pkt[l3:])))
+		}
+	}
+	o.stats.pktTx++
+	o.Tctx.Veth.SendBuffer(false, o.Client, pkt)
+}
+
+// sameAddrs compares the addresses of an advert with the virtual ips
+func (o *PluginVrrpClient) sameAddrs(addrs []byte) bool {
+	var a []byte
+	for _, ip := range o.init.Ipv4 {
+		a = append(a, ip[:]...)
+	}
+	for _, ip := range o.init.Ipv6 {
+		a = append(a, ip[:]...)
+	}
+	return string(a) == string(addrs)
+}
+
+// onAdvert the election, src is the address of the sender and advMs its interval
+func (o *PluginVrrpClient) onAdvert(src []byte, prio uint8, advMs uint32, addrs []byte) {
+	o.stats.pktRx++
+	if prio == 0 {
+		o.stats.pktRxPrio0++
+	}
+	if o.init.Ver == 2 && advMs != o.init.Interval {
+		o.stats.errInterval++
+		return
+	}
+	if !o.sameAddrs(addrs) {
+		o.stats.pktRxAddrDiff++
+	}
+	switch o.state {
+	case VRRP_STATE_MASTER:
+		if prio == 0 {
+			o.sendAdvert(o.init.Prio)
+			o.startTimer(o.init.Interval)
+		} else if fhrpIsHigher(uint32(prio), src, uint32(o.init.Prio), o.srcIp) {
+			o.setMaster(src, prio, advMs)
+			o.becomeBackup()
+		}
+	case VRRP_STATE_BACKUP:
+		if prio == 0 {
+			o.startTimer(o.skewMs())
+		} else if !o.init.Preempt || prio >= o.init.Prio {
+			o.setMaster(src, prio, advMs)
+			o.startTimer(o.masterDownMs())
+		}
+	}
+}
+
+func (o *PluginVrrpClient) setMaster(src []byte, prio uint8, advMs uint32) {
+	o.masterIp = append([]byte(nil), src...)
+	o.masterPrio = prio
+	if o.init.Ver == 3 {
+		o.masterAdvMs = advMs
+	}
+}
+
+func (o *PluginVrrpClient) getInfo() *VrrpInfo {
+	i := &VrrpInfo{Vrid: o.init.Vrid, Ver: o.init.Ver, State: vrrpStateNames[o.state], Prio: o.init.Prio,
+		Vips: o.vips.strings(), Vmac: net.HardwareAddr(o.vips.mac[:]).String(), MasterPrio: o.masterPrio,
+		MasterAdv: o.masterAdvMs, InitErr: o.initErr}
+	if o.masterIp != nil {
+		i.MasterIp = net.IP(o.masterIp).String()
+	}
+	if o.timer.IsRunning() && o.expire > o.timerw.Ticks {
+		i.Remaining = uint32(time.Duration(o.expire-o.timerw.Ticks) * o.timerw.TickDuration / time.Millisecond)
+	}
+	return i
+}
+
+type VrrpNsStats struct {
+	pktRx        uint64
+	pktRxNoGroup uint64
+	errTooShort  uint64
+	errTtl       uint64
+	errVersion   uint64
+	errType      uint64
+	errChecksum  uint64
+}
+
+func NewVrrpNsStatsDb(o *VrrpNsStats) *core.CCounterDb {
+	db := core.NewCCounterDb("vrrpns")
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRx,
+		Name:     "pktRx",
+		Help:     "vrrp packets received",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxNoGroup,
+		Name:     "pktRxNoGroup",
+		Help:     "advertisements of a virtual router that is not emulated",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.errTooShort,
+		Name:     "errTooShort",
+		Help:     "packet is too short",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.errTtl,
+		Name:     "errTtl",
+		Help:     "ttl/hop limit is not 255",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.errVersion,
+		Name:     "errVersion",
+		Help:     "version is not supported",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.errType,
+		Name:     "errType",
+		Help:     "type is not advertisement",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.errChecksum,
+		Name:     "errChecksum",
+		Help:     "wrong checksum",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	return db
+}
+
+// PluginVrrpNs the virtual routers of the namespace
+type PluginVrrpNs struct {
+	core.PluginBase
+	groups map[vrrpKey]*PluginVrrpClient
+	stats  VrrpNsStats
+	cdb    *core.CCounterDb
+	cdbv   *core.CCounterDbVec
+}
+
+func NewVrrpNs(ctx *core.PluginCtx, initJson []byte) *core.PluginBase {
+	o := new(PluginVrrpNs)
+	o.InitPluginBase(ctx, o)
+	o.RegisterEvents(ctx, []string{}, o)
+	o.groups = make(map[vrrpKey]*PluginVrrpClient)
+	o.cdb = NewVrrpNsStatsDb(&o.stats)
+	o.cdbv = core.NewCCounterDbVec("vrrpns")
+	o.cdbv.Add(o.cdb)
+	return &o.PluginBase
+}
+
+func (o *PluginVrrpNs) OnRemove(ctx *core.PluginCtx) {
+}
+
+func (o *PluginVrrpNs) OnEvent(msg string, a, b interface{}) {
+
+}
+
+func (o *PluginVrrpNs) GetCounterDbVec() *core.CCounterDbVec {
+	return o.cdbv
+}
+
+// HandleRxVrrpPacket checks the advertisement and passes it to the router of the group
+func (o *PluginVrrpNs) HandleRxVrrpPacket(ps *core.ParserPacketState) int {
+	o.stats.pktRx++
+	p := ps.M.GetData()
+	ipv6 := p[ps.L3]>>4 == 6
+	var l4len int
+	var ttl uint8
+	var src []byte
+	if ipv6 {
+		hdr := layers.IPv6Header(p[ps.L3 : ps.L3+IPV6_HEADER_SIZE])
+		l4len = int(hdr.PayloadLength()) - int(ps.L4-ps.L3-IPV6_HEADER_SIZE)
+		ttl = hdr.HopLimit()
+		src = hdr.SrcIP()
+	} else {
+		hdr := layers.IPv4Header(p[ps.L3:ps.L4])
+		l4len = int(hdr.GetLength()) - int(ps.L4-ps.L3)
+		ttl = hdr.GetTTL()
+		src = hdr[12:16]
+	}
+	if l4len < VRRP_HEADER_SIZE {
+		o.stats.errTooShort++
+		return core.PARSER_ERR
+	}
+	d := p[ps.L4 : int(ps.L4)+l4len]
+	if ttl != VRRP_TTL {
+		o.stats.errTtl++
+		return core.PARSER_ERR
+	}
+	ver := d[0] >> 4
+	if ver != 3 && (ver != 2 || ipv6) {
+		o.stats.errVersion++
+		return core.PARSER_ERR
+	}
+	if d[0]&0xf != VRRP_TYPE_ADVERT {
+		o.stats.errType++
+		return core.PARSER_ERR
+	}
+	addrLen := net.IPv4len
+	if ipv6 {
+		addrLen = net.IPv6len
+	}
+	size := VRRP_HEADER_SIZE + int(d[3])*addrLen
+	if l4len < size {
+		o.stats.errTooShort++
+		return core.PARSER_ERR
+	}
+
+	var cs uint16
+	switch {
+	case ipv6:
+		hdr := layers.IPv6Header(p[ps.L3 : ps.L3+IPV6_HEADER_SIZE])
+		cs = layers.PktChecksumTcpUdpV6(d, 0, hdr, ps.L4-ps.L3-IPV6_HEADER_SIZE, VRRP_PROTO)
+	case ver == 2:
+		cs = layers.PktChecksum(d, 0)
+	default:
+		cs = layers.PktChecksumTcpUdp(d, 0, layers.IPv4Header(p[ps.L3:ps.L4]))
+	}
+	if cs != 0 {
+		o.stats.errChecksum++
+		return core.PARSER_ERR
+	}
+
+	c, ok := o.groups[vrrpKey{ipv6: ipv6, vrid: d[1]}]
+	if !ok {
+		o.stats.pktRxNoGroup++
+		return core.PARSER_OK
+	}
+	if c.init.Ver != ver {
+		c.stats.errVersion++
+		return core.PARSER_OK
+	}
+	advMs := uint32(binary.BigEndian.Uint16(d[4:6])&0xfff) * 10
+	if ver == 2 {
+		advMs = uint32(d[5]) * 1000
+	}
+	c.onAdvert(src, d[2], advMs, d[VRRP_HEADER_SIZE:size])
+	return core.PARSER_OK
+}
+
+// HandleRxVrrpPacket Parser call this function with mbuf from the pool
+func HandleRxVrrpPacket(ps *core.ParserPacketState) int {
+	ns := ps.Tctx.GetNs(ps.Tun)
+	if ns == nil {
+		return core.PARSER_ERR
+	}
+	nsplg := ns.PluginCtx.Get(VRRP_PLUG)
+	if nsplg == nil {
+		return core.PARSER_ERR
+	}
+	vrrpPlug := nsplg.Ext.(*PluginVrrpNs)
+	return vrrpPlug.HandleRxVrrpPacket(ps)
+}
+
+type PluginVrrpCReg struct{}
+type PluginVrrpNsReg struct{}
+
+func (o PluginVrrpCReg) NewPlugin(ctx *core.PluginCtx, initJson []byte) *core.PluginBase {
+	return NewVrrpClient(ctx, initJson)
+}
+
+func (o PluginVrrpNsReg) NewPlugin(ctx *core.PluginCtx, initJson []byte) *core.PluginBase {
+	return NewVrrpNs(ctx, initJson)
+}
+
+/*******************************************/
+/*  RPC commands */
+type (
+	ApiVrrpClientCntHandler  struct{}
+	ApiVrrpClientInfoHandler struct{}
+	ApiVrrpNsCntHandler      struct{}
+)
+
+func getVrrpClient(ctx interface{}, params *fastjson.RawMessage) (*PluginVrrpClient, *jsonrpc.Error) {
+	tctx := ctx.(*core.CThreadCtx)
+	plug, err := tctx.GetClientPlugin(params, VRRP_PLUG)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+	return plug.Ext.(*PluginVrrpClient), nil
+}
+
+func (h ApiVrrpClientCntHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	var p core.ApiCntParams
+	tctx := ctx.(*core.CThreadCtx)
+	c, err := getVrrpClient(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	return c.cdbv.GeneralCounters(err, tctx, params, &p)
+}
+
+func (h ApiVrrpClientInfoHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	c, err := getVrrpClient(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	return c.getInfo(), nil
+}
+
+func (h ApiVrrpNsCntHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	var p core.ApiCntParams
+	tctx := ctx.(*core.CThreadCtx)
+	plug, err := tctx.GetNsPlugin(params, VRRP_PLUG)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+	return plug.Ext.(*PluginVrrpNs).cdbv.GeneralCounters(nil, tctx, params, &p)
+}
+
+func init() {
+
+	/* register of plugins callbacks for ns,c level  */
+	core.PluginRegister(VRRP_PLUG,
+		core.PluginRegisterData{Client: PluginVrrpCReg{},
+			Ns:     PluginVrrpNsReg{},
+			Thread: nil}) /* no need for thread context for now */
+
+	core.RegisterCB("vrrp_client_cnt", ApiVrrpClientCntHandler{}, false)   // get counters/meta
+	core.RegisterCB("vrrp_client_info", ApiVrrpClientInfoHandler{}, false) // election state
+	core.RegisterCB("vrrp_ns_cnt", ApiVrrpNsCntHandler{}, false)           // get counters/meta of the rx side
+
+	/* register callback for rx side*/
+	core.ParserRegister(VRRP_PLUG, HandleRxVrrpPacket,
+		core.ParserRegisterData{IpProtos: []uint8{VRRP_PROTO}})
+}
@@ -0,0 +1,1100 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package http
+
+/*
+HTTP/1.1 client, client plugin
+
+The client sends the sequence of requests over TCP connections of the transport layer, loops times (0 is forever) with
+interval msec between the loops. A connection is kept alive for the next requests to the same host:port, up to pipeline
+requests are sent without waiting for the responses. keep_alive false opens a connection per request.
+
+The host of a url is resolved by the dns plugin of the client, see dns.ResolveAddr. The sequence starts when the
+default gateway is resolved. A request without a response for timeout msec fails and its connection is reset.
+
+The latency of a request is measured from the write of the request to the end of the response, the histogram of each
+request of the sequence is returned by http_client_requests.
+
+The namespace init json is the default of the clients, the client init json overrides it.
+
+client init json {
+	"requests": [
+		{"method": "GET", "url": "http://48.0.0.1/index.html"},
+		{"method": "POST", "url": "http://www.example.com:8080/upload", "headers": {"Content-Type": "text/plain"}, "body_size": 1024}
+	],
+	"headers": {"Accept": "*\/*"},
+	"keep_alive": true,
+	"pipeline": 1,
+	"loops": 1,
+	"interval": 0,
+	"timeout": 5000,
+	"ipv6": false
+}
+
+headers are added to all the requests, body is sent instead of a generated body of body_size bytes. ipv6 resolves the
+hosts by AAAA queries.
+
+*/
+
+import (
+	"emu/core"
+	"emu/plugins/dns"
+	"emu/plugins/transport"
+	"external/osamingo/jsonrpc"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/intel-go/fastjson"
+)
+
+const (
+	HTTP_PLUG        = "http"
+	HTTP_DEF_TIMEOUT = 5000
+	HTTP_MAX_STATUS  = 64
+	HTTP_ERR_TIMEOUT = "timeout"
+	HTTP_ERR_CLOSED  = "connection closed"
+)
+
+// timers of a client
+const (
+	httpTimerRun = iota
+	httpTimerResponse
+)
+
+type HttpRequestInit struct {
+	Method   string            `json:"method"`
+	Url      string            `json:"url" validate:"required"`
+	Headers  map[string]string `json:"headers"`
+	Body     string            `json:"body"`
+	BodySize uint32            `json:"body_size" validate:"lte=16777216"`
+}
+
+type HttpInit struct {
+	Requests  []HttpRequestInit `json:"requests" validate:"dive"`
+	Headers   map[string]string `json:"headers"`
+	KeepAlive bool              `json:"keep_alive"`
+	Pipeline  uint16            `json:"pipeline" validate:"lte=32"`
+	Loops     uint32            `json:"loops"`
+	Interval  uint32            `json:"interval"` // msec
+	Timeout   uint32            `json:"timeout"`  // msec
+	Ipv6      bool              `json:"ipv6"`
+}
+
+// HttpRequestInfo the counters and the latency histogram of a request of the sequence
+type HttpRequestInfo struct {
+	Method     string            `json:"method"`
+	Url        string            `json:"url"`
+	Sent       uint64            `json:"sent"`
+	Responses  uint64            `json:"responses"`
+	Errors     uint64            `json:"errors"`
+	LastError  string            `json:"last_error,omitempty"`
+	Status     map[string]uint64 `json:"status"`      // by the status code
+	LatencyMin uint64            `json:"latency_min"` // usec
+	LatencyAvg uint64            `json:"latency_avg"`
+	LatencyMax uint64            `json:"latency_max"`
+	Histogram  map[string]uint64 `json:"histogram"` // by the upper bound of the latency
+}
+
+type HttpStats struct {
+	reqTx             uint64
+	rspRx             uint64
+	rsp1xx            uint64
+	rsp2xx            uint64
+	rsp3xx            uint64
+	rsp4xx            uint64
+	rsp5xx            uint64
+	bytesTx           uint64
+	bytesRx           uint64
+	bodyRx            uint64
+	connOpen          uint64
+	connCloseByServer uint64
+	retries           uint64
+	loops             uint64
+	latencyMin        uint64
+	latencyAvg        uint64
+	latencyMax        uint64
+	latencyUnder1ms   uint64
+	latencyUnder10ms  uint64
+	latencyUnder100ms uint64
+	latencyUnder1s    uint64
+	latencyOver1s     uint64
+	errInitJson       uint64
+	errResolve        uint64
+	errDial           uint64
+	errConnect        uint64
+	errTimeout        uint64
+	errConnClosed     uint64
+	errParse          uint64
+	errRxUnexpected   uint64
+	errTx             uint64
+}
+
+func NewHttpStatsDb(o *HttpStats) *core.CCounterDb {
+	db := core.NewCCounterDb(HTTP_PLUG)
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.reqTx,
+		Name:     "reqTx",
+		Help:     "requests sent",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.rspRx,
+		Name:     "rspRx",
+		Help:     "responses received",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.rsp1xx,
+		Name:     "rsp1xx",
+		Help:     "informational responses, not counted by rspRx",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.rsp2xx,
+		Name:     "rsp2xx",
+		Help:     "successful responses",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.rsp3xx,
+		Name:     "rsp3xx",
+		Help:     "redirection responses",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.rsp4xx,
+		Name:     "rsp4xx",
+		Help:     "client error responses",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.rsp5xx,
+		Name:     "rsp5xx",
+		Help:     "server error responses",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.bytesTx,
+		Name:     "bytesTx",
+		Help:     "bytes of the requests",
+		Unit:     "bytes",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.bytesRx,
+		Name:     "bytesRx",
+		Help:     "bytes of the responses",
+		Unit:     "bytes",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.bodyRx,
+		Name:     "bodyRx",
+		Help:     "bytes of the bodies of the responses",
+		Unit:     "bytes",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.connOpen,
+		Name:     "connOpen",
+		Help:     "connections opened",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.connCloseByServer,
+		Name:     "connCloseByServer",
+		Help:     "connections closed by the server",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.retries,
+		Name:     "retries",
+		Help:     "pipelined requests sent again, the server closed the connection",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.loops,
+		Name:     "loops",
+		Help:     "loops of the sequence",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.latencyMin,
+		Name:     "latencyMin",
+		Help:     "minimal latency of the responses",
+		Unit:     "usec",
+		DumpZero: false,
+		Gauge:    true,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.latencyAvg,
+		Name:     "latencyAvg",
+		Help:     "average latency of the responses",
+		Unit:     "usec",
+		DumpZero: false,
+		Gauge:    true,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.latencyMax,
+		Name:     "latencyMax",
+		Help:     "maximal latency of the responses",
+		Unit:     "usec",
+		DumpZero: false,
+		Gauge:    true,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.latencyUnder1ms,
+		Name:     "latencyUnder1ms",
+		Help:     "responses under 1 msec",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.latencyUnder10ms,
+		Name:     "latencyUnder10ms",
+		Help:     "responses under 10 msec",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.latencyUnder100ms,
+		Name:     "latencyUnder100ms",
+		Help:     "responses under 100 msec",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.latencyUnder1s,
+		Name:     "latencyUnder1s",
+		Help:     "responses under 1 sec",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.latencyOver1s,
+		Name:     "latencyOver1s",
+		Help:     "responses over 1 sec",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.errInitJson,
+		Name:     "errInitJson",
+		Help:     "invalid init json",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.errResolve,
+		Name:     "errResolve",
+		Help:     "can't resolve the host of the url",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.errDial,
+		Name:     "errDial",
+		Help:     "can't open a socket",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.errConnect,
+		Name:     "errConnect",
+		Help:     "the connection was closed before it was established, e.g. refused",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.errTimeout,
+		Name:     "errTimeout",
+		Help:     "requests without a response",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.errConnClosed,
+		Name:     "errConnClosed",
+		Help:     "requests failed by the close of the connection",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.errParse,
+		Name:     "errParse",
+		Help:     "malformed responses",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.errRxUnexpected,
+		Name:     "errRxUnexpected",
+		Help:     "responses without a request",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.errTx,
+		Name:     "errTx",
+		Help:     "can't write to the socket",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	return db
+}
+
+// httpStep is a request of the sequence
+type httpStep struct {
+	init      *HttpRequestInit
+	method    string
+	addr      string // host:port of the url
+	head      []byte // the request line and the header
+	body      []byte
+	sent      uint64
+	responses uint64
+	errors    uint64
+	lastError string
+	status    map[int]uint64
+	latency   httpLatency
+}
+
+// httpInflight is a request that waits for the response
+type httpInflight struct {
+	idx   int
+	start time.Time
+}
+
+// httpConn is a connection to a host:port
+type httpConn struct {
+	plug      *PluginHttpClient
+	socket    transport.SocketApi
+	w         httpWriter
+	parser    httpParser
+	addr      string
+	inflight  []httpInflight
+	sent      int
+	responses int
+	connected bool
+	noReuse   bool // the server closes the connection
+	closed    bool
+}
+
+func (o *httpConn) OnRxEvent(event transport.SocketEventType) {
+	if o.closed {
+		return
+	}
+	if event&transport.SocketEventConnected > 0 {
+		o.connected = true
+		o.plug.run()
+	}
+	if event&transport.SocketRemoteDisconnect > 0 {
+		o.plug.onRemoteClose(o)
+	}
+	if event&transport.SocketClosed > 0 {
+		o.plug.onClosed(o)
+	}
+}
+
+func (o *httpConn) OnTxEvent(event transport.SocketEventType) {
+	if o.closed {
+		return
+	}
+	if event&transport.SocketTxMore > 0 {
+		if o.w.onTxMore() != transport.SeOK {
+			o.plug.stats.errTx++
+		}
+	}
+}
+
+func (o *httpConn) OnRxData(d []byte) {
+	if o.closed {
+		return
+	}
+	o.plug.stats.bytesRx += uint64(len(d))
+	if err := o.parser.feed(d); err != nil {
+		o.plug.fail(o, err.Error(), &o.plug.stats.errParse)
+	}
+}
+
+type PluginHttpClientTimer struct {
+}
+
+func (o *PluginHttpClientTimer) OnEvent(a, b interface{}) {
+	pi := a.(*PluginHttpClient)
+	switch b.(int) {
+	case httpTimerRun:
+		pi.onRunTimer()
+	case httpTimerResponse:
+		pi.onResponseTimer()
+	}
+}
+
+// PluginHttpClient the HTTP client of a client
+type PluginHttpClient struct {
+	core.PluginBase
+	httpNsPlug *PluginHttpNs
+	init       HttpInit
+	enable     bool
+	started    bool
+	done       bool
+	steps      []httpStep
+	next       int // the next request of the loop
+	loop       uint32
+	conn       *httpConn
+	resolving  bool
+	latency    httpLatency
+	timer      core.CHTimerObj
+	respTimer  core.CHTimerObj
+	timerw     *core.TimerCtx
+	timerCb    PluginHttpClientTimer
+	stats      HttpStats
+	cdb        *core.CCounterDb
+	cdbv       *core.CCounterDbVec
+	initErr    string
+}
+
+var httpEvents = []string{core.MSG_DG_MAC_RESOLVED}
+
+/*NewHttpClient create plugin */
+func NewHttpClient(ctx *core.PluginCtx, initJson []byte) *core.PluginBase {
+
+	o := new(PluginHttpClient)
+	o.InitPluginBase(ctx, o)             /* init base object*/
+	o.RegisterEvents(ctx, httpEvents, o) /* register events, only if exits*/
+	nsplg := o.Ns.PluginCtx.GetOrCreate(HTTP_PLUG)
+	o.httpNsPlug = nsplg.Ext.(*PluginHttpNs)
+	o.OnCreate(initJson)
+
+	return &o.PluginBase
+}
+
+func (o *PluginHttpClient) OnCreate(initJson []byte) {
+	o.timerw = o.Tctx.GetTimerCtx()
+	o.cdb = NewHttpStatsDb(&o.stats)
+	o.cdbv = core.NewCCounterDbVec(HTTP_PLUG)
+	o.cdbv.Add(o.cdb)
+	o.timer.SetCB(&o.timerCb, o, httpTimerRun)
+	o.respTimer.SetCB(&o.timerCb, o, httpTimerResponse)
+
+	// the headers of the client json are added to a copy of the namespace headers, the other fields replace them.
+	// The requests are decoded into a new slice, otherwise the decoder reuses the requests of the namespace.
+	o.init = o.httpNsPlug.init
+	o.init.Headers = make(map[string]string, len(o.httpNsPlug.init.Headers))
+	for k, v := range o.httpNsPlug.init.Headers {
+		o.init.Headers[k] = v
+	}
+	o.init.Requests = nil
+	var err error
+	if len(initJson) > 0 {
+		err = o.Tctx.UnmarshalValidate(initJson, &o.init)
+	}
+	if o.init.Requests == nil {
+		o.init.Requests = o.httpNsPlug.init.Requests
+	}
+	if err == nil {
+		err = o.validate()
+	}
+	if err != nil {
+		o.stats.errInitJson++
+		o.initErr = err.Error()
+		return
+	}
+	// the rx side of the sockets
+	o.Client.PluginCtx.GetOrCreate(transport.TRANS_PLUG)
+	o.enable = true
+	if _, ok := o.Client.ResolveIPv4DGMac(); ok {
+		o.start()
+	} else if _, ok := o.Client.ResolveIPv6DGMac(); ok {
+		o.start()
+	}
+}
+
+func (o *PluginHttpClient) validate() error {
+	i := &o.init
+	if len(i.Requests) == 0 {
+		return fmt.Errorf("no requests")
+	}
+	if i.Pipeline == 0 || !i.KeepAlive {
+		i.Pipeline = 1
+	}
+	if i.Timeout == 0 {
+		i.Timeout = HTTP_DEF_TIMEOUT
+	}
+	o.steps = make([]httpStep, len(i.Requests))
+	for k := range i.Requests {
+		if err := o.newStep(&o.steps[k], &i.Requests[k]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newStep builds the request, the header fields of the request override the common ones
+func (o *PluginHttpClient) newStep(s *httpStep, r *HttpRequestInit) error {
+	s.init = r
+	s.status = make(map[int]uint64)
+	s.method = strings.ToUpper(r.Method)
+	if s.method == "" {
+		s.method = "GET"
+	}
+	u, err := url.Parse(r.Url)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" || u.Hostname() == "" {
+		return fmt.Errorf("invalid url %q, should be http://host[:port]/path", r.Url)
+	}
+	port := u.Port()
+	if port == "" {
+		port = strconv.Itoa(HTTP_PORT)
+	}
+	s.addr = net.JoinHostPort(u.Hostname(), port)
+	if r.BodySize > 0 || r.Body != "" {
+		s.body = httpBody(r.Body, r.BodySize)
+	}
+
+	fields := map[string]string{"Host": u.Host, "User-Agent": HTTP_USER_AGENT}
+	for _, h := range []map[string]string{o.init.Headers, r.Headers} {
+		for n, v := range h {
+			for k := range fields {
+				if strings.EqualFold(k, n) {
+					delete(fields, k)
+				}
+			}
+			fields[n] = v
+		}
+	}
+	if len(s.body) > 0 || s.method == "POST" || s.method == "PUT" {
+		fields["Content-Length"] = strconv.Itoa(len(s.body))
+	}
+	if !o.init.KeepAlive && !hasField(fields, "Connection") {
+		fields["Connection"] = "close"
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s HTTP/1.1\r\n", s.method, u.RequestURI())
+	httpFields(&b, fields)
+	b.WriteString("\r\n")
+	s.head = []byte(b.String())
+	return nil
+}
+
+/*OnEvent the sequence starts when the default gateway is resolved */
+func (o *PluginHttpClient) OnEvent(msg string, a, b interface{}) {
+	switch msg {
+	case core.MSG_DG_MAC_RESOLVED:
+		if o.enable {
+			o.start()
+		}
+	}
+}
+
+func (o *PluginHttpClient) GetCounterDbVec() *core.CCounterDbVec {
+	return o.cdbv
+}
+
+func (o *PluginHttpClient) OnRemove(ctx *core.PluginCtx) {
+	ctx.UnregisterEvents(&o.PluginBase, httpEvents)
+	o.stopTimer(&o.timer)
+	o.stopTimer(&o.respTimer)
+	if o.conn != nil {
+		o.conn.closed = true
+		o.conn.socket.Shutdown()
+		o.conn = nil
+	}
+	o.enable = false
+}
+
+func (o *PluginHttpClient) stopTimer(t *core.CHTimerObj) {
+	if t.IsRunning() {
+		o.timerw.Stop(t)
+	}
+}
+
+func (o *PluginHttpClient) now() time.Time {
+	if o.Tctx.Simulation {
+		return time.Unix(0, int64(time.Duration(o.timerw.Ticks)*o.timerw.TickDuration))
+	}
+	return time.Now()
+}
+
+func (o *PluginHttpClient) start() {
+	if o.started {
+		return
+	}
+	o.started = true
+	o.run()
+}
+
+// schedule runs the sequence from the timer, not from the callbacks of the socket
+func (o *PluginHttpClient) schedule(d time.Duration) {
+	o.stopTimer(&o.timer)
+	if d == 0 {
+		o.timerw.StartTicks(&o.timer, 1)
+	} else {
+		o.timerw.Start(&o.timer, d)
+	}
+}
+
+// kick runs the sequence at the next tick, unless it waits for the interval
+func (o *PluginHttpClient) kick() {
+	if !o.timer.IsRunning() {
+		o.timerw.StartTicks(&o.timer, 1)
+	}
+}
+
+func (o *PluginHttpClient) onRunTimer() {
+	o.run()
+}
+
+// run sends the requests of the loop, it returns when it waits for a connection or for responses
+func (o *PluginHttpClient) run() {
+	for o.enable && !o.done && !o.resolving && !o.timer.IsRunning() {
+		c := o.conn
+		if o.next >= len(o.steps) {
+			if c != nil && len(c.inflight) > 0 {
+				return
+			}
+			o.endLoop()
+			return
+		}
+		s := &o.steps[o.next]
+		if c == nil {
+			o.connect(s)
+			return
+		}
+		if !c.connected {
+			return
+		}
+		if c.addr != s.addr || c.noReuse || (!o.init.KeepAlive && c.sent > 0) {
+			if len(c.inflight) > 0 {
+				return
+			}
+			o.closeConn()
+			continue
+		}
+		if len(c.inflight) >= int(o.init.Pipeline) {
+			return
+		}
+		o.send(c, o.next)
+		o.next++
+	}
+}
+
+func (o *PluginHttpClient) endLoop() {
+	o.loop++
+	o.stats.loops++
+	if !o.init.KeepAlive || (o.conn != nil && o.conn.noReuse) {
+		o.closeConn()
+	}
+	o.next = 0
+	if o.init.Loops != 0 && o.loop >= o.init.Loops {
+		o.done = true
+		o.closeConn()
+		return
+	}
+	o.schedule(time.Duration(o.init.Interval) * time.Millisecond)
+}
+
+func (o *PluginHttpClient) connect(s *httpStep) {
+	o.resolving = true
+	dns.ResolveAddr(o.Client, s.addr, o.init.Ipv6, func(addr string, err error) {
+		o.resolving = false
+		if !o.enable {
+			return
+		}
+		if err != nil {
+			o.skip(err.Error(), &o.stats.errResolve)
+			return
+		}
+		c := &httpConn{plug: o, addr: s.addr}
+		c.parser = httpParser{response: true, onHeader: c.noBody, onMessage: c.onResponse}
+		socket, err := transport.GetTransportCtx(o.Client).Dial("tcp", addr, c, nil, nil)
+		if err != nil {
+			o.skip(err.Error(), &o.stats.errDial)
+			return
+		}
+		c.socket = socket
+		c.w.socket = socket
+		o.conn = c
+		o.stats.connOpen++
+		// the timeout of the connection
+		o.stopTimer(&o.respTimer)
+		o.timerw.Start(&o.respTimer, time.Duration(o.init.Timeout)*time.Millisecond)
+	})
+}
+
+// skip fails the next request before it was sent and continues with the sequence
+func (o *PluginHttpClient) skip(reason string, counter *uint64) {
+	*counter++
+	s := &o.steps[o.next]
+	s.errors++
+	s.lastError = reason
+	o.next++
+	o.kick()
+}
+
+func (o *PluginHttpClient) send(c *httpConn, idx int) {
+	s := &o.steps[idx]
+	res := c.w.write(s.head)
+	if res == transport.SeOK && len(s.body) > 0 {
+		res = c.w.write(s.body)
+	}
+	if res != transport.SeOK {
+		o.stats.errTx++
+	}
+	o.stats.reqTx++
+	o.stats.bytesTx += uint64(len(s.head) + len(s.body))
+	s.sent++
+	c.sent++
+	c.inflight = append(c.inflight, httpInflight{idx: idx, start: o.now()})
+	if len(c.inflight) == 1 {
+		o.stopTimer(&o.respTimer)
+		o.timerw.Start(&o.respTimer, time.Duration(o.init.Timeout)*time.Millisecond)
+	}
+}
+
+// noBody returns true for the responses without a body
+func (o *httpConn) noBody(h *httpHeader) bool {
+	if h.status < 200 || h.status == 204 || h.status == 304 {
+		return true
+	}
+	return len(o.inflight) > 0 && o.plug.steps[o.inflight[0].idx].method == "HEAD"
+}
+
+func (o *httpConn) onResponse(h *httpHeader, bodyLen int64) {
+	p := o.plug
+	if h.status < 200 {
+		// e.g. 100 Continue, the final response follows
+		p.stats.rsp1xx++
+		return
+	}
+	if len(o.inflight) == 0 {
+		p.stats.errRxUnexpected++
+		o.noReuse = true
+		return
+	}
+	f := o.inflight[0]
+	o.inflight = o.inflight[1:]
+	o.responses++
+	s := &p.steps[f.idx]
+	s.responses++
+	if len(s.status) < HTTP_MAX_STATUS {
+		s.status[h.status]++
+	} else if _, ok := s.status[h.status]; ok {
+		s.status[h.status]++
+	}
+	st := &p.stats
+	st.rspRx++
+	st.bodyRx += uint64(bodyLen)
+	switch h.status / 100 {
+	case 2:
+		st.rsp2xx++
+	case 3:
+		st.rsp3xx++
+	case 4:
+		st.rsp4xx++
+	default:
+		st.rsp5xx++
+	}
+	d := p.now().Sub(f.start)
+	s.latency.update(d)
+	p.updateLatency(d)
+	if h.close {
+		o.noReuse = true
+	}
+	p.stopTimer(&p.respTimer)
+	if len(o.inflight) > 0 {
+		p.timerw.Start(&p.respTimer, time.Duration(p.init.Timeout)*time.Millisecond)
+	}
+	if p.conn == o {
+		p.run()
+	}
+}
+
+func (o *PluginHttpClient) updateLatency(d time.Duration) {
+	l := &o.latency
+	l.update(d)
+	st := &o.stats
+	st.latencyMin, st.latencyAvg, st.latencyMax = l.min, l.avg(), l.max
+	st.latencyUnder1ms, st.latencyUnder10ms, st.latencyUnder100ms = l.buckets[0], l.buckets[1], l.buckets[2]
+	st.latencyUnder1s, st.latencyOver1s = l.buckets[3], l.buckets[4]
+}
+
+// release forgets the connection, the requests without a response are sent again or failed
+func (o *PluginHttpClient) release(c *httpConn, reason string, counter *uint64) {
+	c.closed = true
+	if o.conn != c {
+		return
+	}
+	o.conn = nil
+	o.stopTimer(&o.respTimer)
+	if len(c.inflight) > 0 {
+		if c.responses > 0 && c.noReuse {
+			// the server closed a pipelined connection, send the rest on a new one
+			o.stats.retries += uint64(len(c.inflight))
+			o.next = c.inflight[0].idx
+		} else {
+			for _, f := range c.inflight {
+				*counter++
+				s := &o.steps[f.idx]
+				s.errors++
+				s.lastError = reason
+			}
+		}
+		c.inflight = nil
+	} else if !c.connected {
+		o.skip(reason, counter)
+		return
+	}
+	o.kick()
+}
+
+func (o *PluginHttpClient) onRemoteClose(c *httpConn) {
+	o.stats.connCloseByServer++
+	if !c.parser.eof() {
+		o.stats.errParse++
+	}
+	c.noReuse = true
+	c.closed = true
+	c.socket.Close()
+	o.release(c, HTTP_ERR_CLOSED, &o.stats.errConnClosed)
+}
+
+func (o *PluginHttpClient) onClosed(c *httpConn) {
+	if o.conn != c {
+		c.closed = true
+		return
+	}
+	reason := HTTP_ERR_CLOSED
+	if e := c.socket.GetLastError(); e != transport.SeOK {
+		reason = e.String()
+	}
+	counter := &o.stats.errConnClosed
+	if !c.connected {
+		counter = &o.stats.errConnect
+	}
+	o.release(c, reason, counter)
+}
+
+// fail resets the connection
+func (o *PluginHttpClient) fail(c *httpConn, reason string, counter *uint64) {
+	c.closed = true
+	c.socket.Shutdown()
+	o.release(c, reason, counter)
+}
+
+func (o *PluginHttpClient) onResponseTimer() {
+	if o.conn != nil {
+		o.fail(o.conn, HTTP_ERR_TIMEOUT, &o.stats.errTimeout)
+	}
+}
+
+func (o *PluginHttpClient) closeConn() {
+	c := o.conn
+	if c == nil {
+		return
+	}
+	o.conn = nil
+	o.stopTimer(&o.respTimer)
+	c.closed = true
+	c.w.close()
+}
+
+func (o *PluginHttpClient) getRequestsInfo() []HttpRequestInfo {
+	info := make([]HttpRequestInfo, 0, len(o.steps))
+	for k := range o.steps {
+		s := &o.steps[k]
+		r := HttpRequestInfo{Method: s.method, Url: s.init.Url, Sent: s.sent, Responses: s.responses, Errors: s.errors,
+			LastError: s.lastError, Status: make(map[string]uint64), LatencyMin: s.latency.min,
+			LatencyAvg: s.latency.avg(), LatencyMax: s.latency.max, Histogram: s.latency.histogram()}
+		for code, cnt := range s.status {
+			r.Status[strconv.Itoa(code)] = cnt
+		}
+		info = append(info, r)
+	}
+	return info
+}
+
+type HttpNsStats struct {
+	errInitJson uint64
+}
+
+func NewHttpNsStatsDb(o *HttpNsStats) *core.CCounterDb {
+	db := core.NewCCounterDb(HTTP_PLUG)
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.errInitJson,
+		Name:     "errInitJson",
+		Help:     "invalid init json of the namespace defaults",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	return db
+}
+
+// PluginHttpNs the defaults of the clients of the namespace
+type PluginHttpNs struct {
+	core.PluginBase
+	init  HttpInit
+	stats HttpNsStats
+	cdb   *core.CCounterDb
+	cdbv  *core.CCounterDbVec
+}
+
+func NewHttpNs(ctx *core.PluginCtx, initJson []byte) *core.PluginBase {
+	o := new(PluginHttpNs)
+	o.InitPluginBase(ctx, o)
+	o.RegisterEvents(ctx, []string{}, o)
+	o.cdb = NewHttpNsStatsDb(&o.stats)
+	o.cdbv = core.NewCCounterDbVec(HTTP_PLUG)
+	o.cdbv.Add(o.cdb)
+	o.init = HttpInit{KeepAlive: true, Pipeline: 1, Loops: 1, Timeout: HTTP_DEF_TIMEOUT}
+	if len(initJson) > 0 {
+		if err := o.Tctx.UnmarshalValidate(initJson, &o.init); err != nil {
+			o.stats.errInitJson++
+			o.init = HttpInit{KeepAlive: true, Pipeline: 1, Loops: 1, Timeout: HTTP_DEF_TIMEOUT}
+		}
+	}
+	return &o.PluginBase
+}
+
+func (o *PluginHttpNs) OnRemove(ctx *core.PluginCtx) {
+}
+
+func (o *PluginHttpNs) OnEvent(msg string, a, b interface{}) {
+}
+
+func (o *PluginHttpNs) GetCounterDbVec() *core.CCounterDbVec {
+	return o.cdbv
+}
+
+type PluginHttpCReg struct{}
+type PluginHttpNsReg struct{}
+
+func (o PluginHttpCReg) NewPlugin(ctx *core.PluginCtx, initJson []byte) *core.PluginBase {
+	return NewHttpClient(ctx, initJson)
+}
+
+func (o PluginHttpNsReg) NewPlugin(ctx *core.PluginCtx, initJson []byte) *core.PluginBase {
+	return NewHttpNs(ctx, initJson)
+}
+
+/*******************************************/
+/*  RPC commands */
+type (
+	ApiHttpClientCntHandler      struct{}
+	ApiHttpClientRequestsHandler struct{}
+	ApiHttpNsCntHandler          struct{}
+)
+
+func getHttpClient(ctx interface{}, params *fastjson.RawMessage) (*PluginHttpClient, *jsonrpc.Error) {
+	tctx := ctx.(*core.CThreadCtx)
+	plug, err := tctx.GetClientPlugin(params, HTTP_PLUG)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+	return plug.Ext.(*PluginHttpClient), nil
+}
+
+func (h ApiHttpClientCntHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	var p core.ApiCntParams
+	tctx := ctx.(*core.CThreadCtx)
+	c, err := getHttpClient(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	return c.cdbv.GeneralCounters(err, tctx, params, &p)
+}
+
+func (h ApiHttpClientRequestsHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	c, err := getHttpClient(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	return c.getRequestsInfo(), nil
+}
+
+func (h ApiHttpNsCntHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	var p core.ApiCntParams
+	tctx := ctx.(*core.CThreadCtx)
+	plug, err := tctx.GetNsPlugin(params, HTTP_PLUG)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+	return plug.Ext.(*PluginHttpNs).cdbv.GeneralCounters(nil, tctx, params, &p)
+}
+
+func init() {
+
+	/* register of plugins callbacks for ns,c level  */
+	core.PluginRegister(HTTP_PLUG,
+		core.PluginRegisterData{Client: PluginHttpCReg{},
+			Ns:     PluginHttpNsReg{},
+			Thread: nil}) /* no need for thread context for now */
+
+	core.RegisterCB("http_client_cnt", ApiHttpClientCntHandler{}, false)           // get counters/meta
+	core.RegisterCB("http_client_requests", ApiHttpClientRequestsHandler{}, false) // latency histogram per request
+	core.RegisterCB("http_ns_cnt", ApiHttpNsCntHandler{}, false)                   // get counters/meta
+
+	/* the rx side is the transport plugin*/
+}
+
+func Register(ctx *core.CThreadCtx) {
+}
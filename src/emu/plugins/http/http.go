@@ -0,0 +1,404 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package http
+
+/*
+HTTP/1.1 (RFC 7230) helpers shared by the client and the server plugins. The messages are parsed incrementally from the
+data events of the transport socket, the bodies are counted and not kept.
+*/
+
+import (
+	"bytes"
+	"emu/plugins/transport"
+	"fmt"
+	nethttp "net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	HTTP_PORT            = 80
+	HTTP_MAX_HEADER_SIZE = 16384
+	HTTP_MAX_BODY_SIZE   = 16 * 1024 * 1024
+	HTTP_CHUNK_SIZE      = 16384
+	HTTP_USER_AGENT      = "trex-emu"
+)
+
+// the parser states
+const (
+	httpStHeader = iota
+	httpStBody
+	httpStChunkSize
+	httpStChunkData
+	httpStChunkEnd
+	httpStTrailer
+	httpStUntilClose
+)
+
+var httpCrlf2 = []byte("\r\n\r\n")
+
+// httpLatencyBuckets are the upper bounds of the latency histograms
+var httpLatencyBuckets = []struct {
+	name  string
+	bound time.Duration
+}{{"1ms", time.Millisecond}, {"10ms", 10 * time.Millisecond}, {"100ms", 100 * time.Millisecond},
+	{"1s", time.Second}, {"inf", 0}}
+
+// httpHeader is the start line and the header fields of a request or a response
+type httpHeader struct {
+	method        string
+	uri           string
+	version       string
+	status        int
+	reason        string
+	fields        map[string]string // by the lower case name
+	contentLength int64             // -1 in case there is no Content-Length
+	chunked       bool
+	close         bool
+}
+
+// httpParser parses the messages of a connection, the header of a message can be split between data events
+type httpParser struct {
+	response  bool
+	state     int
+	buf       []byte
+	hdr       httpHeader
+	remaining int64
+	bodyLen   int64
+	onHeader  func(h *httpHeader) bool // returns true in case the message has no body, e.g. a response of HEAD
+	onMessage func(h *httpHeader, bodyLen int64)
+}
+
+func (o *httpParser) parseHeader(b []byte) error {
+	lines := strings.Split(string(b), "\n")
+	for i := range lines {
+		lines[i] = strings.TrimSuffix(lines[i], "\r")
+	}
+	h := &o.hdr
+	*h = httpHeader{fields: make(map[string]string), contentLength: -1}
+	f := strings.SplitN(lines[0], " ", 3)
+	if o.response {
+		if len(f) < 2 || !strings.HasPrefix(f[0], "HTTP/1.") {
+			return fmt.Errorf("invalid status line %q", lines[0])
+		}
+		status, err := strconv.Atoi(f[1])
+		if err != nil || status < 100 || status > 999 {
+			return fmt.Errorf("invalid status line %q", lines[0])
+		}
+		h.version, h.status = f[0], status
+		if len(f) == 3 {
+			h.reason = f[2]
+		}
+	} else {
+		if len(f) != 3 || !strings.HasPrefix(f[2], "HTTP/1.") || f[0] == "" || f[1] == "" {
+			return fmt.Errorf("invalid request line %q", lines[0])
+		}
+		h.method, h.uri, h.version = f[0], f[1], f[2]
+	}
+	keepAlive := false
+	for _, l := range lines[1:] {
+		i := strings.IndexByte(l, ':')
+		if i <= 0 {
+			return fmt.Errorf("invalid header field %q", l)
+		}
+		name, value := strings.ToLower(strings.TrimSpace(l[:i])), strings.TrimSpace(l[i+1:])
+		h.fields[name] = value
+		switch name {
+		case "content-length":
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || n < 0 {
+				return fmt.Errorf("invalid content length %q", value)
+			}
+			h.contentLength = n
+		case "transfer-encoding":
+			h.chunked = strings.Contains(strings.ToLower(value), "chunked")
+		case "connection":
+			for _, t := range strings.Split(strings.ToLower(value), ",") {
+				switch strings.TrimSpace(t) {
+				case "close":
+					h.close = true
+				case "keep-alive":
+					keepAlive = true
+				}
+			}
+		}
+	}
+	// HTTP/1.0 closes the connection unless it is kept alive
+	if h.version == "HTTP/1.0" && !keepAlive {
+		h.close = true
+	}
+	return nil
+}
+
+func (o *httpParser) startBody() {
+	h := &o.hdr
+	noBody := o.onHeader != nil && o.onHeader(h)
+	switch {
+	case noBody:
+		o.complete()
+	case h.chunked:
+		o.state = httpStChunkSize
+	case h.contentLength > 0:
+		o.remaining = h.contentLength
+		o.state = httpStBody
+	case h.contentLength == 0 || !o.response:
+		o.complete()
+	default:
+		// a response without a length ends with the connection
+		o.state = httpStUntilClose
+	}
+}
+
+func (o *httpParser) complete() {
+	o.state = httpStHeader
+	bodyLen := o.bodyLen
+	o.bodyLen = 0
+	o.onMessage(&o.hdr, bodyLen)
+}
+
+// consume counts the body bytes of the data
+func (o *httpParser) consume(d []byte) []byte {
+	n := int64(len(d))
+	if n > o.remaining {
+		n = o.remaining
+	}
+	o.bodyLen += n
+	o.remaining -= n
+	return d[n:]
+}
+
+// feed parses the data of the connection, onMessage is called for each message
+func (o *httpParser) feed(d []byte) error {
+	for len(d) > 0 {
+		switch o.state {
+		case httpStHeader:
+			n := len(o.buf)
+			o.buf = append(o.buf, d...)
+			// the end of the header could be split between the events
+			start := n - 3
+			if start < 0 {
+				start = 0
+			}
+			i := bytes.Index(o.buf[start:], httpCrlf2)
+			if i < 0 || start+i > HTTP_MAX_HEADER_SIZE {
+				if len(o.buf) > HTTP_MAX_HEADER_SIZE {
+					return fmt.Errorf("the header is bigger than %d", HTTP_MAX_HEADER_SIZE)
+				}
+				return nil
+			}
+			end := start + i + len(httpCrlf2)
+			d = d[end-n:]
+			err := o.parseHeader(o.buf[:start+i])
+			o.buf = o.buf[:0]
+			if err != nil {
+				return err
+			}
+			o.startBody()
+		case httpStBody:
+			if d = o.consume(d); o.remaining == 0 {
+				o.complete()
+			}
+		case httpStChunkData:
+			if d = o.consume(d); o.remaining == 0 {
+				o.state = httpStChunkEnd
+			}
+		case httpStChunkSize, httpStChunkEnd, httpStTrailer:
+			i := bytes.IndexByte(d, '\n')
+			if i < 0 {
+				o.buf = append(o.buf, d...)
+				if len(o.buf) > HTTP_MAX_HEADER_SIZE {
+					return fmt.Errorf("the chunk line is bigger than %d", HTTP_MAX_HEADER_SIZE)
+				}
+				return nil
+			}
+			o.buf = append(o.buf, d[:i+1]...)
+			d = d[i+1:]
+			line := strings.TrimRight(string(o.buf), "\r\n")
+			o.buf = o.buf[:0]
+			switch o.state {
+			case httpStChunkSize:
+				if i := strings.IndexByte(line, ';'); i >= 0 {
+					line = line[:i]
+				}
+				size, err := strconv.ParseInt(strings.TrimSpace(line), 16, 64)
+				if err != nil || size < 0 {
+					return fmt.Errorf("invalid chunk size %q", line)
+				}
+				if size == 0 {
+					o.state = httpStTrailer
+				} else {
+					o.remaining = size
+					o.state = httpStChunkData
+				}
+			case httpStChunkEnd:
+				if line != "" {
+					return fmt.Errorf("invalid end of chunk %q", line)
+				}
+				o.state = httpStChunkSize
+			case httpStTrailer:
+				// the trailer fields are ignored
+				if line == "" {
+					o.complete()
+				}
+			}
+		case httpStUntilClose:
+			o.bodyLen += int64(len(d))
+			d = nil
+		}
+	}
+	return nil
+}
+
+// eof is called when the peer closes the connection, it returns false in case a message is cut
+func (o *httpParser) eof() bool {
+	switch o.state {
+	case httpStUntilClose:
+		o.complete()
+		return true
+	case httpStHeader:
+		return len(o.buf) == 0
+	}
+	return false
+}
+
+// httpWriter queues the buffers while the socket drains a partial write, see SocketApi.Write
+type httpWriter struct {
+	socket   transport.SocketApi
+	queue    [][]byte
+	draining bool
+	closing  bool
+}
+
+func (o *httpWriter) write(b []byte) transport.SocketErr {
+	if o.draining {
+		o.queue = append(o.queue, b)
+		return transport.SeOK
+	}
+	res, queued := o.socket.Write(b)
+	if res != transport.SeOK {
+		return res
+	}
+	o.draining = !queued
+	return transport.SeOK
+}
+
+// onTxMore writes the queue once the socket was drained
+func (o *httpWriter) onTxMore() transport.SocketErr {
+	if !o.draining {
+		return transport.SeOK
+	}
+	o.draining = false
+	for len(o.queue) > 0 {
+		b := o.queue[0]
+		o.queue = o.queue[1:]
+		res, queued := o.socket.Write(b)
+		if res != transport.SeOK {
+			return res
+		}
+		if !queued {
+			o.draining = true
+			return transport.SeOK
+		}
+	}
+	if o.closing {
+		o.socket.Close()
+	}
+	return transport.SeOK
+}
+
+// close closes the socket after the queue is written
+func (o *httpWriter) close() {
+	if o.draining {
+		o.closing = true
+		return
+	}
+	o.socket.Close()
+}
+
+// httpFields formats the header fields, sorted by the name
+func httpFields(b *strings.Builder, fields map[string]string) {
+	names := make([]string, 0, len(fields))
+	for n := range fields {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		fmt.Fprintf(b, "%s: %s\r\n", n, fields[n])
+	}
+}
+
+// hasField returns true in case the fields have the name, case insensitive
+func hasField(fields map[string]string, name string) bool {
+	for n := range fields {
+		if strings.EqualFold(n, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// httpBody returns the body, or a generated body of size bytes
+func httpBody(body string, size uint32) []byte {
+	if body != "" {
+		return []byte(body)
+	}
+	b := make([]byte, size)
+	for i := range b {
+		b[i] = 'a' + byte(i%26)
+	}
+	return b
+}
+
+func statusText(status int) string {
+	if s := nethttp.StatusText(status); s != "" {
+		return s
+	}
+	return "Unknown"
+}
+
+// httpLatency is the latency histogram of a request
+type httpLatency struct {
+	min     uint64 // usec
+	max     uint64
+	sum     uint64
+	cnt     uint64
+	buckets [5]uint64
+}
+
+func (o *httpLatency) update(d time.Duration) {
+	usec := uint64(d / time.Microsecond)
+	if o.cnt == 0 || usec < o.min {
+		o.min = usec
+	}
+	if usec > o.max {
+		o.max = usec
+	}
+	o.sum += usec
+	o.cnt++
+	for i, b := range httpLatencyBuckets {
+		if b.bound == 0 || d < b.bound {
+			o.buckets[i]++
+			break
+		}
+	}
+}
+
+func (o *httpLatency) avg() uint64 {
+	if o.cnt == 0 {
+		return 0
+	}
+	return o.sum / o.cnt
+}
+
+func (o *httpLatency) histogram() map[string]uint64 {
+	h := make(map[string]uint64, len(httpLatencyBuckets))
+	for i, b := range httpLatencyBuckets {
+		h[b.name] = o.buckets[i]
+	}
+	return h
+}
@@ -0,0 +1,315 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package http
+
+import (
+	"emu/core"
+	"emu/plugins/dns"
+	"emu/plugins/transport"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// VethHttpSim a wire between the clients and the servers of the namespace
+type VethHttpSim struct {
+}
+
+func (o *VethHttpSim) ProcessTxToRx(m *core.Mbuf) *core.Mbuf {
+	return m
+}
+
+func newHttpNs() (*core.CThreadCtx, *core.CNSCtx) {
+	var simrx core.VethIFSim = &VethHttpSim{}
+	tctx := core.NewThreadCtx(0, 4510, true, &simrx)
+	transport.Register(tctx)
+	var key core.CTunnelKey
+	key.Set(&core.CTunnelData{Vport: 1})
+	ns := core.NewNSCtx(tctx, &key)
+	tctx.AddNs(&key, ns)
+	return tctx, ns
+}
+
+// addHttpClient adds the client 16.0.0.id, the client 16.0.0.dg is its default gateway
+func addHttpClient(ns *core.CNSCtx, id, dg uint8) *core.CClient {
+	c := core.NewClient(ns, core.MACKey{0, 0, 1, 0, 0, id}, core.Ipv4Key{16, 0, 0, id}, core.Ipv6Key{}, core.Ipv4Key{})
+	c.ForceDGW = true
+	c.Ipv4ForcedgMac = core.MACKey{0, 0, 1, 0, 0, dg}
+	ns.AddClient(c)
+	return c
+}
+
+const httpTestSrvJson = `{"responses": [
+	{"method": "GET", "path": "/index.html", "headers": {"Content-Type": "text/html"}, "body_size": 1000},
+	{"method": "POST", "path": "/upload", "status": 201},
+	{"path": "/stream", "body_size": 40000, "chunked": true},
+	{"path": "/api/*", "body": "{\"ok\": true}"},
+	{"path": "/bye", "status": 503, "close": true}]}`
+
+// createHttpEnv creates the server 16.0.0.2 and the client 16.0.0.1, each is the default gateway of the other
+func createHttpEnv(srvJson, clientJson string) (*core.CThreadCtx, *PluginHttpSrvClient, *PluginHttpClient) {
+	tctx, ns := newHttpNs()
+	c := addHttpClient(ns, 2, 1)
+	c.PluginCtx.CreatePlugins([]string{HTTP_SRV_PLUG}, [][]byte{[]byte(srvJson)})
+	srv := c.PluginCtx.Get(HTTP_SRV_PLUG).Ext.(*PluginHttpSrvClient)
+
+	c = addHttpClient(ns, 1, 2)
+	c.PluginCtx.CreatePlugins([]string{HTTP_PLUG}, [][]byte{[]byte(clientJson)})
+	return tctx, srv, c.PluginCtx.Get(HTTP_PLUG).Ext.(*PluginHttpClient)
+}
+
+func TestHttpParser(t *testing.T) {
+	d := "HTTP/1.1 200 OK\r\nContent-Length: 3\r\n\r\nabc" +
+		"HTTP/1.1 200 Chunked\r\nTransfer-Encoding: chunked\r\n\r\n2;x=1\r\nab\r\n3\r\ncde\r\n0\r\nX-Trailer: 1\r\n\r\n" +
+		"HTTP/1.0 200 Close\r\n\r\nabcd"
+	// the messages are split by the data events
+	for step := 1; step <= 16; step++ {
+		var msgs []string
+		p := httpParser{response: true, onMessage: func(h *httpHeader, bodyLen int64) {
+			msgs = append(msgs, fmt.Sprintf("%s %d", h.reason, bodyLen))
+		}}
+		for i := 0; i < len(d); i += step {
+			e := i + step
+			if e > len(d) {
+				e = len(d)
+			}
+			if err := p.feed([]byte(d[i:e])); err != nil {
+				t.Fatalf(" unexpected error %v", err)
+			}
+		}
+		if !p.eof() || len(msgs) != 3 || msgs[0] != "OK 3" || msgs[1] != "Chunked 5" || msgs[2] != "Close 4" || !p.hdr.close {
+			t.Fatalf(" unexpected messages %v", msgs)
+		}
+	}
+	for _, bad := range []string{"HTTP/1.1 2x OK\r\n\r\n", "HTTP/1.1 200 OK\r\nbad\r\n\r\n",
+		"HTTP/1.1 200 OK\r\nContent-Length: -1\r\n\r\n", "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\nzz\r\n",
+		"HTTP/1.1 200 OK\r\n" + strings.Repeat("X-A: b\r\n", HTTP_MAX_HEADER_SIZE/8)} {
+		p := httpParser{response: true, onMessage: func(h *httpHeader, bodyLen int64) {}}
+		if p.feed([]byte(bad)) == nil {
+			t.Fatalf(" %q should fail", bad)
+		}
+	}
+}
+
+func TestHttpClientServer(t *testing.T) {
+	tctx, srv, o := createHttpEnv(httpTestSrvJson, `{"requests": [
+		{"url": "http://16.0.0.2/index.html"},
+		{"method": "POST", "url": "http://16.0.0.2/upload", "body_size": 20000},
+		{"url": "http://16.0.0.2/stream"},
+		{"method": "HEAD", "url": "http://16.0.0.2/index.html"},
+		{"url": "http://16.0.0.2/api/v1/items?id=7", "headers": {"Accept": "application/json"}},
+		{"url": "http://16.0.0.2/missing"}], "headers": {"Accept": "*/*"}, "loops": 2}`)
+	defer tctx.Delete()
+	if !srv.enable || !o.enable {
+		t.Fatalf(" unexpected init error %s %s", srv.initErr, o.initErr)
+	}
+
+	// the simulation ticks are 100msec, the transfers take a few round trips
+	tctx.MainLoopSim(10 * time.Second)
+	st := &o.stats
+	if !o.done || st.loops != 2 || st.reqTx != 12 || st.rspRx != 12 || st.rsp2xx != 10 || st.rsp4xx != 2 || st.connOpen != 1 {
+		t.Fatalf(" unexpected client counters %+v", *st)
+	}
+	// the bodies of GET, the chunked stream, the api and the 404
+	if st.bodyRx != 2*(1000+40000+12) || st.latencyMin == 0 || st.latencyMin > st.latencyMax ||
+		st.latencyUnder1ms+st.latencyUnder10ms+st.latencyUnder100ms+st.latencyUnder1s+st.latencyOver1s != 12 {
+		t.Fatalf(" unexpected client counters %+v", *st)
+	}
+	if st.errResolve+st.errDial+st.errConnect+st.errTimeout+st.errConnClosed+st.errParse+st.errRxUnexpected+st.errTx != 0 {
+		t.Fatalf(" unexpected client errors %+v", *st)
+	}
+	info := o.getRequestsInfo()
+	if len(info) != 6 || info[1].Method != "POST" || info[1].Sent != 2 || info[1].Status["201"] != 2 ||
+		info[5].Status["404"] != 2 || info[0].LatencyMin == 0 || info[0].Histogram["inf"] != 0 {
+		t.Fatalf(" unexpected requests info %+v", info)
+	}
+	var n uint64
+	for _, v := range info[2].Histogram {
+		n += v
+	}
+	if n != 2 {
+		t.Fatalf(" unexpected histogram %+v", info[2])
+	}
+
+	sst := &srv.stats
+	if sst.reqRx != 12 || sst.rspTx != 12 || sst.notFound != 2 || sst.bodyRx != 40000 || sst.connAccept != 1 ||
+		sst.errParse != 0 || sst.errTx != 0 || sst.bytesTx != st.bytesRx || sst.bytesRx != st.bytesTx {
+		t.Fatalf(" unexpected server counters %+v %+v", *sst, *st)
+	}
+	reqs := srv.getRequestsInfo()
+	if len(reqs) != 6 || reqs[0] != (HttpSrvRequestInfo{Method: "GET", Path: "/api/v1/items", Requests: 2}) ||
+		reqs[1] != (HttpSrvRequestInfo{Method: "GET", Path: "/index.html", Requests: 2}) ||
+		reqs[2] != (HttpSrvRequestInfo{Method: "HEAD", Path: "/index.html", Requests: 2}) {
+		t.Fatalf(" unexpected server requests %+v", reqs)
+	}
+	// the connection is closed once the sequence is done
+	tctx.MainLoopSim(5 * time.Second)
+	if len(srv.conns) != 0 || sst.connClose != 1 {
+		t.Fatalf(" unexpected open connections %d", len(srv.conns))
+	}
+}
+
+func TestHttpPipeline(t *testing.T) {
+	var reqs []string
+	for i := 0; i < 8; i++ {
+		reqs = append(reqs, `{"url": "http://16.0.0.2/index.html"}`)
+	}
+	tctx, srv, o := createHttpEnv(`{"max_requests": 5, `+httpTestSrvJson[1:],
+		`{"pipeline": 4, "requests": [`+strings.Join(reqs, ",")+`]}`)
+	defer tctx.Delete()
+	tctx.MainLoopSim(5 * time.Second)
+	// the server closes the connection after 5 requests, the pipelined requests are sent again
+	st := &o.stats
+	if !o.done || st.rspRx != 8 || st.rsp2xx != 8 || st.connOpen != 2 || st.retries == 0 || st.reqTx != 8+st.retries ||
+		st.errConnClosed != 0 || st.errParse != 0 {
+		t.Fatalf(" unexpected client counters %+v", *st)
+	}
+	if srv.stats.rspTx != 8 || srv.stats.connAccept != 2 {
+		t.Fatalf(" unexpected server counters %+v", srv.stats)
+	}
+}
+
+func TestHttpNoKeepAlive(t *testing.T) {
+	tctx, srv, o := createHttpEnv(httpTestSrvJson, `{"keep_alive": false, "loops": 3, "interval": 1000, "requests": [
+		{"url": "http://16.0.0.2/index.html"}, {"url": "http://16.0.0.2/bye"}]}`)
+	defer tctx.Delete()
+	tctx.MainLoopSim(time.Second)
+	if o.done || o.stats.loops != 1 {
+		t.Fatalf(" the interval should delay the next loop %+v", o.stats)
+	}
+	tctx.MainLoopSim(5 * time.Second)
+	st := &o.stats
+	if !o.done || st.loops != 3 || st.rspRx != 6 || st.rsp5xx != 3 || st.connOpen != 6 || st.errConnClosed != 0 {
+		t.Fatalf(" unexpected client counters %+v", *st)
+	}
+	if srv.stats.connAccept != 6 || srv.stats.rspTx != 6 {
+		t.Fatalf(" unexpected server counters %+v", srv.stats)
+	}
+}
+
+// httpTestSilent accepts the connections and does not answer
+type httpTestSilent struct{}
+
+func (o *httpTestSilent) OnAccept(socket transport.SocketApi) transport.ISocketCb {
+	return o
+}
+func (o *httpTestSilent) OnRxEvent(event transport.SocketEventType) {}
+func (o *httpTestSilent) OnRxData(d []byte)                         {}
+func (o *httpTestSilent) OnTxEvent(event transport.SocketEventType) {}
+
+func TestHttpErrors(t *testing.T) {
+	tctx, srv, o := createHttpEnv(httpTestSrvJson, `{"timeout": 500, "requests": [
+		{"url": "http://16.0.0.2:8081/"}, {"url": "http://16.0.0.2:8080/"},
+		{"url": "http://www.example.com/"}, {"url": "http://16.0.0.2/index.html"}]}`)
+	defer tctx.Delete()
+	ctx := transport.GetTransportCtx(srv.Client)
+	ctx.Listen("tcp", ":8081", &httpTestSilent{})
+
+	tctx.MainLoopSim(5 * time.Second)
+	// not answered, the port is not open (the transport drops the syn), no dns plugin, answered
+	st := &o.stats
+	if !o.done || st.errConnect != 0 || st.errTimeout != 2 || st.errResolve != 1 || st.rspRx != 1 || st.connOpen != 3 {
+		t.Fatalf(" unexpected client counters %+v", *st)
+	}
+	info := o.getRequestsInfo()
+	if info[0].LastError != HTTP_ERR_TIMEOUT || info[1].LastError != HTTP_ERR_TIMEOUT || info[2].Errors != 1 || info[3].Responses != 1 {
+		t.Fatalf(" unexpected requests info %+v", info)
+	}
+
+	ns := srv.Ns
+	for k, j := range []string{
+		`{"requests": []}`,
+		`{"requests": [{"url": "https://16.0.0.2/"}]}`,
+		`{"requests": [{"url": "16.0.0.2/index.html"}]}`,
+		`{"pipeline": 100, "requests": [{"url": "http://16.0.0.2/"}]}`,
+	} {
+		c := addHttpClient(ns, uint8(10+k), 2)
+		c.PluginCtx.CreatePlugins([]string{HTTP_PLUG}, [][]byte{[]byte(j)})
+		bad := c.PluginCtx.Get(HTTP_PLUG).Ext.(*PluginHttpClient)
+		if bad.enable || bad.stats.errInitJson != 1 {
+			t.Fatalf(" %s should be invalid", j)
+		}
+	}
+	for k, j := range []string{
+		`{"responses": [{"path": "index.html"}]}`,
+		`{"responses": [{"path": "/", "status": 100}]}`,
+		`{"port": 80}`,
+	} {
+		c := addHttpClient(ns, uint8(20+k), 2)
+		if k == 2 {
+			// the port is used by another server of the client
+			c.PluginCtx.CreatePlugins([]string{transport.TRANS_PLUG}, [][]byte{nil})
+			transport.GetTransportCtx(c).Listen("tcp", ":80", &httpTestSilent{})
+		}
+		c.PluginCtx.CreatePlugins([]string{HTTP_SRV_PLUG}, [][]byte{[]byte(j)})
+		bad := c.PluginCtx.Get(HTTP_SRV_PLUG).Ext.(*PluginHttpSrvClient)
+		if bad.enable || bad.stats.errInitJson+bad.stats.errListen != 1 {
+			t.Fatalf(" %s should be invalid", j)
+		}
+	}
+}
+
+func TestHttpResolve(t *testing.T) {
+	tctx, ns := newHttpNs()
+	defer tctx.Delete()
+	c := addHttpClient(ns, 2, 1)
+	c.PluginCtx.CreatePlugins([]string{HTTP_SRV_PLUG, dns.DNS_SRV_PLUG}, [][]byte{[]byte(httpTestSrvJson),
+		[]byte(`{"zone": [{"name": "www.example.com", "type": "A", "data": "16.0.0.2"}]}`)})
+	srv := c.PluginCtx.Get(HTTP_SRV_PLUG).Ext.(*PluginHttpSrvClient)
+
+	// the host is resolved by the dns plugin of the client
+	c = addHttpClient(ns, 1, 2)
+	c.PluginCtx.CreatePlugins([]string{dns.DNS_PLUG, HTTP_PLUG}, [][]byte{[]byte(`{"servers": ["16.0.0.2"]}`),
+		[]byte(`{"requests": [{"url": "http://www.example.com/index.html"}, {"url": "http://www.example.com/api/x"}]}`)})
+	o := c.PluginCtx.Get(HTTP_PLUG).Ext.(*PluginHttpClient)
+	tctx.MainLoopSim(5 * time.Second)
+	if !o.done || o.stats.rsp2xx != 2 || o.stats.connOpen != 1 || srv.stats.reqRx != 2 || o.stats.errResolve != 0 {
+		t.Fatalf(" unexpected counters %+v %+v", o.stats, srv.stats)
+	}
+}
+
+// the headers of a client are added to its own copy of the namespace headers, its requests replace the namespace ones
+func TestHttpNsHeaders(t *testing.T) {
+	tctx, ns := newHttpNs()
+	defer tctx.Delete()
+	ns.PluginCtx.CreatePlugins([]string{HTTP_PLUG}, [][]byte{[]byte(`{"requests": [{"url": "http://16.0.0.2/", "body_size": 100}], "headers": {"Accept": "*/*"}}`)})
+	var plugs []*PluginHttpClient
+	for i, j := range []string{`{"headers": {"X-Client": "1"}, "requests": [{"url": "http://16.0.0.3/"}]}`, `{}`} {
+		c := addHttpClient(ns, uint8(i+1), 3)
+		c.PluginCtx.CreatePlugins([]string{HTTP_PLUG}, [][]byte{[]byte(j)})
+		plugs = append(plugs, c.PluginCtx.Get(HTTP_PLUG).Ext.(*PluginHttpClient))
+	}
+	if len(plugs[0].init.Headers) != 2 || len(plugs[1].init.Headers) != 1 || plugs[1].init.Headers["Accept"] != "*/*" ||
+		len(plugs[0].httpNsPlug.init.Headers) != 1 {
+		t.Fatalf(" unexpected headers %v %v", plugs[0].init.Headers, plugs[1].init.Headers)
+	}
+	if r := plugs[0].init.Requests[0]; r.Url != "http://16.0.0.3/" || r.BodySize != 0 {
+		t.Fatalf(" the request of the client should replace the namespace one %+v", r)
+	}
+	if r := plugs[1].init.Requests[0]; r.Url != "http://16.0.0.2/" || r.BodySize != 100 {
+		t.Fatalf(" the namespace request was changed %+v", r)
+	}
+}
+
+// the responses of a client replace the namespace ones
+func TestHttpSrvNsResponses(t *testing.T) {
+	tctx, ns := newHttpNs()
+	defer tctx.Delete()
+	ns.PluginCtx.CreatePlugins([]string{HTTP_SRV_PLUG}, [][]byte{[]byte(`{"responses": [{"path": "/a", "status": 201, "body": "a"}]}`)})
+	var plugs []*PluginHttpSrvClient
+	for i, j := range []string{`{"responses": [{"path": "/b"}]}`, `{}`} {
+		c := addHttpClient(ns, uint8(i+1), 3)
+		c.PluginCtx.CreatePlugins([]string{HTTP_SRV_PLUG}, [][]byte{[]byte(j)})
+		plugs = append(plugs, c.PluginCtx.Get(HTTP_SRV_PLUG).Ext.(*PluginHttpSrvClient))
+	}
+	if r := plugs[0].init.Responses; len(r) != 1 || r[0].Path != "/b" || r[0].Status != 0 || r[0].Body != "" {
+		t.Fatalf(" the responses of the client should replace the namespace ones %+v", r)
+	}
+	if r := plugs[1].init.Responses; len(r) != 1 || r[0].Path != "/a" || r[0].Status != 201 {
+		t.Fatalf(" the namespace responses were changed %+v", r)
+	}
+}
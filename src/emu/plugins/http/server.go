@@ -0,0 +1,730 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package http
+
+/*
+HTTP/1.1 server, client plugin
+
+The client listens on the TCP port of the transport layer and answers the requests by the first response that matches
+the method and the path (without the query) of the request. A path that ends with * is a prefix, a response without a
+method matches any method, a GET response matches HEAD too. A request without a response is answered by 404.
+
+The connections are kept alive unless the request (Connection: close, HTTP/1.0) or the response (close) asks otherwise,
+or max_requests were answered. The pipelined requests are answered in order.
+
+The namespace init json is the default of the clients, the client init json overrides it.
+
+client init json {
+	"port": 80,
+	"responses": [
+		{"method": "GET", "path": "/index.html", "headers": {"Content-Type": "text/html"}, "body_size": 1024},
+		{"path": "/api/*", "status": 200, "body": "{\"ok\": true}", "headers": {"Content-Type": "application/json"}},
+		{"method": "POST", "path": "/upload", "status": 201},
+		{"path": "/stream", "body_size": 100000, "chunked": true},
+		{"path": "/bye", "status": 503, "close": true}
+	],
+	"max_requests": 0
+}
+
+status is 200 by default, body is sent instead of a generated body of body_size bytes. The requests are counted per
+method and path, see http_srv_client_requests.
+
+*/
+
+import (
+	"emu/core"
+	"emu/plugins/transport"
+	"external/osamingo/jsonrpc"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/intel-go/fastjson"
+)
+
+const (
+	HTTP_SRV_PLUG        = "http_srv"
+	HTTP_SRV_MAX_QUERIES = 4096
+)
+
+type HttpSrvResponseInit struct {
+	Method   string            `json:"method"`
+	Path     string            `json:"path" validate:"required"`
+	Status   uint16            `json:"status" validate:"lte=999"`
+	Headers  map[string]string `json:"headers"`
+	Body     string            `json:"body"`
+	BodySize uint32            `json:"body_size" validate:"lte=16777216"`
+	Chunked  bool              `json:"chunked"`
+	Close    bool              `json:"close"`
+}
+
+type HttpSrvInit struct {
+	Port        uint16                `json:"port"`
+	Responses   []HttpSrvResponseInit `json:"responses" validate:"dive"`
+	MaxRequests uint32                `json:"max_requests"`
+}
+
+// HttpSrvRequestInfo the number of requests of a method and path
+type HttpSrvRequestInfo struct {
+	Method   string `json:"method"`
+	Path     string `json:"path"`
+	Requests uint64 `json:"requests"`
+}
+
+type HttpSrvStats struct {
+	reqRx           uint64
+	rspTx           uint64
+	rsp2xx          uint64
+	rsp3xx          uint64
+	rsp4xx          uint64
+	rsp5xx          uint64
+	notFound        uint64
+	bytesRx         uint64
+	bytesTx         uint64
+	bodyRx          uint64
+	connAccept      uint64
+	connClose       uint64
+	errInitJson     uint64
+	errListen       uint64
+	errParse        uint64
+	errCut          uint64
+	errTx           uint64
+	errTableFull    uint64
+	errNotListening uint64
+}
+
+func NewHttpSrvStatsDb(o *HttpSrvStats) *core.CCounterDb {
+	db := core.NewCCounterDb(HTTP_SRV_PLUG)
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.reqRx,
+		Name:     "reqRx",
+		Help:     "requests received",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.rspTx,
+		Name:     "rspTx",
+		Help:     "responses sent",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.rsp2xx,
+		Name:     "rsp2xx",
+		Help:     "successful responses",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.rsp3xx,
+		Name:     "rsp3xx",
+		Help:     "redirection responses",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.rsp4xx,
+		Name:     "rsp4xx",
+		Help:     "client error responses",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.rsp5xx,
+		Name:     "rsp5xx",
+		Help:     "server error responses",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.notFound,
+		Name:     "notFound",
+		Help:     "requests without a response, answered by 404",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.bytesRx,
+		Name:     "bytesRx",
+		Help:     "bytes of the requests",
+		Unit:     "bytes",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.bytesTx,
+		Name:     "bytesTx",
+		Help:     "bytes of the responses",
+		Unit:     "bytes",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.bodyRx,
+		Name:     "bodyRx",
+		Help:     "bytes of the bodies of the requests",
+		Unit:     "bytes",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.connAccept,
+		Name:     "connAccept",
+		Help:     "connections accepted",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.connClose,
+		Name:     "connClose",
+		Help:     "connections closed",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.errInitJson,
+		Name:     "errInitJson",
+		Help:     "invalid init json",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.errListen,
+		Name:     "errListen",
+		Help:     "can't listen on the port",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.errParse,
+		Name:     "errParse",
+		Help:     "malformed requests, answered by 400",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.errCut,
+		Name:     "errCut",
+		Help:     "requests cut by the close of the connection",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.errTx,
+		Name:     "errTx",
+		Help:     "can't write to the socket",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.errTableFull,
+		Name:     "errTableFull",
+		Help:     "request not counted per path, the table is full",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.errNotListening,
+		Name:     "errNotListening",
+		Help:     "connections refused, the plugin is not enabled",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	return db
+}
+
+// httpSrvResponse is a response with the prebuilt header fields and body
+type httpSrvResponse struct {
+	init   *HttpSrvResponseInit
+	method string
+	path   string
+	prefix bool
+	status int
+	fields map[string]string
+	body   []byte
+}
+
+// httpSrvKey identifies the requests of a method and path
+type httpSrvKey struct {
+	method string
+	path   string
+}
+
+// httpSrvConn is a connection of a client
+type httpSrvConn struct {
+	plug     *PluginHttpSrvClient
+	socket   transport.SocketApi
+	w        httpWriter
+	parser   httpParser
+	requests uint32
+	closing  bool
+	closed   bool
+}
+
+func (o *httpSrvConn) OnRxEvent(event transport.SocketEventType) {
+	if event&transport.SocketRemoteDisconnect > 0 && !o.closed {
+		if !o.parser.eof() {
+			o.plug.stats.errCut++
+		}
+		o.plug.close(o)
+	}
+	if event&transport.SocketClosed > 0 {
+		o.closed = true
+		if _, ok := o.plug.conns[o]; ok {
+			delete(o.plug.conns, o)
+			o.plug.stats.connClose++
+		}
+	}
+}
+
+func (o *httpSrvConn) OnTxEvent(event transport.SocketEventType) {
+	if event&transport.SocketTxMore > 0 && !o.closed {
+		if o.w.onTxMore() != transport.SeOK {
+			o.plug.stats.errTx++
+		}
+	}
+}
+
+func (o *httpSrvConn) OnRxData(d []byte) {
+	if o.closing || o.closed {
+		return
+	}
+	o.plug.stats.bytesRx += uint64(len(d))
+	if err := o.parser.feed(d); err != nil && !o.closing {
+		o.plug.stats.errParse++
+		o.plug.respond(o, o.plug.badRequest, "", true)
+	}
+}
+
+func (o *httpSrvConn) onRequest(h *httpHeader, bodyLen int64) {
+	if o.closing {
+		return
+	}
+	o.plug.onRequest(o, h, bodyLen)
+}
+
+// PluginHttpSrvClient the HTTP server of a client
+type PluginHttpSrvClient struct {
+	core.PluginBase
+	httpSrvNsPlug *PluginHttpSrvNs
+	init          HttpSrvInit
+	enable        bool
+	listen        bool
+	responses     []httpSrvResponse
+	notFound      *httpSrvResponse
+	badRequest    *httpSrvResponse
+	conns         map[*httpSrvConn]bool
+	requests      map[httpSrvKey]uint64
+	stats         HttpSrvStats
+	cdb           *core.CCounterDb
+	cdbv          *core.CCounterDbVec
+	initErr       string
+}
+
+/*NewHttpSrvClient create plugin */
+func NewHttpSrvClient(ctx *core.PluginCtx, initJson []byte) *core.PluginBase {
+
+	o := new(PluginHttpSrvClient)
+	o.InitPluginBase(ctx, o)             /* init base object*/
+	o.RegisterEvents(ctx, []string{}, o) /* register events, only if exits*/
+	nsplg := o.Ns.PluginCtx.GetOrCreate(HTTP_SRV_PLUG)
+	o.httpSrvNsPlug = nsplg.Ext.(*PluginHttpSrvNs)
+	o.OnCreate(initJson)
+
+	return &o.PluginBase
+}
+
+func (o *PluginHttpSrvClient) OnCreate(initJson []byte) {
+	o.cdb = NewHttpSrvStatsDb(&o.stats)
+	o.cdbv = core.NewCCounterDbVec(HTTP_SRV_PLUG)
+	o.cdbv.Add(o.cdb)
+	o.conns = make(map[*httpSrvConn]bool)
+	o.requests = make(map[httpSrvKey]uint64)
+
+	// the responses of the namespace are served unless the client json has its own list
+	o.init = o.httpSrvNsPlug.init
+	o.init.Responses = nil
+	var err error
+	if len(initJson) > 0 {
+		err = o.Tctx.UnmarshalValidate(initJson, &o.init)
+	}
+	if o.init.Responses == nil {
+		o.init.Responses = o.httpSrvNsPlug.init.Responses
+	}
+	if err == nil {
+		err = o.validate()
+	}
+	if err != nil {
+		o.stats.errInitJson++
+		o.initErr = err.Error()
+		return
+	}
+
+	o.Client.PluginCtx.GetOrCreate(transport.TRANS_PLUG)
+	ctx := transport.GetTransportCtx(o.Client)
+	if err = ctx.Listen("tcp", o.addr(), o); err != nil {
+		o.stats.errListen++
+		o.initErr = err.Error()
+		return
+	}
+	o.listen = true
+	o.enable = true
+}
+
+func (o *PluginHttpSrvClient) addr() string {
+	return ":" + strconv.Itoa(int(o.init.Port))
+}
+
+func newHttpSrvResponse(r *HttpSrvResponseInit) httpSrvResponse {
+	s := httpSrvResponse{init: r, method: strings.ToUpper(r.Method), path: r.Path, status: int(r.Status)}
+	if strings.HasSuffix(s.path, "*") {
+		s.path, s.prefix = strings.TrimSuffix(s.path, "*"), true
+	}
+	if s.status == 0 {
+		s.status = 200
+	}
+	s.body = httpBody(r.Body, r.BodySize)
+	s.fields = map[string]string{"Server": HTTP_USER_AGENT}
+	for n, v := range r.Headers {
+		if strings.EqualFold(n, "Server") {
+			delete(s.fields, "Server")
+		}
+		s.fields[n] = v
+	}
+	return s
+}
+
+func (o *PluginHttpSrvClient) validate() error {
+	i := &o.init
+	if i.Port == 0 {
+		i.Port = HTTP_PORT
+	}
+	o.responses = make([]httpSrvResponse, len(i.Responses))
+	for k := range i.Responses {
+		r := &i.Responses[k]
+		if !strings.HasPrefix(r.Path, "/") && r.Path != "*" {
+			return fmt.Errorf("invalid path %q, should start with /", r.Path)
+		}
+		if r.Status != 0 && r.Status < 200 {
+			return fmt.Errorf("invalid status %d of %q", r.Status, r.Path)
+		}
+		o.responses[k] = newHttpSrvResponse(r)
+	}
+	nf := newHttpSrvResponse(&HttpSrvResponseInit{Path: "*", Status: 404})
+	br := newHttpSrvResponse(&HttpSrvResponseInit{Path: "*", Status: 400, Close: true})
+	o.notFound, o.badRequest = &nf, &br
+	return nil
+}
+
+func (o *PluginHttpSrvClient) OnEvent(msg string, a, b interface{}) {
+}
+
+func (o *PluginHttpSrvClient) GetCounterDbVec() *core.CCounterDbVec {
+	return o.cdbv
+}
+
+func (o *PluginHttpSrvClient) OnRemove(ctx *core.PluginCtx) {
+	for c := range o.conns {
+		c.closed = true
+		c.socket.Shutdown()
+	}
+	o.conns = make(map[*httpSrvConn]bool)
+	if o.listen {
+		transport.GetTransportCtx(o.Client).UnListen("tcp", o.addr(), o)
+		o.listen = false
+	}
+	o.enable = false
+}
+
+// OnAccept is called by the transport layer for a new connection
+func (o *PluginHttpSrvClient) OnAccept(socket transport.SocketApi) transport.ISocketCb {
+	if !o.enable {
+		o.stats.errNotListening++
+		return nil
+	}
+	c := &httpSrvConn{plug: o, socket: socket}
+	c.w.socket = socket
+	c.parser = httpParser{onMessage: c.onRequest}
+	o.conns[c] = true
+	o.stats.connAccept++
+	return c
+}
+
+func (o *PluginHttpSrvClient) close(c *httpSrvConn) {
+	if c.closing {
+		return
+	}
+	c.closing = true
+	c.w.close()
+}
+
+// match returns the response of the request
+func (o *PluginHttpSrvClient) match(method, path string) *httpSrvResponse {
+	for k := range o.responses {
+		r := &o.responses[k]
+		// HEAD is answered by the headers of GET
+		if r.method != "" && r.method != method && !(method == "HEAD" && r.method == "GET") {
+			continue
+		}
+		if path == r.path || (r.prefix && strings.HasPrefix(path, r.path)) {
+			return r
+		}
+	}
+	return nil
+}
+
+func (o *PluginHttpSrvClient) countRequest(method, path string) {
+	k := httpSrvKey{method: method, path: path}
+	if _, ok := o.requests[k]; !ok && len(o.requests) >= HTTP_SRV_MAX_QUERIES {
+		o.stats.errTableFull++
+		return
+	}
+	o.requests[k]++
+}
+
+func (o *PluginHttpSrvClient) onRequest(c *httpSrvConn, h *httpHeader, bodyLen int64) {
+	o.stats.reqRx++
+	o.stats.bodyRx += uint64(bodyLen)
+	c.requests++
+	path := h.uri
+	if i := strings.IndexByte(path, '?'); i >= 0 {
+		path = path[:i]
+	}
+	o.countRequest(h.method, path)
+	r := o.match(h.method, path)
+	if r == nil {
+		o.stats.notFound++
+		r = o.notFound
+	}
+	closing := h.close || (o.init.MaxRequests != 0 && c.requests >= o.init.MaxRequests)
+	o.respond(c, r, h.method, closing)
+}
+
+// respond writes the response, the connection is closed after it in case of closing or close of the response
+func (o *PluginHttpSrvClient) respond(c *httpSrvConn, r *httpSrvResponse, method string, closing bool) {
+	closing = closing || r.init.Close
+	var b strings.Builder
+	fmt.Fprintf(&b, "HTTP/1.1 %d %s\r\n", r.status, statusText(r.status))
+	httpFields(&b, r.fields)
+	if r.init.Chunked {
+		b.WriteString("Transfer-Encoding: chunked\r\n")
+	} else {
+		fmt.Fprintf(&b, "Content-Length: %d\r\n", len(r.body))
+	}
+	if closing {
+		b.WriteString("Connection: close\r\n")
+	}
+	b.WriteString("\r\n")
+	head := []byte(b.String())
+
+	res := c.w.write(head)
+	n := len(head)
+	// HEAD has the header fields of GET without the body
+	if method != "HEAD" && res == transport.SeOK {
+		if r.init.Chunked {
+			for body := r.body; len(body) > 0 && res == transport.SeOK; {
+				l := len(body)
+				if l > HTTP_CHUNK_SIZE {
+					l = HTTP_CHUNK_SIZE
+				}
+				chunk := []byte(fmt.Sprintf("%x\r\n", l))
+				chunk = append(chunk, body[:l]...)
+				chunk = append(chunk, '\r', '\n')
+				body = body[l:]
+				res = c.w.write(chunk)
+				n += len(chunk)
+			}
+			if res == transport.SeOK {
+				res = c.w.write([]byte("0\r\n\r\n"))
+				n += 5
+			}
+		} else if len(r.body) > 0 {
+			res = c.w.write(r.body)
+			n += len(r.body)
+		}
+	}
+	if res != transport.SeOK {
+		o.stats.errTx++
+		o.close(c)
+		return
+	}
+	o.stats.rspTx++
+	o.stats.bytesTx += uint64(n)
+	switch r.status / 100 {
+	case 2:
+		o.stats.rsp2xx++
+	case 3:
+		o.stats.rsp3xx++
+	case 4:
+		o.stats.rsp4xx++
+	default:
+		o.stats.rsp5xx++
+	}
+	if closing {
+		o.close(c)
+	}
+}
+
+func (o *PluginHttpSrvClient) getRequestsInfo() []HttpSrvRequestInfo {
+	info := make([]HttpSrvRequestInfo, 0, len(o.requests))
+	for k, v := range o.requests {
+		info = append(info, HttpSrvRequestInfo{Method: k.method, Path: k.path, Requests: v})
+	}
+	sort.Slice(info, func(i, j int) bool {
+		if info[i].Path != info[j].Path {
+			return info[i].Path < info[j].Path
+		}
+		return info[i].Method < info[j].Method
+	})
+	return info
+}
+
+type HttpSrvNsStats struct {
+	errInitJson uint64
+}
+
+func NewHttpSrvNsStatsDb(o *HttpSrvNsStats) *core.CCounterDb {
+	db := core.NewCCounterDb(HTTP_SRV_PLUG)
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.errInitJson,
+		Name:     "errInitJson",
+		Help:     "invalid init json of the namespace defaults",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	return db
+}
+
+// PluginHttpSrvNs the defaults of the servers of the namespace
+type PluginHttpSrvNs struct {
+	core.PluginBase
+	init  HttpSrvInit
+	stats HttpSrvNsStats
+	cdb   *core.CCounterDb
+	cdbv  *core.CCounterDbVec
+}
+
+func NewHttpSrvNs(ctx *core.PluginCtx, initJson []byte) *core.PluginBase {
+	o := new(PluginHttpSrvNs)
+	o.InitPluginBase(ctx, o)
+	o.RegisterEvents(ctx, []string{}, o)
+	o.cdb = NewHttpSrvNsStatsDb(&o.stats)
+	o.cdbv = core.NewCCounterDbVec(HTTP_SRV_PLUG)
+	o.cdbv.Add(o.cdb)
+	if len(initJson) > 0 {
+		if err := o.Tctx.UnmarshalValidate(initJson, &o.init); err != nil {
+			o.stats.errInitJson++
+			o.init = HttpSrvInit{}
+		}
+	}
+	return &o.PluginBase
+}
+
+func (o *PluginHttpSrvNs) OnRemove(ctx *core.PluginCtx) {
+}
+
+func (o *PluginHttpSrvNs) OnEvent(msg string, a, b interface{}) {
+}
+
+func (o *PluginHttpSrvNs) GetCounterDbVec() *core.CCounterDbVec {
+	return o.cdbv
+}
+
+type PluginHttpSrvCReg struct{}
+type PluginHttpSrvNsReg struct{}
+
+func (o PluginHttpSrvCReg) NewPlugin(ctx *core.PluginCtx, initJson []byte) *core.PluginBase {
+	return NewHttpSrvClient(ctx, initJson)
+}
+
+func (o PluginHttpSrvNsReg) NewPlugin(ctx *core.PluginCtx, initJson []byte) *core.PluginBase {
+	return NewHttpSrvNs(ctx, initJson)
+}
+
+/*******************************************/
+/*  RPC commands */
+type (
+	ApiHttpSrvClientCntHandler      struct{}
+	ApiHttpSrvClientRequestsHandler struct{}
+	ApiHttpSrvNsCntHandler          struct{}
+)
+
+func getHttpSrvClient(ctx interface{}, params *fastjson.RawMessage) (*PluginHttpSrvClient, *jsonrpc.Error) {
+	tctx := ctx.(*core.CThreadCtx)
+	plug, err := tctx.GetClientPlugin(params, HTTP_SRV_PLUG)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+	return plug.Ext.(*PluginHttpSrvClient), nil
+}
+
+func (h ApiHttpSrvClientCntHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	var p core.ApiCntParams
+	tctx := ctx.(*core.CThreadCtx)
+	c, err := getHttpSrvClient(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	return c.cdbv.GeneralCounters(err, tctx, params, &p)
+}
+
+func (h ApiHttpSrvClientRequestsHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	c, err := getHttpSrvClient(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	return c.getRequestsInfo(), nil
+}
+
+func (h ApiHttpSrvNsCntHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	var p core.ApiCntParams
+	tctx := ctx.(*core.CThreadCtx)
+	plug, err := tctx.GetNsPlugin(params, HTTP_SRV_PLUG)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+	return plug.Ext.(*PluginHttpSrvNs).cdbv.GeneralCounters(nil, tctx, params, &p)
+}
+
+func init() {
+
+	/* register of plugins callbacks for ns,c level  */
+	core.PluginRegister(HTTP_SRV_PLUG,
+		core.PluginRegisterData{Client: PluginHttpSrvCReg{},
+			Ns:     PluginHttpSrvNsReg{},
+			Thread: nil}) /* no need for thread context for now */
+
+	core.RegisterCB("http_srv_client_cnt", ApiHttpSrvClientCntHandler{}, false)           // get counters/meta
+	core.RegisterCB("http_srv_client_requests", ApiHttpSrvClientRequestsHandler{}, false) // requests per method and path
+	core.RegisterCB("http_srv_ns_cnt", ApiHttpSrvNsCntHandler{}, false)                   // get counters/meta
+}
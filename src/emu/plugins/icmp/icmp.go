@@ -117,6 +117,7 @@ type PluginIcmpClient struct {
 	icmpNsPlug *PluginIcmpNs
 	ping       *ping.Ping
 	pingData   *ApiIcmpClientStartPingHandler
+	pingL3     uint16 // offset of the ip header in the ping template
 }
 
 var icmpEvents = []string{}
@@ -214,6 +215,7 @@ func (o *PluginIcmpClient) PreparePingPacketTemplate(id, seq uint16, magic uint6
 		layers.EthernetHeader(pkt).SetDestAddress(dstMac[:])
 	}
 	ipHeaderOffset := len(pkt)
+	o.pingL3 = uint16(ipHeaderOffset)
 	ipHeader := core.PacketUtlBuild(
 		&layers.IPv4{Version: 4, IHL: 5,
 			TTL:      ping.DefaultPingTTL,
@@ -242,6 +244,19 @@ func (o *PluginIcmpClient) UpdateTxIcmpQuery(pktSent uint64) {
 
 }
 
+//GetCounterDbVec returns the counters of the active ping, nil in case there isn't one.
+func (o *PluginIcmpClient) GetCounterDbVec() *core.CCounterDbVec {
+	if o.ping == nil {
+		return nil
+	}
+	return o.ping.GetCounterDbVec()
+}
+
+//SendPing implements ping.PingClientIF.SendPing by sending the Echo-Request using the MTU of the client.
+func (o *PluginIcmpClient) SendPing(m *core.Mbuf) {
+	o.Client.SendFragmented(m, o.pingL3)
+}
+
 //OnPingRemove implements ping.PingClientIF.OnPingRemove by updating the ping
 //corresponding fields when a ping is finishing or is stopped..
 func (o *PluginIcmpClient) OnPingRemove() {
@@ -267,6 +282,10 @@ func NewIcmpNs(ctx *core.PluginCtx, initJson []byte) *core.PluginBase {
 	return &o.PluginBase
 }
 
+func (o *PluginIcmpNs) GetCounterDbVec() *core.CCounterDbVec {
+	return o.cdbv
+}
+
 func (o *PluginIcmpNs) OnRemove(ctx *core.PluginCtx) {
 }
 
@@ -286,7 +305,7 @@ func iptime() uint32 {
 	return r
 }
 
-func (o *PluginIcmpNs) HandleEcho(ps *core.ParserPacketState, ts bool) {
+func (o *PluginIcmpNs) HandleEcho(ps *core.ParserPacketState, client *core.CClient, ts bool) {
 	mc := ps.M.DeepClone()
 	p := mc.GetData()
 
@@ -321,7 +340,7 @@ func (o *PluginIcmpNs) HandleEcho(ps *core.ParserPacketState, ts bool) {
 	}
 	o.stats.pktRxIcmpQuery++
 	o.stats.pktTxIcmpResponse++
-	o.Tctx.Veth.Send(mc)
+	client.SendFragmented(mc, ps.L3) // the request could be reassembled
 }
 
 //HandleEchoReply handles an ICMP Echo-Reply that is received in the ICMP namespace.
@@ -437,9 +456,9 @@ func (o *PluginIcmpNs) HandleRxIcmpPacket(ps *core.ParserPacketState) int {
 
 	switch icmpv4.TypeCode {
 	case layers.CreateICMPv4TypeCode(layers.ICMPv4TypeEchoRequest, 0):
-		o.HandleEcho(ps, false)
+		o.HandleEcho(ps, client, false)
 	case layers.CreateICMPv4TypeCode(layers.ICMPv4TypeTimestampRequest, 0):
-		o.HandleEcho(ps, true)
+		o.HandleEcho(ps, client, true)
 	case layers.CreateICMPv4TypeCode(layers.ICMPv4TypeEchoReply, 0):
 		res := o.HandleEchoReply(ps)
 		if res == core.PARSER_ERR {
@@ -672,5 +691,6 @@ func init() {
 	core.RegisterCB("icmp_c_get_ping_stats", ApiIcmpClientGetPingStatsHandler{}, true)
 
 	/* register callback for rx side*/
-	core.ParserRegister("icmp", HandleRxIcmpPacket)
+	core.ParserRegister("icmp", HandleRxIcmpPacket,
+		core.ParserRegisterData{IpProtos: []uint8{uint8(layers.IPProtocolICMPv4)}})
 }
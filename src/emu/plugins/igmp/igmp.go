@@ -458,12 +458,15 @@ type IgmpEntryJson struct {
 	S    *[]core.Ipv4Key `json:"sv,omitempty"`
 }
 
-//IgmpEntry includes one ipv4 mc addr
+//IgmpEntry includes one ipv4 mc addr. It could be owned by management (rpc) or by other plugins (e.g. mDNS)
+// other plugins will increment the refc while management will use the bool
 type IgmpEntry struct {
-	dlist     core.DList // must be first
-	Ipv4      core.Ipv4Key
-	epocQuery uint32
-	maps      MapIgmpS // map for source
+	dlist      core.DList // must be first
+	Ipv4       core.Ipv4Key
+	epocQuery  uint32
+	management bool     // added by management, could not be added twice
+	refc       uint16   // ref counter for the other plugins
+	maps       MapIgmpS // map for source
 }
 
 func (o *IgmpEntry) getJson() *IgmpEntryJson {
@@ -586,6 +589,7 @@ func (o *IgmpFlowTbl) addMcSG(ipv4 core.Ipv4Key, s core.Ipv4Key) (bool, error) {
 		e.Ipv4 = ipv4
 		e.epocQuery = o.epocQuery
 		e.allocMap() // create maps
+		e.management = true
 		o.mapIgmp[ipv4] = e
 		o.head.AddLast(&e.dlist)
 		r = true
@@ -615,26 +619,53 @@ func (o *IgmpFlowTbl) removeMcSG(ipv4 core.Ipv4Key, s core.Ipv4Key) (bool, error
 	return r, err
 }
 
-// Add MC(*)
-func (o *IgmpFlowTbl) addMc(ipv4 core.Ipv4Key) error {
-	_, ok := o.mapIgmp[ipv4]
+// Add MC(*), returns true when the entry is new
+func (o *IgmpFlowTbl) addMc(ipv4 core.Ipv4Key, man bool) (error, bool) {
+	obj, ok := o.mapIgmp[ipv4]
 	if ok {
-		return fmt.Errorf(" ns:%v mc-ipv4 %v already exist", o.ns.Key.StringRpc(), ipv4)
+		if !man {
+			obj.refc++
+			return nil, false
+		}
+		if !obj.management {
+			obj.management = true
+			return nil, false
+		}
+		return fmt.Errorf(" ns:%v mc-ipv4 %v already exist", o.ns.Key.StringRpc(), ipv4), false
 	}
 	// create new entry
 	e := new(IgmpEntry)
 	e.Ipv4 = ipv4
 	e.epocQuery = o.epocQuery
+	if man {
+		e.management = true
+	} else {
+		e.refc = 1
+	}
 	o.mapIgmp[ipv4] = e
 	o.head.AddLast(&e.dlist)
-	return nil
+	return nil, true
 }
 
-// removeMC(*)
-func (o *IgmpFlowTbl) removeMc(ipv4 core.Ipv4Key) error {
+// removeMC(*), returns true when the entry is removed
+func (o *IgmpFlowTbl) removeMc(ipv4 core.Ipv4Key, man bool) (bool, error) {
 	e, ok := o.mapIgmp[ipv4]
 	if !ok {
-		return fmt.Errorf(" ns:%v mc-ipv4 %v does not exist", o.ns.Key.StringRpc(), ipv4)
+		return false, fmt.Errorf(" ns:%v mc-ipv4 %v does not exist", o.ns.Key.StringRpc(), ipv4)
+	}
+	if man {
+		if !e.management {
+			return false, fmt.Errorf(" ns:%v mc-ipv4 %v wasn't added by management and can't be removed", o.ns.Key.StringRpc(), ipv4)
+		}
+		e.management = false
+	} else {
+		if e.refc == 0 {
+			panic(" igmp remove without adding from other plugins")
+		}
+		e.refc--
+	}
+	if e.refc > 0 || e.management {
+		return false, nil
 	}
 	if e.getMode() == IGMP_ENTRY_MODE_INCLUDE_S {
 		o.sgCount--
@@ -646,7 +677,7 @@ func (o *IgmpFlowTbl) removeMc(ipv4 core.Ipv4Key) error {
 		o.activeIter = e.dlist.Next()
 	}
 	o.head.RemoveNode(&e.dlist)
-	return nil
+	return true, nil
 }
 
 //dumpAll for debug and testing
@@ -760,7 +791,7 @@ func NewIgmpNs(ctx *core.PluginCtx, initJson []byte) *core.PluginBase {
 		o.designatorMac = init.DesignatorMac
 	}
 	if len(init.Vec) > 0 {
-		o.addMc(init.Vec)
+		o.AddMc(init.Vec)
 	}
 
 	o.mtu = init.Mtu
@@ -839,6 +870,10 @@ func (o *PluginIgmpNs) preparePacketTemplate() {
 	o.ipv4pktTemplate = append(l2, igmpHeader...)
 }
 
+func (o *PluginIgmpNs) GetCounterDbVec() *core.CCounterDbVec {
+	return o.cdbv
+}
+
 func (o *PluginIgmpNs) OnRemove(ctx *core.PluginCtx) {
 	if o.timer.IsRunning() {
 		o.timerw.Stop(&o.timer)
@@ -919,24 +954,36 @@ func (o *PluginIgmpNs) removeMcSG(vecIpv4 []*IgmpSGRecord) error {
 	return nil
 }
 
-func (o *PluginIgmpNs) addMc(vecIpv4 []core.Ipv4Key) error {
+func (o *PluginIgmpNs) AddMc(vecIpv4 []core.Ipv4Key) error {
+	return o.addMcVec(vecIpv4, true)
+}
+
+// JoinMc adds a reference to the groups for the other plugins, the groups added by rpc are kept
+func (o *PluginIgmpNs) JoinMc(vecIpv4 []core.Ipv4Key) error {
+	return o.addMcVec(vecIpv4, false)
+}
+
+func (o *PluginIgmpNs) addMcVec(vecIpv4 []core.Ipv4Key, man bool) error {
 
 	var err error
+	var add bool
 	vec := []uint32{}
 	maxIds := int(o.getMaxIPv4Ids())
 	o.tbl.epoc++
 	for _, ipv4 := range vecIpv4 {
-		err = o.tbl.addMc(ipv4)
+		err, add = o.tbl.addMc(ipv4, man)
 		if err != nil {
 			o.stats.opsAddErr++
 			o.SendMcPacket(vec, false, false)
 			return err
 		}
-		o.stats.opsAdd++
-		vec = append(vec, ipv4.Uint32())
-		if len(vec) == maxIds {
-			o.SendMcPacket(vec, false, false)
-			vec = vec[:0]
+		if add {
+			o.stats.opsAdd++
+			vec = append(vec, ipv4.Uint32())
+			if len(vec) == maxIds {
+				o.SendMcPacket(vec, false, false)
+				vec = vec[:0]
+			}
 		}
 	}
 	o.SendMcPacket(vec, false, false)
@@ -944,27 +991,40 @@ func (o *PluginIgmpNs) addMc(vecIpv4 []core.Ipv4Key) error {
 }
 
 func (o *PluginIgmpNs) RemoveMc(vecIpv4 []core.Ipv4Key) error {
+	return o.removeMcVec(vecIpv4, true)
+}
+
+// LeaveMc removes a reference of JoinMc
+func (o *PluginIgmpNs) LeaveMc(vecIpv4 []core.Ipv4Key) error {
+	return o.removeMcVec(vecIpv4, false)
+}
+
+func (o *PluginIgmpNs) removeMcVec(vecIpv4 []core.Ipv4Key, man bool) error {
 	var err error
+	var r bool
 	vec := []uint32{}
 	o.tbl.epoc++
 	maxIds := int(o.getMaxIPv4Ids())
 	for _, ipv4 := range vecIpv4 {
-		err = o.tbl.removeMc(ipv4)
+		r, err = o.tbl.removeMc(ipv4, man)
 		if err != nil {
 			o.stats.opsRemoveErr++
 			o.SendMcPacket(vec, true, false)
 			return err
 		}
-		o.stats.opsRemove++
-		vec = append(vec, ipv4.Uint32())
-		if len(vec) == maxIds {
-			o.SendMcPacket(vec, true, false)
-			vec = vec[:0]
+		if r {
+			o.stats.opsRemove++
+			vec = append(vec, ipv4.Uint32())
+			if len(vec) == maxIds {
+				o.SendMcPacket(vec, true, false)
+				vec = vec[:0]
+			}
 		}
 	}
 	o.SendMcPacket(vec, true, false)
 	return nil
 }
+
 func (o *PluginIgmpNs) IsValidQueryEpoc(v uint32) bool {
 	var d uint32
 	d = o.activeEpocQuery - v
@@ -1856,7 +1916,7 @@ func (h ApiIgmpNsAddHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawM
 		}
 	}
 
-	err1 = igmpPlug.addMc(p.Vec)
+	err1 = igmpPlug.AddMc(p.Vec)
 
 	if err1 != nil {
 		return nil, &jsonrpc.Error{
@@ -2042,7 +2102,8 @@ func init() {
 	core.RegisterCB("igmp_ns_set_cfg", ApiIgmpSetHandler{}, false)          // Set
 
 	/* register callback for rx side*/
-	core.ParserRegister("igmp", HandleRxIgmpPacket)
+	core.ParserRegister("igmp", HandleRxIgmpPacket,
+		core.ParserRegisterData{IpProtos: []uint8{uint8(layers.IPProtocolIGMP)}})
 }
 
 func Register(ctx *core.CThreadCtx) {
@@ -142,7 +142,7 @@ func createSimulationEnv(simRx *core.VethIFSim, num int) (*core.CThreadCtx, *cor
 	for j := 0; j < num; j++ {
 		vecIpv4 = append(vecIpv4, core.Ipv4Key{239, 0, uint8(((j >> 8) & 0xff)), uint8(j)})
 	}
-	nsPlug.addMc(vecIpv4)
+	nsPlug.AddMc(vecIpv4)
 	return tctx, nil
 }
 
@@ -19,6 +19,7 @@ TRex EMU emulates the aforementioned flow exporter for https://tools.ietf.org/ht
 
 import (
 	"emu/core"
+	"emu/plugins/dns"
 	engines "emu/plugins/field_engine"
 	"emu/plugins/transport"
 	"encoding/binary"
@@ -922,7 +923,7 @@ func NewIPFixStatsDb(o *IPFixStats) *core.CCounterDb {
 // IPFixClientParams defines the json structure for Ipfix plugin.
 type IPFixClientParams struct {
 	Ver        uint16                 `json:"netflow_version"`                // NetFlow version 9 or 10
-	Dst        string                 `json:"dst" validate:"required"`        // Destination Address. Combination of Host:Port.
+	Dst        string                 `json:"dst" validate:"required"`        // Destination Address. Combination of Host:Port, the host could be a name.
 	DomainID   uint32                 `json:"domain_id"`                      // Observation Domain ID
 	Generators []*fastjson.RawMessage `json:"generators" validate:"required"` // Ipfix Generators (Template or Data)
 }
@@ -947,6 +948,7 @@ type PluginIPFixClient struct {
 	transportCtx    *transport.TransportCtx // Transport Layer Context
 	socket          transport.SocketApi     // Socket API
 	dgMacResolved   bool                    // Is the default gateway MAC address resolved?
+	removed         bool                    // Was the plugin removed, e.g. while the destination is resolved
 	availableL7MTU  uint16                  // Available L7 MTU
 	timerw          *core.TimerCtx          // Timer Wheel
 	timer           core.CHTimerObj         // Timer Object for calculating Unix time every tick
@@ -1040,24 +1042,44 @@ func (o *PluginIPFixClient) OnCreate() {
 func (o *PluginIPFixClient) OnResolve() {
 	o.dgMacResolved = true
 	if o.transportCtx != nil {
-		var err error
-		o.socket, err = o.transportCtx.Dial("udp", o.dstAddress, o, nil, nil)
-		if err != nil {
-			o.stats.invalidSocket++
-			return
-		}
-		o.availableL7MTU = o.socket.GetL7MTU()
+		// A host name is resolved by the dns plugin of the client, a literal address at once.
+		dns.ResolveAddr(o.Client, o.dstAddress, o.isIpv6, func(addr string, err error) {
+			if o.removed {
+				return
+			}
+			if err != nil {
+				o.stats.invalidDst++
+				return
+			}
+			o.dial(addr)
+		})
+	}
 
-		for i, _ := range o.generators {
-			// Created generators can now proceed.
-			o.generators[i].OnResolve()
-		}
+}
+
+// dial opens the socket to the resolved destination address and starts the generators.
+func (o *PluginIPFixClient) dial(addr string) {
+	var err error
+	o.socket, err = o.transportCtx.Dial("udp", addr, o, nil, nil)
+	if err != nil {
+		o.stats.invalidSocket++
+		return
 	}
+	o.availableL7MTU = o.socket.GetL7MTU()
 
+	for i, _ := range o.generators {
+		// Created generators can now proceed.
+		o.generators[i].OnResolve()
+	}
 }
 
 // OnRemove is called when we are trying to remove this IPFix client.
+func (o *PluginIPFixClient) GetCounterDbVec() *core.CCounterDbVec {
+	return o.cdbv
+}
+
 func (o *PluginIPFixClient) OnRemove(ctx *core.PluginCtx) {
+	o.removed = true
 	ctx.UnregisterEvents(&o.PluginBase, ipfixEvents)
 	// Stop Our Timer
 	if o.timer.IsRunning() {
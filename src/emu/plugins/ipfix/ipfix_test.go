@@ -2,6 +2,8 @@ package ipfix
 
 import (
 	"emu/core"
+	"emu/plugins/dns"
+	"emu/plugins/transport"
 	"flag"
 	"fmt"
 	"math/rand"
@@ -2501,3 +2503,61 @@ func TestPluginIPFix23(t *testing.T) {
 func init() {
 	flag.IntVar(&monitor, "monitor", 0, "monitor")
 }
+
+// VethIPFixLoopSim passes the packets between the exporter and the collector of the namespace
+type VethIPFixLoopSim struct{}
+
+func (o *VethIPFixLoopSim) ProcessTxToRx(m *core.Mbuf) *core.Mbuf {
+	return m
+}
+
+// ipfixCollector counts the bytes of the exported packets
+type ipfixCollector struct {
+	rx int
+}
+
+func (o *ipfixCollector) OnAccept(socket transport.SocketApi) transport.ISocketCb { return o }
+func (o *ipfixCollector) OnRxEvent(event transport.SocketEventType)               {}
+func (o *ipfixCollector) OnTxEvent(event transport.SocketEventType)               {}
+func (o *ipfixCollector) OnRxData(d []byte)                                       { o.rx += len(d) }
+
+// the host of the destination is resolved by the dns plugin of the client
+func TestPluginIPFixResolve(t *testing.T) {
+	var simrx core.VethIFSim = &VethIPFixLoopSim{}
+	tctx := core.NewThreadCtx(0, 4510, true, &simrx)
+	defer tctx.Delete()
+	transport.Register(tctx)
+	dns.Register(tctx)
+	var key core.CTunnelKey
+	key.Set(&core.CTunnelData{Vport: 1})
+	ns := core.NewNSCtx(tctx, &key)
+	tctx.AddNs(&key, ns)
+	var clients []*core.CClient
+	for id := byte(1); id <= 2; id++ {
+		c := core.NewClient(ns, core.MACKey{0, 0, 1, 0, 0, id}, core.Ipv4Key{16, 0, 0, id}, core.Ipv6Key{}, core.Ipv4Key{})
+		c.ForceDGW = true
+		c.Ipv4ForcedgMac = core.MACKey{0, 0, 1, 0, 0, 3 - id}
+		ns.AddClient(c)
+		clients = append(clients, c)
+	}
+	// the collector is the name server too
+	clients[1].PluginCtx.CreatePlugins([]string{dns.DNS_SRV_PLUG},
+		[][]byte{[]byte(`{"zone": [{"name": "collector.example.com", "type": "A", "data": "16.0.0.2"}]}`)})
+	collector := &ipfixCollector{}
+	if err := transport.GetTransportCtx(clients[1]).Listen("udp", ":4739", collector); err != nil {
+		t.Fatal(err)
+	}
+
+	templateParams := TemplateParams{autoStart: true, rate: 2, recordsNum: 7}
+	initJson := fmt.Sprintf(`{"netflow_version": 10, "dst": "collector.example.com:4739", "generators": [%s]}`,
+		getTemplate261(&templateParams))
+	clients[0].PluginCtx.CreatePlugins([]string{dns.DNS_PLUG, IPFIX_PLUG},
+		[][]byte{[]byte(`{"servers": ["16.0.0.2"]}`), []byte(initJson)})
+	clients[0].AttemptResolve()
+	tctx.MainLoopSim(5 * time.Second)
+
+	ipfixPlug := clients[0].PluginCtx.Get(IPFIX_PLUG).Ext.(*PluginIPFixClient)
+	if collector.rx == 0 || ipfixPlug.stats.invalidDst != 0 || ipfixPlug.stats.invalidSocket != 0 {
+		t.Fatalf(" the collector got %d bytes, counters %+v", collector.rx, ipfixPlug.stats)
+	}
+}
@@ -121,6 +121,7 @@ type PluginIpv6Client struct {
 	nd         NdClientCtx
 	pingData   *ApiIpv6StartPingHandler
 	ping       *ping.Ping
+	pingL3     uint16 // offset of the ip header in the ping template
 }
 
 var icmpEvents = []string{core.MSG_UPDATE_IPV6_ADDR,
@@ -230,6 +231,7 @@ func (o *PluginIpv6Client) PreparePingPacketTemplate(id, seq uint16, magic uint6
 		}
 	}
 	ipHeaderOffset := len(pkt)
+	o.pingL3 = uint16(ipHeaderOffset)
 	ipHeader := core.PacketUtlBuild(
 		&layers.IPv6{
 			Version:      6,
@@ -267,6 +269,19 @@ func (o *PluginIpv6Client) UpdateTxIcmpQuery(pktSent uint64) {
 
 }
 
+// GetCounterDbVec returns the counters of the active ping, nil in case there isn't one.
+func (o *PluginIpv6Client) GetCounterDbVec() *core.CCounterDbVec {
+	if o.ping == nil {
+		return nil
+	}
+	return o.ping.GetCounterDbVec()
+}
+
+// SendPing implements ping.PingClientIF.SendPing by sending the Echo-Request using the IPv6 MTU of the client.
+func (o *PluginIpv6Client) SendPing(m *core.Mbuf) {
+	o.Client.SendFragmented(m, o.pingL3)
+}
+
 // OnPingRemove implements ping.PingClientIF.OnPingRemove by updating the ping
 // corresponding fields when a ping is finishing or is stopped..
 func (o *PluginIpv6Client) OnPingRemove() {
@@ -299,6 +314,10 @@ func NewIpv6Ns(ctx *core.PluginCtx, initJson []byte) *core.PluginBase {
 	return &o.PluginBase
 }
 
+func (o *PluginIpv6Ns) GetCounterDbVec() *core.CCounterDbVec {
+	return o.cdbv
+}
+
 func (o *PluginIpv6Ns) OnRemove(ctx *core.PluginCtx) {
 	o.mld.OnRemove(ctx)
 	o.nd.OnRemove(ctx)
@@ -312,7 +331,17 @@ func (o *PluginIpv6Ns) SetTruncated() {
 
 }
 
-func (o *PluginIpv6Ns) HandleEcho(ps *core.ParserPacketState, ts bool) {
+// JoinMc adds a reference to the groups on behalf of another plugin, like the solicited-node groups of the clients
+func (o *PluginIpv6Ns) JoinMc(vecIpv6 []core.Ipv6Key) error {
+	return o.mld.addMcInternal(vecIpv6)
+}
+
+// LeaveMc removes a reference of JoinMc
+func (o *PluginIpv6Ns) LeaveMc(vecIpv6 []core.Ipv6Key) error {
+	return o.mld.removeMcInternal(vecIpv6)
+}
+
+func (o *PluginIpv6Ns) HandleEcho(ps *core.ParserPacketState, client *core.CClient, ts bool) {
 	mc := ps.M.DeepClone()
 	p := mc.GetData()
 
@@ -353,7 +382,7 @@ func (o *PluginIpv6Ns) HandleEcho(ps *core.ParserPacketState, ts bool) {
 
 	o.stats.pktRxIcmpQuery++
 	o.stats.pktTxIcmpResponse++
-	o.Tctx.Veth.Send(mc)
+	client.SendFragmented(mc, ps.L3) // the request could be reassembled
 }
 
 //HandleEchoReply handles an ICMP Echo-Reply that is received in the ICMP namespace.
@@ -501,7 +530,7 @@ func (o *PluginIpv6Ns) HandleRxIpv6Packet(ps *core.ParserPacketState) int {
 			return 0
 		}
 
-		o.HandleEcho(ps, false)
+		o.HandleEcho(ps, client, false)
 	case layers.CreateICMPv6TypeCode(layers.ICMPv6TypeMLDv1MulticastListenerQueryMessage, 0),
 		layers.CreateICMPv6TypeCode(layers.ICMPv6TypeMLDv1MulticastListenerReportMessage, 0),
 		layers.CreateICMPv6TypeCode(layers.ICMPv6TypeMLDv1MulticastListenerDoneMessage, 0),
@@ -1088,7 +1117,8 @@ func init() {
 	core.RegisterCB("ipv6_get_ping_stats", ApiIpv6GetPingStatsHandler{}, true) // get ping stats
 
 	/* register callback for rx side*/
-	core.ParserRegister("icmpv6", HandleRxIcmpv6Packet) // support mld/icmp/nd
+	core.ParserRegister("icmpv6", HandleRxIcmpv6Packet,
+		core.ParserRegisterData{IpProtos: []uint8{uint8(layers.IPProtocolICMPv6)}}) // support mld/icmp/nd
 }
 
 func Register(ctx *core.CThreadCtx) {
@@ -236,6 +236,7 @@ func NewIpv6NsStatsDb(o *Ipv6NsStats) *core.CCounterDb {
 		Help:     "ipv6 nd table active",
 		Unit:     "entries",
 		DumpZero: false,
+		Gauge:    true,
 		Info:     core.ScINFO})
 	db.Add(&core.CCounterRec{
 		Counter:  &o.tblAdd,
@@ -1218,6 +1219,15 @@ func (o *NdClientCtx) ResolveDG() {
 
 // respond with Neighbor adv
 func (o *NdClientCtx) Respond(mac *core.MACKey, ps *core.ParserPacketState) {
+	o.respond(mac, false, ps)
+}
+
+// RespondVirtual answers for a virtual ipv6 from its virtual MAC, as a router
+func (o *NdClientCtx) RespondVirtual(mac *core.MACKey, ps *core.ParserPacketState) {
+	o.respond(mac, true, ps)
+}
+
+func (o *NdClientCtx) respond(mac *core.MACKey, router bool, ps *core.ParserPacketState) {
 
 	ms := ps.M
 	psrc := ms.GetData()
@@ -1246,6 +1256,10 @@ func (o *NdClientCtx) Respond(mac *core.MACKey, ps *core.ParserPacketState) {
 		o.nsPlug.stats.pktTxNeighborAdvUnicast++
 		p[l4+4] = 0x60
 	}
+	if router {
+		copy(p[6:12], mac[:])
+		p[l4+4] |= 0x80
+	}
 
 	ipv6.FixIcmpL4Checksum(p[l4:], 0)
 
@@ -1619,6 +1633,19 @@ func (o *NdNsCtx) HandleRxIpv6NdPacket(ps *core.ParserPacketState, code layers.I
 			}
 		}
 
+		var vip core.Ipv6Key
+		copy(vip[:], ra.TargetAddress)
+		if v := o.base.Ns.LookupVirtualIPv6(&vip); v != nil {
+			// virtual ipv6 of VRRP/HSRP
+			cplg := v.Client.PluginCtx.Get(IPV6_PLUG)
+			if cplg == nil {
+				o.stats.pktRxNeighborSolicitationLocalIpNotFound++
+				return core.PARSER_ERR
+			}
+			cplg.Ext.(*PluginIpv6Client).nd.RespondVirtual(&v.Mac, ps)
+			return core.PARSER_OK
+		}
+
 		global := ra.TargetAddress.IsGlobalUnicast()
 
 		if ra.TargetAddress.IsLinkLocalUnicast() || global {
@@ -0,0 +1,788 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package lacp
+
+/*
+lacp (IEEE 802.1AX/802.3ad) actor of one aggregation port.
+
+The namespace is the port, the LACPDUs are sent from mac (a client of the namespace) on the vport/vlans of the
+namespace. Namespaces on a few vports with the same sys_id and key form a link aggregation group with the DUT.
+
+The receive, periodic transmission, selection and mux (independent control) machines are implemented, there is one
+aggregator for each LAG ID so a port is selected as long as its partner information is current. A defaulted
+port is not selected (no LACP partner). Marker PDUs are answered by a Marker Response.
+
+init json:
+
+	{"mac": [0, 0, 1, 0, 0, 1], "sys_id": [0, 0, 1, 0, 0, 1], "sys_prio": 32768, "key": 1, "port": 1, "port_prio": 32768,
+	 "passive": false, "fast": false, "individual": false}
+
+*/
+
+import (
+	"bytes"
+	"emu/core"
+	"encoding/binary"
+	"external/osamingo/jsonrpc"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/intel-go/fastjson"
+)
+
+const (
+	LACP_PLUG = "lacp"
+
+	SLOW_PROTOCOLS_ETHER_TYPE     = 0x8809
+	SLOW_PROTOCOLS_SUBTYPE_LACP   = 1
+	SLOW_PROTOCOLS_SUBTYPE_MARKER = 2
+
+	LACP_PDU_SIZE    = 110
+	MARKER_PDU_SIZE  = 110
+	LACP_TLV_ACTOR   = 1
+	LACP_TLV_PARTNER = 2
+	LACP_TLV_COLL    = 3
+	LACP_INFO_LEN    = 20
+	LACP_COLL_LEN    = 16
+	MARKER_TLV_INFO  = 1
+	MARKER_TLV_RESP  = 2
+	MARKER_INFO_LEN  = 16
+	LACP_DEF_PRIO    = 32768
+
+	/* port state bits */
+	LACP_STATE_ACTIVITY     = 0x01
+	LACP_STATE_TIMEOUT      = 0x02 // short timeout
+	LACP_STATE_AGGREGATION  = 0x04
+	LACP_STATE_SYNC         = 0x08
+	LACP_STATE_COLLECTING   = 0x10
+	LACP_STATE_DISTRIBUTING = 0x20
+	LACP_STATE_DEFAULTED    = 0x40
+	LACP_STATE_EXPIRED      = 0x80
+
+	LACP_FAST_PERIODIC_SEC  = 1
+	LACP_SLOW_PERIODIC_SEC  = 30
+	LACP_SHORT_TIMEOUT_SEC  = 3
+	LACP_LONG_TIMEOUT_SEC   = 90
+	LACP_AGGREGATE_WAIT_SEC = 2
+
+	/* receive machine */
+	LACP_RX_EXPIRED   = 1
+	LACP_RX_DEFAULTED = 2
+	LACP_RX_CURRENT   = 3
+
+	/* mux machine */
+	LACP_MUX_DETACHED     = 1
+	LACP_MUX_WAITING      = 2
+	LACP_MUX_ATTACHED     = 3
+	LACP_MUX_COLLECTING   = 4
+	LACP_MUX_DISTRIBUTING = 5
+
+	/* timers */
+	LACP_TIMER_CURRENT_WHILE = 1
+	LACP_TIMER_PERIODIC      = 2
+	LACP_TIMER_WAIT_WHILE    = 3
+)
+
+var lacpDestMAC = core.MACKey{0x01, 0x80, 0xc2, 0x00, 0x00, 0x02}
+
+var lacpRxStateNames = map[uint8]string{
+	LACP_RX_EXPIRED:   "expired",
+	LACP_RX_DEFAULTED: "defaulted",
+	LACP_RX_CURRENT:   "current",
+}
+
+var lacpMuxStateNames = map[uint8]string{
+	LACP_MUX_DETACHED:     "detached",
+	LACP_MUX_WAITING:      "waiting",
+	LACP_MUX_ATTACHED:     "attached",
+	LACP_MUX_COLLECTING:   "collecting",
+	LACP_MUX_DISTRIBUTING: "distributing",
+}
+
+type LacpInit struct {
+	Mac        core.MACKey  `json:"mac" validate:"required"` // the client of the port
+	SysId      *core.MACKey `json:"sys_id"`                  // default is mac
+	SysPrio    uint16       `json:"sys_prio"`
+	Key        uint16       `json:"key"`
+	Port       uint16       `json:"port"` // default is the vport
+	PortPrio   uint16       `json:"port_prio"`
+	Passive    bool         `json:"passive"`
+	Fast       bool         `json:"fast"` // ask the partner for fast periodic transmission
+	Individual bool         `json:"individual"`
+}
+
+// LacpPortInfo actor/partner information of a LACPDU
+type LacpPortInfo struct {
+	SysPrio  uint16      `json:"sys_prio"`
+	Sys      core.MACKey `json:"sys_id"`
+	Key      uint16      `json:"key"`
+	PortPrio uint16      `json:"port_prio"`
+	Port     uint16      `json:"port"`
+	State    uint8       `json:"state"`
+}
+
+func (o *LacpPortInfo) decode(b []byte) {
+	o.SysPrio = binary.BigEndian.Uint16(b[0:2])
+	copy(o.Sys[:], b[2:8])
+	o.Key = binary.BigEndian.Uint16(b[8:10])
+	o.PortPrio = binary.BigEndian.Uint16(b[10:12])
+	o.Port = binary.BigEndian.Uint16(b[12:14])
+	o.State = b[14]
+}
+
+func (o *LacpPortInfo) encode(b []byte) {
+	binary.BigEndian.PutUint16(b[0:2], o.SysPrio)
+	copy(b[2:8], o.Sys[:])
+	binary.BigEndian.PutUint16(b[8:10], o.Key)
+	binary.BigEndian.PutUint16(b[10:12], o.PortPrio)
+	binary.BigEndian.PutUint16(b[12:14], o.Port)
+	b[14] = o.State
+}
+
+// sameLink compares the parameters that identify the port and its aggregation
+func (o *LacpPortInfo) sameLink(b *LacpPortInfo) bool {
+	return o.SysPrio == b.SysPrio && o.Sys == b.Sys && o.Key == b.Key && o.PortPrio == b.PortPrio &&
+		o.Port == b.Port && o.State&LACP_STATE_AGGREGATION == b.State&LACP_STATE_AGGREGATION
+}
+
+func (o *LacpPortInfo) lagIdPart(individual bool) string {
+	if individual {
+		return fmt.Sprintf("(%04X,%s,%04X,%04X,%04X)", o.SysPrio, net.HardwareAddr(o.Sys[:]).String(), o.Key, o.PortPrio, o.Port)
+	}
+	return fmt.Sprintf("(%04X,%s,%04X,0000,0000)", o.SysPrio, net.HardwareAddr(o.Sys[:]).String(), o.Key)
+}
+
+// LacpPortInfoRec port information with the state bits for the RPC
+type LacpPortInfoRec struct {
+	LacpPortInfo
+	Active       bool `json:"active"`
+	Fast         bool `json:"fast"`
+	Aggregation  bool `json:"aggregation"`
+	Sync         bool `json:"sync"`
+	Collecting   bool `json:"collecting"`
+	Distributing bool `json:"distributing"`
+	Defaulted    bool `json:"defaulted"`
+	Expired      bool `json:"expired"`
+}
+
+func newLacpPortInfoRec(i *LacpPortInfo) LacpPortInfoRec {
+	return LacpPortInfoRec{LacpPortInfo: *i,
+		Active:       i.State&LACP_STATE_ACTIVITY != 0,
+		Fast:         i.State&LACP_STATE_TIMEOUT != 0,
+		Aggregation:  i.State&LACP_STATE_AGGREGATION != 0,
+		Sync:         i.State&LACP_STATE_SYNC != 0,
+		Collecting:   i.State&LACP_STATE_COLLECTING != 0,
+		Distributing: i.State&LACP_STATE_DISTRIBUTING != 0,
+		Defaulted:    i.State&LACP_STATE_DEFAULTED != 0,
+		Expired:      i.State&LACP_STATE_EXPIRED != 0}
+}
+
+// LacpInfo port information for the RPC
+type LacpInfo struct {
+	Actor    LacpPortInfoRec `json:"actor"`
+	Partner  LacpPortInfoRec `json:"partner"`
+	RxState  string          `json:"rx_state"`
+	MuxState string          `json:"mux_state"`
+	Selected bool            `json:"selected"`
+	LagId    string          `json:"lag_id"`
+}
+
+type LacpStats struct {
+	pktTx            uint64
+	pktRx            uint64
+	pktRxMarker      uint64
+	pktTxMarker      uint64
+	errPdu           uint64
+	errNoClient      uint64
+	errInitJson      uint64
+	rxExpired        uint64
+	rxDefaulted      uint64
+	partnerChange    uint64
+	muxDistributing  uint64
+	muxOutOfDistrib  uint64
+	pktRxUnsupported uint64
+}
+
+func NewLacpStatsDb(o *LacpStats) *core.CCounterDb {
+	db := core.NewCCounterDb("lacp")
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTx,
+		Name:     "pktTx",
+		Help:     "lacpdu sent",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRx,
+		Name:     "pktRx",
+		Help:     "lacpdu received",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxMarker,
+		Name:     "pktRxMarker",
+		Help:     "marker pdu received",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTxMarker,
+		Name:     "pktTxMarker",
+		Help:     "marker response sent",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.errPdu,
+		Name:     "errPdu",
+		Help:     "malformed lacpdu or marker pdu",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxUnsupported,
+		Name:     "pktRxUnsupported",
+		Help:     "slow protocols packets that are not lacp/marker",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.errNoClient,
+		Name:     "errNoClient",
+		Help:     "the client of mac does not exist, can't send",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.errInitJson,
+		Name:     "errInitJson",
+		Help:     "invalid init json",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.rxExpired,
+		Name:     "rxExpired",
+		Help:     "partner information expired",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.rxDefaulted,
+		Name:     "rxDefaulted",
+		Help:     "partner information defaulted",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.partnerChange,
+		Name:     "partnerChange",
+		Help:     "new partner, the port is unselected",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.muxDistributing,
+		Name:     "muxDistributing",
+		Help:     "port moved to distributing",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.muxOutOfDistrib,
+		Name:     "muxOutOfDistrib",
+		Help:     "port moved out of distributing",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	return db
+}
+
+type PluginLacpNsTimer struct {
+}
+
+func (o *PluginLacpNsTimer) OnEvent(a, b interface{}) {
+	pi := a.(*PluginLacpNs)
+	pi.onTimerEvent(b.(int))
+}
+
+// PluginLacpNs lacp actor of the port of the namespace
+type PluginLacpNs struct {
+	core.PluginBase
+	init         LacpInit
+	enable       bool
+	actor        LacpPortInfo
+	partner      LacpPortInfo
+	rxState      uint8
+	muxState     uint8
+	selected     bool
+	ready        bool // wait_while has expired
+	ntt          bool // need to transmit
+	periodicSec  uint32
+	timerw       *core.TimerCtx
+	currentWhile core.CHTimerObj
+	periodic     core.CHTimerObj
+	waitWhile    core.CHTimerObj
+	timerCb      PluginLacpNsTimer
+	stats        LacpStats
+	cdb          *core.CCounterDb
+	cdbv         *core.CCounterDbVec
+}
+
+func NewLacpNs(ctx *core.PluginCtx, initJson []byte) *core.PluginBase {
+	o := new(PluginLacpNs)
+	o.InitPluginBase(ctx, o)
+	o.RegisterEvents(ctx, []string{}, o)
+	o.cdb = NewLacpStatsDb(&o.stats)
+	o.cdbv = core.NewCCounterDbVec("lacp")
+	o.cdbv.Add(o.cdb)
+	o.timerw = o.Tctx.GetTimerCtx()
+	o.currentWhile.SetCB(&o.timerCb, o, LACP_TIMER_CURRENT_WHILE)
+	o.periodic.SetCB(&o.timerCb, o, LACP_TIMER_PERIODIC)
+	o.waitWhile.SetCB(&o.timerCb, o, LACP_TIMER_WAIT_WHILE)
+
+	o.init = LacpInit{SysPrio: LACP_DEF_PRIO, PortPrio: LACP_DEF_PRIO, Key: 1, Port: o.Ns.GetVport()}
+	err := o.Tctx.UnmarshalValidate(initJson, &o.init)
+	if err != nil {
+		o.stats.errInitJson++
+		return &o.PluginBase
+	}
+	o.enable = true
+	o.start()
+	return &o.PluginBase
+}
+
+func (o *PluginLacpNs) start() {
+	o.actor = LacpPortInfo{SysPrio: o.init.SysPrio, Sys: o.init.Mac, Key: o.init.Key,
+		PortPrio: o.init.PortPrio, Port: o.init.Port}
+	if o.init.SysId != nil {
+		o.actor.Sys = *o.init.SysId
+	}
+	if !o.init.Passive {
+		o.actor.State |= LACP_STATE_ACTIVITY
+	}
+	if o.init.Fast {
+		o.actor.State |= LACP_STATE_TIMEOUT
+	}
+	if !o.init.Individual {
+		o.actor.State |= LACP_STATE_AGGREGATION
+	}
+	o.muxState = LACP_MUX_DETACHED
+	o.rxExpired()
+	o.txLacpdu()
+}
+
+func (o *PluginLacpNs) OnRemove(ctx *core.PluginCtx) {
+	for _, t := range []*core.CHTimerObj{&o.currentWhile, &o.periodic, &o.waitWhile} {
+		if t.IsRunning() {
+			o.timerw.Stop(t)
+		}
+	}
+}
+
+func (o *PluginLacpNs) OnEvent(msg string, a, b interface{}) {
+
+}
+
+func (o *PluginLacpNs) GetCounterDbVec() *core.CCounterDbVec {
+	return o.cdbv
+}
+
+func (o *PluginLacpNs) restartTimer(t *core.CHTimerObj, sec uint32) {
+	if t.IsRunning() {
+		o.timerw.Stop(t)
+	}
+	o.timerw.Start(t, time.Duration(sec)*time.Second)
+}
+
+func (o *PluginLacpNs) onTimerEvent(t int) {
+	switch t {
+	case LACP_TIMER_CURRENT_WHILE:
+		if o.rxState == LACP_RX_CURRENT {
+			o.rxExpired()
+		} else if o.rxState == LACP_RX_EXPIRED {
+			o.rxDefaulted()
+		}
+	case LACP_TIMER_PERIODIC:
+		o.ntt = true
+		o.updatePeriodic()
+	case LACP_TIMER_WAIT_WHILE:
+		o.ready = true
+		o.runMux()
+	}
+	o.txLacpdu()
+}
+
+// currentWhileSec the timeout of the partner information, by the timeout bit of the actor
+func (o *PluginLacpNs) currentWhileSec() uint32 {
+	if o.actor.State&LACP_STATE_TIMEOUT != 0 {
+		return LACP_SHORT_TIMEOUT_SEC
+	}
+	return LACP_LONG_TIMEOUT_SEC
+}
+
+// updatePeriodic (re)starts the periodic transmission by the timeout bit of the partner
+func (o *PluginLacpNs) updatePeriodic() {
+	if (o.actor.State|o.partner.State)&LACP_STATE_ACTIVITY == 0 {
+		if o.periodic.IsRunning() {
+			o.timerw.Stop(&o.periodic)
+		}
+		o.periodicSec = 0
+		return
+	}
+	sec := uint32(LACP_SLOW_PERIODIC_SEC)
+	if o.partner.State&LACP_STATE_TIMEOUT != 0 {
+		sec = LACP_FAST_PERIODIC_SEC
+	}
+	if sec != o.periodicSec || !o.periodic.IsRunning() {
+		if sec < o.periodicSec {
+			// the partner asks for fast transmission now
+			o.ntt = true
+		}
+		o.periodicSec = sec
+		o.restartTimer(&o.periodic, sec)
+	}
+}
+
+func (o *PluginLacpNs) rxExpired() {
+	o.stats.rxExpired++
+	o.rxState = LACP_RX_EXPIRED
+	o.partner.State &^= LACP_STATE_SYNC
+	o.partner.State |= LACP_STATE_TIMEOUT
+	o.actor.State |= LACP_STATE_EXPIRED
+	o.restartTimer(&o.currentWhile, LACP_SHORT_TIMEOUT_SEC)
+	o.runMux()
+	o.updatePeriodic()
+}
+
+func (o *PluginLacpNs) rxDefaulted() {
+	o.stats.rxDefaulted++
+	o.rxState = LACP_RX_DEFAULTED
+	o.partner = LacpPortInfo{State: LACP_STATE_ACTIVITY}
+	o.actor.State &^= LACP_STATE_EXPIRED
+	o.actor.State |= LACP_STATE_DEFAULTED
+	o.selected = false
+	o.runMux()
+	o.updatePeriodic()
+}
+
+// onRxLacpdu the receive machine in current state, actor/partner are the information of the LACPDU
+func (o *PluginLacpNs) onRxLacpdu(actor, partner *LacpPortInfo) {
+	o.stats.pktRx++
+	// the partner view of this port is not up to date
+	if !partner.sameLink(&o.actor) || (partner.State^o.actor.State)&(LACP_STATE_ACTIVITY|LACP_STATE_TIMEOUT|LACP_STATE_SYNC) != 0 {
+		o.ntt = true
+	}
+	if o.rxState != LACP_RX_DEFAULTED && !o.partner.Sys.IsZero() && !actor.sameLink(&o.partner) {
+		o.stats.partnerChange++
+		o.selected = false
+		o.runMux()
+	}
+	o.partner = *actor
+	// the partner is in sync only in case it has the right information of this port
+	if !partner.sameLink(&o.actor) && actor.State&LACP_STATE_AGGREGATION != 0 {
+		o.partner.State &^= LACP_STATE_SYNC
+	}
+	o.actor.State &^= LACP_STATE_EXPIRED | LACP_STATE_DEFAULTED
+	o.rxState = LACP_RX_CURRENT
+	o.restartTimer(&o.currentWhile, o.currentWhileSec())
+	o.selected = true
+	o.runMux()
+	o.updatePeriodic()
+	o.txLacpdu()
+}
+
+func (o *PluginLacpNs) setActorMuxState(sync, collecting, distributing bool) {
+	s := o.actor.State &^ (LACP_STATE_SYNC | LACP_STATE_COLLECTING | LACP_STATE_DISTRIBUTING)
+	if sync {
+		s |= LACP_STATE_SYNC
+	}
+	if collecting {
+		s |= LACP_STATE_COLLECTING
+	}
+	if distributing {
+		s |= LACP_STATE_DISTRIBUTING
+	}
+	if o.muxState == LACP_MUX_DISTRIBUTING && !distributing {
+		o.stats.muxOutOfDistrib++
+	}
+	o.actor.State = s
+	o.ntt = true
+}
+
+func (o *PluginLacpNs) setMuxState(state uint8) {
+	switch state {
+	case LACP_MUX_DETACHED:
+		o.setActorMuxState(false, false, false)
+		if o.waitWhile.IsRunning() {
+			o.timerw.Stop(&o.waitWhile)
+		}
+	case LACP_MUX_WAITING:
+		o.ready = false
+		o.restartTimer(&o.waitWhile, LACP_AGGREGATE_WAIT_SEC)
+	case LACP_MUX_ATTACHED:
+		o.setActorMuxState(true, false, false)
+	case LACP_MUX_COLLECTING:
+		o.setActorMuxState(true, true, false)
+	case LACP_MUX_DISTRIBUTING:
+		o.setActorMuxState(true, true, true)
+		o.stats.muxDistributing++
+	}
+	o.muxState = state
+}
+
+// runMux runs the mux machine (independent control) until it is stable
+func (o *PluginLacpNs) runMux() {
+	for {
+		partnerSync := o.partner.State&LACP_STATE_SYNC != 0
+		partnerCollecting := o.partner.State&LACP_STATE_COLLECTING != 0
+		next := o.muxState
+		switch o.muxState {
+		case LACP_MUX_DETACHED:
+			if o.selected {
+				next = LACP_MUX_WAITING
+			}
+		case LACP_MUX_WAITING:
+			if !o.selected {
+				next = LACP_MUX_DETACHED
+			} else if o.ready {
+				next = LACP_MUX_ATTACHED
+			}
+		case LACP_MUX_ATTACHED:
+			if !o.selected {
+				next = LACP_MUX_DETACHED
+			} else if partnerSync {
+				next = LACP_MUX_COLLECTING
+			}
+		case LACP_MUX_COLLECTING:
+			if !o.selected || !partnerSync {
+				next = LACP_MUX_ATTACHED
+			} else if partnerCollecting {
+				next = LACP_MUX_DISTRIBUTING
+			}
+		case LACP_MUX_DISTRIBUTING:
+			if !o.selected || !partnerSync || !partnerCollecting {
+				next = LACP_MUX_COLLECTING
+			}
+		}
+		if next == o.muxState {
+			return
+		}
+		o.setMuxState(next)
+	}
+}
+
+func (o *PluginLacpNs) getClient() *core.CClient {
+	c := o.Ns.CLookupByMac(&o.init.Mac)
+	if c == nil {
+		o.stats.errNoClient++
+	}
+	return c
+}
+
+// txLacpdu sends a LACPDU in case it is needed, nothing is sent when both sides are passive
+func (o *PluginLacpNs) txLacpdu() {
+	if !o.ntt || (o.actor.State|o.partner.State)&LACP_STATE_ACTIVITY == 0 {
+		return
+	}
+	o.ntt = false
+	c := o.getClient()
+	if c == nil {
+		return
+	}
+	pkt := c.GetL2Header(false, SLOW_PROTOCOLS_ETHER_TYPE)
+	copy(pkt[0:6], lacpDestMAC[:])
+	l3 := len(pkt)
+	pkt = append(pkt, make([]byte, LACP_PDU_SIZE)...)
+	d := pkt[l3:]
+	d[0] = SLOW_PROTOCOLS_SUBTYPE_LACP
+	d[1] = 1 // version
+	d[2] = LACP_TLV_ACTOR
+	d[3] = LACP_INFO_LEN
+	o.actor.encode(d[4:])
+	d[22] = LACP_TLV_PARTNER
+	d[23] = LACP_INFO_LEN
+	o.partner.encode(d[24:])
+	d[42] = LACP_TLV_COLL
+	d[43] = LACP_COLL_LEN
+	// max delay, terminator and reserved are zero
+	o.stats.pktTx++
+	o.Tctx.Veth.SendBuffer(false, c, pkt)
+}
+
+// onRxMarker answers a Marker PDU, the information is returned as is
+func (o *PluginLacpNs) onRxMarker(d []byte) int {
+	o.stats.pktRxMarker++
+	if d[2] != MARKER_TLV_INFO || d[3] != MARKER_INFO_LEN {
+		if d[2] != MARKER_TLV_RESP {
+			o.stats.errPdu++
+		}
+		return core.PARSER_ERR
+	}
+	c := o.getClient()
+	if c == nil {
+		return core.PARSER_ERR
+	}
+	pkt := c.GetL2Header(false, SLOW_PROTOCOLS_ETHER_TYPE)
+	copy(pkt[0:6], lacpDestMAC[:])
+	l3 := len(pkt)
+	pkt = append(pkt, d[:MARKER_PDU_SIZE]...)
+	pkt[l3+2] = MARKER_TLV_RESP
+	o.stats.pktTxMarker++
+	o.Tctx.Veth.SendBuffer(false, c, pkt)
+	return core.PARSER_OK
+}
+
+func (o *PluginLacpNs) HandleRxLacpPacket(ps *core.ParserPacketState) int {
+	if !o.enable {
+		return core.PARSER_ERR
+	}
+	p := ps.M.GetData()
+	if ps.M.PktLen() < uint32(ps.L3)+LACP_PDU_SIZE {
+		o.stats.errPdu++
+		return core.PARSER_ERR
+	}
+	d := p[ps.L3:]
+	switch d[0] {
+	case SLOW_PROTOCOLS_SUBTYPE_LACP:
+		if d[1] < 1 || d[2] != LACP_TLV_ACTOR || d[3] != LACP_INFO_LEN || d[22] != LACP_TLV_PARTNER || d[23] != LACP_INFO_LEN {
+			o.stats.errPdu++
+			return core.PARSER_ERR
+		}
+		var actor, partner LacpPortInfo
+		actor.decode(d[4:22])
+		partner.decode(d[24:42])
+		o.onRxLacpdu(&actor, &partner)
+		return core.PARSER_OK
+	case SLOW_PROTOCOLS_SUBTYPE_MARKER:
+		return o.onRxMarker(d)
+	}
+	o.stats.pktRxUnsupported++
+	return core.PARSER_ERR
+}
+
+func (o *PluginLacpNs) lagId() string {
+	if o.rxState == LACP_RX_DEFAULTED {
+		return ""
+	}
+	individual := (o.actor.State&o.partner.State)&LACP_STATE_AGGREGATION == 0
+	a := o.actor.lagIdPart(individual)
+	p := o.partner.lagIdPart(individual)
+	// the system with the lower id is first
+	if o.partner.SysPrio < o.actor.SysPrio || (o.partner.SysPrio == o.actor.SysPrio && bytes.Compare(o.partner.Sys[:], o.actor.Sys[:]) < 0) {
+		a, p = p, a
+	}
+	return "[" + a + "," + p + "]"
+}
+
+func (o *PluginLacpNs) getInfo() *LacpInfo {
+	return &LacpInfo{Actor: newLacpPortInfoRec(&o.actor),
+		Partner:  newLacpPortInfoRec(&o.partner),
+		RxState:  lacpRxStateNames[o.rxState],
+		MuxState: lacpMuxStateNames[o.muxState],
+		Selected: o.selected,
+		LagId:    o.lagId()}
+}
+
+// HandleRxLacpPacket Parser call this function with mbuf from the pool
+func HandleRxLacpPacket(ps *core.ParserPacketState) int {
+	ns := ps.Tctx.GetNs(ps.Tun)
+	if ns == nil {
+		return core.PARSER_ERR
+	}
+	nsplg := ns.PluginCtx.Get(LACP_PLUG)
+	if nsplg == nil {
+		return core.PARSER_ERR
+	}
+	lacpPlug := nsplg.Ext.(*PluginLacpNs)
+	return lacpPlug.HandleRxLacpPacket(ps)
+}
+
+type PluginLacpNsReg struct{}
+
+func (o PluginLacpNsReg) NewPlugin(ctx *core.PluginCtx, initJson []byte) *core.PluginBase {
+	return NewLacpNs(ctx, initJson)
+}
+
+/*******************************************/
+/*  RPC commands */
+type (
+	ApiLacpNsCntHandler  struct{}
+	ApiLacpNsInfoHandler struct{}
+)
+
+func getNs(ctx interface{}, params *fastjson.RawMessage) (*PluginLacpNs, *jsonrpc.Error) {
+	tctx := ctx.(*core.CThreadCtx)
+	plug, err := tctx.GetNsPlugin(params, LACP_PLUG)
+
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+	return plug.Ext.(*PluginLacpNs), nil
+}
+
+func (h ApiLacpNsCntHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	var p core.ApiCntParams
+	tctx := ctx.(*core.CThreadCtx)
+	lacpNs, err := getNs(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	return lacpNs.cdbv.GeneralCounters(err, tctx, params, &p)
+}
+
+func (h ApiLacpNsInfoHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	lacpNs, err := getNs(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	if !lacpNs.enable {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: "lacp is not enabled, invalid init json",
+		}
+	}
+	return lacpNs.getInfo(), nil
+}
+
+func init() {
+
+	/* register of plugins callbacks for ns,c level  */
+	core.PluginRegister(LACP_PLUG,
+		core.PluginRegisterData{Client: nil,
+			Ns:     PluginLacpNsReg{},
+			Thread: nil}) /* no need for thread context for now */
+
+	core.RegisterCB("lacp_ns_cnt", ApiLacpNsCntHandler{}, false)   // get counters/meta
+	core.RegisterCB("lacp_ns_info", ApiLacpNsInfoHandler{}, false) // actor/partner information and the state of the port
+
+	/* register callback for rx side*/
+	core.ParserRegister(LACP_PLUG, HandleRxLacpPacket,
+		core.ParserRegisterData{EtherTypes: []uint16{SLOW_PROTOCOLS_ETHER_TYPE}})
+}
+
+func Register(ctx *core.CThreadCtx) {
+	ctx.RegisterParserCb(LACP_PLUG)
+}
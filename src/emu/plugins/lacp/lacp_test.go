@@ -0,0 +1,164 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package lacp
+
+import (
+	"emu/core"
+	"encoding/binary"
+	"fmt"
+	"testing"
+	"time"
+)
+
+const lacpTestL3 = 22 // two vlan tags
+
+var lacpDutSys = core.MACKey{0, 0, 0, 0xaa, 0, 1}
+
+// VethLacpDutSim answers the LACPDUs as an active DUT with fast timeout that is always in sync
+type VethLacpDutSim struct {
+	stopped    bool
+	markerResp int
+}
+
+func (o *VethLacpDutSim) ProcessTxToRx(m *core.Mbuf) *core.Mbuf {
+	p := m.GetData()
+	if binary.BigEndian.Uint16(p[lacpTestL3-2:lacpTestL3]) != SLOW_PROTOCOLS_ETHER_TYPE {
+		m.FreeMbuf()
+		return nil
+	}
+	d := p[lacpTestL3:]
+	if d[0] == SLOW_PROTOCOLS_SUBTYPE_MARKER {
+		if d[2] == MARKER_TLV_RESP && binary.BigEndian.Uint32(d[12:16]) == 0x1234 {
+			o.markerResp++
+		}
+		m.FreeMbuf()
+		return nil
+	}
+	if o.stopped {
+		m.FreeMbuf()
+		return nil
+	}
+	var emu LacpPortInfo
+	emu.decode(d[4:])
+	dut := LacpPortInfo{SysPrio: LACP_DEF_PRIO, Sys: lacpDutSys, Key: 100, PortPrio: LACP_DEF_PRIO, Port: m.VPort() + 10,
+		State: LACP_STATE_ACTIVITY | LACP_STATE_TIMEOUT | LACP_STATE_AGGREGATION | LACP_STATE_SYNC}
+	if emu.State&LACP_STATE_SYNC != 0 {
+		dut.State |= LACP_STATE_COLLECTING | LACP_STATE_DISTRIBUTING
+	}
+	copy(p[6:12], lacpDutSys[:])
+	dut.encode(d[4:])
+	emu.encode(d[24:])
+	return m
+}
+
+func genMbuf(tctx *core.CThreadCtx, vport uint16, pkt []byte) *core.Mbuf {
+	m := tctx.MPool.Alloc(uint16(len(pkt)))
+	m.SetVPort(vport)
+	m.Append(pkt)
+	return m
+}
+
+func createLacpNs(tctx *core.CThreadCtx, vport uint16, initJson string) *PluginLacpNs {
+	var key core.CTunnelKey
+	key.Set(&core.CTunnelData{Vport: vport, Vlans: [2]uint32{0x81000001, 0x81000002}})
+	ns := core.NewNSCtx(tctx, &key)
+	tctx.AddNs(&key, ns)
+	c := core.NewClient(ns, core.MACKey{0, 0, 1, 0, 0, byte(vport)}, core.Ipv4Key{}, core.Ipv6Key{}, core.Ipv4Key{})
+	ns.AddClient(c)
+	ns.PluginCtx.CreatePlugins([]string{"lacp"}, [][]byte{[]byte(initJson)})
+	return ns.PluginCtx.Get(LACP_PLUG).Ext.(*PluginLacpNs)
+}
+
+func checkLacpState(t *testing.T, o *PluginLacpNs, rx, mux string) *LacpInfo {
+	info := o.getInfo()
+	if info.RxState != rx || info.MuxState != mux {
+		t.Fatalf(" port %d expected %s/%s, got %+v %+v", o.actor.Port, rx, mux, *info, o.stats)
+	}
+	return info
+}
+
+func TestLacpAggregation(t *testing.T) {
+	sim := &VethLacpDutSim{}
+	var simrx core.VethIFSim = sim
+	tctx := core.NewThreadCtx(0, 4510, true, &simrx)
+	defer tctx.Delete()
+	Register(tctx)
+
+	ports := []*PluginLacpNs{}
+	for vport := uint16(1); vport <= 2; vport++ {
+		ports = append(ports, createLacpNs(tctx, vport,
+			fmt.Sprintf(`{"mac": [0, 0, 1, 0, 0, %d], "sys_id": [0, 0, 1, 0, 0, 255], "key": 10, "fast": true}`, vport)))
+	}
+	tctx.MainLoopSim(5 * time.Second)
+	for i, o := range ports {
+		info := checkLacpState(t, o, "current", "distributing")
+		if !info.Selected || !info.Actor.Sync || !info.Actor.Distributing || !info.Partner.Distributing ||
+			info.Partner.Sys != lacpDutSys || info.Partner.Port != uint16(i+11) || info.Actor.Port != uint16(i+1) {
+			t.Fatalf(" unexpected port info %+v ", *info)
+		}
+		if info.LagId != "[(8000,00:00:00:aa:00:01,0064,0000,0000),(8000,00:00:01:00:00:ff,000A,0000,0000)]" {
+			t.Fatalf(" unexpected lag id %s ", info.LagId)
+		}
+	}
+
+	// marker
+	pkt := make([]byte, lacpTestL3+MARKER_PDU_SIZE)
+	copy(pkt, lacpDestMAC[:])
+	copy(pkt[6:], lacpDutSys[:])
+	binary.BigEndian.PutUint32(pkt[12:], 0x81000001)
+	binary.BigEndian.PutUint32(pkt[16:], 0x81000002)
+	binary.BigEndian.PutUint16(pkt[20:], SLOW_PROTOCOLS_ETHER_TYPE)
+	copy(pkt[lacpTestL3:], []byte{SLOW_PROTOCOLS_SUBTYPE_MARKER, 1, MARKER_TLV_INFO, MARKER_INFO_LEN, 0, 11})
+	binary.BigEndian.PutUint32(pkt[lacpTestL3+12:], 0x1234)
+	tctx.HandleRxPacket(genMbuf(tctx, 1, pkt))
+	tctx.MainLoopSim(time.Second)
+	if sim.markerResp != 1 || ports[0].stats.pktTxMarker != 1 {
+		t.Fatalf(" expected a marker response %+v ", ports[0].stats)
+	}
+
+	// the DUT stops, the partner information expires after 3 sec and then defaulted
+	sim.stopped = true
+	tctx.MainLoopSim(4 * time.Second)
+	checkLacpState(t, ports[0], "expired", "attached")
+	tctx.MainLoopSim(3 * time.Second)
+	for _, o := range ports {
+		info := checkLacpState(t, o, "defaulted", "detached")
+		if info.Selected || info.Actor.Sync || !info.Actor.Defaulted || info.LagId != "" ||
+			o.stats.muxOutOfDistrib != 1 || o.stats.rxDefaulted != 1 {
+			t.Fatalf(" unexpected port info %+v %+v ", *info, o.stats)
+		}
+	}
+
+	// the DUT is back, the next slow periodic LACPDU brings the port back to distributing
+	sim.stopped = false
+	tctx.MainLoopSim(35 * time.Second)
+	for _, o := range ports {
+		checkLacpState(t, o, "current", "distributing")
+		if o.stats.muxDistributing != 2 || o.stats.errNoClient != 0 || o.stats.errPdu != 0 {
+			t.Fatalf(" unexpected counters %+v ", o.stats)
+		}
+	}
+}
+
+func TestLacpPassive(t *testing.T) {
+	sim := &VethLacpDutSim{stopped: true}
+	var simrx core.VethIFSim = sim
+	tctx := core.NewThreadCtx(0, 4510, true, &simrx)
+	defer tctx.Delete()
+	Register(tctx)
+
+	o := createLacpNs(tctx, 1, `{"mac": [0, 0, 1, 0, 0, 1], "passive": true}`)
+	bad := createLacpNs(tctx, 2, `{"sys_prio": 1}`)
+	tctx.MainLoopSim(60 * time.Second)
+	checkLacpState(t, o, "defaulted", "detached")
+	// the default partner is active, a passive port sends slow periodic LACPDUs, the first at 33 sec
+	if o.stats.pktTx != 1 || o.actor.Sys != (core.MACKey{0, 0, 1, 0, 0, 1}) || o.actor.Port != 1 {
+		t.Fatalf(" unexpected passive port %+v %+v ", o.actor, o.stats)
+	}
+	if bad.enable || bad.stats.errInitJson != 1 || bad.stats.pktTx != 0 {
+		t.Fatalf(" invalid init json should disable the port %+v ", bad.stats)
+	}
+}
@@ -7,10 +7,12 @@ package lldp
 
 import (
 	"emu/core"
+	"encoding/binary"
 	"encoding/hex"
 	"encoding/json"
 	"flag"
 	"os"
+	"strings"
 	"testing"
 	"time"
 )
@@ -162,6 +164,155 @@ func TestPluginLldp2(t *testing.T) {
 	a.Run(t)
 }
 
+func lldpTlv(t uint8, v ...byte) []byte {
+	b := make([]byte, 2)
+	binary.BigEndian.PutUint16(b, uint16(t)<<9|uint16(len(v)))
+	return append(b, v...)
+}
+
+// lldpFrame returns an LLDPDU of the switch port on the vlans of the namespace
+func lldpFrame(port string, ttl uint16, tlvs ...[]byte) []byte {
+	pkt := []byte{0x01, 0x80, 0xc2, 0x00, 0x00, 0x0e, 0, 0, 2, 0, 0, 1,
+		0x81, 0x00, 0x00, 0x01, 0x81, 0x00, 0x00, 0x02, 0x88, 0xcc}
+	pkt = append(pkt, lldpTlv(1, 4, 0, 0, 2, 0, 0, 1)...)
+	pkt = append(pkt, lldpTlv(2, append([]byte{5}, []byte(port)...)...)...)
+	pkt = append(pkt, lldpTlv(3, byte(ttl>>8), byte(ttl))...)
+	for _, t := range tlvs {
+		pkt = append(pkt, t...)
+	}
+	return append(pkt, 0, 0)
+}
+
+func TestLldpNeighbors(t *testing.T) {
+	var simrx core.VethIFSim = &VethIgmpSim{}
+	tctx := core.NewThreadCtx(0, 4510, true, &simrx)
+	defer tctx.Delete()
+	var key core.CTunnelKey
+	key.Set(&core.CTunnelData{Vport: 1, Vlans: [2]uint32{0x81000001, 0x81000002}})
+	ns := core.NewNSCtx(tctx, &key)
+	tctx.AddNs(&key, ns)
+	ns.PluginCtx.CreatePlugins([]string{"lldp"}, [][]byte{})
+	Register(tctx)
+	lldpNs := ns.PluginCtx.Get(LLDP_PLUG).Ext.(*PluginLldpNs)
+
+	tctx.HandleRxPacket(genMbuf(tctx, lldpFrame("Gi1/0/1", 120,
+		lldpTlv(5, []byte("sw1")...),
+		lldpTlv(7, 0, 0x14, 0, 0x04),
+		lldpTlv(8, 5, 1, 16, 0, 0, 9, 2, 0, 0, 0, 1, 0),
+		lldpTlv(127, 0x00, 0x80, 0xc2, 1, 0, 10),
+		lldpTlv(127, 0x00, 0x12, 0xbb, 1, 0, 0x33, 4),
+		lldpTlv(127, 0x00, 0x12, 0xbb, 2, 1, 0x40, 0xc9, 0x6e), // voice, vlan 100, prio 5, dscp 46
+		lldpTlv(127, 0x00, 0x12, 0xbb, 2, 2, 0x40, 0xc8, 0xd8), // voice signaling, vlan 100, prio 3, dscp 24
+		lldpTlv(127, append([]byte{0x00, 0x12, 0xbb, 10}, []byte("phone")...)...))))
+	tctx.HandleRxPacket(genMbuf(tctx, lldpFrame("Gi1/0/2", 5)))
+
+	if lldpNs.IterReset() {
+		t.Fatalf(" neighbor table should not be empty %+v ", lldpNs.nstats)
+	}
+	vec, _ := lldpNs.GetNext(10)
+	if len(vec) != 2 {
+		t.Fatalf(" expected two neighbors %+v ", vec)
+	}
+	n := vec[0]
+	if n.ChassisId != "00:00:02:00:00:01" || n.PortId != "Gi1/0/1" || n.Ttl != 120 || n.Remaining != 120 ||
+		n.SysName != "sw1" || n.SysCap != 0x14 || n.EnabledCap != 4 || n.MgmtAddr != "16.0.0.9" || n.PortVlan != 10 ||
+		n.SrcMac != (core.MACKey{0, 0, 2, 0, 0, 1}) {
+		t.Fatalf(" neighbor is not as expected %+v ", n)
+	}
+	med := n.Med
+	if med == nil || med.Caps != 0x33 || med.Class != 4 || med.Model != "phone" || len(med.NetworkPolicy) != 2 {
+		t.Fatalf(" lldp-med is not as expected %+v ", med)
+	}
+	exp := []LldpNetworkPolicyRec{{App: 1, Tagged: true, Vlan: 100, Prio: 5, Dscp: 46}, {App: 2, Tagged: true, Vlan: 100, Prio: 3, Dscp: 24}}
+	for i, p := range med.NetworkPolicy {
+		if p != exp[i] {
+			t.Fatalf(" network policy is not as expected %+v ", p)
+		}
+	}
+
+	// shutdown, aging and malformed LLDPDUs
+	tctx.HandleRxPacket(genMbuf(tctx, lldpFrame("Gi1/0/1", 0)))
+	pkt := lldpFrame("Gi1/0/3", 120)
+	tctx.HandleRxPacket(genMbuf(tctx, pkt[:len(pkt)-6]))
+	tctx.MainLoopSim(10 * time.Second)
+	st := &lldpNs.nstats
+	if len(lldpNs.neighbors) != 0 || st.neighborAged != 1 || st.pktRxShutdown != 1 || st.errDecode != 1 ||
+		st.neighborActive != 0 || st.pktRx != 4 {
+		t.Fatalf(" unexpected counters %+v ", *st)
+	}
+}
+
+func createLldpClient(tctx *core.CThreadCtx, id byte, initJson string) *PluginLldpClient {
+	var key core.CTunnelKey
+	key.Set(&core.CTunnelData{Vport: 1, Vlans: [2]uint32{0x81000001, 0x81000002}})
+	ns := tctx.GetNs(&key)
+	if ns == nil {
+		ns = core.NewNSCtx(tctx, &key)
+		tctx.AddNs(&key, ns)
+	}
+	c := core.NewClient(ns, core.MACKey{0, 0, 1, 0, 0, id}, core.Ipv4Key{}, core.Ipv6Key{}, core.Ipv4Key{})
+	ns.AddClient(c)
+	c.PluginCtx.CreatePlugins([]string{"lldp"}, [][]byte{[]byte(initJson)})
+	return c.PluginCtx.Get(LLDP_PLUG).Ext.(*PluginLldpClient)
+}
+
+func TestLldpTlvBuilder(t *testing.T) {
+	var simrx core.VethIFSim = &VethIgmpSim{}
+	tctx := core.NewThreadCtx(0, 4510, true, &simrx)
+	defer tctx.Delete()
+
+	c := createLldpClient(tctx, 1, `{"options": {"sys_name": "phone1", "sys_desc": "ip phone", "port_desc": "port 1",
+		"caps": {"system": ["bridge", "telephone"], "enabled": ["telephone"]},
+		"mgmt_addr": {"ipv4": [16, 0, 0, 9], "if_number": 1},
+		"port_vlan": 10,
+		"med": {"class": 3,
+			"network_policy": [{"app": 1, "tagged": true, "vlan": 100, "prio": 5, "dscp": 46}, {"app": 2, "tagged": true, "vlan": 100, "prio": 3, "dscp": 24}],
+			"poe": {"type": "pd", "source": 1, "prio": 2, "power": 65},
+			"inventory": {"hw_rev": "1.0", "model": "phone", "manufacturer": "trex"}}}}`)
+	if c.initErr != "" || c.stats.pktTx != 1 {
+		t.Fatalf(" init json should be valid %s ", c.initErr)
+	}
+	n, err := decodeLldpdu(c.pktTemplate[c.l3Offset:])
+	if err != nil {
+		t.Fatalf(" lldpdu is not valid %v ", err)
+	}
+	if n.SysName != "phone1" || n.SysDesc != "ip phone" || n.PortDesc != "port 1" || n.SysCap != 0x24 || n.EnabledCap != 0x20 ||
+		n.MgmtAddr != "16.0.0.9" || n.PortVlan != 10 || n.ChassisId != "00:00:01:00:00:01" || n.Ttl != 120 {
+		t.Fatalf(" neighbor is not as expected %+v ", n)
+	}
+	med := n.Med
+	exp := []LldpNetworkPolicyRec{{App: 1, Tagged: true, Vlan: 100, Prio: 5, Dscp: 46}, {App: 2, Tagged: true, Vlan: 100, Prio: 3, Dscp: 24}}
+	if med == nil || med.Caps != 0x33 || med.Class != 3 || len(med.NetworkPolicy) != 2 || med.NetworkPolicy[0] != exp[0] ||
+		med.NetworkPolicy[1] != exp[1] || med.PowerType != 1 || med.PowerPrio != 2 || med.Power != 65 ||
+		med.HwRev != "1.0" || med.Model != "phone" || med.Manufacturer != "trex" {
+		t.Fatalf(" lldp-med is not as expected %+v ", med)
+	}
+
+	invalid := []struct {
+		json string
+		err  string
+	}{
+		{`{"options": {"caps": {"system": ["bridge", "phone"]}}}`, "unknown capability 'phone'"},
+		{`{"options": {"caps": {"system": ["bridge"], "enabled": ["router"]}}}`, "subset"},
+		{`{"options": {"mgmt_addr": {"if_number": 1}}}`, "mgmt_addr"},
+		{`{"options": {"port_vlan": 4095}}`, "port_vlan"},
+		{`{"options": {"sys_name": "` + strings.Repeat("a", 256) + `"}}`, "sys_name is longer"},
+		{`{"options": {"med": {"class": 5}}}`, "med.class 5"},
+		{`{"options": {"med": {"class": 1, "network_policy": [{"app": 1}, {"app": 1, "vlan": 5000}]}}}`, "med.network_policy[1].vlan"},
+		{`{"options": {"med": {"class": 1, "network_policy": [{"app": 9}]}}}`, "med.network_policy[0].app"},
+		{`{"options": {"med": {"class": 1, "poe": {"type": "pd", "power": 2000}}}}`, "med.poe.power"},
+		{`{"options": {"med": {"class": 1, "poe": {"type": "pxe"}}}}`, "med.poe.type"},
+		{`{"options": {"med": {"class": 1, "poe": {"type": "pd", "prio": 4}}}}`, "med.poe.prio"},
+		{`{"options": {"med": {"class": 1, "inventory": {"serial": "` + strings.Repeat("1", 33) + `"}}}}`, "med.inventory.serial"},
+	}
+	for i, v := range invalid {
+		c = createLldpClient(tctx, byte(i+2), v.json)
+		if !strings.Contains(c.initErr, v.err) || c.stats.errInitJson != 1 || c.stats.pktTx != 0 || c.timer.IsRunning() {
+			t.Fatalf(" init json %s should fail with %s, got '%s' ", v.json, v.err, c.initErr)
+		}
+	}
+}
+
 func init() {
 	flag.IntVar(&monitor, "monitor", 0, "monitor")
 }
@@ -8,6 +8,8 @@ package lldp
 /*
 lldp client send every 30 sec information from initJson
 
+the namespace plugin keeps the neighbors that were learned from the received LLDPDUs, see neighbor.go
+
 */
 
 import (
@@ -27,8 +29,15 @@ const (
 var lldpDefaultDestMAC = []byte{0x01, 0x80, 0xc2, 0x00, 0x00, 0x0e}
 
 type LldpOptionsT struct {
-	Raw           *[]byte `json:"raw"`            // raw options to add
-	RemoveDefault bool    `json:"remove_default"` // remove the default 		ChassisID/PortID/TTL
+	Raw           *[]byte        `json:"raw"`            // raw options to add
+	RemoveDefault bool           `json:"remove_default"` // remove the default 		ChassisID/PortID/TTL
+	PortDesc      string         `json:"port_desc"`
+	SysName       string         `json:"sys_name"`
+	SysDesc       string         `json:"sys_desc"`
+	Caps          *LldpCapsT     `json:"caps"`
+	MgmtAddr      *LldpMgmtAddrT `json:"mgmt_addr"`
+	PortVlan      *uint16        `json:"port_vlan"` // 802.1 port VLAN ID
+	Med           *LldpMedT      `json:"med"`       // LLDP-MED TLVs, see tlv.go
 }
 
 type LldpInit struct {
@@ -37,7 +46,8 @@ type LldpInit struct {
 }
 
 type LldpStats struct {
-	pktTx uint64
+	pktTx       uint64
+	errInitJson uint64
 }
 
 func NewLldpStatsDb(o *LldpStats) *core.CCounterDb {
@@ -51,6 +61,96 @@ func NewLldpStatsDb(o *LldpStats) *core.CCounterDb {
 		DumpZero: false,
 		Info:     core.ScINFO})
 
+	db.Add(&core.CCounterRec{
+		Counter:  &o.errInitJson,
+		Name:     "errInitJson",
+		Help:     "invalid init json, lldp is not sent",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	return db
+}
+
+type LldpNsStats struct {
+	pktRx          uint64
+	pktRxShutdown  uint64
+	errDecode      uint64
+	errTableFull   uint64
+	neighborAdd    uint64
+	neighborRemove uint64
+	neighborAged   uint64
+	neighborActive uint64
+}
+
+func NewLldpNsStatsDb(o *LldpNsStats) *core.CCounterDb {
+	db := core.NewCCounterDb("lldp")
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRx,
+		Name:     "pktRx",
+		Help:     "received lldp packets",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxShutdown,
+		Name:     "pktRxShutdown",
+		Help:     "received shutdown lldp packets, ttl is zero",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.errDecode,
+		Name:     "errDecode",
+		Help:     "malformed lldp packets",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.errTableFull,
+		Name:     "errTableFull",
+		Help:     "new neighbors dropped, the table is full",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.neighborAdd,
+		Name:     "neighborAdd",
+		Help:     "neighbors added",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.neighborRemove,
+		Name:     "neighborRemove",
+		Help:     "neighbors removed",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.neighborAged,
+		Name:     "neighborAged",
+		Help:     "neighbors removed, the ttl has expired",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.neighborActive,
+		Name:     "neighborActive",
+		Help:     "active neighbors",
+		Unit:     "neighbors",
+		DumpZero: false,
+		Gauge:    true,
+		Info:     core.ScINFO})
+
 	return db
 }
 
@@ -62,7 +162,7 @@ func (o *PluginLldpClientTimer) OnEvent(a, b interface{}) {
 	pi.onTimerEvent()
 }
 
-//PluginLldpClient information per client
+// PluginLldpClient information per client
 type PluginLldpClient struct {
 	core.PluginBase
 	lldpNsPlug  *PluginLldpNs
@@ -76,6 +176,14 @@ type PluginLldpClient struct {
 	timerSec    uint32
 	l3Offset    uint16
 	pktTemplate []byte
+	initErr     string
+}
+
+// LldpClientInfo client information for the RPC
+type LldpClientInfo struct {
+	Timer   uint32 `json:"timer"`
+	PktLen  int    `json:"pkt_len"`
+	InitErr string `json:"init_error,omitempty"`
 }
 
 var lldpEvents = []string{}
@@ -84,33 +192,43 @@ var lldpEvents = []string{}
 func NewLldpClient(ctx *core.PluginCtx, initJson []byte) *core.PluginBase {
 
 	o := new(PluginLldpClient)
-	fastjson.Unmarshal(initJson, &o.init)
 
 	o.InitPluginBase(ctx, o)             /* init base object*/
 	o.RegisterEvents(ctx, lldpEvents, o) /* register events, only if exits*/
 	nsplg := o.Ns.PluginCtx.GetOrCreate(LLDP_PLUG)
 	o.lldpNsPlug = nsplg.Ext.(*PluginLldpNs)
-	o.OnCreate()
+	o.OnCreate(initJson)
 
 	return &o.PluginBase
 }
 
-func (o *PluginLldpClient) OnCreate() {
+func (o *PluginLldpClient) OnCreate(initJson []byte) {
 	o.timerw = o.Tctx.GetTimerCtx()
-	o.preparePacketTemplate()
 	o.timerSec = 30
-	if o.init.TimerSec > 0 {
-		o.timerSec = o.init.TimerSec
-	}
-
 	o.cdb = NewLldpStatsDb(&o.stats)
 	o.cdbv = core.NewCCounterDbVec("lldp")
 	o.cdbv.Add(o.cdb)
 	o.timer.SetCB(&o.timerCb, o, 0) // set the callback to OnEvent
+
+	var err error
+	if len(initJson) > 0 {
+		err = o.Tctx.UnmarshalValidate(initJson, &o.init)
+	}
+	if err == nil {
+		err = o.preparePacketTemplate()
+	}
+	if err != nil {
+		o.stats.errInitJson++
+		o.initErr = err.Error()
+		return
+	}
+	if o.init.TimerSec > 0 {
+		o.timerSec = o.init.TimerSec
+	}
 	o.SendLldp()
 }
 
-func (o *PluginLldpClient) preparePacketTemplate() {
+func (o *PluginLldpClient) preparePacketTemplate() error {
 
 	l2 := o.Client.GetL2Header(true, uint16(layers.EthernetTypeLinkLayerDiscovery))
 	copy(l2[0:6], lldpDefaultDestMAC[:])
@@ -129,13 +247,21 @@ func (o *PluginLldpClient) preparePacketTemplate() {
 		d = []byte{0, 0}
 	}
 
-	if (o.init.Options != nil) && (o.init.Options.Raw != nil) {
+	if o.init.Options != nil {
+		tlvs, err := buildTlvs(o.init.Options)
+		if err != nil {
+			return err
+		}
 		d = d[:len(d)-2]
-		d = append(d, *(o.init.Options.Raw)...)
+		d = append(d, tlvs...)
+		if o.init.Options.Raw != nil {
+			d = append(d, *(o.init.Options.Raw)...)
+		}
 		d = append(d, []byte{0, 0}...)
 	}
 
 	o.pktTemplate = append(l2, d...)
+	return nil
 }
 
 func (o *PluginLldpClient) SendLldp() {
@@ -149,6 +275,10 @@ func (o *PluginLldpClient) OnEvent(msg string, a, b interface{}) {
 
 }
 
+func (o *PluginLldpClient) GetCounterDbVec() *core.CCounterDbVec {
+	return o.cdbv
+}
+
 func (o *PluginLldpClient) OnRemove(ctx *core.PluginCtx) {
 	/* force removing the link to the client */
 	ctx.UnregisterEvents(&o.PluginBase, lldpEvents)
@@ -168,15 +298,23 @@ func (o *PluginLldpClient) restartTimer(sec uint32) {
 	o.timerw.Start(&o.timer, time.Duration(sec)*time.Second)
 }
 
-//onTimerEvent on timer event callback
+// onTimerEvent on timer event callback
 func (o *PluginLldpClient) onTimerEvent() {
 	o.SendLldp()
 }
 
-// PluginLldpNs icmp information per namespace
+// PluginLldpNs lldp information per namespace, the neighbor table
 type PluginLldpNs struct {
 	core.PluginBase
-	stats LldpStats
+	stats      LldpStats
+	nstats     LldpNsStats
+	cdb        *core.CCounterDb
+	cdbv       *core.CCounterDbVec
+	timerw     *core.TimerCtx
+	neighbors  map[lldpNeighborKey]*lldpNeighbor
+	head       core.DList
+	activeIter *core.DList
+	iterReady  bool
 }
 
 func NewLldpNs(ctx *core.PluginCtx, initJson []byte) *core.PluginBase {
@@ -184,11 +322,20 @@ func NewLldpNs(ctx *core.PluginCtx, initJson []byte) *core.PluginBase {
 	o := new(PluginLldpNs)
 	o.InitPluginBase(ctx, o)
 	o.RegisterEvents(ctx, []string{}, o)
+	o.timerw = o.Tctx.GetTimerCtx()
+	o.neighbors = make(map[lldpNeighborKey]*lldpNeighbor)
+	o.head.SetSelf()
+	o.cdb = NewLldpNsStatsDb(&o.nstats)
+	o.cdbv = core.NewCCounterDbVec("lldp")
+	o.cdbv.Add(o.cdb)
 
 	return &o.PluginBase
 }
 
 func (o *PluginLldpNs) OnRemove(ctx *core.PluginCtx) {
+	for _, n := range o.neighbors {
+		o.removeNeighbor(n)
+	}
 }
 
 func (o *PluginLldpNs) OnEvent(msg string, a, b interface{}) {
@@ -199,6 +346,20 @@ func (o *PluginLldpNs) SetTruncated() {
 
 }
 
+// HandleRxLldpPacket Parser call this function with mbuf from the pool
+func HandleRxLldpPacket(ps *core.ParserPacketState) int {
+	ns := ps.Tctx.GetNs(ps.Tun)
+	if ns == nil {
+		return core.PARSER_ERR
+	}
+	nsplg := ns.PluginCtx.Get(LLDP_PLUG)
+	if nsplg == nil {
+		return core.PARSER_ERR
+	}
+	lldpPlug := nsplg.Ext.(*PluginLldpNs)
+	return lldpPlug.HandleRxLldpPacket(ps)
+}
+
 // Tx side client get an event and decide to act !
 // let's see how it works and add some tests
 
@@ -216,7 +377,19 @@ func (o PluginLldpNsReg) NewPlugin(ctx *core.PluginCtx, initJson []byte) *core.P
 /*******************************************/
 /*  RPC commands */
 type (
-	ApiLldpClientCntHandler struct{}
+	ApiLldpClientCntHandler  struct{}
+	ApiLldpClientInfoHandler struct{}
+	ApiLldpNsCntHandler      struct{}
+	ApiLldpNsIterHandler     struct{}
+	ApiLldpNsIterParams      struct {
+		Reset bool   `json:"reset"`
+		Count uint16 `json:"count" validate:"required,gte=0,lte=255"`
+	}
+	ApiLldpNsIterResult struct {
+		Empty   bool              `json:"empty"`
+		Stopped bool              `json:"stopped"`
+		Vec     []LldpNeighborRec `json:"data"`
+	}
 )
 
 func getNs(ctx interface{}, params *fastjson.RawMessage) (*PluginLldpNs, *jsonrpc.Error) {
@@ -263,6 +436,66 @@ func (h ApiLldpClientCntHandler) ServeJSONRPC(ctx interface{}, params *fastjson.
 	return c.cdbv.GeneralCounters(err, tctx, params, &p)
 }
 
+func (h ApiLldpClientInfoHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	c, err := getClientPlugin(ctx, params)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+	return &LldpClientInfo{Timer: c.timerSec, PktLen: len(c.pktTemplate), InitErr: c.initErr}, nil
+}
+
+func (h ApiLldpNsCntHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	var p core.ApiCntParams
+	tctx := ctx.(*core.CThreadCtx)
+	nsPlug, err := getNs(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	return nsPlug.cdbv.GeneralCounters(err, tctx, params, &p)
+}
+
+func (h ApiLldpNsIterHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	var p ApiLldpNsIterParams
+	var res ApiLldpNsIterResult
+	tctx := ctx.(*core.CThreadCtx)
+
+	nsPlug, err := getNs(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	err1 := tctx.UnmarshalValidate(*params, &p)
+	if err1 != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err1.Error(),
+		}
+	}
+
+	if p.Reset {
+		res.Empty = nsPlug.IterReset()
+	}
+	if res.Empty {
+		return &res, nil
+	}
+	if nsPlug.IterIsStopped() {
+		res.Stopped = true
+		return &res, nil
+	}
+
+	vec, err2 := nsPlug.GetNext(p.Count)
+	if err2 != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err2.Error(),
+		}
+	}
+	res.Vec = vec
+	return &res, nil
+}
+
 func init() {
 
 	/* register of plugins callbacks for ns,c level  */
@@ -286,10 +519,16 @@ func init() {
 	  aa - misc
 	*/
 
-	core.RegisterCB("lldp_client_cnt", ApiLldpClientCntHandler{}, false) // get counters/meta
+	core.RegisterCB("lldp_client_cnt", ApiLldpClientCntHandler{}, false)   // get counters/meta
+	core.RegisterCB("lldp_client_info", ApiLldpClientInfoHandler{}, false) // get the init json error
+	core.RegisterCB("lldp_ns_cnt", ApiLldpNsCntHandler{}, false)           // get counters of the neighbor table
+	core.RegisterCB("lldp_ns_iter", ApiLldpNsIterHandler{}, false)         // iterate the neighbors
 
+	/* register callback for rx side*/
+	core.ParserRegister(LLDP_PLUG, HandleRxLldpPacket,
+		core.ParserRegisterData{EtherTypes: []uint16{uint16(layers.EthernetTypeLinkLayerDiscovery)}})
 }
 
 func Register(ctx *core.CThreadCtx) {
-	//pass
+	ctx.RegisterParserCb(LLDP_PLUG)
 }
@@ -0,0 +1,326 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package lldp
+
+/*
+lldp neighbor table of a namespace.
+
+The received LLDPDUs are decoded and kept by chassis ID/port ID. Each neighbor is aged by its own timer
+using the TTL of the last LLDPDU, a TTL of zero (shutdown LLDPDU) removes the neighbor.
+
+*/
+
+import (
+	"emu/core"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"external/google/gopacket"
+	"external/google/gopacket/layers"
+	"net"
+	"time"
+	"unicode"
+	"unsafe"
+)
+
+const (
+	LLDP_MAX_NEIGHBORS = 1024
+)
+
+// LldpNetworkPolicyRec LLDP-MED network policy
+type LldpNetworkPolicyRec struct {
+	App     uint8  `json:"app"` // 1 voice, 2 voice signaling ..
+	Unknown bool   `json:"unknown"`
+	Tagged  bool   `json:"tagged"`
+	Vlan    uint16 `json:"vlan"`
+	Prio    uint8  `json:"prio"`
+	Dscp    uint8  `json:"dscp"`
+}
+
+// LldpMedRec LLDP-MED information of a neighbor
+type LldpMedRec struct {
+	Caps          uint16                 `json:"caps"`
+	Class         uint8                  `json:"class"`
+	NetworkPolicy []LldpNetworkPolicyRec `json:"network_policy,omitempty"`
+	PowerType     uint8                  `json:"power_type,omitempty"`
+	PowerPrio     uint8                  `json:"power_prio,omitempty"`
+	Power         uint16                 `json:"power,omitempty"` // 0.1 watt
+	HwRev         string                 `json:"hw_rev,omitempty"`
+	FwRev         string                 `json:"fw_rev,omitempty"`
+	SwRev         string                 `json:"sw_rev,omitempty"`
+	Serial        string                 `json:"serial,omitempty"`
+	Manufacturer  string                 `json:"manufacturer,omitempty"`
+	Model         string                 `json:"model,omitempty"`
+	AssetId       string                 `json:"asset_id,omitempty"`
+}
+
+// LldpNeighborRec neighbor information for the RPC
+type LldpNeighborRec struct {
+	SrcMac        core.MACKey `json:"src_mac"`
+	ChassisIdType uint8       `json:"chassis_id_type"`
+	ChassisId     string      `json:"chassis_id"`
+	PortIdType    uint8       `json:"port_id_type"`
+	PortId        string      `json:"port_id"`
+	Ttl           uint16      `json:"ttl"`
+	Remaining     uint32      `json:"remaining"` // sec
+	PortDesc      string      `json:"port_desc,omitempty"`
+	SysName       string      `json:"sys_name,omitempty"`
+	SysDesc       string      `json:"sys_desc,omitempty"`
+	SysCap        uint16      `json:"sys_cap"`
+	EnabledCap    uint16      `json:"enabled_cap"`
+	MgmtAddr      string      `json:"mgmt_addr,omitempty"`
+	PortVlan      uint16      `json:"port_vlan,omitempty"`
+	Med           *LldpMedRec `json:"med,omitempty"`
+}
+
+type lldpNeighborKey struct {
+	chassisId string
+	portId    string
+}
+
+type lldpNeighbor struct {
+	dlist  core.DList // must be first
+	timer  core.CHTimerObj
+	key    lldpNeighborKey
+	expire uint64 // ticks
+	rec    LldpNeighborRec
+}
+
+func covertToLldpNeighbor(dlist *core.DList) *lldpNeighbor {
+	return (*lldpNeighbor)(unsafe.Pointer(dlist))
+}
+
+type lldpNeighborTimer struct{}
+
+func (t lldpNeighborTimer) OnEvent(a, b interface{}) {
+	a.(*PluginLldpNs).onNeighborTimer(b.(*lldpNeighbor))
+}
+
+// lldpIdString formats a chassis/port ID, MAC and network addresses are formatted as such
+func lldpIdString(id []byte, isMac, isAddr bool) string {
+	if isMac && len(id) == 6 {
+		return net.HardwareAddr(id).String()
+	}
+	if isAddr && len(id) > 1 {
+		if f := layers.IANAAddressFamily(id[0]); f == layers.IANAAddressFamilyIPV4 || f == layers.IANAAddressFamilyIPV6 {
+			return net.IP(id[1:]).String()
+		}
+	}
+	for _, c := range string(id) {
+		if c > unicode.MaxASCII || !unicode.IsPrint(c) {
+			return hex.EncodeToString(id)
+		}
+	}
+	return string(id)
+}
+
+// decodeLldpMed decodes the LLDP-MED TLVs of the TIA OUI
+func decodeLldpMed(tlv []layers.LLDPOrgSpecificTLV) *LldpMedRec {
+	var med *LldpMedRec
+	for _, t := range tlv {
+		if t.OUI != layers.IEEEOUIMedia {
+			continue
+		}
+		if med == nil {
+			med = new(LldpMedRec)
+		}
+		v := t.Info
+		switch layers.LLDPMediaSubtype(t.SubType) {
+		case layers.LLDPMediaTypeCapabilities:
+			if len(v) >= 3 {
+				med.Caps = binary.BigEndian.Uint16(v[0:2])
+				med.Class = v[2]
+			}
+		case layers.LLDPMediaTypeNetwork:
+			if len(v) >= 4 {
+				b := binary.BigEndian.Uint32(v[0:4])
+				med.NetworkPolicy = append(med.NetworkPolicy, LldpNetworkPolicyRec{
+					App:     v[0],
+					Unknown: b&0x800000 != 0,
+					Tagged:  b&0x400000 != 0,
+					Vlan:    uint16(b>>9) & 0xfff,
+					Prio:    uint8(b>>6) & 0x7,
+					Dscp:    uint8(b) & 0x3f})
+			}
+		case layers.LLDPMediaTypePower:
+			if len(v) >= 3 {
+				med.PowerType = v[0] >> 6
+				med.PowerPrio = v[0] & 0xf
+				med.Power = binary.BigEndian.Uint16(v[1:3])
+			}
+		case layers.LLDPMediaTypeHardware:
+			med.HwRev = string(v)
+		case layers.LLDPMediaTypeFirmware:
+			med.FwRev = string(v)
+		case layers.LLDPMediaTypeSoftware:
+			med.SwRev = string(v)
+		case layers.LLDPMediaTypeSerial:
+			med.Serial = string(v)
+		case layers.LLDPMediaTypeManufacturer:
+			med.Manufacturer = string(v)
+		case layers.LLDPMediaTypeModel:
+			med.Model = string(v)
+		case layers.LLDPMediaTypeAssetID:
+			med.AssetId = string(v)
+		}
+	}
+	return med
+}
+
+// decodeLldpdu decodes the LLDPDU p into a neighbor record
+func decodeLldpdu(p []byte) (*LldpNeighborRec, error) {
+	pkt := gopacket.NewPacket(p, layers.LayerTypeLinkLayerDiscovery, gopacket.NoCopy)
+	if e := pkt.ErrorLayer(); e != nil {
+		return nil, e.Error()
+	}
+	lldp, _ := pkt.Layer(layers.LayerTypeLinkLayerDiscovery).(*layers.LinkLayerDiscovery)
+	info, _ := pkt.Layer(layers.LayerTypeLinkLayerDiscoveryInfo).(*layers.LinkLayerDiscoveryInfo)
+	if lldp == nil || info == nil {
+		return nil, errors.New("missing mandatory LLDP TLV")
+	}
+
+	r := &LldpNeighborRec{
+		ChassisIdType: uint8(lldp.ChassisID.Subtype),
+		ChassisId: lldpIdString(lldp.ChassisID.ID, lldp.ChassisID.Subtype == layers.LLDPChassisIDSubTypeMACAddr,
+			lldp.ChassisID.Subtype == layers.LLDPChassisIDSubTypeNetworkAddr),
+		PortIdType: uint8(lldp.PortID.Subtype),
+		PortId: lldpIdString(lldp.PortID.ID, lldp.PortID.Subtype == layers.LLDPPortIDSubtypeMACAddr,
+			lldp.PortID.Subtype == layers.LLDPPortIDSubtypeNetworkAddr),
+		Ttl:      lldp.TTL,
+		PortDesc: info.PortDescription,
+		SysName:  info.SysName,
+		SysDesc:  info.SysDescription,
+	}
+	for _, v := range lldp.Values {
+		if v.Type == layers.LLDPTLVSysCapabilities && len(v.Value) >= 4 {
+			r.SysCap = binary.BigEndian.Uint16(v.Value[0:2])
+			r.EnabledCap = binary.BigEndian.Uint16(v.Value[2:4])
+		}
+	}
+	switch info.MgmtAddress.Subtype {
+	case layers.IANAAddressFamilyIPV4, layers.IANAAddressFamilyIPV6:
+		r.MgmtAddr = net.IP(info.MgmtAddress.Address).String()
+	case layers.IANAAddressFamily802:
+		r.MgmtAddr = net.HardwareAddr(info.MgmtAddress.Address).String()
+	}
+	for _, t := range info.OrgTLVs {
+		if t.OUI == layers.IEEEOUI8021 && t.SubType == layers.LLDP8021SubtypePortVLANID && len(t.Info) >= 2 {
+			r.PortVlan = binary.BigEndian.Uint16(t.Info[0:2])
+		}
+	}
+	r.Med = decodeLldpMed(info.OrgTLVs)
+	return r, nil
+}
+
+// HandleRxLldpPacket decodes the LLDPDU and updates the neighbor table
+func (o *PluginLldpNs) HandleRxLldpPacket(ps *core.ParserPacketState) int {
+	p := ps.M.GetData()
+	o.nstats.pktRx++
+	rec, err := decodeLldpdu(p[ps.L3:ps.M.PktLen()])
+	if err != nil {
+		o.nstats.errDecode++
+		return core.PARSER_ERR
+	}
+	copy(rec.SrcMac[:], p[6:12])
+	o.updateNeighbor(rec)
+	return core.PARSER_OK
+}
+
+func (o *PluginLldpNs) updateNeighbor(rec *LldpNeighborRec) {
+	key := lldpNeighborKey{chassisId: rec.ChassisId, portId: rec.PortId}
+	n, ok := o.neighbors[key]
+	if rec.Ttl == 0 {
+		o.nstats.pktRxShutdown++
+		if ok {
+			o.removeNeighbor(n)
+		}
+		return
+	}
+	if !ok {
+		if len(o.neighbors) >= LLDP_MAX_NEIGHBORS {
+			o.nstats.errTableFull++
+			return
+		}
+		n = new(lldpNeighbor)
+		n.key = key
+		n.timer.SetCB(lldpNeighborTimer{}, o, n)
+		o.neighbors[key] = n
+		o.head.AddLast(&n.dlist)
+		o.nstats.neighborAdd++
+		o.nstats.neighborActive++
+	} else if o.timerw.IsRunning(&n.timer) {
+		o.timerw.Stop(&n.timer)
+	}
+	n.rec = *rec
+	ticks := o.timerw.DurationToTicks(time.Duration(rec.Ttl) * time.Second)
+	n.expire = o.timerw.Ticks + uint64(ticks)
+	o.timerw.StartTicks(&n.timer, ticks)
+}
+
+func (o *PluginLldpNs) removeNeighbor(n *lldpNeighbor) {
+	if n.timer.IsRunning() {
+		o.timerw.Stop(&n.timer)
+	}
+	if o.activeIter == &n.dlist {
+		// it is going to be removed
+		o.activeIter = n.dlist.Next()
+	}
+	o.head.RemoveNode(&n.dlist)
+	delete(o.neighbors, n.key)
+	o.nstats.neighborRemove++
+	o.nstats.neighborActive--
+}
+
+func (o *PluginLldpNs) onNeighborTimer(n *lldpNeighbor) {
+	o.nstats.neighborAged++
+	o.removeNeighbor(n)
+}
+
+func (o *PluginLldpNs) getNeighborRec(n *lldpNeighbor) LldpNeighborRec {
+	r := n.rec
+	r.Remaining = 0
+	if n.expire > o.timerw.Ticks {
+		r.Remaining = uint32(time.Duration(n.expire-o.timerw.Ticks) * o.timerw.TickDuration / time.Second)
+	}
+	return r
+}
+
+func (o *PluginLldpNs) IterReset() bool {
+	o.activeIter = o.head.Next()
+	if o.head.IsEmpty() {
+		o.iterReady = false
+		return true
+	}
+	o.iterReady = true
+	return false
+}
+
+func (o *PluginLldpNs) IterIsStopped() bool {
+	return !o.iterReady
+}
+
+func (o *PluginLldpNs) GetNext(n uint16) ([]LldpNeighborRec, error) {
+	r := make([]LldpNeighborRec, 0)
+
+	if !o.iterReady {
+		return r, errors.New(" Iterator is not ready- reset the iterator")
+	}
+
+	cnt := 0
+	for {
+		if o.activeIter == &o.head {
+			o.iterReady = false
+			break
+		}
+		cnt++
+		if cnt > int(n) {
+			break
+		}
+		r = append(r, o.getNeighborRec(covertToLldpNeighbor(o.activeIter)))
+		o.activeIter = o.activeIter.Next()
+	}
+	return r, nil
+}
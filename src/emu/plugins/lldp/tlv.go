@@ -0,0 +1,278 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package lldp
+
+/*
+builder of the optional LLDP TLVs from the init json: system name/description, capabilities, management address,
+802.1 port VLAN and the LLDP-MED (ANSI/TIA-1057) capabilities, network policy, extended power-via-MDI and inventory.
+
+*/
+
+import (
+	"emu/core"
+	"encoding/binary"
+	"external/google/gopacket/layers"
+	"fmt"
+)
+
+const (
+	LLDP_STR_MAX_LEN     = 255
+	LLDP_MED_STR_MAX_LEN = 32
+	LLDP_MED_MAX_POWER   = 1023 // 102.3 watt
+)
+
+// lldpCapNames the bits of the system capabilities TLV
+var lldpCapNames = map[string]uint16{
+	"other":     1 << 0,
+	"repeater":  1 << 1,
+	"bridge":    1 << 2,
+	"wlan_ap":   1 << 3,
+	"router":    1 << 4,
+	"telephone": 1 << 5,
+	"docsis":    1 << 6,
+	"station":   1 << 7,
+	"cvlan":     1 << 8,
+	"svlan":     1 << 9,
+	"tpmr":      1 << 10,
+}
+
+type LldpCapsT struct {
+	System  []string `json:"system"`
+	Enabled []string `json:"enabled"`
+}
+
+type LldpMgmtAddrT struct {
+	Ipv4     *core.Ipv4Key `json:"ipv4"`
+	Ipv6     *core.Ipv6Key `json:"ipv6"`
+	IfNumber uint32        `json:"if_number"`
+}
+
+type LldpNetworkPolicyT struct {
+	App     uint8  `json:"app"` // 1 voice, 2 voice signaling, 3 guest voice ..
+	Unknown bool   `json:"unknown"`
+	Tagged  bool   `json:"tagged"`
+	Vlan    uint16 `json:"vlan"`
+	Prio    uint8  `json:"prio"`
+	Dscp    uint8  `json:"dscp"`
+}
+
+type LldpPoeT struct {
+	Type   string `json:"type"`   // pse or pd
+	Source uint8  `json:"source"` // pd: 1 pse, 2 local, 3 pse and local. pse: 1 primary, 2 backup
+	Prio   uint8  `json:"prio"`   // 1 critical, 2 high, 3 low
+	Power  uint16 `json:"power"`  // 0.1 watt
+}
+
+type LldpInventoryT struct {
+	HwRev        string `json:"hw_rev"`
+	FwRev        string `json:"fw_rev"`
+	SwRev        string `json:"sw_rev"`
+	Serial       string `json:"serial"`
+	Manufacturer string `json:"manufacturer"`
+	Model        string `json:"model"`
+	AssetId      string `json:"asset_id"`
+}
+
+type LldpMedT struct {
+	Class         uint8                `json:"class"`
+	NetworkPolicy []LldpNetworkPolicyT `json:"network_policy"`
+	Poe           *LldpPoeT            `json:"poe"`
+	Inventory     *LldpInventoryT      `json:"inventory"`
+}
+
+func lldpAddTlv(b []byte, t layers.LLDPTLVType, v []byte) []byte {
+	var h [2]byte
+	binary.BigEndian.PutUint16(h[:], uint16(t)<<9|uint16(len(v)))
+	b = append(b, h[:]...)
+	return append(b, v...)
+}
+
+func lldpAddOrgTlv(b []byte, oui layers.IEEEOUI, subtype uint8, v []byte) []byte {
+	d := []byte{byte(oui >> 16), byte(oui >> 8), byte(oui), subtype}
+	return lldpAddTlv(b, layers.LLDPTLVOrgSpecific, append(d, v...))
+}
+
+func lldpCapsMask(names []string, field string) (uint16, error) {
+	var m uint16
+	for _, n := range names {
+		bit, ok := lldpCapNames[n]
+		if !ok {
+			return 0, fmt.Errorf("caps.%s: unknown capability '%s'", field, n)
+		}
+		m |= bit
+	}
+	return m, nil
+}
+
+func lldpCheckStr(s string, max int, field string) error {
+	if len(s) > max {
+		return fmt.Errorf("%s is longer than %d bytes", field, max)
+	}
+	return nil
+}
+
+// lldpCheckRange returns an error in case the value of the field is not in [min, max]
+func lldpCheckRange(v, min, max int, field string) error {
+	if v < min || v > max {
+		return fmt.Errorf("%s %d is not in the range %d-%d", field, v, min, max)
+	}
+	return nil
+}
+
+// buildMed returns the LLDP-MED TLVs, the capabilities are derived from the TLVs that are given
+func buildMed(b []byte, med *LldpMedT) ([]byte, error) {
+	if err := lldpCheckRange(int(med.Class), 1, 4, "med.class"); err != nil {
+		return nil, err
+	}
+	caps := layers.LLDPMediaCapsLLDP
+	var d []byte
+	for i, p := range med.NetworkPolicy {
+		field := fmt.Sprintf("med.network_policy[%d]", i)
+		checks := []struct {
+			v, min, max int
+			name        string
+		}{
+			{int(p.App), 1, 8, "app"},
+			{int(p.Vlan), 0, 4094, "vlan"},
+			{int(p.Prio), 0, 7, "prio"},
+			{int(p.Dscp), 0, 63, "dscp"},
+		}
+		for _, c := range checks {
+			if err := lldpCheckRange(c.v, c.min, c.max, field+"."+c.name); err != nil {
+				return nil, err
+			}
+		}
+		v := uint32(p.App)<<24 | uint32(p.Vlan)<<9 | uint32(p.Prio)<<6 | uint32(p.Dscp)
+		if p.Unknown {
+			v |= 0x800000
+		}
+		if p.Tagged {
+			v |= 0x400000
+		}
+		if p.Unknown && (p.Vlan != 0 || p.Tagged) {
+			return nil, fmt.Errorf("%s: an unknown policy should not have a vlan", field)
+		}
+		var e [4]byte
+		binary.BigEndian.PutUint32(e[:], v)
+		d = lldpAddOrgTlv(d, layers.IEEEOUIMedia, uint8(layers.LLDPMediaTypeNetwork), e[:])
+		caps |= layers.LLDPMediaCapsNetwork
+	}
+	if p := med.Poe; p != nil {
+		if p.Type != "pse" && p.Type != "pd" {
+			return nil, fmt.Errorf("med.poe.type '%s' should be pse or pd", p.Type)
+		}
+		if err := lldpCheckRange(int(p.Source), 0, 3, "med.poe.source"); err != nil {
+			return nil, err
+		}
+		if err := lldpCheckRange(int(p.Prio), 0, 3, "med.poe.prio"); err != nil {
+			return nil, err
+		}
+		if p.Power > LLDP_MED_MAX_POWER {
+			return nil, fmt.Errorf("med.poe.power %d is bigger than %d (0.1 watt)", p.Power, LLDP_MED_MAX_POWER)
+		}
+		t := uint8(1) // PD device
+		caps |= layers.LLDPMediaCapsPowerPD
+		if p.Type == "pse" {
+			t = 0
+			caps = caps&^layers.LLDPMediaCapsPowerPD | layers.LLDPMediaCapsPowerPSE
+		}
+		d = lldpAddOrgTlv(d, layers.IEEEOUIMedia, uint8(layers.LLDPMediaTypePower),
+			[]byte{t<<6 | p.Source<<4 | p.Prio, byte(p.Power >> 8), byte(p.Power)})
+	}
+	if inv := med.Inventory; inv != nil {
+		vals := []struct {
+			t     layers.LLDPMediaSubtype
+			s     string
+			field string
+		}{
+			{layers.LLDPMediaTypeHardware, inv.HwRev, "hw_rev"},
+			{layers.LLDPMediaTypeFirmware, inv.FwRev, "fw_rev"},
+			{layers.LLDPMediaTypeSoftware, inv.SwRev, "sw_rev"},
+			{layers.LLDPMediaTypeSerial, inv.Serial, "serial"},
+			{layers.LLDPMediaTypeManufacturer, inv.Manufacturer, "manufacturer"},
+			{layers.LLDPMediaTypeModel, inv.Model, "model"},
+			{layers.LLDPMediaTypeAssetID, inv.AssetId, "asset_id"},
+		}
+		for _, v := range vals {
+			if err := lldpCheckStr(v.s, LLDP_MED_STR_MAX_LEN, "med.inventory."+v.field); err != nil {
+				return nil, err
+			}
+			// all the inventory TLVs are sent, an empty one is valid
+			d = lldpAddOrgTlv(d, layers.IEEEOUIMedia, uint8(v.t), []byte(v.s))
+		}
+		caps |= layers.LLDPMediaCapsInventory
+	}
+	b = lldpAddOrgTlv(b, layers.IEEEOUIMedia, uint8(layers.LLDPMediaTypeCapabilities), []byte{byte(caps >> 8), byte(caps), med.Class})
+	return append(b, d...), nil
+}
+
+// buildTlvs returns the optional TLVs of the init json, in the order of IEEE 802.1AB
+func buildTlvs(opt *LldpOptionsT) ([]byte, error) {
+	var b []byte
+	strs := []struct {
+		t     layers.LLDPTLVType
+		s     string
+		field string
+	}{
+		{layers.LLDPTLVPortDescription, opt.PortDesc, "port_desc"},
+		{layers.LLDPTLVSysName, opt.SysName, "sys_name"},
+		{layers.LLDPTLVSysDescription, opt.SysDesc, "sys_desc"},
+	}
+	for _, v := range strs {
+		if v.s == "" {
+			continue
+		}
+		if err := lldpCheckStr(v.s, LLDP_STR_MAX_LEN, v.field); err != nil {
+			return nil, err
+		}
+		b = lldpAddTlv(b, v.t, []byte(v.s))
+	}
+	if opt.Caps != nil {
+		sys, err := lldpCapsMask(opt.Caps.System, "system")
+		if err != nil {
+			return nil, err
+		}
+		en, err := lldpCapsMask(opt.Caps.Enabled, "enabled")
+		if err != nil {
+			return nil, err
+		}
+		if en&^sys != 0 {
+			return nil, fmt.Errorf("caps.enabled should be a subset of caps.system")
+		}
+		b = lldpAddTlv(b, layers.LLDPTLVSysCapabilities, []byte{byte(sys >> 8), byte(sys), byte(en >> 8), byte(en)})
+	}
+	if m := opt.MgmtAddr; m != nil {
+		var addr []byte
+		switch {
+		case m.Ipv4 != nil && m.Ipv6 != nil:
+			return nil, fmt.Errorf("mgmt_addr should have ipv4 or ipv6, not both")
+		case m.Ipv4 != nil:
+			addr = append([]byte{byte(layers.IANAAddressFamilyIPV4)}, m.Ipv4[:]...)
+		case m.Ipv6 != nil:
+			addr = append([]byte{byte(layers.IANAAddressFamilyIPV6)}, m.Ipv6[:]...)
+		default:
+			return nil, fmt.Errorf("mgmt_addr should have ipv4 or ipv6")
+		}
+		v := append([]byte{byte(len(addr))}, addr...)
+		// interface numbering is ifIndex, no OID
+		v = append(v, byte(layers.LLDPInterfaceSubtypeifIndex), 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint32(v[len(v)-5:], m.IfNumber)
+		b = lldpAddTlv(b, layers.LLDPTLVMgmtAddress, v)
+	}
+	if opt.PortVlan != nil {
+		if *opt.PortVlan == 0 || *opt.PortVlan > 4094 {
+			return nil, fmt.Errorf("port_vlan %d is not valid (1-4094)", *opt.PortVlan)
+		}
+		b = lldpAddOrgTlv(b, layers.IEEEOUI8021, layers.LLDP8021SubtypePortVLANID, []byte{byte(*opt.PortVlan >> 8), byte(*opt.PortVlan)})
+	}
+	if opt.Med != nil {
+		var err error
+		if b, err = buildMed(b, opt.Med); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
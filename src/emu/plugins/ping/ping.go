@@ -21,7 +21,7 @@ import (
 // PingClientIF is an interface that should be implemented by each Ping Client, meaning someone that
 // wants to use the ping functionality. For example, ICMPv4/v6 are natural Ping Clients as they both
 // use the ping functionality. However most of the ping code is generic, and could be used by both clients.
-// The differences would be minor, and hence a client would only need implement the following three functions
+// The differences would be minor, and hence a client would only need implement the following functions
 // to get the whole ping functionality.
 type PingClientIF interface {
 	// PreparePingPacketTemplate creates a template Ping Packet depending on the protocol, ICMPv4/ICMPv6.
@@ -31,6 +31,8 @@ type PingClientIF interface {
 	PreparePingPacketTemplate(id, seq uint16, magic uint64) (icmpHeaderOffset int, pkt []byte)
 	// UpdateTxIcmpQuery updates the counter of the ping client when sending an echo requests.
 	UpdateTxIcmpQuery(pktSend uint64)
+	// SendPing sends an Echo-Request built from the template, it is fragmented in case it is bigger than the MTU of the client.
+	SendPing(m *core.Mbuf)
 	// OnPingRemove is called when we finish pinging (after timeout) or a ping stop request is received.
 	OnPingRemove()
 }
@@ -135,6 +137,7 @@ func NewPingStatsDb(o *PingStats) *core.CCounterDb {
 		Help:     "average latency",
 		Unit:     "usec",
 		DumpZero: true,
+		Gauge:    true,
 		Info:     core.ScINFO})
 	db.Add(&core.CCounterRec{
 		Counter:  &o.minLatencyUsec,
@@ -142,6 +145,7 @@ func NewPingStatsDb(o *PingStats) *core.CCounterDb {
 		Help:     "minimal latency",
 		Unit:     "usec",
 		DumpZero: true,
+		Gauge:    true,
 		Info:     core.ScINFO})
 	db.Add(&core.CCounterRec{
 		Counter:  &o.maxLatencyUsec,
@@ -149,6 +153,7 @@ func NewPingStatsDb(o *PingStats) *core.CCounterDb {
 		Help:     "maximal latency",
 		Unit:     "usec",
 		DumpZero: true,
+		Gauge:    true,
 		Info:     core.ScINFO})
 	return db
 }
@@ -312,6 +317,12 @@ func (o *Ping) GetPingCounters(params *fastjson.RawMessage) (interface{}, *jsonr
 	return o.cdbv.GeneralCounters(nil, o.tctx, params, &p)
 }
 
+// GetCounterDbVec returns the Ping counters, updated, for the metrics of the ping client.
+func (o *Ping) GetCounterDbVec() *core.CCounterDbVec {
+	o.updateStats()
+	return o.cdbv
+}
+
 // sendPing calculates how many packets to send (in case of burts), updates counters of ICMPQueries,
 // and calls the PingClientIF.SendPing which should send the updated packets.
 func (o *Ping) sendPing() {
@@ -336,7 +347,7 @@ func (o *Ping) sendPing() {
 
 		m := o.ns.AllocMbuf(uint16(len(o.pingPkt)))
 		m.Append(o.pingPkt)
-		o.tctx.Veth.Send(m)
+		o.pingClient.SendPing(m)
 	}
 }
 
@@ -0,0 +1,1107 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package stp
+
+/*
+spanning tree (IEEE 802.1D/802.1Q) bridge port, STP, RSTP or MSTP.
+
+The namespace is the port, the BPDUs (802.3 LLC frames with DSAP 0x42) are sent from mac (a client of the
+namespace) on the vport/vlans of the namespace. The bridge ID, the advertised root bridge, the root path cost and
+the role of the port are configured, there is no election. A designated port sends BPDUs every hello time and moves
+to forwarding after 2 forward delays, or at once by the agreement of the partner (RSTP/MSTP). A root port answers a
+proposal by an agreement, alternate/backup ports are silent.
+
+Topology changes: a received TCN is acknowledged (TCA flag), the root bridge sets the TC flag. A topology change
+from the RPC is sent as TCN until acknowledged (STP), or as the TC flag for 2 hello times (RSTP/MSTP). A RSTP/MSTP
+port moves to STP in case it receives a STP BPDU.
+
+The received BPDUs are decoded, the port keeps the last one until it is aged (max age - message age or 3 hello
+times) and compares it with its own priority vector to have the view of the root bridge.
+
+init json:
+
+	{"mac": [0, 0, 1, 0, 0, 1], "ver": 2, "bridge_prio": 32768, "bridge_mac": [0, 0, 1, 0, 0, 1], "root_prio": 32768,
+	 "root_mac": [0, 0, 1, 0, 0, 1], "root_cost": 0, "port": 1, "port_prio": 128, "path_cost": 20000,
+	 "role": "designated", "hello": 2, "max_age": 20, "fwd_delay": 15,
+	 "region": "r1", "revision": 1, "msti": [{"id": 1, "prio": 32768, "vlans": [10, 11]}]}
+
+*/
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"emu/core"
+	"encoding/binary"
+	"encoding/hex"
+	"external/osamingo/jsonrpc"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/intel-go/fastjson"
+)
+
+const (
+	STP_PLUG    = "stp"
+	STP_LLC_SAP = 0x42
+
+	STP_BPDU_CONFIG = 0x00
+	STP_BPDU_RST    = 0x02
+	STP_BPDU_TCN    = 0x80
+
+	STP_TCN_SIZE    = 4
+	STP_CONFIG_SIZE = 35
+	STP_RST_SIZE    = 36
+	STP_MST_SIZE    = 102 // without the MSTI configuration messages
+	STP_MSTI_SIZE   = 16
+	STP_MST_V3_LEN  = 64 // version 3 length without the MSTI configuration messages
+	STP_REGION_LEN  = 32
+	STP_MAX_VLAN    = 4094
+
+	/* flags */
+	STP_FLAG_TC         = 0x01
+	STP_FLAG_PROPOSAL   = 0x02
+	STP_FLAG_ROLE_SHIFT = 2
+	STP_FLAG_ROLE_MASK  = 0x0c
+	STP_FLAG_LEARNING   = 0x10
+	STP_FLAG_FORWARDING = 0x20
+	STP_FLAG_AGREEMENT  = 0x40
+	STP_FLAG_TCA        = 0x80 // STP
+	STP_FLAG_MASTER     = 0x80 // MSTI
+
+	/* port role in the flags */
+	STP_ROLE_UNKNOWN    = 0
+	STP_ROLE_ALTERNATE  = 1 // alternate or backup
+	STP_ROLE_ROOT       = 2
+	STP_ROLE_DESIGNATED = 3
+
+	/* port state */
+	STP_STATE_DISCARDING = 1 // blocking for STP
+	STP_STATE_LISTENING  = 2
+	STP_STATE_LEARNING   = 3
+	STP_STATE_FORWARDING = 4
+
+	STP_DEF_PRIO      = 32768
+	STP_DEF_PORT_PRIO = 128
+	STP_DEF_PATH_COST = 20000 // 1Gbps
+	STP_DEF_HELLO     = 2
+	STP_DEF_MAX_AGE   = 20
+	STP_DEF_FWD_DELAY = 15
+	STP_DEF_MAX_HOPS  = 20
+
+	/* timers */
+	STP_TIMER_HELLO    = 1
+	STP_TIMER_INFO     = 2 // the received information
+	STP_TIMER_FWD      = 3
+	STP_TIMER_TC_WHILE = 4
+)
+
+var stpDestMAC = core.MACKey{0x01, 0x80, 0xc2, 0x00, 0x00, 0x00}
+
+// the key of the MST configuration digest
+var stpMstDigestKey = []byte{0x13, 0xac, 0x06, 0xa6, 0x2e, 0x47, 0xfd, 0x51, 0xf9, 0x5d, 0x2b, 0xa2, 0x43, 0xcd, 0x03, 0x46}
+
+var stpRoles = map[string]uint8{
+	"designated": STP_ROLE_DESIGNATED,
+	"root":       STP_ROLE_ROOT,
+	"alternate":  STP_ROLE_ALTERNATE,
+	"backup":     STP_ROLE_ALTERNATE,
+}
+
+var stpRoleNames = map[uint8]string{
+	STP_ROLE_UNKNOWN:    "unknown",
+	STP_ROLE_ALTERNATE:  "alternate",
+	STP_ROLE_ROOT:       "root",
+	STP_ROLE_DESIGNATED: "designated",
+}
+
+var stpStateNames = map[uint8]string{
+	STP_STATE_DISCARDING: "discarding",
+	STP_STATE_LISTENING:  "listening",
+	STP_STATE_LEARNING:   "learning",
+	STP_STATE_FORWARDING: "forwarding",
+}
+
+var stpBpduTypeNames = map[uint8]string{
+	STP_BPDU_CONFIG: "config",
+	STP_BPDU_RST:    "rst",
+	STP_BPDU_TCN:    "tcn",
+}
+
+type StpMstiInit struct {
+	Id       uint16       `json:"id" validate:"required,max=4094"`
+	Prio     uint16       `json:"prio"` // zero for the bridge priority
+	RootPrio *uint16      `json:"root_prio"`
+	RootMac  *core.MACKey `json:"root_mac"`  // the regional root, default is this bridge
+	RootCost uint32       `json:"root_cost"` // internal root path cost
+	PortPrio uint8        `json:"port_prio"` // zero for the port priority
+	Role     string       `json:"role" validate:"omitempty,oneof=designated root alternate backup"`
+	Vlans    []uint16     `json:"vlans"` // mapped to the MSTI, for the configuration digest
+}
+
+type StpInit struct {
+	Mac        core.MACKey   `json:"mac" validate:"required"` // the client of the port
+	Ver        uint8         `json:"ver" validate:"oneof=0 2 3"`
+	BridgePrio uint16        `json:"bridge_prio"`
+	BridgeMac  *core.MACKey  `json:"bridge_mac"` // default is mac
+	RootPrio   *uint16       `json:"root_prio"`
+	RootMac    *core.MACKey  `json:"root_mac"`                 // the advertised root bridge, default is this bridge
+	RootCost   uint32        `json:"root_cost"`                // the advertised root path cost
+	Port       uint16        `json:"port" validate:"max=4095"` // default is the vport
+	PortPrio   uint8         `json:"port_prio"`
+	PathCost   uint32        `json:"path_cost"` // the cost of the port, for the root view
+	Role       string        `json:"role" validate:"oneof=designated root alternate backup"`
+	Hello      uint8         `json:"hello" validate:"min=1,max=10"`
+	MaxAge     uint8         `json:"max_age" validate:"min=6,max=40"`
+	FwdDelay   uint8         `json:"fwd_delay" validate:"min=4,max=30"`
+	Region     string        `json:"region" validate:"max=32"` // MST configuration name
+	Revision   uint16        `json:"revision"`
+	Msti       []StpMstiInit `json:"msti" validate:"max=64,dive"`
+}
+
+// stpBridgeId priority (with the system ID extension) and MAC
+type stpBridgeId [8]byte
+
+func newStpBridgeId(prio uint16, mac core.MACKey) (b stpBridgeId) {
+	binary.BigEndian.PutUint16(b[0:2], prio)
+	copy(b[2:8], mac[:])
+	return b
+}
+
+func (o stpBridgeId) String() string {
+	return fmt.Sprintf("%04x.%s", binary.BigEndian.Uint16(o[0:2]), net.HardwareAddr(o[2:8]).String())
+}
+
+// stpVector the priority vector, the lower is better
+type stpVector struct {
+	root   stpBridgeId
+	cost   uint32
+	bridge stpBridgeId
+	port   uint16
+}
+
+func (o *stpVector) better(b *stpVector) bool {
+	if r := bytes.Compare(o.root[:], b.root[:]); r != 0 {
+		return r < 0
+	}
+	if o.cost != b.cost {
+		return o.cost < b.cost
+	}
+	if r := bytes.Compare(o.bridge[:], b.bridge[:]); r != 0 {
+		return r < 0
+	}
+	return o.port < b.port
+}
+
+// StpMstiRec MSTI configuration message for the RPC
+type StpMstiRec struct {
+	Id             uint16 `json:"id"`
+	Flags          uint8  `json:"flags"`
+	Role           string `json:"role"`
+	RegionalRootId string `json:"regional_root_id"`
+	InternalCost   uint32 `json:"internal_cost"`
+	BridgePrio     uint16 `json:"bridge_prio"`
+	PortPrio       uint8  `json:"port_prio"`
+	Hops           uint8  `json:"hops"`
+}
+
+// StpBpduRec received BPDU for the RPC, times are in sec
+type StpBpduRec struct {
+	Ver            uint8        `json:"ver"`
+	Type           string       `json:"type"`
+	Flags          uint8        `json:"flags"`
+	Tc             bool         `json:"tc"`
+	Role           string       `json:"role,omitempty"`
+	RootId         string       `json:"root_id"`
+	RootCost       uint32       `json:"root_cost"`
+	BridgeId       string       `json:"bridge_id"`
+	PortId         uint16       `json:"port_id"`
+	MsgAge         uint8        `json:"msg_age"`
+	MaxAge         uint8        `json:"max_age"`
+	Hello          uint8        `json:"hello"`
+	FwdDelay       uint8        `json:"fwd_delay"`
+	Region         string       `json:"region,omitempty"`
+	Revision       uint16       `json:"revision,omitempty"`
+	Digest         string       `json:"digest,omitempty"`
+	RegionalRootId string       `json:"regional_root_id,omitempty"`
+	InternalCost   uint32       `json:"internal_cost,omitempty"`
+	Hops           uint8        `json:"hops,omitempty"`
+	Msti           []StpMstiRec `json:"msti,omitempty"`
+}
+
+// decodeBpdu decodes the BPDU d to rec and the priority vector v, returns false in case it is malformed
+func decodeBpdu(d []byte, rec *StpBpduRec, v *stpVector) bool {
+	if len(d) < STP_TCN_SIZE || d[0] != 0 || d[1] != 0 {
+		return false
+	}
+	rec.Ver = d[2]
+	t := d[3]
+	switch {
+	case t == STP_BPDU_TCN:
+		rec.Type = stpBpduTypeNames[t]
+		return true
+	case t == STP_BPDU_CONFIG && len(d) >= STP_CONFIG_SIZE:
+	case t == STP_BPDU_RST && rec.Ver >= 2 && len(d) >= STP_RST_SIZE:
+	default:
+		return false
+	}
+	rec.Type = stpBpduTypeNames[t]
+	rec.Flags = d[4]
+	rec.Tc = d[4]&STP_FLAG_TC != 0
+	if t == STP_BPDU_RST {
+		rec.Role = stpRoleNames[(d[4]&STP_FLAG_ROLE_MASK)>>STP_FLAG_ROLE_SHIFT]
+	}
+	copy(v.root[:], d[5:13])
+	v.cost = binary.BigEndian.Uint32(d[13:17])
+	copy(v.bridge[:], d[17:25])
+	v.port = binary.BigEndian.Uint16(d[25:27])
+	rec.RootId = v.root.String()
+	rec.RootCost = v.cost
+	rec.PortId = v.port
+	rec.MsgAge = d[27]
+	rec.MaxAge = d[29]
+	rec.Hello = d[31]
+	rec.FwdDelay = d[33]
+
+	if rec.Ver >= 3 && len(d) >= STP_MST_SIZE {
+		v3len := int(binary.BigEndian.Uint16(d[36:38]))
+		n := (v3len - STP_MST_V3_LEN) / STP_MSTI_SIZE
+		if v3len < STP_MST_V3_LEN || (v3len-STP_MST_V3_LEN)%STP_MSTI_SIZE != 0 || len(d) < STP_MST_SIZE+n*STP_MSTI_SIZE {
+			return false
+		}
+		rec.Type = "mst"
+		rec.Region = string(bytes.TrimRight(d[39:39+STP_REGION_LEN], "\x00"))
+		rec.Revision = binary.BigEndian.Uint16(d[71:73])
+		rec.Digest = hex.EncodeToString(d[73:89])
+		rec.RegionalRootId = v.bridge.String()
+		rec.InternalCost = binary.BigEndian.Uint32(d[89:93])
+		copy(v.bridge[:], d[93:101])
+		rec.Hops = d[101]
+		for i := 0; i < n; i++ {
+			m := d[STP_MST_SIZE+i*STP_MSTI_SIZE:]
+			var root stpBridgeId
+			copy(root[:], m[1:9])
+			rec.Msti = append(rec.Msti, StpMstiRec{Id: binary.BigEndian.Uint16(m[1:3]) & 0xfff, Flags: m[0],
+				Role:           stpRoleNames[(m[0]&STP_FLAG_ROLE_MASK)>>STP_FLAG_ROLE_SHIFT],
+				RegionalRootId: root.String(), InternalCost: binary.BigEndian.Uint32(m[9:13]),
+				BridgePrio: uint16(m[13]&0xf0) << 8, PortPrio: m[14] & 0xf0, Hops: m[15]})
+		}
+	}
+	rec.BridgeId = v.bridge.String()
+	return true
+}
+
+// mstDigest the MST configuration digest of the VLAN to MSTI table
+func mstDigest(msti []StpMstiInit) []byte {
+	table := make([]byte, 2*(STP_MAX_VLAN+2))
+	for _, m := range msti {
+		for _, vlan := range m.Vlans {
+			binary.BigEndian.PutUint16(table[2*vlan:], m.Id)
+		}
+	}
+	mac := hmac.New(md5.New, stpMstDigestKey)
+	mac.Write(table)
+	return mac.Sum(nil)
+}
+
+// StpInfo port information for the RPC
+type StpInfo struct {
+	Ver        uint8       `json:"ver"`
+	TxVer      uint8       `json:"tx_ver"` // STP in case the partner is STP
+	BridgeId   string      `json:"bridge_id"`
+	PortId     uint16      `json:"port_id"`
+	Role       string      `json:"role"`
+	State      string      `json:"state"`
+	RootId     string      `json:"root_id"`   // the root bridge of the port view
+	RootCost   uint32      `json:"root_cost"` // the root path cost of the port view
+	RootBridge bool        `json:"root_bridge"`
+	Superior   bool        `json:"superior"` // the received information is better than the port information
+	Tc         bool        `json:"tc"`
+	TcnPending bool        `json:"tcn_pending"`
+	Digest     string      `json:"digest,omitempty"`
+	Remaining  uint32      `json:"remaining"` // sec, to the aging of the received information
+	Neighbor   *StpBpduRec `json:"neighbor,omitempty"`
+}
+
+type StpStats struct {
+	pktTx          uint64
+	pktTxTcn       uint64
+	pktTxTca       uint64
+	pktTxAgreement uint64
+	pktRx          uint64
+	pktRxTcn       uint64
+	pktRxTc        uint64
+	pktRxTca       uint64
+	pktRxProposal  uint64
+	pktRxAgreement uint64
+	pktRxLoop      uint64
+	errBpdu        uint64
+	errMsgAge      uint64
+	errNoClient    uint64
+	errInitJson    uint64
+	rxSuperior     uint64
+	infoExpired    uint64
+	stpCompat      uint64
+	toForwarding   uint64
+	tcStart        uint64
+}
+
+func NewStpStatsDb(o *StpStats) *core.CCounterDb {
+	db := core.NewCCounterDb("stp")
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTx,
+		Name:     "pktTx",
+		Help:     "bpdu sent",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTxTcn,
+		Name:     "pktTxTcn",
+		Help:     "tcn bpdu sent",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTxTca,
+		Name:     "pktTxTca",
+		Help:     "bpdu with tc acknowledgment sent",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTxAgreement,
+		Name:     "pktTxAgreement",
+		Help:     "bpdu with agreement sent",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRx,
+		Name:     "pktRx",
+		Help:     "configuration bpdu received",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxTcn,
+		Name:     "pktRxTcn",
+		Help:     "tcn bpdu received",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxTc,
+		Name:     "pktRxTc",
+		Help:     "bpdu with topology change received",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxTca,
+		Name:     "pktRxTca",
+		Help:     "bpdu with tc acknowledgment received",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxProposal,
+		Name:     "pktRxProposal",
+		Help:     "bpdu with proposal received",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxAgreement,
+		Name:     "pktRxAgreement",
+		Help:     "bpdu with agreement received",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxLoop,
+		Name:     "pktRxLoop",
+		Help:     "bpdu of this port received, loop",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.errBpdu,
+		Name:     "errBpdu",
+		Help:     "malformed bpdu",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.errMsgAge,
+		Name:     "errMsgAge",
+		Help:     "message age is not smaller than max age",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.errNoClient,
+		Name:     "errNoClient",
+		Help:     "the client of mac does not exist, can't send",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.errInitJson,
+		Name:     "errInitJson",
+		Help:     "invalid init json",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.rxSuperior,
+		Name:     "rxSuperior",
+		Help:     "received information became superior to the port information",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.infoExpired,
+		Name:     "infoExpired",
+		Help:     "received information aged",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.stpCompat,
+		Name:     "stpCompat",
+		Help:     "port moved to stp, stp bpdu received",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.toForwarding,
+		Name:     "toForwarding",
+		Help:     "port moved to forwarding",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.tcStart,
+		Name:     "tcStart",
+		Help:     "topology changes started by this port",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	return db
+}
+
+type PluginStpNsTimer struct {
+}
+
+func (o *PluginStpNsTimer) OnEvent(a, b interface{}) {
+	pi := a.(*PluginStpNs)
+	pi.onTimerEvent(b.(int))
+}
+
+// PluginStpNs spanning tree port of the namespace
+type PluginStpNs struct {
+	core.PluginBase
+	init       StpInit
+	enable     bool
+	txVer      uint8
+	role       uint8
+	state      uint8
+	vector     stpVector // advertised
+	digest     []byte
+	tcAck      bool // send TCA in the next BPDU
+	tcnPending bool
+	superior   bool
+	neighbor   *StpBpduRec
+	rxVector   stpVector
+	infoExpire uint64 // ticks
+	timerw     *core.TimerCtx
+	hello      core.CHTimerObj
+	info       core.CHTimerObj
+	fwd        core.CHTimerObj
+	tcWhile    core.CHTimerObj
+	timerCb    PluginStpNsTimer
+	stats      StpStats
+	cdb        *core.CCounterDb
+	cdbv       *core.CCounterDbVec
+}
+
+func NewStpNs(ctx *core.PluginCtx, initJson []byte) *core.PluginBase {
+	o := new(PluginStpNs)
+	o.InitPluginBase(ctx, o)
+	o.RegisterEvents(ctx, []string{}, o)
+	o.cdb = NewStpStatsDb(&o.stats)
+	o.cdbv = core.NewCCounterDbVec("stp")
+	o.cdbv.Add(o.cdb)
+	o.timerw = o.Tctx.GetTimerCtx()
+	o.hello.SetCB(&o.timerCb, o, STP_TIMER_HELLO)
+	o.info.SetCB(&o.timerCb, o, STP_TIMER_INFO)
+	o.fwd.SetCB(&o.timerCb, o, STP_TIMER_FWD)
+	o.tcWhile.SetCB(&o.timerCb, o, STP_TIMER_TC_WHILE)
+
+	o.init = StpInit{Ver: 2, BridgePrio: STP_DEF_PRIO, Port: o.Ns.GetVport(), PortPrio: STP_DEF_PORT_PRIO,
+		PathCost: STP_DEF_PATH_COST, Role: "designated", Hello: STP_DEF_HELLO, MaxAge: STP_DEF_MAX_AGE,
+		FwdDelay: STP_DEF_FWD_DELAY}
+	err := o.Tctx.UnmarshalValidate(initJson, &o.init)
+	if err == nil {
+		err = o.validate()
+	}
+	if err != nil {
+		o.stats.errInitJson++
+		return &o.PluginBase
+	}
+	o.enable = true
+	o.start()
+	return &o.PluginBase
+}
+
+func (o *PluginStpNs) validate() error {
+	i := &o.init
+	if i.MaxAge > 2*(i.FwdDelay-1) || i.MaxAge < 2*(i.Hello+1) {
+		return fmt.Errorf("max_age %d should be between 2*(hello+1) and 2*(fwd_delay-1)", i.MaxAge)
+	}
+	if len(i.Msti) > 0 && i.Ver != 3 {
+		return fmt.Errorf("msti is supported only by mstp")
+	}
+	ids := make(map[uint16]bool)
+	vlans := make(map[uint16]bool)
+	for _, m := range i.Msti {
+		if ids[m.Id] {
+			return fmt.Errorf("msti %d is duplicated", m.Id)
+		}
+		ids[m.Id] = true
+		for _, vlan := range m.Vlans {
+			if vlan == 0 || vlan > STP_MAX_VLAN || vlans[vlan] {
+				return fmt.Errorf("vlan %d of msti %d is invalid or mapped twice", vlan, m.Id)
+			}
+			vlans[vlan] = true
+		}
+	}
+	return nil
+}
+
+func (o *PluginStpNs) bridgeMac() core.MACKey {
+	if o.init.BridgeMac != nil {
+		return *o.init.BridgeMac
+	}
+	return o.init.Mac
+}
+
+func (o *PluginStpNs) portId(prio uint8) uint16 {
+	if o.txVer == 0 {
+		return uint16(prio)<<8 | o.init.Port&0xff
+	}
+	return uint16(prio&0xf0)<<8 | o.init.Port&0xfff
+}
+
+func (o *PluginStpNs) start() {
+	o.txVer = o.init.Ver
+	o.role = stpRoles[o.init.Role]
+	o.setVector()
+	if o.txVer == 3 {
+		o.digest = mstDigest(o.init.Msti)
+	}
+	switch {
+	case o.role == STP_ROLE_ALTERNATE:
+		o.state = STP_STATE_DISCARDING
+	case o.txVer == 0:
+		o.state = STP_STATE_LISTENING
+		o.restartTimer(&o.fwd, uint32(o.init.FwdDelay))
+	case o.role == STP_ROLE_DESIGNATED:
+		// proposal until the agreement of the partner
+		o.state = STP_STATE_DISCARDING
+		o.restartTimer(&o.fwd, uint32(o.init.FwdDelay))
+	default:
+		o.setState(STP_STATE_FORWARDING)
+	}
+	o.onHello()
+}
+
+func (o *PluginStpNs) setVector() {
+	bridge := newStpBridgeId(o.init.BridgePrio, o.bridgeMac())
+	o.vector = stpVector{root: bridge, cost: o.init.RootCost, bridge: bridge, port: o.portId(o.init.PortPrio)}
+	if o.init.RootPrio != nil || o.init.RootMac != nil {
+		prio, mac := o.init.BridgePrio, o.bridgeMac()
+		if o.init.RootPrio != nil {
+			prio = *o.init.RootPrio
+		}
+		if o.init.RootMac != nil {
+			mac = *o.init.RootMac
+		}
+		o.vector.root = newStpBridgeId(prio, mac)
+	}
+}
+
+func (o *PluginStpNs) isRoot() bool {
+	return o.vector.root == o.vector.bridge
+}
+
+func (o *PluginStpNs) OnRemove(ctx *core.PluginCtx) {
+	for _, t := range []*core.CHTimerObj{&o.hello, &o.info, &o.fwd, &o.tcWhile} {
+		if t.IsRunning() {
+			o.timerw.Stop(t)
+		}
+	}
+}
+
+func (o *PluginStpNs) OnEvent(msg string, a, b interface{}) {
+
+}
+
+func (o *PluginStpNs) GetCounterDbVec() *core.CCounterDbVec {
+	return o.cdbv
+}
+
+func (o *PluginStpNs) restartTimer(t *core.CHTimerObj, sec uint32) {
+	if t.IsRunning() {
+		o.timerw.Stop(t)
+	}
+	o.timerw.Start(t, time.Duration(sec)*time.Second)
+}
+
+func (o *PluginStpNs) onTimerEvent(t int) {
+	switch t {
+	case STP_TIMER_HELLO:
+		o.onHello()
+	case STP_TIMER_INFO:
+		o.stats.infoExpired++
+		o.neighbor = nil
+		o.superior = false
+	case STP_TIMER_FWD:
+		if o.state == STP_STATE_LEARNING {
+			o.setState(STP_STATE_FORWARDING)
+		} else {
+			o.setState(STP_STATE_LEARNING)
+			o.restartTimer(&o.fwd, uint32(o.init.FwdDelay))
+		}
+	}
+}
+
+// onHello the periodic transmission, only designated ports send BPDUs unless there is a RSTP topology change
+func (o *PluginStpNs) onHello() {
+	o.restartTimer(&o.hello, uint32(o.init.Hello))
+	if o.role == STP_ROLE_DESIGNATED || (o.txVer >= 2 && o.role == STP_ROLE_ROOT && o.tcWhile.IsRunning()) {
+		o.txBpdu(0)
+	}
+	if o.tcnPending {
+		o.txTcn()
+	}
+}
+
+func (o *PluginStpNs) setState(state uint8) {
+	if o.state == state {
+		return
+	}
+	o.state = state
+	if state == STP_STATE_FORWARDING {
+		o.stats.toForwarding++
+		if o.fwd.IsRunning() {
+			o.timerw.Stop(&o.fwd)
+		}
+	}
+	if o.txVer >= 2 && o.role == STP_ROLE_DESIGNATED {
+		// the partner should know about the new state
+		o.txBpdu(0)
+	}
+}
+
+func (o *PluginStpNs) getClient() *core.CClient {
+	c := o.Ns.CLookupByMac(&o.init.Mac)
+	if c == nil {
+		o.stats.errNoClient++
+	}
+	return c
+}
+
+func (o *PluginStpNs) send(bpdu []byte) bool {
+	c := o.getClient()
+	if c == nil {
+		return false
+	}
+	pkt := c.GetL2Header(false, 0)
+	copy(pkt[0:6], stpDestMAC[:])
+	a := len(pkt) - 2
+	binary.BigEndian.PutUint16(pkt[a:a+2], uint16(core.LLC_HEADER_SIZE+len(bpdu)))
+	pkt = append(pkt, STP_LLC_SAP, STP_LLC_SAP, 0x03)
+	pkt = append(pkt, bpdu...)
+	o.Tctx.Veth.SendBuffer(false, c, pkt)
+	return true
+}
+
+func (o *PluginStpNs) txTcn() {
+	if o.send([]byte{0, 0, 0, STP_BPDU_TCN}) {
+		o.stats.pktTxTcn++
+	}
+}
+
+// roleFlags the role, state and proposal flags of RSTP/MSTP
+func (o *PluginStpNs) roleFlags(role uint8) uint8 {
+	f := role << STP_FLAG_ROLE_SHIFT
+	if o.state >= STP_STATE_LEARNING {
+		f |= STP_FLAG_LEARNING
+	}
+	if o.state == STP_STATE_FORWARDING {
+		f |= STP_FLAG_FORWARDING
+	} else if role == STP_ROLE_DESIGNATED {
+		f |= STP_FLAG_PROPOSAL
+	}
+	return f
+}
+
+// txBpdu sends the configuration BPDU of the version, with the extra flags
+func (o *PluginStpNs) txBpdu(extra uint8) {
+	i := &o.init
+	size := STP_CONFIG_SIZE
+	switch o.txVer {
+	case 2:
+		size = STP_RST_SIZE
+	case 3:
+		size = STP_MST_SIZE + len(i.Msti)*STP_MSTI_SIZE
+	}
+	d := make([]byte, size)
+	d[2] = o.txVer
+	flags := extra
+	if o.tcWhile.IsRunning() {
+		flags |= STP_FLAG_TC
+	}
+	if o.txVer == 0 {
+		if o.tcAck {
+			flags |= STP_FLAG_TCA
+		}
+	} else {
+		d[3] = STP_BPDU_RST
+		flags |= o.roleFlags(o.role)
+	}
+	d[4] = flags
+	copy(d[5:13], o.vector.root[:])
+	binary.BigEndian.PutUint32(d[13:17], o.vector.cost)
+	copy(d[17:25], o.vector.bridge[:])
+	binary.BigEndian.PutUint16(d[25:27], o.vector.port)
+	if !o.isRoot() {
+		d[27] = 1 // message age
+	}
+	d[29] = i.MaxAge
+	d[31] = i.Hello
+	d[33] = i.FwdDelay
+
+	if o.txVer == 3 {
+		binary.BigEndian.PutUint16(d[36:38], uint16(STP_MST_V3_LEN+len(i.Msti)*STP_MSTI_SIZE))
+		copy(d[39:39+STP_REGION_LEN], i.Region)
+		binary.BigEndian.PutUint16(d[71:73], i.Revision)
+		copy(d[73:89], o.digest)
+		copy(d[93:101], o.vector.bridge[:])
+		d[101] = STP_DEF_MAX_HOPS
+		for k, m := range i.Msti {
+			o.encodeMsti(d[STP_MST_SIZE+k*STP_MSTI_SIZE:], &m, flags&(STP_FLAG_TC|STP_FLAG_AGREEMENT))
+		}
+	}
+
+	if !o.send(d) {
+		return
+	}
+	o.stats.pktTx++
+	if flags&STP_FLAG_TCA != 0 {
+		o.tcAck = false
+		o.stats.pktTxTca++
+	}
+	if flags&STP_FLAG_AGREEMENT != 0 {
+		o.stats.pktTxAgreement++
+	}
+}
+
+// encodeMsti the MSTI configuration message, the state of the MSTI port is the state of the CIST port
+func (o *PluginStpNs) encodeMsti(b []byte, m *StpMstiInit, flags uint8) {
+	role := o.role
+	if m.Role != "" {
+		role = stpRoles[m.Role]
+	}
+	prio, portPrio := m.Prio, m.PortPrio
+	if prio == 0 {
+		prio = o.init.BridgePrio
+	}
+	if portPrio == 0 {
+		portPrio = o.init.PortPrio
+	}
+	b[0] = flags | o.roleFlags(role)
+	root := newStpBridgeId(prio&0xf000|m.Id, o.bridgeMac())
+	if m.RootPrio != nil {
+		binary.BigEndian.PutUint16(root[0:2], *m.RootPrio&0xf000|m.Id)
+	}
+	if m.RootMac != nil {
+		copy(root[2:8], m.RootMac[:])
+	}
+	copy(b[1:9], root[:])
+	binary.BigEndian.PutUint32(b[9:13], m.RootCost)
+	b[13] = uint8(prio>>8) & 0xf0
+	b[14] = portPrio & 0xf0
+	b[15] = STP_DEF_MAX_HOPS
+}
+
+// startTc a topology change of this port
+func (o *PluginStpNs) startTc() {
+	o.stats.tcStart++
+	if o.txVer >= 2 {
+		o.restartTimer(&o.tcWhile, 2*uint32(o.init.Hello))
+		if o.role != STP_ROLE_ALTERNATE {
+			o.txBpdu(0)
+		}
+		return
+	}
+	if o.role == STP_ROLE_DESIGNATED && o.isRoot() {
+		o.restartTimer(&o.tcWhile, uint32(o.init.MaxAge)+uint32(o.init.FwdDelay))
+		o.txBpdu(0)
+		return
+	}
+	o.tcnPending = true
+	o.txTcn()
+}
+
+func (o *PluginStpNs) onRxTcn() {
+	o.stats.pktRxTcn++
+	if o.role != STP_ROLE_DESIGNATED {
+		return
+	}
+	if o.txVer == 0 {
+		o.tcAck = true
+		if o.isRoot() {
+			o.restartTimer(&o.tcWhile, uint32(o.init.MaxAge)+uint32(o.init.FwdDelay))
+		}
+	} else {
+		o.restartTimer(&o.tcWhile, 2*uint32(o.init.Hello))
+	}
+	o.txBpdu(0)
+}
+
+func (o *PluginStpNs) onRxBpdu(rec *StpBpduRec, v *stpVector) {
+	o.stats.pktRx++
+	if rec.MsgAge >= rec.MaxAge {
+		o.stats.errMsgAge++
+		return
+	}
+	if v.bridge == o.vector.bridge && v.port == o.vector.port {
+		o.stats.pktRxLoop++
+		return
+	}
+	if o.txVer >= 2 && rec.Ver == 0 {
+		o.stats.stpCompat++
+		o.txVer = 0
+		o.vector.port = o.portId(o.init.PortPrio)
+	}
+	if rec.Tc {
+		o.stats.pktRxTc++
+	}
+	if rec.Flags&STP_FLAG_TCA != 0 && rec.Ver == 0 {
+		o.stats.pktRxTca++
+		o.tcnPending = false
+	}
+
+	superior := v.better(&o.vector)
+	if superior && !o.superior {
+		o.stats.rxSuperior++
+	}
+	o.superior = superior
+	o.neighbor = rec
+	o.rxVector = *v
+	sec := uint32(rec.MaxAge - rec.MsgAge)
+	if rec.Ver >= 2 {
+		sec = 3 * uint32(rec.Hello)
+		if sec == 0 {
+			sec = 3 * uint32(o.init.Hello)
+		}
+	}
+	o.restartTimer(&o.info, sec)
+	o.infoExpire = o.timerw.Ticks + uint64(o.timerw.DurationToTicks(time.Duration(sec)*time.Second))
+
+	if rec.Ver < 2 || o.txVer < 2 {
+		return
+	}
+	rxRole := (rec.Flags & STP_FLAG_ROLE_MASK) >> STP_FLAG_ROLE_SHIFT
+	if rec.Flags&STP_FLAG_PROPOSAL != 0 && rxRole == STP_ROLE_DESIGNATED {
+		o.stats.pktRxProposal++
+		if o.role == STP_ROLE_ROOT {
+			o.setState(STP_STATE_FORWARDING)
+			o.txBpdu(STP_FLAG_AGREEMENT)
+		}
+	}
+	if rec.Flags&STP_FLAG_AGREEMENT != 0 {
+		o.stats.pktRxAgreement++
+		if o.role == STP_ROLE_DESIGNATED && rxRole == STP_ROLE_ROOT {
+			o.setState(STP_STATE_FORWARDING)
+		}
+	}
+}
+
+func (o *PluginStpNs) HandleRxStpPacket(ps *core.ParserPacketState) int {
+	if !o.enable {
+		return core.PARSER_ERR
+	}
+	p := ps.M.GetData()
+	l3 := int(ps.L3)
+	length := int(binary.BigEndian.Uint16(p[l3-2 : l3]))
+	if length > len(p)-l3 || length < core.LLC_HEADER_SIZE || p[l3+1] != STP_LLC_SAP {
+		o.stats.errBpdu++
+		return core.PARSER_ERR
+	}
+	d := p[l3+core.LLC_HEADER_SIZE : l3+length]
+	rec := new(StpBpduRec)
+	var v stpVector
+	if !decodeBpdu(d, rec, &v) {
+		o.stats.errBpdu++
+		return core.PARSER_ERR
+	}
+	if rec.Type == stpBpduTypeNames[STP_BPDU_TCN] {
+		o.onRxTcn()
+	} else {
+		o.onRxBpdu(rec, &v)
+	}
+	return core.PARSER_OK
+}
+
+func (o *PluginStpNs) getInfo() *StpInfo {
+	i := &StpInfo{Ver: o.init.Ver, TxVer: o.txVer, BridgeId: o.vector.bridge.String(), PortId: o.vector.port,
+		Role: o.init.Role, State: stpStateNames[o.state], RootId: o.vector.root.String(), RootCost: o.vector.cost,
+		Superior: o.superior, Tc: o.tcWhile.IsRunning(), TcnPending: o.tcnPending, Neighbor: o.neighbor}
+	if o.txVer == 0 && o.state == STP_STATE_DISCARDING {
+		i.State = "blocking"
+	}
+	if o.digest != nil {
+		i.Digest = hex.EncodeToString(o.digest)
+	}
+	if o.superior {
+		i.RootId = o.rxVector.root.String()
+		i.RootCost = o.rxVector.cost + o.init.PathCost
+	}
+	i.RootBridge = !o.superior && o.isRoot()
+	if o.info.IsRunning() && o.infoExpire > o.timerw.Ticks {
+		i.Remaining = uint32(time.Duration(o.infoExpire-o.timerw.Ticks) * o.timerw.TickDuration / time.Second)
+	}
+	return i
+}
+
+// HandleRxStpPacket Parser call this function with mbuf from the pool
+func HandleRxStpPacket(ps *core.ParserPacketState) int {
+	ns := ps.Tctx.GetNs(ps.Tun)
+	if ns == nil {
+		return core.PARSER_ERR
+	}
+	nsplg := ns.PluginCtx.Get(STP_PLUG)
+	if nsplg == nil {
+		return core.PARSER_ERR
+	}
+	stpPlug := nsplg.Ext.(*PluginStpNs)
+	return stpPlug.HandleRxStpPacket(ps)
+}
+
+type PluginStpNsReg struct{}
+
+func (o PluginStpNsReg) NewPlugin(ctx *core.PluginCtx, initJson []byte) *core.PluginBase {
+	return NewStpNs(ctx, initJson)
+}
+
+/*******************************************/
+/*  RPC commands */
+type (
+	ApiStpNsCntHandler  struct{}
+	ApiStpNsInfoHandler struct{}
+	ApiStpNsTcHandler   struct{}
+)
+
+func getNs(ctx interface{}, params *fastjson.RawMessage) (*PluginStpNs, *jsonrpc.Error) {
+	tctx := ctx.(*core.CThreadCtx)
+	plug, err := tctx.GetNsPlugin(params, STP_PLUG)
+
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+	return plug.Ext.(*PluginStpNs), nil
+}
+
+func getEnabledNs(ctx interface{}, params *fastjson.RawMessage) (*PluginStpNs, *jsonrpc.Error) {
+	stpNs, err := getNs(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	if !stpNs.enable {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: "stp is not enabled, invalid init json",
+		}
+	}
+	return stpNs, nil
+}
+
+func (h ApiStpNsCntHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	var p core.ApiCntParams
+	tctx := ctx.(*core.CThreadCtx)
+	stpNs, err := getNs(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	return stpNs.cdbv.GeneralCounters(err, tctx, params, &p)
+}
+
+func (h ApiStpNsInfoHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	stpNs, err := getEnabledNs(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	return stpNs.getInfo(), nil
+}
+
+func (h ApiStpNsTcHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	stpNs, err := getEnabledNs(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	stpNs.startTc()
+	return nil, nil
+}
+
+func init() {
+
+	/* register of plugins callbacks for ns,c level  */
+	core.PluginRegister(STP_PLUG,
+		core.PluginRegisterData{Client: nil,
+			Ns:     PluginStpNsReg{},
+			Thread: nil}) /* no need for thread context for now */
+
+	core.RegisterCB("stp_ns_cnt", ApiStpNsCntHandler{}, false)   // get counters/meta
+	core.RegisterCB("stp_ns_info", ApiStpNsInfoHandler{}, false) // port information and the received BPDU
+	core.RegisterCB("stp_ns_tc", ApiStpNsTcHandler{}, false)     // topology change
+
+	/* register callback for rx side*/
+	core.ParserRegister(STP_PLUG, HandleRxStpPacket,
+		core.ParserRegisterData{LlcSaps: []uint8{STP_LLC_SAP}})
+}
+
+func Register(ctx *core.CThreadCtx) {
+	ctx.RegisterParserCb(STP_PLUG)
+}
@@ -0,0 +1,197 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package stp
+
+import (
+	"emu/core"
+	"encoding/binary"
+	"encoding/hex"
+	"testing"
+	"time"
+)
+
+// VethStpSim a link between vport 1 and 2
+type VethStpSim struct {
+}
+
+func (o *VethStpSim) ProcessTxToRx(m *core.Mbuf) *core.Mbuf {
+	m.SetVPort(3 - m.VPort())
+	return m
+}
+
+func genMbuf(tctx *core.CThreadCtx, vport uint16, pkt []byte) *core.Mbuf {
+	m := tctx.MPool.Alloc(uint16(len(pkt)))
+	m.SetVPort(vport)
+	m.Append(pkt)
+	return m
+}
+
+func createStpNs(tctx *core.CThreadCtx, vport uint16, initJson string) *PluginStpNs {
+	var key core.CTunnelKey
+	key.Set(&core.CTunnelData{Vport: vport})
+	ns := core.NewNSCtx(tctx, &key)
+	tctx.AddNs(&key, ns)
+	c := core.NewClient(ns, core.MACKey{0, 0, 1, 0, 0, byte(vport)}, core.Ipv4Key{}, core.Ipv6Key{}, core.Ipv4Key{})
+	ns.AddClient(c)
+	ns.PluginCtx.CreatePlugins([]string{"stp"}, [][]byte{[]byte(initJson)})
+	return ns.PluginCtx.Get(STP_PLUG).Ext.(*PluginStpNs)
+}
+
+func newStpCtx() *core.CThreadCtx {
+	var simrx core.VethIFSim = &VethStpSim{}
+	tctx := core.NewThreadCtx(0, 4510, true, &simrx)
+	Register(tctx)
+	return tctx
+}
+
+func checkStpState(t *testing.T, o *PluginStpNs, state string) *StpInfo {
+	info := o.getInfo()
+	if info.State != state {
+		t.Fatalf(" port %d expected %s, got %+v %+v", o.init.Port, state, *info, o.stats)
+	}
+	return info
+}
+
+func TestStpRstpAgreement(t *testing.T) {
+	tctx := newStpCtx()
+	defer tctx.Delete()
+
+	root := createStpNs(tctx, 1, `{"mac": [0, 0, 1, 0, 0, 1], "bridge_prio": 4096}`)
+	o := createStpNs(tctx, 2, `{"mac": [0, 0, 1, 0, 0, 2], "role": "root", "root_prio": 4096,
+		"root_mac": [0, 0, 1, 0, 0, 1], "root_cost": 20000}`)
+
+	// the proposal of the designated port is answered by an agreement, both are forwarding at once
+	tctx.MainLoopSim(time.Second)
+	info := checkStpState(t, root, "forwarding")
+	if !info.RootBridge || info.Superior || info.RootId != "1000.00:00:01:00:00:01" || root.stats.pktRxAgreement != 1 ||
+		root.stats.toForwarding != 1 {
+		t.Fatalf(" unexpected root port %+v %+v", *info, root.stats)
+	}
+	info = checkStpState(t, o, "forwarding")
+	if info.RootBridge || !info.Superior || info.RootId != "1000.00:00:01:00:00:01" || info.RootCost != 20000 ||
+		info.Neighbor == nil || info.Neighbor.Role != "designated" || info.Neighbor.Type != "rst" ||
+		o.stats.pktTxAgreement != 1 || o.stats.rxSuperior != 1 || o.stats.pktTx != 1 {
+		t.Fatalf(" unexpected port %+v %+v", *info, o.stats)
+	}
+
+	// the root port is silent, the information it sent is aged after 3 hello times
+	tctx.MainLoopSim(10 * time.Second)
+	if info = root.getInfo(); info.Neighbor != nil || root.stats.infoExpired != 1 || o.stats.pktTx != 1 {
+		t.Fatalf(" the information should be aged %+v %+v", *info, root.stats)
+	}
+	if o.stats.infoExpired != 0 || o.getInfo().Remaining == 0 {
+		t.Fatalf(" the information of the designated port should be current %+v", o.stats)
+	}
+
+	// topology change, the root port sends the TC flag for 2 hello times
+	o.startTc()
+	tctx.MainLoopSim(time.Second)
+	if !o.getInfo().Tc || root.stats.pktRxTc == 0 || o.stats.tcStart != 1 {
+		t.Fatalf(" expected a topology change %+v", root.stats)
+	}
+	tctx.MainLoopSim(4 * time.Second)
+	rx := root.stats.pktRx
+	tctx.MainLoopSim(4 * time.Second)
+	if o.getInfo().Tc || root.stats.pktRx != rx || root.stats.pktRxTc != rx-1 || root.stats.errBpdu != 0 || o.stats.errBpdu != 0 {
+		t.Fatalf(" the topology change should be over %+v", root.stats)
+	}
+}
+
+func TestStpTcn(t *testing.T) {
+	tctx := newStpCtx()
+	defer tctx.Delete()
+
+	root := createStpNs(tctx, 1, `{"mac": [0, 0, 1, 0, 0, 1], "ver": 0, "bridge_prio": 4096}`)
+	o := createStpNs(tctx, 2, `{"mac": [0, 0, 1, 0, 0, 2], "role": "root", "root_prio": 4096,
+		"root_mac": [0, 0, 1, 0, 0, 1], "root_cost": 20000}`)
+
+	// the RSTP port moves to STP, listening and learning for 2 forward delays
+	tctx.MainLoopSim(time.Second)
+	checkStpState(t, root, "listening")
+	info := checkStpState(t, o, "forwarding")
+	if info.TxVer != 0 || o.stats.stpCompat != 1 || info.Neighbor.Type != "config" {
+		t.Fatalf(" expected stp %+v %+v", *info, o.stats)
+	}
+	tctx.MainLoopSim(15 * time.Second)
+	checkStpState(t, root, "learning")
+	tctx.MainLoopSim(15 * time.Second)
+	checkStpState(t, root, "forwarding")
+
+	// TCN is acknowledged by the root, that sets the TC flag for max age + forward delay
+	o.startTc()
+	tctx.MainLoopSim(time.Second)
+	info = o.getInfo()
+	if info.TcnPending || o.stats.pktTxTcn != 1 || o.stats.pktRxTca != 1 || root.stats.pktRxTcn != 1 || root.stats.pktTxTca != 1 {
+		t.Fatalf(" expected an acknowledged tcn %+v %+v", o.stats, root.stats)
+	}
+	if !root.getInfo().Tc || o.stats.pktRxTc == 0 {
+		t.Fatalf(" expected the tc flag %+v", o.stats)
+	}
+	tctx.MainLoopSim(36 * time.Second)
+	if root.getInfo().Tc {
+		t.Fatalf(" the topology change should be over")
+	}
+}
+
+func TestStpMst(t *testing.T) {
+	tctx := newStpCtx()
+	defer tctx.Delete()
+
+	// the digest of the table without VLANs
+	if d := hex.EncodeToString(mstDigest(nil)); d != "ac36177f50283cd4b83821d8ab26de62" {
+		t.Fatalf(" unexpected digest %s", d)
+	}
+
+	mst := createStpNs(tctx, 1, `{"mac": [0, 0, 1, 0, 0, 1], "ver": 3, "region": "r1", "revision": 7,
+		"msti": [{"id": 1, "vlans": [10, 11]}, {"id": 2, "prio": 4096, "role": "alternate", "vlans": [20]}]}`)
+	o := createStpNs(tctx, 2, `{"mac": [0, 0, 1, 0, 0, 2], "role": "alternate"}`)
+	tctx.MainLoopSim(3 * time.Second)
+	n := o.getInfo().Neighbor
+	if n == nil || n.Type != "mst" || n.Region != "r1" || n.Revision != 7 || n.Digest != mst.getInfo().Digest ||
+		n.BridgeId != "8000.00:00:01:00:00:01" || n.Hops != STP_DEF_MAX_HOPS || len(n.Msti) != 2 {
+		t.Fatalf(" unexpected mst bpdu %+v", n)
+	}
+	if m := n.Msti[1]; m.Id != 2 || m.BridgePrio != 4096 || m.Role != "alternate" || m.RegionalRootId != "1002.00:00:01:00:00:01" {
+		t.Fatalf(" unexpected msti %+v", m)
+	}
+	if info := o.getInfo(); !info.Superior || info.RootId != "8000.00:00:01:00:00:01" || o.stats.pktTx != 0 || mst.stats.pktRx != 0 {
+		t.Fatalf(" the alternate port should be silent %+v %+v", *info, o.stats)
+	}
+
+	// malformed, aged and looped BPDUs
+	l2 := []byte{0x01, 0x80, 0xc2, 0, 0, 0, 0, 0, 1, 0, 0, 9, 0, 0, 0x42, 0x42, 0x03}
+	for _, b := range [][]byte{{0, 0, 2, 2, 0}, {0, 1, 0, 0x80}} {
+		pkt := append(append([]byte(nil), l2...), b...)
+		pkt[13] = uint8(3 + len(b))
+		tctx.HandleRxPacket(genMbuf(tctx, 2, pkt))
+	}
+	bpdu := make([]byte, STP_CONFIG_SIZE)
+	bpdu[27], bpdu[29] = 20, 20
+	pkt := append(append([]byte(nil), l2...), bpdu...)
+	pkt[13] = 3 + STP_CONFIG_SIZE
+	tctx.HandleRxPacket(genMbuf(tctx, 2, pkt))
+	bpdu[27] = 0
+	copy(bpdu[17:25], o.vector.bridge[:])
+	binary.BigEndian.PutUint16(bpdu[25:27], o.vector.port)
+	pkt = append(append([]byte(nil), l2...), bpdu...)
+	pkt[13] = 3 + STP_CONFIG_SIZE
+	tctx.HandleRxPacket(genMbuf(tctx, 2, pkt))
+	if o.stats.errBpdu != 2 || o.stats.errMsgAge != 1 || o.stats.pktRxLoop != 1 {
+		t.Fatalf(" unexpected counters %+v", o.stats)
+	}
+
+	for k, j := range []string{
+		`{"mac": [0, 0, 1, 0, 0, 3], "ver": 2, "msti": [{"id": 1}]}`,
+		`{"mac": [0, 0, 1, 0, 0, 3], "ver": 3, "msti": [{"id": 1, "vlans": [10]}, {"id": 2, "vlans": [10]}]}`,
+		`{"mac": [0, 0, 1, 0, 0, 3], "hello": 10, "max_age": 20}`,
+		`{"mac": [0, 0, 1, 0, 0, 3], "role": "edge"}`,
+	} {
+		bad := createStpNs(tctx, uint16(3+k), j)
+		if bad.enable || bad.stats.errInitJson != 1 || bad.stats.pktTx != 0 {
+			t.Fatalf(" %s should be invalid %+v", j, bad.stats)
+		}
+	}
+}
@@ -10,6 +10,7 @@ tree.
 
 import (
 	"emu/core"
+	"emu/plugins/dns"
 	engines "emu/plugins/field_engine"
 	"emu/plugins/transport"
 	"encoding/binary"
@@ -249,7 +250,7 @@ type UnconstructedTdlType struct {
 
 // TdlClientParams defines a structure that parses the init Json params of the Tdl client.
 type TdlClientParams struct {
-	Dst        string                 `json:"dst" validate:"required"`         // Destination address. Combination of Host:Port
+	Dst        string                 `json:"dst" validate:"required"`         // Destination address. Combination of Host:Port, the host could be a name
 	Rate       float32                `json:"rate_pps"`                        // Rate of Tx in PPS. Default=1.
 	UdpDebug   bool                   `json:"udp_debug"`                       // Should we run UDP because we are debugging?
 	Header     TdlHeader              `json:"header" validate:"required"`      // Tdl header options
@@ -270,6 +271,7 @@ type PluginTdlClient struct {
 	transportCtx       *transport.TransportCtx           // Transport Layer Context
 	socket             transport.SocketApi               // Socket API
 	dgMacResolved      bool                              // Is the default gateway MAC address resolved?
+	removed            bool                              // Was the plugin removed, e.g. while the destination is resolved
 	stats              TdlStats                          // Tdl statistics
 	cdb                *core.CCounterDb                  // Counters database
 	cdbv               *core.CCounterDbVec               // Counters database vector
@@ -409,7 +411,12 @@ func (o *PluginTdlClient) OnCreate() {
 }
 
 // OnRemove is called when we remove the Tdl client.
+func (o *PluginTdlClient) GetCounterDbVec() *core.CCounterDbVec {
+	return o.cdbv
+}
+
 func (o *PluginTdlClient) OnRemove(ctx *core.PluginCtx) {
+	o.removed = true
 	if o.pktTimer.IsRunning() {
 		o.timerw.Stop(&o.pktTimer)
 	}
@@ -445,20 +452,35 @@ func (o *PluginTdlClient) OnEvent(msg string, a, b interface{}) {
 func (o *PluginTdlClient) OnResolve() {
 	o.dgMacResolved = true
 	if o.transportCtx != nil {
-		var err error
-		l4Protocol := "tcp"
-		if o.udpDebug {
-			l4Protocol = "udp"
-		}
-		o.socket, err = o.transportCtx.Dial(l4Protocol, o.dstAddress, o, nil, nil)
-		if err != nil {
-			o.stats.invalidSocket++
-			return
-		}
-		if o.socket.GetCap()&transport.SocketCapConnection == 0 {
-			// socket isn't connection oriented, we can start ticks
-			o.timerw.StartTicks(&o.pktTimer, o.pktTicks)
-		}
+		// A host name is resolved by the dns plugin of the client, a literal address at once.
+		dns.ResolveAddr(o.Client, o.dstAddress, o.isIpv6, func(addr string, err error) {
+			if o.removed {
+				return
+			}
+			if err != nil {
+				o.stats.invalidDst++
+				return
+			}
+			o.dial(addr)
+		})
+	}
+}
+
+// dial opens the socket to the resolved destination address.
+func (o *PluginTdlClient) dial(addr string) {
+	var err error
+	l4Protocol := "tcp"
+	if o.udpDebug {
+		l4Protocol = "udp"
+	}
+	o.socket, err = o.transportCtx.Dial(l4Protocol, addr, o, nil, nil)
+	if err != nil {
+		o.stats.invalidSocket++
+		return
+	}
+	if o.socket.GetCap()&transport.SocketCapConnection == 0 {
+		// socket isn't connection oriented, we can start ticks
+		o.timerw.StartTicks(&o.pktTimer, o.pktTicks)
 	}
 }
 
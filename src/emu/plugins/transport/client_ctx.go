@@ -317,6 +317,7 @@ func newftStatsDb(o *ftStats) *core.CCounterDb {
 		Help:     "active source ports",
 		Unit:     "event",
 		DumpZero: true,
+		Gauge:    true,
 		Info:     core.ScINFO})
 
 	db.Add(&core.CCounterRec{
@@ -357,6 +358,7 @@ func newftStatsDb(o *ftStats) *core.CCounterDb {
 		Help:     "active v4 flows",
 		Unit:     "flows",
 		DumpZero: true,
+		Gauge:    true,
 		Info:     core.ScINFO})
 
 	db.Add(&core.CCounterRec{
@@ -413,6 +415,7 @@ func newftStatsDb(o *ftStats) *core.CCounterDb {
 		Help:     "active v6 flows",
 		Unit:     "flows",
 		DumpZero: true,
+		Gauge:    true,
 		Info:     core.ScINFO})
 
 	db.Add(&core.CCounterRec{
@@ -457,10 +460,12 @@ type TransportCtx struct {
 	Tctx     *core.CThreadCtx
 	tcpStats TcpStats
 	udpStats UdpStats
+	tlsStats TlsStats
 	timerw   *core.TimerCtx
 	cdbv     *core.CCounterDbVec
 	cdbtcp   *core.CCounterDb
 	cdbudp   *core.CCounterDb
+	cdbtls   *core.CCounterDb
 	timer    core.CHTimerObj
 	timerCb  ctxClientTimer
 
@@ -494,6 +499,10 @@ type TransportCtx struct {
 	ftv6           flowTablev6
 	srcPorts       srcPortManager
 	serverCb       serverft // server callbacks
+
+	// tls
+	tlsServers map[uint16]*tlsServer // the servers of Listen("tls", ..) by port
+	tlsSockets map[*tlsSocket]bool   // the sockets with a running goroutine
 }
 
 func updateInitwnd(mss uint16, initwnd uint16) uint16 {
@@ -515,8 +524,10 @@ func newCtx(c *core.CClient) *TransportCtx {
 	o.cdbudp = NewUdpStatsDb(&o.udpStats)
 	o.cdbtcp = NewTcpStatsDb(&o.tcpStats)
 	o.cdbv = core.NewCCounterDbVec("tcp")
+	o.cdbtls = NewTlsStatsDb(&o.tlsStats)
 	o.cdbv.Add(o.cdbtcp)
 	o.cdbv.Add(o.cdbudp)
+	o.cdbv.Add(o.cdbtls)
 	o.timer.SetCB(&o.timerCb, o, 0) // set the callback to OnEvent
 	o.restartTimer()
 
@@ -526,6 +537,8 @@ func newCtx(c *core.CClient) *TransportCtx {
 	o.ftv6 = make(flowTablev6)
 	o.srcPorts.init(o)
 	o.serverCb = make(serverft)
+	o.tlsServers = make(map[uint16]*tlsServer)
+	o.tlsSockets = make(map[*tlsSocket]bool)
 	return o
 }
 
@@ -589,6 +602,11 @@ func (o *TransportCtx) onRemove() {
 		or.onRemove()
 	}
 
+	// stop the goroutines of the tls sockets
+	for s := range o.tlsSockets {
+		s.abort()
+	}
+
 	if o.timer.IsRunning() {
 		o.timerw.Stop(&o.timer)
 	}
@@ -971,12 +989,13 @@ func (o *TransportCtx) handleRxPacket(ps *core.ParserPacketState) int {
 //	Dial("tcp", "[2001:db8::1]:80",cb,nil)
 //	Dial("tcp", "[2001:db8::1]:80",cb,{"tos":12})
 //	Dial("udp", "192.0.2.1:80",cb,nil, &core.MACKey{0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+//	Dial("tls", "192.0.2.1:443",cb,{"tls_server_name":"www.example.com", "tls_alpn":"h2,http/1.1"}, nil)
 func (o *TransportCtx) Dial(network, address string, cb ISocketCb, ioctl IoctlMap, dstMac *core.MACKey) (SocketApi, error) {
 
 	o.flowTableStats.dial++
 
 	switch network {
-	case "tcp", "udp":
+	case "tcp", "udp", "tls":
 	default:
 		o.flowTableStats.dial_wrong_network++
 		return nil, fmt.Errorf(" unsupported %v network", network)
@@ -1004,6 +1023,8 @@ func (o *TransportCtx) Dial(network, address string, cb ISocketCb, ioctl IoctlMa
 		return o.dialTcp(dst, port16, cb, ioctl, dstMac)
 	case "udp":
 		return o.dialUdp(dst, port16, cb, ioctl, dstMac)
+	case "tls":
+		return o.dialTls(host, dst, port16, cb, ioctl, dstMac)
 	}
 	return nil, fmt.Errorf(" unsupported %v network", network)
 }
@@ -1145,7 +1166,7 @@ func (o *TransportCtx) lookupServerPort(port uint16, proto uint8) IServerSocketC
 func (o *TransportCtx) parseNA(network, address string, port *uint16, proto *uint8) error {
 	var proid uint8
 	switch network {
-	case "tcp":
+	case "tcp", "tls":
 		proid = TCP_PROTO
 	case "udp":
 		proid = UDP_PROTO
@@ -1185,6 +1206,10 @@ to remove the callback
 
 ctx.UnListen("tcp",":8080",cb)
 
+create a TLS server, OnAccept gets the TLS socket, the tls keys can be set by SetIoctl in OnAccept
+
+ctx.Listen("tls",":443",cb)
+
 */
 func (o *TransportCtx) Listen(network, address string, cb IServerSocketCb) error {
 	var proto uint8
@@ -1192,9 +1217,16 @@ func (o *TransportCtx) Listen(network, address string, cb IServerSocketCb) error
 	if err := o.parseNA(network, address, &port, &proto); err != nil {
 		return err
 	}
-	if !o.addServerCb(port, proto, cb) {
+	scb := cb
+	if network == "tls" {
+		scb = &tlsServer{ctx: o, cb: cb}
+	}
+	if !o.addServerCb(port, proto, scb) {
 		return fmt.Errorf(" port %v already register for %s network", port, network)
 	}
+	if network == "tls" {
+		o.tlsServers[port] = scb.(*tlsServer)
+	}
 	return nil
 }
 
@@ -1207,6 +1239,14 @@ func (o *TransportCtx) UnListen(network, address string, cb IServerSocketCb) err
 	if err := o.parseNA(network, address, &port, &proto); err != nil {
 		return err
 	}
+	if network == "tls" {
+		srv, ok := o.tlsServers[port]
+		if !ok || srv.cb != cb {
+			return fmt.Errorf(" port %v is no register for %s network", port, network)
+		}
+		delete(o.tlsServers, port)
+		cb = srv
+	}
 	if !o.removeServerCb(port, proto, cb) {
 		return fmt.Errorf(" port %v is no register for %s network", port, network)
 	}
@@ -73,6 +73,15 @@ func (o *PluginTransClient) OnRemove(ctx *core.PluginCtx) {
 	tx.onRemove()
 }
 
+// GetCounterDbVec returns the counters of the transport layer, nil in case it wasn't created
+func (o *PluginTransClient) GetCounterDbVec() *core.CCounterDbVec {
+	tl := o.Client.GetTransportCtx()
+	if tl == nil {
+		return nil
+	}
+	return tl.(*TransportCtx).cdbv
+}
+
 func (o *PluginTransClient) handleRxTransPacket(ps *core.ParserPacketState) int {
 	tl := o.Client.GetTransportCtx()
 	if tl == nil {
@@ -206,7 +215,8 @@ func init() {
 	core.RegisterCB("transport_client_cnt", ApiTransClientCntHandler{}, false) // get counters/meta
 
 	/* register callback for rx side*/
-	core.ParserRegister("transport", HandleRxTransPacket)
+	core.ParserRegister("transport", HandleRxTransPacket,
+		core.ParserRegisterData{UdpDefault: true, TcpDefault: true}) // unmatched udp/tcp
 }
 
 func Register(ctx *core.CThreadCtx) {
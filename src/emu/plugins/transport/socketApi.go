@@ -33,6 +33,7 @@ const (
 	SeCONNECTION_IS_CLOSED SocketErr = 7
 	SeWRITE_WHILE_DRAIN    SocketErr = 8
 	SeUNRESOLVED           SocketErr = 9
+	SeTLS_ERROR            SocketErr = 10
 )
 
 // String shows the register type nicely formatted
@@ -60,6 +61,8 @@ func (tt SocketErr) String() string {
 		return "Socket queue is full, wait for tx event"
 	case SeUNRESOLVED:
 		return "Socket destination MAC address unresolved."
+	case SeTLS_ERROR:
+		return "Socket TLS handshake failed or fatal alert"
 	}
 }
 
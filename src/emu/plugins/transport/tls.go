@@ -0,0 +1,795 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License")
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package transport
+
+/*
+TLS over the TCP sockets, Dial("tls", ..) and Listen("tls", ..) return a SocketApi that runs crypto/tls over a TCP socket
+of the client.
+
+crypto/tls works on a blocking net.Conn, so each TLS socket runs its tls.Conn in a goroutine over tlsConn. The goroutine
+and the thread never run at the same time: the thread passes the data of the TCP socket to the goroutine and waits
+until the goroutine is blocked on Read again, then it writes the records to the TCP socket and calls the callbacks.
+The goroutine does not wait for the network and all the callbacks are called by the thread.
+
+SocketEventConnected is called when the handshake is done, the data that is written before it is sent after the
+handshake. Close sends close_notify and closes the TCP socket after the tx queue was flushed and the close_notify
+(or FIN) of the peer was received. A failed handshake or a fatal alert closes the TCP socket, GetLastError returns
+SeTLS_ERROR.
+*/
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"emu/core"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	TLS_IOCTL_CERT        = "tls_cert"        // PEM certificate chain, the server uses a self signed certificate without it
+	TLS_IOCTL_KEY         = "tls_key"         // PEM private key of tls_cert
+	TLS_IOCTL_CA          = "tls_ca"          // PEM roots, the client verifies the server, the server requires a client certificate
+	TLS_IOCTL_SERVER_NAME = "tls_server_name" // SNI of the client, the address of Dial by default. get returns the SNI
+	TLS_IOCTL_ALPN        = "tls_alpn"        // ALPN protocols, a list or a comma separated string. get returns the negotiated one
+	TLS_IOCTL_CIPHERS     = "tls_ciphers"     // TLS 1.0-1.2 cipher suites by name, the TLS 1.3 suites can't be changed
+	TLS_IOCTL_MIN_VERSION = "tls_min_version" // "1.0", "1.1", "1.2" or "1.3"
+	TLS_IOCTL_MAX_VERSION = "tls_max_version" // "1.0", "1.1", "1.2" or "1.3"
+	TLS_IOCTL_CONFIG      = "tls_config"      // *tls.Config, replaces all the other keys
+	TLS_IOCTL_VERSION     = "tls_version"     // get only, the negotiated version
+	TLS_IOCTL_CIPHER      = "tls_cipher"      // get only, the negotiated cipher suite
+	TLS_IOCTL_ALERT       = "tls_alert"       // get only, the fatal alert (or error) that was sent or received
+
+	TLS_READ_SIZE = 16384
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+func tlsVersionName(v uint16) string {
+	for n, ver := range tlsVersions {
+		if ver == v {
+			return n
+		}
+	}
+	return fmt.Sprintf("0x%04x", v)
+}
+
+// tlsStrings returns the strings of an ioctl value, a list or a comma separated string
+func tlsStrings(key string, v interface{}) ([]string, error) {
+	switch s := v.(type) {
+	case string:
+		var r []string
+		for _, e := range strings.Split(s, ",") {
+			if e = strings.TrimSpace(e); e != "" {
+				r = append(r, e)
+			}
+		}
+		return r, nil
+	case []string:
+		return s, nil
+	case []interface{}:
+		r := make([]string, 0, len(s))
+		for _, e := range s {
+			es, ok := e.(string)
+			if !ok {
+				return nil, fmt.Errorf(" %s should be a list of strings", key)
+			}
+			r = append(r, es)
+		}
+		return r, nil
+	}
+	return nil, fmt.Errorf(" %s should be a string or a list of strings", key)
+}
+
+func tlsString(m IoctlMap, key string) (string, bool, error) {
+	v, ok := m[key]
+	if !ok {
+		return "", false, nil
+	}
+	s, ok := v.(string)
+	if !ok {
+		return "", false, fmt.Errorf(" %s should be a string", key)
+	}
+	return s, true, nil
+}
+
+var tlsDefCert struct {
+	once sync.Once
+	cert tls.Certificate
+	err  error
+}
+
+// tlsDefaultCert returns the self signed certificate of the servers without tls_cert, it is created once
+func tlsDefaultCert() (tls.Certificate, error) {
+	d := &tlsDefCert
+	d.once.Do(func() {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			d.err = err
+			return
+		}
+		now := time.Now()
+		tmpl := x509.Certificate{
+			SerialNumber:          big.NewInt(1),
+			Subject:               pkix.Name{CommonName: "trex-emu"},
+			DNSNames:              []string{"trex-emu"},
+			NotBefore:             now.Add(-time.Hour),
+			NotAfter:              now.AddDate(10, 0, 0),
+			KeyUsage:              x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+			BasicConstraintsValid: true,
+		}
+		der, err := x509.CreateCertificate(rand.Reader, &tmpl, &tmpl, &key.PublicKey, key)
+		if err != nil {
+			d.err = err
+			return
+		}
+		d.cert = tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+	})
+	return d.cert, d.err
+}
+
+// newTlsConfig builds the configuration from the tls keys of the ioctl, host is the address of Dial
+func newTlsConfig(m IoctlMap, server bool, host string) (*tls.Config, error) {
+	if v, ok := m[TLS_IOCTL_CONFIG]; ok {
+		c, ok := v.(*tls.Config)
+		if !ok || c == nil {
+			return nil, fmt.Errorf(" %s should be *tls.Config", TLS_IOCTL_CONFIG)
+		}
+		return c.Clone(), nil
+	}
+	cfg := &tls.Config{}
+
+	cert, hasCert, err := tlsString(m, TLS_IOCTL_CERT)
+	if err != nil {
+		return nil, err
+	}
+	key, hasKey, err := tlsString(m, TLS_IOCTL_KEY)
+	if err != nil {
+		return nil, err
+	}
+	if hasCert != hasKey {
+		return nil, fmt.Errorf(" %s and %s should be given together", TLS_IOCTL_CERT, TLS_IOCTL_KEY)
+	}
+	if hasCert {
+		c, err := tls.X509KeyPair([]byte(cert), []byte(key))
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{c}
+	} else if server {
+		c, err := tlsDefaultCert()
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{c}
+	}
+
+	ca, hasCa, err := tlsString(m, TLS_IOCTL_CA)
+	if err != nil {
+		return nil, err
+	}
+	if hasCa {
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM([]byte(ca)) {
+			return nil, fmt.Errorf(" %s is not valid", TLS_IOCTL_CA)
+		}
+		if server {
+			cfg.ClientCAs = roots
+			cfg.ClientAuth = tls.RequireAndVerifyClientCert
+		} else {
+			cfg.RootCAs = roots
+		}
+	} else if !server {
+		// the emulated servers have self signed certificates
+		cfg.InsecureSkipVerify = true
+	}
+
+	if !server {
+		name, ok, err := tlsString(m, TLS_IOCTL_SERVER_NAME)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			name = host
+		}
+		cfg.ServerName = name
+	}
+
+	if v, ok := m[TLS_IOCTL_ALPN]; ok {
+		if cfg.NextProtos, err = tlsStrings(TLS_IOCTL_ALPN, v); err != nil {
+			return nil, err
+		}
+	}
+
+	if v, ok := m[TLS_IOCTL_CIPHERS]; ok {
+		names, err := tlsStrings(TLS_IOCTL_CIPHERS, v)
+		if err != nil {
+			return nil, err
+		}
+		suites := append(tls.CipherSuites(), tls.InsecureCipherSuites()...)
+		for _, n := range names {
+			var id uint16
+			for _, s := range suites {
+				if s.Name == n {
+					id = s.ID
+					break
+				}
+			}
+			if id == 0 {
+				return nil, fmt.Errorf(" unknown cipher suite %s", n)
+			}
+			cfg.CipherSuites = append(cfg.CipherSuites, id)
+		}
+	}
+
+	for _, k := range []string{TLS_IOCTL_MIN_VERSION, TLS_IOCTL_MAX_VERSION} {
+		s, ok, err := tlsString(m, k)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		ver, ok := tlsVersions[s]
+		if !ok {
+			return nil, fmt.Errorf(" %s %s is not valid", k, s)
+		}
+		if k == TLS_IOCTL_MIN_VERSION {
+			cfg.MinVersion = ver
+		} else {
+			cfg.MaxVersion = ver
+		}
+	}
+	if cfg.MinVersion != 0 && cfg.MaxVersion != 0 && cfg.MinVersion > cfg.MaxVersion {
+		return nil, fmt.Errorf(" %s is bigger than %s", TLS_IOCTL_MIN_VERSION, TLS_IOCTL_MAX_VERSION)
+	}
+	return cfg, nil
+}
+
+// isTlsKey returns true for the keys of the tls layer
+func isTlsKey(k string) bool {
+	return strings.HasPrefix(k, "tls_")
+}
+
+// tlsConn the connection of crypto/tls, Read blocks the goroutine until the thread passes the next data
+type tlsConn struct {
+	s    *tlsSocket
+	in   chan []byte
+	wait chan bool // true the goroutine waits for data, false it exited
+	mu   sync.Mutex
+	out  []byte
+	rbuf []byte
+	eof  bool // Read returned io.EOF
+}
+
+func (o *tlsConn) Read(b []byte) (int, error) {
+	if len(o.rbuf) == 0 {
+		o.wait <- true
+		d, ok := <-o.in
+		if !ok {
+			o.eof = true
+			return 0, io.EOF
+		}
+		o.rbuf = d
+	}
+	n := copy(b, o.rbuf)
+	o.rbuf = o.rbuf[n:]
+	return n, nil
+}
+
+func (o *tlsConn) Write(b []byte) (int, error) {
+	o.mu.Lock()
+	o.out = append(o.out, b...)
+	o.mu.Unlock()
+	return len(b), nil
+}
+
+func (o *tlsConn) takeOut() []byte {
+	o.mu.Lock()
+	out := o.out
+	o.out = nil
+	o.mu.Unlock()
+	return out
+}
+
+func (o *tlsConn) Close() error                       { return nil }
+func (o *tlsConn) LocalAddr() net.Addr                { return o.s.tcp.LocalAddr() }
+func (o *tlsConn) RemoteAddr() net.Addr               { return o.s.tcp.RemoteAddr() }
+func (o *tlsConn) SetDeadline(t time.Time) error      { return nil }
+func (o *tlsConn) SetReadDeadline(t time.Time) error  { return nil }
+func (o *tlsConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// tlsSocket is the SocketApi of TLS, it is the callback of its TCP socket
+type tlsSocket struct {
+	ctx    *TransportCtx
+	tcp    SocketApi
+	cb     ISocketCb
+	server bool
+	host   string
+	ioctl  IoctlMap // the tls keys
+	cfg    *tls.Config
+
+	conn    tlsConn
+	tls     *tls.Conn
+	running bool
+	inEof   bool // the data of the TCP socket ended
+
+	// set by the goroutine
+	hsDone bool
+	hsErr  error
+	state  tls.ConnectionState
+	plain  [][]byte
+	err    error // the error that ended the goroutine
+
+	hsHandled  bool
+	connected  bool // SocketEventConnected was called
+	exited     bool // the end of the goroutine was handled
+	pending    [][]byte
+	tx         []byte // records that wait for the TCP socket
+	txDrain    bool   // the TCP socket drains a partial write
+	userDrain  bool   // the user waits for SocketTxMore
+	closing    bool
+	notifySent bool
+	tcpClosed  bool
+	closed     bool
+	alert      string
+	lastErr    SocketErr
+}
+
+func newTlsSocket(ctx *TransportCtx, server bool, host string) *tlsSocket {
+	o := &tlsSocket{ctx: ctx, server: server, host: host, ioctl: make(IoctlMap)}
+	o.conn.s = o
+	o.conn.in = make(chan []byte)
+	o.conn.wait = make(chan bool)
+	return o
+}
+
+// setTlsIoctl keeps the tls keys of the ioctl, the configuration is used by the handshake
+func (o *tlsSocket) setTlsIoctl(m IoctlMap) error {
+	n := 0
+	for k := range m {
+		if isTlsKey(k) {
+			n++
+		}
+	}
+	if n == 0 {
+		return nil
+	}
+	if o.running || o.exited {
+		return fmt.Errorf(" the tls keys can't be changed after the handshake started")
+	}
+	ioctl := make(IoctlMap, len(o.ioctl)+n)
+	for k, v := range o.ioctl {
+		ioctl[k] = v
+	}
+	for k, v := range m {
+		if isTlsKey(k) {
+			ioctl[k] = v
+		}
+	}
+	cfg, err := newTlsConfig(ioctl, o.server, o.host)
+	if err != nil {
+		o.ctx.tlsStats.tls_err_ioctl++
+		return err
+	}
+	o.ioctl = ioctl
+	o.cfg = cfg
+	return nil
+}
+
+func (o *tlsSocket) run() {
+	err := o.tls.Handshake()
+	if err == nil {
+		o.state = o.tls.ConnectionState()
+	}
+	o.hsErr = err
+	o.hsDone = true
+	if err == nil {
+		buf := make([]byte, TLS_READ_SIZE)
+		for err == nil {
+			var n int
+			n, err = o.tls.Read(buf)
+			if n > 0 {
+				o.plain = append(o.plain, append([]byte(nil), buf[:n]...))
+			}
+		}
+	}
+	o.err = err
+	o.conn.wait <- false
+}
+
+func (o *tlsSocket) waitForData() {
+	if !<-o.conn.wait {
+		o.running = false
+		delete(o.ctx.tlsSockets, o)
+	}
+	o.tx = append(o.tx, o.conn.takeOut()...)
+}
+
+// start starts the handshake, once the TCP socket is connected or has data
+func (o *tlsSocket) start() {
+	if o.running || o.exited || o.closed {
+		return
+	}
+	if o.cfg == nil {
+		cfg, err := newTlsConfig(o.ioctl, o.server, o.host)
+		if err != nil {
+			o.ctx.tlsStats.tls_err_ioctl++
+			o.exited = true
+			o.lastErr = SeTLS_ERROR
+			o.closing = true
+			o.checkClose()
+			return
+		}
+		o.cfg = cfg
+	}
+	if o.server {
+		o.tls = tls.Server(&o.conn, o.cfg)
+	} else {
+		o.tls = tls.Client(&o.conn, o.cfg)
+	}
+	o.ctx.tlsStats.tls_handshake_start++
+	o.ctx.tlsSockets[o] = true
+	o.running = true
+	go o.run()
+	o.waitForData()
+	o.poll()
+}
+
+// input passes the data of the TCP socket to the goroutine
+func (o *tlsSocket) input(d []byte) {
+	if !o.running || o.inEof {
+		return
+	}
+	o.conn.in <- d
+	o.waitForData()
+	o.poll()
+}
+
+// inputEof passes the end of the data, the goroutine exits
+func (o *tlsSocket) inputEof() {
+	if !o.running || o.inEof {
+		return
+	}
+	o.inEof = true
+	close(o.conn.in)
+	o.waitForData()
+	o.poll()
+}
+
+// abort stops the goroutine without handling its end
+func (o *tlsSocket) abort() {
+	if o.running {
+		o.exited = true
+		if !o.inEof {
+			o.inEof = true
+			close(o.conn.in)
+		}
+		for <-o.conn.wait {
+		}
+		o.running = false
+		delete(o.ctx.tlsSockets, o)
+	}
+}
+
+// poll handles the state of the goroutine, it is blocked on Read or it exited
+func (o *tlsSocket) poll() {
+	sts := &o.ctx.tlsStats
+	if o.hsDone && !o.hsHandled {
+		o.hsHandled = true
+		if o.hsErr == nil {
+			sts.tls_handshake_ok++
+			for _, b := range o.pending {
+				o.tls.Write(b)
+			}
+			o.pending = nil
+			o.tx = append(o.tx, o.conn.takeOut()...)
+		} else {
+			sts.tls_handshake_err++
+		}
+	}
+	o.flush()
+	if o.hsHandled && o.hsErr == nil && !o.connected && !o.closed {
+		o.connected = true
+		o.cb.OnRxEvent(SocketEventConnected)
+	}
+	for len(o.plain) > 0 && !o.closed {
+		d := o.plain[0]
+		o.plain = o.plain[1:]
+		sts.tls_rxbyte += uint64(len(d))
+		o.cb.OnRxData(d)
+	}
+	if !o.running && !o.exited {
+		o.exited = true
+		o.onExit()
+	}
+	o.checkClose()
+}
+
+// onExit handles the error that ended the goroutine
+func (o *tlsSocket) onExit() {
+	sts := &o.ctx.tlsStats
+	err := o.err
+	if o.hsErr == nil && err == io.EOF {
+		if o.conn.eof {
+			// the TCP socket was closed without close_notify
+			sts.tls_no_close_notify++
+		} else {
+			sts.tls_close_notify_rx++
+		}
+		if !o.closed && !o.closing {
+			o.cb.OnRxEvent(SocketRemoteDisconnect)
+		}
+		return
+	}
+	o.fail(err)
+}
+
+// fail closes the TCP socket after the alert was sent
+func (o *tlsSocket) fail(err error) {
+	sts := &o.ctx.tlsStats
+	var oe *net.OpError
+	if errors.As(err, &oe) && oe.Op == "remote error" {
+		sts.tls_alert_rx++
+		o.alert = oe.Err.Error()
+	} else if err != io.EOF && err != io.ErrUnexpectedEOF {
+		// crypto/tls sends a fatal alert on its own errors, the alert itself is not exported
+		sts.tls_alert_tx++
+		if oe != nil {
+			err = oe.Err
+		}
+		o.alert = err.Error()
+	}
+	o.lastErr = SeTLS_ERROR
+	o.closing = true
+}
+
+// flush writes the records to the TCP socket
+func (o *tlsSocket) flush() SocketErr {
+	if o.txDrain || len(o.tx) == 0 || o.tcpClosed {
+		return SeOK
+	}
+	b := o.tx
+	o.tx = nil
+	res, queued := o.tcp.Write(b)
+	if res != SeOK {
+		return res
+	}
+	o.txDrain = !queued
+	return SeOK
+}
+
+// checkClose closes the TCP socket once the handshake is done and the records were written
+func (o *tlsSocket) checkClose() {
+	if !o.closing || o.tcpClosed || o.closed {
+		return
+	}
+	if o.running && !o.hsHandled {
+		return
+	}
+	if o.connected && !o.notifySent && (o.running || o.err == io.EOF) {
+		o.notifySent = true
+		o.tls.CloseWrite()
+		o.ctx.tlsStats.tls_close_notify_tx++
+		o.tx = append(o.tx, o.conn.takeOut()...)
+		o.flush()
+	}
+	if o.txDrain || len(o.tx) > 0 {
+		return
+	}
+	if o.running {
+		// data after the FIN resets the TCP socket, wait for the close_notify (or FIN) of the peer
+		return
+	}
+	o.tcpClosed = true
+	o.tcp.Close()
+}
+
+// OnRxEvent the events of the TCP socket
+func (o *tlsSocket) OnRxEvent(event SocketEventType) {
+	if event&SocketEventConnected > 0 {
+		o.start()
+	}
+	if event&SocketRemoteDisconnect > 0 {
+		if !o.running && !o.exited {
+			// closed before the handshake
+			o.start()
+		}
+		o.inputEof()
+	}
+	if event&SocketClosed > 0 && !o.closed {
+		o.abort()
+		o.closed = true
+		o.cb.OnRxEvent(SocketClosed)
+	}
+}
+
+// OnRxData the records of the peer
+func (o *tlsSocket) OnRxData(d []byte) {
+	b := append([]byte(nil), d...)
+	if !o.running && !o.exited {
+		o.start()
+	}
+	o.input(b)
+}
+
+// OnTxEvent the TCP socket was drained
+func (o *tlsSocket) OnTxEvent(event SocketEventType) {
+	if event&SocketTxMore > 0 && o.txDrain {
+		o.txDrain = false
+		o.flush()
+	}
+	if o.txDrain {
+		return
+	}
+	o.checkClose()
+	var ev SocketEventType
+	if o.userDrain && len(o.tx) == 0 {
+		o.userDrain = false
+		ev |= SocketTxMore
+	}
+	if event&SocketTxEmpty > 0 && len(o.tx) == 0 {
+		ev |= SocketTxEmpty
+	}
+	if ev != 0 && o.connected && !o.closed {
+		o.cb.OnTxEvent(ev)
+	}
+}
+
+func (o *tlsSocket) Write(buf []byte) (res SocketErr, queued bool) {
+	// the data can be written after close_notify of the peer
+	if o.closing || o.closed || (o.exited && o.err != io.EOF) {
+		return SeCONNECTION_IS_CLOSED, false
+	}
+	if o.userDrain {
+		return SeWRITE_WHILE_DRAIN, false
+	}
+	if len(buf) == 0 {
+		return SeOK, true
+	}
+	o.ctx.tlsStats.tls_txbyte += uint64(len(buf))
+	if !o.hsHandled {
+		o.pending = append(o.pending, append([]byte(nil), buf...))
+		return SeOK, true
+	}
+	if _, err := o.tls.Write(buf); err != nil {
+		return SeCONNECTION_IS_CLOSED, false
+	}
+	o.tx = append(o.tx, o.conn.takeOut()...)
+	if res = o.flush(); res != SeOK {
+		return res, false
+	}
+	if o.txDrain {
+		o.userDrain = true
+		return SeOK, false
+	}
+	return SeOK, true
+}
+
+func (o *tlsSocket) Close() SocketErr {
+	if o.closing || o.closed {
+		o.ctx.tcpStats.tcps_already_closed++
+		return SeCONNECTION_IS_CLOSED
+	}
+	o.closing = true
+	if !o.running && !o.exited {
+		// not connected yet
+		o.pending = nil
+		o.tcpClosed = true
+		return o.tcp.Close()
+	}
+	o.checkClose()
+	return SeOK
+}
+
+func (o *tlsSocket) Shutdown() SocketErr {
+	o.closing = true
+	o.tcpClosed = true
+	return o.tcp.Shutdown()
+}
+
+func (o *tlsSocket) LocalAddr() net.Addr {
+	return o.tcp.LocalAddr()
+}
+
+func (o *tlsSocket) RemoteAddr() net.Addr {
+	return o.tcp.RemoteAddr()
+}
+
+func (o *tlsSocket) GetCap() SocketCapType {
+	return o.tcp.GetCap()
+}
+
+func (o *tlsSocket) GetLastError() SocketErr {
+	if o.lastErr != SeOK {
+		return o.lastErr
+	}
+	return o.tcp.GetLastError()
+}
+
+// SetIoctl sets the tls keys and passes the other keys to the TCP socket
+func (o *tlsSocket) SetIoctl(m IoctlMap) error {
+	if err := o.setTlsIoctl(m); err != nil {
+		return err
+	}
+	return o.tcp.SetIoctl(m)
+}
+
+func (o *tlsSocket) GetIoctl(m IoctlMap) error {
+	if err := o.tcp.GetIoctl(m); err != nil {
+		return err
+	}
+	if o.hsHandled && o.hsErr == nil {
+		m[TLS_IOCTL_VERSION] = tlsVersionName(o.state.Version)
+		m[TLS_IOCTL_CIPHER] = tls.CipherSuiteName(o.state.CipherSuite)
+		m[TLS_IOCTL_ALPN] = o.state.NegotiatedProtocol
+		m[TLS_IOCTL_SERVER_NAME] = o.state.ServerName
+	}
+	if o.alert != "" {
+		m[TLS_IOCTL_ALERT] = o.alert
+	}
+	return nil
+}
+
+func (o *tlsSocket) GetL7MTU() uint16 {
+	return o.tcp.GetL7MTU()
+}
+
+func (o *tlsSocket) IsIPv6() bool {
+	return o.tcp.IsIPv6()
+}
+
+func (o *tlsSocket) GetSocket() interface{} {
+	return o.tcp.GetSocket()
+}
+
+// tlsServer the accept callback of Listen("tls", ..), the user gets the TLS socket
+type tlsServer struct {
+	ctx *TransportCtx
+	cb  IServerSocketCb
+}
+
+func (o *tlsServer) OnAccept(socket SocketApi) ISocketCb {
+	s := newTlsSocket(o.ctx, true, "")
+	s.tcp = socket
+	cb := o.cb.OnAccept(s)
+	if cb == nil {
+		return nil
+	}
+	s.cb = cb
+	return s
+}
+
+func (o *TransportCtx) dialTls(host string, dst net.IP, port uint16, cb ISocketCb, ioctl IoctlMap, dstMac *core.MACKey) (SocketApi, error) {
+	s := newTlsSocket(o, false, host)
+	s.cb = cb
+	if ioctl != nil {
+		if err := s.setTlsIoctl(ioctl); err != nil {
+			return nil, err
+		}
+	}
+	tcp, err := o.dialTcp(dst, port, s, ioctl, dstMac)
+	if err != nil {
+		return nil, err
+	}
+	s.tcp = tcp
+	return s, nil
+}
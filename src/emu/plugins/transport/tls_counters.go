@@ -0,0 +1,116 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License")
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package transport
+
+import "emu/core"
+
+type TlsStats struct {
+	tls_handshake_start uint64 /* handshakes started */
+	tls_handshake_ok    uint64 /* handshakes done */
+	tls_handshake_err   uint64 /* handshakes failed */
+	tls_alert_tx        uint64 /* fatal alerts sent */
+	tls_alert_rx        uint64 /* fatal alerts received */
+	tls_close_notify_tx uint64 /* close_notify sent */
+	tls_close_notify_rx uint64 /* close_notify received */
+	tls_no_close_notify uint64 /* tcp closed by the peer without close_notify */
+	tls_txbyte          uint64 /* plain bytes written by the application */
+	tls_rxbyte          uint64 /* plain bytes delivered to the application */
+	tls_err_ioctl       uint64 /* bad tls keys of the ioctl */
+}
+
+func NewTlsStatsDb(o *TlsStats) *core.CCounterDb {
+	db := core.NewCCounterDb("tls")
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.tls_handshake_start,
+		Name:     "tls_handshake_start",
+		Help:     "handshakes started",
+		Unit:     "event",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.tls_handshake_ok,
+		Name:     "tls_handshake_ok",
+		Help:     "handshakes done",
+		Unit:     "event",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.tls_handshake_err,
+		Name:     "tls_handshake_err",
+		Help:     "handshakes failed",
+		Unit:     "event",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.tls_alert_tx,
+		Name:     "tls_alert_tx",
+		Help:     "fatal alerts sent",
+		Unit:     "event",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.tls_alert_rx,
+		Name:     "tls_alert_rx",
+		Help:     "fatal alerts received",
+		Unit:     "event",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.tls_close_notify_tx,
+		Name:     "tls_close_notify_tx",
+		Help:     "close_notify sent",
+		Unit:     "event",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.tls_close_notify_rx,
+		Name:     "tls_close_notify_rx",
+		Help:     "close_notify received",
+		Unit:     "event",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.tls_no_close_notify,
+		Name:     "tls_no_close_notify",
+		Help:     "tcp closed by the peer without close_notify",
+		Unit:     "event",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.tls_txbyte,
+		Name:     "tls_txbyte",
+		Help:     "plain bytes written by the application",
+		Unit:     "bytes",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.tls_rxbyte,
+		Name:     "tls_rxbyte",
+		Help:     "plain bytes delivered to the application",
+		Unit:     "bytes",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.tls_err_ioctl,
+		Name:     "tls_err_ioctl",
+		Help:     "bad tls keys of the ioctl",
+		Unit:     "event",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	return db
+}
@@ -0,0 +1,166 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package transport
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"testing"
+	"time"
+)
+
+type TlsSimTestBase struct {
+	duration time.Duration
+	param    transportSimParam
+}
+
+// Run runs the simulation and returns the tls counters of the client and the server,
+// the records of the handshake are random so there is no compare to a golden file
+func (o *TlsSimTestBase) Run(t *testing.T) (*TlsStats, *TlsStats) {
+	rand.Seed(0x1234)
+	o.param.tls = true
+	sim := newTransportSim(&o.param)
+	defer sim.tctx.Delete()
+
+	sim.tctx.Veth.SetDebug(monitor > 0, os.Stdout, false)
+	sim.tctx.MainLoopSim(o.duration)
+	fmt.Printf("\n== Client counters === \n")
+	sim.client.ctx.cdbv.Dump()
+	fmt.Printf("\n== Server counters === \n")
+	sim.server.ctx.cdbv.Dump()
+
+	if acf := sim.client.ctx.getActiveFlows() + sim.server.ctx.getActiveFlows(); acf > 0 {
+		t.Fatalf(" active flows exists %v", acf)
+	}
+	if len(sim.client.ctx.tlsSockets)+len(sim.server.ctx.tlsSockets) > 0 {
+		t.Fatalf(" tls goroutines are still running")
+	}
+	return &sim.client.ctx.tlsStats, &sim.server.ctx.tlsStats
+}
+
+func checkTlsData(t *testing.T, c, s *TlsStats, size uint64) {
+	if c.tls_handshake_ok != 1 || s.tls_handshake_ok != 1 {
+		t.Fatalf(" handshake client %v server %v", c.tls_handshake_ok, s.tls_handshake_ok)
+	}
+	if c.tls_txbyte != size || s.tls_rxbyte != size {
+		t.Fatalf(" client tx %v server rx %v expected %v", c.tls_txbyte, s.tls_rxbyte, size)
+	}
+	if c.tls_close_notify_tx != 1 || s.tls_close_notify_rx != 1 || s.tls_close_notify_tx != 1 {
+		t.Fatalf(" close_notify client tx %v server rx %v server tx %v",
+			c.tls_close_notify_tx, s.tls_close_notify_rx, s.tls_close_notify_tx)
+	}
+}
+
+func TestPluginTls1(t *testing.T) {
+	a := &TlsSimTestBase{
+		duration: 20 * time.Second,
+		param: transportSimParam{
+			name:                    "a",
+			totalClientToServerSize: 4000,
+			chunkSize:               1000,
+			closeByClient:           true,
+		},
+	}
+	c, s := a.Run(t)
+	checkTlsData(t, c, s, 4000)
+}
+
+func TestPluginTlsV6(t *testing.T) {
+	a := &TlsSimTestBase{
+		duration: 20 * time.Second,
+		param: transportSimParam{
+			name:                    "a",
+			totalClientToServerSize: 4000,
+			chunkSize:               1000,
+			closeByClient:           false,
+			ipv6:                    true,
+		},
+	}
+	c, s := a.Run(t)
+	if c.tls_handshake_ok != 1 || s.tls_handshake_ok != 1 || s.tls_rxbyte != 4000 {
+		t.Fatalf(" handshake client %v server %v rx %v", c.tls_handshake_ok, s.tls_handshake_ok, s.tls_rxbyte)
+	}
+	if s.tls_close_notify_tx != 1 || c.tls_close_notify_rx != 1 {
+		t.Fatalf(" close_notify server tx %v client rx %v", s.tls_close_notify_tx, c.tls_close_notify_rx)
+	}
+}
+
+func TestPluginTlsInfo(t *testing.T) {
+	info := IoctlMap{TLS_IOCTL_VERSION: nil, TLS_IOCTL_CIPHER: nil, TLS_IOCTL_ALPN: nil}
+	a := &TlsSimTestBase{
+		duration: 20 * time.Second,
+		param: transportSimParam{
+			name:                    "a",
+			totalClientToServerSize: 1000,
+			chunkSize:               1000,
+			closeByClient:           true,
+			ioctlc: &map[string]interface{}{
+				TLS_IOCTL_ALPN:        "h2,http/1.1",
+				TLS_IOCTL_MAX_VERSION: "1.2",
+				TLS_IOCTL_CIPHERS:     []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"}},
+			ioctls:  &map[string]interface{}{TLS_IOCTL_ALPN: []interface{}{"http/1.1"}},
+			tlsInfo: info,
+		},
+	}
+	c, s := a.Run(t)
+	checkTlsData(t, c, s, 1000)
+	if info[TLS_IOCTL_VERSION] != "1.2" ||
+		info[TLS_IOCTL_CIPHER] != "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256" ||
+		info[TLS_IOCTL_ALPN] != "http/1.1" {
+		t.Fatalf(" unexpected info %v", info)
+	}
+}
+
+func TestPluginTlsAlpnMismatch(t *testing.T) {
+	a := &TlsSimTestBase{
+		duration: 20 * time.Second,
+		param: transportSimParam{
+			name:                    "a",
+			totalClientToServerSize: 1000,
+			chunkSize:               1000,
+			closeByClient:           true,
+			ioctlc:                  &map[string]interface{}{TLS_IOCTL_ALPN: []string{"h2"}},
+			ioctls:                  &map[string]interface{}{TLS_IOCTL_ALPN: "http/1.1"},
+		},
+	}
+	c, s := a.Run(t)
+	if c.tls_handshake_err != 1 || s.tls_handshake_err != 1 {
+		t.Fatalf(" handshake err client %v server %v", c.tls_handshake_err, s.tls_handshake_err)
+	}
+	if s.tls_alert_tx != 1 || c.tls_alert_rx != 1 {
+		t.Fatalf(" alert server tx %v client rx %v", s.tls_alert_tx, c.tls_alert_rx)
+	}
+	if c.tls_txbyte != 0 || s.tls_rxbyte != 0 {
+		t.Fatalf(" data client tx %v server rx %v", c.tls_txbyte, s.tls_rxbyte)
+	}
+}
+
+func TestPluginTlsConfig(t *testing.T) {
+	bad := []IoctlMap{
+		{TLS_IOCTL_CIPHERS: "TLS_NO_SUCH_CIPHER"},
+		{TLS_IOCTL_CERT: "cert"},
+		{TLS_IOCTL_MIN_VERSION: "2.0"},
+		{TLS_IOCTL_MIN_VERSION: "1.3", TLS_IOCTL_MAX_VERSION: "1.2"},
+		{TLS_IOCTL_ALPN: 1},
+		{TLS_IOCTL_CA: "not a pem"},
+		{TLS_IOCTL_CONFIG: "config"},
+	}
+	for _, m := range bad {
+		if _, err := newTlsConfig(m, false, "48.0.0.1"); err == nil {
+			t.Fatalf(" %v should fail", m)
+		}
+	}
+
+	cfg, err := newTlsConfig(IoctlMap{}, true, "")
+	if err != nil || len(cfg.Certificates) != 1 {
+		t.Fatalf(" server without a certificate %v", err)
+	}
+	cfg, err = newTlsConfig(IoctlMap{}, false, "48.0.0.1")
+	if err != nil || !cfg.InsecureSkipVerify || cfg.ServerName != "48.0.0.1" {
+		t.Fatalf(" client default config %v", err)
+	}
+}
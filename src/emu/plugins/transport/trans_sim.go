@@ -36,6 +36,9 @@ func newSimCtx(app iSockeApp, c *core.CClient, server bool, ioctl *map[string]in
 	if params.udp {
 		net = "udp"
 	}
+	if params.tls {
+		net = "tls"
+	}
 
 	if server {
 		o.ctx.Listen(net, ":80", app.getServerAcceptCb())
@@ -207,6 +210,9 @@ func (o *SocketAppTx1) OnRxEvent(event SocketEventType) {
 		fmt.Printf(" clientRx %p %x  \n", o, event)
 	}
 	if (event & SocketEventConnected) > 0 {
+		if o.params.tlsInfo != nil {
+			o.socket.GetIoctl(o.params.tlsInfo)
+		}
 		o.sendChunk() // start
 	}
 
@@ -615,6 +621,8 @@ type transportSimParam struct {
 	ioctls                  *map[string]interface{}
 	ipv6                    bool
 	udp                     bool
+	tls                     bool
+	tlsInfo                 IoctlMap // the client gets the tls keys after the handshake
 }
 
 type transportSim struct {
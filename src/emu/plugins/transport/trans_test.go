@@ -7,6 +7,8 @@ package transport
 
 import (
 	"emu/core"
+	"encoding/binary"
+	"external/google/gopacket/layers"
 	"flag"
 	"fmt"
 	"math/rand"
@@ -790,6 +792,75 @@ func TestPluginUdp2(t *testing.T) {
 	a.Run(t, false)
 }
 
+// VethFragSim keeps the tx packets
+type VethFragSim struct {
+	tx [][]byte
+}
+
+func (o *VethFragSim) ProcessTxToRx(m *core.Mbuf) *core.Mbuf {
+	o.tx = append(o.tx, append([]byte(nil), m.GetData()...))
+	m.FreeMbuf()
+	return nil
+}
+
+type udpFragCb struct{}
+
+func (o *udpFragCb) OnRxEvent(event SocketEventType) {}
+func (o *udpFragCb) OnRxData(d []byte)               {}
+func (o *udpFragCb) OnTxEvent(event SocketEventType) {}
+
+// a message bigger than the mtu of the client is fragmented
+func TestPluginUdpFrag(t *testing.T) {
+	var simVeth VethFragSim
+	var simrx core.VethIFSim = &simVeth
+	tctx := core.NewThreadCtx(0, 4510, true, &simrx)
+	defer tctx.Delete()
+	var key core.CTunnelKey
+	key.Set(&core.CTunnelData{Vport: 1, Vlans: [2]uint32{0x81000001, 0x81000002}})
+	ns := core.NewNSCtx(tctx, &key)
+	tctx.AddNs(&key, ns)
+	client := core.NewClient(ns, core.MACKey{0, 0, 1, 0, 0, 1}, core.Ipv4Key{16, 0, 0, 1}, core.Ipv6Key{}, core.Ipv4Key{16, 0, 0, 2})
+	client.ForceDGW = true
+	client.Ipv4ForcedgMac = core.MACKey{0, 0, 1, 0, 0, 2}
+	client.MTU = 1000
+	ns.AddClient(client)
+
+	ctx := newCtx(client)
+	s, err := ctx.Dial("udp", "48.0.0.1:80", &udpFragCb{}, nil, nil)
+	if err != nil {
+		t.Fatalf(" dial failed %v", err)
+	}
+	if r, _ := s.Write(make([]byte, 3000)); r != SeOK {
+		t.Fatalf(" write failed %v", r)
+	}
+	if r, _ := s.Write(make([]byte, core.MAX_PACKET_SIZE)); r != SeENOBUFS || ctx.udpStats.udp_drop_msg_bigger_max != 1 {
+		t.Fatalf(" a msg bigger than the max packet size should be dropped %v", r)
+	}
+	tctx.Veth.SimulatorCheckRxQueue()
+	s.Close()
+
+	// 3000 bytes of data and 8 bytes of udp header, 976 bytes in each fragment
+	if len(simVeth.tx) != 4 {
+		t.Fatalf(" expected 4 fragments, got %d", len(simVeth.tx))
+	}
+	l3 := 14 + 8
+	off := 0
+	for i, p := range simVeth.tx {
+		ipv4 := layers.IPv4Header(p[l3 : l3+20])
+		if int(ipv4.GetLength()) > int(client.MTU) || ipv4.GetHeaderLen() != 20 || !ipv4.IsValidHeaderChecksum() {
+			t.Fatalf(" fragment %d is not valid", i)
+		}
+		frag := binary.BigEndian.Uint16(p[l3+6 : l3+8])
+		if int(frag&0x1fff)<<3 != off || ((frag&0x2000) != 0) != (i < 3) {
+			t.Fatalf(" fragment %d has a wrong offset 0x%x", i, frag)
+		}
+		off += int(ipv4.GetLength()) - 20
+	}
+	if off != 3008 {
+		t.Fatalf(" total fragmented payload %d", off)
+	}
+}
+
 func init() {
 	flag.IntVar(&monitor, "monitor", 0, "monitor")
 }
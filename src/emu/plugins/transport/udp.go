@@ -96,8 +96,9 @@ func (o *UdpSocket) Write(buf []byte) (res SocketErr, queued bool) {
 	}
 	var pkt udpPkt
 
-	if uint16(len(buf)) > o.GetL7MTU() {
-		o.ctx.udpStats.udp_drop_msg_bigger_mtu++
+	// bigger than the MTU is fragmented, limited by the max packet size
+	if len(buf) > int(core.MAX_PACKET_SIZE)-len(o.pktTemplate) {
+		o.ctx.udpStats.udp_drop_msg_bigger_max++
 		return SeENOBUFS, false
 	}
 
@@ -155,7 +156,7 @@ func (o *UdpSocket) send(pkt *udpPkt) int {
 		ipv6.FixUdpL4Checksum(p[l4:], 0)
 	}
 
-	o.tctx.Veth.Send(m)
+	o.client.SendFragmented(m, o.l3Offset)
 	return 0
 }
 
@@ -15,7 +15,7 @@ type UdpStats struct {
 	udp_rcvpkt  uint64 /* bytes received in sequence */
 
 	udp_drop_unresolved     uint64 /* not resolved  */
-	udp_drop_msg_bigger_mtu uint64 /* msg is bigger than mtu */
+	udp_drop_msg_bigger_max uint64 /* msg is bigger than the max packet size, a msg bigger than the mtu is fragmented */
 
 }
 
@@ -56,9 +56,9 @@ func NewUdpStatsDb(o *UdpStats) *core.CCounterDb {
 		Info: core.ScERROR})
 
 	db.Add(&core.CCounterRec{
-		Counter:  &o.udp_drop_msg_bigger_mtu,
-		Name:     "udp_drop_msg_bigger_mtu",
-		Help:     "udp_drop_msg_bigger_mtu",
+		Counter:  &o.udp_drop_msg_bigger_max,
+		Name:     "udp_drop_msg_bigger_max",
+		Help:     "udp_drop_msg_bigger_max",
 		Unit:     "event",
 		DumpZero: false,
 		Info:     core.ScERROR})
@@ -14,6 +14,7 @@ example of transport
 import (
 	"bytes"
 	"emu/core"
+	"emu/plugins/dns"
 	"emu/plugins/transport"
 	"external/osamingo/jsonrpc"
 
@@ -25,7 +26,7 @@ const (
 )
 
 type TransEInit struct {
-	Addr     string `json:"addr"`
+	Addr     string `json:"addr"` // host:port, the host could be a name
 	DataSize uint32 `json:"size"`
 	Loops    uint32 `json:"loops"`
 }
@@ -121,6 +122,7 @@ type PluginTransportEClient struct {
 	b          []byte
 	loops      uint32
 	rxcnt      uint32
+	removed    bool
 }
 
 var events = []string{core.MSG_DG_MAC_RESOLVED}
@@ -220,19 +222,24 @@ func (o *PluginTransportEClient) OnEvent(msg string, a, b interface{}) {
 		}
 		resolvedIPv4 := (bitMask & core.RESOLVED_IPV4_DG_MAC) == core.RESOLVED_IPV4_DG_MAC
 		if resolvedIPv4 {
-			// now we can dial
-			o.ctx = transport.GetTransportCtx(o.Client)
-			s, err := o.ctx.Dial("tcp", o.cfg.Addr, o, nil, nil)
-			if err != nil {
-				return
-			}
-			o.s = s
+			// now we can dial, a host name is resolved by the dns plugin of the client
+			dns.ResolveAddr(o.Client, o.cfg.Addr, false, func(addr string, err error) {
+				if err != nil || o.removed {
+					return
+				}
+				o.ctx = transport.GetTransportCtx(o.Client)
+				s, err := o.ctx.Dial("tcp", addr, o, nil, nil)
+				if err != nil {
+					return
+				}
+				o.s = s
+			})
 		}
 	}
 }
 
 func (o *PluginTransportEClient) OnRemove(ctx *core.PluginCtx) {
-
+	o.removed = true
 }
 
 // PluginTransportENs icmp information per namespace
@@ -254,6 +261,10 @@ func NewTransportENs(ctx *core.PluginCtx, initJson []byte) *core.PluginBase {
 	return &o.PluginBase
 }
 
+func (o *PluginTransportENs) GetCounterDbVec() *core.CCounterDbVec {
+	return o.cdbv
+}
+
 func (o *PluginTransportENs) OnRemove(ctx *core.PluginCtx) {
 }
 
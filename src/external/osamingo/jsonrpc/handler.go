@@ -50,7 +50,11 @@ func (mr *MethodRepository) ServeBytes(req []byte) []byte {
 
 	resp := make([]*Response, len(rs))
 	for i := range rs {
-		resp[i] = mr.InvokeMethod(nil, rs[i])
+		if mr.Router != nil {
+			resp[i] = mr.Router(rs[i])
+		} else {
+			resp[i] = mr.InvokeMethod(nil, rs[i])
+		}
 	}
 
 	b, _ := GetResponseBytes(resp, batch)
@@ -16,6 +16,9 @@ type (
 		rpcRec *[]interface{}
 		api    string
 		ctx    interface{}
+		// Router in case it is set, it is called instead of InvokeMethod.
+		// Used to dispatch the request to another repository (e.g. another thread)
+		Router func(r *Request) *Response
 	}
 	// Metadata has method meta data.
 	Metadata struct {